@@ -0,0 +1,68 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"zultys-smpp-mm4/smpp/pdu"
+)
+
+// SMPP address types for an alphanumeric source address.
+const (
+	smppTONAlphanumeric = 0x05
+	smppNPIUnknown      = 0x00
+)
+
+var (
+	alphaSenderRegex = regexp.MustCompile(`^[A-Za-z0-9 ]{1,11}$`)
+	e164Regex        = regexp.MustCompile(`^\+[1-9]\d{1,14}$`)
+)
+
+// isAlphanumericSender reports whether addr is an alphanumeric sender ID
+// such as "MYBRAND" rather than a phone number. All-digit strings are
+// treated as numbers.
+func isAlphanumericSender(addr string) bool {
+	if !alphaSenderRegex.MatchString(addr) {
+		return false
+	}
+	return strings.IndexFunc(addr, func(r rune) bool {
+		return (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z')
+	}) >= 0
+}
+
+// formatSender normalises a source address: alphanumeric sender IDs are
+// kept as-is, anything else goes through FormatToE164.
+func formatSender(from string) string {
+	if isAlphanumericSender(from) {
+		return from
+	}
+	formatted, _ := FormatToE164(from)
+	return formatted
+}
+
+// isE164 reports whether number is already in +E.164 form.
+func isE164(number string) bool {
+	return e164Regex.MatchString(number)
+}
+
+// smppAddress builds a PDU address for addr, marking alphanumeric sender IDs
+// with TON 0x05/NPI 0x00 and everything else as an international number.
+func smppAddress(addr string) pdu.Address {
+	if isAlphanumericSender(addr) {
+		return pdu.Address{TON: smppTONAlphanumeric, NPI: smppNPIUnknown, No: addr}
+	}
+	return pdu.Address{TON: 0x01, NPI: 0x01, No: addr}
+}
+
+// alphaSenderCarrier is implemented by carrier handlers whose API accepts
+// an alphanumeric sender ID in place of a number.
+type alphaSenderCarrier interface {
+	SupportsAlphaSender() bool
+}
+
+// carrierSupportsAlphaSender reports whether handler can send from an
+// alphanumeric sender ID.
+func carrierSupportsAlphaSender(handler CarrierHandler) bool {
+	c, ok := handler.(alphaSenderCarrier)
+	return ok && c.SupportsAlphaSender()
+}
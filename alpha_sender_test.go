@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsAlphanumericSender(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"MYBRAND":      true,
+		"My Brand":     true,
+		"Shop24":       true,
+		"ABCDEFGHIJK":  true,
+		"ABCDEFGHIJKL": false, // over 11 characters
+		"15551234567":  false, // digits only is a number
+		"+15551234567": false,
+		"MY-BRAND":     false,
+		"":             false,
+	} {
+		assert.Equal(t, want, isAlphanumericSender(addr), addr)
+	}
+}
+
+func TestFormatSender(t *testing.T) {
+	assert.Equal(t, "MYBRAND", formatSender("MYBRAND"))
+	assert.Equal(t, "+12505551234", formatSender("12505551234"))
+	assert.Equal(t, "+12505551234", formatSender("+1 (250) 555-1234"))
+}
+
+func TestSMPPAddress(t *testing.T) {
+	a := smppAddress("MYBRAND")
+	assert.Equal(t, byte(0x05), a.TON)
+	assert.Equal(t, byte(0x00), a.NPI)
+	assert.Equal(t, "MYBRAND", a.No)
+
+	a = smppAddress("+12505551234")
+	assert.Equal(t, byte(0x01), a.TON)
+	assert.Equal(t, byte(0x01), a.NPI)
+}
+
+func TestRouter_DecideRouteAlphaSender(t *testing.T) {
+	r := newDecisionRouter(t)
+	r.gateway.Clients["brand"] = &Client{Username: "brand", Type: "web", Numbers: []ClientNumber{{Number: "MYBRAND", Carrier: "twilio"}}}
+	r.AddRoute("carrier", "twilio", &TwilioHandler{})
+
+	d := r.decideRoute("MYBRAND", "+14155559876", MsgQueueItemType.SMS, "client")
+	assert.Equal(t, RoutePathCarrier, d.Path)
+	assert.Equal(t, "twilio", d.Carrier)
+	assert.Empty(t, d.Error)
+
+	d = r.decideRoute("MYBRAND", "+14155559876", MsgQueueItemType.MMS, "client")
+	assert.Equal(t, "Alphanumeric sender IDs are SMS only", d.Error)
+
+	d = r.decideRoute("MYBRAND", "4155559876x", MsgQueueItemType.SMS, "client")
+	assert.Equal(t, RoutePathRejected, d.Path)
+	assert.Equal(t, "Invalid destination number", d.Error)
+
+	// vonage is registered without a handler, so it cannot take alpha senders
+	d = r.decideRoute("MYBRAND", "+447700900123", MsgQueueItemType.SMS, "client")
+	assert.Equal(t, "vonage", d.Carrier)
+	assert.Equal(t, "Carrier vonage does not support alphanumeric sender IDs", d.Error)
+}
+
+func TestComposeDeliverSMs_AlphaSender(t *testing.T) {
+	parts, _, err := composeDeliverSMs("MYBRAND", "+12505551234", "hello", func() uint16 { return 1 })
+	if assert.NoError(t, err) && assert.Len(t, parts, 1) {
+		assert.Equal(t, byte(0x05), parts[0].pdu.SourceAddr.TON)
+		assert.Equal(t, byte(0x01), parts[0].pdu.DestAddr.TON)
+	}
+}
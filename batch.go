@@ -38,9 +38,11 @@ type BatchJob struct {
 type BatchMessageItem struct {
 	ID         string     `gorm:"primaryKey" json:"id"` // UUID — the cancellable message ID
 	BatchJobID string     `gorm:"index;not null" json:"batch_job_id"`
-	Index      int        `json:"index"` // Position in original batch
+	Index      int        `json:"index"`          // Position in original batch
+	From       string     `json:"from,omitempty"` // Sender of this message; empty uses the job's FromNumber
 	To         string     `json:"to"`
 	Text       string     `json:"text"`
+	Type       string     `json:"type,omitempty"`                  // "sms" or "mms"; empty is sms
 	Status     string     `gorm:"default:'pending'" json:"status"` // pending, sent, queued, failed, cancelled
 	Error      string     `json:"error,omitempty"`
 	ErrorCode  int        `json:"error_code,omitempty"`
@@ -194,31 +196,9 @@ func ParseCSVBatch(reader io.Reader) ([]BatchMessage, error) {
 	return messages, nil
 }
 
-// processBatchJob runs in a background goroutine to send all messages in a batch.
-// Rate-limited messages are queued for retry instead of permanently failed.
-func (gateway *Gateway) processBatchJob(job *BatchJob, messages []BatchMessage, textTemplate string, client *Client) {
-	lm := gateway.LogManager
-
-	lm.SendLog(lm.BuildLog(
-		"Batch.Process",
-		"Starting batch job",
-		logrus.InfoLevel,
-		map[string]interface{}{
-			"jobID":      job.ID,
-			"clientID":   job.ClientID,
-			"totalCount": job.TotalCount,
-			"fromNumber": job.FromNumber,
-			"throttle":   job.ThrottleRPS,
-		},
-	))
-
-	// Update status to processing
-	job.Status = "processing"
-	gateway.DB.Model(job).Update("status", "processing")
-
-	var mu sync.Mutex
-
-	// Create BatchMessageItems for all messages
+// newBatchItems builds the BatchMessageItems for a batch request, applying
+// textTemplate to messages without their own text.
+func newBatchItems(job *BatchJob, messages []BatchMessage, textTemplate string) []BatchMessageItem {
 	var items []BatchMessageItem
 	for i, msg := range messages {
 		// Determine message text
@@ -243,6 +223,33 @@ func (gateway *Gateway) processBatchJob(job *BatchJob, messages []BatchMessage,
 			CreatedAt:  time.Now(),
 		})
 	}
+	return items
+}
+
+// processBatchJob runs in a background goroutine to send all messages in a batch.
+// Rate-limited messages are queued for retry instead of permanently failed.
+// Items that are already failed when it starts are stored and counted but not sent.
+func (gateway *Gateway) processBatchJob(job *BatchJob, items []BatchMessageItem, client *Client) {
+	lm := gateway.LogManager
+
+	lm.SendLog(lm.BuildLog(
+		"Batch.Process",
+		"Starting batch job",
+		logrus.InfoLevel,
+		map[string]interface{}{
+			"jobID":      job.ID,
+			"clientID":   job.ClientID,
+			"totalCount": job.TotalCount,
+			"fromNumber": job.FromNumber,
+			"throttle":   job.ThrottleRPS,
+		},
+	))
+
+	// Update status to processing
+	job.Status = "processing"
+	gateway.DB.Model(job).Update("status", "processing")
+
+	var mu sync.Mutex
 
 	// Bulk insert message items
 	gateway.DB.CreateInBatches(&items, 100)
@@ -259,6 +266,14 @@ func (gateway *Gateway) processBatchJob(job *BatchJob, messages []BatchMessage,
 	for idx := range items {
 		item := &items[idx]
 
+		// Rejected before the job started
+		if item.Status == "failed" {
+			mu.Lock()
+			job.FailedCount++
+			mu.Unlock()
+			continue
+		}
+
 		// Throttle
 		if ticker != nil {
 			<-ticker.C
@@ -300,7 +315,7 @@ func (gateway *Gateway) processBatchJob(job *BatchJob, messages []BatchMessage,
 		}
 
 		// Check limits
-		limitResult := gateway.CheckMessageLimits(client, job.FromNumber, "sms", "outbound")
+		limitResult := gateway.CheckMessageLimits(client, item.from(job), item.msgType(), "outbound")
 		if limitResult != nil && !limitResult.Allowed {
 			// Queue for retry instead of failing
 			now := time.Now()
@@ -392,7 +407,7 @@ func (gateway *Gateway) processBatchJob(job *BatchJob, messages []BatchMessage,
 				}
 
 				// Re-check limits
-				limitResult := gateway.CheckMessageLimits(client, job.FromNumber, "sms", "outbound")
+				limitResult := gateway.CheckMessageLimits(client, qi.from(job), qi.msgType(), "outbound")
 				if limitResult != nil && !limitResult.Allowed {
 					continue // Still rate limited, keep queued
 				}
@@ -499,16 +514,34 @@ func (gateway *Gateway) processBatchJob(job *BatchJob, messages []BatchMessage,
 	}
 }
 
+// from returns the item's sender, which defaults to the job's.
+func (item *BatchMessageItem) from(job *BatchJob) string {
+	if item.From != "" {
+		return item.From
+	}
+	return job.FromNumber
+}
+
+// msgType returns the item's message type, which defaults to sms.
+func (item *BatchMessageItem) msgType() string {
+	if item.Type != "" {
+		return item.Type
+	}
+	return string(MsgQueueItemType.SMS)
+}
+
 // sendBatchMessage sends a single batch message item to the router.
 func (gateway *Gateway) sendBatchMessage(item *BatchMessageItem, job *BatchJob) {
 	now := time.Now()
 	queueItem := MsgQueueItem{
-		From:              job.FromNumber,
+		From:              item.from(job),
 		To:                item.To,
 		message:           item.Text,
-		Type:              MsgQueueItemType.SMS,
+		Type:              MsgQueueType(item.msgType()),
 		ReceivedTimestamp: now,
+		QueuedTimestamp:   now,
 		LogID:             item.ID, // Use the message item ID as the log ID
+		TraceID:           newTraceID(),
 	}
 
 	gateway.Router.ClientMsgChan <- queueItem
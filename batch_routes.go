@@ -18,8 +18,7 @@ func requireBatchScope(ctx iris.Context) bool {
 	if apiKeyVal != nil {
 		apiKey := apiKeyVal.(*TenantAPIKey)
 		if !apiKey.HasScope("batch") {
-			ctx.StatusCode(iris.StatusForbidden)
-			ctx.JSON(iris.Map{"error": "API key does not have 'batch' scope"})
+			apiError(ctx, iris.StatusForbidden, errCodeForbidden, "API key does not have 'batch' scope")
 			return true
 		}
 	}
@@ -36,8 +35,7 @@ func SetupAPIKeyRoutes(app *iris.Application, gateway *Gateway) {
 			clientIDStr := ctx.Params().Get("client_id")
 			clientID, err := strconv.ParseUint(clientIDStr, 10, 32)
 			if err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid client ID"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid client ID")
 				return
 			}
 
@@ -49,8 +47,7 @@ func SetupAPIKeyRoutes(app *iris.Application, gateway *Gateway) {
 				AllowedNumberIDs []uint `json:"allowed_number_ids"` // Empty = all numbers
 			}
 			if err := ctx.ReadJSON(&req); err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid request body"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Invalid request body")
 				return
 			}
 
@@ -69,8 +66,7 @@ func SetupAPIKeyRoutes(app *iris.Application, gateway *Gateway) {
 
 			rawKey, apiKey, err := gateway.createAPIKey(uint(clientID), req.Name, req.Scopes, req.RateLimit, expiresAt, req.AllowedNumberIDs)
 			if err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": err.Error()})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, err.Error())
 				return
 			}
 
@@ -106,15 +102,13 @@ func SetupAPIKeyRoutes(app *iris.Application, gateway *Gateway) {
 			clientIDStr := ctx.Params().Get("client_id")
 			clientID, err := strconv.ParseUint(clientIDStr, 10, 32)
 			if err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid client ID"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid client ID")
 				return
 			}
 
 			keys, err := gateway.listAPIKeys(uint(clientID))
 			if err != nil {
-				ctx.StatusCode(iris.StatusInternalServerError)
-				ctx.JSON(iris.Map{"error": "Failed to list API keys"})
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, "Failed to list API keys")
 				return
 			}
 
@@ -126,22 +120,19 @@ func SetupAPIKeyRoutes(app *iris.Application, gateway *Gateway) {
 			clientIDStr := ctx.Params().Get("client_id")
 			clientID, err := strconv.ParseUint(clientIDStr, 10, 32)
 			if err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid client ID"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid client ID")
 				return
 			}
 
 			keyIDStr := ctx.Params().Get("key_id")
 			keyID, err := strconv.ParseUint(keyIDStr, 10, 32)
 			if err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid key ID"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid key ID")
 				return
 			}
 
 			if err := gateway.revokeAPIKey(uint(keyID), uint(clientID)); err != nil {
-				ctx.StatusCode(iris.StatusNotFound)
-				ctx.JSON(iris.Map{"error": err.Error()})
+				apiError(ctx, iris.StatusNotFound, errCodeAPIKeyNotFound, err.Error())
 				return
 			}
 
@@ -194,16 +185,14 @@ func SetupBatchRoutes(app *iris.Application, gateway *Gateway) {
 
 				file, _, err := ctx.FormFile("csv")
 				if err != nil {
-					ctx.StatusCode(iris.StatusBadRequest)
-					ctx.JSON(iris.Map{"error": "CSV file is required for multipart upload"})
+					apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "CSV file is required for multipart upload")
 					return
 				}
 				defer file.Close()
 
 				messages, err := ParseCSVBatch(file)
 				if err != nil {
-					ctx.StatusCode(iris.StatusBadRequest)
-					ctx.JSON(iris.Map{"error": err.Error()})
+					apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, err.Error())
 					return
 				}
 
@@ -218,22 +207,19 @@ func SetupBatchRoutes(app *iris.Application, gateway *Gateway) {
 			} else {
 				// JSON request
 				if err := ctx.ReadJSON(&req); err != nil {
-					ctx.StatusCode(iris.StatusBadRequest)
-					ctx.JSON(iris.Map{"error": "Invalid request body"})
+					apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Invalid request body")
 					return
 				}
 			}
 
 			// Validate
 			if len(req.Messages) == 0 {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "No messages provided"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "No messages provided")
 				return
 			}
 
 			if len(req.Messages) > 10000 {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Batch size exceeds maximum of 10,000 messages"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Batch size exceeds maximum of 10,000 messages")
 				return
 			}
 
@@ -243,8 +229,7 @@ func SetupBatchRoutes(app *iris.Application, gateway *Gateway) {
 				if len(client.Numbers) == 1 {
 					fromNumber = client.Numbers[0].Number
 				} else {
-					ctx.StatusCode(iris.StatusBadRequest)
-					ctx.JSON(iris.Map{"error": "'from' field is required when client has multiple numbers"})
+					apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "'from' field is required when client has multiple numbers")
 					return
 				}
 			}
@@ -258,15 +243,13 @@ func SetupBatchRoutes(app *iris.Application, gateway *Gateway) {
 				}
 			}
 			if !owned {
-				ctx.StatusCode(iris.StatusForbidden)
-				ctx.JSON(iris.Map{"error": "Client does not own the specified 'from' number"})
+				apiError(ctx, iris.StatusForbidden, errCodeForbidden, "Client does not own the specified 'from' number")
 				return
 			}
 
 			// API key number scoping check
 			if apiKey != nil && !apiKey.IsNumberAllowed(fromNumber) {
-				ctx.StatusCode(iris.StatusForbidden)
-				ctx.JSON(iris.Map{"error": "API key is not authorized to send from this number"})
+				apiError(ctx, iris.StatusForbidden, errCodeForbidden, "API key is not authorized to send from this number")
 				return
 			}
 
@@ -297,8 +280,7 @@ func SetupBatchRoutes(app *iris.Application, gateway *Gateway) {
 			}
 
 			if err := gateway.DB.Create(job).Error; err != nil {
-				ctx.StatusCode(iris.StatusInternalServerError)
-				ctx.JSON(iris.Map{"error": "Failed to create batch job"})
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, "Failed to create batch job")
 				return
 			}
 
@@ -316,7 +298,7 @@ func SetupBatchRoutes(app *iris.Application, gateway *Gateway) {
 			))
 
 			// Start processing in background
-			go gateway.processBatchJob(job, req.Messages, req.TextTemplate, client)
+			go gateway.processBatchJob(job, newBatchItems(job, req.Messages, req.TextTemplate), client)
 
 			ctx.StatusCode(iris.StatusAccepted)
 			ctx.JSON(BatchResponse{
@@ -340,14 +322,12 @@ func SetupBatchRoutes(app *iris.Application, gateway *Gateway) {
 				MsgType      string `json:"msg_type"` // "sms" or "mms", default "sms"
 			}
 			if err := ctx.ReadJSON(&req); err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid request body"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Invalid request body")
 				return
 			}
 
 			if req.MessageCount <= 0 {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "'message_count' must be greater than 0"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "'message_count' must be greater than 0")
 				return
 			}
 
@@ -361,8 +341,7 @@ func SetupBatchRoutes(app *iris.Application, gateway *Gateway) {
 				if len(client.Numbers) == 1 {
 					fromNumber = client.Numbers[0].Number
 				} else {
-					ctx.StatusCode(iris.StatusBadRequest)
-					ctx.JSON(iris.Map{"error": "'from' field is required when client has multiple numbers"})
+					apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "'from' field is required when client has multiple numbers")
 					return
 				}
 			}
@@ -376,8 +355,7 @@ func SetupBatchRoutes(app *iris.Application, gateway *Gateway) {
 				}
 			}
 			if !owned {
-				ctx.StatusCode(iris.StatusForbidden)
-				ctx.JSON(iris.Map{"error": "Client does not own the specified 'from' number"})
+				apiError(ctx, iris.StatusForbidden, errCodeForbidden, "Client does not own the specified 'from' number")
 				return
 			}
 
@@ -386,8 +364,7 @@ func SetupBatchRoutes(app *iris.Application, gateway *Gateway) {
 			if apiKeyVal != nil {
 				apiKey := apiKeyVal.(*TenantAPIKey)
 				if !apiKey.IsNumberAllowed(fromNumber) {
-					ctx.StatusCode(iris.StatusForbidden)
-					ctx.JSON(iris.Map{"error": "API key is not authorized to send from this number"})
+					apiError(ctx, iris.StatusForbidden, errCodeForbidden, "API key is not authorized to send from this number")
 					return
 				}
 			}
@@ -510,8 +487,7 @@ func SetupBatchRoutes(app *iris.Application, gateway *Gateway) {
 
 			var job BatchJob
 			if err := gateway.DB.Where("id = ? AND client_id = ?", jobID, client.ID).First(&job).Error; err != nil {
-				ctx.StatusCode(iris.StatusNotFound)
-				ctx.JSON(iris.Map{"error": "Batch job not found"})
+				apiError(ctx, iris.StatusNotFound, errCodeBatchNotFound, "Batch job not found")
 				return
 			}
 
@@ -603,8 +579,7 @@ func SetupBatchRoutes(app *iris.Application, gateway *Gateway) {
 			// Verify job belongs to client
 			var job BatchJob
 			if err := gateway.DB.Where("id = ? AND client_id = ?", jobID, client.ID).First(&job).Error; err != nil {
-				ctx.StatusCode(iris.StatusNotFound)
-				ctx.JSON(iris.Map{"error": "Batch job not found"})
+				apiError(ctx, iris.StatusNotFound, errCodeBatchNotFound, "Batch job not found")
 				return
 			}
 
@@ -660,19 +635,13 @@ func SetupBatchRoutes(app *iris.Application, gateway *Gateway) {
 			// Verify job belongs to client
 			var job BatchJob
 			if err := gateway.DB.Where("id = ? AND client_id = ?", jobID, client.ID).First(&job).Error; err != nil {
-				ctx.StatusCode(iris.StatusNotFound)
-				ctx.JSON(iris.Map{"error": "Batch job not found"})
+				apiError(ctx, iris.StatusNotFound, errCodeBatchNotFound, "Batch job not found")
 				return
 			}
 
 			// Only active jobs can be cancelled
 			if job.Status == "completed" || job.Status == "failed" || job.Status == "cancelled" {
-				ctx.StatusCode(iris.StatusConflict)
-				ctx.JSON(iris.Map{
-					"error":  "Batch job cannot be cancelled",
-					"status": job.Status,
-					"detail": fmt.Sprintf("Job is already '%s'", job.Status),
-				})
+				apiError(ctx, iris.StatusConflict, errCodeCancelRefused, fmt.Sprintf("Batch job cannot be cancelled: it is already '%s'", job.Status))
 				return
 			}
 
@@ -734,27 +703,20 @@ func SetupBatchRoutes(app *iris.Application, gateway *Gateway) {
 			// Verify job belongs to client
 			var job BatchJob
 			if err := gateway.DB.Where("id = ? AND client_id = ?", jobID, client.ID).First(&job).Error; err != nil {
-				ctx.StatusCode(iris.StatusNotFound)
-				ctx.JSON(iris.Map{"error": "Batch job not found"})
+				apiError(ctx, iris.StatusNotFound, errCodeBatchNotFound, "Batch job not found")
 				return
 			}
 
 			// Find the message item
 			var item BatchMessageItem
 			if err := gateway.DB.Where("id = ? AND batch_job_id = ?", msgID, jobID).First(&item).Error; err != nil {
-				ctx.StatusCode(iris.StatusNotFound)
-				ctx.JSON(iris.Map{"error": "Message not found"})
+				apiError(ctx, iris.StatusNotFound, errCodeMessageNotFound, "Message not found")
 				return
 			}
 
 			// Only pending or queued messages can be cancelled
 			if item.Status != "pending" && item.Status != "queued" {
-				ctx.StatusCode(iris.StatusConflict)
-				ctx.JSON(iris.Map{
-					"error":  "Message cannot be cancelled",
-					"status": item.Status,
-					"detail": fmt.Sprintf("Message is already '%s'", item.Status),
-				})
+				apiError(ctx, iris.StatusConflict, errCodeCancelRefused, fmt.Sprintf("Message cannot be cancelled: it is already '%s'", item.Status))
 				return
 			}
 
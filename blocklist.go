@@ -0,0 +1,266 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/sirupsen/logrus"
+)
+
+// Directions a blocklist entry applies to (BlockedNumber.Direction).
+const (
+	BlockDirectionBoth     = ""         // both of the below
+	BlockDirectionInbound  = "inbound"  // carrier messages from the number
+	BlockDirectionOutbound = "outbound" // client messages to the number
+)
+
+// validBlockDirection reports whether direction is an accepted Direction.
+func validBlockDirection(direction string) bool {
+	switch direction {
+	case BlockDirectionBoth, BlockDirectionInbound, BlockDirectionOutbound:
+		return true
+	}
+	return false
+}
+
+// BlockedNumber is a globally blocked number, or with Prefix every number
+// starting with it. Inbound messages from it are dropped and outbound
+// messages to it are refused. Number is stored as digits only.
+type BlockedNumber struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Number    string    `gorm:"uniqueIndex:idx_blocked_number;not null" json:"number"`
+	Prefix    bool      `gorm:"uniqueIndex:idx_blocked_number;not null;default:false" json:"prefix"`
+	Direction string    `gorm:"uniqueIndex:idx_blocked_number;not null;default:''" json:"direction"` // '', 'inbound' or 'outbound'
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// appliesTo reports whether the entry covers direction.
+func (b *BlockedNumber) appliesTo(direction string) bool {
+	return b.Direction == BlockDirectionBoth || b.Direction == direction
+}
+
+// blocklistSet is an immutable snapshot of the blocklist table.
+type blocklistSet struct {
+	exact    map[string][]BlockedNumber
+	prefixes []BlockedNumber // longest first
+}
+
+func newBlocklistSet(rows []BlockedNumber) *blocklistSet {
+	s := &blocklistSet{exact: make(map[string][]BlockedNumber)}
+	for _, row := range rows {
+		if row.Prefix {
+			s.prefixes = append(s.prefixes, row)
+		} else {
+			s.exact[row.Number] = append(s.exact[row.Number], row)
+		}
+	}
+	sort.SliceStable(s.prefixes, func(i, j int) bool {
+		return len(s.prefixes[i].Number) > len(s.prefixes[j].Number)
+	})
+	return s
+}
+
+// size returns the number of entries in the snapshot.
+func (s *blocklistSet) size() int {
+	n := len(s.prefixes)
+	for _, rows := range s.exact {
+		n += len(rows)
+	}
+	return n
+}
+
+// match returns the entry blocking number in direction, exact entries before
+// the longest prefix, or nil.
+func (s *blocklistSet) match(number, direction string) *BlockedNumber {
+	number = strings.TrimPrefix(number, "+")
+	for _, b := range s.exact[number] {
+		if b.appliesTo(direction) {
+			return &b
+		}
+	}
+	for _, b := range s.prefixes {
+		if strings.HasPrefix(number, b.Number) && b.appliesTo(direction) {
+			return &b
+		}
+	}
+	return nil
+}
+
+// blocklist holds the current snapshot, replaced whole on every reload so
+// the router never sees a half-loaded list. The zero value blocks nothing.
+type blocklist struct {
+	set atomic.Pointer[blocklistSet]
+}
+
+func (l *blocklist) match(number, direction string) *BlockedNumber {
+	s := l.set.Load()
+	if s == nil {
+		return nil
+	}
+	return s.match(number, direction)
+}
+
+// loadBlocklist loads the blocked_numbers table into memory.
+func (gateway *Gateway) loadBlocklist() error {
+	set, err := gateway.readBlocklist()
+	if err != nil {
+		return err
+	}
+	gateway.blocklist.set.Store(set)
+	return nil
+}
+
+// readBlocklist reads the blocked_numbers table into a new snapshot.
+func (gateway *Gateway) readBlocklist() (*blocklistSet, error) {
+	var rows []BlockedNumber
+	if err := gateway.DB.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	return newBlocklistSet(rows), nil
+}
+
+// blockedNumber returns the blocklist entry that stops a message from -> to
+// arriving from origin: the source for carrier messages, the destination
+// for client messages.
+func (gateway *Gateway) blockedNumber(from, to, origin string) *BlockedNumber {
+	if origin == "carrier" {
+		return gateway.blocklist.match(from, BlockDirectionInbound)
+	}
+	return gateway.blocklist.match(to, BlockDirectionOutbound)
+}
+
+// blockBlocklisted stops a message whose source or destination is on the
+// blocklist. Inbound messages are dropped silently; outbound ones are
+// reported to the sending client as rejected.
+func (router *Router) blockBlocklisted(m *MsgQueueItem, d routeDecision, origin string) {
+	lm := router.gateway.LogManager
+	fields := map[string]interface{}{
+		"logID":   m.LogID,
+		"traceID": m.TraceID,
+		"from":    m.From,
+		"to":      m.To,
+		"msgType": string(m.Type),
+		"blocked": d.Blocked.Number,
+		"prefix":  d.Blocked.Prefix,
+		"reason":  d.Blocked.Reason,
+	}
+
+	if origin == "carrier" {
+		fields["toClient"] = safeClientUsername(d.ToClient)
+		lm.SendLog(lm.BuildLog("Router.Blocklist", "InboundBlocked", logrus.WarnLevel, fields))
+		if m.Type == MsgQueueItemType.MMS {
+			router.gateway.completePendingMMS(m)
+		}
+		return
+	}
+
+	fields["client"] = safeClientUsername(d.FromClient)
+	lm.SendLog(lm.BuildLog("Router.Blocklist", "OutboundBlocked", logrus.WarnLevel, fields))
+	router.gateway.SMPPServer.sendDeliveryReceipt(m, DLRStatRejected, DLRErrBlocklisted)
+	if router.gateway.MsgRecordChan != nil && d.FromClient != nil {
+		router.gateway.MsgRecordChan <- MsgRecord{
+			MsgQueueItem: *m,
+			ClientID:     d.FromClient.ID,
+			Direction:    "outbound",
+			Status:       MsgStatusBlocked,
+			Error:        d.Error,
+		}
+	}
+}
+
+// addBlockedNumber validates and persists an entry, then reloads the list.
+func (gateway *Gateway) addBlockedNumber(entry *BlockedNumber) error {
+	entry.Number = strings.TrimPrefix(strings.TrimSpace(entry.Number), "+")
+	if entry.Number == "" {
+		return errors.New("number is required")
+	}
+	if strings.Trim(entry.Number, "0123456789") != "" {
+		return errors.New("number must be digits, with an optional leading +")
+	}
+	if !validBlockDirection(entry.Direction) {
+		return errors.New("direction must be inbound, outbound or empty for both")
+	}
+	if err := gateway.DB.Create(entry).Error; err != nil {
+		return err
+	}
+	return gateway.loadBlocklist()
+}
+
+// SetupBlocklistRoutes sets up the HTTP routes for the number blocklist.
+func SetupBlocklistRoutes(app *iris.Application, gateway *Gateway) {
+	blocked := app.Party("/blocklist", gateway.basicAuthMiddleware)
+	{
+		// List blocklist entries
+		blocked.Get("/", func(ctx iris.Context) {
+			var rows []BlockedNumber
+			if err := gateway.DB.Order("number").Find(&rows).Error; err != nil {
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, "Failed to list blocklist")
+				return
+			}
+			if rows == nil {
+				rows = []BlockedNumber{}
+			}
+			ctx.JSON(rows)
+		})
+
+		// Block a number or prefix
+		blocked.Post("/", func(ctx iris.Context) {
+			var entry BlockedNumber
+			if err := ctx.ReadJSON(&entry); err != nil {
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Invalid blocklist entry")
+				return
+			}
+			entry.ID = 0
+
+			if err := gateway.addBlockedNumber(&entry); err != nil {
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, err.Error())
+				return
+			}
+
+			ctx.StatusCode(iris.StatusCreated)
+			ctx.JSON(entry)
+		})
+
+		// Unblock an entry
+		blocked.Delete("/{id}", func(ctx iris.Context) {
+			entryID, err := strconv.ParseUint(ctx.Params().Get("id"), 10, 32)
+			if err != nil {
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid blocklist entry ID")
+				return
+			}
+
+			result := gateway.DB.Delete(&BlockedNumber{}, entryID)
+			if result.Error != nil {
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, "Failed to delete blocklist entry")
+				return
+			}
+			if result.RowsAffected == 0 {
+				apiError(ctx, iris.StatusNotFound, errCodeBlockNotFound, "Blocklist entry not found")
+				return
+			}
+
+			if err := gateway.loadBlocklist(); err != nil {
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, err.Error())
+				return
+			}
+
+			ctx.JSON(iris.Map{"message": "Blocklist entry deleted", "id": entryID})
+		})
+
+		// Reload the blocklist from the database
+		blocked.Post("/reload", func(ctx iris.Context) {
+			if err := gateway.loadBlocklist(); err != nil {
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, err.Error())
+				return
+			}
+
+			ctx.JSON(iris.Map{"status": "Blocklist reloaded"})
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlocklistSet_Match(t *testing.T) {
+	s := newBlocklistSet([]BlockedNumber{
+		{Number: "14155550100"},
+		{Number: "1900", Prefix: true, Direction: BlockDirectionOutbound},
+		{Number: "1900555", Prefix: true, Direction: BlockDirectionInbound, Reason: "fraud"},
+	})
+
+	assert.NotNil(t, s.match("+14155550100", BlockDirectionInbound))
+	assert.NotNil(t, s.match("14155550100", BlockDirectionOutbound))
+	assert.Nil(t, s.match("+14155550101", BlockDirectionInbound))
+
+	assert.NotNil(t, s.match("+19005550000", BlockDirectionOutbound))
+	assert.Nil(t, s.match("+19001230000", BlockDirectionInbound), "outbound-only prefix")
+	if b := s.match("+19005550000", BlockDirectionInbound); assert.NotNil(t, b) {
+		assert.Equal(t, "fraud", b.Reason, "longest matching prefix for the direction")
+	}
+	assert.Equal(t, 3, s.size())
+}
+
+func TestRouter_ProcessMessageBlockedSource(t *testing.T) {
+	var hits int
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		hits++
+	}))
+	defer hook.Close()
+
+	r := newDecisionRouter(t)
+	gw := r.gateway
+	gw.Config.WebhookTimeoutSecs = 5
+	gw.MsgRecordChan = make(chan MsgRecord, 4)
+	gw.Clients["pbx2"].WebhookURL = hook.URL
+	gw.blocklist.set.Store(newBlocklistSet([]BlockedNumber{{Number: "14155559876", Direction: BlockDirectionInbound}}))
+
+	d := r.decideRoute("+14155559876", "+12505550000", MsgQueueItemType.SMS, "carrier")
+	assert.Equal(t, RoutePathBlocked, d.Path)
+
+	r.processMessage(&MsgQueueItem{LogID: "bl-1", From: "+14155559876", To: "+12505550000", Type: MsgQueueItemType.SMS, message: "spam"}, "carrier")
+	assert.Zero(t, hits, "blocked source is not delivered")
+	assert.Empty(t, gw.MsgRecordChan)
+
+	r.processMessage(&MsgQueueItem{LogID: "bl-2", From: "+14155550000", To: "+12505550000", Type: MsgQueueItemType.SMS, message: "hi"}, "carrier")
+	assert.Equal(t, 1, hits, "other senders still get through")
+}
+
+func TestRouter_ProcessMessageBlockedDestination(t *testing.T) {
+	r := newDecisionRouter(t)
+	gw := r.gateway
+	gw.ConvoManager = NewConvoManager()
+	gw.MsgRecordChan = make(chan MsgRecord, 4)
+	gw.Clients["pbx1"].ID = 3
+	gw.blocklist.set.Store(newBlocklistSet([]BlockedNumber{{Number: "44770090", Prefix: true, Reason: "premium range"}}))
+
+	assert.Nil(t, gw.blockedNumber("+12505551234", "+447700900123", "carrier"), "destination blocks only apply to client messages")
+
+	r.processMessage(&MsgQueueItem{LogID: "bl-3", From: "+12505551234", To: "+447700900123", Type: MsgQueueItemType.SMS, message: "hi"}, "client")
+
+	require.Len(t, gw.MsgRecordChan, 1)
+	record := <-gw.MsgRecordChan
+	assert.Equal(t, MsgStatusBlocked, record.Status)
+	assert.Equal(t, uint(3), record.ClientID)
+	assert.Equal(t, "Number is blocklisted: premium range", record.Error)
+	assert.Empty(t, r.CarrierMsgChan)
+}
@@ -13,14 +13,21 @@ import (
 type Carrier struct {
 	ID        uint   `gorm:"primaryKey" json:"id"`
 	Name      string `gorm:"unique;not null" json:"name"` // e.g., "twilio", "telnyx"
-	Type      string `gorm:"not null" json:"type"`        // e.g., "twilio", "telnyx"
+	Type      string `gorm:"not null" json:"type"`        // e.g., "twilio", "telnyx", "aws", "smpp"
 	Username  string `gorm:"not null" json:"username"`    // e.g., Account SID for Twilio (plaintext)
 	Password  string `gorm:"not null" json:"password"`    // e.g., Auth Token for Twilio (encrypted)
 	UUID      string `gorm:"unique;not null" json:"uuid"` // Internal UUID for webhook routing
-	ProfileID string `json:"profile_id,omitempty"`        // Carrier-specific ID (e.g., Telnyx messaging_profile_id)
+	ProfileID string `json:"profile_id,omitempty"`        // Carrier-specific ID (e.g., Telnyx messaging_profile_id, SMSC host:port)
+	// Send limits; zero falls back to CARRIER_MAX_CONCURRENCY / CARRIER_MAX_TPS
+	MaxConcurrency int     `json:"max_concurrency,omitempty"`
+	MaxTPS         float64 `json:"max_tps,omitempty"`
 	// Add any carrier-specific configuration fields here
 }
 
+// traceIDHeader carries a message's TraceID on outbound carrier API requests,
+// so a carrier support ticket can be matched to our logs.
+const traceIDHeader = "X-Trace-ID"
+
 // Name returns the name of the carrier handler
 func (h *BaseCarrierHandler) Name() string {
 	return h.name
@@ -59,12 +66,71 @@ type CarrierHandler interface {
 
 // loadCarriers loads carriers from the database and initializes their handlers.
 func (gateway *Gateway) loadCarriers() error {
+	carriersMap, carriersMapUUIDs, err := gateway.readCarriers()
+	if err != nil {
+		return err
+	}
+
+	// Update the Gateway's Carriers map
+	gateway.mu.Lock()
+	old := gateway.Carriers
+	gateway.Carriers = carriersMap
+	gateway.CarrierUUIDs = carriersMapUUIDs
+	gateway.mu.Unlock()
+	closeCarriers(old, carriersMap)
+
+	return nil
+}
+
+// closableCarrier is implemented by carrier handlers that hold a connection
+// open, such as an SMPP bind, which must be closed when they are replaced.
+type closableCarrier interface {
+	Close()
+}
+
+// closeCarriers closes the handlers that hold a connection open, except
+// those still in use in keep.
+func closeCarriers(handlers, keep map[string]CarrierHandler) {
+	for name, handler := range handlers {
+		if keep[name] == handler {
+			continue
+		}
+		if c, ok := handler.(closableCarrier); ok {
+			c.Close()
+		}
+	}
+}
+
+// currentCarriers returns the gateway's carrier handlers.
+func (gateway *Gateway) currentCarriers() map[string]CarrierHandler {
+	gateway.mu.RLock()
+	defer gateway.mu.RUnlock()
+	return gateway.Carriers
+}
+
+// reusableCarrier returns the running handler of carrier when it holds a
+// connection open and its row is unchanged, so a reload does not drop and
+// re-establish an SMPP bind for nothing.
+func reusableCarrier(current map[string]CarrierHandler, carrier Carrier) (CarrierHandler, bool) {
+	h, ok := current[carrier.Name].(*SMPPCarrierHandler)
+	if !ok || h.carrier == nil || *h.carrier != carrier {
+		return nil, false
+	}
+	return h, true
+}
+
+// readCarriers reads the carriers from the database and initializes their
+// handlers, keyed by name and by UUID, without touching the gateway's maps.
+// Running handlers of unchanged carriers that hold a connection open are
+// reused; closeCarriers(old, new) leaves them open.
+func (gateway *Gateway) readCarriers() (map[string]CarrierHandler, map[string]Carrier, error) {
 	var carriers []Carrier
 
 	// Fetch all carriers from the database
 	if err := gateway.DB.Find(&carriers).Error; err != nil {
-		return err
+		return nil, nil, err
 	}
+	current := gateway.currentCarriers()
 
 	carriersMap := make(map[string]CarrierHandler)
 	carriersMapUUIDs := make(map[string]Carrier)
@@ -74,7 +140,14 @@ func (gateway *Gateway) loadCarriers() error {
 		// Decrypt password (username is stored as plaintext)
 		decryptedPassword, err := DecryptAES256(carrier.Password, gateway.EncryptionKey)
 		if err != nil {
-			return fmt.Errorf("failed to decrypt password for carrier %s: %w", carrier.Name, err)
+			closeCarriers(carriersMap, current)
+			return nil, nil, fmt.Errorf("failed to decrypt password for carrier %s: %w", carrier.Name, err)
+		}
+
+		if handler, ok := reusableCarrier(current, carrier); ok {
+			carriersMap[carrier.Name] = handler
+			carriersMapUUIDs[carrier.UUID] = carrier
+			continue
 		}
 
 		var handler CarrierHandler
@@ -85,20 +158,23 @@ func (gateway *Gateway) loadCarriers() error {
 			handler = NewTelnyxHandler(gateway, &carrier, carrier.Username, decryptedPassword)
 		case "onevoiceplus":
 			handler = NewOneVoicePlusHandler(gateway, &carrier, carrier.Username, decryptedPassword)
+		case "vonage":
+			handler = NewVonageHandler(gateway, &carrier, carrier.Username, decryptedPassword)
+		case "aws":
+			handler = NewAWSHandler(gateway, &carrier, carrier.Username, decryptedPassword)
+		case "sinch":
+			handler = NewSinchHandler(gateway, &carrier, carrier.Username, decryptedPassword)
+		case "smpp":
+			handler = NewSMPPCarrierHandler(gateway, &carrier, carrier.Username, decryptedPassword)
 		default:
-			return fmt.Errorf("unknown carrier type: %s", carrier.Type)
+			closeCarriers(carriersMap, current)
+			return nil, nil, fmt.Errorf("unknown carrier type: %s", carrier.Type)
 		}
 		carriersMap[carrier.Name] = handler
 		carriersMapUUIDs[carrier.UUID] = carrier
 	}
 
-	// Update the Gateway's Carriers map
-	gateway.mu.Lock()
-	defer gateway.mu.Unlock()
-	gateway.Carriers = carriersMap
-	gateway.CarrierUUIDs = carriersMapUUIDs
-
-	return nil
+	return carriersMap, carriersMapUUIDs, nil
 }
 
 // addCarrier adds a new carrier to the database and initializes its handler.
@@ -130,15 +206,24 @@ func (gateway *Gateway) addCarrier(carrier *Carrier) error {
 		handler = NewTelnyxHandler(gateway, carrier, plaintextUsername, plaintextPassword)
 	case "onevoiceplus":
 		handler = NewOneVoicePlusHandler(gateway, carrier, plaintextUsername, plaintextPassword)
+	case "vonage":
+		handler = NewVonageHandler(gateway, carrier, plaintextUsername, plaintextPassword)
+	case "aws":
+		handler = NewAWSHandler(gateway, carrier, plaintextUsername, plaintextPassword)
+	case "sinch":
+		handler = NewSinchHandler(gateway, carrier, plaintextUsername, plaintextPassword)
+	case "smpp":
+		handler = NewSMPPCarrierHandler(gateway, carrier, plaintextUsername, plaintextPassword)
 	default:
 		return fmt.Errorf("unknown carrier type: %s", carrier.Type)
 	}
 
-	// Add the handler to the in-memory map
+	// Add the handler to the in-memory map and make it routable
 	gateway.mu.Lock()
-	defer gateway.mu.Unlock()
 	gateway.Carriers[carrier.Name] = handler
 	gateway.CarrierUUIDs[carrier.UUID] = *carrier
+	gateway.mu.Unlock()
+	gateway.Router.syncCarrierRoutes()
 
 	return nil
 }
@@ -152,8 +237,3 @@ func (gateway *Gateway) FindCarrierByUUID(uuid string) (*Carrier, bool) {
 	}
 	return nil, false
 }
-
-// reloadCarriers reloads carriers from the database and reinitializes their handlers.
-func (gateway *Gateway) reloadCarriers() error {
-	return gateway.loadCarriers()
-}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// errCarrierUnavailable is returned, wrapped with the carrier name, when a
+// carrier's circuit breaker is open and the send was not attempted. The
+// router retries the message later, when another carrier may be chosen.
+var errCarrierUnavailable = errors.New("carrier unavailable")
+
+// carrierBreaker counts consecutive failed sends to one carrier. At the
+// threshold it opens for the cooldown; after that a single trial send is let
+// through, which closes it on success or reopens it on failure.
+type carrierBreaker struct {
+	failures  int
+	openUntil time.Time
+}
+
+// carrierBreakers holds the breaker of each carrier.
+type carrierBreakers struct {
+	mu       sync.Mutex
+	breakers map[string]*carrierBreaker
+}
+
+// get returns carrier's breaker, creating it closed. Callers hold c.mu.
+func (c *carrierBreakers) get(carrier string) *carrierBreaker {
+	if c.breakers == nil {
+		c.breakers = make(map[string]*carrierBreaker)
+	}
+	b, ok := c.breakers[carrier]
+	if !ok {
+		b = &carrierBreaker{}
+		c.breakers[carrier] = b
+	}
+	return b
+}
+
+// allow reports whether a send to carrier may go ahead at now. A breaker
+// past its cooldown allows one trial and stays open for everyone else.
+func (c *carrierBreakers) allow(carrier string, threshold int, cooldown time.Duration, now time.Time) bool {
+	if threshold <= 0 {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b := c.get(carrier)
+	if b.failures < threshold {
+		return true
+	}
+	if now.Before(b.openUntil) {
+		return false
+	}
+	b.openUntil = now.Add(cooldown)
+	return true
+}
+
+// record counts the outcome of a send to carrier and reports whether it
+// opened the breaker.
+func (c *carrierBreakers) record(carrier string, ok bool, threshold int, cooldown time.Duration, now time.Time) bool {
+	if threshold <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b := c.get(carrier)
+	if ok {
+		b.failures = 0
+		b.openUntil = time.Time{}
+		return false
+	}
+	b.failures++
+	if b.failures < threshold {
+		return false
+	}
+	b.openUntil = now.Add(cooldown)
+	return true
+}
+
+// isOpen reports whether carrier's breaker is refusing sends at now.
+func (c *carrierBreakers) isOpen(carrier string, threshold int, now time.Time) bool {
+	if threshold <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.breakers[carrier]
+	return ok && b.failures >= threshold && now.Before(b.openUntil)
+}
+
+// carrierAvailable reports whether sends to carrier are currently allowed
+// by its circuit breaker.
+func (gateway *Gateway) carrierAvailable(carrier string) bool {
+	return !gateway.carrierBreakers.isOpen(carrier, gateway.Config.CarrierBreakerThreshold, time.Now())
+}
+
+// carrierAttempt classifies the outcome of one call to a carrier API.
+type carrierAttempt int
+
+const (
+	// attemptAnswered: the carrier answered, successfully or with a
+	// rejection. Not a carrier failure.
+	attemptAnswered carrierAttempt = iota
+	// attemptRetry: the carrier failed and certainly did not accept the
+	// message (it could not be reached, or answered 429), so it is resent.
+	attemptRetry
+	// attemptFailed: the carrier failed but may have accepted the message
+	// (5xx, or the connection broke after the request was sent). Sends are
+	// not idempotent, so it is not resent here.
+	attemptFailed
+)
+
+// carrierCall runs attempt against carrier's API up to CarrierHTTPAttempts
+// times, backing off between tries while attempt returns attemptRetry.
+// Answered calls close the breaker; failed ones count against it.
+func (gateway *Gateway) carrierCall(carrier string, attempt func() (carrierAttempt, error)) error {
+	cfg := gateway.Config
+	cooldown := time.Duration(cfg.CarrierBreakerCooldownSecs) * time.Second
+	if !gateway.carrierBreakers.allow(carrier, cfg.CarrierBreakerThreshold, cooldown, time.Now()) {
+		return fmt.Errorf("%w: %s", errCarrierUnavailable, carrier)
+	}
+
+	attempts := cfg.CarrierHTTPAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	backoff := time.Duration(cfg.CarrierHTTPBackoffMs) * time.Millisecond
+
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			time.Sleep(backoff << (i - 1))
+		}
+		var result carrierAttempt
+		result, err = attempt()
+		if err == nil || result == attemptAnswered {
+			gateway.carrierBreakers.record(carrier, true, cfg.CarrierBreakerThreshold, cooldown, time.Now())
+			return err
+		}
+		if result != attemptRetry {
+			break
+		}
+	}
+
+	if gateway.carrierBreakers.record(carrier, false, cfg.CarrierBreakerThreshold, cooldown, time.Now()) {
+		lm := gateway.LogManager
+		lm.SendLog(lm.BuildLog(
+			"Carrier.Breaker",
+			"CarrierBreakerOpen",
+			logrus.WarnLevel,
+			map[string]interface{}{
+				"carrier":      carrier,
+				"cooldownSecs": cfg.CarrierBreakerCooldownSecs,
+			}, err,
+		))
+	}
+	return err
+}
+
+// carrierStatusAttempt classifies an HTTP status from a carrier API. Only
+// 429 is retried; a 5xx may come after the message was accepted.
+func carrierStatusAttempt(code int) carrierAttempt {
+	switch {
+	case code == http.StatusTooManyRequests:
+		return attemptRetry
+	case code >= 500:
+		return attemptFailed
+	}
+	return attemptAnswered
+}
+
+// carrierErrAttempt classifies a transport error from a carrier API call.
+// Only a failure to connect proves the request was never sent.
+func carrierErrAttempt(err error) carrierAttempt {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return attemptRetry
+	}
+	return attemptFailed
+}
+
+// carrierHTTP sends the request built by newReq to carrier through
+// carrierCall, retrying connection failures and 429. It returns the status
+// and body of the last response; err is set only when no response was
+// received or the breaker is open.
+func (gateway *Gateway) carrierHTTP(carrier string, client *http.Client, newReq func() (*http.Request, error)) (int, []byte, error) {
+	var (
+		status int
+		body   []byte
+	)
+	err := gateway.carrierCall(carrier, func() (carrierAttempt, error) {
+		status, body = 0, nil
+		req, err := newReq()
+		if err != nil {
+			return attemptAnswered, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return carrierErrAttempt(err), err
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return attemptFailed, err
+		}
+		status, body = resp.StatusCode, b
+		if result := carrierStatusAttempt(status); result != attemptAnswered {
+			return result, fmt.Errorf("%s returned HTTP %d", carrier, status)
+		}
+		return attemptAnswered, nil
+	})
+	if err != nil && status == 0 {
+		return 0, nil, err
+	}
+	return status, body, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	twilioClient "github.com/twilio/twilio-go/client"
+)
+
+func newBreakerTestGateway(attempts, threshold int) *Gateway {
+	_, gw := newTestRouter(1)
+	gw.Config.CarrierHTTPAttempts = attempts
+	gw.Config.CarrierBreakerThreshold = threshold
+	gw.Config.CarrierBreakerCooldownSecs = 30
+	return gw
+}
+
+func TestCarrierBreakers_OpenCooldownTrial(t *testing.T) {
+	var c carrierBreakers
+	now := time.Now()
+	cooldown := 30 * time.Second
+
+	assert.False(t, c.record("telnyx", false, 2, cooldown, now))
+	assert.True(t, c.allow("telnyx", 2, cooldown, now))
+	assert.True(t, c.record("telnyx", false, 2, cooldown, now), "second failure opens the breaker")
+	assert.False(t, c.allow("telnyx", 2, cooldown, now))
+	assert.True(t, c.isOpen("telnyx", 2, now))
+	assert.False(t, c.isOpen("twilio", 2, now), "breakers are per carrier")
+
+	// After the cooldown one trial goes through; others wait for its outcome
+	later := now.Add(cooldown)
+	assert.True(t, c.allow("telnyx", 2, cooldown, later))
+	assert.False(t, c.allow("telnyx", 2, cooldown, later))
+
+	c.record("telnyx", true, 2, cooldown, later)
+	assert.True(t, c.allow("telnyx", 2, cooldown, later))
+	assert.False(t, c.isOpen("telnyx", 2, later))
+}
+
+func TestCarrierBreakers_Disabled(t *testing.T) {
+	var c carrierBreakers
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		assert.False(t, c.record("telnyx", false, 0, time.Second, now))
+	}
+	assert.True(t, c.allow("telnyx", 0, time.Second, now))
+}
+
+func TestTelnyxSendSMS_RetriesTransientFailures(t *testing.T) {
+	var calls int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"data":{"id":"msg-1"}}`))
+	}))
+	defer api.Close()
+
+	gw := newBreakerTestGateway(3, 5)
+	h := NewTelnyxHandler(gw, &Carrier{Name: "telnyx"}, "", "key")
+	h.apiBase = api.URL
+
+	id, err := h.SendSMS(&MsgQueueItem{From: "+15551230000", To: "+15559870000", message: "hello"})
+	require.NoError(t, err)
+	assert.Equal(t, "msg-1", id)
+	assert.EqualValues(t, 3, atomic.LoadInt32(&calls))
+}
+
+func TestTelnyxSendSMS_NoRetryOnClientError(t *testing.T) {
+	var calls int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}))
+	defer api.Close()
+
+	gw := newBreakerTestGateway(3, 1)
+	h := NewTelnyxHandler(gw, &Carrier{Name: "telnyx"}, "", "key")
+	h.apiBase = api.URL
+
+	_, err := h.SendSMS(&MsgQueueItem{From: "+15551230000", To: "+15559870000", message: "hello"})
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, errCarrierUnavailable))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+	assert.True(t, gw.carrierAvailable("telnyx"), "a 4xx is an answer, not an outage")
+}
+
+func TestTelnyxSendSMS_BreakerShortCircuits(t *testing.T) {
+	var calls int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer api.Close()
+
+	gw := newBreakerTestGateway(3, 1)
+	h := NewTelnyxHandler(gw, &Carrier{Name: "telnyx"}, "", "key")
+	h.apiBase = api.URL
+	sms := &MsgQueueItem{From: "+15551230000", To: "+15559870000", message: "hello"}
+
+	_, err := h.SendSMS(sms)
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, errCarrierUnavailable))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls), "a 5xx may have been accepted and is not resent")
+	assert.False(t, gw.carrierAvailable("telnyx"))
+
+	_, err = h.SendSMS(sms)
+	assert.True(t, errors.Is(err, errCarrierUnavailable))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls), "open breaker does not call the API")
+}
+
+func TestTwilioAttempt(t *testing.T) {
+	dialErr := &url.Error{Op: "Post", URL: "https://api.twilio.com", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}
+	assert.Equal(t, attemptRetry, twilioAttempt(dialErr))
+	assert.Equal(t, attemptFailed, twilioAttempt(io.ErrUnexpectedEOF), "the request may have been sent")
+	assert.Equal(t, attemptRetry, twilioAttempt(&twilioClient.TwilioRestError{Status: 429}))
+	assert.Equal(t, attemptFailed, twilioAttempt(&twilioClient.TwilioRestError{Status: 503}))
+	assert.Equal(t, attemptAnswered, twilioAttempt(&twilioClient.TwilioRestError{Status: 400}))
+}
+
+func TestRouter_SelectCarrierSkipsOpenBreaker(t *testing.T) {
+	r, gw := newTestRouter(1)
+	gw.Config.CarrierBreakerThreshold = 1
+	gw.Clients = map[string]*Client{
+		"pbx1": {Username: "pbx1", Numbers: []ClientNumber{{Number: "12505551234", Carrier: "telnyx"}}},
+	}
+	gw.CarrierRoutes = sortedRoutes(
+		CarrierRoute{Prefix: "44", Carrier: "vonage", Priority: 0},
+		CarrierRoute{Prefix: "44", Carrier: "sinch", Priority: 1},
+	)
+	r.AddRoute("carrier", "vonage", nil)
+	r.AddRoute("carrier", "sinch", nil)
+
+	assert.Equal(t, "vonage", r.selectCarrier("12505551234", "+447700900123"))
+
+	gw.carrierBreakers.record("vonage", false, 1, time.Minute, time.Now())
+	assert.Equal(t, "sinch", r.selectCarrier("12505551234", "+447700900123"))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// inboundDedupe remembers recently seen carrier message IDs so webhook
+// retries from the carrier are not delivered to clients twice. The zero value
+// is ready to use.
+type inboundDedupe struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// check records key and reports whether it was already seen within window.
+func (d *inboundDedupe) check(key string, window time.Duration) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	if d.now != nil {
+		now = d.now()
+	}
+	if d.seen == nil {
+		d.seen = make(map[string]time.Time)
+	}
+
+	// Drop expired entries at most once per window to bound memory.
+	if now.Sub(d.lastSweep) >= window {
+		for k, t := range d.seen {
+			if now.Sub(t) >= window {
+				delete(d.seen, k)
+			}
+		}
+		d.lastSweep = now
+	}
+
+	if t, ok := d.seen[key]; ok && now.Sub(t) < window {
+		return true
+	}
+	d.seen[key] = now
+	return false
+}
+
+// forget drops key so the next check for it passes.
+func (d *inboundDedupe) forget(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.seen, key)
+}
+
+// isDuplicateInbound reports whether carrier has already delivered a message
+// with messageID within INBOUND_DEDUPE_WINDOW_SECS. Empty IDs are never
+// treated as duplicates, and a window of 0 disables the check.
+func (gateway *Gateway) isDuplicateInbound(carrier, messageID string) bool {
+	if messageID == "" || gateway.Config.InboundDedupeWindowSecs <= 0 {
+		return false
+	}
+	window := time.Duration(gateway.Config.InboundDedupeWindowSecs) * time.Second
+	return gateway.inboundDedupe.check(carrier+":"+messageID, window)
+}
+
+// forgetInbound undoes isDuplicateInbound for a message that was not queued,
+// so the carrier's retry of it is processed instead of dropped.
+func (gateway *Gateway) forgetInbound(carrier, messageID string) {
+	if messageID == "" {
+		return
+	}
+	gateway.inboundDedupe.forget(carrier + ":" + messageID)
+}
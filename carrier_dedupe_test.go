@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_IsDuplicateInbound(t *testing.T) {
+	_, gw := newTestRouter(1)
+	gw.Config.InboundDedupeWindowSecs = 300
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	gw.inboundDedupe.now = func() time.Time { return now }
+
+	assert.False(t, gw.isDuplicateInbound("twilio", "SM123"), "first delivery passes")
+	assert.True(t, gw.isDuplicateInbound("twilio", "SM123"), "retry is dropped")
+	assert.False(t, gw.isDuplicateInbound("twilio", "SM456"), "new SID passes")
+	assert.False(t, gw.isDuplicateInbound("telnyx", "SM123"), "IDs are scoped per carrier")
+	assert.False(t, gw.isDuplicateInbound("twilio", ""), "empty IDs are never deduped")
+	assert.False(t, gw.isDuplicateInbound("twilio", ""))
+
+	now = now.Add(5 * time.Minute)
+	assert.False(t, gw.isDuplicateInbound("twilio", "SM123"), "passes again after the window")
+}
+
+func TestGateway_IsDuplicateInbound_Disabled(t *testing.T) {
+	_, gw := newTestRouter(1)
+
+	assert.False(t, gw.isDuplicateInbound("twilio", "SM123"))
+	assert.False(t, gw.isDuplicateInbound("twilio", "SM123"))
+}
+
+func TestGateway_ForgetInbound(t *testing.T) {
+	_, gw := newTestRouter(1)
+	gw.Config.InboundDedupeWindowSecs = 300
+
+	assert.False(t, gw.isDuplicateInbound("twilio", "SM123"))
+	gw.forgetInbound("twilio", "SM123")
+	assert.False(t, gw.isDuplicateInbound("twilio", "SM123"), "a failed message is processed on retry")
+	assert.True(t, gw.isDuplicateInbound("twilio", "SM123"))
+}
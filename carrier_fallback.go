@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// errCarrierRejected is wrapped by carrier handlers when the carrier answered
+// and refused the message, as opposed to failing or being unreachable. Only
+// such refusals and an open breaker send a message to a fallback carrier.
+var errCarrierRejected = errors.New("rejected by carrier")
+
+// carrierRejected wraps err with errCarrierRejected when code is an HTTP
+// status refusing the request itself: a 4xx other than 429.
+func carrierRejected(code int, err error) error {
+	if code >= 400 && code < 500 && code != http.StatusTooManyRequests {
+		return fmt.Errorf("%w: %w", errCarrierRejected, err)
+	}
+	return err
+}
+
+// fallbackCarriers returns the carriers of route's fallback_carriers in the
+// order they are tried, or nil for no route.
+func (route *CarrierRoute) fallbackCarriers() []string {
+	if route == nil {
+		return nil
+	}
+	var carriers []string
+	for _, name := range strings.Split(route.FallbackCarriers, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			carriers = append(carriers, name)
+		}
+	}
+	return carriers
+}
+
+// normalizeFallbackCarriers checks that every carrier of a comma-separated
+// fallback list is loaded and returns the list without blanks, duplicates or
+// the route's own carrier, which is always tried first.
+func (gateway *Gateway) normalizeFallbackCarriers(route *CarrierRoute) error {
+	seen := map[string]bool{route.Carrier: true}
+	var carriers []string
+	gateway.mu.RLock()
+	defer gateway.mu.RUnlock()
+	for _, name := range route.fallbackCarriers() {
+		if _, exists := gateway.Carriers[name]; !exists {
+			return fmt.Errorf("fallback carrier %s does not exist", name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			carriers = append(carriers, name)
+		}
+	}
+	route.FallbackCarriers = strings.Join(carriers, ",")
+	return nil
+}
+
+// sendWithFallback sends m with send through carrier and, while the carrier
+// rejects it or its breaker is open, through each fallback carrier of route
+// in turn. Other failures, which the carrier may recover from or may even
+// have accepted the message despite, are left to the usual retry. Each
+// carrier is tried at most once; fallbacks that are not loaded, have an open circuit
+// breaker or cannot carry a binary payload are skipped. It returns the ack ID
+// and error of the last send along with the carrier that made it, which took
+// the message when err is nil. A recipient that opted out or a message whose
+// validity period ran out ends the attempt at once, as another carrier would
+// fare no better. Failures before the last are recorded here; the caller
+// records the outcome of the last.
+func (router *Router) sendWithFallback(carrier string, route *CarrierRoute, m *MsgQueueItem, send func(CarrierHandler) (string, error)) (string, string, error) {
+	lm := router.gateway.LogManager
+	handler := router.findRouteByName("carrier", carrier).Handler
+	tried := map[string]bool{}
+	fallbacks := route.fallbackCarriers()
+
+	for {
+		tried[carrier] = true
+		ackID, err := router.gateway.carrierSend(carrier, m, func() (string, error) {
+			return send(handler)
+		})
+		if err == nil || ackID == "STOP_MESSAGE" || errors.Is(err, errMessageExpired) ||
+			!(errors.Is(err, errCarrierRejected) || errors.Is(err, errCarrierUnavailable)) {
+			return ackID, carrier, err
+		}
+
+		next := ""
+		for len(fallbacks) > 0 && next == "" {
+			name := fallbacks[0]
+			fallbacks = fallbacks[1:]
+			r := router.findRouteByName("carrier", name)
+			switch {
+			case tried[name], r == nil, !router.gateway.carrierAvailable(name):
+			case m.binary != nil && !carrierSupportsBinarySMS(r.Handler):
+			default:
+				next, handler = name, r.Handler
+			}
+		}
+		if next == "" {
+			return ackID, carrier, err
+		}
+
+		lm.SendLog(lm.BuildLog(
+			"Router.CarrierFallback",
+			"CarrierFallback",
+			logrus.WarnLevel,
+			map[string]interface{}{
+				"logID":    m.LogID,
+				"traceID":  m.TraceID,
+				"type":     m.Type,
+				"failed":   carrier,
+				"fallback": next,
+			}, err,
+		))
+		router.gateway.recordMessage("outbound", m.Type, carrier, MetricResultFailure)
+		carrier = next
+	}
+}
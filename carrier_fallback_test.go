@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"zultys-smpp-mm4/smpp/pdu"
+
+	"github.com/kataras/iris/v12"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scriptedCarrier is a CarrierHandler whose sends return err, or ackID when
+// err is nil, and counts how often it was called. With failFrom set, err is
+// returned only from that send on.
+type scriptedCarrier struct {
+	name     string
+	ackID    string
+	err      error
+	failFrom int
+	sends    int
+}
+
+func (c *scriptedCarrier) Inbound(iris.Context) error { return nil }
+func (c *scriptedCarrier) Name() string               { return c.name }
+
+func (c *scriptedCarrier) SendSMS(*MsgQueueItem) (string, error) {
+	c.sends++
+	if c.sends < c.failFrom {
+		return c.ackID, nil
+	}
+	return c.ackID, c.err
+}
+
+func (c *scriptedCarrier) SendMMS(m *MsgQueueItem) (string, error) { return c.SendSMS(m) }
+
+func TestCarrierRoute_FallbackCarriers(t *testing.T) {
+	var none *CarrierRoute
+	assert.Nil(t, none.fallbackCarriers())
+	route := &CarrierRoute{Carrier: "vonage", FallbackCarriers: " sinch, ,telnyx "}
+	assert.Equal(t, []string{"sinch", "telnyx"}, route.fallbackCarriers())
+
+	gw := &Gateway{Carriers: map[string]CarrierHandler{"vonage": nil, "sinch": nil, "telnyx": nil}}
+	route.FallbackCarriers = "vonage,sinch,telnyx,sinch"
+	require.NoError(t, gw.normalizeFallbackCarriers(route))
+	assert.Equal(t, "sinch,telnyx", route.FallbackCarriers, "the primary and repeats are dropped")
+
+	route.FallbackCarriers = "bandwidth"
+	assert.EqualError(t, gw.normalizeFallbackCarriers(route), "fallback carrier bandwidth does not exist")
+}
+
+func TestRouter_CarrierFallback(t *testing.T) {
+	r := newDecisionRouter(t)
+	gw := r.gateway
+	gw.ConvoManager = NewConvoManager()
+	gw.MsgRecordChan = make(chan MsgRecord, 4)
+	gw.Clients["pbx1"].ID = 3
+
+	primary := &scriptedCarrier{name: "vonage", err: carrierRejected(http.StatusBadRequest, errors.New("destination rejected"))}
+	fallback := &scriptedCarrier{name: "sinch", ackID: "sinch-1"}
+	r.Routes = nil
+	r.AddRoute("carrier", "telnyx", nil)
+	r.AddRoute("carrier", "vonage", primary)
+	r.AddRoute("carrier", "sinch", fallback)
+	gw.CarrierRoutes = sortedRoutes(CarrierRoute{Prefix: "44", Carrier: "vonage", FallbackCarriers: "vonage,bandwidth,sinch"})
+
+	r.processMessage(&MsgQueueItem{LogID: "fb-1", From: "+12505551234", To: "+447700900123", Type: MsgQueueItemType.SMS, message: "hi"}, "client")
+
+	assert.Equal(t, 1, primary.sends, "the primary is not retried by the fallback")
+	assert.Equal(t, 1, fallback.sends)
+	require.Len(t, gw.MsgRecordChan, 1, "the message is recorded once")
+	record := <-gw.MsgRecordChan
+	assert.Equal(t, "sinch", record.Carrier, "the record names the carrier that took the message")
+	assert.Equal(t, "sinch-1", record.CarrierMsgID)
+	assert.Empty(t, r.ClientMsgChan, "no retry is scheduled")
+
+	// When every carrier fails the message is retried as before.
+	fallback.err = fmt.Errorf("%w: sinch", errCarrierUnavailable)
+	r.processMessage(&MsgQueueItem{LogID: "fb-2", From: "+12505551234", To: "+447700900123", Type: MsgQueueItemType.SMS, message: "hi"}, "client")
+	assert.Equal(t, 2, primary.sends)
+	assert.Equal(t, 2, fallback.sends)
+	assert.Empty(t, gw.MsgRecordChan)
+
+	// A transient failure may have been accepted and is not sent elsewhere.
+	primary.err = carrierRejected(http.StatusBadGateway, errors.New("bad gateway"))
+	r.processMessage(&MsgQueueItem{LogID: "fb-3", From: "+12505551234", To: "+447700900123", Type: MsgQueueItemType.SMS, message: "hi"}, "client")
+	assert.Equal(t, 3, primary.sends)
+	assert.Equal(t, 2, fallback.sends, "no fallback on a 5xx")
+	assert.Empty(t, gw.MsgRecordChan)
+}
+
+func TestCarrierRejected(t *testing.T) {
+	err := errors.New("failed")
+	assert.ErrorIs(t, carrierRejected(http.StatusBadRequest, err), errCarrierRejected)
+	assert.ErrorIs(t, carrierRejected(http.StatusBadRequest, err), err)
+	assert.NotErrorIs(t, carrierRejected(http.StatusTooManyRequests, err), errCarrierRejected)
+	assert.NotErrorIs(t, carrierRejected(http.StatusServiceUnavailable, err), errCarrierRejected)
+	assert.True(t, smppRejected(pdu.ESME_RINVDSTADR))
+	assert.False(t, smppRejected(pdu.ESME_RTHROTTLED))
+}
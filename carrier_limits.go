@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// errMessageExpired is returned, wrapped with the carrier name, when a
+// message's validity period ran out while it waited for a send slot. It is
+// not retried.
+var errMessageExpired = errors.New("validity period expired waiting for carrier")
+
+// carrierLimit bounds the sends to one carrier. Zero values are unlimited.
+type carrierLimit struct {
+	Concurrency int     // sends in flight at once
+	TPS         float64 // sends started per second
+}
+
+// carrierLimiter queues the sends to one carrier beyond its limit. Sends
+// wait for one of the concurrency slots, then for their turn at the TPS
+// rate.
+type carrierLimiter struct {
+	limit    carrierLimit
+	slots    chan struct{} // nil when concurrency is unlimited
+	interval time.Duration // between send starts; zero when TPS is unlimited
+
+	mu   sync.Mutex
+	next time.Time // earliest start of the next send
+
+	inFlight atomic.Int64
+	queued   atomic.Int64
+}
+
+func newCarrierLimiter(limit carrierLimit) *carrierLimiter {
+	l := &carrierLimiter{limit: limit}
+	if limit.Concurrency > 0 {
+		l.slots = make(chan struct{}, limit.Concurrency)
+	}
+	if limit.TPS > 0 {
+		l.interval = time.Duration(float64(time.Second) / limit.TPS)
+	}
+	return l
+}
+
+// acquire waits for a slot and the send's turn, giving up with
+// errMessageExpired at deadline (none when zero). A successful acquire must
+// be followed by release.
+func (l *carrierLimiter) acquire(deadline time.Time) error {
+	l.queued.Add(1)
+	defer l.queued.Add(-1)
+
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return errMessageExpired
+		}
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-expired:
+			return errMessageExpired
+		}
+	}
+
+	if l.interval > 0 {
+		l.mu.Lock()
+		now := time.Now()
+		start := l.next
+		if start.Before(now) {
+			start = now
+		}
+		if !deadline.IsZero() && start.After(deadline) {
+			l.mu.Unlock()
+			l.releaseSlot()
+			return errMessageExpired
+		}
+		l.next = start.Add(l.interval)
+		l.mu.Unlock()
+		time.Sleep(time.Until(start))
+	}
+
+	l.inFlight.Add(1)
+	return nil
+}
+
+// release ends a send started by acquire.
+func (l *carrierLimiter) release() {
+	l.inFlight.Add(-1)
+	l.releaseSlot()
+}
+
+func (l *carrierLimiter) releaseSlot() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// carrierLimiters holds the limiter of each carrier.
+type carrierLimiters struct {
+	mu       sync.Mutex
+	limiters map[string]*carrierLimiter
+}
+
+// get returns carrier's limiter for limit. A limiter whose limit has changed,
+// after a carrier reload, is replaced; sends already holding the old one
+// finish against it.
+func (c *carrierLimiters) get(carrier string, limit carrierLimit) *carrierLimiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.limiters == nil {
+		c.limiters = make(map[string]*carrierLimiter)
+	}
+	l, ok := c.limiters[carrier]
+	if !ok || l.limit != limit {
+		l = newCarrierLimiter(limit)
+		c.limiters[carrier] = l
+	}
+	return l
+}
+
+// carrierLoad is the send load on one carrier.
+type carrierLoad struct {
+	Carrier  string
+	InFlight int64
+	Queued   int64
+}
+
+// loads returns the load on every carrier sent to so far, by name.
+func (c *carrierLimiters) loads() []carrierLoad {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	loads := make([]carrierLoad, 0, len(c.limiters))
+	for name, l := range c.limiters {
+		loads = append(loads, carrierLoad{Carrier: name, InFlight: l.inFlight.Load(), Queued: l.queued.Load()})
+	}
+	sort.Slice(loads, func(i, j int) bool { return loads[i].Carrier < loads[j].Carrier })
+	return loads
+}
+
+// carrierLimitFor returns the send limit of the named carrier: its own
+// max_concurrency and max_tps where set, otherwise CARRIER_MAX_CONCURRENCY
+// and CARRIER_MAX_TPS.
+func (gateway *Gateway) carrierLimitFor(carrier string) carrierLimit {
+	limit := carrierLimit{
+		Concurrency: gateway.Config.CarrierMaxConcurrency,
+		TPS:         gateway.Config.CarrierMaxTPS,
+	}
+	gateway.mu.RLock()
+	defer gateway.mu.RUnlock()
+	for _, c := range gateway.CarrierUUIDs {
+		if c.Name != carrier {
+			continue
+		}
+		if c.MaxConcurrency > 0 {
+			limit.Concurrency = c.MaxConcurrency
+		}
+		if c.MaxTPS > 0 {
+			limit.TPS = c.MaxTPS
+		}
+		break
+	}
+	return limit
+}
+
+// carrierSend runs send, which sends msg to carrier, within the carrier's
+// limits. Past the limits the send waits its turn, for no longer than msg's
+// validity period.
+func (gateway *Gateway) carrierSend(carrier string, msg *MsgQueueItem, send func() (string, error)) (string, error) {
+	limiter := gateway.carrierLimiters.get(carrier, gateway.carrierLimitFor(carrier))
+	if err := limiter.acquire(msg.ValidUntil); err != nil {
+		return "", fmt.Errorf("%w: %s", err, carrier)
+	}
+	defer limiter.release()
+	return send()
+}
+
+// expireCarrierMessage gives up on m, whose validity period ran out while it
+// waited for a send slot on carrier, with an EXPIRED receipt.
+func (router *Router) expireCarrierMessage(m *MsgQueueItem, carrier string, err error) {
+	lm := router.gateway.LogManager
+	lm.SendLog(lm.BuildLog(
+		"Router.CarrierLimit",
+		"MessageExpired",
+		logrus.WarnLevel,
+		map[string]interface{}{
+			"logID":      m.LogID,
+			"traceID":    m.TraceID,
+			"carrier":    carrier,
+			"validUntil": m.ValidUntil,
+		}, err,
+	))
+	router.gateway.recordMessage("outbound", m.Type, carrier, MetricResultFailure)
+	router.gateway.SMPPServer.sendDeliveryReceipt(m, DLRStatExpired, DLRErrNone)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCarrierSend_ConcurrencyCap(t *testing.T) {
+	gw := &Gateway{
+		Config:       GatewayConfig{CarrierMaxConcurrency: 5},
+		CarrierUUIDs: map[string]Carrier{"u1": {Name: "acme", MaxConcurrency: 3}},
+	}
+
+	var current, peak atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := gw.carrierSend("acme", &MsgQueueItem{}, func() (string, error) {
+				n := current.Add(1)
+				for {
+					p := peak.Load()
+					if n <= p || peak.CompareAndSwap(p, n) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+				current.Add(-1)
+				return "ok", nil
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(3), peak.Load(), "the carrier's own limit overrides the gateway default")
+	loads := gw.carrierLimiters.loads()
+	require.Len(t, loads, 1)
+	assert.Equal(t, carrierLoad{Carrier: "acme"}, loads[0], "nothing in flight or queued afterwards")
+}
+
+func TestCarrierSend_TPS(t *testing.T) {
+	gw := &Gateway{Config: GatewayConfig{CarrierMaxTPS: 50}}
+
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		_, err := gw.carrierSend("acme", &MsgQueueItem{}, func() (string, error) { return "", nil })
+		require.NoError(t, err)
+	}
+	// Six sends at 50 per second start 20ms apart.
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestCarrierSend_ExpiresWhileQueued(t *testing.T) {
+	gw := &Gateway{Config: GatewayConfig{CarrierMaxConcurrency: 1}}
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go gw.carrierSend("acme", &MsgQueueItem{}, func() (string, error) {
+		close(started)
+		<-release
+		return "", nil
+	})
+	<-started
+
+	sent := false
+	_, err := gw.carrierSend("acme", &MsgQueueItem{ValidUntil: time.Now().Add(20 * time.Millisecond)}, func() (string, error) {
+		sent = true
+		return "", nil
+	})
+	assert.True(t, errors.Is(err, errMessageExpired))
+	assert.False(t, sent)
+	close(release)
+
+	_, err = gw.carrierSend("other", &MsgQueueItem{ValidUntil: time.Now().Add(-time.Second)}, func() (string, error) { return "", nil })
+	assert.True(t, errors.Is(err, errMessageExpired), "already past its validity period")
+}
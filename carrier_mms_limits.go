@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// errMMSOverLimit is returned, wrapped with the details, for an MMS with more
+// attachments or more media than its carrier accepts. It is not retried.
+var errMMSOverLimit = errors.New("MMS exceeds carrier limits")
+
+// mmsLimit bounds the attachments of one MMS sent through a carrier type.
+// Zero values are unlimited.
+type mmsLimit struct {
+	MaxParts int  `json:"max_parts"` // attachments, not counting SMIL
+	MaxBytes int  `json:"max_bytes"` // total size of the attachments
+	Split    bool `json:"split"`     // send an over-limit MMS as several that fit
+}
+
+// defaultMMSLimits are the documented limits of the carrier types that have
+// them. Vonage and Sinch take one attachment per message and already send
+// each separately.
+var defaultMMSLimits = map[string]mmsLimit{
+	"twilio": {MaxParts: 10, MaxBytes: 5 * 1024 * 1024},
+	"telnyx": {MaxParts: 10},
+}
+
+// parseMMSLimits reads CARRIER_MMS_LIMITS, comma-separated
+// type=parts:bytes[:split] entries keyed by carrier type, over
+// defaultMMSLimits. Either number may be 0 for no limit; invalid entries are
+// skipped.
+func parseMMSLimits(val string) map[string]mmsLimit {
+	limits := make(map[string]mmsLimit, len(defaultMMSLimits))
+	for carrierType, limit := range defaultMMSLimits {
+		limits[carrierType] = limit
+	}
+	for _, entry := range strings.Split(val, ",") {
+		carrierType, spec, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		carrierType = strings.ToLower(strings.TrimSpace(carrierType))
+		if !ok || carrierType == "" {
+			continue
+		}
+		fields := strings.Split(strings.TrimSpace(spec), ":")
+		if len(fields) < 2 || len(fields) > 3 {
+			continue
+		}
+		parts, err1 := strconv.Atoi(fields[0])
+		bytes, err2 := strconv.Atoi(fields[1])
+		if err1 != nil || err2 != nil || parts < 0 || bytes < 0 {
+			continue
+		}
+		if len(fields) == 3 && fields[2] != "split" {
+			continue
+		}
+		limits[carrierType] = mmsLimit{MaxParts: parts, MaxBytes: bytes, Split: len(fields) == 3}
+	}
+	return limits
+}
+
+// msgFileSize returns the decoded size of f.
+func msgFileSize(f MsgFile) int {
+	if len(f.Content) > 0 || f.Base64Data == "" {
+		return len(f.Content)
+	}
+	return base64.StdEncoding.DecodedLen(len(f.Base64Data))
+}
+
+// splitMMSFiles checks files against limit. A message within the limit is
+// returned as its single part. One over it fails with errMMSOverLimit unless
+// the limit splits, in which case the attachments are returned in order as
+// the parts of several messages that each fit, without SMIL, which would
+// describe the whole.
+func splitMMSFiles(files []MsgFile, limit mmsLimit) ([][]MsgFile, error) {
+	var media []MsgFile
+	total := 0
+	for _, f := range files {
+		if f.ContentType != "application/smil" {
+			media = append(media, f)
+			total += msgFileSize(f)
+		}
+	}
+
+	tooMany := limit.MaxParts > 0 && len(media) > limit.MaxParts
+	tooLarge := limit.MaxBytes > 0 && total > limit.MaxBytes
+	switch {
+	case !tooMany && !tooLarge:
+		return [][]MsgFile{files}, nil
+	case !limit.Split && tooMany:
+		return nil, fmt.Errorf("%w: %d attachments, at most %d allowed", errMMSOverLimit, len(media), limit.MaxParts)
+	case !limit.Split:
+		return nil, fmt.Errorf("%w: %d bytes of media, at most %d allowed", errMMSOverLimit, total, limit.MaxBytes)
+	}
+
+	var parts [][]MsgFile
+	var part []MsgFile
+	partBytes := 0
+	for _, f := range media {
+		size := msgFileSize(f)
+		if limit.MaxBytes > 0 && size > limit.MaxBytes {
+			return nil, fmt.Errorf("%w: attachment %q is %d bytes, at most %d allowed", errMMSOverLimit, f.Filename, size, limit.MaxBytes)
+		}
+		full := limit.MaxParts > 0 && len(part) == limit.MaxParts
+		if full || (limit.MaxBytes > 0 && partBytes+size > limit.MaxBytes) {
+			parts = append(parts, part)
+			part, partBytes = nil, 0
+		}
+		part = append(part, f)
+		partBytes += size
+	}
+	return append(parts, part), nil
+}
+
+// sendMMS sends m through handler within the MMS limits of the handler's
+// carrier type. An MMS over the limits fails before any media is uploaded,
+// unless the carrier type splits: then it goes as several messages, the
+// first carrying the text, and the ID of the first is returned. Once a part
+// is sent the message counts as sent, since a retry would send that part
+// again; a later part that fails is logged and dropped.
+func (gateway *Gateway) sendMMS(handler CarrierHandler, m *MsgQueueItem) (string, error) {
+	parts, err := splitMMSFiles(m.files, gateway.Config.CarrierMMSLimits[handler.Name()])
+	if err != nil {
+		return "", fmt.Errorf("%w (%s)", err, handler.Name())
+	}
+	if len(parts) == 1 {
+		return handler.SendMMS(m)
+	}
+
+	lm := gateway.LogManager
+	lm.SendLog(lm.BuildLog(
+		"Router.MMS",
+		"MMSSplit",
+		logrus.InfoLevel,
+		map[string]interface{}{
+			"logID":   m.LogID,
+			"traceID": m.TraceID,
+			"carrier": handler.Name(),
+			"parts":   len(parts),
+		},
+	))
+
+	var firstID string
+	for i, files := range parts {
+		part := *m
+		part.files = files
+		if i > 0 {
+			part.message = ""
+		}
+		id, err := handler.SendMMS(&part)
+		if err != nil && i > 0 {
+			lm.SendLog(lm.BuildLog(
+				"Router.MMS",
+				"MMSSplitPartial",
+				logrus.ErrorLevel,
+				map[string]interface{}{
+					"logID":   m.LogID,
+					"traceID": m.TraceID,
+					"carrier": handler.Name(),
+					"part":    i + 1,
+					"parts":   len(parts),
+				}, err,
+			))
+			return firstID, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if firstID == "" {
+			firstID = id
+		}
+	}
+	return firstID, nil
+}
+
+// rejectOverLimitMMS gives up on m, which no carrier could take within its
+// MMS limits, without retrying it, and tells the sender why.
+func (router *Router) rejectOverLimitMMS(m *MsgQueueItem, carrier string, err error) {
+	lm := router.gateway.LogManager
+	lm.SendLog(lm.BuildLog(
+		"Router.MMS",
+		"MMSOverLimit",
+		logrus.WarnLevel,
+		map[string]interface{}{
+			"logID":   m.LogID,
+			"traceID": m.TraceID,
+			"carrier": carrier,
+			"files":   len(m.files),
+		}, err,
+	))
+	router.gateway.recordMessage("outbound", m.Type, carrier, MetricResultFailure)
+	router.gateway.completePendingMMS(m)
+
+	if m.Delivery == nil {
+		m.Delivery = &MsgQueueDelivery{}
+	}
+	m.Delivery.Error = err.Error()
+	router.gateway.recordRetryStatus(*m, MsgStatusFailed)
+
+	router.CarrierMsgChan <- MsgQueueItem{
+		To:      m.From,
+		From:    m.To,
+		Type:    MsgQueueItemType.SMS,
+		message: "Your message has too many attachments or is too large to send. Please send fewer or smaller files. ID: " + m.LogID,
+		LogID:   m.LogID,
+		TraceID: m.TraceID,
+		Delivery: &MsgQueueDelivery{
+			Error:      "discard after first attempt",
+			RetryTime:  time.Now(),
+			RetryCount: 666,
+		},
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMMSLimits(t *testing.T) {
+	limits := parseMMSLimits("")
+	assert.Equal(t, defaultMMSLimits, limits)
+
+	limits = parseMMSLimits("Twilio=5:0, acme=3:1048576:split, bad=3, worse=x:1, odd=1:1:merge")
+	assert.Equal(t, mmsLimit{MaxParts: 5}, limits["twilio"], "an entry replaces the default")
+	assert.Equal(t, mmsLimit{MaxParts: 3, MaxBytes: 1048576, Split: true}, limits["acme"])
+	assert.Equal(t, defaultMMSLimits["telnyx"], limits["telnyx"])
+	assert.NotContains(t, limits, "bad")
+	assert.NotContains(t, limits, "worse")
+	assert.NotContains(t, limits, "odd")
+}
+
+func TestSplitMMSFiles(t *testing.T) {
+	file := func(name string, size int) MsgFile {
+		return MsgFile{Filename: name, ContentType: "image/jpeg", Content: make([]byte, size)}
+	}
+	smil := MsgFile{Filename: "smil.xml", ContentType: "application/smil", Content: make([]byte, 500)}
+	files := []MsgFile{smil, file("a", 40), file("b", 40), file("c", 40)}
+
+	parts, err := splitMMSFiles(files, mmsLimit{MaxParts: 3, MaxBytes: 120})
+	require.NoError(t, err)
+	assert.Equal(t, [][]MsgFile{files}, parts, "SMIL counts towards neither limit")
+
+	_, err = splitMMSFiles(files, mmsLimit{MaxParts: 2})
+	assert.True(t, errors.Is(err, errMMSOverLimit))
+	assert.EqualError(t, err, "MMS exceeds carrier limits: 3 attachments, at most 2 allowed")
+
+	_, err = splitMMSFiles(files, mmsLimit{MaxBytes: 100})
+	assert.EqualError(t, err, "MMS exceeds carrier limits: 120 bytes of media, at most 100 allowed")
+
+	parts, err = splitMMSFiles(files, mmsLimit{MaxParts: 2, MaxBytes: 100, Split: true})
+	require.NoError(t, err)
+	assert.Equal(t, [][]MsgFile{{file("a", 40), file("b", 40)}, {file("c", 40)}}, parts)
+
+	parts, err = splitMMSFiles(files, mmsLimit{MaxBytes: 50, Split: true})
+	require.NoError(t, err)
+	assert.Len(t, parts, 3)
+
+	_, err = splitMMSFiles(append(files, file("big", 200)), mmsLimit{MaxBytes: 100, Split: true})
+	assert.EqualError(t, err, `MMS exceeds carrier limits: attachment "big" is 200 bytes, at most 100 allowed`)
+}
+
+func TestGatewaySendMMS_Split(t *testing.T) {
+	_, gw := newTestRouter(1)
+	gw.Config.CarrierMMSLimits = map[string]mmsLimit{"twilio": {MaxParts: 1, Split: true}}
+	carrier := &scriptedCarrier{name: "twilio", ackID: "SM1"}
+
+	files := []MsgFile{{Filename: "a", Content: []byte("a")}, {Filename: "b", Content: []byte("b")}}
+	ackID, err := gw.sendMMS(carrier, &MsgQueueItem{LogID: "mms-1", Type: MsgQueueItemType.MMS, files: files})
+	require.NoError(t, err)
+	assert.Equal(t, "SM1", ackID)
+	assert.Equal(t, 2, carrier.sends, "one MMS per attachment")
+
+	// Parts already sent are not sent again by a retry
+	carrier = &scriptedCarrier{name: "twilio", ackID: "SM2", err: errors.New("down"), failFrom: 2}
+	ackID, err = gw.sendMMS(carrier, &MsgQueueItem{LogID: "mms-2", Type: MsgQueueItemType.MMS, files: files})
+	require.NoError(t, err)
+	assert.Equal(t, "SM2", ackID)
+	assert.Equal(t, 2, carrier.sends)
+
+	carrier = &scriptedCarrier{name: "twilio", err: errors.New("down")}
+	_, err = gw.sendMMS(carrier, &MsgQueueItem{LogID: "mms-3", Type: MsgQueueItemType.MMS, files: files})
+	assert.Error(t, err, "a failed first part is retried as usual")
+	assert.Equal(t, 1, carrier.sends)
+}
+
+func TestRouter_MMSOverLimitRejected(t *testing.T) {
+	r := newDecisionRouter(t)
+	gw := r.gateway
+	gw.ConvoManager = NewConvoManager()
+	gw.MsgRecordChan = make(chan MsgRecord, 4)
+	gw.Clients["pbx1"].ID = 3
+	gw.Config.CarrierMMSLimits = map[string]mmsLimit{"twilio": {MaxParts: 2}}
+
+	carrier := &scriptedCarrier{name: "twilio", ackID: "SM1"}
+	r.Routes = nil
+	r.AddRoute("carrier", "telnyx", nil)
+	r.AddRoute("carrier", "vonage", carrier)
+
+	files := []MsgFile{
+		{Filename: "a.jpg", ContentType: "image/jpeg", Content: []byte("a")},
+		{Filename: "b.jpg", ContentType: "image/jpeg", Content: []byte("b")},
+		{Filename: "c.jpg", ContentType: "image/jpeg", Content: []byte("c")},
+	}
+	m := &MsgQueueItem{LogID: "mms-over", From: "+12505551234", To: "+447700900123", Type: MsgQueueItemType.MMS, files: files}
+	r.processMessage(m, "client")
+
+	assert.Equal(t, 0, carrier.sends, "nothing is uploaded or sent")
+	assert.Equal(t, 0, m.Delivery.RetryCount, "the message is not retried")
+	require.Len(t, r.CarrierMsgChan, 1, "the sender is told")
+	notice := <-r.CarrierMsgChan
+	assert.Equal(t, "+12505551234", notice.To)
+	assert.Contains(t, notice.message, "too many attachments")
+	assert.Equal(t, MsgQueueItemType.SMS, notice.Type)
+	require.Len(t, gw.MsgRecordChan, 1)
+	record := <-gw.MsgRecordChan
+	assert.Equal(t, MsgStatusFailed, record.Status)
+	assert.Contains(t, record.Error, "3 attachments, at most 2 allowed")
+
+	m.files = files[:2]
+	m.Delivery = nil
+	r.processMessage(m, "client")
+	assert.Equal(t, 1, carrier.sends, "a message within the limit is sent")
+	assert.Len(t, gw.MsgRecordChan, 1)
+}
@@ -118,18 +118,24 @@ func (h *OneVoicePlusHandler) Inbound(c iris.Context) error {
 	}
 
 	logID := primitive.NewObjectID().Hex()
+	traceID := newTraceID()
+	received := time.Now()
 
 	if strings.TrimSpace(payload.Message) != "" {
 		sms := MsgQueueItem{
 			To:                payload.To,
 			From:              payload.From,
-			ReceivedTimestamp: time.Now(),
+			ReceivedTimestamp: received,
+			QueuedTimestamp:   received,
 			Type:              MsgQueueItemType.SMS,
 			message:           payload.Message,
 			LogID:             logID,
+			TraceID:           traceID,
 			SourceCarrier:     h.carrier.Name,
 		}
-		h.gateway.Router.CarrierMsgChan <- sms
+		sms.Priority = h.gateway.msgPriorityFor(sms.To, sms.Type)
+		h.gateway.Router.carrierQueue(sms.Priority) <- sms
+		h.gateway.recordMessage("inbound", sms.Type, h.Name(), MetricResultReceived)
 	}
 
 	lm.SendLog(lm.BuildLog(
@@ -165,7 +171,8 @@ func (h *OneVoicePlusHandler) SendSMS(sms *MsgQueueItem) (string, error) {
 			"Failed to marshal payload: %v",
 			logrus.ErrorLevel,
 			map[string]interface{}{
-				"logID": sms.LogID,
+				"logID":   sms.LogID,
+				"traceID": sms.TraceID,
 			}, err,
 		))
 		return "", err
@@ -178,7 +185,8 @@ func (h *OneVoicePlusHandler) SendSMS(sms *MsgQueueItem) (string, error) {
 			"Failed to build request: %v",
 			logrus.ErrorLevel,
 			map[string]interface{}{
-				"logID": sms.LogID,
+				"logID":   sms.LogID,
+				"traceID": sms.TraceID,
 			}, err,
 		))
 		return "", err
@@ -186,6 +194,7 @@ func (h *OneVoicePlusHandler) SendSMS(sms *MsgQueueItem) (string, error) {
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-TELUS-SDF-Developer-Key", h.password)
+	req.Header.Set(traceIDHeader, sms.TraceID)
 
 	client := &http.Client{Timeout: 30 * time.Second}
 	resp, err := client.Do(req)
@@ -195,7 +204,8 @@ func (h *OneVoicePlusHandler) SendSMS(sms *MsgQueueItem) (string, error) {
 			"Failed to send request: %v",
 			logrus.ErrorLevel,
 			map[string]interface{}{
-				"logID": sms.LogID,
+				"logID":   sms.LogID,
+				"traceID": sms.TraceID,
 			}, err,
 		))
 		return "", err
@@ -209,7 +219,8 @@ func (h *OneVoicePlusHandler) SendSMS(sms *MsgQueueItem) (string, error) {
 			"Failed to read response body: %v",
 			logrus.ErrorLevel,
 			map[string]interface{}{
-				"logID": sms.LogID,
+				"logID":   sms.LogID,
+				"traceID": sms.TraceID,
 			}, err,
 		))
 		return "", err
@@ -222,6 +233,7 @@ func (h *OneVoicePlusHandler) SendSMS(sms *MsgQueueItem) (string, error) {
 			logrus.ErrorLevel,
 			map[string]interface{}{
 				"logID":           sms.LogID,
+				"traceID":         sms.TraceID,
 				"response_code":   resp.StatusCode,
 				"response_status": resp.Status,
 				"to":              sms.To,
@@ -259,6 +271,7 @@ func (h *OneVoicePlusHandler) SendMMS(mms *MsgQueueItem) (string, error) {
 				logrus.ErrorLevel,
 				map[string]interface{}{
 					"logID":    mms.LogID,
+					"traceID":  mms.TraceID,
 					"filename": file.Filename,
 				}, err,
 			))
@@ -273,7 +286,8 @@ func (h *OneVoicePlusHandler) SendMMS(mms *MsgQueueItem) (string, error) {
 			"NoMediaToSend",
 			logrus.WarnLevel,
 			map[string]interface{}{
-				"logID": mms.LogID,
+				"logID":   mms.LogID,
+				"traceID": mms.TraceID,
 			},
 		))
 		return "", errors.New("no media files to send via OneVoicePlus MMS")
@@ -292,7 +306,8 @@ func (h *OneVoicePlusHandler) SendMMS(mms *MsgQueueItem) (string, error) {
 			"Failed to marshal MMS payload: %v",
 			logrus.ErrorLevel,
 			map[string]interface{}{
-				"logID": mms.LogID,
+				"logID":   mms.LogID,
+				"traceID": mms.TraceID,
 			}, err,
 		))
 		return "", err
@@ -305,7 +320,8 @@ func (h *OneVoicePlusHandler) SendMMS(mms *MsgQueueItem) (string, error) {
 			"Failed to build MMS request: %v",
 			logrus.ErrorLevel,
 			map[string]interface{}{
-				"logID": mms.LogID,
+				"logID":   mms.LogID,
+				"traceID": mms.TraceID,
 			}, err,
 		))
 		return "", err
@@ -313,6 +329,7 @@ func (h *OneVoicePlusHandler) SendMMS(mms *MsgQueueItem) (string, error) {
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-TELUS-SDF-Developer-Key", h.password)
+	req.Header.Set(traceIDHeader, mms.TraceID)
 
 	client := &http.Client{Timeout: 30 * time.Second}
 	resp, err := client.Do(req)
@@ -322,7 +339,8 @@ func (h *OneVoicePlusHandler) SendMMS(mms *MsgQueueItem) (string, error) {
 			"Failed to send MMS request: %v",
 			logrus.ErrorLevel,
 			map[string]interface{}{
-				"logID": mms.LogID,
+				"logID":   mms.LogID,
+				"traceID": mms.TraceID,
 			}, err,
 		))
 		return "", err
@@ -336,7 +354,8 @@ func (h *OneVoicePlusHandler) SendMMS(mms *MsgQueueItem) (string, error) {
 			"Failed to read MMS response body: %v",
 			logrus.ErrorLevel,
 			map[string]interface{}{
-				"logID": mms.LogID,
+				"logID":   mms.LogID,
+				"traceID": mms.TraceID,
 			}, err,
 		))
 		return "", err
@@ -349,6 +368,7 @@ func (h *OneVoicePlusHandler) SendMMS(mms *MsgQueueItem) (string, error) {
 			logrus.ErrorLevel,
 			map[string]interface{}{
 				"logID":           mms.LogID,
+				"traceID":         mms.TraceID,
 				"response_code":   resp.StatusCode,
 				"response_status": resp.Status,
 				"to":              mms.To,
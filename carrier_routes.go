@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CarrierRoute sends outbound traffic whose destination starts with Prefix
+// through Carrier, regardless of the sender's own carrier. The longest
+// matching prefix wins; among equal prefixes the lowest Priority is used.
+// When the send to Carrier fails, FallbackCarriers are tried in order.
+type CarrierRoute struct {
+	ID               uint   `gorm:"primaryKey" json:"id"`
+	Prefix           string `gorm:"index;not null" json:"destination_prefix"` // E.164 digits without '+', e.g. "1", "44"
+	Carrier          string `gorm:"not null" json:"carrier"`                  // Carrier name as in the carriers table
+	FallbackCarriers string `json:"fallback_carriers,omitempty"`              // Comma-separated carrier names
+	Priority         int    `gorm:"default:0;not null" json:"priority"`       // Lower = tried first
+	Enabled          bool   `gorm:"default:true" json:"enabled"`
+}
+
+// normalizeRoutePrefix strips everything but digits so "+1", "1" and
+// "+1 (250)" compare the same way as stored numbers.
+func normalizeRoutePrefix(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// sortCarrierRoutes orders routes longest prefix first, then by priority, so
+// the first match is the one to use.
+func sortCarrierRoutes(routes []CarrierRoute) {
+	sort.SliceStable(routes, func(i, j int) bool {
+		if len(routes[i].Prefix) != len(routes[j].Prefix) {
+			return len(routes[i].Prefix) > len(routes[j].Prefix)
+		}
+		return routes[i].Priority < routes[j].Priority
+	})
+}
+
+// findCarrierRoute returns the first enabled route in the sorted routes
+// whose prefix matches to and for which available is true, or nil.
+func findCarrierRoute(routes []CarrierRoute, to string, available func(string) bool) *CarrierRoute {
+	digits := normalizeRoutePrefix(to)
+	for i, route := range routes {
+		if !route.Enabled || route.Prefix == "" || !strings.HasPrefix(digits, route.Prefix) {
+			continue
+		}
+		if available != nil && !available(route.Carrier) {
+			continue
+		}
+		return &routes[i]
+	}
+	return nil
+}
+
+// matchCarrierRoute returns the carrier of the route findCarrierRoute picks.
+func matchCarrierRoute(routes []CarrierRoute, to string, available func(string) bool) (string, bool) {
+	if route := findCarrierRoute(routes, to, available); route != nil {
+		return route.Carrier, true
+	}
+	return "", false
+}
+
+// loadCarrierRoutes loads the destination routing table from the database.
+func (gateway *Gateway) loadCarrierRoutes() error {
+	routes, err := gateway.readCarrierRoutes()
+	if err != nil {
+		return err
+	}
+
+	gateway.mu.Lock()
+	gateway.CarrierRoutes = routes
+	gateway.mu.Unlock()
+
+	return nil
+}
+
+// readCarrierRoutes reads the destination routing table from the database,
+// normalized and in match order.
+func (gateway *Gateway) readCarrierRoutes() ([]CarrierRoute, error) {
+	var routes []CarrierRoute
+	if err := gateway.DB.Find(&routes).Error; err != nil {
+		return nil, err
+	}
+	for i := range routes {
+		routes[i].Prefix = normalizeRoutePrefix(routes[i].Prefix)
+	}
+	sortCarrierRoutes(routes)
+	return routes, nil
+}
+
+// addCarrierRoute stores a new route and reloads the routing table.
+func (gateway *Gateway) addCarrierRoute(route *CarrierRoute) error {
+	route.Prefix = normalizeRoutePrefix(route.Prefix)
+	if route.Prefix == "" {
+		return fmt.Errorf("destination_prefix must contain digits")
+	}
+
+	gateway.mu.RLock()
+	_, exists := gateway.Carriers[route.Carrier]
+	gateway.mu.RUnlock()
+	if !exists {
+		return fmt.Errorf("carrier %s does not exist", route.Carrier)
+	}
+	if err := gateway.normalizeFallbackCarriers(route); err != nil {
+		return err
+	}
+
+	if err := gateway.DB.Create(route).Error; err != nil {
+		return err
+	}
+	return gateway.loadCarrierRoutes()
+}
+
+// selectCarrier picks the outbound carrier for a message from -> to: the
+// best destination route whose carrier is loaded and not tripped by its
+// circuit breaker, otherwise the sender number's own carrier.
+func (router *Router) selectCarrier(from, to string) string {
+	carrier, _ := router.resolveCarrier(from, to)
+	return carrier
+}
+
+// resolveCarrier is selectCarrier that also returns the destination route
+// used, or nil when the sender number's carrier was chosen.
+func (router *Router) resolveCarrier(from, to string) (string, *CarrierRoute) {
+	router.gateway.mu.RLock()
+	routes := router.gateway.CarrierRoutes
+	router.gateway.mu.RUnlock()
+
+	if route := findCarrierRoute(routes, to, func(name string) bool {
+		return router.findRouteByName("carrier", name) != nil && router.gateway.carrierAvailable(name)
+	}); route != nil {
+		matched := *route
+		return matched.Carrier, &matched
+	}
+
+	carrier, _ := router.gateway.getClientCarrier(from)
+	return carrier, nil
+}
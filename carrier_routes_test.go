@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sortedRoutes(routes ...CarrierRoute) []CarrierRoute {
+	for i := range routes {
+		routes[i].Enabled = true
+	}
+	sortCarrierRoutes(routes)
+	return routes
+}
+
+func TestMatchCarrierRoute_LongestPrefix(t *testing.T) {
+	routes := sortedRoutes(
+		CarrierRoute{Prefix: "1", Carrier: "telnyx"},
+		CarrierRoute{Prefix: "44", Carrier: "vonage"},
+		CarrierRoute{Prefix: "1250", Carrier: "twilio"},
+	)
+
+	carrier, ok := matchCarrierRoute(routes, "+12505551234", nil)
+	assert.True(t, ok)
+	assert.Equal(t, "twilio", carrier, "1250 beats 1")
+
+	carrier, _ = matchCarrierRoute(routes, "+14155559876", nil)
+	assert.Equal(t, "telnyx", carrier)
+
+	carrier, _ = matchCarrierRoute(routes, "447700900123", nil)
+	assert.Equal(t, "vonage", carrier)
+
+	_, ok = matchCarrierRoute(routes, "+33612345678", nil)
+	assert.False(t, ok, "no route for +33")
+}
+
+func TestMatchCarrierRoute_PriorityTieBreak(t *testing.T) {
+	routes := sortedRoutes(
+		CarrierRoute{Prefix: "1", Carrier: "telnyx", Priority: 10},
+		CarrierRoute{Prefix: "1", Carrier: "twilio", Priority: 0},
+	)
+
+	carrier, _ := matchCarrierRoute(routes, "+14155559876", nil)
+	assert.Equal(t, "twilio", carrier, "lower priority wins on equal prefix")
+
+	// An unavailable carrier is skipped in favour of the next candidate.
+	carrier, ok := matchCarrierRoute(routes, "+14155559876", func(name string) bool { return name != "twilio" })
+	assert.True(t, ok)
+	assert.Equal(t, "telnyx", carrier)
+}
+
+func TestMatchCarrierRoute_SkipsDisabled(t *testing.T) {
+	routes := sortedRoutes(
+		CarrierRoute{Prefix: "1", Carrier: "telnyx"},
+		CarrierRoute{Prefix: "1415", Carrier: "twilio"},
+	)
+	routes[0].Enabled = false
+
+	carrier, _ := matchCarrierRoute(routes, "+14155559876", nil)
+	assert.Equal(t, "telnyx", carrier)
+}
+
+func TestRouter_SelectCarrierFallsBackToSender(t *testing.T) {
+	r, gw := newTestRouter(1)
+	gw.Clients = map[string]*Client{
+		"pbx1": {Username: "pbx1", Numbers: []ClientNumber{{Number: "12505551234", Carrier: "telnyx"}}},
+	}
+	gw.CarrierRoutes = sortedRoutes(CarrierRoute{Prefix: "44", Carrier: "vonage"})
+	r.AddRoute("carrier", "vonage", nil)
+
+	assert.Equal(t, "vonage", r.selectCarrier("12505551234", "+447700900123"))
+	assert.Equal(t, "telnyx", r.selectCarrier("12505551234", "+14155559876"))
+}
+
+func TestRouter_SyncCarrierRoutes(t *testing.T) {
+	r, gw := newTestRouter(1)
+	r.AddRoute("carrier", "old", nil)
+	assert.Nil(t, r.findRouteByName("carrier", "acme"))
+
+	// A carrier added after startup, named differently from its type
+	handler := NewTelnyxHandler(gw, &Carrier{Name: "acme", Type: "telnyx"}, "", "")
+	gw.Carriers = map[string]CarrierHandler{"acme": handler}
+	r.syncCarrierRoutes()
+
+	route := r.findRouteByName("carrier", "acme")
+	if assert.NotNil(t, route) {
+		assert.Same(t, handler, route.Handler)
+	}
+	assert.Nil(t, r.findRouteByName("carrier", "old"), "routes for removed carriers are dropped")
+
+	delete(gw.Carriers, "acme")
+	r.syncCarrierRoutes()
+	assert.Nil(t, r.findRouteByName("carrier", "acme"))
+}
+
+func TestRouter_SetCarrierRoutesUnderGatewayLock(t *testing.T) {
+	r, gw := newTestRouter(1)
+	r.AddRoute("client", "pbx1", nil)
+	r.AddRoute("carrier", "old", nil)
+
+	// reload swaps the carrier routes while it holds the gateway lock
+	handler := NewTelnyxHandler(gw, &Carrier{Name: "acme", Type: "telnyx"}, "", "")
+	gw.mu.Lock()
+	r.setCarrierRoutes(map[string]CarrierHandler{"acme": handler})
+	gw.mu.Unlock()
+
+	assert.NotNil(t, r.findRouteByName("carrier", "acme"))
+	assert.Nil(t, r.findRouteByName("carrier", "old"))
+	assert.NotNil(t, r.findRouteByName("client", "pbx1"), "non-carrier routes are kept")
+}
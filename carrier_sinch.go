@@ -0,0 +1,507 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/kataras/iris/v12"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const sinchDefaultRegion = "us"
+
+// SinchHandler implements CarrierHandler for the Sinch SMS (XMS) REST API.
+// The carrier's username is the service plan ID, its password the API token
+// and its profile_id the API region (us, eu, au, br or ca; default us).
+type SinchHandler struct {
+	BaseCarrierHandler
+	gateway       *Gateway
+	carrier       *Carrier
+	servicePlanID string
+	token         string
+	apiBase       string // e.g. https://us.sms.api.sinch.com
+}
+
+// NewSinchHandler initializes a new SinchHandler
+func NewSinchHandler(gateway *Gateway, carrier *Carrier, decryptedUsername string, decryptedPassword string) *SinchHandler {
+	region := sinchDefaultRegion
+	if carrier != nil && carrier.ProfileID != "" {
+		region = strings.ToLower(carrier.ProfileID)
+	}
+	return &SinchHandler{
+		BaseCarrierHandler: BaseCarrierHandler{name: "sinch"},
+		gateway:            gateway,
+		carrier:            carrier,
+		servicePlanID:      decryptedUsername,
+		token:              decryptedPassword,
+		apiBase:            "https://" + region + ".sms.api.sinch.com",
+	}
+}
+
+// SinchBatch is the outbound batches request body. Body is the text for an
+// mt_text batch and a SinchMTMedia for an mt_media (MMS) batch.
+type SinchBatch struct {
+	From            string      `json:"from"`
+	To              []string    `json:"to"`
+	Type            string      `json:"type"`
+	Body            interface{} `json:"body"`
+	DeliveryReport  string      `json:"delivery_report,omitempty"`
+	CallbackURL     string      `json:"callback_url,omitempty"`
+	ClientReference string      `json:"client_reference,omitempty"`
+	UDH             string      `json:"udh,omitempty"` // mt_binary only, hex
+}
+
+// SinchMTMedia is the body of an mt_media batch: one media URL and its text.
+type SinchMTMedia struct {
+	URL     string `json:"url"`
+	Message string `json:"message,omitempty"`
+}
+
+// SinchBatchResponse represents the response from the batches endpoint
+type SinchBatchResponse struct {
+	ID string `json:"id"`
+}
+
+// SinchWebhookPayload covers inbound messages (mo_text, mo_media) and
+// per-recipient delivery reports; BatchID and Status are only set on the
+// latter.
+type SinchWebhookPayload struct {
+	Type       string          `json:"type"`
+	ID         string          `json:"id"`
+	From       string          `json:"from"`
+	To         string          `json:"to"`
+	Body       json.RawMessage `json:"body"`
+	ReceivedAt string          `json:"received_at"`
+	BatchID    string          `json:"batch_id"`
+	Status     string          `json:"status"`
+	Code       int             `json:"code"`
+	Recipient  string          `json:"recipient"`
+}
+
+// SinchMOMedia is the body of an inbound mo_media message.
+type SinchMOMedia struct {
+	Subject string       `json:"subject"`
+	Message string       `json:"message"`
+	Media   []SinchMedia `json:"media"`
+}
+
+// SinchMedia is one attachment of an inbound MMS.
+type SinchMedia struct {
+	URL         string `json:"url"`
+	ContentType string `json:"contentType"`
+}
+
+// isDeliveryReport reports whether the webhook is a delivery report rather
+// than an inbound message.
+func (p *SinchWebhookPayload) isDeliveryReport() bool {
+	return strings.Contains(p.Type, "delivery_report")
+}
+
+// content returns the text, subject and attachments of an inbound message.
+// mo_text carries its text as a JSON string, mo_media as an object.
+func (p *SinchWebhookPayload) content() (text, subject string, media []SinchMedia, err error) {
+	if len(p.Body) == 0 {
+		return "", "", nil, nil
+	}
+	if p.Type != "mo_media" {
+		err = json.Unmarshal(p.Body, &text)
+		return text, "", nil, err
+	}
+	var body SinchMOMedia
+	if err := json.Unmarshal(p.Body, &body); err != nil {
+		return "", "", nil, err
+	}
+	return body.Message, body.Subject, body.Media, nil
+}
+
+// sinchCarrierStatus maps a Sinch delivery report status to a carrier
+// status; ok is false for interim statuses such as Queued.
+func sinchCarrierStatus(status string) (string, bool) {
+	switch strings.ToLower(status) {
+	case "dispatched":
+		return CarrierStatusSent, true
+	case "delivered":
+		return CarrierStatusDelivered, true
+	case "aborted", "cancelled", "rejected", "deleted", "failed", "expired":
+		return CarrierStatusFailed, true
+	}
+	return "", false
+}
+
+// Inbound handles incoming Sinch message and delivery report webhooks.
+func (h *SinchHandler) Inbound(c iris.Context) error {
+	var lm = h.gateway.LogManager
+
+	var payload SinchWebhookPayload
+	if err := c.ReadJSON(&payload); err != nil {
+		lm.SendLog(lm.BuildLog(
+			"Carrier.Inbound.Sinch",
+			"GenericError",
+			logrus.ErrorLevel,
+			nil,
+			err,
+		))
+		c.StatusCode(http.StatusBadRequest)
+		return nil
+	}
+
+	if payload.isDeliveryReport() {
+		if status, ok := sinchCarrierStatus(payload.Status); ok {
+			detail := ""
+			if status == CarrierStatusFailed {
+				detail = fmt.Sprintf("%s (%d)", payload.Status, payload.Code)
+			}
+			h.gateway.handleCarrierStatus(h.carrier.Name, payload.BatchID, status, detail)
+		}
+		c.StatusCode(http.StatusOK)
+		return nil
+	}
+
+	body, subject, media, err := payload.content()
+	if err != nil || payload.To == "" || payload.From == "" {
+		lm.SendLog(lm.BuildLog(
+			"Carrier.Inbound.Sinch",
+			"CarrierNoDestinations",
+			logrus.ErrorLevel,
+			map[string]interface{}{
+				"carrierID": payload.ID,
+				"type":      payload.Type,
+			}, err,
+		))
+		c.StatusCode(http.StatusBadRequest)
+		return nil
+	}
+
+	from := payload.From
+	to := payload.To
+	messageID := payload.ID
+
+	if h.gateway.isDuplicateInbound(h.carrier.Name, messageID) {
+		lm.SendLog(lm.BuildLog(
+			"Carrier.Inbound.Sinch",
+			"DuplicateInbound",
+			logrus.InfoLevel,
+			map[string]interface{}{
+				"carrierID": messageID,
+				"from":      from,
+				"to":        to,
+			},
+		))
+		c.StatusCode(http.StatusOK)
+		return nil
+	}
+
+	var files []MsgFile
+	if len(media) > 0 {
+		files = h.fetchSinchMediaFiles(media, messageID)
+		if len(files) <= 0 {
+			h.gateway.forgetInbound(h.carrier.Name, messageID)
+			c.StatusCode(http.StatusBadRequest)
+			return nil
+		}
+	}
+
+	logID := primitive.NewObjectID().Hex()
+	traceID := newTraceID()
+	received := time.Now()
+
+	if len(files) > 0 {
+		var originalSizeBytes int
+		for _, f := range files {
+			originalSizeBytes += len(f.Content)
+		}
+
+		msg := MsgQueueItem{
+			To:                to,
+			From:              from,
+			ReceivedTimestamp: received,
+			QueuedTimestamp:   received,
+			Type:              MsgQueueItemType.MMS,
+			files:             files,
+			Subject:           subject,
+			LogID:             logID,
+			TraceID:           traceID,
+			SourceCarrier:     h.carrier.Name,
+			OriginalSizeBytes: originalSizeBytes,
+		}
+		msg.Priority = h.gateway.msgPriorityFor(msg.To, msg.Type)
+		h.gateway.Router.carrierQueue(msg.Priority) <- msg
+		h.gateway.recordMessage("inbound", msg.Type, h.Name(), MetricResultReceived)
+	}
+
+	if strings.TrimSpace(body) != "" {
+		sms := MsgQueueItem{
+			To:                to,
+			From:              from,
+			ReceivedTimestamp: received,
+			QueuedTimestamp:   received,
+			Type:              MsgQueueItemType.SMS,
+			message:           body,
+			LogID:             logID,
+			TraceID:           traceID,
+			SourceCarrier:     h.carrier.Name,
+		}
+		sms.Priority = h.gateway.msgPriorityFor(sms.To, sms.Type)
+		h.gateway.Router.carrierQueue(sms.Priority) <- sms
+		h.gateway.recordMessage("inbound", sms.Type, h.Name(), MetricResultReceived)
+	}
+
+	lm.SendLog(lm.BuildLog(
+		"Carrier.Sinch.Inbound",
+		"received",
+		logrus.InfoLevel,
+		map[string]interface{}{
+			"logID":     logID,
+			"traceID":   traceID,
+			"carrierID": messageID,
+			"from":      from,
+			"to":        to,
+		}, nil,
+	))
+
+	c.StatusCode(http.StatusOK)
+	return nil
+}
+
+// fetchSinchMediaFiles downloads the attachments of an inbound MMS.
+func (h *SinchHandler) fetchSinchMediaFiles(media []SinchMedia, messageID string) []MsgFile {
+	lm := h.gateway.LogManager
+	var files []MsgFile
+
+	for _, m := range media {
+		content, contentType, err := h.fetchMediaContent(m.URL)
+		if err != nil {
+			lm.SendLog(lm.BuildLog(
+				"Carrier.FetchMedia.Sinch",
+				"CarrierFetchMediaError",
+				logrus.ErrorLevel,
+				map[string]interface{}{
+					"logID":    messageID,
+					"mediaURL": m.URL,
+				}, err,
+			))
+			continue
+		}
+		if m.ContentType != "" {
+			contentType = m.ContentType
+		}
+
+		filename := path.Base(m.URL)
+		if path.Ext(filename) == "" {
+			filename += getExtensionForContentType(contentType)
+		}
+
+		files = append(files, MsgFile{
+			Filename:    filename,
+			ContentType: contentType,
+			Content:     content,
+		})
+	}
+	return files
+}
+
+// fetchMediaContent retrieves inbound media. Sinch media URLs are
+// pre-signed, so the API token is not sent with the request.
+func (h *SinchHandler) fetchMediaContent(mediaURL string) ([]byte, string, error) {
+	content, contentType, err := h.gateway.fetchMedia(h.carrier, mediaURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	contentType = strings.TrimSpace(strings.Split(contentType, ";")[0])
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = mimetype.Detect(content).String()
+	}
+	return content, contentType, nil
+}
+
+// SupportsAlphaSender reports that Sinch accepts alphanumeric sender IDs.
+func (h *SinchHandler) SupportsAlphaSender() bool {
+	return true
+}
+
+// SendSMS sends an SMS message via the Sinch batches API.
+func (h *SinchHandler) SendSMS(sms *MsgQueueItem) (string, error) {
+	return h.send(h.newBatch(sms, "mt_text", sms.message), sms, "Carrier.SendSMS.Sinch")
+}
+
+// SendBinarySMS sends an 8-bit payload, such as a WAP push, as an mt_binary
+// batch with its UDH.
+func (h *SinchHandler) SendBinarySMS(sms *MsgQueueItem) (string, error) {
+	batch := h.newBatch(sms, "mt_binary", base64.StdEncoding.EncodeToString(sms.binary))
+	var udh bytes.Buffer
+	if _, err := sms.UDH.WriteTo(&udh); err != nil {
+		return "", err
+	}
+	batch.UDH = hex.EncodeToString(udh.Bytes())
+	return h.send(batch, sms, "Carrier.SendSMS.Sinch")
+}
+
+// SendMMS sends an MMS via Sinch mt_media batches. A batch takes one media
+// URL, so each attachment is sent separately; the ID of the first batch is
+// returned. Once a batch is sent the MMS counts as sent, since a retry would
+// send that batch again; a later attachment that fails is logged and
+// dropped. An MMS with text and no media goes as an mt_text batch.
+func (h *SinchHandler) SendMMS(mms *MsgQueueItem) (string, error) {
+	var lm = h.gateway.LogManager
+
+	var firstID string
+	text := mms.message
+	for i, f := range mms.files {
+		if strings.Contains(f.ContentType, "application/smil") {
+			continue
+		}
+
+		id, err := h.sendMedia(mms, f, text)
+		if err != nil && firstID != "" {
+			lm.SendLog(lm.BuildLog(
+				"Carrier.SendMMS.Sinch",
+				"PartialSend",
+				logrus.ErrorLevel,
+				map[string]interface{}{
+					"logID":   mms.LogID,
+					"traceID": mms.TraceID,
+					"file":    i + 1,
+					"files":   len(mms.files),
+				}, err,
+			))
+			return firstID, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		text = "" // only the first attachment carries the text
+		if firstID == "" {
+			firstID = id
+		}
+	}
+
+	if firstID != "" {
+		return firstID, nil
+	}
+	if strings.TrimSpace(mms.message) != "" {
+		return h.send(h.newBatch(mms, "mt_text", mms.message), mms, "Carrier.SendMMS.Sinch")
+	}
+	return "", errors.New("no media to send via Sinch")
+}
+
+// sendMedia stores f for the carrier to fetch and sends it, with text, as
+// one mt_media batch.
+func (h *SinchHandler) sendMedia(mms *MsgQueueItem, f MsgFile, text string) (string, error) {
+	var lm = h.gateway.LogManager
+
+	accessToken, err := h.gateway.saveMsgFileMedia(f)
+	if err != nil {
+		lm.SendLog(lm.BuildLog(
+			"Carrier.SendMMS.Sinch",
+			"SaveMediaError",
+			logrus.ErrorLevel,
+			map[string]interface{}{
+				"logID":   mms.LogID,
+				"traceID": mms.TraceID,
+			}, err,
+		))
+		return "", err
+	}
+
+	batch := h.newBatch(mms, "mt_media", SinchMTMedia{
+		URL:     getMediaUrlWithExtension(h.gateway.Config.ServerAddress, accessToken, f.ContentType),
+		Message: text,
+	})
+	return h.send(batch, mms, "Carrier.SendMMS.Sinch")
+}
+
+// newBatch builds a single-recipient batch for item. Delivery reports are
+// requested per recipient on this carrier's inbound webhook when
+// SERVER_ADDRESS is set.
+func (h *SinchHandler) newBatch(item *MsgQueueItem, batchType string, body interface{}) SinchBatch {
+	batch := SinchBatch{
+		From:            strings.TrimPrefix(item.From, "+"),
+		To:              []string{strings.TrimPrefix(item.To, "+")},
+		Type:            batchType,
+		Body:            body,
+		ClientReference: item.LogID,
+	}
+	if base := h.gateway.Config.ServerAddress; base != "" && h.carrier != nil {
+		batch.DeliveryReport = "per_recipient"
+		batch.CallbackURL = base + "/inbound/" + h.carrier.UUID
+	}
+	return batch
+}
+
+// send POSTs one batch and returns its ID.
+func (h *SinchHandler) send(batch SinchBatch, item *MsgQueueItem, logModule string) (string, error) {
+	var lm = h.gateway.LogManager
+
+	payloadBytes, err := json.Marshal(batch)
+	if err != nil {
+		return "", err
+	}
+
+	batchesURL := h.apiBase + "/xms/v1/" + url.PathEscape(h.servicePlanID) + "/batches"
+	req, err := http.NewRequest("POST", batchesURL, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+h.token)
+	req.Header.Set(traceIDHeader, item.TraceID)
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		lm.SendLog(lm.BuildLog(
+			logModule,
+			"Failed to send request: %v",
+			logrus.ErrorLevel,
+			map[string]interface{}{
+				"logID":   item.LogID,
+				"traceID": item.TraceID,
+			}, err,
+		))
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		lm.SendLog(lm.BuildLog(
+			logModule,
+			"Failed to send message to Carrier",
+			logrus.ErrorLevel,
+			map[string]interface{}{
+				"logID":           item.LogID,
+				"traceID":         item.TraceID,
+				"response_code":   resp.StatusCode,
+				"response_status": resp.Status,
+				"to":              item.To,
+				"from":            item.From,
+				"response_body":   string(bodyBytes),
+			},
+		))
+		return "", carrierRejected(resp.StatusCode, fmt.Errorf("failed to send %s via Sinch", batch.Type))
+	}
+
+	var sinchResp SinchBatchResponse
+	if err := json.Unmarshal(bodyBytes, &sinchResp); err != nil {
+		return "", err
+	}
+	return sinchResp.ID, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kataras/iris/v12"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSinchWebhookPayload_Content(t *testing.T) {
+	var text SinchWebhookPayload
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"type": "mo_text",
+		"id": "01FC66621XXXXX119Z8PMV1QPQ",
+		"from": "15559870000",
+		"to": "15551230000",
+		"body": "hi there"
+	}`), &text))
+	body, subject, media, err := text.content()
+	require.NoError(t, err)
+	assert.Equal(t, "hi there", body)
+	assert.Empty(t, subject)
+	assert.Empty(t, media)
+	assert.False(t, text.isDeliveryReport())
+
+	var mms SinchWebhookPayload
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"type": "mo_media",
+		"id": "01FC66621XXXXX119Z8PMV1QPR",
+		"from": "15559870000",
+		"to": "15551230000",
+		"body": {
+			"subject": "pics",
+			"message": "look",
+			"media": [{"url": "https://media.sinch.com/abc.jpg", "contentType": "image/jpeg"}]
+		}
+	}`), &mms))
+	body, subject, media, err = mms.content()
+	require.NoError(t, err)
+	assert.Equal(t, "look", body)
+	assert.Equal(t, "pics", subject)
+	require.Len(t, media, 1)
+	assert.Equal(t, "https://media.sinch.com/abc.jpg", media[0].URL)
+	assert.Equal(t, "image/jpeg", media[0].ContentType)
+
+	var report SinchWebhookPayload
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"type": "recipient_delivery_report_sms",
+		"batch_id": "01FC66621XXXXX119Z8PMV1QPS",
+		"recipient": "15559870000",
+		"status": "Delivered",
+		"code": 0
+	}`), &report))
+	assert.True(t, report.isDeliveryReport())
+}
+
+func TestSinchCarrierStatus(t *testing.T) {
+	for status, want := range map[string]string{
+		"Dispatched": CarrierStatusSent,
+		"Delivered":  CarrierStatusDelivered,
+		"Failed":     CarrierStatusFailed,
+		"Rejected":   CarrierStatusFailed,
+		"Expired":    CarrierStatusFailed,
+	} {
+		got, ok := sinchCarrierStatus(status)
+		assert.True(t, ok, status)
+		assert.Equal(t, want, got, status)
+	}
+	_, ok := sinchCarrierStatus("Queued")
+	assert.False(t, ok)
+}
+
+func TestSinchHandler_SendSMS(t *testing.T) {
+	var (
+		gotPath  string
+		gotAuth  string
+		gotTrace string
+		gotBatch map[string]interface{}
+	)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+		gotTrace = r.Header.Get(traceIDHeader)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&gotBatch))
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"id":"01FC66621XXXXX119Z8PMV1QPQ"}`))
+	}))
+	defer api.Close()
+
+	_, gw := newTestRouter(1)
+	gw.Config.ServerAddress = "https://gw.example.com"
+	h := NewSinchHandler(gw, &Carrier{Name: "sinch", UUID: "carrier-uuid"}, "plan-1", "token-1")
+	h.apiBase = api.URL
+
+	id, err := h.SendSMS(&MsgQueueItem{LogID: "log-1", TraceID: "trace-1", From: "+15551230000", To: "+15559870000", message: "hello"})
+	require.NoError(t, err)
+	assert.Equal(t, "01FC66621XXXXX119Z8PMV1QPQ", id)
+
+	assert.Equal(t, "/xms/v1/plan-1/batches", gotPath)
+	assert.Equal(t, "Bearer token-1", gotAuth)
+	assert.Equal(t, "trace-1", gotTrace)
+	assert.Equal(t, "mt_text", gotBatch["type"])
+	assert.Equal(t, "15551230000", gotBatch["from"])
+	assert.Equal(t, []interface{}{"15559870000"}, gotBatch["to"])
+	assert.Equal(t, "hello", gotBatch["body"])
+	assert.Equal(t, "log-1", gotBatch["client_reference"])
+	assert.Equal(t, "per_recipient", gotBatch["delivery_report"])
+	assert.Equal(t, "https://gw.example.com/inbound/carrier-uuid", gotBatch["callback_url"])
+}
+
+func TestNewSinchHandler_Region(t *testing.T) {
+	assert.Equal(t, "https://us.sms.api.sinch.com", NewSinchHandler(nil, &Carrier{}, "p", "t").apiBase)
+	assert.Equal(t, "https://eu.sms.api.sinch.com", NewSinchHandler(nil, &Carrier{ProfileID: "EU"}, "p", "t").apiBase)
+}
+
+func TestSinchHandler_SendMMSTextOnly(t *testing.T) {
+	var gotBatch map[string]interface{}
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&gotBatch))
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"id":"batch-1"}`))
+	}))
+	defer api.Close()
+
+	_, gw := newTestRouter(1)
+	h := NewSinchHandler(gw, &Carrier{Name: "sinch"}, "plan-1", "token-1")
+	h.apiBase = api.URL
+
+	id, err := h.SendMMS(&MsgQueueItem{LogID: "log-1", From: "+15551230000", To: "+15559870000", Type: MsgQueueItemType.MMS, message: "no pictures"})
+	require.NoError(t, err)
+	assert.Equal(t, "batch-1", id)
+	assert.Equal(t, "mt_text", gotBatch["type"])
+	assert.Equal(t, "no pictures", gotBatch["body"])
+
+	_, err = h.SendMMS(&MsgQueueItem{LogID: "log-2", From: "+15551230000", To: "+15559870000", Type: MsgQueueItemType.MMS})
+	assert.Error(t, err)
+}
+
+func TestSinchHandler_InboundDuplicate(t *testing.T) {
+	r, gw := newTestRouter(2)
+	gw.Config.InboundDedupeWindowSecs = 300
+	h := NewSinchHandler(gw, &Carrier{Name: "sinch"}, "plan-1", "token-1")
+	payload := `{"type":"mo_text","id":"01FC66621XXXXX119Z8PMV1QPQ","from":"15559870000","to":"15551230000","body":"hi"}`
+
+	for i := 0; i < 2; i++ {
+		app := iris.New()
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/inbound/uuid", strings.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		ctx := app.ContextPool.Acquire(w, req)
+		require.NoError(t, h.Inbound(ctx))
+		assert.Equal(t, http.StatusOK, ctx.GetStatusCode())
+	}
+	assert.Len(t, r.CarrierMsgChan, 1, "the retry is not delivered again")
+}
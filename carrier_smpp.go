@@ -0,0 +1,631 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"zultys-smpp-mm4/smpp"
+	"zultys-smpp-mm4/smpp/pdu"
+
+	"github.com/kataras/iris/v12"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Rebind backoff and bind reply wait for upstream SMSC connections.
+const (
+	smppCarrierMinBackoff  = time.Second
+	smppCarrierMaxBackoff  = time.Minute
+	smppCarrierBindTimeout = 10 * time.Second
+)
+
+var (
+	errSMPPCarrierNotBound = errors.New("not bound to the SMSC")
+	errSMPPCarrierClosed   = errors.New("carrier closed")
+	errSMPPCarrierUnbound  = errors.New("SMSC sent unbind")
+)
+
+// smppMessageStates maps a receipt's message_state TLV to its stat value.
+var smppMessageStates = map[byte]string{
+	1: "ENROUTE",
+	2: DLRStatDelivered,
+	3: DLRStatExpired,
+	4: "DELETED",
+	5: DLRStatUndeliverable,
+	6: "ACCEPTD",
+	7: "UNKNOWN",
+	8: DLRStatRejected,
+}
+
+// SMPPCarrierHandler implements CarrierHandler for an upstream SMSC reached
+// over SMPP. The carrier's username is the system_id, its password the bind
+// password and its profile_id the SMSC address as host:port, prefixed with
+// tls:// for SMPP over TLS. The handler stays bound as a transceiver and
+// rebinds with backoff when the connection drops. Mobile-originated
+// deliver_sm are routed like a carrier webhook; delivery receipts update the
+// messages sent through it.
+type SMPPCarrierHandler struct {
+	BaseCarrierHandler
+	gateway  *Gateway
+	carrier  *Carrier
+	systemID string
+	password string
+	dial     func(ctx context.Context) (net.Conn, error)
+	concat   *concatBuffer
+	ref      atomic.Uint32 // concatenation reference for outbound segments
+
+	mu        sync.RWMutex
+	session   *smpp.Session // nil while not bound
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewSMPPCarrierHandler initializes a new SMPPCarrierHandler and starts
+// binding to the SMSC in the background.
+func NewSMPPCarrierHandler(gateway *Gateway, carrier *Carrier, decryptedUsername string, decryptedPassword string) *SMPPCarrierHandler {
+	h := newSMPPCarrierHandler(gateway, carrier, decryptedUsername, decryptedPassword)
+	go h.run()
+	return h
+}
+
+// newSMPPCarrierHandler builds the handler without connecting.
+func newSMPPCarrierHandler(gateway *Gateway, carrier *Carrier, decryptedUsername string, decryptedPassword string) *SMPPCarrierHandler {
+	address := ""
+	if carrier != nil {
+		address = carrier.ProfileID
+	}
+	h := &SMPPCarrierHandler{
+		BaseCarrierHandler: BaseCarrierHandler{name: "smpp"},
+		gateway:            gateway,
+		carrier:            carrier,
+		systemID:           decryptedUsername,
+		password:           decryptedPassword,
+		dial:               smppCarrierDialer(address),
+		done:               make(chan struct{}),
+	}
+	h.concat = newConcatBuffer(time.Duration(gateway.Config.SMPPConcatTimeoutSecs)*time.Second, h.finishConcatenated)
+	return h
+}
+
+// smppCarrierDialer connects to address, over TLS when it starts with tls://.
+func smppCarrierDialer(address string) func(ctx context.Context) (net.Conn, error) {
+	if host, ok := strings.CutPrefix(address, "tls://"); ok {
+		return func(ctx context.Context) (net.Conn, error) {
+			dialer := &tls.Dialer{}
+			return dialer.DialContext(ctx, "tcp", host)
+		}
+	}
+	return func(ctx context.Context) (net.Conn, error) {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "tcp", address)
+	}
+}
+
+// smppCarrierConn reports the first read error, which ends the session: the
+// smpp package does not surface a dropped connection itself. A zero-length
+// read, which the pdu package makes for PDUs without a body, returns at once
+// as it does on TCP.
+type smppCarrierConn struct {
+	net.Conn
+	lost context.CancelCauseFunc
+}
+
+func (c *smppCarrierConn) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	n, err := c.Conn.Read(b)
+	if err != nil {
+		c.lost(fmt.Errorf("connection lost: %w", err))
+	}
+	return n, err
+}
+
+// Inbound rejects webhooks; an SMPP carrier delivers over its bind.
+func (h *SMPPCarrierHandler) Inbound(c iris.Context) error {
+	c.StatusCode(http.StatusNotFound)
+	return nil
+}
+
+// SupportsAlphaSender reports that submit_sm takes an alphanumeric source.
+func (h *SMPPCarrierHandler) SupportsAlphaSender() bool {
+	return true
+}
+
+// Close unbinds from the SMSC and stops rebinding. It is called when the
+// carrier is reloaded or removed.
+func (h *SMPPCarrierHandler) Close() {
+	h.closeOnce.Do(func() {
+		if session := h.boundSession(); session != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			_, _ = session.Submit(ctx, &pdu.Unbind{})
+			cancel()
+		}
+		close(h.done)
+	})
+}
+
+func (h *SMPPCarrierHandler) boundSession() *smpp.Session {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.session
+}
+
+func (h *SMPPCarrierHandler) setSession(session *smpp.Session) {
+	h.mu.Lock()
+	h.session = session
+	h.mu.Unlock()
+}
+
+// run keeps the handler bound until Close, rebinding after a failed bind or
+// a lost connection with a backoff that doubles up to a minute.
+func (h *SMPPCarrierHandler) run() {
+	lm := h.gateway.LogManager
+	backoff := smppCarrierMinBackoff
+	for {
+		bound, err := h.bindAndServe()
+		if bound {
+			backoff = smppCarrierMinBackoff
+		}
+		select {
+		case <-h.done:
+			return
+		default:
+		}
+
+		lm.SendLog(lm.BuildLog(
+			"Carrier.SMPP",
+			"Disconnected",
+			logrus.WarnLevel,
+			map[string]interface{}{
+				"carrier": h.carrier.Name,
+				"bound":   bound,
+				"retryIn": backoff.String(),
+			}, err,
+		))
+
+		select {
+		case <-h.done:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, smppCarrierMaxBackoff)
+	}
+}
+
+// bindAndServe connects and binds as a transceiver, then handles the
+// session until it is lost or the handler is closed. bound reports whether
+// the bind succeeded; err says why the session ended.
+func (h *SMPPCarrierHandler) bindAndServe() (bound bool, err error) {
+	lm := h.gateway.LogManager
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	go func() {
+		select {
+		case <-h.done:
+			cancel(errSMPPCarrierClosed)
+		case <-ctx.Done():
+		}
+	}()
+
+	dialCtx, dialCancel := context.WithTimeout(ctx, smppCarrierBindTimeout)
+	conn, err := h.dial(dialCtx)
+	dialCancel()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	var seq atomic.Int32
+	session := smpp.NewSession(ctx, &smppCarrierConn{Conn: conn, lost: cancel})
+	session.NextSequence = func() int32 { return seq.Add(1) }
+	defer func() {
+		// The session's reader stops once the context ends, unless it is
+		// blocked handing over a packet nobody will read.
+		go func() {
+			timeout := time.After(time.Second)
+			for {
+				select {
+				case <-session.PDU():
+				case <-timeout:
+					return
+				}
+			}
+		}()
+	}()
+
+	bind := &pdu.BindTransceiver{SystemID: h.systemID, Password: h.password, Version: pdu.SMPPVersion34}
+	bind.Header.Sequence = session.NextSequence()
+	if err := session.Send(bind); err != nil {
+		return false, err
+	}
+	timer := time.NewTimer(smppCarrierBindTimeout)
+	defer timer.Stop()
+	select {
+	case packet := <-session.PDU():
+		resp, ok := packet.(*pdu.BindTransceiverResp)
+		if !ok {
+			return false, fmt.Errorf("unexpected %T in reply to bind_transceiver", packet)
+		}
+		if resp.Header.CommandStatus != pdu.ESME_ROK {
+			return false, fmt.Errorf("bind_transceiver refused: %s", resp.Header.CommandStatus)
+		}
+	case <-timer.C:
+		return false, errors.New("no reply to bind_transceiver")
+	case <-ctx.Done():
+		return false, context.Cause(ctx)
+	}
+
+	h.setSession(session)
+	defer h.setSession(nil)
+	lm.SendLog(lm.BuildLog(
+		"Carrier.SMPP",
+		"Bound",
+		logrus.InfoLevel,
+		map[string]interface{}{
+			"carrier":  h.carrier.Name,
+			"systemID": h.systemID,
+		},
+	))
+
+	go h.enquireLink(ctx, cancel, session)
+	for {
+		select {
+		case <-ctx.Done():
+			return true, context.Cause(ctx)
+		case packet := <-session.PDU():
+			h.handlePDU(session, packet, cancel)
+		}
+	}
+}
+
+// enquireLink checks the bind at the SMPP keepalive interval and ends the
+// session when the SMSC stops answering.
+func (h *SMPPCarrierHandler) enquireLink(ctx context.Context, lost context.CancelCauseFunc, session *smpp.Session) {
+	keepalive := h.gateway.smppKeepalive(nil)
+	ticker := time.NewTicker(keepalive.EnquireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		linkCtx, cancel := context.WithTimeout(ctx, keepalive.EnquireTimeout)
+		_, err := session.Submit(linkCtx, &pdu.EnquireLink{})
+		timedOut := linkCtx.Err() != nil
+		cancel()
+		if err != nil || timedOut {
+			lost(fmt.Errorf("no reply to enquire_link within %s", keepalive.EnquireTimeout))
+			return
+		}
+	}
+}
+
+// handlePDU answers a request from the SMSC.
+func (h *SMPPCarrierHandler) handlePDU(session *smpp.Session, packet any, lost context.CancelCauseFunc) {
+	switch p := packet.(type) {
+	case *pdu.DeliverSM:
+		h.handleDeliverSM(p)
+		_ = session.Send(p.Resp())
+	case *pdu.EnquireLink:
+		_ = session.Send(p.Resp())
+	case *pdu.Unbind:
+		_ = session.Send(p.Resp())
+		lost(errSMPPCarrierUnbound)
+	default:
+		lm := h.gateway.LogManager
+		lm.SendLog(lm.BuildLog(
+			"Carrier.SMPP",
+			"UnhandledPDU",
+			logrus.DebugLevel,
+			map[string]interface{}{
+				"carrier": h.carrier.Name,
+				"type":    fmt.Sprintf("%T", packet),
+			},
+		))
+	}
+}
+
+// handleDeliverSM routes a mobile-originated message, reassembling
+// concatenated segments first, or applies a delivery receipt.
+func (h *SMPPCarrierHandler) handleDeliverSM(deliverSM *pdu.DeliverSM) {
+	if deliverSM.ESMClass.MessageType == 1 || deliverSM.ESMClass.MessageType == 8 {
+		h.handleReceipt(deliverSM)
+		return
+	}
+
+	// concatBuffer works on submit_sm; the fields it reads are the same.
+	raw := deliverSM.Message.Message
+	if payload, ok := deliverSM.Tags[tlvMessagePayload]; ok && len(raw) == 0 {
+		raw = payload
+	}
+	submitSM := &pdu.SubmitSM{
+		SourceAddr: deliverSM.SourceAddr,
+		DestAddr:   deliverSM.DestAddr,
+		ESMClass:   deliverSM.ESMClass,
+		Message:    pdu.ShortMessage{DataCoding: deliverSM.Message.DataCoding, UDHeader: deliverSM.Message.UDHeader, Message: raw},
+	}
+	logID := primitive.NewObjectID().Hex()
+	traceID := newTraceID()
+
+	if header := concatHeader(submitSM); header != nil {
+		key := concatKey{
+			username: h.carrier.Name,
+			source:   submitSM.SourceAddr.String(),
+			dest:     submitSM.DestAddr.String(),
+			ref:      header.Reference,
+		}
+		h.concat.add(key, header, submitSM, nil, nil, logID, traceID)
+		return
+	}
+
+	text, _, err := decodeSubmitSMText(submitSM.Message.DataCoding, raw)
+	if err != nil {
+		lm := h.gateway.LogManager
+		lm.SendLog(lm.BuildLog(
+			"Carrier.Inbound.SMPP",
+			"DecodeError",
+			logrus.WarnLevel,
+			map[string]interface{}{
+				"carrier":    h.carrier.Name,
+				"logID":      logID,
+				"traceID":    traceID,
+				"dataCoding": byte(submitSM.Message.DataCoding),
+			}, err,
+		))
+	}
+	h.queueInbound(submitSM.SourceAddr.No, submitSM.DestAddr.No, text, logID, traceID, time.Now())
+}
+
+// finishConcatenated routes a reassembled message.
+func (h *SMPPCarrierHandler) finishConcatenated(entry *concatEntry) {
+	text, err := entry.text()
+	if err != nil || entry.timedOut {
+		lm := h.gateway.LogManager
+		lm.SendLog(lm.BuildLog(
+			"Carrier.Inbound.SMPP",
+			"IncompleteMessage",
+			logrus.WarnLevel,
+			map[string]interface{}{
+				"carrier":  h.carrier.Name,
+				"logID":    entry.transId,
+				"traceID":  entry.traceID,
+				"received": entry.received,
+				"parts":    len(entry.parts),
+			}, err,
+		))
+	}
+	h.queueInbound(entry.first.SourceAddr.No, entry.first.DestAddr.No, text, entry.transId, entry.traceID, entry.started)
+}
+
+// queueInbound hands a mobile-originated SMS to the router.
+func (h *SMPPCarrierHandler) queueInbound(from, to, text, logID, traceID string, received time.Time) {
+	lm := h.gateway.LogManager
+	if strings.TrimSpace(text) == "" || from == "" || to == "" {
+		lm.SendLog(lm.BuildLog(
+			"Carrier.Inbound.SMPP",
+			"CarrierNoDestinations",
+			logrus.ErrorLevel,
+			map[string]interface{}{
+				"carrier": h.carrier.Name,
+				"logID":   logID,
+				"traceID": traceID,
+				"from":    from,
+				"to":      to,
+			},
+		))
+		return
+	}
+
+	sms := MsgQueueItem{
+		To:                to,
+		From:              from,
+		ReceivedTimestamp: received,
+		QueuedTimestamp:   time.Now(),
+		Type:              MsgQueueItemType.SMS,
+		message:           text,
+		LogID:             logID,
+		TraceID:           traceID,
+		SourceCarrier:     h.carrier.Name,
+	}
+	sms.Priority = h.gateway.msgPriorityFor(sms.To, sms.Type)
+	h.gateway.Router.carrierQueue(sms.Priority) <- sms
+	h.gateway.recordMessage("inbound", sms.Type, h.Name(), MetricResultReceived)
+
+	lm.SendLog(lm.BuildLog(
+		"Carrier.SMPP.Inbound",
+		"received",
+		logrus.InfoLevel,
+		map[string]interface{}{
+			"logID":   logID,
+			"traceID": traceID,
+			"carrier": h.carrier.Name,
+			"from":    from,
+			"to":      to,
+		},
+	))
+}
+
+// handleReceipt applies a delivery receipt to the message it reports on.
+func (h *SMPPCarrierHandler) handleReceipt(deliverSM *pdu.DeliverSM) {
+	id, stat, errCode := smppReceiptFields(deliverSM)
+	status, ok := smppCarrierStatus(stat)
+	if id == "" || !ok {
+		lm := h.gateway.LogManager
+		lm.SendLog(lm.BuildLog(
+			"Carrier.SMPP",
+			"UnreadableReceipt",
+			logrus.DebugLevel,
+			map[string]interface{}{
+				"carrier":   h.carrier.Name,
+				"carrierID": id,
+				"stat":      stat,
+			},
+		))
+		return
+	}
+	detail := ""
+	if status == CarrierStatusFailed {
+		detail = fmt.Sprintf("%s (err %s)", stat, errCode)
+	}
+	h.gateway.handleCarrierStatus(h.carrier.Name, id, status, detail)
+}
+
+// smppReceiptFields reads the message ID, stat and error code of a delivery
+// receipt. The receipted_message_id and message_state TLVs are preferred
+// over the receipt text, whose format SMSCs only loosely follow.
+func smppReceiptFields(deliverSM *pdu.DeliverSM) (id, stat, errCode string) {
+	text, _, _ := decodeSubmitSMText(deliverSM.Message.DataCoding, deliverSM.Message.Message)
+	for _, field := range strings.Fields(text) {
+		name, value, ok := strings.Cut(field, ":")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(name) {
+		case "id":
+			id = value
+		case "stat":
+			stat = strings.ToUpper(value)
+		case "err":
+			errCode = value
+		}
+	}
+	if tlv, ok := deliverSM.Tags[tlvReceiptedMessageID]; ok && len(strings.TrimRight(string(tlv), "\x00")) > 0 {
+		id = strings.TrimRight(string(tlv), "\x00")
+	}
+	if tlv, ok := deliverSM.Tags[tlvMessageState]; ok && len(tlv) == 1 {
+		if s, ok := smppMessageStates[tlv[0]]; ok {
+			stat = s
+		}
+	}
+	return id, stat, errCode
+}
+
+// smppCarrierStatus maps a receipt stat to a carrier status; ok is false for
+// an unknown stat.
+func smppCarrierStatus(stat string) (string, bool) {
+	switch stat {
+	case "ENROUTE", "ACCEPTD":
+		return CarrierStatusSent, true
+	case DLRStatDelivered:
+		return CarrierStatusDelivered, true
+	case DLRStatUndeliverable, DLRStatRejected, DLRStatExpired, "DELETED", "UNKNOWN":
+		return CarrierStatusFailed, true
+	}
+	return "", false
+}
+
+// SendSMS sends sms as one submit_sm per segment and returns the SMSC's
+// message ID for the first.
+func (h *SMPPCarrierHandler) SendSMS(sms *MsgQueueItem) (string, error) {
+	parts, _, err := composeDeliverSMs(sms.From, sms.To, sms.message, func() uint16 {
+		return uint16(h.ref.Add(1))
+	})
+	if err != nil {
+		return "", err
+	}
+	return h.submit(sms, parts)
+}
+
+// SendBinarySMS sends the binary payload of sms unchanged in one submit_sm.
+func (h *SMPPCarrierHandler) SendBinarySMS(sms *MsgQueueItem) (string, error) {
+	part, err := composeBinaryDeliverSM(sms.From, sms.To, *sms)
+	if err != nil {
+		return "", err
+	}
+	return h.submit(sms, []outboundSegment{part})
+}
+
+// SendMMS fails: SMPP carries SMS only.
+func (h *SMPPCarrierHandler) SendMMS(mms *MsgQueueItem) (string, error) {
+	return "", errors.New("SMPP carriers cannot send MMS")
+}
+
+// smppRejected reports whether a submit_sm_resp status refuses the message
+// itself, rather than reporting a busy or failing SMSC.
+func smppRejected(status pdu.CommandStatus) bool {
+	switch status {
+	case pdu.ESME_RTHROTTLED,
+		0x08, // ESME_RSYSERR
+		0x14: // ESME_RMSGQFUL
+		return false
+	}
+	return true
+}
+
+// submit sends the composed segments in order, each as a submit_sm
+// requesting a delivery receipt, and returns the message ID the SMSC gave
+// the first; its receipts refer to that ID. Once the first segment is
+// accepted the message counts as sent even if a later one fails, since a
+// retry would send the accepted segments again.
+func (h *SMPPCarrierHandler) submit(sms *MsgQueueItem, parts []outboundSegment) (string, error) {
+	lm := h.gateway.LogManager
+	session := h.boundSession()
+	if session == nil {
+		return "", errSMPPCarrierNotBound
+	}
+	timeout := h.gateway.smppKeepalive(nil).ResponseTimeout
+
+	var firstID string
+	for i, part := range parts {
+		applySMPPPassthrough(part.pdu, *sms)
+		submitSM := &pdu.SubmitSM{
+			ServiceType:        part.pdu.ServiceType,
+			SourceAddr:         part.pdu.SourceAddr,
+			DestAddr:           part.pdu.DestAddr,
+			ESMClass:           part.pdu.ESMClass,
+			RegisteredDelivery: part.pdu.RegisteredDelivery,
+			Message:            part.pdu.Message,
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		resp, err := session.Submit(ctx, submitSM)
+		if ctx.Err() != nil {
+			err = fmt.Errorf("no submit_sm_resp within %s", timeout)
+		}
+		cancel()
+		if err == nil {
+			if submitResp, ok := resp.(*pdu.SubmitSMResp); !ok {
+				err = fmt.Errorf("unexpected %T in reply to submit_sm", resp)
+			} else if submitResp.Header.CommandStatus != pdu.ESME_ROK {
+				err = fmt.Errorf("submit_sm refused: %s", submitResp.Header.CommandStatus)
+				if smppRejected(submitResp.Header.CommandStatus) {
+					err = fmt.Errorf("%w: %w", errCarrierRejected, err)
+				}
+			} else if i == 0 {
+				firstID = submitResp.MessageID
+			}
+		}
+		if err != nil {
+			event := "Failed to send SMS to Carrier"
+			if i > 0 {
+				event = "PartialSend"
+			}
+			lm.SendLog(lm.BuildLog(
+				"Carrier.SendSMS.SMPP",
+				event,
+				logrus.ErrorLevel,
+				map[string]interface{}{
+					"logID":   sms.LogID,
+					"traceID": sms.TraceID,
+					"carrier": h.carrier.Name,
+					"segment": i + 1,
+					"parts":   len(parts),
+				}, err,
+			))
+			if i > 0 {
+				return firstID, nil
+			}
+			return "", err
+		}
+	}
+	return firstID, nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"zultys-smpp-mm4/smpp"
+	"zultys-smpp-mm4/smpp/coding"
+	"zultys-smpp-mm4/smpp/pdu"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSMSC answers binds, submit_sm and unbind on the server end of a pipe.
+type fakeSMSC struct {
+	session *smpp.Session
+	submits chan *pdu.SubmitSM
+	accept  atomic.Int32 // submit_sm accepted before refusing the rest; 0 accepts all
+}
+
+func startFakeSMSC(t *testing.T, conn net.Conn) *fakeSMSC {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	conn = &smppCarrierConn{Conn: conn, lost: func(error) {}}
+	s := &fakeSMSC{session: smpp.NewSession(ctx, conn), submits: make(chan *pdu.SubmitSM, 4)}
+	go func() {
+		n := 0
+		for packet := range s.session.PDU() {
+			switch p := packet.(type) {
+			case *pdu.BindTransceiver:
+				_ = s.session.Send(p.Resp(pdu.ESME_ROK))
+			case *pdu.SubmitSM:
+				n++
+				resp := p.Resp().(*pdu.SubmitSMResp)
+				resp.MessageID = fmt.Sprintf("smsc-%d", n)
+				if limit := s.accept.Load(); limit > 0 && int32(n) > limit {
+					resp.Header.CommandStatus = pdu.ESME_RINVDSTADR
+				}
+				// Recorded before the response, so a send that has returned
+				// has had every submit it made recorded.
+				s.submits <- p
+				_ = s.session.Send(resp)
+			case *pdu.EnquireLink:
+				_ = s.session.Send(p.Resp())
+			case *pdu.Unbind:
+				_ = s.session.Send(p.Resp())
+			}
+		}
+	}()
+	return s
+}
+
+// deliver sends deliverSM to the carrier and waits for its response.
+func (s *fakeSMSC) deliver(t *testing.T, deliverSM *pdu.DeliverSM) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	resp, err := s.session.Submit(ctx, deliverSM)
+	require.NoError(t, err)
+	require.IsType(t, &pdu.DeliverSMResp{}, resp)
+}
+
+func newTestSMPPCarrier(t *testing.T) (*SMPPCarrierHandler, *Router, chan *fakeSMSC) {
+	t.Helper()
+	r, gw := newTestRouter(2)
+	gw.MsgRecordChan = make(chan MsgRecord, 4)
+	gw.Config.SMPPConcatTimeoutSecs = 30
+
+	h := newSMPPCarrierHandler(gw, &Carrier{Name: "upstream", Type: "smpp", ProfileID: "smsc.example.com:2775"}, "gw", "secret")
+	smscs := make(chan *fakeSMSC, 2)
+	h.dial = func(ctx context.Context) (net.Conn, error) {
+		serverConn, clientConn := net.Pipe()
+		smscs <- startFakeSMSC(t, serverConn)
+		return clientConn, nil
+	}
+	t.Cleanup(h.Close)
+	go h.run()
+	return h, r, smscs
+}
+
+func waitBound(t *testing.T, h *SMPPCarrierHandler) {
+	t.Helper()
+	require.Eventually(t, func() bool { return h.boundSession() != nil }, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestSMPPCarrier_SendAndReceive(t *testing.T) {
+	h, r, smscs := newTestSMPPCarrier(t)
+	smsc := <-smscs
+	waitBound(t, h)
+
+	ackID, err := h.SendSMS(&MsgQueueItem{LogID: "out-1", From: "+12505551234", To: "+447700900123", Type: MsgQueueItemType.SMS, message: "hello"})
+	require.NoError(t, err)
+	assert.Equal(t, "smsc-1", ackID)
+	submit := <-smsc.submits
+	assert.Equal(t, "+447700900123", submit.DestAddr.No)
+	assert.Equal(t, []byte("hello"), submit.Message.Message)
+	assert.Equal(t, byte(1), submit.RegisteredDelivery.MCDeliveryReceipt, "a receipt is requested")
+
+	smsc.deliver(t, &pdu.DeliverSM{
+		SourceAddr: pdu.Address{TON: 1, NPI: 1, No: "+447700900123"},
+		DestAddr:   pdu.Address{TON: 1, NPI: 1, No: "+12505551234"},
+		Message:    pdu.ShortMessage{Message: []byte("reply"), DataCoding: coding.GSM7BitCoding},
+	})
+	require.Len(t, r.CarrierMsgChan, 1)
+	inbound := <-r.CarrierMsgChan
+	assert.Equal(t, "reply", inbound.message)
+	assert.Equal(t, "upstream", inbound.SourceCarrier)
+	assert.False(t, inbound.ReceivedTimestamp.IsZero())
+
+	smsc.deliver(t, &pdu.DeliverSM{
+		SourceAddr: pdu.Address{TON: 1, NPI: 1, No: "+447700900123"},
+		DestAddr:   pdu.Address{TON: 1, NPI: 1, No: "+12505551234"},
+		ESMClass:   pdu.ESMClass{MessageType: 1},
+		Message: pdu.ShortMessage{
+			Message:    []byte("id:smsc-1 sub:001 dlvrd:001 submit date:2610161200 done date:2610161201 stat:DELIVRD err:000 text:hello"),
+			DataCoding: coding.GSM7BitCoding,
+		},
+	})
+	require.Len(t, h.gateway.MsgRecordChan, 1)
+	record := <-h.gateway.MsgRecordChan
+	assert.Equal(t, "smsc-1", record.CarrierMsgID)
+	assert.Equal(t, MsgStatusDelivered, record.Status)
+	assert.Empty(t, r.CarrierMsgChan, "a receipt is not routed as a message")
+}
+
+func TestSMPPCarrier_Rebinds(t *testing.T) {
+	h, _, smscs := newTestSMPPCarrier(t)
+	smsc := <-smscs
+	waitBound(t, h)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := smsc.session.Submit(ctx, &pdu.Unbind{})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool { return h.boundSession() == nil }, time.Second, 10*time.Millisecond)
+	_, err = h.SendSMS(&MsgQueueItem{From: "+12505551234", To: "+447700900123", message: "hi"})
+	assert.ErrorIs(t, err, errSMPPCarrierNotBound)
+
+	select {
+	case <-smscs:
+	case <-time.After(3 * time.Second):
+		t.Fatal("no rebind")
+	}
+	waitBound(t, h)
+}
+
+func TestSMPPReceiptFields(t *testing.T) {
+	receipt := &pdu.DeliverSM{
+		ESMClass: pdu.ESMClass{MessageType: 1},
+		Message:  pdu.ShortMessage{Message: []byte("id:abc sub:001 dlvrd:000 submit date:2610161200 done date:2610161201 stat:UNDELIV err:034 text:")},
+	}
+	id, stat, errCode := smppReceiptFields(receipt)
+	assert.Equal(t, "abc", id)
+	assert.Equal(t, DLRStatUndeliverable, stat)
+	assert.Equal(t, "034", errCode)
+	status, ok := smppCarrierStatus(stat)
+	assert.True(t, ok)
+	assert.Equal(t, CarrierStatusFailed, status)
+
+	receipt.Tags = pdu.Tags{tlvReceiptedMessageID: []byte("ABC-1\x00"), tlvMessageState: {2}}
+	id, stat, _ = smppReceiptFields(receipt)
+	assert.Equal(t, "ABC-1", id, "the TLV wins over the text")
+	assert.Equal(t, DLRStatDelivered, stat)
+
+	_, ok = smppCarrierStatus("BOGUS")
+	assert.False(t, ok)
+	status, _ = smppCarrierStatus("ACCEPTD")
+	assert.Equal(t, CarrierStatusSent, status)
+}
+
+func TestSMPPCarrier_PartialSendCountsAsSent(t *testing.T) {
+	h, _, smscs := newTestSMPPCarrier(t)
+	smsc := <-smscs
+	waitBound(t, h)
+	smsc.accept.Store(1)
+
+	ackID, err := h.SendSMS(&MsgQueueItem{From: "+12505551234", To: "+447700900123", Type: MsgQueueItemType.SMS, message: strings.Repeat("long text ", 40)})
+	require.NoError(t, err, "a retry would resend the accepted segment")
+	assert.Equal(t, "smsc-1", ackID)
+	assert.Len(t, smsc.submits, 2, "the send stops at the refused segment")
+}
+
+func TestReusableCarrier(t *testing.T) {
+	row := Carrier{ID: 1, Name: "upstream", Type: "smpp", Username: "gw", Password: "enc", UUID: "u1", ProfileID: "smsc.example.com:2775"}
+	_, gw := newTestRouter(1)
+	h := newSMPPCarrierHandler(gw, &row, "gw", "secret")
+	current := map[string]CarrierHandler{"upstream": h, "telnyx": NewTelnyxHandler(gw, &Carrier{Name: "telnyx"}, "", "key")}
+
+	got, ok := reusableCarrier(current, row)
+	assert.True(t, ok, "an unchanged SMPP carrier keeps its bind")
+	assert.Same(t, h, got)
+
+	changed := row
+	changed.ProfileID = "smsc2.example.com:2775"
+	_, ok = reusableCarrier(current, changed)
+	assert.False(t, ok)
+	_, ok = reusableCarrier(current, Carrier{Name: "telnyx", Type: "telnyx"})
+	assert.False(t, ok, "only handlers holding a connection are reused")
+
+	// closeCarriers leaves the reused handler bound
+	closeCarriers(current, map[string]CarrierHandler{"upstream": h})
+	select {
+	case <-h.done:
+		t.Fatal("reused handler was closed")
+	default:
+	}
+	closeCarriers(current, nil)
+	<-h.done
+}
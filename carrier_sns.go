@@ -0,0 +1,659 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/google/uuid"
+	"github.com/kataras/iris/v12"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	awsDefaultRegion = "us-east-1"
+	// awsSMSVoiceService is the SigV4 signing name of AWS End User Messaging
+	// SMS (Pinpoint SMS and Voice v2).
+	awsSMSVoiceService = "sms-voice"
+)
+
+// AWSHandler implements CarrierHandler for AWS End User Messaging (Pinpoint
+// SMS and Voice v2). The carrier's username is the IAM access key ID, its
+// password the secret access key and its profile_id the AWS region.
+// Inbound messages arrive through an SNS topic subscribed to /inbound/{uuid}.
+//
+// The vendored AWS SDK predates the v2 API, so requests are built here and
+// signed with the SDK's SigV4 signer. MMS media is staged in the S3 bucket
+// named by AWS_MEDIA_BUCKET, since SendMediaMessage only takes s3:// URLs.
+type AWSHandler struct {
+	BaseCarrierHandler
+	gateway     *Gateway
+	carrier     *Carrier
+	region      string
+	credentials *credentials.Credentials
+	endpoint    string
+	mediaBucket string
+	certs       *snsCertCache
+}
+
+// NewAWSHandler initializes a new AWSHandler
+func NewAWSHandler(gateway *Gateway, carrier *Carrier, decryptedUsername string, decryptedPassword string) *AWSHandler {
+	region := strings.TrimSpace(carrier.ProfileID)
+	if region == "" {
+		region = awsDefaultRegion
+	}
+	return &AWSHandler{
+		BaseCarrierHandler: BaseCarrierHandler{name: "aws"},
+		gateway:            gateway,
+		carrier:            carrier,
+		region:             region,
+		credentials:        credentials.NewStaticCredentials(decryptedUsername, decryptedPassword, ""),
+		endpoint:           fmt.Sprintf("https://sms-voice.%s.amazonaws.com/", region),
+		mediaBucket:        gateway.Config.AWSMediaBucket,
+		certs:              &snsCertCache{},
+	}
+}
+
+// AWSSendRequest is the body of SendTextMessage and SendMediaMessage.
+type AWSSendRequest struct {
+	DestinationPhoneNumber string            `json:"DestinationPhoneNumber"`
+	OriginationIdentity    string            `json:"OriginationIdentity"`
+	MessageBody            string            `json:"MessageBody,omitempty"`
+	MediaUrls              []string          `json:"MediaUrls,omitempty"`
+	Context                map[string]string `json:"Context,omitempty"`
+}
+
+// AWSSendResponse is the response of SendTextMessage and SendMediaMessage.
+type AWSSendResponse struct {
+	MessageId string `json:"MessageId"`
+}
+
+// SNSEnvelope is the message SNS POSTs to an HTTP(S) subscription.
+type SNSEnvelope struct {
+	Type             string `json:"Type"`
+	MessageId        string `json:"MessageId"`
+	TopicArn         string `json:"TopicArn"`
+	Subject          string `json:"Subject,omitempty"`
+	Message          string `json:"Message"`
+	Timestamp        string `json:"Timestamp"`
+	Token            string `json:"Token,omitempty"`
+	SubscribeURL     string `json:"SubscribeURL,omitempty"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+}
+
+// stringToSign returns the text SNS signed for e, which depends on its type.
+func (e *SNSEnvelope) stringToSign() string {
+	fields := [][2]string{{"Message", e.Message}, {"MessageId", e.MessageId}}
+	if e.Type == "Notification" {
+		if e.Subject != "" {
+			fields = append(fields, [2]string{"Subject", e.Subject})
+		}
+		fields = append(fields, [2]string{"Timestamp", e.Timestamp})
+	} else {
+		fields = append(fields,
+			[2]string{"SubscribeURL", e.SubscribeURL},
+			[2]string{"Timestamp", e.Timestamp},
+			[2]string{"Token", e.Token})
+	}
+	fields = append(fields, [2]string{"TopicArn", e.TopicArn}, [2]string{"Type", e.Type})
+
+	var b strings.Builder
+	for _, f := range fields {
+		b.WriteString(f[0] + "\n" + f[1] + "\n")
+	}
+	return b.String()
+}
+
+// validSNSCertURL reports whether u may host an SNS signing certificate: an
+// HTTPS sns.<region>.amazonaws.com URL of a .pem file.
+func validSNSCertURL(u *url.URL) bool {
+	host := u.Hostname()
+	return u.Scheme == "https" && strings.HasPrefix(host, "sns.") &&
+		strings.HasSuffix(host, ".amazonaws.com") && strings.HasSuffix(u.Path, ".pem")
+}
+
+// snsCertCache holds SNS signing certificates by URL. The zero value is
+// ready to use.
+type snsCertCache struct {
+	mu    sync.Mutex
+	certs map[string]*x509.Certificate
+}
+
+// get returns the certificate at certURL, fetching it on first use.
+func (c *snsCertCache) get(certURL string) (*x509.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cert, ok := c.certs[certURL]; ok {
+		return cert, nil
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(certURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching SNS signing certificate: %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(body)
+	if block == nil {
+		return nil, errors.New("SNS signing certificate is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if c.certs == nil {
+		c.certs = make(map[string]*x509.Certificate)
+	}
+	c.certs[certURL] = cert
+	return cert, nil
+}
+
+// verifySNSSignature checks the signature of e against the certificate at
+// its SigningCertURL, which must be an SNS host on amazonaws.com.
+func (h *AWSHandler) verifySNSSignature(e *SNSEnvelope) error {
+	u, err := url.Parse(e.SigningCertURL)
+	if err != nil || !validSNSCertURL(u) {
+		return fmt.Errorf("refusing SNS SigningCertURL %q", e.SigningCertURL)
+	}
+	var algo x509.SignatureAlgorithm
+	switch e.SignatureVersion {
+	case "1":
+		algo = x509.SHA1WithRSA
+	case "2":
+		algo = x509.SHA256WithRSA
+	default:
+		return fmt.Errorf("unsupported SNS SignatureVersion %q", e.SignatureVersion)
+	}
+	signature, err := base64.StdEncoding.DecodeString(e.Signature)
+	if err != nil {
+		return fmt.Errorf("decoding SNS signature: %w", err)
+	}
+	cert, err := h.certs.get(u.String())
+	if err != nil {
+		return err
+	}
+	return cert.CheckSignature(algo, []byte(e.stringToSign()), signature)
+}
+
+// AWSInboundMessage is the two-way message AWS publishes to the SNS topic.
+type AWSInboundMessage struct {
+	OriginationNumber string   `json:"originationNumber"`
+	DestinationNumber string   `json:"destinationNumber"`
+	MessageBody       string   `json:"messageBody"`
+	InboundMessageId  string   `json:"inboundMessageId"`
+	InboundMediaUrls  []string `json:"inboundMediaUrls"`
+}
+
+// Inbound handles SNS deliveries: subscription confirmations are confirmed
+// automatically and notifications are parsed as inbound two-way messages.
+func (h *AWSHandler) Inbound(c iris.Context) error {
+	var lm = h.gateway.LogManager
+
+	var envelope SNSEnvelope
+	body, err := io.ReadAll(c.Request().Body)
+	if err == nil {
+		err = json.Unmarshal(body, &envelope)
+	}
+	if err != nil {
+		lm.SendLog(lm.BuildLog(
+			"Carrier.Inbound.AWS",
+			"GenericError",
+			logrus.ErrorLevel,
+			nil,
+			err,
+		))
+		c.StatusCode(http.StatusBadRequest)
+		return nil
+	}
+
+	if h.gateway.Config.SNSValidateSignature {
+		if err := h.verifySNSSignature(&envelope); err != nil {
+			lm.SendLog(lm.BuildLog(
+				"Carrier.Inbound.AWS",
+				"AuthFailed",
+				logrus.WarnLevel,
+				map[string]interface{}{
+					"carrier":        h.carrier.Name,
+					"snsMessageID":   envelope.MessageId,
+					"signingCertURL": envelope.SigningCertURL,
+					"client_ip":      c.RemoteAddr(),
+				}, err,
+			))
+			c.StatusCode(http.StatusForbidden)
+			return nil
+		}
+	}
+
+	switch envelope.Type {
+	case "SubscriptionConfirmation":
+		if err := h.confirmSubscription(envelope.SubscribeURL); err != nil {
+			lm.SendLog(lm.BuildLog(
+				"Carrier.Inbound.AWS",
+				"SubscriptionConfirmError",
+				logrus.ErrorLevel,
+				map[string]interface{}{
+					"topicArn": envelope.TopicArn,
+				}, err,
+			))
+			c.StatusCode(http.StatusBadRequest)
+			return nil
+		}
+		lm.SendLog(lm.BuildLog(
+			"Carrier.Inbound.AWS",
+			"SubscriptionConfirmed",
+			logrus.InfoLevel,
+			map[string]interface{}{
+				"topicArn": envelope.TopicArn,
+			},
+		))
+		c.StatusCode(http.StatusOK)
+		return nil
+	case "Notification":
+	default:
+		// UnsubscribeConfirmation and anything else needs no action.
+		c.StatusCode(http.StatusOK)
+		return nil
+	}
+
+	var payload AWSInboundMessage
+	if err := json.Unmarshal([]byte(envelope.Message), &payload); err != nil {
+		lm.SendLog(lm.BuildLog(
+			"Carrier.Inbound.AWS",
+			"GenericError",
+			logrus.ErrorLevel,
+			map[string]interface{}{
+				"snsMessageID": envelope.MessageId,
+			}, err,
+		))
+		c.StatusCode(http.StatusBadRequest)
+		return nil
+	}
+
+	if payload.OriginationNumber == "" || payload.DestinationNumber == "" {
+		lm.SendLog(lm.BuildLog(
+			"Carrier.Inbound.AWS",
+			"CarrierNoDestinations",
+			logrus.ErrorLevel,
+			map[string]interface{}{
+				"carrierID": payload.InboundMessageId,
+			},
+		))
+		c.StatusCode(http.StatusBadRequest)
+		return nil
+	}
+
+	from := payload.OriginationNumber
+	to := payload.DestinationNumber
+	messageID := payload.InboundMessageId
+
+	// SNS retries deliveries it does not see acknowledged in time.
+	if h.gateway.isDuplicateInbound(h.carrier.Name, messageID) {
+		lm.SendLog(lm.BuildLog(
+			"Carrier.Inbound.AWS",
+			"DuplicateInbound",
+			logrus.InfoLevel,
+			map[string]interface{}{
+				"carrierID": messageID,
+				"from":      from,
+				"to":        to,
+			},
+		))
+		c.StatusCode(http.StatusOK)
+		return nil
+	}
+
+	var files []MsgFile
+	if len(payload.InboundMediaUrls) > 0 {
+		files = h.fetchAWSMediaFiles(payload.InboundMediaUrls, messageID)
+		if len(files) <= 0 {
+			h.gateway.forgetInbound(h.carrier.Name, messageID)
+			c.StatusCode(http.StatusBadRequest)
+			return nil
+		}
+	}
+
+	logID := primitive.NewObjectID().Hex()
+	traceID := newTraceID()
+	received := time.Now()
+
+	if len(files) > 0 {
+		var originalSizeBytes int
+		for _, f := range files {
+			originalSizeBytes += len(f.Content)
+		}
+
+		msg := MsgQueueItem{
+			To:                to,
+			From:              from,
+			ReceivedTimestamp: received,
+			QueuedTimestamp:   received,
+			Type:              MsgQueueItemType.MMS,
+			files:             files,
+			LogID:             logID,
+			TraceID:           traceID,
+			SourceCarrier:     h.carrier.Name,
+			OriginalSizeBytes: originalSizeBytes,
+		}
+		msg.Priority = h.gateway.msgPriorityFor(msg.To, msg.Type)
+		h.gateway.Router.carrierQueue(msg.Priority) <- msg
+		h.gateway.recordMessage("inbound", msg.Type, h.Name(), MetricResultReceived)
+	}
+
+	if strings.TrimSpace(payload.MessageBody) != "" {
+		sms := MsgQueueItem{
+			To:                to,
+			From:              from,
+			ReceivedTimestamp: received,
+			QueuedTimestamp:   received,
+			Type:              MsgQueueItemType.SMS,
+			message:           payload.MessageBody,
+			LogID:             logID,
+			TraceID:           traceID,
+			SourceCarrier:     h.carrier.Name,
+		}
+		sms.Priority = h.gateway.msgPriorityFor(sms.To, sms.Type)
+		h.gateway.Router.carrierQueue(sms.Priority) <- sms
+		h.gateway.recordMessage("inbound", sms.Type, h.Name(), MetricResultReceived)
+	}
+
+	lm.SendLog(lm.BuildLog(
+		"Carrier.AWS.Inbound",
+		"received",
+		logrus.InfoLevel,
+		map[string]interface{}{
+			"logID":     logID,
+			"carrierID": messageID,
+			"from":      from,
+			"to":        to,
+		}, nil,
+	))
+
+	c.StatusCode(http.StatusOK)
+	return nil
+}
+
+// confirmSubscription visits an SNS SubscribeURL. Only HTTPS URLs on an
+// amazonaws.com host are followed, so a forged confirmation cannot make the
+// gateway fetch arbitrary URLs.
+func (h *AWSHandler) confirmSubscription(subscribeURL string) error {
+	u, err := url.Parse(subscribeURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" || !strings.HasSuffix(u.Hostname(), ".amazonaws.com") {
+		return fmt.Errorf("refusing SNS SubscribeURL %q", subscribeURL)
+	}
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("non-OK HTTP status: %s", resp.Status)
+	}
+	return nil
+}
+
+// fetchAWSMediaFiles downloads inbound media from its s3:// URLs.
+func (h *AWSHandler) fetchAWSMediaFiles(mediaURLs []string, messageID string) []MsgFile {
+	lm := h.gateway.LogManager
+	var files []MsgFile
+
+	for _, mediaURL := range mediaURLs {
+		content, contentType, err := h.fetchMediaContent(mediaURL)
+		if err != nil {
+			lm.SendLog(lm.BuildLog(
+				"Carrier.FetchMedia.AWS",
+				"CarrierFetchMediaError",
+				logrus.ErrorLevel,
+				map[string]interface{}{
+					"logID":    messageID,
+					"mediaURL": mediaURL,
+				}, err,
+			))
+			continue
+		}
+
+		filename := path.Base(mediaURL)
+		if path.Ext(filename) == "" {
+			filename += getExtensionForContentType(contentType)
+		}
+
+		files = append(files, MsgFile{
+			Filename:    filename,
+			ContentType: contentType,
+			Content:     content,
+		})
+	}
+	return files
+}
+
+func (h *AWSHandler) fetchMediaContent(mediaURL string) ([]byte, string, error) {
+	bucket, key, err := parseS3URL(mediaURL)
+	if err != nil {
+		return nil, "", err
+	}
+
+	svc, err := h.s3Client()
+	if err != nil {
+		return nil, "", err
+	}
+	out, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("S3 GetObject failed: %w", err)
+	}
+	defer out.Body.Close()
+
+	content, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading media content: %w", err)
+	}
+
+	contentType := strings.TrimSpace(strings.Split(aws.StringValue(out.ContentType), ";")[0])
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = mimetype.Detect(content).String()
+	}
+	return content, contentType, nil
+}
+
+// parseS3URL splits an s3://bucket/key URL.
+func parseS3URL(s3URL string) (string, string, error) {
+	u, err := url.Parse(s3URL)
+	if err != nil {
+		return "", "", err
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Scheme != "s3" || u.Host == "" || key == "" {
+		return "", "", fmt.Errorf("not an s3:// media URL: %q", s3URL)
+	}
+	return u.Host, key, nil
+}
+
+func (h *AWSHandler) s3Client() (*s3.S3, error) {
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String(h.region),
+		Credentials: h.credentials,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s3.New(sess), nil
+}
+
+// SendSMS sends an SMS message via SendTextMessage.
+func (h *AWSHandler) SendSMS(sms *MsgQueueItem) (string, error) {
+	return h.send("SendTextMessage", AWSSendRequest{
+		DestinationPhoneNumber: sms.To,
+		OriginationIdentity:    sms.From,
+		MessageBody:            sms.message,
+		Context:                map[string]string{"logID": sms.LogID, "traceID": sms.TraceID},
+	}, sms, "Carrier.SendSMS.AWS")
+}
+
+// SendMMS uploads the attachments to AWS_MEDIA_BUCKET and sends them with
+// the message text via SendMediaMessage.
+func (h *AWSHandler) SendMMS(mms *MsgQueueItem) (string, error) {
+	var lm = h.gateway.LogManager
+
+	if h.mediaBucket == "" {
+		return "", errors.New("AWS_MEDIA_BUCKET is not set; cannot send MMS via AWS")
+	}
+
+	svc, err := h.s3Client()
+	if err != nil {
+		return "", err
+	}
+
+	var mediaURLs []string
+	for _, f := range mms.files {
+		if strings.Contains(f.ContentType, "application/smil") {
+			continue
+		}
+
+		key := fmt.Sprintf("mms/%s/%s%s", mms.LogID, uuid.New().String(), getExtensionForContentType(f.ContentType))
+		_, err := svc.PutObject(&s3.PutObjectInput{
+			Bucket:      aws.String(h.mediaBucket),
+			Key:         aws.String(key),
+			Body:        bytes.NewReader(f.Content),
+			ContentType: aws.String(f.ContentType),
+		})
+		if err != nil {
+			lm.SendLog(lm.BuildLog(
+				"Carrier.SendMMS.AWS",
+				"SaveMediaError",
+				logrus.ErrorLevel,
+				map[string]interface{}{
+					"logID":   mms.LogID,
+					"traceID": mms.TraceID,
+					"bucket":  h.mediaBucket,
+				}, err,
+			))
+			return "", err
+		}
+		mediaURLs = append(mediaURLs, fmt.Sprintf("s3://%s/%s", h.mediaBucket, key))
+	}
+
+	if len(mediaURLs) == 0 {
+		return "", errors.New("no media to send via AWS")
+	}
+
+	return h.send("SendMediaMessage", AWSSendRequest{
+		DestinationPhoneNumber: mms.To,
+		OriginationIdentity:    mms.From,
+		MessageBody:            mms.message,
+		MediaUrls:              mediaURLs,
+		Context:                map[string]string{"logID": mms.LogID, "traceID": mms.TraceID},
+	}, mms, "Carrier.SendMMS.AWS")
+}
+
+// send signs and POSTs one SMS and Voice v2 action and returns its MessageId.
+func (h *AWSHandler) send(action string, message AWSSendRequest, item *MsgQueueItem, logModule string) (string, error) {
+	var lm = h.gateway.LogManager
+
+	payloadBytes, err := json.Marshal(message)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest("POST", h.endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "PinpointSMSVoiceV2."+action)
+
+	signer := v4.NewSigner(h.credentials)
+	if _, err := signer.Sign(req, bytes.NewReader(payloadBytes), awsSMSVoiceService, h.region, time.Now()); err != nil {
+		lm.SendLog(lm.BuildLog(
+			logModule,
+			"SignError",
+			logrus.ErrorLevel,
+			map[string]interface{}{
+				"logID":   item.LogID,
+				"traceID": item.TraceID,
+			}, err,
+		))
+		return "", err
+	}
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		lm.SendLog(lm.BuildLog(
+			logModule,
+			"Failed to send request: %v",
+			logrus.ErrorLevel,
+			map[string]interface{}{
+				"logID":   item.LogID,
+				"traceID": item.TraceID,
+			}, err,
+		))
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		lm.SendLog(lm.BuildLog(
+			logModule,
+			"Failed to send message to Carrier",
+			logrus.ErrorLevel,
+			map[string]interface{}{
+				"logID":           item.LogID,
+				"traceID":         item.TraceID,
+				"response_code":   resp.StatusCode,
+				"response_status": resp.Status,
+				"to":              item.To,
+				"from":            item.From,
+				"response_body":   string(bodyBytes),
+			},
+		))
+		return "", carrierRejected(resp.StatusCode, fmt.Errorf("%s via AWS failed", action))
+	}
+
+	var awsResp AWSSendResponse
+	if err := json.Unmarshal(bodyBytes, &awsResp); err != nil {
+		return "", err
+	}
+	return awsResp.MessageId, nil
+}
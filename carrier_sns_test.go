@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// snsInbound runs body through h.Inbound as an SNS POST and returns the status.
+func snsInbound(t *testing.T, h *AWSHandler, body string) int {
+	t.Helper()
+	app := iris.New()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/inbound/uuid", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/plain; charset=UTF-8")
+	ctx := app.ContextPool.Acquire(w, req)
+
+	require.NoError(t, h.Inbound(ctx))
+	return ctx.GetStatusCode()
+}
+
+func TestAWSHandler_InboundNotification(t *testing.T) {
+	r, gw := newTestRouter(2)
+	h := NewAWSHandler(gw, &Carrier{Name: "aws-main"}, "AKID", "secret")
+
+	message, err := json.Marshal(AWSInboundMessage{
+		OriginationNumber: "+14255550182",
+		DestinationNumber: "+12125550101",
+		MessageBody:       "hello from aws",
+		InboundMessageId:  "cae173d2-66b9-564c-8309-21f858e9fb84",
+	})
+	require.NoError(t, err)
+	envelope, err := json.Marshal(SNSEnvelope{Type: "Notification", MessageId: "sns-1", Message: string(message)})
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, snsInbound(t, h, string(envelope)))
+	require.Len(t, r.CarrierMsgChan, 1)
+	msg := <-r.CarrierMsgChan
+	assert.Equal(t, MsgQueueItemType.SMS, msg.Type)
+	assert.Equal(t, "+14255550182", msg.From)
+	assert.Equal(t, "+12125550101", msg.To)
+	assert.Equal(t, "hello from aws", msg.message)
+	assert.Equal(t, "aws-main", msg.SourceCarrier)
+}
+
+func TestAWSHandler_InboundSubscriptionConfirmation(t *testing.T) {
+	_, gw := newTestRouter(1)
+	h := NewAWSHandler(gw, &Carrier{Name: "aws-main"}, "AKID", "secret")
+
+	// Only amazonaws.com HTTPS URLs are visited.
+	envelope := `{"Type":"SubscriptionConfirmation","TopicArn":"arn:aws:sns:us-east-1:1:t","SubscribeURL":"http://169.254.169.254/latest"}`
+	assert.Equal(t, http.StatusBadRequest, snsInbound(t, h, envelope))
+
+	assert.Equal(t, http.StatusOK, snsInbound(t, h, `{"Type":"UnsubscribeConfirmation"}`))
+	assert.Equal(t, http.StatusBadRequest, snsInbound(t, h, `not json`))
+}
+
+func TestAWSHandler_SendSMS(t *testing.T) {
+	var target, auth string
+	var sent AWSSendRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target = r.Header.Get("X-Amz-Target")
+		auth = r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &sent)
+		w.Write([]byte(`{"MessageId":"msg-123"}`))
+	}))
+	defer srv.Close()
+
+	_, gw := newTestRouter(1)
+	h := NewAWSHandler(gw, &Carrier{Name: "aws-main", ProfileID: "ca-central-1"}, "AKID", "secret")
+	assert.Equal(t, "https://sms-voice.ca-central-1.amazonaws.com/", h.endpoint)
+	h.endpoint = srv.URL
+
+	id, err := h.SendSMS(&MsgQueueItem{From: "+12125550101", To: "+14255550182", message: "hi", LogID: "log-1"})
+	require.NoError(t, err)
+	assert.Equal(t, "msg-123", id)
+	assert.Equal(t, "PinpointSMSVoiceV2.SendTextMessage", target)
+	assert.True(t, strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/"))
+	assert.Contains(t, auth, "/ca-central-1/sms-voice/aws4_request")
+	assert.Equal(t, "+14255550182", sent.DestinationPhoneNumber)
+	assert.Equal(t, "+12125550101", sent.OriginationIdentity)
+	assert.Equal(t, "hi", sent.MessageBody)
+}
+
+func TestAWSHandler_SendMMSNeedsBucket(t *testing.T) {
+	_, gw := newTestRouter(1)
+	h := NewAWSHandler(gw, &Carrier{Name: "aws-main"}, "AKID", "secret")
+
+	_, err := h.SendMMS(&MsgQueueItem{files: []MsgFile{{ContentType: "image/jpeg", Content: []byte{1}}}})
+	assert.ErrorContains(t, err, "AWS_MEDIA_BUCKET")
+}
+
+func TestParseS3URL(t *testing.T) {
+	bucket, key, err := parseS3URL("s3://inbound-media/2024/abc.jpg")
+	require.NoError(t, err)
+	assert.Equal(t, "inbound-media", bucket)
+	assert.Equal(t, "2024/abc.jpg", key)
+
+	_, _, err = parseS3URL("https://example.com/abc.jpg")
+	assert.Error(t, err)
+}
+
+func TestAWSHandler_InboundSignature(t *testing.T) {
+	r, gw := newTestRouter(2)
+	gw.Config.SNSValidateSignature = true
+	h := NewAWSHandler(gw, &Carrier{Name: "aws-main"}, "AKID", "secret")
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	certURL := "https://sns.us-east-1.amazonaws.com/SimpleNotificationService-test.pem"
+	h.certs.certs = map[string]*x509.Certificate{certURL: cert}
+
+	message, err := json.Marshal(AWSInboundMessage{
+		OriginationNumber: "+14255550182",
+		DestinationNumber: "+12125550101",
+		MessageBody:       "signed",
+		InboundMessageId:  "in-1",
+	})
+	require.NoError(t, err)
+	signed := func(e SNSEnvelope) string {
+		sum := sha256.Sum256([]byte(e.stringToSign()))
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+		require.NoError(t, err)
+		e.Signature = base64.StdEncoding.EncodeToString(sig)
+		b, err := json.Marshal(e)
+		require.NoError(t, err)
+		return string(b)
+	}
+	envelope := SNSEnvelope{
+		Type:             "Notification",
+		MessageId:        "sns-1",
+		TopicArn:         "arn:aws:sns:us-east-1:1:t",
+		Message:          string(message),
+		Timestamp:        "2026-10-16T12:00:00.000Z",
+		SignatureVersion: "2",
+		SigningCertURL:   certURL,
+	}
+
+	assert.Equal(t, http.StatusOK, snsInbound(t, h, signed(envelope)))
+	require.Len(t, r.CarrierMsgChan, 1)
+	<-r.CarrierMsgChan
+
+	tampered := strings.Replace(signed(envelope), "signed", "forged", 1)
+	assert.Equal(t, http.StatusForbidden, snsInbound(t, h, tampered))
+
+	envelope.SigningCertURL = "https://attacker.example.com/cert.pem"
+	assert.Equal(t, http.StatusForbidden, snsInbound(t, h, signed(envelope)))
+
+	assert.Equal(t, http.StatusForbidden, snsInbound(t, h, `{"Type":"SubscriptionConfirmation","SubscribeURL":"https://sns.us-east-1.amazonaws.com/?Action=ConfirmSubscription"}`))
+	assert.Empty(t, r.CarrierMsgChan)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Delivery-status events, as normalised by the carrier Inbound handlers.
+const (
+	CarrierStatusSent      = "sent"      // accepted by the carrier network
+	CarrierStatusDelivered = "delivered" // delivered to the handset
+	CarrierStatusFailed    = "failed"    // rejected or undeliverable
+)
+
+// carrierReceiptTTL bounds how long an SMPP receipt waits for the carrier's
+// delivery report before it is dropped.
+const carrierReceiptTTL = 24 * time.Hour
+
+// carrierReceipts holds SMPP delivery receipt requests for messages handed to
+// a carrier, keyed by carrier message ID, until the carrier reports the
+// outcome. Used when SMPP_DLR_FROM_CARRIER is enabled. The zero value is
+// ready to use.
+type carrierReceipts struct {
+	mu        sync.Mutex
+	held      map[string]heldReceipt
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type heldReceipt struct {
+	msg  MsgQueueItem
+	held time.Time
+}
+
+func (r *carrierReceipts) clock() time.Time {
+	if r.now != nil {
+		return r.now()
+	}
+	return time.Now()
+}
+
+// hold keeps msg's receipt request until take is called for carrierMsgID.
+// Messages without a receipt request are ignored.
+func (r *carrierReceipts) hold(carrierMsgID string, msg *MsgQueueItem) {
+	if carrierMsgID == "" || msg.DeliveryReceipt == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock()
+	if r.held == nil {
+		r.held = make(map[string]heldReceipt)
+	}
+	// Drop receipts the carrier never reported on, at most once per hour.
+	if now.Sub(r.lastSweep) >= time.Hour {
+		for id, h := range r.held {
+			if now.Sub(h.held) >= carrierReceiptTTL {
+				delete(r.held, id)
+			}
+		}
+		r.lastSweep = now
+	}
+	r.held[carrierMsgID] = heldReceipt{msg: *msg, held: now}
+}
+
+// take removes and returns the message held for carrierMsgID.
+func (r *carrierReceipts) take(carrierMsgID string) (MsgQueueItem, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	h, ok := r.held[carrierMsgID]
+	if !ok {
+		return MsgQueueItem{}, false
+	}
+	delete(r.held, carrierMsgID)
+	if r.clock().Sub(h.held) >= carrierReceiptTTL {
+		return MsgQueueItem{}, false
+	}
+	return h.msg, true
+}
+
+// handleCarrierStatus applies a delivery-status webhook for the outbound
+// message the carrier knows as carrierMsgID: it releases the conversation
+// queue, updates the message record and, with SMPP_DLR_FROM_CARRIER, sends
+// the held receipt to the originating SMPP client.
+func (gateway *Gateway) handleCarrierStatus(carrier, carrierMsgID, status, detail string) {
+	if carrierMsgID == "" {
+		return
+	}
+	lm := gateway.LogManager
+	lm.SendLog(lm.BuildLog(
+		"Carrier.Status",
+		"DeliveryStatus",
+		logrus.DebugLevel,
+		map[string]interface{}{
+			"carrier":   carrier,
+			"carrierID": carrierMsgID,
+			"status":    status,
+			"detail":    detail,
+		},
+	))
+
+	switch status {
+	case CarrierStatusSent:
+		gateway.ConvoManager.HandleCarrierAck(carrierMsgID, gateway.Router)
+	case CarrierStatusDelivered:
+		gateway.recordCarrierStatus(carrierMsgID, MsgStatusDelivered, "")
+		gateway.forwardCarrierReceipt(carrierMsgID, DLRStatDelivered, DLRErrNone)
+	case CarrierStatusFailed:
+		// A failure can arrive without a "sent" event first; release the
+		// conversation if it is still waiting on this message.
+		if gateway.ConvoManager.awaitingCarrierAck(carrierMsgID) {
+			gateway.ConvoManager.HandleCarrierAck(carrierMsgID, gateway.Router)
+		}
+		gateway.recordCarrierStatus(carrierMsgID, MsgStatusFailed, detail)
+		gateway.forwardCarrierReceipt(carrierMsgID, DLRStatUndeliverable, DLRErrCarrierFailed)
+	}
+}
+
+// forwardCarrierReceipt sends the SMPP receipt held for carrierMsgID, if any.
+func (gateway *Gateway) forwardCarrierReceipt(carrierMsgID, stat string, errCode int) {
+	msg, ok := gateway.carrierReceipts.take(carrierMsgID)
+	if !ok {
+		return
+	}
+	gateway.SMPPServer.sendDeliveryReceipt(&msg, stat, errCode)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCarrierReceipts_HoldAndTake(t *testing.T) {
+	var r carrierReceipts
+	now := time.Date(2026, 3, 7, 6, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+
+	r.hold("SM1", &MsgQueueItem{LogID: "no-receipt"})
+	_, ok := r.take("SM1")
+	assert.False(t, ok, "messages without a receipt request are not held")
+
+	r.hold("SM1", &MsgQueueItem{LogID: "a", DeliveryReceipt: &SMPPReceiptRequest{Mode: 1}})
+	msg, ok := r.take("SM1")
+	require.True(t, ok)
+	assert.Equal(t, "a", msg.LogID)
+	_, ok = r.take("SM1")
+	assert.False(t, ok, "a receipt is forwarded once")
+
+	r.hold("SM2", &MsgQueueItem{LogID: "b", DeliveryReceipt: &SMPPReceiptRequest{Mode: 1}})
+	now = now.Add(carrierReceiptTTL)
+	_, ok = r.take("SM2")
+	assert.False(t, ok, "expired receipts are dropped")
+
+	r.hold("SM3", &MsgQueueItem{LogID: "c", DeliveryReceipt: &SMPPReceiptRequest{Mode: 1}})
+	now = now.Add(carrierReceiptTTL)
+	r.hold("SM4", &MsgQueueItem{LogID: "d", DeliveryReceipt: &SMPPReceiptRequest{Mode: 1}})
+	assert.NotContains(t, r.held, "SM3", "hold sweeps expired entries")
+}
+
+func TestGateway_HandleCarrierStatus(t *testing.T) {
+	r, gw := newTestRouter(1)
+	gw.ConvoManager = NewConvoManager()
+	records := make(chan MsgRecord, 1)
+	gw.MsgRecordChan = records
+
+	convoID := computeCorrelationKey("+12505551234", "+14155559876")
+	gw.ConvoManager.SetExpectedAck(convoID, "SM1", r, time.Minute)
+	require.True(t, gw.ConvoManager.awaitingCarrierAck("SM1"))
+
+	gw.handleCarrierStatus("twilio", "SM1", CarrierStatusFailed, "undelivered (30003)")
+	assert.False(t, gw.ConvoManager.awaitingCarrierAck("SM1"), "a failure releases the conversation")
+	rec := <-records
+	assert.Equal(t, "SM1", rec.CarrierMsgID)
+	assert.Equal(t, MsgStatusFailed, rec.Status)
+	assert.Equal(t, "undelivered (30003)", rec.Error)
+
+	gw.handleCarrierStatus("twilio", "SM2", CarrierStatusDelivered, "")
+	rec = <-records
+	assert.Equal(t, "SM2", rec.CarrierMsgID)
+	assert.Equal(t, MsgStatusDelivered, rec.Status)
+
+	gw.handleCarrierStatus("twilio", "SM3", CarrierStatusSent, "")
+	assert.Empty(t, records, "sent only releases the conversation")
+	gw.handleCarrierStatus("twilio", "", CarrierStatusDelivered, "")
+	assert.Empty(t, records)
+}
+
+func TestTwilioHandler_StatusCallbackURL(t *testing.T) {
+	_, gw := newTestRouter(1)
+	h := &TwilioHandler{gateway: gw, carrier: &Carrier{UUID: "abc123"}}
+
+	assert.Empty(t, h.statusCallbackURL(), "no public address, no callback")
+
+	gw.Config.ServerAddress = "https://gw.example.com"
+	assert.Equal(t, "https://gw.example.com/inbound/abc123", h.statusCallbackURL())
+}
@@ -5,10 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"net/http"
-	"os"
 	"path"
 	"strings"
 	"time"
@@ -24,6 +21,7 @@ type TelnyxHandler struct {
 	gateway  *Gateway
 	carrier  *Carrier
 	password string
+	apiBase  string // e.g. https://api.telnyx.com/v2
 }
 
 // NewTelnyxHandler initializes a new TelnyxHandler
@@ -33,6 +31,7 @@ func NewTelnyxHandler(gateway *Gateway, carrier *Carrier, decryptedUsername stri
 		gateway:            gateway,
 		carrier:            carrier,
 		password:           decryptedPassword,
+		apiBase:            "https://api.telnyx.com/v2",
 	}
 }
 
@@ -98,7 +97,15 @@ func (h *TelnyxHandler) Inbound(c iris.Context) error {
 	if webhookPayload.Data.EventType != "message.received" {
 		// ignore delivery?? or log it?? todo
 		if webhookPayload.Data.EventType == "message.sent" {
-			h.gateway.ConvoManager.HandleCarrierAck(webhookPayload.Data.Payload.ID, h.gateway.Router)
+			h.gateway.handleCarrierStatus(h.carrier.Name, webhookPayload.Data.Payload.ID, CarrierStatusSent, "")
+		}
+		if webhookPayload.Data.EventType == "message.finalized" && len(webhookPayload.Data.Payload.To) > 0 {
+			switch status := webhookPayload.Data.Payload.To[0].Status; status {
+			case "delivered":
+				h.gateway.handleCarrierStatus(h.carrier.Name, webhookPayload.Data.Payload.ID, CarrierStatusDelivered, "")
+			case "sending_failed", "delivery_failed":
+				h.gateway.handleCarrierStatus(h.carrier.Name, webhookPayload.Data.Payload.ID, CarrierStatusFailed, status)
+			}
 		}
 		c.StatusCode(http.StatusOK)
 		return nil
@@ -111,12 +118,28 @@ func (h *TelnyxHandler) Inbound(c iris.Context) error {
 	messageID := webhookPayload.Data.Payload.ID
 	// messagingProfileID := webhookPayload.Data.Payload.MessagingProfileID // if needed
 
+	if h.gateway.isDuplicateInbound(h.carrier.Name, messageID) {
+		lm.SendLog(lm.BuildLog(
+			"Carrier.Inbound.Telnyx",
+			"DuplicateInbound",
+			logrus.InfoLevel,
+			map[string]interface{}{
+				"carrierID": messageID,
+				"from":      from,
+				"to":        to,
+			},
+		))
+		c.StatusCode(http.StatusOK)
+		return nil
+	}
+
 	numMedia := len(webhookPayload.Data.Payload.Media)
 
 	var files []MsgFile
 	if numMedia > 0 {
 		ff := h.fetchTelnyxMediaFiles(webhookPayload.Data.Payload.Media, messageID)
 		if len(ff) <= 0 {
+			h.gateway.forgetInbound(h.carrier.Name, messageID)
 			c.StatusCode(http.StatusBadRequest)
 			return nil
 		}
@@ -124,6 +147,8 @@ func (h *TelnyxHandler) Inbound(c iris.Context) error {
 	}
 
 	logID := primitive.NewObjectID().Hex()
+	traceID := newTraceID()
+	received := time.Now()
 
 	// Handle MMS if media files are present
 	if numMedia > 0 && len(files) > 0 {
@@ -136,16 +161,21 @@ func (h *TelnyxHandler) Inbound(c iris.Context) error {
 		msg := MsgQueueItem{
 			To:                to,
 			From:              from,
-			ReceivedTimestamp: time.Now(),
+			ReceivedTimestamp: received,
+			QueuedTimestamp:   received,
 			Type:              MsgQueueItemType.MMS,
 			files:             files,
+			Subject:           webhookPayload.Data.Payload.Subject,
 			SkipNumberCheck:   false,
 			LogID:             logID,
+			TraceID:           traceID,
 			SourceCarrier:     h.carrier.Name,
 			OriginalSizeBytes: originalSizeBytes,
 		}
 		//h.gateway.MM4Server.msgToClientChannel <- mm4Message
-		h.gateway.Router.CarrierMsgChan <- msg
+		msg.Priority = h.gateway.msgPriorityFor(msg.To, msg.Type)
+		h.gateway.Router.carrierQueue(msg.Priority) <- msg
+		h.gateway.recordMessage("inbound", msg.Type, h.Name(), MetricResultReceived)
 	}
 
 	// Handle SMS if body is present
@@ -154,13 +184,17 @@ func (h *TelnyxHandler) Inbound(c iris.Context) error {
 		sms := MsgQueueItem{
 			To:                to,
 			From:              from,
-			ReceivedTimestamp: time.Now(),
+			ReceivedTimestamp: received,
+			QueuedTimestamp:   received,
 			Type:              MsgQueueItemType.SMS,
 			message:           body,
 			LogID:             logID,
+			TraceID:           traceID,
 			SourceCarrier:     h.carrier.Name,
 		}
-		h.gateway.Router.CarrierMsgChan <- sms
+		sms.Priority = h.gateway.msgPriorityFor(sms.To, sms.Type)
+		h.gateway.Router.carrierQueue(sms.Priority) <- sms
+		h.gateway.recordMessage("inbound", sms.Type, h.Name(), MetricResultReceived)
 		/*for _, smsBody := range smsMessages {
 
 		}*/
@@ -222,6 +256,7 @@ type TelnyxWebhookPayload struct {
 			ReceivedAt            time.Time     `json:"received_at"`
 			RecordType            string        `json:"record_type"`
 			SentAt                time.Time     `json:"sent_at"`
+			Subject               string        `json:"subject"`
 			Tags                  []interface{} `json:"tags"`
 			TcrCampaignBillable   bool          `json:"tcr_campaign_billable"`
 			TcrCampaignId         interface{}   `json:"tcr_campaign_id"`
@@ -294,7 +329,7 @@ func (h *TelnyxHandler) fetchTelnyxMediaFiles(media []TelnyxMedia, messageID str
 		filename := fmt.Sprintf("%s" /*.%s"*/, mediaSid /*, extension*/) // e.g., mediaSid.jpg
 
 		// Fetch the media content
-		contentBytes, err := fetchMediaContentTelnyx(mediaURL)
+		contentBytes, _, err := h.gateway.fetchMedia(h.carrier, mediaURL, nil)
 		if err != nil {
 			var lm = h.gateway.LogManager
 			lm.SendLog(lm.BuildLog(
@@ -303,6 +338,7 @@ func (h *TelnyxHandler) fetchTelnyxMediaFiles(media []TelnyxMedia, messageID str
 				logrus.ErrorLevel,
 				map[string]interface{}{
 					"logID": messageID,
+					"error": err.Error(),
 				}, mediaURL,
 			))
 			continue
@@ -319,32 +355,9 @@ func (h *TelnyxHandler) fetchTelnyxMediaFiles(media []TelnyxMedia, messageID str
 	return files
 }
 
-// fetchMediaContentTelnyx retrieves media content from Telnyx URL
-func fetchMediaContentTelnyx(mediaURL string) ([]byte, error) {
-	req, err := http.NewRequest("GET", mediaURL, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("HTTP request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Non-OK HTTP status: %s", resp.Status)
-	}
-
-	contentBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading media content: %w", err)
-	}
-
-	return contentBytes, nil
+// SupportsAlphaSender reports that Telnyx accepts alphanumeric sender IDs.
+func (h *TelnyxHandler) SupportsAlphaSender() bool {
+	return true
 }
 
 // SendSMS sends an SMS message via Telnyx API
@@ -373,63 +386,41 @@ func (h *TelnyxHandler) SendSMS(sms *MsgQueueItem) (string, error) {
 			"Failed to unmarshal (1) %v",
 			logrus.ErrorLevel,
 			map[string]interface{}{
-				"logID": sms.LogID,
+				"logID":   sms.LogID,
+				"traceID": sms.TraceID,
 			}, err,
 		))
 		return "", err
 	}
 
-	// Create HTTP request
-	req, err := http.NewRequest("POST", "https://api.telnyx.com/v2/messages", bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		lm.SendLog(lm.BuildLog(
-			"Carrier.SendSMS.Telnyx",
-			"Failed to build request: %v",
-			logrus.ErrorLevel,
-			map[string]interface{}{
-				"logID": sms.LogID,
-			}, err,
-		))
-		return "", err
-	}
-
-	// Set headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+h.password)
-
-	// Perform the request
+	// Send through the carrier breaker, retrying transient failures
 	client := &http.Client{
 		Timeout: 30 * time.Second,
 	}
-	resp, err := client.Do(req)
+	statusCode, bodyBytes, err := h.gateway.carrierHTTP(h.carrier.Name, client, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", h.apiBase+"/messages", bytes.NewReader(payloadBytes))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+h.password)
+		req.Header.Set(traceIDHeader, sms.TraceID)
+		return req, nil
+	})
 	if err != nil {
 		lm.SendLog(lm.BuildLog(
 			"Carrier.SendSMS.Telnyx",
 			"Failed to send request: %v",
 			logrus.ErrorLevel,
 			map[string]interface{}{
-				"logID": sms.LogID,
-			}, err,
-		))
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	// Read and parse response
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		lm.SendLog(lm.BuildLog(
-			"Carrier.SendSMS.Telnyx",
-			"Failed to read and parse: %v",
-			logrus.ErrorLevel,
-			map[string]interface{}{
-				"logID": sms.LogID,
+				"logID":   sms.LogID,
+				"traceID": sms.TraceID,
 			}, err,
 		))
 		return "", err
 	}
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+	if statusCode != http.StatusOK && statusCode != http.StatusCreated {
 
 		// check if it was blocked due to stop message
 		if strings.Contains(string(bodyBytes), "Blocked due to STOP message") {
@@ -439,8 +430,9 @@ func (h *TelnyxHandler) SendSMS(sms *MsgQueueItem) (string, error) {
 				logrus.ErrorLevel,
 				map[string]interface{}{
 					"logID":           sms.LogID,
-					"response_code":   resp.StatusCode,
-					"response_status": resp.Status,
+					"traceID":         sms.TraceID,
+					"response_code":   statusCode,
+					"response_status": http.StatusText(statusCode),
 					"to":              sms.To,
 					"from":            sms.From,
 					"response_body":   string(bodyBytes), // Include response body for debugging
@@ -455,14 +447,15 @@ func (h *TelnyxHandler) SendSMS(sms *MsgQueueItem) (string, error) {
 			logrus.ErrorLevel,
 			map[string]interface{}{
 				"logID":           sms.LogID,
-				"response_code":   resp.StatusCode,
-				"response_status": resp.Status,
+				"traceID":         sms.TraceID,
+				"response_code":   statusCode,
+				"response_status": http.StatusText(statusCode),
 				"to":              sms.To,
 				"from":            sms.From,
 				"response_body":   string(bodyBytes), // Include response body for debugging
 			}, err,
 		))
-		return "", errors.New("failed to send SMS via Telnyx")
+		return "", carrierRejected(statusCode, errors.New("failed to send SMS via Telnyx"))
 	}
 
 	// Optionally, parse the response to get message ID
@@ -473,7 +466,8 @@ func (h *TelnyxHandler) SendSMS(sms *MsgQueueItem) (string, error) {
 			"Failed to unmarshal (2): %v",
 			logrus.ErrorLevel,
 			map[string]interface{}{
-				"logID": sms.LogID,
+				"logID":   sms.LogID,
+				"traceID": sms.TraceID,
 			}, err,
 		))
 		return "", err
@@ -510,13 +504,14 @@ func (h *TelnyxHandler) SendMMS(mms *MsgQueueItem) (string, error) {
 					"SaveMediaError",
 					logrus.ErrorLevel,
 					map[string]interface{}{
-						"logID": mms.LogID,
+						"logID":   mms.LogID,
+						"traceID": mms.TraceID,
 					}, err,
 				))
 				return "", err
 			}
 
-			mediaUrls = append(mediaUrls, os.Getenv("SERVER_ADDRESS")+"/media/"+accessToken)
+			mediaUrls = append(mediaUrls, h.gateway.Config.ServerAddress+"/media/"+accessToken)
 		}
 		message.MediaUrls = mediaUrls
 	}
@@ -534,64 +529,41 @@ func (h *TelnyxHandler) SendMMS(mms *MsgQueueItem) (string, error) {
 			"Failed to marshal payload: %v",
 			logrus.ErrorLevel,
 			map[string]interface{}{
-				"logID": mms.LogID,
+				"logID":   mms.LogID,
+				"traceID": mms.TraceID,
 			}, err,
 		))
 		return "", err
 	}
 
-	// Create HTTP request
-	req, err := http.NewRequest("POST", "https://api.telnyx.com/v2/messages", bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		lm.SendLog(lm.BuildLog(
-			"Carrier.SendMMS.Telnyx",
-			"Failed to build request: %v",
-			logrus.ErrorLevel,
-			map[string]interface{}{
-				"logID": mms.LogID,
-			}, err,
-		))
-		return "", err
-	}
-
-	// Set headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+h.password)
-
-	// Perform the request
+	// Send through the carrier breaker, retrying transient failures
 	client := &http.Client{
 		Timeout: 30 * time.Second,
 	}
-	resp, err := client.Do(req)
+	statusCode, bodyBytes, err := h.gateway.carrierHTTP(h.carrier.Name, client, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", h.apiBase+"/messages", bytes.NewReader(payloadBytes))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+h.password)
+		req.Header.Set(traceIDHeader, mms.TraceID)
+		return req, nil
+	})
 	if err != nil {
-		var lm = h.gateway.LogManager
 		lm.SendLog(lm.BuildLog(
 			"Carrier.SendMMS.Telnyx",
 			"Failed to send request: %v",
 			logrus.ErrorLevel,
 			map[string]interface{}{
-				"logID": mms.LogID,
-			}, err,
-		))
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	// Read and parse response
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		lm.SendLog(lm.BuildLog(
-			"Carrier.SendMMS.Telnyx",
-			"Failed to read response body: %v",
-			logrus.ErrorLevel,
-			map[string]interface{}{
-				"logID": mms.LogID,
+				"logID":   mms.LogID,
+				"traceID": mms.TraceID,
 			}, err,
 		))
 		return "", err
 	}
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+	if statusCode != http.StatusOK && statusCode != http.StatusCreated {
 		// check if it was blocked due to stop message
 		if strings.Contains(string(bodyBytes), "Blocked due to STOP message") {
 			lm.SendLog(lm.BuildLog(
@@ -600,8 +572,9 @@ func (h *TelnyxHandler) SendMMS(mms *MsgQueueItem) (string, error) {
 				logrus.ErrorLevel,
 				map[string]interface{}{
 					"logID":           mms.LogID,
-					"response_code":   resp.StatusCode,
-					"response_status": resp.Status,
+					"traceID":         mms.TraceID,
+					"response_code":   statusCode,
+					"response_status": http.StatusText(statusCode),
 					"to":              mms.To,
 					"from":            mms.From,
 					"response_body":   string(bodyBytes), // Include response body for debugging
@@ -616,14 +589,15 @@ func (h *TelnyxHandler) SendMMS(mms *MsgQueueItem) (string, error) {
 			logrus.ErrorLevel,
 			map[string]interface{}{
 				"logID":           mms.LogID,
-				"response_code":   resp.StatusCode,
-				"response_status": resp.Status,
+				"traceID":         mms.TraceID,
+				"response_code":   statusCode,
+				"response_status": http.StatusText(statusCode),
 				"to":              mms.To,
 				"from":            mms.From,
 				"response_body":   string(bodyBytes), // Include response body for debugging
 			}, nil,
 		))
-		return "", errors.New("failed to send MMS via Telnyx")
+		return "", carrierRejected(statusCode, errors.New("failed to send MMS via Telnyx"))
 	}
 
 	// Optionally, parse the response to get message ID
@@ -634,7 +608,8 @@ func (h *TelnyxHandler) SendMMS(mms *MsgQueueItem) (string, error) {
 			"Failed to unmarshal response: %v",
 			logrus.ErrorLevel,
 			map[string]interface{}{
-				"logID": mms.LogID,
+				"logID":   mms.LogID,
+				"traceID": mms.TraceID,
 			}, err,
 		))
 		return "", err
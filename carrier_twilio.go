@@ -1,11 +1,15 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	"os"
+	"net/url"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -13,6 +17,7 @@ import (
 	"github.com/kataras/iris/v12"
 	"github.com/sirupsen/logrus"
 	"github.com/twilio/twilio-go"
+	twilioClient "github.com/twilio/twilio-go/client"
 	twilioApi "github.com/twilio/twilio-go/rest/api/v2010"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -47,6 +52,47 @@ func (h *TwilioHandler) Inbound(c iris.Context) error {
 
 	// Initialize logging with a unique transaction ID
 	transId := primitive.NewObjectID().Hex()
+	traceID := newTraceID()
+	received := time.Now()
+
+	if h.gateway.Config.TwilioValidateSignature {
+		requestURL := twilioRequestURL(c, h.gateway.Config.ServerAddress)
+		if err := c.Request().ParseForm(); err != nil || !validateTwilioSignature(h.password, requestURL, c.Request().PostForm, c.GetHeader("X-Twilio-Signature")) {
+			lm.SendLog(lm.BuildLog(
+				"Carrier.Twilio.Inbound",
+				"AuthFailed",
+				logrus.WarnLevel,
+				map[string]interface{}{
+					"logID":      transId,
+					"carrier":    h.carrier.Name,
+					"requestURL": requestURL,
+					"client_ip":  c.RemoteAddr(),
+					"hasHeader":  c.GetHeader("X-Twilio-Signature") != "",
+				}, err,
+			))
+			c.StatusCode(http.StatusForbidden)
+			return nil
+		}
+	}
+
+	// Status callback for a message we sent (see twilioStatusCallbackURL)
+	if status := c.FormValue("MessageStatus"); status != "" && status != "received" {
+		messageSid := c.FormValue("MessageSid")
+		switch status {
+		case "sent":
+			h.gateway.handleCarrierStatus(h.carrier.Name, messageSid, CarrierStatusSent, "")
+		case "delivered":
+			h.gateway.handleCarrierStatus(h.carrier.Name, messageSid, CarrierStatusDelivered, "")
+		case "undelivered", "failed":
+			detail := status
+			if code := c.FormValue("ErrorCode"); code != "" {
+				detail = status + " (" + code + ")"
+			}
+			h.gateway.handleCarrierStatus(h.carrier.Name, messageSid, CarrierStatusFailed, detail)
+		}
+		c.StatusCode(http.StatusOK)
+		return nil
+	}
 
 	// Parse the number of media items
 	numMediaStr := c.FormValue("NumMedia")
@@ -61,12 +107,30 @@ func (h *TwilioHandler) Inbound(c iris.Context) error {
 	body := c.FormValue("Body")
 	messageSid := c.FormValue("MessageSid")
 
+	if h.gateway.isDuplicateInbound(h.carrier.Name, messageSid) {
+		lm.SendLog(lm.BuildLog(
+			"Carrier.Twilio.Inbound",
+			"DuplicateInbound",
+			logrus.InfoLevel,
+			map[string]interface{}{
+				"logID":     transId,
+				"carrierID": messageSid,
+				"from":      from,
+				"to":        to,
+			},
+		))
+		c.Header("Content-Type", "application/xml")
+		_, err = c.Write([]byte("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<Response>\n</Response>"))
+		return err
+	}
+
 	var files []MsgFile
 
 	// Fetch media files if present
 	if numMedia > 0 {
 		ff := h.fetchMediaFiles(c, numMedia, messageSid)
 		if len(ff) <= 0 {
+			h.gateway.forgetInbound(h.carrier.Name, messageSid)
 			c.StatusCode(http.StatusBadRequest)
 			return nil
 		}
@@ -84,15 +148,19 @@ func (h *TwilioHandler) Inbound(c iris.Context) error {
 		msg := MsgQueueItem{
 			To:                to,
 			From:              from,
-			ReceivedTimestamp: time.Now(),
+			ReceivedTimestamp: received,
+			QueuedTimestamp:   received,
 			Type:              MsgQueueItemType.MMS,
 			files:             files,
 			SkipNumberCheck:   false,
 			LogID:             transId,
+			TraceID:           traceID,
 			SourceCarrier:     h.carrier.Name,
 			OriginalSizeBytes: originalSizeBytes,
 		}
-		h.gateway.Router.CarrierMsgChan <- msg
+		msg.Priority = h.gateway.msgPriorityFor(msg.To, msg.Type)
+		h.gateway.Router.carrierQueue(msg.Priority) <- msg
+		h.gateway.recordMessage("inbound", msg.Type, h.Name(), MetricResultReceived)
 	}
 
 	// Handle SMS if body is present
@@ -102,13 +170,17 @@ func (h *TwilioHandler) Inbound(c iris.Context) error {
 			sms := MsgQueueItem{
 				To:                to,
 				From:              from,
-				ReceivedTimestamp: time.Now(),
+				ReceivedTimestamp: received,
+				QueuedTimestamp:   received,
 				Type:              MsgQueueItemType.SMS,
 				message:           smsBody,
 				LogID:             transId,
+				TraceID:           traceID,
 				SourceCarrier:     h.carrier.Name,
 			}
-			h.gateway.Router.CarrierMsgChan <- sms
+			sms.Priority = h.gateway.msgPriorityFor(sms.To, sms.Type)
+			h.gateway.Router.carrierQueue(sms.Priority) <- sms
+			h.gateway.recordMessage("inbound", sms.Type, h.Name(), MetricResultReceived)
 		}
 	}
 
@@ -138,6 +210,72 @@ func (h *TwilioHandler) Inbound(c iris.Context) error {
 	return nil
 }
 
+// statusCallbackURL is where Twilio posts delivery status for messages we
+// send: this carrier's inbound webhook. Without SERVER_ADDRESS there is no
+// public URL, so no callback is requested.
+func (h *TwilioHandler) statusCallbackURL() string {
+	base := h.gateway.Config.ServerAddress
+	if base == "" || h.carrier == nil {
+		return ""
+	}
+	return base + "/inbound/" + h.carrier.UUID
+}
+
+// twilioRequestURL reconstructs the public URL Twilio posted to. The
+// SERVER_ADDRESS base is preferred since the gateway usually sits behind a
+// proxy that rewrites Host.
+func twilioRequestURL(c iris.Context, base string) string {
+	uri := c.Request().URL.RequestURI()
+	if base != "" {
+		return base + uri
+	}
+
+	scheme := "http"
+	if c.Request().TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + c.Request().Host + uri
+}
+
+// computeTwilioSignature implements Twilio's webhook signing algorithm: the full
+// URL followed by each POST parameter name and value sorted by name, signed
+// with HMAC-SHA1 using the account auth token and base64 encoded.
+func computeTwilioSignature(authToken, requestURL string, params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(requestURL)
+	for _, k := range keys {
+		values := append([]string(nil), params[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			b.WriteString(k)
+			b.WriteString(v)
+		}
+	}
+
+	mac := hmac.New(sha1.New, []byte(authToken))
+	mac.Write([]byte(b.String()))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// validateTwilioSignature reports whether the X-Twilio-Signature header matches
+// the expected signature for the request.
+func validateTwilioSignature(authToken, requestURL string, params url.Values, signature string) bool {
+	if authToken == "" || signature == "" {
+		return false
+	}
+	expected := computeTwilioSignature(authToken, requestURL, params)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
 // fetchMediaFiles retrieves media files from Twilio and returns a slice of MsgFile structs.
 // Uses the carrier's stored credentials (Account SID + Auth Token) for Basic Auth.
 func (h *TwilioHandler) fetchMediaFiles(c iris.Context, numMedia int, messageSid string) []MsgFile {
@@ -157,20 +295,10 @@ func (h *TwilioHandler) fetchMediaFiles(c iris.Context, numMedia int, messageSid
 		extension := parts[1]
 		filename := fmt.Sprintf("%s.%s", mediaSid, extension)
 
-		// Fetch the media content
-		req, err := http.NewRequest("GET", mediaURL, nil)
-		if err != nil {
-			continue
-		}
-
-		// Use the carrier's stored credentials for auth
-		req.SetBasicAuth(h.username, h.password)
-
-		// Perform the request
-		client := &http.Client{
-			Timeout: 30 * time.Second,
-		}
-		resp, err := client.Do(req)
+		// Fetch the media content with the carrier's stored credentials
+		contentBytes, _, err := h.gateway.fetchMedia(h.carrier, mediaURL, func(req *http.Request) {
+			req.SetBasicAuth(h.username, h.password)
+		})
 		if err != nil {
 			lm.SendLog(lm.BuildLog(
 				"Carrier.FetchMedia.Twilio",
@@ -178,32 +306,11 @@ func (h *TwilioHandler) fetchMediaFiles(c iris.Context, numMedia int, messageSid
 				logrus.ErrorLevel,
 				map[string]interface{}{
 					"logID": messageSid,
+					"error": err.Error(),
 				}, mediaURL,
 			))
 			continue
 		}
-		defer resp.Body.Close()
-
-		// Check for successful response
-		if resp.StatusCode != http.StatusOK {
-			lm.SendLog(lm.BuildLog(
-				"Carrier.FetchMedia.Twilio",
-				"CarrierFetchMediaNonOK",
-				logrus.ErrorLevel,
-				map[string]interface{}{
-					"logID":      messageSid,
-					"statusCode": resp.StatusCode,
-					"mediaURL":   mediaURL,
-				},
-			))
-			continue
-		}
-
-		// Read the content into memory
-		contentBytes, err := io.ReadAll(resp.Body)
-		if err != nil {
-			continue
-		}
 
 		// Create the MsgFile struct
 		file := MsgFile{
@@ -245,6 +352,40 @@ func splitSMS(body string, maxBytes int) []string {
 	return smsSegments
 }
 
+// createMessage calls the Messages API through the carrier breaker,
+// retrying connection failures and 429.
+func (h *TwilioHandler) createMessage(params *twilioApi.CreateMessageParams) (*twilioApi.ApiV2010Message, error) {
+	var msg *twilioApi.ApiV2010Message
+	err := h.gateway.carrierCall(h.carrier.Name, func() (carrierAttempt, error) {
+		var err error
+		msg, err = h.client.Api.CreateMessage(params)
+		var apiErr *twilioClient.TwilioRestError
+		if errors.As(err, &apiErr) {
+			err = carrierRejected(apiErr.Status, err)
+		}
+		if err != nil {
+			return twilioAttempt(err), err
+		}
+		return attemptAnswered, nil
+	})
+	return msg, err
+}
+
+// twilioAttempt classifies a Twilio SDK error. API errors carry the HTTP
+// status; anything else failed before a response.
+func twilioAttempt(err error) carrierAttempt {
+	var apiErr *twilioClient.TwilioRestError
+	if errors.As(err, &apiErr) {
+		return carrierStatusAttempt(apiErr.Status)
+	}
+	return carrierErrAttempt(err)
+}
+
+// SupportsAlphaSender reports that the Twilio API accepts alphanumeric sender IDs.
+func (h *TwilioHandler) SupportsAlphaSender() bool {
+	return true
+}
+
 // SendSMS sends an SMS message via the Twilio API.
 func (h *TwilioHandler) SendSMS(sms *MsgQueueItem) (string, error) {
 	lm := h.gateway.LogManager
@@ -253,17 +394,21 @@ func (h *TwilioHandler) SendSMS(sms *MsgQueueItem) (string, error) {
 	params.SetTo(sms.To)
 	params.SetFrom(sms.From)
 	params.SetBody(sms.message)
+	if callback := h.statusCallbackURL(); callback != "" {
+		params.SetStatusCallback(callback)
+	}
 
-	msg, err := h.client.Api.CreateMessage(params)
+	msg, err := h.createMessage(params)
 	if err != nil {
 		lm.SendLog(lm.BuildLog(
 			"Carrier.SendSMS.Twilio",
 			"Failed to send SMS to Carrier",
 			logrus.ErrorLevel,
 			map[string]interface{}{
-				"logID": sms.LogID,
-				"to":    sms.To,
-				"from":  sms.From,
+				"logID":   sms.LogID,
+				"traceID": sms.TraceID,
+				"to":      sms.To,
+				"from":    sms.From,
 			}, err,
 		))
 		return "", err
@@ -286,6 +431,9 @@ func (h *TwilioHandler) SendMMS(mms *MsgQueueItem) (string, error) {
 	params.SetTo(mms.To)
 	params.SetFrom(mms.From)
 	params.SetBody("") // MMS body (Twilio uses empty body with media)
+	if callback := h.statusCallbackURL(); callback != "" {
+		params.SetStatusCallback(callback)
+	}
 
 	var mediaUrls []string
 
@@ -302,27 +450,29 @@ func (h *TwilioHandler) SendMMS(mms *MsgQueueItem) (string, error) {
 					"SaveMediaError",
 					logrus.ErrorLevel,
 					map[string]interface{}{
-						"logID": mms.LogID,
+						"logID":   mms.LogID,
+						"traceID": mms.TraceID,
 					}, err,
 				))
 				return "", err
 			}
 
-			mediaUrls = append(mediaUrls, os.Getenv("SERVER_ADDRESS")+"/media/"+accessToken)
+			mediaUrls = append(mediaUrls, h.gateway.Config.ServerAddress+"/media/"+accessToken)
 		}
 		params.MediaUrl = &mediaUrls
 	}
 
-	msg, err := h.client.Api.CreateMessage(params)
+	msg, err := h.createMessage(params)
 	if err != nil {
 		lm.SendLog(lm.BuildLog(
 			"Carrier.SendMMS.Twilio",
 			"Failed to send MMS to Carrier",
 			logrus.ErrorLevel,
 			map[string]interface{}{
-				"logID": mms.LogID,
-				"to":    mms.To,
-				"from":  mms.From,
+				"logID":   mms.LogID,
+				"traceID": mms.TraceID,
+				"to":      mms.To,
+				"from":    mms.From,
 			}, err,
 		))
 		return "", err
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"net/url"
 	"strings"
 	"testing"
 
@@ -54,3 +55,35 @@ func TestSplitSMS_PreservesOrder(t *testing.T) {
 	got := splitSMS(strings.Repeat("a", 20), 7)
 	assert.Equal(t, []string{strings.Repeat("a", 7), strings.Repeat("a", 7), strings.Repeat("a", 6)}, got)
 }
+
+// twilioDocParams is the worked example from Twilio's webhook security docs.
+func twilioDocParams() url.Values {
+	return url.Values{
+		"CallSid": {"CA1234567890ABCDE"},
+		"Caller":  {"+14158675310"},
+		"Digits":  {"1234"},
+		"From":    {"+14158675310"},
+		"To":      {"+18005551212"},
+	}
+}
+
+const twilioDocURL = "https://mycompany.com/myapp.php?foo=1&bar=2"
+
+func TestComputeTwilioSignature_MatchesDocsExample(t *testing.T) {
+	got := computeTwilioSignature("12345", twilioDocURL, twilioDocParams())
+	assert.Equal(t, "GvWf1cFY/Q7PnoempGyD5oXAezc=", got)
+}
+
+func TestValidateTwilioSignature(t *testing.T) {
+	params := twilioDocParams()
+	assert.True(t, validateTwilioSignature("12345", twilioDocURL, params, "GvWf1cFY/Q7PnoempGyD5oXAezc="))
+
+	// Wrong token, tampered params, different URL, or missing header all fail.
+	assert.False(t, validateTwilioSignature("54321", twilioDocURL, params, "GvWf1cFY/Q7PnoempGyD5oXAezc="))
+	tampered := twilioDocParams()
+	tampered.Set("Digits", "9999")
+	assert.False(t, validateTwilioSignature("12345", twilioDocURL, tampered, "GvWf1cFY/Q7PnoempGyD5oXAezc="))
+	assert.False(t, validateTwilioSignature("12345", "https://mycompany.com/other", params, "GvWf1cFY/Q7PnoempGyD5oXAezc="))
+	assert.False(t, validateTwilioSignature("12345", twilioDocURL, params, ""))
+	assert.False(t, validateTwilioSignature("", twilioDocURL, params, "GvWf1cFY/Q7PnoempGyD5oXAezc="))
+}
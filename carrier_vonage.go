@@ -0,0 +1,502 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/kataras/iris/v12"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const vonageMessagesURL = "https://api.nexmo.com/v1/messages"
+
+// VonageHandler implements CarrierHandler for the Vonage (Nexmo) Messages API.
+// The carrier's username is the Vonage application ID and its password is the
+// application's PEM private key, used to sign request JWTs.
+type VonageHandler struct {
+	BaseCarrierHandler
+	gateway       *Gateway
+	carrier       *Carrier
+	applicationID string
+	privateKey    *rsa.PrivateKey
+	keyErr        error
+}
+
+// NewVonageHandler initializes a new VonageHandler
+func NewVonageHandler(gateway *Gateway, carrier *Carrier, decryptedUsername string, decryptedPassword string) *VonageHandler {
+	key, err := parseVonagePrivateKey(decryptedPassword)
+	return &VonageHandler{
+		BaseCarrierHandler: BaseCarrierHandler{name: "vonage"},
+		gateway:            gateway,
+		carrier:            carrier,
+		applicationID:      decryptedUsername,
+		privateKey:         key,
+		keyErr:             err,
+	}
+}
+
+// VonageMessage is the outbound Messages API request body.
+type VonageMessage struct {
+	MessageType string       `json:"message_type"`
+	Channel     string       `json:"channel"`
+	To          string       `json:"to"`
+	From        string       `json:"from"`
+	Text        string       `json:"text,omitempty"`
+	Image       *VonageMedia `json:"image,omitempty"`
+	Audio       *VonageMedia `json:"audio,omitempty"`
+	Video       *VonageMedia `json:"video,omitempty"`
+	File        *VonageMedia `json:"file,omitempty"`
+	ClientRef   string       `json:"client_ref,omitempty"`
+}
+
+// VonageMedia is a media object in Messages API requests and webhooks.
+type VonageMedia struct {
+	URL     string `json:"url"`
+	Caption string `json:"caption,omitempty"`
+	Name    string `json:"name,omitempty"`
+}
+
+// VonageResponse represents the response from the Messages API
+type VonageResponse struct {
+	MessageUUID string `json:"message_uuid"`
+}
+
+// VonageWebhookPayload covers both inbound (message-received) and status
+// webhooks; Status is only set on the latter.
+type VonageWebhookPayload struct {
+	Channel     string       `json:"channel"`
+	MessageUUID string       `json:"message_uuid"`
+	To          string       `json:"to"`
+	From        string       `json:"from"`
+	Timestamp   string       `json:"timestamp"`
+	MessageType string       `json:"message_type"`
+	Text        string       `json:"text"`
+	Image       *VonageMedia `json:"image,omitempty"`
+	Audio       *VonageMedia `json:"audio,omitempty"`
+	Video       *VonageMedia `json:"video,omitempty"`
+	File        *VonageMedia `json:"file,omitempty"`
+	Status      string       `json:"status,omitempty"`
+}
+
+// media returns the attachments of an inbound message.
+func (p *VonageWebhookPayload) media() []*VonageMedia {
+	var media []*VonageMedia
+	for _, m := range []*VonageMedia{p.Image, p.Audio, p.Video, p.File} {
+		if m != nil && m.URL != "" {
+			media = append(media, m)
+		}
+	}
+	return media
+}
+
+// caption returns the text of an inbound message, which for MMS is carried
+// as a caption on the media object.
+func (p *VonageWebhookPayload) caption() string {
+	if p.Text != "" {
+		return p.Text
+	}
+	for _, m := range p.media() {
+		if m.Caption != "" {
+			return m.Caption
+		}
+	}
+	return ""
+}
+
+// Inbound handles incoming Vonage message and status webhooks.
+func (h *VonageHandler) Inbound(c iris.Context) error {
+	var lm = h.gateway.LogManager
+
+	var payload VonageWebhookPayload
+	if err := c.ReadJSON(&payload); err != nil {
+		lm.SendLog(lm.BuildLog(
+			"Carrier.Inbound.Vonage",
+			"GenericError",
+			logrus.ErrorLevel,
+			nil,
+			err,
+		))
+		c.StatusCode(http.StatusBadRequest)
+		return nil
+	}
+
+	if payload.Status != "" {
+		switch payload.Status {
+		case "submitted":
+			h.gateway.handleCarrierStatus(h.carrier.Name, payload.MessageUUID, CarrierStatusSent, "")
+		case "delivered", "read":
+			h.gateway.handleCarrierStatus(h.carrier.Name, payload.MessageUUID, CarrierStatusDelivered, "")
+		case "rejected", "undeliverable":
+			h.gateway.handleCarrierStatus(h.carrier.Name, payload.MessageUUID, CarrierStatusFailed, payload.Status)
+		}
+		c.StatusCode(http.StatusOK)
+		return nil
+	}
+
+	if payload.To == "" || payload.From == "" {
+		lm.SendLog(lm.BuildLog(
+			"Carrier.Inbound.Vonage",
+			"CarrierNoDestinations",
+			logrus.ErrorLevel,
+			map[string]interface{}{
+				"carrierID": payload.MessageUUID,
+			},
+		))
+		c.StatusCode(http.StatusBadRequest)
+		return nil
+	}
+
+	from := payload.From
+	to := payload.To
+	body := payload.caption()
+	messageID := payload.MessageUUID
+	media := payload.media()
+
+	var files []MsgFile
+	if len(media) > 0 {
+		files = h.fetchVonageMediaFiles(media, messageID)
+		if len(files) <= 0 {
+			c.StatusCode(http.StatusBadRequest)
+			return nil
+		}
+	}
+
+	logID := primitive.NewObjectID().Hex()
+	traceID := newTraceID()
+	received := time.Now()
+
+	if len(files) > 0 {
+		var originalSizeBytes int
+		for _, f := range files {
+			originalSizeBytes += len(f.Content)
+		}
+
+		msg := MsgQueueItem{
+			To:                to,
+			From:              from,
+			ReceivedTimestamp: received,
+			QueuedTimestamp:   received,
+			Type:              MsgQueueItemType.MMS,
+			files:             files,
+			LogID:             logID,
+			TraceID:           traceID,
+			SourceCarrier:     h.carrier.Name,
+			OriginalSizeBytes: originalSizeBytes,
+		}
+		msg.Priority = h.gateway.msgPriorityFor(msg.To, msg.Type)
+		h.gateway.Router.carrierQueue(msg.Priority) <- msg
+		h.gateway.recordMessage("inbound", msg.Type, h.Name(), MetricResultReceived)
+	}
+
+	if strings.TrimSpace(body) != "" {
+		sms := MsgQueueItem{
+			To:                to,
+			From:              from,
+			ReceivedTimestamp: received,
+			QueuedTimestamp:   received,
+			Type:              MsgQueueItemType.SMS,
+			message:           body,
+			LogID:             logID,
+			TraceID:           traceID,
+			SourceCarrier:     h.carrier.Name,
+		}
+		sms.Priority = h.gateway.msgPriorityFor(sms.To, sms.Type)
+		h.gateway.Router.carrierQueue(sms.Priority) <- sms
+		h.gateway.recordMessage("inbound", sms.Type, h.Name(), MetricResultReceived)
+	}
+
+	lm.SendLog(lm.BuildLog(
+		"Carrier.Vonage.Inbound",
+		"received",
+		logrus.InfoLevel,
+		map[string]interface{}{
+			"logID":     logID,
+			"carrierID": messageID,
+			"from":      from,
+			"to":        to,
+		}, nil,
+	))
+
+	c.StatusCode(http.StatusOK)
+	return nil
+}
+
+// fetchVonageMediaFiles downloads inbound media using the application JWT.
+func (h *VonageHandler) fetchVonageMediaFiles(media []*VonageMedia, messageID string) []MsgFile {
+	lm := h.gateway.LogManager
+	var files []MsgFile
+
+	for _, m := range media {
+		content, contentType, err := h.fetchMediaContent(m.URL)
+		if err != nil {
+			lm.SendLog(lm.BuildLog(
+				"Carrier.FetchMedia.Vonage",
+				"CarrierFetchMediaError",
+				logrus.ErrorLevel,
+				map[string]interface{}{
+					"logID":    messageID,
+					"mediaURL": m.URL,
+				}, err,
+			))
+			continue
+		}
+
+		filename := m.Name
+		if filename == "" {
+			filename = path.Base(m.URL) + getExtensionForContentType(contentType)
+		}
+
+		files = append(files, MsgFile{
+			Filename:    filename,
+			ContentType: contentType,
+			Content:     content,
+		})
+	}
+	return files
+}
+
+func (h *VonageHandler) fetchMediaContent(mediaURL string) ([]byte, string, error) {
+	token, err := h.generateJWT()
+	if err != nil {
+		return nil, "", err
+	}
+
+	content, contentType, err := h.gateway.fetchMedia(h.carrier, mediaURL, func(req *http.Request) {
+		req.Header.Set("Authorization", "Bearer "+token)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	contentType = strings.TrimSpace(strings.Split(contentType, ";")[0])
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = mimetype.Detect(content).String()
+	}
+	return content, contentType, nil
+}
+
+// SupportsAlphaSender reports that the Vonage Messages API accepts alphanumeric sender IDs.
+func (h *VonageHandler) SupportsAlphaSender() bool {
+	return true
+}
+
+// SendSMS sends an SMS message via the Vonage Messages API.
+func (h *VonageHandler) SendSMS(sms *MsgQueueItem) (string, error) {
+	return h.send(VonageMessage{
+		MessageType: "text",
+		Channel:     "sms",
+		To:          strings.TrimPrefix(sms.To, "+"),
+		From:        strings.TrimPrefix(sms.From, "+"),
+		Text:        sms.message,
+		ClientRef:   sms.LogID,
+	}, sms, "Carrier.SendSMS.Vonage")
+}
+
+// SendMMS sends an MMS via the Vonage Messages API. The API takes one media
+// object per message, so each attachment is sent separately; the UUID of the
+// first message is returned.
+func (h *VonageHandler) SendMMS(mms *MsgQueueItem) (string, error) {
+	var lm = h.gateway.LogManager
+
+	var firstID string
+	caption := mms.message
+	for _, f := range mms.files {
+		if strings.Contains(f.ContentType, "application/smil") {
+			continue
+		}
+
+		accessToken, err := h.gateway.saveMsgFileMedia(f)
+		if err != nil {
+			lm.SendLog(lm.BuildLog(
+				"Carrier.SendMMS.Vonage",
+				"SaveMediaError",
+				logrus.ErrorLevel,
+				map[string]interface{}{
+					"logID":   mms.LogID,
+					"traceID": mms.TraceID,
+				}, err,
+			))
+			return "", err
+		}
+
+		message := VonageMessage{
+			Channel:   "mms",
+			To:        strings.TrimPrefix(mms.To, "+"),
+			From:      strings.TrimPrefix(mms.From, "+"),
+			ClientRef: mms.LogID,
+		}
+		media := &VonageMedia{
+			URL:     getMediaUrlWithExtension(h.gateway.Config.ServerAddress, accessToken, f.ContentType),
+			Caption: caption,
+		}
+		switch {
+		case strings.HasPrefix(f.ContentType, "image/"):
+			message.MessageType, message.Image = "image", media
+		case strings.HasPrefix(f.ContentType, "audio/"):
+			message.MessageType, message.Audio = "audio", media
+		case strings.HasPrefix(f.ContentType, "video/"):
+			message.MessageType, message.Video = "video", media
+		default:
+			media.Name = f.Filename
+			message.MessageType, message.File = "file", media
+		}
+		caption = "" // only the first attachment carries the text
+
+		id, err := h.send(message, mms, "Carrier.SendMMS.Vonage")
+		if err != nil {
+			return "", err
+		}
+		if firstID == "" {
+			firstID = id
+		}
+	}
+
+	if firstID == "" {
+		return "", errors.New("no media to send via Vonage")
+	}
+	return firstID, nil
+}
+
+// send POSTs one message to the Messages API and returns its message_uuid.
+func (h *VonageHandler) send(message VonageMessage, item *MsgQueueItem, logModule string) (string, error) {
+	var lm = h.gateway.LogManager
+
+	token, err := h.generateJWT()
+	if err != nil {
+		lm.SendLog(lm.BuildLog(
+			logModule,
+			"JWTError",
+			logrus.ErrorLevel,
+			map[string]interface{}{
+				"logID":   item.LogID,
+				"traceID": item.TraceID,
+			}, err,
+		))
+		return "", err
+	}
+
+	payloadBytes, err := json.Marshal(message)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest("POST", vonageMessagesURL, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(traceIDHeader, item.TraceID)
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		lm.SendLog(lm.BuildLog(
+			logModule,
+			"Failed to send request: %v",
+			logrus.ErrorLevel,
+			map[string]interface{}{
+				"logID":   item.LogID,
+				"traceID": item.TraceID,
+			}, err,
+		))
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		lm.SendLog(lm.BuildLog(
+			logModule,
+			"Failed to send message to Carrier",
+			logrus.ErrorLevel,
+			map[string]interface{}{
+				"logID":           item.LogID,
+				"traceID":         item.TraceID,
+				"response_code":   resp.StatusCode,
+				"response_status": resp.Status,
+				"to":              item.To,
+				"from":            item.From,
+				"response_body":   string(bodyBytes),
+			},
+		))
+		return "", carrierRejected(resp.StatusCode, fmt.Errorf("failed to send %s via Vonage", message.MessageType))
+	}
+
+	var vonageResp VonageResponse
+	if err := json.Unmarshal(bodyBytes, &vonageResp); err != nil {
+		return "", err
+	}
+	return vonageResp.MessageUUID, nil
+}
+
+// generateJWT returns a short-lived RS256 application JWT.
+func (h *VonageHandler) generateJWT() (string, error) {
+	if h.keyErr != nil {
+		return "", h.keyErr
+	}
+
+	now := time.Now().Unix()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, err := json.Marshal(map[string]interface{}{
+		"application_id": h.applicationID,
+		"iat":            now,
+		"exp":            now + 900,
+		"jti":            primitive.NewObjectID().Hex(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	signingInput := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, h.privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + enc.EncodeToString(signature), nil
+}
+
+// parseVonagePrivateKey decodes a PKCS#8 or PKCS#1 PEM RSA private key.
+func parseVonagePrivateKey(keyPEM string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, errors.New("vonage private key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse vonage private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("vonage private key is not RSA")
+	}
+	return key, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testVonageKeyPEM(t *testing.T) (*rsa.PrivateKey, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return key, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func TestVonageHandler_GenerateJWT(t *testing.T) {
+	key, keyPEM := testVonageKeyPEM(t)
+	h := NewVonageHandler(nil, &Carrier{Name: "vonage"}, "app-123", keyPEM)
+	require.NoError(t, h.keyErr)
+
+	token, err := h.generateJWT()
+	require.NoError(t, err)
+	parts := strings.Split(token, ".")
+	require.Len(t, parts, 3)
+
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	require.NoError(t, err)
+	var claims map[string]interface{}
+	require.NoError(t, json.Unmarshal(claimsJSON, &claims))
+	assert.Equal(t, "app-123", claims["application_id"])
+	assert.NotEmpty(t, claims["jti"])
+	assert.Greater(t, claims["exp"].(float64), claims["iat"].(float64))
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(t, err)
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	assert.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig))
+}
+
+func TestVonageHandler_InvalidKey(t *testing.T) {
+	h := NewVonageHandler(nil, &Carrier{Name: "vonage"}, "app-123", "not a key")
+	_, err := h.generateJWT()
+	assert.Error(t, err)
+}
+
+func TestVonageWebhookPayload_MediaAndCaption(t *testing.T) {
+	var p VonageWebhookPayload
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"channel": "mms",
+		"message_uuid": "aaaaaaaa-bbbb-cccc-dddd-0123456789ab",
+		"to": "15551230000",
+		"from": "15559870000",
+		"message_type": "image",
+		"image": {"url": "https://api.nexmo.com/v3/media/abc", "caption": "look"}
+	}`), &p))
+
+	media := p.media()
+	require.Len(t, media, 1)
+	assert.Equal(t, "https://api.nexmo.com/v3/media/abc", media[0].URL)
+	assert.Equal(t, "look", p.caption())
+	assert.Empty(t, p.Status)
+
+	var text VonageWebhookPayload
+	require.NoError(t, json.Unmarshal([]byte(`{"message_type":"text","text":"hi","to":"1","from":"2"}`), &text))
+	assert.Empty(t, text.media())
+	assert.Equal(t, "hi", text.caption())
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net"
+	"strings"
+)
+
+// clientAddressRule is one entry of a client's comma-separated Address:
+// a single IP, a CIDR block, or a hostname (matched literally).
+type clientAddressRule struct {
+	raw     string
+	ip      net.IP
+	network *net.IPNet
+}
+
+// parseClientAddress splits a client Address such as
+// "203.0.113.10, 198.51.100.0/28" into match rules. Empty entries are skipped.
+func parseClientAddress(address string) []clientAddressRule {
+	var rules []clientAddressRule
+	for _, entry := range strings.Split(address, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		rule := clientAddressRule{raw: entry}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			rule.network = network
+		} else {
+			rule.ip = net.ParseIP(entry)
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func (r clientAddressRule) matches(ip string, parsed net.IP) bool {
+	switch {
+	case r.network != nil:
+		return parsed != nil && r.network.Contains(parsed)
+	case r.ip != nil:
+		return parsed != nil && r.ip.Equal(parsed)
+	}
+	return r.raw == ip
+}
+
+// parseAddressRules (re)builds the client's address rules from Address.
+func (c *Client) parseAddressRules() {
+	c.addressRules = parseClientAddress(c.Address)
+}
+
+// matchAddress reports whether ip is allowed by the client's Address, and
+// returns the entry that matched.
+func (c *Client) matchAddress(ip string) (string, bool) {
+	rules := c.addressRules
+	if rules == nil {
+		rules = parseClientAddress(c.Address)
+	}
+	parsed := net.ParseIP(ip)
+	for _, rule := range rules {
+		if rule.matches(ip, parsed) {
+			return rule.raw, true
+		}
+	}
+	return "", false
+}
+
+// deliveryHost returns the address MM4 deliveries are sent to: the first
+// entry of Address that is not a CIDR block.
+func (c *Client) deliveryHost() string {
+	for _, rule := range parseClientAddress(c.Address) {
+		if rule.network == nil {
+			return rule.raw
+		}
+	}
+	return ""
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_MatchAddress(t *testing.T) {
+	c := &Client{Address: "203.0.113.10, 198.51.100.0/28 ,2001:db8::/32,pbx.example.com"}
+	c.parseAddressRules()
+
+	rule, ok := c.matchAddress("203.0.113.10")
+	assert.True(t, ok)
+	assert.Equal(t, "203.0.113.10", rule)
+
+	rule, ok = c.matchAddress("198.51.100.14")
+	assert.True(t, ok)
+	assert.Equal(t, "198.51.100.0/28", rule)
+
+	rule, ok = c.matchAddress("2001:db8::1")
+	assert.True(t, ok)
+	assert.Equal(t, "2001:db8::/32", rule)
+
+	_, ok = c.matchAddress("198.51.100.16")
+	assert.False(t, ok, "outside the /28")
+	_, ok = c.matchAddress("203.0.113.11")
+	assert.False(t, ok)
+
+	rule, ok = c.matchAddress("pbx.example.com")
+	assert.True(t, ok, "hostnames still match literally")
+	assert.Equal(t, "pbx.example.com", rule)
+}
+
+func TestClient_MatchAddress_SingleIPUnchanged(t *testing.T) {
+	// Clients built without parseAddressRules (e.g. before a reload) still match.
+	c := &Client{Address: "192.168.1.10"}
+
+	rule, ok := c.matchAddress("192.168.1.10")
+	assert.True(t, ok)
+	assert.Equal(t, "192.168.1.10", rule)
+
+	_, ok = c.matchAddress("192.168.1.100")
+	assert.False(t, ok)
+
+	_, ok = (&Client{}).matchAddress("192.168.1.10")
+	assert.False(t, ok, "empty address matches nothing")
+}
+
+func TestClient_DeliveryHost(t *testing.T) {
+	assert.Equal(t, "192.168.1.10", (&Client{Address: "192.168.1.10"}).deliveryHost())
+	assert.Equal(t, "mx.example.com", (&Client{Address: "10.0.0.0/8, mx.example.com, 10.1.1.1"}).deliveryHost())
+	assert.Equal(t, "", (&Client{Address: "10.0.0.0/8"}).deliveryHost())
+}
+
+func TestMM4Server_GetClientByIP(t *testing.T) {
+	_, gw := newTestRouter(1)
+	gw.Clients = map[string]*Client{
+		"pbx1": {Username: "pbx1", Address: "10.0.0.5"},
+		"pbx2": {Username: "pbx2", Address: "172.16.0.0/24, 172.16.1.9"},
+	}
+	s := &MM4Server{gateway: gw}
+
+	client, rule := s.getClientByIP("172.16.0.77")
+	if assert.NotNil(t, client) {
+		assert.Equal(t, "pbx2", client.Username)
+	}
+	assert.Equal(t, "172.16.0.0/24", rule)
+
+	client, _ = s.getClientByIP("10.0.0.5")
+	if assert.NotNil(t, client) {
+		assert.Equal(t, "pbx1", client.Username)
+	}
+
+	client, _ = s.getClientByIP("8.8.8.8")
+	assert.Nil(t, client)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// clientHistoryEntry is one message of GET /clients/{id}/messages.
+type clientHistoryEntry struct {
+	LogID       string    `json:"log_id"`
+	Direction   string    `json:"direction"`
+	Counterpart string    `json:"counterpart"` // the number on the other end
+	Type        string    `json:"type"`
+	Status      string    `json:"status"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// clientHistoryQuery selects clientID's message records created in
+// [from, to]; zero times leave that end open. The filter and the newest-first
+// order are served by idx_message_record_client_time.
+func clientHistoryQuery(db *gorm.DB, clientID uint, from, to time.Time) *gorm.DB {
+	query := db.Model(&MessageRecord{}).Where("client_id = ?", clientID)
+	if !from.IsZero() {
+		query = query.Where("created_at >= ?", from)
+	}
+	if !to.IsZero() {
+		query = query.Where("created_at <= ?", to)
+	}
+	return query
+}
+
+// newClientHistoryEntry returns record as seen by its client. The
+// counterpart is the recipient of what the client sent and the sender of
+// what it received, masked when the client's log privacy level hides numbers.
+func newClientHistoryEntry(record MessageRecord, privacyLevel string) clientHistoryEntry {
+	counterpart := record.From
+	if record.Direction == "outbound" {
+		counterpart = record.To
+	}
+	if logPrivacyRank(privacyLevel) > 0 {
+		counterpart = maskNumber(counterpart)
+	}
+	return clientHistoryEntry{
+		LogID:       record.LogID,
+		Direction:   record.Direction,
+		Counterpart: counterpart,
+		Type:        record.Type,
+		Status:      record.Status,
+		Timestamp:   record.CreatedAt,
+	}
+}
+
+// clientHistory returns one page of client's messages, newest first, and the
+// total number in range.
+func (gateway *Gateway) clientHistory(client *Client, from, to time.Time, offset, limit int) ([]clientHistoryEntry, int64, error) {
+	query := clientHistoryQuery(gateway.DB, client.ID, from, to).Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var records []MessageRecord
+	if err := query.Order("created_at DESC").Offset(offset).Limit(limit).Find(&records).Error; err != nil {
+		return nil, 0, err
+	}
+
+	privacyLevel := gateway.logPrivacyLevel(client)
+	entries := make([]clientHistoryEntry, 0, len(records))
+	for _, record := range records {
+		entries = append(entries, newClientHistoryEntry(record, privacyLevel))
+	}
+	return entries, total, nil
+}
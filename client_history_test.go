@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientHistoryQuery(t *testing.T) {
+	db := dryRunDB(t)
+	from := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 10, 2, 0, 0, 0, 0, time.UTC)
+
+	var records []MessageRecord
+	stmt := clientHistoryQuery(db, 7, from, to).Order("created_at DESC").Find(&records).Statement
+	assert.Contains(t, stmt.SQL.String(), "client_id = $1 AND created_at >= $2 AND created_at <= $3")
+	assert.Contains(t, stmt.SQL.String(), "ORDER BY created_at DESC")
+	assert.Equal(t, []interface{}{uint(7), from, to}, stmt.Vars)
+
+	stmt = clientHistoryQuery(db, 7, time.Time{}, time.Time{}).Find(&records).Statement
+	assert.NotContains(t, stmt.SQL.String(), "created_at", "no dates leaves the range open")
+}
+
+func TestNewClientHistoryEntry(t *testing.T) {
+	at := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	sent := MessageRecord{LogID: "l1", Direction: "outbound", Type: "sms", From: "+15551230000", To: "+15557654321", Status: MsgStatusDelivered, CreatedAt: at}
+	received := MessageRecord{LogID: "l2", Direction: "inbound", Type: "mms", From: "+15557654321", To: "+15551230000", Status: MsgStatusSent, CreatedAt: at}
+
+	assert.Equal(t, clientHistoryEntry{
+		LogID: "l1", Direction: "outbound", Counterpart: "+15557654321", Type: "sms", Status: MsgStatusDelivered, Timestamp: at,
+	}, newClientHistoryEntry(sent, LogPrivacyNone))
+	assert.Equal(t, "+15557654321", newClientHistoryEntry(received, LogPrivacyNone).Counterpart, "the sender of a received message")
+
+	assert.Equal(t, "+155******21", newClientHistoryEntry(sent, LogPrivacyNumbers).Counterpart)
+	assert.Equal(t, "+155******21", newClientHistoryEntry(received, LogPrivacyFull).Counterpart)
+}
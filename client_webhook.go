@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Delivery methods for legacy clients (Client.DeliveryMethod).
+const (
+	DeliveryMethodDefault = ""        // webhook if WebhookURL is set, otherwise SMPP/MM4
+	DeliveryMethodSMPP    = "smpp"    // SMS over SMPP; MMS to the webhook when configured
+	DeliveryMethodMM4     = "mm4"     // MMS over MM4; SMS to the webhook when configured
+	DeliveryMethodWebhook = "webhook" // SMS and MMS to the webhook
+)
+
+// Signature headers sent with client webhook deliveries. The signature is
+// hex(HMAC-SHA256(WebhookSecret, timestamp + "." + body)).
+const (
+	clientWebhookTimestampHeader = "X-Gateway-Timestamp"
+	clientWebhookSignatureHeader = "X-Gateway-Signature"
+)
+
+// validDeliveryMethod reports whether method is an accepted DeliveryMethod.
+func validDeliveryMethod(method string) bool {
+	switch method {
+	case DeliveryMethodDefault, DeliveryMethodSMPP, DeliveryMethodMM4, DeliveryMethodWebhook:
+		return true
+	}
+	return false
+}
+
+// usesWebhookDelivery reports whether messages of msgType for this legacy
+// client are POSTed to its WebhookURL instead of going over SMPP or MM4.
+func (c *Client) usesWebhookDelivery(msgType MsgQueueType) bool {
+	if c.Type == "web" || c.WebhookURL == "" {
+		return false
+	}
+	switch c.DeliveryMethod {
+	case DeliveryMethodSMPP:
+		return msgType == MsgQueueItemType.MMS
+	case DeliveryMethodMM4:
+		return msgType == MsgQueueItemType.SMS
+	}
+	return true
+}
+
+// clientWebhookPayload is the JSON body POSTed to a legacy client's webhook.
+type clientWebhookPayload struct {
+	LogID             string       `json:"log_id"`
+	TraceID           string       `json:"trace_id,omitempty"`
+	Type              MsgQueueType `json:"type"`
+	From              string       `json:"from_number"`
+	To                string       `json:"to_number"`
+	Text              string       `json:"text,omitempty"`
+	ReceivedTimestamp time.Time    `json:"received_timestamp"`
+	Media             []MsgFile    `json:"media,omitempty"`
+}
+
+func newClientWebhookPayload(m *MsgQueueItem) clientWebhookPayload {
+	payload := clientWebhookPayload{
+		LogID:             m.LogID,
+		TraceID:           m.TraceID,
+		Type:              m.Type,
+		From:              m.From,
+		To:                m.To,
+		Text:              m.message,
+		ReceivedTimestamp: m.ReceivedTimestamp,
+	}
+	for _, f := range m.files {
+		data := f.Base64Data
+		if data == "" && len(f.Content) > 0 {
+			data = base64.StdEncoding.EncodeToString(f.Content)
+		}
+		payload.Media = append(payload.Media, MsgFile{
+			Filename:    f.Filename,
+			ContentType: f.ContentType,
+			Base64Data:  data,
+		})
+	}
+	return payload
+}
+
+// signClientWebhook returns the signature header value for body.
+func signClientWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// clientReceiptPayload is the JSON body POSTed to a legacy client's webhook
+// for a delivery receipt. Type tells it apart from a message.
+type clientReceiptPayload struct {
+	Type       string    `json:"type"` // always "delivery_receipt"
+	LogID      string    `json:"log_id"`
+	TraceID    string    `json:"trace_id,omitempty"`
+	MessageID  string    `json:"message_id"` // as returned in submit_sm_resp
+	From       string    `json:"from_number"`
+	To         string    `json:"to_number"`
+	Stat       string    `json:"stat"` // DELIVRD, UNDELIV, REJECTD or EXPIRED
+	Err        int       `json:"err"`
+	SubmitDate time.Time `json:"submit_date"`
+	DoneDate   time.Time `json:"done_date"`
+}
+
+func newClientReceiptPayload(m *MsgQueueItem, stat string, errCode int, done time.Time) clientReceiptPayload {
+	req := m.DeliveryReceipt
+	return clientReceiptPayload{
+		Type:       "delivery_receipt",
+		LogID:      m.LogID,
+		TraceID:    m.TraceID,
+		MessageID:  req.MessageID,
+		From:       req.SourceAddr.No,
+		To:         req.DestAddr.No,
+		Stat:       stat,
+		Err:        errCode,
+		SubmitDate: req.SubmitDate,
+		DoneDate:   done,
+	}
+}
+
+// deliverClientWebhook POSTs the message to url, a legacy client's WebhookURL
+// or the webhook of a number routed to one.
+// Any non-2xx response is returned as an error so the caller can retry.
+func (router *Router) deliverClientWebhook(m *MsgQueueItem, client *Client, url string) error {
+	return router.postClientWebhook(newClientWebhookPayload(m), client, url)
+}
+
+// postClientWebhook POSTs payload as JSON to url, signed with the client's
+// WebhookSecret when it has one.
+func (router *Router) postClientWebhook(payload interface{}, client *Client, url string) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook payload: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if client.WebhookSecret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(clientWebhookTimestampHeader, timestamp)
+		req.Header.Set(clientWebhookSignatureHeader, signClientWebhook(client.WebhookSecret, timestamp, body))
+	}
+
+	timeoutSecs := router.gateway.Config.WebhookTimeoutSecs
+	if client.Settings != nil && client.Settings.WebhookTimeoutSecs > 0 {
+		timeoutSecs = client.Settings.WebhookTimeoutSecs
+	}
+	httpClient := &http.Client{Timeout: time.Duration(timeoutSecs) * time.Second}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Delivery receipts for client webhooks are POSTed by a fixed pool of
+// workers from a bounded queue, so a slow webhook cannot pile up goroutines.
+const (
+	receiptWebhookWorkers   = 4
+	receiptWebhookQueueSize = 1000
+)
+
+// receipts returns the receipt webhook queue, starting its workers on first
+// use.
+func (router *Router) receipts() chan MsgQueueItem {
+	router.receiptOnce.Do(func() {
+		router.receiptQueue = make(chan MsgQueueItem, receiptWebhookQueueSize)
+		for i := 0; i < receiptWebhookWorkers; i++ {
+			go func() {
+				for m := range router.receiptQueue {
+					router.sendReceiptWebhook(m)
+				}
+			}()
+		}
+	})
+	return router.receiptQueue
+}
+
+// queueReceiptWebhook queues a delivery receipt for the client's webhook.
+// The receipt is dropped, and logged, when the queue is full.
+func (router *Router) queueReceiptWebhook(msg *MsgQueueItem, payload clientReceiptPayload) {
+	m := MsgQueueItem{
+		LogID:           msg.LogID,
+		TraceID:         msg.TraceID,
+		Type:            MsgQueueItemType.SMS,
+		DeliveryReceipt: msg.DeliveryReceipt,
+		receipt:         &payload,
+	}
+	select {
+	case router.receipts() <- m:
+	default:
+		lm := router.gateway.LogManager
+		lm.SendLog(lm.BuildLog("Router.ClientWebhook", "ReceiptDropped", logrus.WarnLevel, map[string]interface{}{
+			"logID":     payload.LogID,
+			"traceID":   payload.TraceID,
+			"toClient":  msg.DeliveryReceipt.Username,
+			"messageID": payload.MessageID,
+			"stat":      payload.Stat,
+		}))
+	}
+}
+
+// sendReceiptWebhook POSTs a queued delivery receipt to the client's
+// WebhookURL, then to its WebhookFailoverURL if that fails. When both fail
+// the receipt is retried with the SMS retry policy; unlike a message, the
+// message's status is left alone.
+func (router *Router) sendReceiptWebhook(m MsgQueueItem) {
+	lm := router.gateway.LogManager
+	payload := *m.receipt
+	username := m.DeliveryReceipt.Username
+	fields := map[string]interface{}{
+		"logID":     payload.LogID,
+		"traceID":   payload.TraceID,
+		"toClient":  username,
+		"messageID": payload.MessageID,
+		"stat":      payload.Stat,
+	}
+
+	router.gateway.mu.RLock()
+	client := router.gateway.Clients[username]
+	router.gateway.mu.RUnlock()
+	if client == nil || !client.usesWebhookDelivery(MsgQueueItemType.SMS) {
+		lm.SendLog(lm.BuildLog("Router.ClientWebhook", "ReceiptFailed", logrus.ErrorLevel, fields,
+			fmt.Errorf("client no longer delivers SMS to a webhook")))
+		return
+	}
+
+	url := client.WebhookURL
+	err := router.postClientWebhook(payload, client, url)
+	if err != nil && client.WebhookFailoverURL != "" && client.WebhookFailoverURL != url {
+		url = client.WebhookFailoverURL
+		err = router.postClientWebhook(payload, client, url)
+	}
+	fields["url"] = url
+	if err == nil {
+		lm.SendLog(lm.BuildLog("Router.ClientWebhook", "ReceiptDelivered", logrus.InfoLevel, fields))
+		return
+	}
+
+	if m.Delivery == nil {
+		m.Delivery = &MsgQueueDelivery{}
+	}
+	m.Delivery.Error = err.Error()
+	policy := msgRetryScheduler.getPolicy()
+	if m.Delivery.RetryCount >= policy.maxRetries(m.Type) {
+		fields["retryCount"] = m.Delivery.RetryCount
+		lm.SendLog(lm.BuildLog("Router.ClientWebhook", "ReceiptFailed", logrus.ErrorLevel, fields, err))
+		return
+	}
+	m.Delivery.RetryCount++
+	m.Delivery.RetryTime = time.Now().Add(policy.backoff(m.Delivery.RetryCount))
+	fields["retryCount"] = m.Delivery.RetryCount
+	fields["retryTime"] = m.Delivery.RetryTime
+	lm.SendLog(lm.BuildLog("Router.ClientWebhook", "ReceiptRetry", logrus.WarnLevel, fields, err))
+	msgRetryScheduler.schedule(m, router.receipts())
+}
+
+// routeClientWebhook delivers m to url for toClient, then to the client's
+// WebhookFailoverURL if that fails, and records the outcome. record carries
+// the type-specific usage fields (encoding and segments for SMS, media counts
+// for MMS). Only when both URLs fail does the message go through Retry;
+// undelivered MMS are persisted once retries are exhausted.
+func (router *Router) routeClientWebhook(m *MsgQueueItem, toClient, fromClient *Client, url string, retryChan chan MsgQueueItem, origin string, record MsgRecord) {
+	lm := router.gateway.LogManager
+
+	failover := toClient.WebhookFailoverURL
+	if failover == url {
+		failover = ""
+	}
+
+	deliveredURL := url
+	err := router.deliverClientWebhook(m, toClient, url)
+	if err != nil && failover != "" {
+		lm.SendLog(lm.BuildLog("Router.ClientWebhook", "PrimaryFailed", logrus.WarnLevel, map[string]interface{}{
+			"logID":       m.LogID,
+			"traceID":     m.TraceID,
+			"toClient":    toClient.Username,
+			"url":         url,
+			"failoverURL": failover,
+			"msgType":     string(m.Type),
+		}, err))
+		deliveredURL = failover
+		err = router.deliverClientWebhook(m, toClient, failover)
+	}
+	if err != nil {
+		lm.SendLog(lm.BuildLog("Router.ClientWebhook", "DeliveryFailed", logrus.ErrorLevel, map[string]interface{}{
+			"logID":       m.LogID,
+			"traceID":     m.TraceID,
+			"toClient":    toClient.Username,
+			"url":         url,
+			"failoverURL": failover,
+			"msgType":     string(m.Type),
+		}, err))
+		router.gateway.recordMessage("inbound", m.Type, m.SourceCarrier, MetricResultFailure)
+		if m.Retry("failed to deliver client webhook", retryChan) && m.Type == MsgQueueItemType.MMS {
+			router.persistUndeliveredMMS(m, origin, "failed to deliver client webhook")
+		}
+		return
+	}
+
+	lm.SendLog(lm.BuildLog("Router.ClientWebhook", "Delivered", logrus.InfoLevel, map[string]interface{}{
+		"logID":      m.LogID,
+		"traceID":    m.TraceID,
+		"toClient":   toClient.Username,
+		"url":        deliveredURL,
+		"failover":   deliveredURL != url,
+		"msgType":    string(m.Type),
+		"mediaCount": len(m.files),
+	}))
+
+	router.gateway.recordMessage("inbound", m.Type, m.SourceCarrier, MetricResultSuccess)
+	if m.Type == MsgQueueItemType.MMS {
+		router.gateway.completePendingMMS(m)
+	}
+
+	record.MsgQueueItem = *m
+	record.Internal = fromClient != nil
+	record.FromClientType = "carrier"
+	record.ToClientType = "legacy"
+	record.DeliveryMethod = DeliveryMethodWebhook
+	record.SourceIP = m.SourceIP
+	carrierName := m.SourceCarrier
+	if fromClient != nil {
+		record.FromClientType = fromClient.Type
+		carrierName = ""
+		outbound := record
+		outbound.ClientID = fromClient.ID
+		outbound.Direction = "outbound"
+		router.gateway.MsgRecordChan <- outbound
+	}
+	record.Carrier = carrierName
+	record.ClientID = toClient.ID
+	record.Direction = "inbound"
+	router.gateway.MsgRecordChan <- record
+}
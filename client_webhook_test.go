@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_UsesWebhookDelivery(t *testing.T) {
+	sms, mms := MsgQueueItemType.SMS, MsgQueueItemType.MMS
+
+	c := &Client{Type: "legacy"}
+	assert.False(t, c.usesWebhookDelivery(sms), "no webhook configured")
+
+	c.WebhookURL = "https://pbx.example.com/hook"
+	assert.True(t, c.usesWebhookDelivery(sms))
+	assert.True(t, c.usesWebhookDelivery(mms))
+
+	c.DeliveryMethod = DeliveryMethodSMPP
+	assert.False(t, c.usesWebhookDelivery(sms))
+	assert.True(t, c.usesWebhookDelivery(mms))
+
+	c.DeliveryMethod = DeliveryMethodMM4
+	assert.True(t, c.usesWebhookDelivery(sms))
+	assert.False(t, c.usesWebhookDelivery(mms))
+
+	web := &Client{Type: "web", WebhookURL: "https://app.example.com/hook", DeliveryMethod: DeliveryMethodWebhook}
+	assert.False(t, web.usesWebhookDelivery(sms), "web clients use DispatchWebhook")
+}
+
+func TestRouter_DeliverClientWebhookSigned(t *testing.T) {
+	var gotBody []byte
+	var gotHeader http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader = r.Header.Clone()
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	r, gw := newTestRouter(1)
+	gw.Config.WebhookTimeoutSecs = 5
+	client := &Client{Username: "pbx1", WebhookURL: srv.URL, WebhookSecret: "s3cret"}
+	msg := &MsgQueueItem{
+		LogID:             "w1",
+		Type:              MsgQueueItemType.MMS,
+		From:              "+14155559876",
+		To:                "+12505551234",
+		message:           "hi",
+		ReceivedTimestamp: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+		files:             []MsgFile{{Filename: "a.jpg", ContentType: "image/jpeg", Content: []byte("jpeg")}},
+	}
+
+	require.NoError(t, r.deliverClientWebhook(msg, client, client.WebhookURL))
+
+	timestamp := gotHeader.Get(clientWebhookTimestampHeader)
+	require.NotEmpty(t, timestamp)
+	assert.Equal(t, signClientWebhook("s3cret", timestamp, gotBody), gotHeader.Get(clientWebhookSignatureHeader))
+
+	var payload clientWebhookPayload
+	require.NoError(t, json.Unmarshal(gotBody, &payload))
+	assert.Equal(t, "w1", payload.LogID)
+	assert.Equal(t, "+12505551234", payload.To)
+	assert.Equal(t, "hi", payload.Text)
+	require.Len(t, payload.Media, 1)
+	assert.Equal(t, "anBlZw==", payload.Media[0].Base64Data)
+	assert.Empty(t, payload.Media[0].Content)
+}
+
+func TestRouter_DeliverClientWebhookNon2xx(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	r, gw := newTestRouter(1)
+	gw.Config.WebhookTimeoutSecs = 5
+	client := &Client{Username: "pbx1", WebhookURL: srv.URL}
+
+	err := r.deliverClientWebhook(&MsgQueueItem{LogID: "w2", Type: MsgQueueItemType.SMS}, client, client.WebhookURL)
+	assert.ErrorContains(t, err, "502")
+}
+
+func TestRouter_RouteClientWebhookFailover(t *testing.T) {
+	var primaryHits, failoverHits int
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryHits++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer primary.Close()
+	failover := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failoverHits++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer failover.Close()
+
+	r, gw := newTestRouter(1)
+	gw.Config.WebhookTimeoutSecs = 5
+	gw.MsgRecordChan = make(chan MsgRecord, 1)
+	client := &Client{ID: 7, Username: "pbx1", WebhookURL: primary.URL, WebhookFailoverURL: failover.URL}
+	msg := &MsgQueueItem{LogID: "w3", Type: MsgQueueItemType.SMS, From: "+14155559876", To: "+12505551234", message: "hi"}
+
+	r.routeClientWebhook(msg, client, nil, client.WebhookURL, r.ClientMsgChan, "carrier", MsgRecord{})
+
+	assert.Equal(t, 1, primaryHits)
+	assert.Equal(t, 1, failoverHits)
+	assert.Empty(t, r.ClientMsgChan, "delivered by the failover, so not retried")
+	record := <-gw.MsgRecordChan
+	assert.Equal(t, uint(7), record.ClientID)
+	assert.Equal(t, DeliveryMethodWebhook, record.DeliveryMethod)
+}
+
+func TestRouter_RouteClientWebhookBothFail(t *testing.T) {
+	var hits []string
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits = append(hits, r.URL.Path)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	r, gw := newTestRouter(1)
+	gw.Config.WebhookTimeoutSecs = 5
+	gw.MsgRecordChan = make(chan MsgRecord, 1)
+	client := &Client{Username: "pbx1", WebhookURL: down.URL + "/primary", WebhookFailoverURL: down.URL + "/failover"}
+	msg := &MsgQueueItem{LogID: "w4", Type: MsgQueueItemType.SMS}
+
+	r.routeClientWebhook(msg, client, nil, client.WebhookURL, r.ClientMsgChan, "carrier", MsgRecord{})
+
+	assert.Equal(t, []string{"/primary", "/failover"}, hits)
+	require.NotNil(t, msg.Delivery, "handed to Retry once both URLs failed")
+	assert.Equal(t, "failed to deliver client webhook", msg.Delivery.Error)
+	assert.Empty(t, gw.MsgRecordChan)
+}
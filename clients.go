@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -10,17 +11,43 @@ import (
 )
 
 type Client struct {
-	ID         uint             `gorm:"primaryKey" json:"id"`
-	Username   string           `gorm:"unique;not null" json:"username"`
-	Password   string           `gorm:"not null" json:"password"` // Never returned in JSON
-	Address    string           `json:"address"`                  // IP address or hostname (required for legacy)
-	Name       string           `json:"name"`
-	Type       string           `json:"type" gorm:"default:'legacy'"`  // 'legacy' or 'web'
-	Timezone   string           `json:"timezone" gorm:"default:'UTC'"` // IANA timezone for limit period calculation
-	LogPrivacy bool             `json:"log_privacy"`
-	Settings   *ClientSettings  `gorm:"foreignKey:ClientID" json:"settings,omitempty"`
-	Numbers    []ClientNumber   `gorm:"foreignKey:ClientID" json:"numbers"`
-	Failovers  []ClientFailover `gorm:"foreignKey:PrimaryClientID" json:"failovers,omitempty"`
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	Username   string `gorm:"unique;not null" json:"username"`
+	Password   string `gorm:"not null" json:"password"` // Never returned in JSON
+	Address    string `json:"address"`                  // IP address or hostname (required for legacy)
+	Name       string `json:"name"`
+	Type       string `json:"type" gorm:"default:'legacy'"`  // 'legacy' or 'web'
+	Timezone   string `json:"timezone" gorm:"default:'UTC'"` // IANA timezone for limit period calculation
+	LogPrivacy bool   `json:"log_privacy"`
+
+	// How much of this client's traffic is redacted in logs: "none",
+	// "numbers" or "full". Empty uses full if LogPrivacy is set, and
+	// LOG_PRIVACY otherwise.
+	LogPrivacyLevel string `json:"log_privacy_level,omitempty"`
+
+	// MMS sent to this client is recompressed to carrier limits. When false,
+	// original media is forwarded as long as it fits MMS_PASSTHROUGH_MAX_BYTES.
+	TranscodeMedia bool `json:"transcode_media" gorm:"default:true;not null"`
+
+	// Legacy clients that can't run an SMPP bind or MM4 listener can receive
+	// messages as signed HTTP POSTs instead (see client_webhook.go).
+	WebhookURL         string `json:"webhook_url,omitempty"`
+	WebhookFailoverURL string `json:"webhook_failover_url,omitempty"` // Tried when WebhookURL fails
+	WebhookSecret      string `json:"webhook_secret,omitempty"`       // Never returned in JSON
+	DeliveryMethod     string `json:"delivery_method,omitempty"`      // '', 'smpp', 'mm4' or 'webhook'
+
+	// Which delivery receipts are sent for this client's submit_sm: "" (as
+	// registered_delivery asks), "off", "failures_only" or "all". Receipts go
+	// over SMPP, or to the webhook when the client's SMS do, under
+	// WebhookDeliveryReceipts (see sms_dlr.go).
+	DeliveryReceipts        string `json:"delivery_receipts,omitempty"`
+	WebhookDeliveryReceipts string `json:"webhook_delivery_receipts,omitempty"`
+
+	Settings  *ClientSettings  `gorm:"foreignKey:ClientID" json:"settings,omitempty"`
+	Numbers   []ClientNumber   `gorm:"foreignKey:ClientID" json:"numbers"`
+	Failovers []ClientFailover `gorm:"foreignKey:PrimaryClientID" json:"failovers,omitempty"`
+
+	addressRules []clientAddressRule // Parsed from Address at load time
 }
 
 // ClientFailover defines a failover relationship between clients.
@@ -62,17 +89,59 @@ type ClientSettings struct {
 
 	// === Limit Behavior ===
 	LimitBoth bool `json:"limit_both" gorm:"default:false"` // If true, limit applies to inbound+outbound; default=false (outbound only)
+
+	// === SMPP Throttling ===
+	SubmitRateLimit int `json:"submit_rate_limit"` // submit_sm per second (0 = use SMPP_SUBMIT_RATE_LIMIT)
+	WindowSize      int `json:"window_size"`       // deliver_sm awaiting a response at once (0 = use SMPP_WINDOW_SIZE)
+
+	// === Connection Cap ===
+	MaxConnections int `json:"max_connections"` // concurrent MM4 or SMPP connections (0 = use MAX_CONNS_PER_CLIENT)
+
+	// === Routing ===
+	Priority string `json:"priority"` // "high", "normal", or "" (use HIGH_PRIORITY_TYPES)
+
+	// === SMPP Keepalive ===
+	EnquireIntervalSecs int `json:"enquire_interval_secs"` // enquire_link interval (0 = use SMPP_ENQUIRE_INTERVAL)
+	EnquireTimeoutSecs  int `json:"enquire_timeout_secs"`  // enquire_link_resp wait (0 = use SMPP_ENQUIRE_TIMEOUT)
+	ResponseTimeoutSecs int `json:"response_timeout_secs"` // deliver_sm_resp wait (0 = use SMPP_RESPONSE_TIMEOUT)
+
+	// === Outbound MM4 ===
+	MM4DialTimeoutSecs     int    `json:"mm4_dial_timeout_secs"`     // connect timeout (0 = use MM4_DIAL_TIMEOUT)
+	MM4SessionDeadlineSecs int    `json:"mm4_session_deadline_secs"` // per-read/write deadline (0 = use MM4_SESSION_DEADLINE)
+	MM4SingleImage         string `json:"mm4_single_image"`          // "smil", "mixed", or "" (use MM4_SINGLE_IMAGE)
+	MMSDelivery            string `json:"mms_delivery"`              // "inline" (or ""), "notification", or "link"
+
+	// === SMPP Coding ===
+	SMSCoding   string `json:"sms_coding"`   // "auto" (or ""), "force_gsm7_transliterate", or "force_ucs2"
+	SMSLanguage string `json:"sms_language"` // GSM7 national language tables: "turkish", "spanish", "portuguese", or "" (none)
+	DeliverPDU  string `json:"deliver_pdu"`  // "deliver_sm" (or "") or "data_sm"
+
+	// === SMPP Numbering ===
+	SMPPAddressFormat string `json:"smpp_address_format"` // "international" (or ""), "national", or "unknown"
+	SMPPCountryCode   string `json:"smpp_country_code"`   // country code stripped in national format ("" = 1)
+
+	// === Number Formatting ===
+	DefaultCountryCode string `json:"default_country_code"` // added to national numbers from the client ("" = use DEFAULT_COUNTRY_CODE)
+
+	// === MMS Transcode Replies ===
+	TranscodeFailureReply string `json:"transcode_failure_reply"` // {id}/{reason} template, "none", or "" (use TRANSCODE_FAILURE_REPLY)
+	TranscodePanicReply   string `json:"transcode_panic_reply"`   // {id} template, "none", or "" (use TRANSCODE_PANIC_REPLY)
+
+	// === Sender Pool ===
+	SenderPoolPolicy string `json:"sender_pool_policy"` // "passthrough" (or ""), "sticky", or "roundrobin"
+	SenderPool       string `json:"sender_pool"`        // Comma-separated client numbers used as From for carrier sends
 }
 
 type ClientNumber struct {
 	ID                   uint            `gorm:"primaryKey" json:"id"`
 	ClientID             uint            `gorm:"index;not null" json:"client_id"`
 	Number               string          `gorm:"unique;not null" json:"number"`
-	Carrier              string          `json:"carrier"`
+	Carrier              string          `gorm:"index" json:"carrier"`
 	Tag                  string          `json:"tag"`   // For organizational purposes
 	Group                string          `json:"group"` // For number groupings
 	IgnoreStopCmdSending bool            `json:"ignore_stop_cmd_sending" gorm:"default:false;not null"`
-	WebHook              string          `json:"webhook"` // Number-specific webhook URL
+	WebHook              string          `json:"webhook"`       // Number-specific webhook URL
+	InboundRoute         string          `json:"inbound_route"` // '', 'smpp', 'mm4', 'webhook' or 'drop'
 	Settings             *NumberSettings `gorm:"foreignKey:NumberID" json:"settings,omitempty"`
 }
 
@@ -100,6 +169,9 @@ type NumberSettings struct {
 	AutoReplyEnabled     bool   `json:"auto_reply_enabled" gorm:"default:false"`
 	AutoReplyMessage     string `json:"auto_reply_message"`                         // custom reply body (optional)
 	AutoReplyCooldownSec int    `json:"auto_reply_cooldown_secs" gorm:"default:60"` // per (from,to) cooldown
+
+	// === Opt-out keywords ===
+	HelpReplyMessage string `json:"help_reply_message"` // reply to HELP/INFO (optional, overrides HELP_REPLY_MESSAGE)
 }
 
 // ResolveAutoReply returns the effective enabled flag and reply text for a
@@ -330,53 +402,79 @@ func (gateway *Gateway) CheckMessageLimits(client *Client, fromNumber string, ms
 
 // loadClients loads clients from the database, decrypts passwords, and populates the in-memory map.
 func (gateway *Gateway) loadClients() error {
+	clientMap, err := gateway.readClients()
+	if err != nil {
+		return err
+	}
+
+	gateway.mu.Lock()
+	defer gateway.mu.Unlock()
+	gateway.Clients = clientMap
+	return nil
+}
+
+// readClients reads the clients from the database with their numbers,
+// settings and failovers, decrypting their secrets, keyed by username.
+func (gateway *Gateway) readClients() (map[string]*Client, error) {
 	var clients []Client
 	// Preload Numbers, Settings, NumberSettings, and Failovers
 	if err := gateway.DB.Preload("Numbers").Preload("Numbers.Settings").Preload("Settings").Preload("Failovers", func(db *gorm.DB) *gorm.DB {
 		return db.Where("enabled = ?", true).Order("priority ASC")
 	}).Find(&clients).Error; err != nil {
-		return err
+		return nil, err
 	}
 
 	clientMap := make(map[string]*Client)
-	gateway.mu.Lock()
-	defer gateway.mu.Unlock()
-
 	for _, client := range clients {
 		// Decrypt password only (username is stored in plaintext)
 		decryptedPassword, err := DecryptAES256(client.Password, gateway.EncryptionKey)
 		if err != nil {
-			return fmt.Errorf("failed to decrypt password for client %s: %w", client.Name, err)
+			return nil, fmt.Errorf("failed to decrypt password for client %s: %w", client.Name, err)
 		}
 
 		// Update client struct with decrypted password
 		client.Password = decryptedPassword
 
+		if client.WebhookSecret != "" {
+			secret, err := DecryptAES256(client.WebhookSecret, gateway.EncryptionKey)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt webhook secret for client %s: %w", client.Name, err)
+			}
+			client.WebhookSecret = secret
+		}
+
 		c := client // create a copy to avoid referencing the loop variable
+		c.parseAddressRules()
 		clientMap[client.Username] = &c
 	}
-
-	gateway.Clients = clientMap
-	return nil
+	return clientMap, nil
 }
 
 func (gateway *Gateway) loadNumbers() error {
-	var numbers []ClientNumber
-	if err := gateway.DB.Find(&numbers).Error; err != nil {
+	numberMap, err := gateway.readNumbers()
+	if err != nil {
 		return err
 	}
 
-	numberMap := make(map[string]*ClientNumber)
 	gateway.mu.Lock()
 	defer gateway.mu.Unlock()
+	gateway.Numbers = numberMap
+	return nil
+}
+
+// readNumbers reads the client numbers from the database, keyed by number.
+func (gateway *Gateway) readNumbers() (map[string]*ClientNumber, error) {
+	var numbers []ClientNumber
+	if err := gateway.DB.Find(&numbers).Error; err != nil {
+		return nil, err
+	}
 
+	numberMap := make(map[string]*ClientNumber)
 	for _, number := range numbers {
 		n := number // create a copy to avoid referencing the loop variable
 		numberMap[number.Number] = &n
 	}
-
-	gateway.Numbers = numberMap
-	return nil
+	return numberMap, nil
 }
 
 // addClient encrypts the client's password and stores the client in the database and in-memory map.
@@ -392,17 +490,38 @@ func (gateway *Gateway) addClient(client *Client) error {
 
 	client.Password = encryptedPassword
 
+	plaintextSecret := client.WebhookSecret
+	if plaintextSecret != "" {
+		encryptedSecret, err := EncryptAES256(plaintextSecret, gateway.EncryptionKey)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt webhook secret: %w", err)
+		}
+		client.WebhookSecret = encryptedSecret
+	}
+
+	// gorm writes the column default (true) in place of a false bool, so an
+	// explicit opt-out is applied after the insert.
+	transcodeMedia := client.TranscodeMedia
+
 	// Store in the database
 	if err := gateway.DB.Create(client).Error; err != nil {
 		return err
 	}
+	if !transcodeMedia {
+		if err := gateway.DB.Model(client).Update("transcode_media", false).Error; err != nil {
+			return err
+		}
+	}
 
 	// Restore plaintext password for in-memory map
 	client.Password = plaintextPassword
+	client.WebhookSecret = plaintextSecret
+	client.parseAddressRules()
 
 	gateway.mu.Lock()
 	gateway.Clients[client.Username] = client
 	gateway.mu.Unlock()
+	gateway.syncLogPrivacy()
 
 	return nil
 }
@@ -434,19 +553,343 @@ func (gateway *Gateway) updateClientPassword(clientID uint, newPassword string)
 	return nil
 }
 
+// removeClient deletes a client with its numbers, settings, failovers and API
+// keys from the database and the in-memory maps, and drops any live SMPP
+// session so the client cannot keep sending on an existing bind.
+func (gateway *Gateway) removeClient(client *Client) error {
+	err := gateway.DB.Transaction(func(tx *gorm.DB) error {
+		for _, num := range client.Numbers {
+			if num.Settings != nil {
+				if err := tx.Delete(num.Settings).Error; err != nil {
+					return err
+				}
+			}
+		}
+		if err := tx.Where("client_id = ?", client.ID).Delete(&ClientNumber{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("client_id = ?", client.ID).Delete(&ClientSettings{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("primary_client_id = ? OR fallback_client_id = ?", client.ID, client.ID).Delete(&ClientFailover{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("client_id = ?", client.ID).Delete(&TenantAPIKey{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Client{}, client.ID).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete client: %w", err)
+	}
+
+	gateway.mu.Lock()
+	delete(gateway.Clients, client.Username)
+	for _, num := range client.Numbers {
+		delete(gateway.Numbers, num.Number)
+	}
+	for hash, key := range gateway.APIKeys {
+		if key.ClientID == client.ID {
+			delete(gateway.APIKeys, hash)
+		}
+	}
+	for _, c := range gateway.Clients {
+		kept := c.Failovers[:0]
+		for _, fo := range c.Failovers {
+			if fo.FallbackClientID != client.ID {
+				kept = append(kept, fo)
+			}
+		}
+		c.Failovers = kept
+	}
+	gateway.mu.Unlock()
+	gateway.syncLogPrivacy()
+
+	if gateway.SMPPServer != nil {
+		gateway.SMPPServer.disconnectClient(client.Username)
+	}
+
+	return nil
+}
+
+// clientUpdate carries the fields of PUT /clients/{id}; a nil field is left
+// unchanged.
+type clientUpdate struct {
+	Name               *string `json:"name,omitempty"`
+	Address            *string `json:"address,omitempty"`
+	Password           *string `json:"password,omitempty"`
+	LogPrivacy         *bool   `json:"log_privacy,omitempty"`
+	LogPrivacyLevel    *string `json:"log_privacy_level,omitempty"`
+	WebhookURL         *string `json:"webhook_url,omitempty"`
+	WebhookFailoverURL *string `json:"webhook_failover_url,omitempty"`
+	WebhookSecret      *string `json:"webhook_secret,omitempty"`
+	DeliveryMethod     *string `json:"delivery_method,omitempty"`
+	TranscodeMedia     *bool   `json:"transcode_media,omitempty"`
+
+	DeliveryReceipts        *string `json:"delivery_receipts,omitempty"`
+	WebhookDeliveryReceipts *string `json:"webhook_delivery_receipts,omitempty"`
+}
+
+// updateClient applies changes to a client's mutable fields in the database
+// and in memory. A nil field is left unchanged.
+func (gateway *Gateway) updateClient(client *Client, upd clientUpdate) error {
+	updates := map[string]interface{}{}
+	if upd.Name != nil {
+		updates["name"] = *upd.Name
+	}
+	if upd.Address != nil {
+		updates["address"] = *upd.Address
+	}
+	if upd.LogPrivacy != nil {
+		updates["log_privacy"] = *upd.LogPrivacy
+	}
+	if upd.LogPrivacyLevel != nil {
+		updates["log_privacy_level"] = *upd.LogPrivacyLevel
+	}
+	if upd.WebhookURL != nil {
+		updates["webhook_url"] = *upd.WebhookURL
+	}
+	if upd.WebhookFailoverURL != nil {
+		updates["webhook_failover_url"] = *upd.WebhookFailoverURL
+	}
+	if upd.WebhookSecret != nil {
+		encryptedSecret := ""
+		if *upd.WebhookSecret != "" {
+			var err error
+			encryptedSecret, err = EncryptAES256(*upd.WebhookSecret, gateway.EncryptionKey)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt webhook secret: %w", err)
+			}
+		}
+		updates["webhook_secret"] = encryptedSecret
+	}
+	if upd.DeliveryMethod != nil {
+		updates["delivery_method"] = *upd.DeliveryMethod
+	}
+	if upd.TranscodeMedia != nil {
+		updates["transcode_media"] = *upd.TranscodeMedia
+	}
+	if upd.DeliveryReceipts != nil {
+		updates["delivery_receipts"] = *upd.DeliveryReceipts
+	}
+	if upd.WebhookDeliveryReceipts != nil {
+		updates["webhook_delivery_receipts"] = *upd.WebhookDeliveryReceipts
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+
+	if err := gateway.DB.Model(&Client{}).Where("id = ?", client.ID).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to update client in database: %w", err)
+	}
+
+	gateway.mu.Lock()
+	if upd.Name != nil {
+		client.Name = *upd.Name
+	}
+	if upd.Address != nil {
+		client.Address = *upd.Address
+		client.parseAddressRules()
+	}
+	if upd.LogPrivacy != nil {
+		client.LogPrivacy = *upd.LogPrivacy
+	}
+	if upd.LogPrivacyLevel != nil {
+		client.LogPrivacyLevel = *upd.LogPrivacyLevel
+	}
+	if upd.WebhookURL != nil {
+		client.WebhookURL = *upd.WebhookURL
+	}
+	if upd.WebhookFailoverURL != nil {
+		client.WebhookFailoverURL = *upd.WebhookFailoverURL
+	}
+	if upd.WebhookSecret != nil {
+		client.WebhookSecret = *upd.WebhookSecret
+	}
+	if upd.DeliveryMethod != nil {
+		client.DeliveryMethod = *upd.DeliveryMethod
+	}
+	if upd.TranscodeMedia != nil {
+		client.TranscodeMedia = *upd.TranscodeMedia
+	}
+	if upd.DeliveryReceipts != nil {
+		client.DeliveryReceipts = *upd.DeliveryReceipts
+	}
+	if upd.WebhookDeliveryReceipts != nil {
+		client.WebhookDeliveryReceipts = *upd.WebhookDeliveryReceipts
+	}
+	gateway.mu.Unlock()
+	gateway.syncLogPrivacy()
+
+	return nil
+}
+
+// numberUpdate carries the fields of PUT /clients/{id}/numbers/{number}; a
+// nil field is left unchanged.
+type numberUpdate struct {
+	Carrier              *string `json:"carrier,omitempty"`
+	Tag                  *string `json:"tag,omitempty"`
+	Group                *string `json:"group,omitempty"`
+	Webhook              *string `json:"webhook,omitempty"`
+	IgnoreStopCmdSending *bool   `json:"ignore_stop_cmd_sending,omitempty"`
+	InboundRoute         *string `json:"inbound_route,omitempty"`
+	AutoReplyEnabled     *bool   `json:"auto_reply_enabled,omitempty"`
+	AutoReplyMessage     *string `json:"auto_reply_message,omitempty"`
+	AutoReplyCooldownSec *int    `json:"auto_reply_cooldown_secs,omitempty"`
+	HelpReplyMessage     *string `json:"help_reply_message,omitempty"`
+}
+
+func (u numberUpdate) touchesSettings() bool {
+	return u.AutoReplyEnabled != nil || u.AutoReplyMessage != nil || u.AutoReplyCooldownSec != nil ||
+		u.HelpReplyMessage != nil
+}
+
+// apply returns a copy of num with the update applied. Settings are copied
+// rather than shared, so the in-memory number is untouched until saved.
+func (u numberUpdate) apply(num ClientNumber) ClientNumber {
+	if u.Carrier != nil {
+		num.Carrier = *u.Carrier
+	}
+	if u.Tag != nil {
+		num.Tag = *u.Tag
+	}
+	if u.Group != nil {
+		num.Group = *u.Group
+	}
+	if u.Webhook != nil {
+		num.WebHook = *u.Webhook
+	}
+	if u.IgnoreStopCmdSending != nil {
+		num.IgnoreStopCmdSending = *u.IgnoreStopCmdSending
+	}
+	if u.InboundRoute != nil {
+		num.InboundRoute = *u.InboundRoute
+	}
+	if u.touchesSettings() {
+		settings := NumberSettings{NumberID: num.ID}
+		if num.Settings != nil {
+			settings = *num.Settings
+		}
+		if u.AutoReplyEnabled != nil {
+			settings.AutoReplyEnabled = *u.AutoReplyEnabled
+		}
+		if u.AutoReplyMessage != nil {
+			settings.AutoReplyMessage = *u.AutoReplyMessage
+		}
+		if u.AutoReplyCooldownSec != nil {
+			settings.AutoReplyCooldownSec = *u.AutoReplyCooldownSec
+		}
+		if u.HelpReplyMessage != nil {
+			settings.HelpReplyMessage = *u.HelpReplyMessage
+		}
+		num.Settings = &settings
+	}
+	return num
+}
+
+// findClientNumber returns the position in client.Numbers of the number
+// identified by ref, which is either its ID or the phone number itself
+// (with or without a leading +). It returns -1 if the client has no such
+// number.
+func findClientNumber(client *Client, ref string) int {
+	if id, err := strconv.ParseUint(ref, 10, 32); err == nil {
+		for i := range client.Numbers {
+			if client.Numbers[i].ID == uint(id) {
+				return i
+			}
+		}
+	}
+	phone := strings.TrimPrefix(ref, "+")
+	for i := range client.Numbers {
+		if strings.TrimPrefix(client.Numbers[i].Number, "+") == phone {
+			return i
+		}
+	}
+	return -1
+}
+
+// updateNumber saves upd to the client's number numberID in one transaction.
+// Only once it commits is the result swapped into the in-memory maps, so
+// routing picks up the new carrier immediately and never sees a half-saved
+// number.
+func (gateway *Gateway) updateNumber(client *Client, numberID uint, upd numberUpdate) (ClientNumber, error) {
+	gateway.mu.RLock()
+	var current *ClientNumber
+	for i := range client.Numbers {
+		if client.Numbers[i].ID == numberID {
+			current = &client.Numbers[i]
+			break
+		}
+	}
+	var updated ClientNumber
+	if current != nil {
+		updated = upd.apply(*current)
+	}
+	gateway.mu.RUnlock()
+	if current == nil {
+		return ClientNumber{}, fmt.Errorf("number %d not found for client %s", numberID, client.Username)
+	}
+
+	err := gateway.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&ClientNumber{}).Where("id = ?", updated.ID).Updates(map[string]interface{}{
+			"carrier":                 updated.Carrier,
+			"tag":                     updated.Tag,
+			"group":                   updated.Group,
+			"web_hook":                updated.WebHook,
+			"ignore_stop_cmd_sending": updated.IgnoreStopCmdSending,
+			"inbound_route":           updated.InboundRoute,
+		}).Error; err != nil {
+			return err
+		}
+		if upd.touchesSettings() {
+			return tx.Save(updated.Settings).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return ClientNumber{}, fmt.Errorf("failed to update number in database: %w", err)
+	}
+
+	gateway.setNumber(client, updated)
+	return updated, nil
+}
+
+// setNumber replaces one of the client's numbers in memory, in both
+// client.Numbers and the gateway's number map.
+func (gateway *Gateway) setNumber(client *Client, num ClientNumber) {
+	gateway.mu.Lock()
+	defer gateway.mu.Unlock()
+
+	for i := range client.Numbers {
+		if client.Numbers[i].ID == num.ID {
+			client.Numbers[i] = num
+		}
+	}
+	if gateway.Numbers != nil {
+		n := num
+		gateway.Numbers[num.Number] = &n
+	}
+}
+
 // addNumber adds a new number to a client by ID.
 func (gateway *Gateway) addNumber(clientID uint, number *ClientNumber) error {
-	// Normalize number to E.164 without + prefix
-	// Strip leading + if present, ensure it starts with country code
-	normalizedNumber := strings.TrimPrefix(number.Number, "+")
-	// Remove any non-digit characters
-	normalizedNumber = strings.Map(func(r rune) rune {
-		if r >= '0' && r <= '9' {
-			return r
-		}
-		return -1
-	}, normalizedNumber)
-	number.Number = normalizedNumber
+	// Normalize number to E.164 without + prefix. Alphanumeric sender IDs
+	// such as "MYBRAND" are kept as they are.
+	if !isAlphanumericSender(number.Number) {
+		// Strip leading + if present, ensure it starts with country code
+		normalizedNumber := strings.TrimPrefix(number.Number, "+")
+		// Remove any non-digit characters
+		normalizedNumber = strings.Map(func(r rune) rune {
+			if r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, normalizedNumber)
+		number.Number = normalizedNumber
+	}
+	if number.Number == "" {
+		return fmt.Errorf("number must be a phone number or an alphanumeric sender ID")
+	}
 
 	// Check if the client exists
 	client := gateway.getClientByID(clientID)
@@ -502,6 +945,7 @@ func (gateway *Gateway) reloadClientsAndNumbers() error {
 	if err := gateway.loadClients(); err != nil {
 		return err
 	}
+	gateway.syncLogPrivacy()
 	if err := gateway.loadNumbers(); err != nil {
 		return err
 	}
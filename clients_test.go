@@ -154,3 +154,48 @@ func TestGetPeriodStart_UnknownPeriodDefaultsTo24h(t *testing.T) {
 	assert.True(t, delta > 23*time.Hour && delta < 25*time.Hour,
 		"unknown period should default to ~24h ago, got %v", delta)
 }
+
+func TestFindClientNumber_ByIDOrNumber(t *testing.T) {
+	c := &Client{Numbers: []ClientNumber{
+		{ID: 4, Number: "12505551234"},
+		{ID: 9, Number: "14155559876"},
+	}}
+
+	assert.Equal(t, 1, findClientNumber(c, "9"))
+	assert.Equal(t, 0, findClientNumber(c, "12505551234"))
+	assert.Equal(t, 1, findClientNumber(c, "+14155559876"))
+	assert.Equal(t, -1, findClientNumber(c, "5"))
+	assert.Equal(t, -1, findClientNumber(c, "+16045550000"))
+}
+
+func TestNumberUpdate_ApplyCopiesSettings(t *testing.T) {
+	settings := &NumberSettings{NumberID: 4, AutoReplyMessage: "old"}
+	num := ClientNumber{ID: 4, Number: "12505551234", Carrier: "telnyx", Tag: "sales", Settings: settings}
+
+	carrier, msg := "twilio", "new"
+	got := numberUpdate{Carrier: &carrier, AutoReplyMessage: &msg}.apply(num)
+
+	assert.Equal(t, "twilio", got.Carrier)
+	assert.Equal(t, "sales", got.Tag, "unset fields are kept")
+	assert.Equal(t, "new", got.Settings.AutoReplyMessage)
+	assert.Equal(t, "old", settings.AutoReplyMessage, "original settings untouched")
+	assert.Equal(t, "telnyx", num.Carrier)
+}
+
+func TestGateway_SetNumberUpdatesRouting(t *testing.T) {
+	_, gw := newTestRouter(1)
+	client := &Client{Username: "pbx1", Numbers: []ClientNumber{{ID: 4, Number: "12505551234", Carrier: "telnyx"}}}
+	gw.Clients = map[string]*Client{"pbx1": client}
+	gw.Numbers = map[string]*ClientNumber{"12505551234": {ID: 4, Number: "12505551234", Carrier: "telnyx"}}
+
+	updated := client.Numbers[0]
+	updated.Carrier = "twilio"
+	gw.setNumber(client, updated)
+
+	carrier, err := gw.getCarrier("12505551234")
+	require.NoError(t, err)
+	assert.Equal(t, "twilio", carrier)
+	carrier, _ = gw.getClientCarrier("+12505551234")
+	assert.Equal(t, "twilio", carrier)
+	assert.Len(t, client.Numbers, 1, "updated in place, not duplicated")
+}
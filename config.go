@@ -0,0 +1,289 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// envReader reads typed settings from the environment. A value that does
+// not parse or is out of range leaves the default in place and is recorded,
+// so that startup can report every bad setting at once.
+type envReader struct {
+	errs []error
+}
+
+func (e *envReader) fail(name, val, want string) {
+	e.errs = append(e.errs, fmt.Errorf("%s=%q: want %s", name, val, want))
+}
+
+// err returns the settings that were rejected, or nil.
+func (e *envReader) err() error {
+	return errors.Join(e.errs...)
+}
+
+// str returns name, or def when it is unset or empty.
+func (e *envReader) str(name, def string) string {
+	if val := os.Getenv(name); val != "" {
+		return val
+	}
+	return def
+}
+
+// int returns name as an integer of at least min, or def when it is unset
+// or empty.
+func (e *envReader) int(name string, def, min int) int {
+	val := strings.TrimSpace(os.Getenv(name))
+	if val == "" {
+		return def
+	}
+	v, err := strconv.Atoi(val)
+	if err != nil || v < min {
+		e.fail(name, val, fmt.Sprintf("an integer >= %d", min))
+		return def
+	}
+	return v
+}
+
+// float returns name as a number of at least min, or def when it is unset
+// or empty.
+func (e *envReader) float(name string, def, min float64) float64 {
+	val := strings.TrimSpace(os.Getenv(name))
+	if val == "" {
+		return def
+	}
+	v, err := strconv.ParseFloat(val, 64)
+	if err != nil || v < min {
+		e.fail(name, val, fmt.Sprintf("a number >= %g", min))
+		return def
+	}
+	return v
+}
+
+// bool returns name as true/false or 1/0, or def when it is unset or empty.
+func (e *envReader) bool(name string, def bool) bool {
+	val := strings.TrimSpace(os.Getenv(name))
+	if val == "" {
+		return def
+	}
+	v, err := strconv.ParseBool(val)
+	if err != nil {
+		e.fail(name, val, "true or false")
+		return def
+	}
+	return v
+}
+
+// oneOf returns name lower-cased and trimmed, which must be accepted by
+// valid, or def when it is unset or empty.
+func (e *envReader) oneOf(name, def string, valid func(string) bool, want string) string {
+	val := strings.ToLower(strings.TrimSpace(os.Getenv(name)))
+	if val == "" {
+		return def
+	}
+	if !valid(val) {
+		e.fail(name, val, want)
+		return def
+	}
+	return val
+}
+
+// loadEnvFile loads ENV_FILE, or .env when it is unset, into the
+// environment without overriding variables that are already set. Only a
+// missing default .env is not an error.
+func loadEnvFile() error {
+	path := os.Getenv("ENV_FILE")
+	if path == "" {
+		if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	return godotenv.Load(path)
+}
+
+// defaultTrustedProxies are the private ranges trusted when TRUSTED_PROXIES
+// is unset.
+var defaultTrustedProxies = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"}
+
+func validLogLevel(level string) bool {
+	switch level {
+	case "debug", "info", "warn", "warning", "error":
+		return true
+	}
+	return false
+}
+
+// loadGatewayConfig loads the configuration from environment variables once
+// at startup. Every setting that is set but invalid is reported in the
+// returned error, along with a missing ENCRYPTION_KEY.
+func loadGatewayConfig() (GatewayConfig, error) {
+	env := &envReader{}
+	config := GatewayConfig{
+		WebhookRetries:          env.int("WEBHOOK_RETRIES", 3, 0),
+		WebhookTimeoutSecs:      env.int("WEBHOOK_TIMEOUT_SECS", 10, 1),
+		WebhookRetryDelaySecs:   env.int("WEBHOOK_RETRY_DELAY_SECS", 5, 0),
+		SMPPRetries:             env.int("SMPP_RETRIES", 3, 0),
+		SMPPTimeoutSecs:         env.int("SMPP_TIMEOUT_SECS", 30, 1),
+		SMPPConcatTimeoutSecs:   env.int("SMPP_CONCAT_TIMEOUT_SECS", 30, 1),
+		SMPPSubmitRateLimit:     env.int("SMPP_SUBMIT_RATE_LIMIT", 0, 0),
+		SMPPEnquireIntervalSecs: env.int("SMPP_ENQUIRE_INTERVAL", 15, 1),
+		SMPPEnquireTimeoutSecs:  env.int("SMPP_ENQUIRE_TIMEOUT", 0, 1),
+		SMPPResponseTimeoutSecs: env.int("SMPP_RESPONSE_TIMEOUT", 5, 1),
+		SMPPWindowSize:          env.int("SMPP_WINDOW_SIZE", 1, 1),
+		RetryBaseDelaySecs:      env.int("RETRY_BASE_DELAY_SECS", 10, 1),
+		RetryMaxDelaySecs:       env.int("RETRY_MAX_DELAY_SECS", 300, 1),
+		RetrySpillAfter:         env.int("RETRY_SPILL_AFTER", 0, 0),
+		MM4Retries:              env.int("MM4_RETRIES", 3, 0),
+		MM4TimeoutSecs:          env.int("MM4_TIMEOUT_SECS", 60, 1),
+		MM4DialTimeoutSecs:      env.int("MM4_DIAL_TIMEOUT", 10, 1),
+		MM4SessionDeadlineSecs:  env.int("MM4_SESSION_DEADLINE", 30, 1),
+		MM4SingleImage:          env.oneOf("MM4_SINGLE_IMAGE", MM4SingleImageSMIL, validMM4SingleImage, "smil or mixed"),
+		MM4MaxMessageBytes:      env.int("MM4_MAX_MESSAGE_BYTES", defaultMM4MaxMessageBytes, 0),
+		MaxConnsPerClient:       env.int("MAX_CONNS_PER_CLIENT", 0, 0),
+		MaxConnsTotal:           env.int("MAX_CONNS_TOTAL", 0, 0),
+		NotifySenderOnFailure:   env.bool("NOTIFY_SENDER_ON_FAILURE", true),
+		SMPPDLRFromCarrier:      env.bool("SMPP_DLR_FROM_CARRIER", false),
+
+		SMPPBindMaxFailures:       env.int("SMPP_BIND_MAX_FAILURES", 5, 0),
+		SMPPBindFailureWindowSecs: env.int("SMPP_BIND_FAILURE_WINDOW_SECS", 300, 1),
+		SMPPBindLockoutSecs:       env.int("SMPP_BIND_LOCKOUT_SECS", 900, 1),
+
+		TwilioValidateSignature: env.bool("TWILIO_VALIDATE_SIGNATURE", true),
+		SNSValidateSignature:    env.bool("SNS_VALIDATE_SIGNATURE", true),
+		InboundDedupeWindowSecs: env.int("INBOUND_DEDUPE_WINDOW_SECS", 300, 0),
+		ShutdownTimeoutSecs:     env.int("SHUTDOWN_TIMEOUT_SECS", 30, 1),
+		HighPriorityTypes:       []string{"sms"},
+		NumberValidation:        env.oneOf("NUMBER_VALIDATION", NumberValidationOff, validNumberValidation, "off or strict"),
+		LogPrivacy:              env.oneOf("LOG_PRIVACY", LogPrivacyNone, validLogPrivacy, "none, numbers or full"),
+		TranscodeFailureReply:   env.str("TRANSCODE_FAILURE_REPLY", ""),
+		TranscodePanicReply:     env.str("TRANSCODE_PANIC_REPLY", ""),
+		MediaTTLHours:           env.int("MEDIA_TTL_HOURS", 7*24, 1),
+		MediaMaxFetches:         env.int("MEDIA_MAX_FETCHES", 0, 0),
+		MediaGCGraceSecs:        env.int("MEDIA_GC_GRACE_SECS", 600, 0),
+
+		BulkMaxMessages:   env.int("BULK_MAX_MESSAGES", 1000, 1),
+		BulkTricklePerSec: env.int("BULK_TRICKLE_PER_SEC", 10, 0),
+
+		CarrierHTTPAttempts:        env.int("CARRIER_HTTP_ATTEMPTS", 3, 1),
+		CarrierHTTPBackoffMs:       env.int("CARRIER_HTTP_BACKOFF_MS", 500, 0),
+		CarrierBreakerThreshold:    env.int("CARRIER_BREAKER_THRESHOLD", 5, 0),
+		CarrierBreakerCooldownSecs: env.int("CARRIER_BREAKER_COOLDOWN_SECS", 30, 1),
+		CarrierMaxConcurrency:      env.int("CARRIER_MAX_CONCURRENCY", 0, 0),
+		CarrierMaxTPS:              env.float("CARRIER_MAX_TPS", 0, 0),
+		CarrierMMSLimits:           parseMMSLimits(os.Getenv("CARRIER_MMS_LIMITS")),
+
+		LogLevel:            env.oneOf("LOG_LEVEL", "info", validLogLevel, "debug, info, warn or error"),
+		Debug:               env.bool("DEBUG", false),
+		PprofListen:         env.str("PPROF_LISTEN", "0.0.0.0:42666"),
+		ServerID:            os.Getenv("SERVER_ID"),
+		ServerAddress:       strings.TrimRight(os.Getenv("SERVER_ADDRESS"), "/"),
+		EncryptionKey:       os.Getenv("ENCRYPTION_KEY"),
+		APIKey:              os.Getenv("API_KEY"),
+		TrustedProxies:      defaultTrustedProxies,
+		WebListen:           env.str("WEB_LISTEN", "0.0.0.0:3000"),
+		SMPPListen:          env.str("SMPP_LISTEN", "0.0.0.0:2775"),
+		SMPPTLSListen:       os.Getenv("SMPP_TLS_LISTEN"),
+		SMPPTLSCert:         os.Getenv("SMPP_TLS_CERT"),
+		SMPPTLSKey:          os.Getenv("SMPP_TLS_KEY"),
+		MM4Listen:           env.str("MM4_LISTEN", "0.0.0.0:2566"),
+		MM4TLSCert:          os.Getenv("MM4_TLS_CERT"),
+		MM4TLSKey:           os.Getenv("MM4_TLS_KEY"),
+		MM4Hostname:         strings.TrimSpace(os.Getenv("MM4_HOSTNAME")),
+		MM4MsgIDHost:        os.Getenv("MM4_MSG_ID_HOST"),
+		MM4OriginatorSystem: os.Getenv("MM4_ORIGINATOR_SYSTEM"),
+		MM4Debug:            env.bool("MM4_DEBUG", false),
+		ProxyProtocol:       env.bool("HAPROXY_PROXY_PROTOCOL", false),
+		PrometheusListen:    env.str("PROMETHEUS_LISTEN", "0.0.0.0:2550"),
+		PrometheusPath:      env.str("PROMETHEUS_PATH", "/metrics"),
+		MMSReplayMaxRetries: env.int("MMS_REPLAY_MAX_RETRIES", defaultMMSReplayMaxRetries, 1),
+		AWSMediaBucket:      os.Getenv("AWS_MEDIA_BUCKET"),
+		AutoReplyEnabled:    env.bool("AUTO_REPLY_ENABLED", false),
+		AutoReplyDefaultMsg: os.Getenv("AUTO_REPLY_DEFAULT_MESSAGE"),
+		HelpReplyMsg:        os.Getenv("HELP_REPLY_MESSAGE"),
+
+		LokiEnabled:  env.bool("LOKI_ENABLED", false),
+		LokiURL:      os.Getenv("LOKI_URL"),
+		LokiUsername: os.Getenv("LOKI_USERNAME"),
+		LokiPassword: os.Getenv("LOKI_PASSWORD"),
+
+		Postgres: postgresConfig{
+			Host:     env.str("POSTGRES_HOST", "localhost"),
+			Port:     env.int("POSTGRES_PORT", 5432, 1),
+			User:     os.Getenv("POSTGRES_USER"),
+			Password: os.Getenv("POSTGRES_PASSWORD"),
+			DBName:   os.Getenv("POSTGRES_DB"),
+			SSLMode:  env.str("POSTGRES_SSLMODE", "disable"),
+			TimeZone: env.str("POSTGRES_TIMEZONE", "America/Vancouver"),
+		},
+
+		Transcode:  loadTranscodeConfig(env),
+		SMILLayout: loadSMILLayout(env),
+		MM4Capture: loadMM4CaptureConfig(env),
+		MediaFetch: loadMediaFetchConfig(env),
+	}
+
+	if val, ok := os.LookupEnv("HIGH_PRIORITY_TYPES"); ok {
+		config.HighPriorityTypes = nil
+		for _, t := range strings.Split(val, ",") {
+			if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+				config.HighPriorityTypes = append(config.HighPriorityTypes, t)
+			}
+		}
+	}
+	if val := strings.TrimSpace(os.Getenv("DEFAULT_COUNTRY_CODE")); val != "" {
+		if cc := strings.TrimPrefix(val, "+"); validCountryCode(cc) {
+			config.DefaultCountryCode = cc
+		} else {
+			env.fail("DEFAULT_COUNTRY_CODE", val, "a country calling code such as 1 or 44")
+		}
+	}
+	if val := os.Getenv("SMS_GSM7_REPLACEMENTS"); val != "" {
+		config.GSM7Replacements = parseGSM7Replacements(val)
+	}
+	if val := os.Getenv("TRUSTED_PROXIES"); val != "" {
+		config.TrustedProxies = nil
+		for _, cidr := range strings.Split(val, ",") {
+			if cidr = strings.TrimSpace(cidr); cidr == "" {
+				continue
+			}
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				env.fail("TRUSTED_PROXIES", cidr, "CIDR ranges such as 10.0.0.0/8")
+				continue
+			}
+			config.TrustedProxies = append(config.TrustedProxies, cidr)
+		}
+	}
+	if config.SMPPTLSListen != "" && (config.SMPPTLSCert == "" || config.SMPPTLSKey == "") {
+		env.errs = append(env.errs, errors.New("SMPP_TLS_LISTEN needs SMPP_TLS_CERT and SMPP_TLS_KEY"))
+	}
+	if config.EncryptionKey == "" {
+		env.errs = append(env.errs, errors.New("ENCRYPTION_KEY environment variable not set"))
+	}
+
+	return config, env.err()
+}
+
+// postgresConfig holds the POSTGRES_* connection settings.
+type postgresConfig struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	DBName   string
+	SSLMode  string
+	TimeZone string
+}
+
+// dsn returns the connection string for gorm's postgres driver.
+func (c postgresConfig) dsn() string {
+	return fmt.Sprintf(
+		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s TimeZone=%s",
+		c.Host, c.Port, c.User, c.Password, c.DBName, c.SSLMode, c.TimeZone,
+	)
+}
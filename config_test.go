@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mustLoadGatewayConfig loads the configuration from the test's environment,
+// which must be valid.
+func mustLoadGatewayConfig(t *testing.T) GatewayConfig {
+	t.Helper()
+	config, err := loadGatewayConfig()
+	require.NoError(t, err)
+	return config
+}
+
+func TestLoadGatewayConfig_Defaults(t *testing.T) {
+	t.Setenv("ENCRYPTION_KEY", "test-key")
+	t.Setenv("SERVER_ADDRESS", "https://gw.example.com/")
+	config := mustLoadGatewayConfig(t)
+
+	assert.Equal(t, "0.0.0.0:3000", config.WebListen)
+	assert.Equal(t, "0.0.0.0:2775", config.SMPPListen)
+	assert.Equal(t, "0.0.0.0:2566", config.MM4Listen)
+	assert.Equal(t, "/metrics", config.PrometheusPath)
+	assert.Equal(t, defaultTrustedProxies, config.TrustedProxies)
+	assert.Equal(t, "https://gw.example.com", config.ServerAddress, "no trailing slash")
+	assert.Equal(t, "info", config.LogLevel)
+	assert.Equal(t, targetOutputSize, config.Transcode.MaxImageBytes)
+	assert.Equal(t, "host=localhost port=5432 user= password= dbname= sslmode=disable TimeZone=America/Vancouver", config.Postgres.dsn())
+}
+
+func TestLoadGatewayConfig_RejectsBadValues(t *testing.T) {
+	t.Setenv("ENCRYPTION_KEY", "")
+	t.Setenv("SMPP_WINDOW_SIZE", "0")
+	t.Setenv("CARRIER_MAX_TPS", "fast")
+	t.Setenv("NOTIFY_SENDER_ON_FAILURE", "maybe")
+	t.Setenv("LOG_PRIVACY", "some")
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.168.1.1")
+	t.Setenv("MMS_MAX_IMAGE_BYTES", "-5")
+	t.Setenv("SMPP_TLS_LISTEN", "0.0.0.0:3550")
+	t.Setenv("MEDIA_FETCH_PROXY", "ftp://proxy.example.com")
+
+	config, err := loadGatewayConfig()
+	require.Error(t, err)
+	for _, want := range []string{
+		`SMPP_WINDOW_SIZE="0": want an integer >= 1`,
+		`CARRIER_MAX_TPS="fast": want a number >= 0`,
+		`NOTIFY_SENDER_ON_FAILURE="maybe": want true or false`,
+		`LOG_PRIVACY="some": want none, numbers or full`,
+		`TRUSTED_PROXIES="192.168.1.1"`,
+		`MMS_MAX_IMAGE_BYTES="-5"`,
+		"SMPP_TLS_LISTEN needs SMPP_TLS_CERT and SMPP_TLS_KEY",
+		`MEDIA_FETCH_PROXY="ftp://proxy.example.com"`,
+		"ENCRYPTION_KEY environment variable not set",
+	} {
+		assert.ErrorContains(t, err, want)
+	}
+	assert.Equal(t, 1, config.SMPPWindowSize, "a rejected value keeps the default")
+	assert.Equal(t, []string{"10.0.0.0/8"}, config.TrustedProxies)
+}
+
+func TestLoadEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.env")
+	require.NoError(t, os.WriteFile(path, []byte("GW_TEST_FROM_FILE=file\nGW_TEST_FROM_ENV=file\n"), 0o600))
+	t.Setenv("ENV_FILE", path)
+	t.Setenv("GW_TEST_FROM_ENV", "env")
+	t.Cleanup(func() { os.Unsetenv("GW_TEST_FROM_FILE") })
+
+	require.NoError(t, loadEnvFile())
+	assert.Equal(t, "file", os.Getenv("GW_TEST_FROM_FILE"))
+	assert.Equal(t, "env", os.Getenv("GW_TEST_FROM_ENV"), "the environment wins over the file")
+
+	t.Setenv("ENV_FILE", filepath.Join(t.TempDir(), "missing.env"))
+	assert.Error(t, loadEnvFile(), "an explicit file must exist")
+}
@@ -0,0 +1,139 @@
+package main
+
+import "sync"
+
+// connLimiter caps the concurrent connections one server accepts, per client
+// and in total. Slots are held by an owner (a session) so releasing twice, or
+// acquiring again for a session that rebinds, is harmless. A nil connLimiter
+// allows everything.
+type connLimiter struct {
+	server string // "smpp" or "mm4", the metric label
+
+	mu      sync.Mutex
+	owners  map[any]string // owner -> client username
+	clients map[string]int // client username -> open connections
+}
+
+func newConnLimiter(server string) *connLimiter {
+	return &connLimiter{
+		server:  server,
+		owners:  make(map[any]string),
+		clients: make(map[string]int),
+	}
+}
+
+// acquire takes a slot for owner on username's behalf unless username already
+// has perClient connections or the server has total. A limit of 0 is
+// unlimited. An owner that already holds a slot for username keeps it.
+func (l *connLimiter) acquire(owner any, username string, perClient, total int) bool {
+	return l.acquireReplacing(owner, nil, username, perClient, total)
+}
+
+// acquireReplacing is acquire for an owner that takes over replaced's
+// connection, as a rebind does. If replaced holds a slot for username, that
+// slot does not count against the caps and is freed only once owner has its
+// own; if owner is refused, replaced keeps it. replaced may be nil.
+func (l *connLimiter) acquireReplacing(owner, replaced any, username string, perClient, total int) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if held, ok := l.owners[owner]; ok {
+		if held == username {
+			return true
+		}
+		l.releaseLocked(owner, held)
+	}
+	clientConns, totalConns := l.clients[username], len(l.owners)
+	replacing := false
+	if replaced != nil && replaced != owner {
+		replacing = l.owners[replaced] == username
+	}
+	if replacing {
+		clientConns--
+		totalConns--
+	}
+	if perClient > 0 && clientConns >= perClient {
+		return false
+	}
+	if total > 0 && totalConns >= total {
+		return false
+	}
+	if replacing {
+		l.releaseLocked(replaced, username)
+	}
+	l.owners[owner] = username
+	l.clients[username]++
+	gatewayConnections.WithLabelValues(l.server, username).Set(float64(l.clients[username]))
+	return true
+}
+
+// release frees owner's slot, if it holds one.
+func (l *connLimiter) release(owner any) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if username, ok := l.owners[owner]; ok {
+		l.releaseLocked(owner, username)
+	}
+}
+
+func (l *connLimiter) releaseLocked(owner any, username string) {
+	delete(l.owners, owner)
+	l.clients[username]--
+	if l.clients[username] <= 0 {
+		delete(l.clients, username)
+		gatewayConnections.DeleteLabelValues(l.server, username)
+		return
+	}
+	gatewayConnections.WithLabelValues(l.server, username).Set(float64(l.clients[username]))
+}
+
+// counts returns the open connections per client and in total.
+func (l *connLimiter) counts() (map[string]int, int) {
+	clients := make(map[string]int)
+	if l == nil {
+		return clients, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for username, n := range l.clients {
+		clients[username] = n
+	}
+	return clients, len(l.owners)
+}
+
+// maxClientConns returns how many connections client may hold open on each
+// of the MM4 and SMPP servers: its max_connections, then MAX_CONNS_PER_CLIENT.
+// 0 is unlimited.
+func (gateway *Gateway) maxClientConns(client *Client) int {
+	if client != nil && client.Settings != nil && client.Settings.MaxConnections > 0 {
+		return client.Settings.MaxConnections
+	}
+	return gateway.Config.MaxConnsPerClient
+}
+
+// ConnectionStats reports a server's open connections against its caps.
+type ConnectionStats struct {
+	Total        int            `json:"total"`
+	MaxTotal     int            `json:"max_total"`      // 0 = unlimited
+	MaxPerClient int            `json:"max_per_client"` // default; clients may override
+	Clients      map[string]int `json:"clients"`        // username -> open connections
+}
+
+// connectionStats returns l's counts for the stats endpoint.
+func (gateway *Gateway) connectionStats(l *connLimiter) ConnectionStats {
+	clients, total := l.counts()
+	return ConnectionStats{
+		Total:        total,
+		MaxTotal:     gateway.Config.MaxConnsTotal,
+		MaxPerClient: gateway.Config.MaxConnsPerClient,
+		Clients:      clients,
+	}
+}
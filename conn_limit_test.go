@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+	"zultys-smpp-mm4/smpp"
+	"zultys-smpp-mm4/smpp/pdu"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnLimiter_Caps(t *testing.T) {
+	l := newConnLimiter("test")
+
+	assert.True(t, l.acquire("a1", "pbx1", 2, 3))
+	assert.True(t, l.acquire("a2", "pbx1", 2, 3))
+	assert.False(t, l.acquire("a3", "pbx1", 2, 3), "per-client cap")
+	assert.True(t, l.acquire("a2", "pbx1", 2, 3), "an owner keeps its slot")
+	assert.True(t, l.acquire("b1", "pbx2", 2, 3))
+	assert.False(t, l.acquire("b2", "pbx2", 2, 3), "total cap")
+
+	l.release("a1")
+	l.release("a1")
+	clients, total := l.counts()
+	assert.Equal(t, map[string]int{"pbx1": 1, "pbx2": 1}, clients)
+	assert.Equal(t, 2, total)
+
+	assert.True(t, l.acquire("b2", "pbx2", 0, 0), "0 is unlimited")
+
+	var disabled *connLimiter
+	assert.True(t, disabled.acquire("x", "pbx1", 1, 1))
+	disabled.release("x")
+}
+
+func TestConnLimiter_AcquireReplacing(t *testing.T) {
+	l := newConnLimiter("test")
+	require.True(t, l.acquire("a1", "pbx1", 1, 2))
+	require.True(t, l.acquire("b1", "pbx2", 1, 2))
+
+	assert.False(t, l.acquire("a2", "pbx1", 1, 2))
+	assert.False(t, l.acquireReplacing("c1", "a1", "pbx3", 1, 2), "only the same client's slot is replaced")
+	clients, total := l.counts()
+	assert.Equal(t, map[string]int{"pbx1": 1, "pbx2": 1}, clients, "a refused bind leaves the replaced slot held")
+	assert.Equal(t, 2, total)
+
+	assert.True(t, l.acquireReplacing("a2", "a1", "pbx1", 1, 2))
+	l.release("a1")
+	clients, total = l.counts()
+	assert.Equal(t, map[string]int{"pbx1": 1, "pbx2": 1}, clients, "a1's slot passed to a2")
+	assert.Equal(t, 2, total)
+}
+
+func TestGateway_MaxClientConns(t *testing.T) {
+	gw := &Gateway{Config: GatewayConfig{MaxConnsPerClient: 4}}
+	assert.Equal(t, 4, gw.maxClientConns(nil))
+	assert.Equal(t, 4, gw.maxClientConns(&Client{Settings: &ClientSettings{}}))
+	assert.Equal(t, 2, gw.maxClientConns(&Client{Settings: &ClientSettings{MaxConnections: 2}}))
+}
+
+func TestHandleBind_ConnectionCap(t *testing.T) {
+	_, gw := newTestRouter(1)
+	gw.Config.MaxConnsTotal = 1
+	gw.Clients = map[string]*Client{
+		"pbx1": {Username: "pbx1", Password: "secret"},
+		"pbx2": {Username: "pbx2", Password: "secret"},
+	}
+
+	srv := &SMPPServer{
+		gateway:    gw,
+		conns:      map[string]*smpp.Session{},
+		connLimits: newConnLimiter("smpp"),
+	}
+	h := NewSimpleHandler(srv)
+
+	bind := func(username string) (*smpp.Session, pdu.CommandStatus) {
+		serverConn, clientConn := net.Pipe()
+		t.Cleanup(func() { _ = clientConn.Close() })
+		session := smpp.NewSession(context.Background(), serverConn)
+
+		req := &pdu.BindTransceiver{SystemID: username, Password: "secret", Version: pdu.SMPPVersion34}
+		req.Header.Sequence = 1
+		go h.handleBind(session, req)
+
+		resp, ok := readTestPDU(t, clientConn).(*pdu.BindTransceiverResp)
+		require.True(t, ok)
+		return session, resp.Header.CommandStatus
+	}
+
+	first, status := bind("pbx1")
+	assert.Equal(t, pdu.ESME_ROK, status)
+	require.Eventually(t, func() bool { return srv.isSessionActive("pbx1") }, time.Second, 10*time.Millisecond)
+	_, status = bind("pbx2")
+	assert.Equal(t, pdu.ErrBindFail, status, "the gateway is at MAX_CONNS_TOTAL")
+
+	// A rebind replaces the client's old session rather than adding one.
+	_, status = bind("pbx1")
+	assert.Equal(t, pdu.ESME_ROK, status)
+	_, total := srv.connLimits.counts()
+	assert.Equal(t, 1, total)
+
+	srv.connLimits.release(first)
+	_, total = srv.connLimits.counts()
+	assert.Equal(t, 1, total, "the replaced session no longer holds a slot")
+}
@@ -13,6 +13,7 @@ type ConvoQueue struct {
 	queue         []MsgQueueItem
 	inFlight      bool
 	expectedAckID string
+	ackSince      time.Time   // when expectedAckID was set
 	ackTimer      *time.Timer // new field for the ack timeout timer
 	mu            sync.Mutex
 }
@@ -67,6 +68,7 @@ func (cm *ConvoManager) AddMessage(convoID string, msg MsgQueueItem, router *Rou
 			logrus.DebugLevel,
 			map[string]interface{}{
 				"logID":        msg.LogID,
+				"traceID":      msg.TraceID,
 				"convoID":      convoID,
 				"from":         msg.From,
 				"to":           msg.To,
@@ -77,7 +79,7 @@ func (cm *ConvoManager) AddMessage(convoID string, msg MsgQueueItem, router *Rou
 		))
 
 		// Send the message into the normal routing path.
-		router.ClientMsgChan <- nextMsg
+		router.clientQueue(nextMsg.Priority) <- nextMsg
 	} else {
 		// Debug: Log that message was queued
 		lm.SendLog(lm.BuildLog(
@@ -86,6 +88,7 @@ func (cm *ConvoManager) AddMessage(convoID string, msg MsgQueueItem, router *Rou
 			logrus.DebugLevel,
 			map[string]interface{}{
 				"logID":       msg.LogID,
+				"traceID":     msg.TraceID,
 				"convoID":     convoID,
 				"from":        msg.From,
 				"to":          msg.To,
@@ -116,6 +119,7 @@ func (cm *ConvoManager) SetExpectedAck(convoID, ackID string, router *Router, ti
 	// Lock the conversation queue to update expectedAckID and its timer.
 	cq.mu.Lock()
 	cq.expectedAckID = ackID
+	cq.ackSince = time.Now()
 	// If an old timer exists, stop it.
 	if cq.ackTimer != nil {
 		cq.ackTimer.Stop()
@@ -157,12 +161,13 @@ func (cm *ConvoManager) HandleAck(convoID, receivedAck string, router *Router) {
 		// Clear current in-flight state.
 		cq.inFlight = false
 		cq.expectedAckID = ""
+		cq.ackSince = time.Time{}
 		// If there are queued messages, release the next one.
 		if len(cq.queue) > 0 {
 			nextMsg := cq.queue[0]
 			cq.queue = cq.queue[1:]
 			cq.inFlight = true
-			router.ClientMsgChan <- nextMsg
+			router.clientQueue(nextMsg.Priority) <- nextMsg
 		}
 	}
 	cq.mu.Unlock()
@@ -183,13 +188,14 @@ func (cm *ConvoManager) HandleFailure(convoID string, router *Router) {
 		// Clear current in-flight state.
 		cq.inFlight = false
 		cq.expectedAckID = ""
+		cq.ackSince = time.Time{}
 
 		// If there are queued messages, release the next one.
 		if len(cq.queue) > 0 {
 			nextMsg := cq.queue[0]
 			cq.queue = cq.queue[1:]
 			cq.inFlight = true
-			router.ClientMsgChan <- nextMsg
+			router.clientQueue(nextMsg.Priority) <- nextMsg
 		}
 	}
 	cq.mu.Unlock()
@@ -213,6 +219,52 @@ func (cm *ConvoManager) HandleCarrierAck(ackID string, router *Router) {
 	cm.HandleAck(convoID, ackID, router)
 }
 
+// awaitingCarrierAck reports whether a conversation is waiting on ackID.
+func (cm *ConvoManager) awaitingCarrierAck(ackID string) bool {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	_, exists := cm.ackMap[ackID]
+	return exists
+}
+
+// ConvoStats is a point-in-time summary of the ConvoManager.
+type ConvoStats struct {
+	ActiveConversations  int     `json:"active_conversations"`    // in flight or with messages queued
+	QueuedMessages       int     `json:"queued_messages"`         // waiting behind an in-flight message
+	PendingAcks          int     `json:"pending_acks"`            // in-flight messages waiting on a carrier ack
+	OldestPendingAckSecs float64 `json:"oldest_pending_ack_secs"` // age of the longest-waiting ack, 0 if none
+}
+
+// Stats summarises the conversations. The queue list is copied under cm.mu
+// and each queue is then read under its own lock, the same order AddMessage
+// and SetExpectedAck use, so neither lock is held while taking the other.
+func (cm *ConvoManager) Stats() ConvoStats {
+	cm.mu.Lock()
+	queues := make([]*ConvoQueue, 0, len(cm.queues))
+	for _, cq := range cm.queues {
+		queues = append(queues, cq)
+	}
+	cm.mu.Unlock()
+
+	now := time.Now()
+	var stats ConvoStats
+	for _, cq := range queues {
+		cq.mu.Lock()
+		if cq.inFlight || len(cq.queue) > 0 {
+			stats.ActiveConversations++
+		}
+		stats.QueuedMessages += len(cq.queue)
+		if cq.inFlight && cq.expectedAckID != "" {
+			stats.PendingAcks++
+			if age := now.Sub(cq.ackSince).Seconds(); age > stats.OldestPendingAckSecs {
+				stats.OldestPendingAckSecs = age
+			}
+		}
+		cq.mu.Unlock()
+	}
+	return stats
+}
+
 // computeCorrelationKey creates a conversation ID (hash) from from/to.
 // In production, consider using SHA-256. Here we use a simple lower-case concatenation.
 func computeCorrelationKey(from, to string) string {
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"fmt"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -17,9 +19,11 @@ func newTestRouter(bufferSize int) (*Router, *Gateway) {
 		LogManager: NewLogManager(NewLokiClient("", "", ""), false),
 	}
 	r := &Router{
-		gateway:        gw,
-		ClientMsgChan:  make(chan MsgQueueItem, bufferSize),
-		CarrierMsgChan: make(chan MsgQueueItem, bufferSize),
+		gateway:                gw,
+		ClientMsgChan:          make(chan MsgQueueItem, bufferSize),
+		CarrierMsgChan:         make(chan MsgQueueItem, bufferSize),
+		ClientPriorityMsgChan:  make(chan MsgQueueItem, bufferSize),
+		CarrierPriorityMsgChan: make(chan MsgQueueItem, bufferSize),
 	}
 	gw.Router = r
 	return r, gw
@@ -249,3 +253,65 @@ func TestConvoManager_TimeoutTriggersImplicitAck(t *testing.T) {
 		}
 	}, 1*time.Second, 10*time.Millisecond, "expected m2 to release after timeout")
 }
+
+func TestConvoManager_Stats(t *testing.T) {
+	r, _ := newTestRouter(4)
+	cm := NewConvoManager()
+	assert.Equal(t, ConvoStats{}, cm.Stats())
+
+	cm.AddMessage("c1", MsgQueueItem{LogID: "m1"}, r)
+	<-r.ClientMsgChan
+	cm.AddMessage("c1", MsgQueueItem{LogID: "m2"}, r)
+	cm.AddMessage("c2", MsgQueueItem{LogID: "n1"}, r)
+	<-r.ClientMsgChan
+	cm.SetExpectedAck("c1", "ack1", r, 5*time.Second)
+	time.Sleep(20 * time.Millisecond)
+
+	stats := cm.Stats()
+	assert.Equal(t, 2, stats.ActiveConversations)
+	assert.Equal(t, 1, stats.QueuedMessages)
+	assert.Equal(t, 1, stats.PendingAcks)
+	assert.GreaterOrEqual(t, stats.OldestPendingAckSecs, 0.02)
+
+	cm.HandleAck("c1", "ack1", r)
+	<-r.ClientMsgChan
+	cm.HandleFailure("c1", r)
+	cm.HandleFailure("c2", r)
+	assert.Equal(t, ConvoStats{}, cm.Stats())
+}
+
+func TestConvoManager_StatsConcurrentWithAdds(t *testing.T) {
+	r, _ := newTestRouter(1000)
+	cm := NewConvoManager()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				convo := fmt.Sprintf("c%d-%d", i, j%10)
+				cm.AddMessage(convo, MsgQueueItem{LogID: fmt.Sprintf("m%d-%d", i, j)}, r)
+				cm.SetExpectedAck(convo, fmt.Sprintf("ack%d-%d", i, j), r, time.Minute)
+			}
+		}(i)
+	}
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				cm.Stats()
+			}
+		}
+	}()
+	wg.Wait()
+	close(done)
+
+	stats := cm.Stats()
+	assert.Equal(t, 40, stats.ActiveConversations)
+	assert.Equal(t, 40, stats.PendingAcks)
+	assert.Equal(t, 400-40, stats.QueuedMessages)
+}
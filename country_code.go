@@ -0,0 +1,58 @@
+package main
+
+import "strings"
+
+// withCountryCode returns number with country code cc in front when it looks
+// like a national number of that country: no leading +, and a length (after
+// dropping a leading trunk 0) that nationalLengths allows for cc, unless it
+// already starts with cc followed by such a length. Any other number, and
+// every number when cc is empty or not in nationalLengths, is returned as is
+// for FormatToE164 to handle.
+func withCountryCode(number, cc string) string {
+	lengths, ok := nationalLengths[cc]
+	if !ok || strings.HasPrefix(strings.TrimSpace(number), "+") {
+		return number
+	}
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, strings.Split(number, "/")[0])
+
+	national := func(n string) bool { return len(n) >= lengths[0] && len(n) <= lengths[1] }
+	if strings.HasPrefix(digits, cc) && national(digits[len(cc):]) {
+		return number
+	}
+	if national(digits) && digits[0] != '0' {
+		return "+" + cc + digits
+	}
+	if trunk := strings.TrimPrefix(digits, "0"); trunk != digits && national(trunk) {
+		return "+" + cc + trunk
+	}
+	return number
+}
+
+// defaultCountryCode returns the country code assumed for client's numbers
+// that have none: its own setting where set, otherwise DEFAULT_COUNTRY_CODE.
+func (gateway *Gateway) defaultCountryCode(client *Client) string {
+	if client != nil && client.Settings != nil && client.Settings.DefaultCountryCode != "" {
+		return client.Settings.DefaultCountryCode
+	}
+	return gateway.Config.DefaultCountryCode
+}
+
+// formatClientNumber is FormatToE164 for a number given by client, adding
+// its default country code to a national number first.
+func (gateway *Gateway) formatClientNumber(number string, client *Client) (string, error) {
+	return FormatToE164(withCountryCode(number, gateway.defaultCountryCode(client)))
+}
+
+// formatClientSender is formatSender for a source address given by client.
+func (gateway *Gateway) formatClientSender(from string, client *Client) string {
+	if isAlphanumericSender(from) {
+		return from
+	}
+	formatted, _ := gateway.formatClientNumber(from, client)
+	return formatted
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithCountryCode(t *testing.T) {
+	cases := []struct {
+		number, cc, want string
+	}{
+		{"5551234567", "1", "+15551234567"},
+		{"(555) 123-4567", "1", "+15551234567"},
+		{"15551234567", "1", "15551234567"},
+		{"+447700900123", "1", "+447700900123"},
+		{"07700900123", "44", "+447700900123"},
+		{"447700900123", "44", "447700900123"},
+		{"5551234567", "", "5551234567"},
+		{"5551234567", "999", "5551234567"},
+		{"123", "1", "123"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, withCountryCode(c.number, c.cc), "%s with %s", c.number, c.cc)
+	}
+}
+
+func TestFormatClientNumber(t *testing.T) {
+	_, gw := newTestRouter(1)
+	gw.Config.DefaultCountryCode = "1"
+
+	got, err := gw.formatClientNumber("5551234567", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "+15551234567", got, "10-digit US number")
+
+	got, err = gw.formatClientNumber("15551234567", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "+15551234567", got, "11-digit number with a leading 1")
+
+	got, err = gw.formatClientNumber("+447700900123", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "+447700900123", got, "already international")
+
+	uk := &Client{Settings: &ClientSettings{DefaultCountryCode: "44"}}
+	got, err = gw.formatClientNumber("07700900123", uk)
+	require.NoError(t, err)
+	assert.Equal(t, "+447700900123", got, "the client's own country code wins")
+	assert.Equal(t, "MYBRAND", gw.formatClientSender("MYBRAND", uk))
+
+	gw.Config.DefaultCountryCode = ""
+	got, _ = gw.formatClientNumber("5551234567", nil)
+	assert.Equal(t, "+5551234567", got, "unchanged without a default")
+}
+
+func TestMM4Recipients_CountryCode(t *testing.T) {
+	recipients, ok := mm4Recipients([]string{"5551234567", "+15551234567"}, "1")
+	require.True(t, ok)
+	assert.Equal(t, []string{"+15551234567"}, recipients)
+}
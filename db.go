@@ -1,6 +1,10 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
 
 func (gateway *Gateway) createIndexes() error {
 	// Create index on expires_at column
@@ -8,11 +12,29 @@ func (gateway *Gateway) createIndexes() error {
 	if err != nil {
 		return fmt.Errorf("failed to create index on expires_at: %v", err)
 	}
+
+	// Trigram index for the partial-number search of GET /numbers. pg_trgm
+	// may need privileges the gateway's role lacks, so failing here only
+	// makes the search slower.
+	err = gateway.DB.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error
+	if err == nil {
+		err = gateway.DB.Exec("CREATE INDEX IF NOT EXISTS idx_client_numbers_number_trgm ON client_numbers USING gin (number gin_trgm_ops)").Error
+	}
+	if err != nil {
+		lm := gateway.LogManager
+		lm.SendLog(lm.BuildLog(
+			"System.Database",
+			"NumberSearchIndexUnavailable",
+			logrus.WarnLevel,
+			nil,
+			err,
+		))
+	}
 	return nil
 }
 
 func (gateway *Gateway) migrateSchema() error {
-	if err := gateway.DB.AutoMigrate(&Client{}, &ClientNumber{}, &ClientSettings{}, &NumberSettings{}, &ClientFailover{}, &Carrier{}, &MediaFile{}, &MsgRecordDBItem{}, &TenantAPIKey{}, &APIKeyNumber{}, &BatchJob{}, &BatchMessageItem{}); err != nil {
+	if err := gateway.DB.AutoMigrate(&Client{}, &ClientNumber{}, &ClientSettings{}, &NumberSettings{}, &ClientFailover{}, &Carrier{}, &MediaFile{}, &MsgRecordDBItem{}, &TenantAPIKey{}, &APIKeyNumber{}, &BatchJob{}, &BatchMessageItem{}, &PendingMMS{}, &CarrierRoute{}, &MessageRecord{}, &OptOut{}, &ScheduledMessage{}, &BlockedNumber{}); err != nil {
 		return err
 	}
 	err := gateway.createIndexes()
@@ -2,8 +2,7 @@ package main
 
 import (
 	"fmt"
-	"os"
-	"strconv"
+	"net/http"
 	"strings"
 	"sync"
 
@@ -23,12 +22,164 @@ type GatewayConfig struct {
 	SMPPRetries     int `json:"smpp_retries"`      // Default: 3
 	SMPPTimeoutSecs int `json:"smpp_timeout_secs"` // Default: 30
 
+	// How long to wait for the remaining segments of a concatenated submit_sm
+	SMPPConcatTimeoutSecs int `json:"smpp_concat_timeout_secs"` // Default: 30
+
+	// Default per-client submit_sm rate (messages/sec) when the client has none set
+	SMPPSubmitRateLimit int `json:"smpp_submit_rate_limit"` // Default: 0 (unlimited)
+
+	// Failed binds per source IP or system_id within the window before further
+	// binds are refused for the lockout period
+	SMPPBindMaxFailures       int `json:"smpp_bind_max_failures"`        // Default: 5 (0 = no lockout)
+	SMPPBindFailureWindowSecs int `json:"smpp_bind_failure_window_secs"` // Default: 300
+	SMPPBindLockoutSecs       int `json:"smpp_bind_lockout_secs"`        // Default: 900
+
+	// deliver_sm that may await a response per session (can be overridden per-client)
+	SMPPWindowSize int `json:"smpp_window_size"` // Default: 1 (one at a time)
+
+	// enquire_link keepalive and deliver_sm_resp wait (can be overridden per-client)
+	SMPPEnquireIntervalSecs int `json:"smpp_enquire_interval"` // Default: 15
+	SMPPEnquireTimeoutSecs  int `json:"smpp_enquire_timeout"`  // Default: 0 (use SMPP_TIMEOUT_SECS)
+	SMPPResponseTimeoutSecs int `json:"smpp_response_timeout"` // Default: 5
+
+	// Retry backoff: base * 2^(attempt-1), capped
+	RetryBaseDelaySecs int `json:"retry_base_delay_secs"` // Default: 10
+	RetryMaxDelaySecs  int `json:"retry_max_delay_secs"`  // Default: 300
+	RetrySpillAfter    int `json:"retry_spill_after"`     // Default: 0 (spill only when the router is busy)
+
 	// MM4 (MMS) defaults
 	MM4Retries     int `json:"mm4_retries"`      // Default: 3
 	MM4TimeoutSecs int `json:"mm4_timeout_secs"` // Default: 60
 
+	// Outbound MM4 connect timeout and per-read/write deadline (can be overridden per-client)
+	MM4DialTimeoutSecs     int `json:"mm4_dial_timeout_secs"`     // Default: 10
+	MM4SessionDeadlineSecs int `json:"mm4_session_deadline_secs"` // Default: 30
+
+	// How a lone image is delivered to MM4 clients, "smil" or "mixed" (can be overridden per-client)
+	MM4SingleImage string `json:"mm4_single_image"` // Default: "smil"
+
+	// Largest inbound MM4 DATA body accepted, as received (base64-encoded)
+	MM4MaxMessageBytes int `json:"mm4_max_message_bytes"` // Default: 42991616 (41 MB, 0 = unlimited)
+
+	// Concurrent connections accepted by each of the MM4 and SMPP servers
+	MaxConnsPerClient int `json:"max_conns_per_client"` // Default: 0 (unlimited, can be overridden per-client)
+	MaxConnsTotal     int `json:"max_conns_total"`      // Default: 0 (unlimited)
+
 	// Failure notification
 	NotifySenderOnFailure bool `json:"notify_sender_on_failure"` // Send error back to original sender
+
+	// Send SMPP delivery receipts from the carrier's delivery report rather
+	// than on hand-off to the carrier
+	SMPPDLRFromCarrier bool `json:"smpp_dlr_from_carrier"` // Default: false
+
+	// Inbound webhook authentication
+	TwilioValidateSignature bool `json:"twilio_validate_signature"` // Default: true
+	SNSValidateSignature    bool `json:"sns_validate_signature"`    // Default: true
+
+	// Window in which a repeated carrier message ID is dropped as a webhook retry
+	InboundDedupeWindowSecs int `json:"inbound_dedupe_window_secs"` // Default: 300 (0 = disabled)
+
+	// How long Shutdown waits for in-flight messages before giving up
+	ShutdownTimeoutSecs int `json:"shutdown_timeout_secs"` // Default: 30
+
+	// Message types routed on the high-priority lane unless the client overrides it
+	HighPriorityTypes []string `json:"high_priority_types"` // Default: ["sms"]
+
+	// Destination checks before a message goes to a carrier: "off" or "strict"
+	NumberValidation string `json:"number_validation"` // Default: "off"
+
+	// Country code added to national numbers without one (can be overridden per-client)
+	DefaultCountryCode string `json:"default_country_code"` // Default: "" (none)
+
+	// Reply to the sender when MMS transcoding fails or panics: a template
+	// with {id} and {reason}, or "none" (can be overridden per-client)
+	TranscodeFailureReply string `json:"transcode_failure_reply"` // Default: "{reason} ID: {id}"
+	TranscodePanicReply   string `json:"transcode_panic_reply"`   // Default: generic internal error text
+
+	// Media saved for carriers to fetch (GET /media), and its GC
+	MediaTTLHours    int `json:"media_ttl_hours"`     // Default: 168
+	MediaMaxFetches  int `json:"media_max_fetches"`   // Default: 0 (kept until expiry)
+	MediaGCGraceSecs int `json:"media_gc_grace_secs"` // Default: 600
+
+	// Log redaction for clients without a log_privacy_level: "none", "numbers" or "full"
+	LogPrivacy string `json:"log_privacy"` // Default: "none"
+
+	// Extra replacements for clients with sms_coding force_gsm7_transliterate
+	GSM7Replacements map[rune]string `json:"gsm7_replacements"` // SMS_GSM7_REPLACEMENTS
+
+	// POST /outbound/bulk
+	BulkMaxMessages   int `json:"bulk_max_messages"`    // Default: 1000
+	BulkTricklePerSec int `json:"bulk_trickle_per_sec"` // Default: 10 (0 = no delay)
+
+	// Carrier API calls: attempts per send on connection failures and 429,
+	// and the per-carrier circuit breaker
+	CarrierHTTPAttempts        int `json:"carrier_http_attempts"`         // Default: 3
+	CarrierHTTPBackoffMs       int `json:"carrier_http_backoff_ms"`       // Default: 500, doubled per attempt
+	CarrierBreakerThreshold    int `json:"carrier_breaker_threshold"`     // Default: 5 failed sends (0 = disabled)
+	CarrierBreakerCooldownSecs int `json:"carrier_breaker_cooldown_secs"` // Default: 30
+
+	// Outbound send limits per carrier, unless the carrier sets its own
+	CarrierMaxConcurrency int     `json:"carrier_max_concurrency"` // Default: 0 (unlimited)
+	CarrierMaxTPS         float64 `json:"carrier_max_tps"`         // Default: 0 (unlimited)
+
+	// Attachment count and total media size of one MMS, per carrier type
+	CarrierMMSLimits map[string]mmsLimit `json:"carrier_mms_limits"` // Default: twilio 10 parts / 5 MB, telnyx 10 parts
+
+	// Process settings
+	LogLevel       string   `json:"log_level"`       // Default: "info"
+	Debug          bool     `json:"debug"`           // Serves pprof on PprofListen
+	PprofListen    string   `json:"pprof_listen"`    // Default: "0.0.0.0:42666"
+	ServerID       string   `json:"server_id"`       // Default: "" (none)
+	ServerAddress  string   `json:"server_address"`  // Public base URL carriers fetch /media from, without trailing slash
+	EncryptionKey  string   `json:"-"`               // Required
+	APIKey         string   `json:"-"`               // Admin API Basic auth password
+	TrustedProxies []string `json:"trusted_proxies"` // Default: private ranges
+
+	// Listeners
+	WebListen        string `json:"web_listen"`        // Default: "0.0.0.0:3000"
+	SMPPListen       string `json:"smpp_listen"`       // Default: "0.0.0.0:2775"
+	SMPPTLSListen    string `json:"smpp_tls_listen"`   // Default: "" (off)
+	SMPPTLSCert      string `json:"smpp_tls_cert"`     // Required with SMPPTLSListen
+	SMPPTLSKey       string `json:"smpp_tls_key"`      // Required with SMPPTLSListen
+	MM4Listen        string `json:"mm4_listen"`        // Default: "0.0.0.0:2566"
+	MM4TLSCert       string `json:"mm4_tls_cert"`      // STARTTLS when both are set
+	MM4TLSKey        string `json:"mm4_tls_key"`       // STARTTLS when both are set
+	ProxyProtocol    bool   `json:"proxy_protocol"`    // HAProxy PROXY protocol on the MM4 and SMPP listeners
+	PrometheusListen string `json:"prometheus_listen"` // Default: "0.0.0.0:2550"
+	PrometheusPath   string `json:"prometheus_path"`   // Default: "/metrics"
+
+	// MM4 identity
+	MM4Hostname         string `json:"mm4_hostname"`          // Default: "localhost"
+	MM4MsgIDHost        string `json:"mm4_msg_id_host"`       // Default: MM4Hostname
+	MM4OriginatorSystem string `json:"mm4_originator_system"` // Default: system@MM4Hostname
+	MM4Debug            bool   `json:"mm4_debug"`             // Default: false
+
+	// Replays of a persisted MMS before it is marked failed
+	MMSReplayMaxRetries int `json:"mms_replay_max_retries"` // Default: 5
+
+	// S3 bucket MMS media is staged in for aws carriers
+	AWSMediaBucket string `json:"aws_media_bucket"` // Default: "" (AWS MMS fails)
+
+	// Auto-reply master controls
+	AutoReplyEnabled    bool   `json:"auto_reply_enabled"`         // Global kill switch
+	AutoReplyDefaultMsg string `json:"auto_reply_default_message"` // Fallback body
+	HelpReplyMsg        string `json:"help_reply_message"`         // Fallback HELP reply
+
+	// Loki log shipping
+	LokiEnabled  bool   `json:"loki_enabled"`
+	LokiURL      string `json:"loki_url"`
+	LokiUsername string `json:"loki_username"`
+	LokiPassword string `json:"-"`
+
+	Postgres postgresConfig `json:"-"`
+
+	// MMS transcoding, generated SMIL and raw MM4 capture
+	Transcode  TranscodeConfig  `json:"transcode"`
+	SMILLayout smilLayout       `json:"smil_layout"`
+	MM4Capture mm4CaptureConfig `json:"-"`
+
+	// Downloads of inbound and client-supplied media
+	MediaFetch mediaFetchConfig `json:"-"`
 }
 
 // Gateway handles SMS processing for different carriers
@@ -36,10 +187,12 @@ type Gateway struct {
 	Config       GatewayConfig
 	Carriers     map[string]CarrierHandler
 	CarrierUUIDs map[string]Carrier
-	DB           *gorm.DB
-	SMPPServer   *SMPPServer
-	Router       *Router
-	MM4Server    *MM4Server
+	// Destination-prefix routes, sorted longest prefix first then by priority
+	CarrierRoutes []CarrierRoute
+	DB            *gorm.DB
+	SMPPServer    *SMPPServer
+	Router        *Router
+	MM4Server     *MM4Server
 	//AMPQClient    *AMPQClient
 	Clients       map[string]*Client
 	Numbers       map[string]*ClientNumber
@@ -51,10 +204,30 @@ type Gateway struct {
 	EncryptionKey string // PSK for encryption/decryption
 	// AckTracker for carrier acknowledgments.
 	ConvoManager *ConvoManager
+	// Recently seen carrier message IDs, for dropping webhook retries.
+	inboundDedupe inboundDedupe
+	// Recipients who texted STOP, per client number.
+	optOuts optOutSet
+	// Globally blocked source and destination numbers.
+	blocklist blocklist
+	// SMPP receipts awaiting a carrier delivery report.
+	carrierReceipts carrierReceipts
+	// Checks carrier-bound destinations; nil accepts any E.164 number.
+	NumberValidator NumberValidator
+	// Round-robin positions for client sender pools.
+	senderPoolCursors senderPoolCursors
+	// Circuit breakers around carrier API calls, per carrier.
+	carrierBreakers carrierBreakers
+	// Concurrency and TPS limits on sends, per carrier.
+	carrierLimiters carrierLimiters
+	// Shared client for media fetches, built from Config.MediaFetch.
+	mediaClientOnce sync.Once
+	mediaClient     *http.Client
 
 	// Auto-reply master controls (env-driven)
 	AutoReplyEnabled    bool   // AUTO_REPLY_ENABLED — global kill switch
 	AutoReplyDefaultMsg string // AUTO_REPLY_DEFAULT_MESSAGE — fallback body
+	HelpReplyDefaultMsg string // HELP_REPLY_MESSAGE — reply to HELP/INFO
 }
 
 type MsgRecord struct {
@@ -80,139 +253,66 @@ type MsgRecord struct {
 	TranscodedSizeBytes  int
 	MediaCount           int
 	TranscodingPerformed bool
-}
 
-func getPostgresDSN() string {
-	host := os.Getenv("POSTGRES_HOST")
-	if host == "" {
-		host = "localhost"
-	}
-
-	port := os.Getenv("POSTGRES_PORT")
-	if port == "" {
-		port = "5432"
-	}
-
-	user := os.Getenv("POSTGRES_USER")
-	password := os.Getenv("POSTGRES_PASSWORD")
-	dbName := os.Getenv("POSTGRES_DB")
-	sslMode := os.Getenv("POSTGRES_SSLMODE")
-	if sslMode == "" {
-		sslMode = "disable"
-	}
-
-	timeZone := os.Getenv("POSTGRES_TIMEZONE")
-	if timeZone == "" {
-		timeZone = "America/Vancouver"
-	}
-
-	dsn := fmt.Sprintf(
-		"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s TimeZone=%s",
-		host, port, user, password, dbName, sslMode, timeZone,
-	)
-
-	return dsn
+	// Outcome tracking (message_records)
+	Status       string // MsgStatus*; empty means sent
+	Error        string // Last error for failed messages
+	CarrierMsgID string // Provider message ID, for matching carrier DLRs
 }
 
-// loadGatewayConfig loads global configuration from environment variables
-func loadGatewayConfig() GatewayConfig {
-	config := GatewayConfig{
-		WebhookRetries:        3,
-		WebhookTimeoutSecs:    10,
-		WebhookRetryDelaySecs: 5,
-		SMPPRetries:           3,
-		SMPPTimeoutSecs:       30,
-		MM4Retries:            3,
-		MM4TimeoutSecs:        60,
-		NotifySenderOnFailure: true,
-	}
-
-	if val := os.Getenv("WEBHOOK_RETRIES"); val != "" {
-		if v, err := strconv.Atoi(val); err == nil {
-			config.WebhookRetries = v
-		}
-	}
-	if val := os.Getenv("WEBHOOK_TIMEOUT_SECS"); val != "" {
-		if v, err := strconv.Atoi(val); err == nil {
-			config.WebhookTimeoutSecs = v
-		}
-	}
-	if val := os.Getenv("WEBHOOK_RETRY_DELAY_SECS"); val != "" {
-		if v, err := strconv.Atoi(val); err == nil {
-			config.WebhookRetryDelaySecs = v
-		}
-	}
-	if val := os.Getenv("SMPP_RETRIES"); val != "" {
-		if v, err := strconv.Atoi(val); err == nil {
-			config.SMPPRetries = v
-		}
-	}
-	if val := os.Getenv("SMPP_TIMEOUT_SECS"); val != "" {
-		if v, err := strconv.Atoi(val); err == nil {
-			config.SMPPTimeoutSecs = v
-		}
-	}
-	if val := os.Getenv("MM4_RETRIES"); val != "" {
-		if v, err := strconv.Atoi(val); err == nil {
-			config.MM4Retries = v
-		}
-	}
-	if val := os.Getenv("MM4_TIMEOUT_SECS"); val != "" {
-		if v, err := strconv.Atoi(val); err == nil {
-			config.MM4TimeoutSecs = v
-		}
-	}
-	if val := os.Getenv("NOTIFY_SENDER_ON_FAILURE"); val != "" {
-		config.NotifySenderOnFailure = strings.ToLower(val) == "true" || val == "1"
-	}
-
-	return config
-}
-
-// NewGateway creates a new Gateway instance
-func NewGateway() (*Gateway, error) {
-	// Load environment variables or configuration for the database
-	dsn := getPostgresDSN() // e.g., "host=localhost user=postgres password=yourpassword dbname=yourdb port=5432 sslmode=disable TimeZone=Asia/Shanghai"
-
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+// NewGateway creates a new Gateway instance from config, as loaded by
+// loadGatewayConfig.
+func NewGateway(config GatewayConfig) (*Gateway, error) {
+	db, err := gorm.Open(postgres.Open(config.Postgres.dsn()), &gorm.Config{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to PostgreSQL: %v", err)
 	}
 
 	gateway := &Gateway{
-		Config:       loadGatewayConfig(),
+		Config:       config,
 		Carriers:     make(map[string]CarrierHandler),
 		CarrierUUIDs: make(map[string]Carrier),
 		Router: &Router{
-			Routes:         make([]*Route, 0),
-			ClientMsgChan:  make(chan MsgQueueItem),
-			CarrierMsgChan: make(chan MsgQueueItem),
+			Routes:                 make([]*Route, 0),
+			ClientMsgChan:          make(chan MsgQueueItem),
+			CarrierMsgChan:         make(chan MsgQueueItem),
+			ClientPriorityMsgChan:  make(chan MsgQueueItem),
+			CarrierPriorityMsgChan: make(chan MsgQueueItem),
 		},
 		MsgRecordChan: make(chan MsgRecord),
 		Clients:       make(map[string]*Client),
 		Numbers:       make(map[string]*ClientNumber),
 		APIKeys:       make(map[string]*TenantAPIKey),
-		ServerID:      os.Getenv("SERVER_ID"),
-		EncryptionKey: os.Getenv("ENCRYPTION_KEY"),
+		ServerID:      config.ServerID,
+		EncryptionKey: config.EncryptionKey,
 		DB:            db,
 
-		// Auto-reply master controls (env-driven)
-		AutoReplyEnabled:    strings.ToLower(os.Getenv("AUTO_REPLY_ENABLED")) == "true" || os.Getenv("AUTO_REPLY_ENABLED") == "1",
-		AutoReplyDefaultMsg: os.Getenv("AUTO_REPLY_DEFAULT_MESSAGE"),
+		AutoReplyEnabled:    config.AutoReplyEnabled,
+		AutoReplyDefaultMsg: config.AutoReplyDefaultMsg,
+		HelpReplyDefaultMsg: config.HelpReplyMsg,
 	}
 
 	gateway.ConvoManager = NewConvoManager()
 
+	if gateway.NumberValidator, err = newNumberValidator(gateway.Config.NumberValidation); err != nil {
+		return nil, fmt.Errorf("NUMBER_VALIDATION: %v", err)
+	}
+
 	gateway.Router.gateway = gateway
 
 	// Initialize Loki Client and Log Manager
-	lokiClient := NewLokiClient(os.Getenv("LOKI_URL"), os.Getenv("LOKI_USERNAME"), os.Getenv("LOKI_PASSWORD"))
-	lokiEnabled := strings.ToLower(os.Getenv("LOKI_ENABLED")) == "true" || os.Getenv("LOKI_ENABLED") == "1"
-	logManager := NewLogManager(lokiClient, lokiEnabled)
+	lokiClient := NewLokiClient(config.LokiURL, config.LokiUsername, config.LokiPassword)
+	logManager := NewLogManager(lokiClient, config.LokiEnabled)
+	logManager.LokiMinLevel, logManager.LokiSampleRates = loadLokiFilter()
 	// Define Templates
 	logManager.LoadTemplates()
 	gateway.LogManager = logManager
 
+	msgRetryScheduler.configure(retryPolicyFromConfig(gateway.Config), logManager)
+	msgRetryScheduler.setStatusHook(gateway.recordRetryStatus)
+	spillQueues := gateway.Router.spillQueues()
+	msgRetryScheduler.setSpill(newMemorySpillQueue(spillQueues, defaultSpillLimit), spillQueues)
+
 	// Migrate the schema
 	if err := gateway.migrateSchema(); err != nil {
 		return nil, err
@@ -222,6 +322,7 @@ func NewGateway() (*Gateway, error) {
 	if err := gateway.loadClients(); err != nil {
 		return nil, fmt.Errorf("failed to load clients: %v", err)
 	}
+	gateway.syncLogPrivacy()
 
 	if err := gateway.loadNumbers(); err != nil {
 		return nil, fmt.Errorf("failed to load numbers: %v", err)
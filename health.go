@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/iris/v12"
+)
+
+// healthPingTTL is how long a database ping result is reused, so load
+// balancers polling /health don't hit Postgres/Mongo on every request.
+const healthPingTTL = 5 * time.Second
+
+const healthPingTimeout = 2 * time.Second
+
+var (
+	errHealthNotConfigured = errors.New("not configured")
+	errHealthNotListening  = errors.New("listener not bound")
+	errHealthNotConnected  = errors.New("not connected")
+)
+
+// healthNotChecked prefixes the state of a dependency /health does not
+// check; it does not count against readiness.
+const healthNotChecked = "not checked: "
+
+// cachedPing memoises the result of a dependency ping for healthPingTTL.
+type cachedPing struct {
+	mu      sync.Mutex
+	checked time.Time
+	err     error
+}
+
+func (p *cachedPing) check(ping func(ctx context.Context) error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.checked.IsZero() && time.Since(p.checked) < healthPingTTL {
+		return p.err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), healthPingTimeout)
+	defer cancel()
+	p.err = ping(ctx)
+	p.checked = time.Now()
+	return p.err
+}
+
+// HealthChecker reports readiness of the gateway's listeners and datastores.
+// AMQP is listed as not checked: this build has no AMQP client, and messages
+// are queued in memory.
+type HealthChecker struct {
+	gateway  *Gateway
+	postgres cachedPing
+	mongo    cachedPing
+}
+
+func NewHealthChecker(gateway *Gateway) *HealthChecker {
+	return &HealthChecker{gateway: gateway}
+}
+
+// Check returns the state of each subsystem ("ok", the failure reason, or
+// why it is not checked) and whether all of them are ready.
+func (h *HealthChecker) Check() (map[string]string, bool) {
+	status := make(map[string]string)
+	ready := true
+
+	set := func(name string, err error) {
+		if err != nil {
+			status[name] = err.Error()
+			ready = false
+			return
+		}
+		status[name] = "ok"
+	}
+	skip := func(name, reason string) {
+		status[name] = healthNotChecked + reason
+	}
+
+	set("postgres", h.postgres.check(func(ctx context.Context) error {
+		if h.gateway.DB == nil {
+			return errHealthNotConfigured
+		}
+		sqlDB, err := h.gateway.DB.DB()
+		if err != nil {
+			return err
+		}
+		return sqlDB.PingContext(ctx)
+	}))
+
+	// Mongo is only used by MM4 capture; with capture on, a store that
+	// failed to connect is down.
+	switch mm4 := h.gateway.MM4Server; {
+	case mm4 != nil && mm4.mongo != nil:
+		set("mongo", h.mongo.check(func(ctx context.Context) error {
+			return mm4.mongo.Ping(ctx, nil)
+		}))
+	case h.gateway.Config.MM4Capture.enabled():
+		set("mongo", errHealthNotConnected)
+	default:
+		skip("mongo", "MM4 capture is off")
+	}
+	skip("amqp", "no AMQP client in this build")
+
+	set("smpp", listenerErr(h.gateway.SMPPServer.isListening()))
+	set("mm4", listenerErr(h.gateway.MM4Server.isListening()))
+
+	return status, ready
+}
+
+func listenerErr(listening bool) error {
+	if !listening {
+		return errHealthNotListening
+	}
+	return nil
+}
+
+// Handler serves GET /health: 200 when every subsystem is ready, otherwise
+// 503 with the failing subsystems listed.
+func (h *HealthChecker) Handler(ctx iris.Context) {
+	status, ready := h.Check()
+
+	if !ready {
+		down := make([]string, 0, len(status))
+		for name, state := range status {
+			if state != "ok" && !strings.HasPrefix(state, healthNotChecked) {
+				down = append(down, name)
+			}
+		}
+		sort.Strings(down)
+
+		ctx.StatusCode(http.StatusServiceUnavailable)
+		ctx.JSON(iris.Map{
+			"status":     "unavailable",
+			"down":       down,
+			"subsystems": status,
+		})
+		return
+	}
+
+	ctx.StatusCode(http.StatusOK)
+	ctx.JSON(iris.Map{
+		"status":     "ok",
+		"subsystems": status,
+	})
+}
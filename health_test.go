@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kataras/iris/v12"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachedPing_ReusesResultWithinTTL(t *testing.T) {
+	var p cachedPing
+	calls := 0
+	ping := func(ctx context.Context) error {
+		calls++
+		return errors.New("down")
+	}
+
+	assert.EqualError(t, p.check(ping), "down")
+	assert.EqualError(t, p.check(ping), "down")
+	assert.Equal(t, 1, calls)
+}
+
+func TestHealthChecker_NothingReady(t *testing.T) {
+	_, gw := newTestRouter(1)
+
+	status, ready := NewHealthChecker(gw).Check()
+	assert.False(t, ready)
+	assert.Equal(t, errHealthNotConfigured.Error(), status["postgres"])
+	assert.Equal(t, errHealthNotListening.Error(), status["smpp"])
+	assert.Equal(t, errHealthNotListening.Error(), status["mm4"])
+	assert.Equal(t, healthNotChecked+"MM4 capture is off", status["mongo"])
+	assert.Equal(t, healthNotChecked+"no AMQP client in this build", status["amqp"])
+
+	gw.Config.MM4Capture.Mode, gw.Config.MM4Capture.URI = MM4CaptureAll, "mongodb://mongo:27017"
+	status, _ = NewHealthChecker(gw).Check()
+	assert.Equal(t, errHealthNotConnected.Error(), status["mongo"], "capture without a store is down")
+}
+
+func TestHealthChecker_ListenersBound(t *testing.T) {
+	_, gw := newTestRouter(1)
+
+	smppLn, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer smppLn.Close()
+	mm4Ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer mm4Ln.Close()
+
+	gw.SMPPServer = &SMPPServer{listener: smppLn}
+	gw.MM4Server = &MM4Server{listener: mm4Ln}
+
+	status, ready := NewHealthChecker(gw).Check()
+	assert.False(t, ready, "postgres is still unconfigured")
+	assert.Equal(t, "ok", status["smpp"])
+	assert.Equal(t, "ok", status["mm4"])
+}
+
+func TestHealthChecker_HandlerSkipsUncheckedInDown(t *testing.T) {
+	_, gw := newTestRouter(1)
+
+	app := iris.New()
+	app.Get("/health", NewHealthChecker(gw).Handler)
+	require.NoError(t, app.Build())
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	var body struct {
+		Down []string `json:"down"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, []string{"mm4", "postgres", "smpp"}, body.Down)
+}
@@ -0,0 +1,42 @@
+package main
+
+import "strings"
+
+// Inbound routes for a single number (ClientNumber.InboundRoute). They take
+// precedence over the owning client's delivery method.
+const (
+	InboundRouteDefault = ""        // the client's delivery method
+	InboundRouteSMPP    = "smpp"    // SMS over SMPP; MMS as the client default
+	InboundRouteMM4     = "mm4"     // MMS over MM4; SMS as the client default
+	InboundRouteWebhook = "webhook" // SMS and MMS to the number's webhook, else the client's
+	InboundRouteDrop    = "drop"    // discarded without delivery or reply
+)
+
+// validInboundRoute reports whether route is an accepted InboundRoute.
+func validInboundRoute(route string) bool {
+	switch route {
+	case InboundRouteDefault, InboundRouteSMPP, InboundRouteMM4, InboundRouteWebhook, InboundRouteDrop:
+		return true
+	}
+	return false
+}
+
+// validInboundRouteFor reports whether route can be used on a number of
+// client. Web clients have no SMPP or MM4 connection to force.
+func validInboundRouteFor(client *Client, route string) bool {
+	if client.Type == "web" && (route == InboundRouteSMPP || route == InboundRouteMM4) {
+		return false
+	}
+	return validInboundRoute(route)
+}
+
+// numberInboundRoute returns the inbound route and webhook of the client
+// number that to belongs to.
+func numberInboundRoute(client *Client, to string) (route, webhook string) {
+	for _, n := range client.Numbers {
+		if strings.Contains(to, n.Number) {
+			return n.InboundRoute, n.WebHook
+		}
+	}
+	return InboundRouteDefault, ""
+}
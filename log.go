@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -49,6 +51,10 @@ func (lm *LogManager) LoadTemplates() {
 	}
 }
 
+// defaultLokiBufferSize is the LogChannel capacity unless LOKI_BUFFER_SIZE
+// is set.
+const defaultLokiBufferSize = 4096
+
 // LogManager manages log templates and handles dispatching logs to Loki.
 type LogManager struct {
 	Templates   map[string]string
@@ -56,11 +62,33 @@ type LogManager struct {
 	LokiEnabled bool // Whether to send logs to Loki
 	LogChannel  chan *LoggingFormat
 	wg          sync.WaitGroup
+	closeMu     sync.RWMutex
+	closed      bool // set by CloseLogManager; later logs are printed only
+
+	// Logs less severe than LokiMinLevel (LOKI_MIN_LEVEL) are printed but
+	// not shipped. LokiSampleRates (LOKI_SAMPLE_TEMPLATES) ships only 1 in N
+	// logs of the listed templates, keyed by upper-case template name.
+	LokiMinLevel    logrus.Level
+	LokiSampleRates map[string]int
+	sampleMu        sync.Mutex
+	sampleSeen      map[string]uint64
+
+	// LokiLabels (LOKI_JOB, SERVER_ID and LOKI_LABELS) label every stream,
+	// along with the log's type. No other label is set per log, so the
+	// number of streams stays bounded.
+	LokiLabels map[string]string
+
+	dropped atomic.Uint64 // logs dropped because LogChannel was full
+
+	// Numbers and message content are redacted per client (see log_privacy.go).
+	privacyMu sync.RWMutex
+	privacy   logPrivacy
 }
 
 // LoggingFormat represents the structure of a log message.
 type LoggingFormat struct {
 	Message        string                 `json:"message,omitempty"`
+	Template       string                 `json:"-"` // template name passed to BuildLog
 	Error          error                  `json:"error,omitempty"`
 	Type           string                 `json:"type,omitempty"`
 	Level          logrus.Level           `json:"level,omitempty"`
@@ -85,19 +113,35 @@ type LokiStream struct {
 	Values [][2]string       `json:"values"` // Array of [timestamp, line] tuples
 }
 
+// defaultLokiPushTimeout bounds each push so an unreachable Loki cannot stall
+// the log consumer for long.
+const defaultLokiPushTimeout = 3 * time.Second
+
 // LokiClient handles interactions with the Loki service.
 type LokiClient struct {
 	PushURL  string
 	Username string
 	Password string
+	TenantID string // sent as X-Scope-OrgID when set
+	client   *http.Client
 }
 
-// NewLokiClient initializes a new Loki client.
+// NewLokiClient initializes a new Loki client. Pushes time out after
+// LOKI_PUSH_TIMEOUT (a duration such as "2s"), default 3s, and go to the
+// LOKI_TENANT_ID tenant of a multi-tenant Loki when set.
 func NewLokiClient(pushURL, username, password string) *LokiClient {
+	timeout := defaultLokiPushTimeout
+	if val := os.Getenv("LOKI_PUSH_TIMEOUT"); val != "" {
+		if d, err := time.ParseDuration(val); err == nil && d > 0 {
+			timeout = d
+		}
+	}
 	return &LokiClient{
 		PushURL:  pushURL,
 		Username: username,
 		Password: password,
+		TenantID: os.Getenv("LOKI_TENANT_ID"),
+		client:   &http.Client{Timeout: timeout},
 	}
 }
 
@@ -128,9 +172,11 @@ func (c *LokiClient) PushLog(labels map[string]string, entry LogEntry) error {
 	if c.Username != "" && c.Password != "" {
 		req.SetBasicAuth(c.Username, c.Password)
 	}
+	if c.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", c.TenantID)
+	}
 
-	client := &http.Client{Timeout: 3 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := c.client.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to send request to Loki: %w", err)
 	}
@@ -144,13 +190,22 @@ func (c *LokiClient) PushLog(labels map[string]string, entry LogEntry) error {
 }
 
 // NewLogManager initializes a new LogManager.
-// lokiEnabled controls whether logs are sent to Loki (set via LOKI_ENABLED env var)
+// lokiEnabled controls whether logs are sent to Loki (set via LOKI_ENABLED env var).
+// All levels are shipped until LokiMinLevel is changed.
 func NewLogManager(lokiClient *LokiClient, lokiEnabled bool) *LogManager {
+	bufferSize := defaultLokiBufferSize
+	if val := os.Getenv("LOKI_BUFFER_SIZE"); val != "" {
+		if v, err := strconv.Atoi(val); err == nil && v > 0 {
+			bufferSize = v
+		}
+	}
 	lm := &LogManager{
-		Templates:   make(map[string]string),
-		LokiClient:  lokiClient,
-		LokiEnabled: lokiEnabled,
-		LogChannel:  make(chan *LoggingFormat, 512),
+		Templates:    make(map[string]string),
+		LokiClient:   lokiClient,
+		LokiEnabled:  lokiEnabled,
+		LogChannel:   make(chan *LoggingFormat, bufferSize),
+		LokiMinLevel: logrus.TraceLevel,
+		LokiLabels:   loadLokiLabels(),
 	}
 	lm.wg.Add(1)
 	go lm.processLogChannel()
@@ -167,6 +222,7 @@ func (lm *LogManager) BuildLog(logType string, templateName string, level logrus
 	message := lm.formatTemplate(templateName, args...)
 	return &LoggingFormat{
 		Message:        message,
+		Template:       templateName,
 		Type:           strings.ToUpper(logType),
 		Level:          level,
 		AdditionalData: fields,
@@ -191,29 +247,124 @@ func (lm *LogManager) formatTemplate(templateName string, args ...interface{}) s
 	return fmt.Sprintf(template, args...)
 }
 
+// SendLog redacts log for the privacy level of the clients it names, then
+// prints it locally and queues it for Loki unless it is filtered
+// by level or sampling. When Loki falls behind and the channel is full, the
+// log is dropped and counted rather than blocking the caller.
 func (lm *LogManager) SendLog(log *LoggingFormat) {
+	lm.redactLog(log)
 	log.Print()
+	if !lm.shipToLoki(log) {
+		return
+	}
+	lm.closeMu.RLock()
+	defer lm.closeMu.RUnlock()
+	if lm.closed {
+		return
+	}
 	select {
 	case lm.LogChannel <- log:
 	default:
-		logrus.Warn("log channel full, dropping log")
+		lokiDroppedLogs.Inc()
+		if n := lm.dropped.Add(1); n == 1 || n%1000 == 0 {
+			logrus.WithField("dropped", n).Warn("log channel full, dropping logs")
+		}
 	}
 }
 
-// processLogChannel processes logs from the channel and sends them to Loki.
-func (lm *LogManager) processLogChannel() {
-	defer lm.wg.Done()
-	for log := range lm.LogChannel {
-		// Skip Loki sending if disabled
-		if !lm.LokiEnabled {
+// Dropped returns how many logs were dropped because Loki fell behind.
+func (lm *LogManager) Dropped() uint64 {
+	return lm.dropped.Load()
+}
+
+// shipToLoki reports whether log should be sent to Loki.
+func (lm *LogManager) shipToLoki(log *LoggingFormat) bool {
+	if !lm.LokiEnabled || log.Level > lm.LokiMinLevel {
+		return false
+	}
+	key := strings.ToUpper(log.Template)
+	rate := lm.LokiSampleRates[key]
+	if rate <= 1 {
+		return true
+	}
+
+	lm.sampleMu.Lock()
+	defer lm.sampleMu.Unlock()
+	if lm.sampleSeen == nil {
+		lm.sampleSeen = make(map[string]uint64)
+	}
+	seen := lm.sampleSeen[key]
+	lm.sampleSeen[key] = seen + 1
+	return seen%uint64(rate) == 0
+}
+
+// loadLokiFilter reads LOKI_MIN_LEVEL and LOKI_SAMPLE_TEMPLATES. An unset or
+// invalid level ships everything. Sample templates are comma-separated
+// "Template=N" pairs, e.g. "SendCommand=100,ReadResponse=100".
+func loadLokiFilter() (logrus.Level, map[string]int) {
+	minLevel := logrus.TraceLevel
+	if val := os.Getenv("LOKI_MIN_LEVEL"); val != "" {
+		if level, err := logrus.ParseLevel(val); err == nil {
+			minLevel = level
+		}
+	}
+
+	rates := make(map[string]int)
+	for _, entry := range strings.Split(os.Getenv("LOKI_SAMPLE_TEMPLATES"), ",") {
+		name, n, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || name == "" {
 			continue
 		}
+		if v, err := strconv.Atoi(strings.TrimSpace(n)); err == nil && v > 1 {
+			rates[strings.ToUpper(strings.TrimSpace(name))] = v
+		}
+	}
+	return minLevel, rates
+}
+
+// lokiLabelName matches the label names Loki accepts.
+var lokiLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
 
-		labels := map[string]string{
-			"job":       os.Getenv("LOKI_JOB"),
-			"server_id": os.Getenv("SERVER_ID"),
-			"type":      log.Type,
+// loadLokiLabels returns the static labels of every Loki stream: job from
+// LOKI_JOB and server_id from SERVER_ID, merged with the comma-separated
+// "name=value" pairs of LOKI_LABELS, e.g. "env=prod,region=us", which may
+// override them. Invalid names, empty values and "type", which is set from
+// each log, are ignored.
+func loadLokiLabels() map[string]string {
+	labels := make(map[string]string)
+	if job := os.Getenv("LOKI_JOB"); job != "" {
+		labels["job"] = job
+	}
+	if serverID := os.Getenv("SERVER_ID"); serverID != "" {
+		labels["server_id"] = serverID
+	}
+	for _, entry := range strings.Split(os.Getenv("LOKI_LABELS"), ",") {
+		name, value, ok := strings.Cut(entry, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || name == "type" || value == "" || !lokiLabelName.MatchString(name) {
+			continue
 		}
+		labels[name] = value
+	}
+	return labels
+}
+
+// streamLabels returns the labels of the Loki stream for a log of logType:
+// the static labels plus its type.
+func (lm *LogManager) streamLabels(logType string) map[string]string {
+	labels := make(map[string]string, len(lm.LokiLabels)+1)
+	for name, value := range lm.LokiLabels {
+		labels[name] = value
+	}
+	labels["type"] = logType
+	return labels
+}
+
+// processLogChannel processes logs from the channel and sends them to Loki.
+func (lm *LogManager) processLogChannel() {
+	defer lm.wg.Done()
+	for log := range lm.LogChannel {
+		labels := lm.streamLabels(log.Type)
 		logLine := log.String()
 		entry := LogEntry{
 			Timestamp: log.Timestamp,
@@ -259,7 +410,15 @@ func (lf *LoggingFormat) String() string {
 }
 
 // CloseLogManager gracefully shuts down the log manager and waits for the log channel to empty.
+// It is safe to call more than once.
 func (lm *LogManager) CloseLogManager() {
+	lm.closeMu.Lock()
+	if lm.closed {
+		lm.closeMu.Unlock()
+		return
+	}
+	lm.closed = true
 	close(lm.LogChannel)
+	lm.closeMu.Unlock()
 	lm.wg.Wait()
 }
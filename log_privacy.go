@@ -0,0 +1,285 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Values of the log_privacy_level client setting and LOG_PRIVACY. Levels are
+// ordered: each one redacts everything the previous one does.
+const (
+	// LogPrivacyNone logs numbers and message content as they are.
+	LogPrivacyNone = "none"
+	// LogPrivacyNumbers masks the middle digits of phone numbers.
+	LogPrivacyNumbers = "numbers"
+	// LogPrivacyFull also replaces message content with its length and hash.
+	LogPrivacyFull = "full"
+)
+
+// validLogPrivacy reports whether level is a log privacy level, or empty.
+func validLogPrivacy(level string) bool {
+	switch level {
+	case "", LogPrivacyNone, LogPrivacyNumbers, LogPrivacyFull:
+		return true
+	}
+	return false
+}
+
+// logPrivacyRank orders levels so the strictest of several can be picked.
+func logPrivacyRank(level string) int {
+	switch level {
+	case LogPrivacyNumbers:
+		return 1
+	case LogPrivacyFull:
+		return 2
+	}
+	return 0
+}
+
+// logPrivacyLevel returns the level for logs about client: its
+// log_privacy_level, then full if the older log_privacy flag is set, then
+// the gateway default (LOG_PRIVACY).
+func (gateway *Gateway) logPrivacyLevel(client *Client) string {
+	if client != nil {
+		if client.LogPrivacyLevel != "" {
+			return client.LogPrivacyLevel
+		}
+		if client.LogPrivacy {
+			return LogPrivacyFull
+		}
+	}
+	if gateway.Config.LogPrivacy != "" {
+		return gateway.Config.LogPrivacy
+	}
+	return LogPrivacyNone
+}
+
+// syncLogPrivacy hands the current per-client levels to the LogManager. It is
+// called whenever clients are loaded, added, changed or removed.
+func (gateway *Gateway) syncLogPrivacy() {
+	if gateway.LogManager == nil {
+		return
+	}
+	gateway.mu.RLock()
+	levels := make(map[string]string, len(gateway.Clients))
+	for username, client := range gateway.Clients {
+		levels[username] = gateway.logPrivacyLevel(client)
+	}
+	gateway.mu.RUnlock()
+	gateway.LogManager.setLogPrivacy(gateway.logPrivacyLevel(nil), levels)
+}
+
+// logPrivacy holds the levels the LogManager redacts with. It keeps its own
+// copy so SendLog never has to take the gateway lock.
+type logPrivacy struct {
+	defaultLevel string
+	clients      map[string]string // username -> level
+}
+
+// setLogPrivacy replaces the levels used by SendLog.
+func (lm *LogManager) setLogPrivacy(defaultLevel string, clients map[string]string) {
+	lm.privacyMu.Lock()
+	lm.privacy = logPrivacy{defaultLevel: defaultLevel, clients: clients}
+	lm.privacyMu.Unlock()
+}
+
+// Log fields that name a client. The strictest level of the clients a log
+// names applies to the whole log.
+var logClientFields = []string{
+	"client", "username", "clientName", "clientUsername", "toClient",
+	"toClientUsername", "fromClient", "fromClientUsername", "deliveryClient",
+	"destinationClient", "destination_client", "primaryClient", "fallbackClient",
+}
+
+// Log fields that hold phone numbers.
+var logNumberFields = map[string]bool{
+	"from": true, "to": true, "destination": true, "destination_addr": true,
+	"number": true, "numbers": true, "phone_number": true, "recipient": true,
+	"fromNumber": true, "destinationNumber": true, "inboundFrom": true,
+	"inboundTo": true, "replyFrom": true, "replyTo": true, "fromWas": true,
+	"envelope_from": true, "envelope_to": true, "rcpt": true, "raw": true,
+}
+
+// Log fields that hold message content, or its encoding.
+var logContentFields = map[string]bool{
+	"text": true, "segment": true, "originalMessage": true, "replyMessage": true,
+	"inboundMessage": true, "decodedMsg": true, "textPreview": true,
+	"body": true, "body_preview_raw": true, "subject": true, "caption": true,
+	"encodedHex": true, "segmentEncodedHex": true, "rawMsgBytes": true,
+}
+
+// logPhoneNumber matches digit runs long enough to be a phone number, with
+// an optional leading +.
+var logPhoneNumber = regexp.MustCompile(`\+?\d{7,15}`)
+
+// level returns the level for a log with fields.
+func (p logPrivacy) level(fields map[string]interface{}) string {
+	level := p.defaultLevel
+	for _, key := range logClientFields {
+		username, ok := fields[key].(string)
+		if !ok || username == "" {
+			continue
+		}
+		if l, ok := p.clients[username]; ok && logPrivacyRank(l) > logPrivacyRank(level) {
+			level = l
+		}
+	}
+	return level
+}
+
+// Longest message content, and longest other text, kept in a log field.
+// Anything longer is cut and marked with its full length.
+const (
+	maxLogContentLen = 256
+	maxLogFieldLen   = 2048
+)
+
+// redactLog applies the privacy level for log to its message and fields, and
+// trims fields that are too long or hold message bodies or media. The
+// caller's fields map is not modified, since callers reuse it across logs.
+func (lm *LogManager) redactLog(log *LoggingFormat) {
+	lm.privacyMu.RLock()
+	level := lm.privacy.level(log.AdditionalData)
+	lm.privacyMu.RUnlock()
+
+	if logPrivacyRank(level) > 0 {
+		log.Message = maskNumbersIn(log.Message)
+		if log.Error != nil {
+			log.Error = errors.New(maskNumbersIn(log.Error.Error()))
+		}
+	}
+	if log.AdditionalData == nil {
+		return
+	}
+
+	fields := make(map[string]interface{}, len(log.AdditionalData))
+	for key, value := range log.AdditionalData {
+		switch {
+		case level == LogPrivacyFull && logContentFields[key]:
+			fields[key] = redactContent(value)
+		case logPrivacyRank(level) > 0 && logNumberFields[key]:
+			fields[key] = maskNumberValue(trimLogValue(key, value))
+		default:
+			fields[key] = trimLogValue(key, value)
+		}
+	}
+	log.AdditionalData = fields
+}
+
+// trimLogValue keeps a log field to a bounded size. Strings are cut, raw
+// bytes are replaced by their length, and queue items and attachments by
+// their metadata, so media never reaches the logs.
+func trimLogValue(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if logContentFields[key] {
+			return truncateLogString(v, maxLogContentLen)
+		}
+		return truncateLogString(v, maxLogFieldLen)
+	case []byte:
+		return fmt.Sprintf("[%d bytes]", len(v))
+	case MsgQueueItem:
+		return v.LogSummary()
+	case *MsgQueueItem:
+		if v == nil {
+			return value
+		}
+		return v.LogSummary()
+	case MsgFile:
+		return msgFileLogSummary(v)
+	case []MsgFile:
+		summaries := make([]string, len(v))
+		for i, f := range v {
+			summaries[i] = msgFileLogSummary(f)
+		}
+		return summaries
+	}
+	return value
+}
+
+// logBase64Run matches a run of base64 long enough to be encoded media rather
+// than an ID or a token.
+var logBase64Run = regexp.MustCompile(`[A-Za-z0-9+/]{256,}={0,2}`)
+
+// truncateLogString replaces base64 runs in s with their length, replaces
+// invalid UTF-8, as left by binary payloads, and cuts s to at most max bytes
+// on a character boundary. Hex runs, as logged for SMPP debugging, are kept.
+func truncateLogString(s string, max int) string {
+	s = logBase64Run.ReplaceAllStringFunc(s, func(run string) string {
+		if strings.Trim(run, "0123456789abcdefABCDEF") == "" {
+			return run
+		}
+		return fmt.Sprintf("[base64 len=%d]", len(run))
+	})
+	s = strings.ToValidUTF8(s, "\uFFFD")
+	if len(s) <= max {
+		return s
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s...[truncated len=%d]", s[:cut], len(s))
+}
+
+// msgFileLogSummary describes an attachment by its name, type and size.
+func msgFileLogSummary(f MsgFile) string {
+	return fmt.Sprintf("[%s %s %d bytes]", f.Filename, f.ContentType, msgFileSize(f))
+}
+
+// maskNumber hides the middle of a phone number, keeping the first three
+// and last two digits: +15551234567 becomes +155******67. Values too short
+// to identify anyone, such as short codes, are left alone.
+func maskNumber(number string) string {
+	prefix := ""
+	if strings.HasPrefix(number, "+") {
+		prefix, number = "+", number[1:]
+	}
+	if len(number) < 7 {
+		return prefix + number
+	}
+	return prefix + number[:3] + strings.Repeat("*", len(number)-5) + number[len(number)-2:]
+}
+
+// maskNumbersIn masks every phone number found in s.
+func maskNumbersIn(s string) string {
+	return logPhoneNumber.ReplaceAllStringFunc(s, maskNumber)
+}
+
+// maskNumberValue masks the numbers in a log field value.
+func maskNumberValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return maskNumbersIn(v)
+	case []string:
+		masked := make([]string, len(v))
+		for i, n := range v {
+			masked[i] = maskNumbersIn(n)
+		}
+		return masked
+	case fmt.Stringer:
+		return maskNumbersIn(v.String())
+	}
+	return value
+}
+
+// redactContent replaces message content with its length and a short hash,
+// so identical messages can still be matched up across logs.
+func redactContent(value interface{}) interface{} {
+	var data []byte
+	switch v := value.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		data = []byte(fmt.Sprint(v))
+	}
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("[redacted len=%d sha256=%s]", len(data), hex.EncodeToString(sum[:4]))
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaskNumber(t *testing.T) {
+	assert.Equal(t, "+155******67", maskNumber("+15551234567"))
+	assert.Equal(t, "555**67", maskNumber("5551267"))
+	assert.Equal(t, "12345", maskNumber("12345"), "short codes are kept")
+	assert.Equal(t, "to +155******67 failed", maskNumbersIn("to +15551234567 failed"))
+}
+
+func TestLogPrivacyLevel(t *testing.T) {
+	gateway := &Gateway{Config: GatewayConfig{LogPrivacy: LogPrivacyNumbers}}
+
+	assert.Equal(t, LogPrivacyNumbers, gateway.logPrivacyLevel(nil))
+	assert.Equal(t, LogPrivacyNumbers, gateway.logPrivacyLevel(&Client{}))
+	assert.Equal(t, LogPrivacyFull, gateway.logPrivacyLevel(&Client{LogPrivacy: true}))
+	assert.Equal(t, LogPrivacyNone, gateway.logPrivacyLevel(&Client{LogPrivacy: true, LogPrivacyLevel: LogPrivacyNone}))
+
+	assert.True(t, validLogPrivacy(""))
+	assert.True(t, validLogPrivacy(LogPrivacyFull))
+	assert.False(t, validLogPrivacy("partial"))
+}
+
+// sendPrivacyLog sends one log through lm and returns what was queued.
+func sendPrivacyLog(t *testing.T, lm *LogManager, fields map[string]interface{}, args ...interface{}) *LoggingFormat {
+	t.Helper()
+	lm.SendLog(lm.BuildLog("Server.SMPP.HandleSubmitSM", "Send to %v failed", logrus.InfoLevel, fields, args...))
+	require.Len(t, lm.LogChannel, 1)
+	return <-lm.LogChannel
+}
+
+func TestLogManager_RedactsFull(t *testing.T) {
+	lm := newQueueOnlyLogManager(1)
+	lm.setLogPrivacy(LogPrivacyNone, map[string]string{"pbx1": LogPrivacyFull})
+
+	fields := map[string]interface{}{
+		"client":     "pbx1",
+		"from":       "+15551234567",
+		"to":         "+15557654321",
+		"decodedMsg": "my secret code is 1234",
+		"logID":      "abc",
+	}
+	log := sendPrivacyLog(t, lm, fields, "+15557654321")
+
+	assert.Equal(t, "Send to +155******21 failed", log.Message)
+	assert.Equal(t, "+155******67", log.AdditionalData["from"])
+	assert.Equal(t, "+155******21", log.AdditionalData["to"])
+	assert.Equal(t, redactContent("my secret code is 1234"), log.AdditionalData["decodedMsg"])
+	assert.NotContains(t, log.AdditionalData["decodedMsg"], "secret")
+	assert.Equal(t, "abc", log.AdditionalData["logID"])
+
+	assert.Equal(t, "+15551234567", fields["from"], "the caller's fields are not modified")
+	assert.Equal(t, "my secret code is 1234", fields["decodedMsg"])
+}
+
+func TestLogManager_RedactsNumbersOnly(t *testing.T) {
+	lm := newQueueOnlyLogManager(1)
+	lm.setLogPrivacy(LogPrivacyNone, map[string]string{"pbx2": LogPrivacyNumbers})
+
+	log := sendPrivacyLog(t, lm, map[string]interface{}{
+		"toClient": "pbx2",
+		"to":       "+15557654321",
+		"text":     "hello",
+	}, "x")
+
+	assert.Equal(t, "+155******21", log.AdditionalData["to"])
+	assert.Equal(t, "hello", log.AdditionalData["text"])
+}
+
+func TestLogManager_RedactionLevels(t *testing.T) {
+	lm := newQueueOnlyLogManager(1)
+	lm.setLogPrivacy(LogPrivacyNone, map[string]string{"open": LogPrivacyNone, "private": LogPrivacyFull})
+
+	log := sendPrivacyLog(t, lm, map[string]interface{}{"client": "open", "to": "+15557654321"}, "x")
+	assert.Equal(t, "+15557654321", log.AdditionalData["to"], "none logs as is")
+
+	log = sendPrivacyLog(t, lm, map[string]interface{}{
+		"client":   "open",
+		"toClient": "private",
+		"text":     "hello",
+	}, "x")
+	assert.Equal(t, redactContent("hello"), log.AdditionalData["text"], "the strictest named client wins")
+
+	lm.setLogPrivacy(LogPrivacyNumbers, nil)
+	log = sendPrivacyLog(t, lm, map[string]interface{}{"to": "+15557654321"}, "x")
+	assert.Equal(t, "+155******21", log.AdditionalData["to"], "the default applies without a client")
+}
+
+func TestLogManager_LogsQueueItemSummary(t *testing.T) {
+	lm := newQueueOnlyLogManager(1)
+	lm.setLogPrivacy(LogPrivacyFull, nil)
+
+	m := MsgQueueItem{To: "+15557654321", From: "+15551234567", message: "hello", LogID: "abc", Type: MsgQueueItemType.SMS}
+	log := sendPrivacyLog(t, lm, map[string]interface{}{"msg": &m}, errors.New("no session"))
+
+	summary, ok := log.AdditionalData["msg"].(map[string]interface{})
+	require.True(t, ok, "a queue item is logged as its summary")
+	assert.Equal(t, m.LogSummary(), summary)
+	assert.NotContains(t, fmt.Sprint(summary), "hello")
+	assert.NotContains(t, fmt.Sprint(summary), "7654321")
+}
+
+func TestLogManager_NoMediaInLogs(t *testing.T) {
+	lm := newQueueOnlyLogManager(1)
+	lm.setLogPrivacy(LogPrivacyNone, nil)
+
+	image := bytes.Repeat([]byte{0xff, 0xd8, 0xff, 0xe0}, 300)
+	encoded := base64.StdEncoding.EncodeToString(image)
+	files := []MsgFile{
+		{Filename: "a.jpg", ContentType: "image/jpeg", Base64Data: encoded},
+		{Filename: "b.jpg", ContentType: "image/jpeg", Content: image},
+	}
+	m := &MsgQueueItem{Type: MsgQueueItemType.MMS, message: "caption", files: files, binary: image}
+	text := strings.Repeat("long text ", 100)
+
+	log := sendPrivacyLog(t, lm, map[string]interface{}{
+		"msg":         m,
+		"item":        *m,
+		"files":       files,
+		"file":        files[1],
+		"payload":     image,
+		"decodedMsg":  text,
+		"binaryText":  string(image),
+		"response":    encoded,
+		"hex":         hex.EncodeToString(image[:200]),
+		"attachments": len(files),
+	}, "x")
+
+	line, err := json.Marshal(log.AdditionalData)
+	require.NoError(t, err)
+	assert.NotContains(t, string(line), encoded[:64], "no base64 media is logged")
+	assert.NotContains(t, string(line), "caption")
+
+	summary := log.AdditionalData["msg"].(map[string]interface{})
+	assert.Equal(t, 2, summary["files"])
+	assert.Equal(t, 2*len(image), summary["mediaBytes"])
+	assert.Equal(t, []string{"image/jpeg", "image/jpeg"}, summary["contentTypes"])
+	assert.Equal(t, len(image), summary["binaryBytes"])
+	assert.Equal(t, []string{"[a.jpg image/jpeg 1200 bytes]", "[b.jpg image/jpeg 1200 bytes]"}, log.AdditionalData["files"])
+	assert.Equal(t, "[1200 bytes]", log.AdditionalData["payload"])
+
+	decoded := log.AdditionalData["decodedMsg"].(string)
+	assert.True(t, strings.HasPrefix(decoded, text[:maxLogContentLen]))
+	assert.True(t, strings.HasSuffix(decoded, "...[truncated len=1000]"))
+	assert.True(t, utf8.ValidString(log.AdditionalData["binaryText"].(string)))
+	assert.Equal(t, "[base64 len=1600]", log.AdditionalData["response"])
+	assert.Equal(t, hex.EncodeToString(image[:200]), log.AdditionalData["hex"], "hex is not taken for base64")
+	assert.Equal(t, 2, log.AdditionalData["attachments"])
+}
+
+func TestTruncateLogString(t *testing.T) {
+	assert.Equal(t, "short", truncateLogString("short", 10))
+	assert.Equal(t, "h...[truncated len=6]", truncateLogString("hé€", 2), "cut on a character boundary")
+	assert.Equal(t, "a\uFFFDb", truncateLogString("a\xffb", 10))
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// newQueueOnlyLogManager returns a LogManager with Loki enabled but no
+// consumer, so tests can inspect what SendLog queues.
+func newQueueOnlyLogManager(size int) *LogManager {
+	return &LogManager{
+		Templates:    make(map[string]string),
+		LokiEnabled:  true,
+		LogChannel:   make(chan *LoggingFormat, size),
+		LokiMinLevel: logrus.TraceLevel,
+	}
+}
+
+func TestLogManager_MinLevel(t *testing.T) {
+	lm := newQueueOnlyLogManager(8)
+	lm.LokiMinLevel = logrus.InfoLevel
+
+	lm.SendLog(lm.BuildLog("Test", "Debug", logrus.DebugLevel, nil))
+	lm.SendLog(lm.BuildLog("Test", "Info", logrus.InfoLevel, nil))
+	lm.SendLog(lm.BuildLog("Test", "Error", logrus.ErrorLevel, nil))
+
+	assert.Len(t, lm.LogChannel, 2, "debug log is printed but not shipped")
+}
+
+func TestLogManager_Sampling(t *testing.T) {
+	lm := newQueueOnlyLogManager(16)
+	lm.LokiSampleRates = map[string]int{"SENDCOMMAND": 5}
+
+	for i := 0; i < 10; i++ {
+		lm.SendLog(lm.BuildLog("Server.MM4", "SendCommand", logrus.DebugLevel, nil))
+	}
+	lm.SendLog(lm.BuildLog("Server.MM4", "ReadResponse", logrus.DebugLevel, nil))
+
+	assert.Len(t, lm.LogChannel, 3, "1 in 5 sampled logs plus the unsampled one")
+}
+
+func TestLogManager_DropsWhenFull(t *testing.T) {
+	lm := newQueueOnlyLogManager(1)
+
+	for i := 0; i < 3; i++ {
+		lm.SendLog(lm.BuildLog("Test", "Info", logrus.InfoLevel, nil))
+	}
+	assert.Len(t, lm.LogChannel, 1)
+	assert.Equal(t, uint64(2), lm.Dropped())
+}
+
+func TestLogManager_DisabledShipsNothing(t *testing.T) {
+	lm := newQueueOnlyLogManager(1)
+	lm.LokiEnabled = false
+
+	lm.SendLog(lm.BuildLog("Test", "Info", logrus.InfoLevel, nil))
+	assert.Empty(t, lm.LogChannel)
+	assert.Zero(t, lm.Dropped())
+}
+
+func TestLoadLokiFilter(t *testing.T) {
+	t.Setenv("LOKI_MIN_LEVEL", "warn")
+	t.Setenv("LOKI_SAMPLE_TEMPLATES", "SendCommand=100, ReadResponse = 50,bad=x,one=1,noRate")
+
+	level, rates := loadLokiFilter()
+	assert.Equal(t, logrus.WarnLevel, level)
+	assert.Equal(t, map[string]int{"SENDCOMMAND": 100, "READRESPONSE": 50}, rates)
+
+	t.Setenv("LOKI_MIN_LEVEL", "loud")
+	level, _ = loadLokiFilter()
+	assert.Equal(t, logrus.TraceLevel, level)
+}
+
+func TestLogManager_HungLokiDoesNotBlockRouting(t *testing.T) {
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer hung.Close()
+	defer close(release)
+
+	// The push timeout outlasts the test, so the consumer stays stuck.
+	t.Setenv("LOKI_BUFFER_SIZE", "4")
+	t.Setenv("LOKI_PUSH_TIMEOUT", "1m")
+	r, gw := newTestRouter(1)
+	gw.LogManager = NewLogManager(NewLokiClient(hung.URL, "", ""), true)
+	gw.ConvoManager = NewConvoManager()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			r.processMessage(&MsgQueueItem{
+				From:  "+14155559876",
+				To:    "+12505551234",
+				Type:  MsgQueueItemType.SMS,
+				LogID: fmt.Sprintf("log-%d", i),
+			}, "client")
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("message routing blocked on a hung Loki endpoint")
+	}
+	assert.Greater(t, gw.LogManager.Dropped(), uint64(0))
+}
+
+func TestNewLokiClient_PushTimeout(t *testing.T) {
+	t.Setenv("LOKI_PUSH_TIMEOUT", "")
+	assert.Equal(t, defaultLokiPushTimeout, NewLokiClient("", "", "").client.Timeout)
+
+	t.Setenv("LOKI_PUSH_TIMEOUT", "500ms")
+	assert.Equal(t, 500*time.Millisecond, NewLokiClient("", "", "").client.Timeout)
+}
+
+func TestLoadLokiLabels(t *testing.T) {
+	t.Setenv("LOKI_JOB", "gomsggw")
+	t.Setenv("SERVER_ID", "gw1")
+	t.Setenv("LOKI_LABELS", "env=prod, region = us ,type=x,bad-name=1,empty=,noValue,job=edge")
+
+	assert.Equal(t, map[string]string{
+		"job":       "edge",
+		"server_id": "gw1",
+		"env":       "prod",
+		"region":    "us",
+	}, loadLokiLabels())
+
+	lm := &LogManager{LokiLabels: map[string]string{"env": "prod"}}
+	assert.Equal(t, map[string]string{"env": "prod", "type": "ROUTER"}, lm.streamLabels("ROUTER"))
+	assert.Equal(t, map[string]string{"env": "prod"}, lm.LokiLabels, "static labels are not changed per log")
+}
+
+func TestLokiClient_TenantHeader(t *testing.T) {
+	orgIDs := make(chan string, 2)
+	loki := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		orgIDs <- r.Header.Get("X-Scope-OrgID")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer loki.Close()
+
+	entry := LogEntry{Timestamp: time.Now(), Line: "test"}
+
+	t.Setenv("LOKI_TENANT_ID", "")
+	assert.NoError(t, NewLokiClient(loki.URL, "", "").PushLog(map[string]string{"type": "TEST"}, entry))
+	assert.Empty(t, <-orgIDs)
+
+	t.Setenv("LOKI_TENANT_ID", "tenant-a")
+	assert.NoError(t, NewLokiClient(loki.URL, "", "").PushLog(map[string]string{"type": "TEST"}, entry))
+	assert.Equal(t, "tenant-a", <-orgIDs)
+}
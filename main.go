@@ -1,15 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/joho/godotenv"
 	"github.com/kataras/iris/v12"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
@@ -18,12 +20,17 @@ import (
 var trustedProxies []string
 
 func main() {
-	// Load environment variables
-	err := godotenv.Load()
+	// Load the environment, then read and check the whole configuration
+	// before anything starts
+	if err := loadEnvFile(); err != nil {
+		log.Fatalf("failed to load env file: %v", err)
+	}
+	config, err := loadGatewayConfig()
+	if err != nil {
+		log.Fatalf("invalid configuration:\n%v", err)
+	}
 
-	// Set log level from environment (default to info)
-	logLevel := os.Getenv("LOG_LEVEL")
-	switch strings.ToLower(logLevel) {
+	switch config.LogLevel {
 	case "debug":
 		logrus.SetLevel(logrus.DebugLevel)
 	case "warn", "warning":
@@ -34,32 +41,20 @@ func main() {
 		logrus.SetLevel(logrus.InfoLevel)
 	}
 
-	if os.Getenv("DEBUG") == "true" {
+	if config.Debug {
 		go func() {
-			err := http.ListenAndServe(os.Getenv("PPROF_LISTEN"), nil)
+			err := http.ListenAndServe(config.PprofListen, nil)
 			if err != nil {
 				return
 			}
 		}()
 	}
 
-	encryptionKey := os.Getenv("ENCRYPTION_KEY")
-	if encryptionKey == "" {
-		log.Fatal("ENCRYPTION_KEY environment variable not set")
-	}
-
-	if os.Getenv("TRUSTED_PROXIES") == "" {
-		trustedProxies = []string{"10.0.0.0/8",
-			"172.16.0.0/12",
-			"192.168.0.0/16",
-			"fc00::/7"}
-	} else {
-		trustedProxies = strings.Split(os.Getenv("TRUSTED_PROXIES"), ",")
-	}
+	trustedProxies = config.TrustedProxies
 
 	app := iris.New()
 
-	gateway, err := NewGateway()
+	gateway, err := NewGateway(config)
 	if err != nil {
 		panic(err)
 	}
@@ -85,8 +80,21 @@ func main() {
 		panic(err)
 	}
 
-	for _, c := range gateway.Carriers {
-		gateway.Router.AddRoute("carrier", c.Name(), c)
+	gateway.Router.syncCarrierRoutes()
+
+	err = gateway.loadCarrierRoutes()
+	if err != nil {
+		panic(err)
+	}
+
+	err = gateway.loadOptOuts()
+	if err != nil {
+		panic(err)
+	}
+
+	err = gateway.loadBlocklist()
+	if err != nil {
+		panic(err)
 	}
 
 	go func() {
@@ -113,7 +121,7 @@ func main() {
 
 	go func() {
 		mm4Server := &MM4Server{
-			Addr:    os.Getenv("MM4_LISTEN"),
+			Addr:    config.MM4Listen,
 			routing: gateway.Router,
 		}
 		gateway.MM4Server = mm4Server
@@ -138,12 +146,12 @@ func main() {
 
 	// Create and register the exporter with Prometheus
 	exporter := NewMetricExporter("gateway_metrics", gateway)
-	prometheus.MustRegister(exporter)
+	prometheus.MustRegister(exporter, messagesTotal, transcodeDuration, deliveryLatency, queueWait, lokiDroppedLogs, mediaFiles, mediaBytes, gatewayConnections)
 
 	// Start the Prometheus HTTP server
 	prometheusExporter := PrometheusExporter{
-		Path:   os.Getenv("PROMETHEUS_PATH"),
-		Listen: os.Getenv("PROMETHEUS_LISTEN"),
+		Path:   config.PrometheusPath,
+		Listen: config.PrometheusListen,
 	}
 
 	go func() {
@@ -166,12 +174,11 @@ func main() {
 	go gateway.processMsgRecords()
 
 	go gateway.cleanUpExpiredMediaFiles(15 * time.Minute)
+	go gateway.replayPendingMMS(10 * time.Minute)
+	go gateway.releaseScheduledMessages(5 * time.Second)
 
 	// Start server
-	webListen := os.Getenv("WEB_LISTEN")
-	if webListen == "" {
-		webListen = "0.0.0.0:3000"
-	}
+	webListen := config.WebListen
 
 	app.Use(ProxyIPMiddleware)
 
@@ -180,12 +187,16 @@ func main() {
 	SetupNumberRoutes(app, gateway)
 	SetupMessageRoutes(app, gateway)
 	SetupStatsRoutes(app, gateway)
+	SetupReportRoutes(app, gateway)
+	SetupDebugRoutes(app, gateway)
+	SetupRouteTestRoutes(app, gateway)
 	SetupAPIKeyRoutes(app, gateway)
 	SetupBatchRoutes(app, gateway)
-	app.Get("/health", func(ctx iris.Context) {
-		ctx.StatusCode(200)
-		return
-	})
+	SetupOutboundRoutes(app, gateway)
+	SetupBlocklistRoutes(app, gateway)
+	SetupReloadRoutes(app, gateway)
+	SetupResendRoutes(app, gateway)
+	app.Get("/health", NewHealthChecker(gateway).Handler)
 
 	// Define the /reload_clients route
 	// app.Get("/reload_clients", basicAuthMiddleware, gateway.webReloadClients)
@@ -195,7 +206,36 @@ func main() {
 	// Define the /inbound/{carrier} route
 	app.Post("/inbound/{carrier}", gateway.webInboundCarrier)
 
+	// On SIGINT/SIGTERM stop the web server, then drain and stop the gateway.
+	shutdownDone := make(chan struct{})
+	go func() {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-sigCh
+
+		var lm = gateway.LogManager
+		lm.SendLog(lm.BuildLog(
+			"System.Shutdown",
+			"SignalReceived",
+			logrus.InfoLevel,
+			map[string]interface{}{
+				"signal":       sig.String(),
+				"timeout_secs": gateway.Config.ShutdownTimeoutSecs,
+			},
+		))
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(gateway.Config.ShutdownTimeoutSecs)*time.Second)
+		defer cancel()
+		_ = app.Shutdown(ctx)
+		_ = gateway.Shutdown(ctx)
+		close(shutdownDone)
+	}()
+
 	err = app.Listen(webListen)
+	if errors.Is(err, iris.ErrServerClosed) {
+		<-shutdownDone
+		return
+	}
 	if err != nil {
 		var lm = gateway.LogManager
 		lm.SendLog(lm.BuildLog(
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultMediaFetchTimeoutSecs  = 30
+	defaultMediaFetchMaxRedirects = 3
+)
+
+// errMediaTooLarge is returned for media bodies over maxInputSize, the most
+// the transcoder accepts for one file.
+var errMediaTooLarge = errors.New("media exceeds the maximum size")
+
+// errMediaFetchBlocked is returned for media URLs that are not http(s) or
+// that resolve to a loopback, private or link-local address.
+var errMediaFetchBlocked = errors.New("media URL not allowed")
+
+// mediaFetchConfig controls downloads of inbound carrier media and of the
+// media_urls given to the web API.
+type mediaFetchConfig struct {
+	Timeout      time.Duration // MEDIA_FETCH_TIMEOUT_SECS - whole request, body included
+	MaxRedirects int           // MEDIA_FETCH_MAX_REDIRECTS
+	Proxy        *url.URL      // MEDIA_FETCH_PROXY - outbound HTTP(S) proxy
+
+	// MEDIA_FETCH_ALLOW_PRIVATE - allow hosts on loopback, private and
+	// link-local addresses, which are refused so a webhook cannot make the
+	// gateway fetch from its own network.
+	AllowPrivate bool
+
+	// MEDIA_FETCH_HEADERS - extra request headers keyed by lower-cased
+	// carrier name or type; "*" applies to every fetch.
+	Headers map[string]http.Header
+}
+
+func loadMediaFetchConfig(env *envReader) mediaFetchConfig {
+	cfg := mediaFetchConfig{
+		Timeout:      time.Duration(env.int("MEDIA_FETCH_TIMEOUT_SECS", defaultMediaFetchTimeoutSecs, 1)) * time.Second,
+		MaxRedirects: env.int("MEDIA_FETCH_MAX_REDIRECTS", defaultMediaFetchMaxRedirects, 0),
+		AllowPrivate: env.bool("MEDIA_FETCH_ALLOW_PRIVATE", false),
+		Headers:      parseMediaFetchHeaders(env, env.str("MEDIA_FETCH_HEADERS", "")),
+	}
+	if val := strings.TrimSpace(env.str("MEDIA_FETCH_PROXY", "")); val != "" {
+		proxy, err := url.Parse(val)
+		if err != nil || proxy.Host == "" || (proxy.Scheme != "http" && proxy.Scheme != "https" && proxy.Scheme != "socks5") {
+			env.fail("MEDIA_FETCH_PROXY", val, "an http://, https:// or socks5:// URL")
+		} else {
+			cfg.Proxy = proxy
+		}
+	}
+	return cfg
+}
+
+// parseMediaFetchHeaders parses "telnyx:Authorization=Bearer abc;*:User-Agent=gw"
+// into headers keyed by carrier.
+func parseMediaFetchHeaders(env *envReader, val string) map[string]http.Header {
+	headers := make(map[string]http.Header)
+	for _, entry := range strings.Split(val, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		carrier, header, ok := strings.Cut(entry, ":")
+		name, value, ok2 := strings.Cut(header, "=")
+		carrier, name = strings.ToLower(strings.TrimSpace(carrier)), strings.TrimSpace(name)
+		if !ok || !ok2 || carrier == "" || name == "" {
+			env.fail("MEDIA_FETCH_HEADERS", entry, "carrier:Header=value entries separated by ;")
+			continue
+		}
+		if headers[carrier] == nil {
+			headers[carrier] = make(http.Header)
+		}
+		headers[carrier].Add(name, strings.TrimSpace(value))
+	}
+	return headers
+}
+
+// blockedMediaIP reports whether ip is an address media must not be fetched
+// from.
+func blockedMediaIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast()
+}
+
+// checkURL refuses anything but http(s) and, unless AllowPrivate is set,
+// hosts that resolve to a blocked address.
+func (cfg mediaFetchConfig) checkURL(ctx context.Context, u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: scheme %q", errMediaFetchBlocked, u.Scheme)
+	}
+	if cfg.AllowPrivate {
+		return nil
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if blockedMediaIP(ip) {
+			return fmt.Errorf("%w: %s", errMediaFetchBlocked, host)
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if blockedMediaIP(addr.IP) {
+			return fmt.Errorf("%w: %s resolves to %s", errMediaFetchBlocked, host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// client returns an HTTP client for media fetches. Every URL, redirects
+// included, is checked before it is requested; without a proxy the dialer
+// checks the address it connects to as well, so a host cannot resolve to a
+// public address for the check and a private one for the connection. A
+// redirect to another host drops the MEDIA_FETCH_HEADERS, which may carry
+// credentials; net/http already drops Authorization and Cookie.
+func (cfg mediaFetchConfig) client() *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	if !cfg.AllowPrivate && cfg.Proxy == nil {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || blockedMediaIP(ip) {
+				return fmt.Errorf("%w: %s", errMediaFetchBlocked, host)
+			}
+			return nil
+		}
+	}
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConns:        20,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	if cfg.Proxy != nil {
+		transport.Proxy = http.ProxyURL(cfg.Proxy)
+	}
+	return &http.Client{
+		Timeout:   cfg.Timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > cfg.MaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", cfg.MaxRedirects)
+			}
+			if req.URL.Host != via[0].URL.Host {
+				for _, headers := range cfg.Headers {
+					for name := range headers {
+						req.Header.Del(name)
+					}
+				}
+			}
+			return cfg.checkURL(req.Context(), req.URL)
+		},
+	}
+}
+
+// mediaHTTPClient returns the client shared by all media fetches.
+func (gateway *Gateway) mediaHTTPClient() *http.Client {
+	gateway.mediaClientOnce.Do(func() {
+		gateway.mediaClient = gateway.Config.MediaFetch.client()
+	})
+	return gateway.mediaClient
+}
+
+// fetchMedia downloads mediaURL for carrier, which may be nil for URLs given
+// by clients. The MEDIA_FETCH_HEADERS for the carrier are added, then auth,
+// when set, adds the carrier's credentials. It returns the body and the
+// Content-Type header; a body over maxInputSize fails with errMediaTooLarge.
+func (gateway *Gateway) fetchMedia(carrier *Carrier, mediaURL string, auth func(*http.Request)) ([]byte, string, error) {
+	cfg := gateway.Config.MediaFetch
+	ctx := context.Background()
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, mediaURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := cfg.checkURL(ctx, req.URL); err != nil {
+		return nil, "", err
+	}
+	keys := []string{"*"}
+	if carrier != nil {
+		keys = append(keys, strings.ToLower(carrier.Type), strings.ToLower(carrier.Name))
+	}
+	for _, key := range keys {
+		for name, values := range cfg.Headers[key] {
+			req.Header[name] = values
+		}
+	}
+	if auth != nil {
+		auth(req)
+	}
+
+	resp, err := gateway.mediaHTTPClient().Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("non-OK HTTP status: %s", resp.Status)
+	}
+	if resp.ContentLength > maxInputSize {
+		return nil, "", fmt.Errorf("%w: %d bytes, at most %d allowed", errMediaTooLarge, resp.ContentLength, maxInputSize)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxInputSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading media content: %w", err)
+	}
+	if len(content) > maxInputSize {
+		return nil, "", fmt.Errorf("%w: more than %d bytes", errMediaTooLarge, maxInputSize)
+	}
+	return content, resp.Header.Get("Content-Type"), nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchMedia_RejectsPrivateHosts(t *testing.T) {
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+	}))
+	defer srv.Close()
+
+	gw := &Gateway{Config: GatewayConfig{MediaFetch: mediaFetchConfig{Timeout: time.Second}}}
+	localhostURL := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	for _, mediaURL := range []string{srv.URL + "/a.jpg", localhostURL + "/a.jpg", "http://169.254.169.254/latest/meta-data/", "file:///etc/passwd"} {
+		_, _, err := gw.fetchMedia(nil, mediaURL, nil)
+		assert.True(t, errors.Is(err, errMediaFetchBlocked), "%s: %v", mediaURL, err)
+	}
+	assert.Equal(t, 0, hits, "nothing is requested")
+}
+
+func TestFetchMedia_Timeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	gw := &Gateway{Config: GatewayConfig{MediaFetch: mediaFetchConfig{Timeout: 100 * time.Millisecond, AllowPrivate: true}}}
+	start := time.Now()
+	_, _, err := gw.fetchMedia(nil, srv.URL, nil)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+func TestFetchMedia_HeadersAndRedirects(t *testing.T) {
+	var gotAuth, gotKey, gotAgent string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/media", func(w http.ResponseWriter, r *http.Request) {
+		gotAuth, gotKey, gotAgent = r.Header.Get("Authorization"), r.Header.Get("X-Api-Key"), r.Header.Get("User-Agent")
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	})
+	mux.HandleFunc("/hop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/media", http.StatusFound)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	gw := &Gateway{Config: GatewayConfig{MediaFetch: mediaFetchConfig{
+		Timeout:      time.Second,
+		MaxRedirects: 2,
+		AllowPrivate: true,
+		Headers: map[string]http.Header{
+			"*":     {"User-Agent": {"gw"}},
+			"acme":  {"X-Api-Key": {"k1"}},
+			"other": {"X-Api-Key": {"nope"}},
+		},
+	}}}
+	carrier := &Carrier{Name: "acme", Type: "telnyx"}
+
+	content, contentType, err := gw.fetchMedia(carrier, srv.URL+"/hop", func(req *http.Request) {
+		req.SetBasicAuth("sid", "token")
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("png"), content)
+	assert.Equal(t, "image/png", contentType)
+	assert.Equal(t, "k1", gotKey)
+	assert.Equal(t, "gw", gotAgent)
+	assert.True(t, strings.HasPrefix(gotAuth, "Basic "), "auth follows a same-host redirect")
+
+	// A redirect to another host drops the configured headers
+	other := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	mux.HandleFunc("/away", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, other+"/media", http.StatusFound)
+	})
+	gotKey, gotAgent = "", ""
+	_, _, err = gw.fetchMedia(carrier, srv.URL+"/away", nil)
+	require.NoError(t, err)
+	assert.Empty(t, gotKey)
+	assert.NotEqual(t, "gw", gotAgent)
+
+	_, _, err = gw.fetchMedia(carrier, srv.URL+"/loop", nil)
+	assert.ErrorContains(t, err, "stopped after 2 redirects")
+}
+
+func TestFetchMedia_SizeLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/sized" {
+			w.Header().Set("Content-Length", strconv.Itoa(maxInputSize+1))
+		}
+		w.Write(make([]byte, maxInputSize+1))
+	}))
+	defer srv.Close()
+
+	gw := &Gateway{Config: GatewayConfig{MediaFetch: mediaFetchConfig{Timeout: 5 * time.Second, AllowPrivate: true}}}
+	for _, path := range []string{"/sized", "/chunked"} {
+		_, _, err := gw.fetchMedia(nil, srv.URL+path, nil)
+		assert.ErrorIs(t, err, errMediaTooLarge, path)
+	}
+}
+
+func TestParseMediaFetchHeaders(t *testing.T) {
+	env := &envReader{}
+	headers := parseMediaFetchHeaders(env, "Telnyx:Authorization=Bearer abc; *:User-Agent=gw/1.0;bad")
+	assert.Equal(t, "Bearer abc", headers["telnyx"].Get("Authorization"))
+	assert.Equal(t, "gw/1.0", headers["*"].Get("User-Agent"))
+	assert.ErrorContains(t, env.err(), `MEDIA_FETCH_HEADERS="bad"`)
+}
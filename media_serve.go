@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// base64Reader is an io.ReadSeeker over the bytes that padded standard
+// base64 data decodes to. Each read decodes only the quantums it covers, so
+// a ranged request decodes just its range and a full one never holds the
+// whole decoded file.
+type base64Reader struct {
+	data string
+	size int64
+	off  int64
+}
+
+// newBase64Reader returns a reader over data, which must be padded standard
+// base64 without line breaks, as saveMsgFileMedia stores it.
+func newBase64Reader(data string) (*base64Reader, error) {
+	if len(data)%4 != 0 || strings.ContainsAny(data, "\r\n") {
+		return nil, errors.New("base64 data is not padded to whole quantums")
+	}
+	size := int64(len(data) / 4 * 3)
+	switch {
+	case strings.HasSuffix(data, "=="):
+		size -= 2
+	case strings.HasSuffix(data, "="):
+		size--
+	}
+	return &base64Reader{data: data, size: size}, nil
+}
+
+func (r *base64Reader) Read(p []byte) (int, error) {
+	if r.off >= r.size {
+		return 0, io.EOF
+	}
+	end := r.off + int64(len(p))
+	if end > r.size {
+		end = r.size
+	}
+	first, last := r.off/3, (end+2)/3 // quantums covering [off, end)
+	buf := make([]byte, (last-first)*3)
+	n, err := base64.StdEncoding.Decode(buf, []byte(r.data[first*4:last*4]))
+	if err != nil {
+		return 0, err
+	}
+	copied := copy(p, buf[r.off-first*3:n])
+	r.off += int64(copied)
+	return copied, nil
+}
+
+func (r *base64Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.off = offset
+	return offset, nil
+}
+
+// serveMediaFile streams the content of mediaFile, decoding it as it goes.
+// Range requests are answered with 206 and the requested bytes, and
+// If-None-Match / If-Modified-Since with 304. The content of a token never
+// changes, so clients may cache it until it expires.
+func serveMediaFile(w http.ResponseWriter, r *http.Request, mediaFile *MediaFile, content io.ReadSeeker) {
+	maxAge := int64(time.Until(mediaFile.ExpiresAt) / time.Second)
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	if mediaFile.ContentType != "" {
+		w.Header().Set("Content-Type", mediaFile.ContentType)
+	}
+	w.Header().Set("ETag", fmt.Sprintf("%q", mediaFile.AccessToken))
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", maxAge))
+	http.ServeContent(w, r, mediaFile.FileName, mediaFile.UploadAt, content)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBase64Reader_Seek(t *testing.T) {
+	for size := 0; size <= 10; size++ {
+		content := make([]byte, size)
+		for i := range content {
+			content[i] = byte('a' + i)
+		}
+		r, err := newBase64Reader(base64.StdEncoding.EncodeToString(content))
+		require.NoError(t, err)
+
+		end, err := r.Seek(0, io.SeekEnd)
+		require.NoError(t, err)
+		assert.Equal(t, int64(size), end)
+
+		for off := 0; off <= size; off++ {
+			_, err := r.Seek(int64(off), io.SeekStart)
+			require.NoError(t, err)
+			got, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, content[off:], got, "size %d offset %d", size, off)
+		}
+	}
+
+	_, err := newBase64Reader("abc")
+	assert.Error(t, err, "unpadded data")
+}
+
+func TestServeMediaFile_Range(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+	mediaFile := &MediaFile{
+		AccessToken: "abc-token",
+		FileName:    "clip.mp4",
+		ContentType: "video/mp4",
+		Base64Data:  base64.StdEncoding.EncodeToString(content),
+		UploadAt:    time.Now().Add(-time.Hour),
+		ExpiresAt:   time.Now().Add(time.Hour),
+	}
+	serve := func(header http.Header) *httptest.ResponseRecorder {
+		reader, err := newBase64Reader(mediaFile.Base64Data)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodGet, "/media/abc-token.mp4", nil)
+		req.Header = header
+		rec := httptest.NewRecorder()
+		serveMediaFile(rec, req, mediaFile, reader)
+		return rec
+	}
+
+	rec := serve(http.Header{"Range": {"bytes=5-9"}})
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, "56789", rec.Body.String())
+	assert.Equal(t, "bytes 5-9/20", rec.Header().Get("Content-Range"))
+	assert.Equal(t, "5", rec.Header().Get("Content-Length"))
+	assert.Equal(t, "video/mp4", rec.Header().Get("Content-Type"))
+
+	rec = serve(http.Header{})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, content, rec.Body.Bytes())
+	assert.Equal(t, "bytes", rec.Header().Get("Accept-Ranges"))
+	assert.Equal(t, "20", rec.Header().Get("Content-Length"))
+	assert.Contains(t, rec.Header().Get("Cache-Control"), "max-age=")
+
+	rec = serve(http.Header{"If-None-Match": {`"abc-token"`}})
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+
+	rec = serve(http.Header{"Range": {"bytes=30-"}})
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, rec.Code)
+}
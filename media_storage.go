@@ -3,16 +3,16 @@ package main
 import (
 	"errors"
 	"fmt"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
 
 const (
-	TTLDuration = 7 * 24 * time.Hour // 7-day expiration
+	TTLDuration = 7 * 24 * time.Hour // 7-day expiration, unless MEDIA_TTL_HOURS is set
 )
 
 // MediaFile stores uploaded media content with UUID-based access tokens
@@ -24,6 +24,11 @@ type MediaFile struct {
 	Base64Data  string    `json:"base64_data"`
 	UploadAt    time.Time `json:"upload_at"`
 	ExpiresAt   time.Time `gorm:"index" json:"expires_at"`
+
+	// FetchCount counts GET /media requests; LastAccessAt is set on those and
+	// when a queued MMS reloads the file, so GC leaves media in use alone.
+	FetchCount   int        `gorm:"default:0;not null" json:"fetch_count"`
+	LastAccessAt *time.Time `json:"last_access_at,omitempty"`
 }
 
 var mimeToExt = map[string]string{
@@ -73,32 +78,99 @@ func (gateway *Gateway) uploadMediaGetUrls(mms *MsgQueueItem) ([]string, error)
 				return mediaUrls, err
 			}
 
-			mediaUrls = append(mediaUrls, getMediaUrlWithExtension(os.Getenv("SERVER_ADDRESS"), accessToken, i.ContentType))
+			mediaUrls = append(mediaUrls, getMediaUrlWithExtension(gateway.Config.ServerAddress, accessToken, i.ContentType))
 		}
 		return mediaUrls, nil
 	}
 	return mediaUrls, nil
 }
 
-func (gateway *Gateway) cleanUpExpiredMediaFiles(interval time.Duration) {
-	// Run cleanup immediately
-	err := gateway.DB.Where("expires_at < ?", time.Now()).Delete(&MediaFile{}).Error
-	if err != nil {
-		fmt.Printf("Failed to clean up expired media files: %v\n", err)
+// mediaGCPolicy decides which media files the GC job deletes.
+type mediaGCPolicy struct {
+	MaxFetches int           // delete after this many GET /media requests (0 = only on expiry)
+	Grace      time.Duration // keep files accessed this recently, even if expired or fetched out
+}
+
+// mediaGCPolicyFromConfig returns the GC policy for config.
+func mediaGCPolicyFromConfig(config GatewayConfig) mediaGCPolicy {
+	return mediaGCPolicy{
+		MaxFetches: config.MediaMaxFetches,
+		Grace:      time.Duration(config.MediaGCGraceSecs) * time.Second,
 	}
+}
+
+// collectable narrows db to the media files the GC may delete at now: those
+// past ExpiresAt or fetched MaxFetches times, and not accessed within Grace,
+// so a carrier still retrying a fetch or a queued MMS being replayed can
+// resolve its media.
+func (p mediaGCPolicy) collectable(db *gorm.DB, now time.Time) *gorm.DB {
+	done := db.Where("expires_at < ?", now)
+	if p.MaxFetches > 0 {
+		done = done.Or("fetch_count >= ?", p.MaxFetches)
+	}
+	return db.Where(done).Where("last_access_at IS NULL OR last_access_at < ?", now.Add(-p.Grace))
+}
+
+// cleanUpExpiredMediaFiles runs the media GC now and then every interval.
+func (gateway *Gateway) cleanUpExpiredMediaFiles(interval time.Duration) {
+	gateway.collectMediaFiles()
 
-	// Create a ticker that fires at the specified interval
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for range ticker.C {
-		err := gateway.DB.Where("expires_at < ?", time.Now()).Delete(&MediaFile{}).Error
-		if err != nil {
-			fmt.Printf("Failed to clean up expired media files: %v\n", err)
-		}
+		gateway.collectMediaFiles()
 	}
 }
 
+// collectMediaFiles deletes collectable media files and updates the media
+// storage gauges.
+func (gateway *Gateway) collectMediaFiles() {
+	lm := gateway.LogManager
+	policy := mediaGCPolicyFromConfig(gateway.Config)
+
+	result := policy.collectable(gateway.DB, time.Now()).Delete(&MediaFile{})
+	if result.Error != nil {
+		lm.SendLog(lm.BuildLog(
+			"System.MediaGC",
+			"MediaGCError",
+			logrus.ErrorLevel,
+			nil,
+			result.Error,
+		))
+	} else if result.RowsAffected > 0 {
+		lm.SendLog(lm.BuildLog(
+			"System.MediaGC",
+			"MediaFilesDeleted",
+			logrus.InfoLevel,
+			map[string]interface{}{
+				"deleted":     result.RowsAffected,
+				"max_fetches": policy.MaxFetches,
+			},
+		))
+	}
+
+	var size struct {
+		Files int64
+		Bytes int64
+	}
+	err := gateway.DB.Model(&MediaFile{}).
+		Select("COUNT(*) AS files, COALESCE(SUM(LENGTH(base64_data)), 0) AS bytes").
+		Scan(&size).Error
+	if err != nil {
+		lm.SendLog(lm.BuildLog(
+			"System.MediaGC",
+			"MediaSizeError",
+			logrus.WarnLevel,
+			nil,
+			err,
+		))
+		return
+	}
+	mediaFiles.Set(float64(size.Files))
+	mediaBytes.Set(float64(size.Bytes))
+}
+
 // saveMsgFileMedia saves a media file and returns its UUID access token
 func (gateway *Gateway) saveMsgFileMedia(file MsgFile) (string, error) {
 	accessToken := uuid.New().String()
@@ -109,7 +181,7 @@ func (gateway *Gateway) saveMsgFileMedia(file MsgFile) (string, error) {
 		ContentType: file.ContentType,
 		Base64Data:  file.Base64Data,
 		UploadAt:    time.Now(),
-		ExpiresAt:   time.Now().Add(TTLDuration),
+		ExpiresAt:   time.Now().Add(gateway.mediaTTL()),
 	}
 
 	if err := gateway.DB.Create(&mediaFile).Error; err != nil {
@@ -119,7 +191,16 @@ func (gateway *Gateway) saveMsgFileMedia(file MsgFile) (string, error) {
 	return accessToken, nil
 }
 
-// getMediaFileByToken retrieves a media file by its UUID access token
+// mediaTTL is how long saved media stays fetchable (MEDIA_TTL_HOURS).
+func (gateway *Gateway) mediaTTL() time.Duration {
+	if gateway.Config.MediaTTLHours > 0 {
+		return time.Duration(gateway.Config.MediaTTLHours) * time.Hour
+	}
+	return TTLDuration
+}
+
+// getMediaFileByToken retrieves a media file by its UUID access token and
+// marks it accessed, which holds off GC for MEDIA_GC_GRACE_SECS.
 func (gateway *Gateway) getMediaFileByToken(accessToken string) (*MediaFile, error) {
 	var mediaFile MediaFile
 	if err := gateway.DB.Where("access_token = ?", accessToken).First(&mediaFile).Error; err != nil {
@@ -136,5 +217,15 @@ func (gateway *Gateway) getMediaFileByToken(accessToken string) (*MediaFile, err
 		return nil, fmt.Errorf("media file has expired: %s", accessToken)
 	}
 
+	now := time.Now()
+	gateway.DB.Model(&mediaFile).UpdateColumn("last_access_at", now)
+	mediaFile.LastAccessAt = &now
+
 	return &mediaFile, nil
 }
+
+// recordMediaFetch counts a GET /media request for mediaFile, towards
+// MEDIA_MAX_FETCHES.
+func (gateway *Gateway) recordMediaFetch(mediaFile *MediaFile) error {
+	return gateway.DB.Model(mediaFile).UpdateColumn("fetch_count", gorm.Expr("fetch_count + 1")).Error
+}
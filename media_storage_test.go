@@ -2,6 +2,7 @@ package main
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -55,3 +56,27 @@ func TestGetMediaUrlWithExtension_TrimsTrailingSlash(t *testing.T) {
 	// We don't trim — so a trailing slash is preserved. Document current behavior.
 	assert.Equal(t, "https://sms.example.com//media/token.mp4", url)
 }
+
+func TestMediaGCPolicy_Collectable(t *testing.T) {
+	db := dryRunDB(t)
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	policy := mediaGCPolicy{Grace: 10 * time.Minute}
+	stmt := policy.collectable(db, now).Find(&[]MediaFile{}).Statement
+	assert.Contains(t, stmt.SQL.String(), "expires_at < $1 AND (last_access_at IS NULL OR last_access_at < $2)")
+	assert.NotContains(t, stmt.SQL.String(), "fetch_count")
+	assert.Equal(t, []interface{}{now, now.Add(-10 * time.Minute)}, stmt.Vars)
+
+	policy.MaxFetches = 2
+	stmt = policy.collectable(db, now).Find(&[]MediaFile{}).Statement
+	assert.Contains(t, stmt.SQL.String(), "(expires_at < $1 OR fetch_count >= $2) AND (last_access_at IS NULL OR last_access_at < $3)")
+	assert.Equal(t, []interface{}{now, 2, now.Add(-10 * time.Minute)}, stmt.Vars)
+}
+
+func TestMediaTTL(t *testing.T) {
+	gateway := &Gateway{}
+	assert.Equal(t, TTLDuration, gateway.mediaTTL())
+
+	gateway.Config.MediaTTLHours = 24
+	assert.Equal(t, 24*time.Hour, gateway.mediaTTL())
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBoundedCarrierLabel(t *testing.T) {
+	assert.Equal(t, "twilio", boundedCarrierLabel("Twilio"))
+	assert.Equal(t, "telnyx", boundedCarrierLabel("telnyx"))
+	assert.Equal(t, "none", boundedCarrierLabel(""))
+	assert.Equal(t, "other", boundedCarrierLabel("acme-sms"))
+}
+
+func TestGateway_RecordMessageUsesCarrierType(t *testing.T) {
+	_, gw := newTestRouter(1)
+	gw.Carriers = map[string]CarrierHandler{
+		"twilio-prod": &TwilioHandler{BaseCarrierHandler: BaseCarrierHandler{name: "twilio"}},
+	}
+
+	assert.Equal(t, "twilio", gw.carrierMetricLabel("twilio-prod"))
+	assert.Equal(t, "other", gw.carrierMetricLabel("unknown-carrier"))
+
+	counter := messagesTotal.WithLabelValues("outbound", "sms", "twilio", MetricResultSuccess)
+	before := testutil.ToFloat64(counter)
+	gw.recordMessage("outbound", MsgQueueItemType.SMS, "twilio-prod", MetricResultSuccess)
+	assert.Equal(t, before+1, testutil.ToFloat64(counter))
+}
+
+// histogramCount returns the number of observations in the series of vec
+// with the given label values, ordered by label name.
+func histogramCount(t *testing.T, vec *prometheus.HistogramVec, labels ...string) uint64 {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(vec)
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			var got []string
+			for _, pair := range metric.GetLabel() {
+				got = append(got, pair.GetValue())
+			}
+			if assert.ObjectsAreEqual(labels, got) {
+				return metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	return 0
+}
+
+func TestGateway_ObserveDelivery(t *testing.T) {
+	_, gw := newTestRouter(1)
+	gw.Carriers = map[string]CarrierHandler{
+		"vonage-uk": &VonageHandler{BaseCarrierHandler: BaseCarrierHandler{name: "vonage"}},
+	}
+	latencyBefore := histogramCount(t, deliveryLatency, "vonage", "mms")
+	waitBefore := histogramCount(t, queueWait, "vonage", "mms")
+
+	now := time.Now()
+	gw.observeDelivery(&MsgQueueItem{Type: MsgQueueItemType.MMS, ReceivedTimestamp: now.Add(-time.Minute), QueuedTimestamp: now}, "vonage-uk")
+	assert.Equal(t, latencyBefore+1, histogramCount(t, deliveryLatency, "vonage", "mms"))
+	assert.Equal(t, waitBefore+1, histogramCount(t, queueWait, "vonage", "mms"))
+
+	gw.observeDelivery(&MsgQueueItem{Type: MsgQueueItemType.MMS}, "vonage-uk")
+	assert.Equal(t, latencyBefore+1, histogramCount(t, deliveryLatency, "vonage", "mms"), "no ingress time, nothing observed")
+}
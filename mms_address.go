@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var mm4NumberRegex = regexp.MustCompile(`^\+?[0-9]{3,15}$`)
+
+// mm4Mailbox strips angle brackets, ESMTP parameters and any source route
+// from an envelope address, leaving the bare mailbox.
+func mm4Mailbox(raw string) (string, error) {
+	addr := strings.TrimSpace(raw)
+
+	if strings.HasPrefix(addr, "<") {
+		end := strings.Index(addr, ">")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated address: %s", raw)
+		}
+		addr = addr[1:end]
+	} else if i := strings.IndexAny(addr, " \t"); i >= 0 {
+		addr = addr[:i] // bare address followed by ESMTP parameters
+	}
+
+	// Source route: "@hop1,@hop2:mailbox"
+	if strings.HasPrefix(addr, "@") {
+		i := strings.Index(addr, ":")
+		if i < 0 {
+			return "", fmt.Errorf("malformed source route: %s", raw)
+		}
+		addr = addr[i+1:]
+	}
+
+	if addr == "" {
+		return "", fmt.Errorf("empty address: %s", raw)
+	}
+	return addr, nil
+}
+
+// mm4SystemAddress returns the mailbox of an MM4 system address such as
+// "<system-user@mmsc.example.com>", used as the envelope of .RES and report
+// messages. Numbers are not system addresses.
+func mm4SystemAddress(raw string) (string, bool) {
+	addr, err := mm4Mailbox(raw)
+	if err != nil || !strings.Contains(addr, "@") {
+		return "", false
+	}
+	if _, err := parseMM4Address(raw); err == nil {
+		return "", false
+	}
+	return addr, true
+}
+
+// mm4Hostname is the name the MM4 server announces in its 220 greeting and
+// in the EHLO of outbound sessions (MM4_HOSTNAME, default "localhost").
+func (c *GatewayConfig) mm4Hostname() string {
+	if c.MM4Hostname != "" {
+		return c.MM4Hostname
+	}
+	return "localhost"
+}
+
+// mm4MessageIDHost is the host part of the X-Mms-Message-ID we generate:
+// MM4_MSG_ID_HOST, otherwise mm4Hostname.
+func (c *GatewayConfig) mm4MessageIDHost() string {
+	if c.MM4MsgIDHost != "" {
+		return c.MM4MsgIDHost
+	}
+	return c.mm4Hostname()
+}
+
+// mm4OriginatorSystem is the system address this gateway uses for
+// X-Mms-Originator-System and as the envelope of the .RES it sends. Without
+// MM4_ORIGINATOR_SYSTEM it is system@MM4_HOSTNAME.
+func (c *GatewayConfig) mm4OriginatorSystem() string {
+	if c.MM4OriginatorSystem != "" {
+		return c.MM4OriginatorSystem
+	}
+	if c.MM4Hostname != "" {
+		return "system@" + c.MM4Hostname
+	}
+	return "system@yourdomain.com"
+}
+
+// parseMM4Address extracts the phone number from an MM4 envelope address
+// such as "<@relay.example:+1 (555) 123-4567/TYPE=PLMN@mms.example.com> SIZE=1024".
+// Angle brackets, ESMTP parameters, source routes, the domain and the
+// /TYPE=PLMN suffix are removed; anything that is not then a plausible
+// number is rejected.
+func parseMM4Address(raw string) (string, error) {
+	addr, err := mm4Mailbox(raw)
+	if err != nil {
+		return "", err
+	}
+
+	if i := strings.LastIndex(addr, "@"); i >= 0 {
+		addr = addr[:i]
+	}
+	if i := strings.Index(addr, "/"); i >= 0 {
+		addr = addr[:i]
+	}
+
+	addr = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '(', ')':
+			return -1
+		}
+		return r
+	}, addr)
+
+	if !mm4NumberRegex.MatchString(addr) {
+		return "", fmt.Errorf("invalid address: %s", raw)
+	}
+	return addr, nil
+}
+
+// mm4Recipients normalizes the RCPT TO numbers of a forward to E.164, taking
+// national numbers to be in country cc, and drops duplicates, keeping the
+// first occurrence. ok is false when there are no recipients or any of them
+// is not a phone number.
+func mm4Recipients(to []string, cc string) (recipients []string, ok bool) {
+	seen := make(map[string]bool, len(to))
+	for _, addr := range to {
+		if !mm4NumberRegex.MatchString(addr) {
+			return nil, false
+		}
+		number, err := FormatToE164(withCountryCode(addr, cc))
+		if err != nil {
+			return nil, false
+		}
+		if !seen[number] {
+			seen[number] = true
+			recipients = append(recipients, number)
+		}
+	}
+	return recipients, len(recipients) > 0
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMM4Address(t *testing.T) {
+	cases := map[string]string{
+		"<+15551234567/TYPE=PLMN@mms.example.com>":              "+15551234567",
+		" <15551234567/TYPE=PLMN@mms.example.com> SIZE=1024":    "15551234567",
+		"<@relay1.example,@relay2.example:15551234567@mms.com>": "15551234567",
+		"+15551234567/TYPE=PLMN":                                "+15551234567",
+		"<+1 (555) 123-4567/TYPE=PLMN>":                         "+15551234567",
+		"15551234567@mms.example.com BODY=8BITMIME":             "15551234567",
+		"<12345@shortcode.example>":                             "12345",
+	}
+	for raw, want := range cases {
+		t.Run(raw, func(t *testing.T) {
+			got, err := parseMM4Address(raw)
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+func TestParseMM4Address_RejectsMalformed(t *testing.T) {
+	for _, raw := range []string{
+		"",
+		"<>",
+		"<15551234567@mms.example.com",
+		"<@relay.example15551234567>",
+		"<alice@example.com>",
+		"<+1555abc4567/TYPE=PLMN@mms.example.com>",
+		"<1234567890123456/TYPE=PLMN>",
+	} {
+		_, err := parseMM4Address(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestSession_MailResetsRecipients(t *testing.T) {
+	_, gw := newTestRouter(1)
+	s := &Session{Server: &MM4Server{gateway: gw}}
+
+	require.NoError(t, s.handleMail("FROM:<+15551234567/TYPE=PLMN@mms.example.com>"))
+	require.NoError(t, s.handleRcpt("TO:<+15557654321/TYPE=PLMN@mms.example.com>"))
+	assert.Equal(t, "+15551234567", s.From)
+	assert.Equal(t, []string{"+15557654321"}, s.To)
+
+	require.NoError(t, s.handleMail("FROM:<15550000000@mms.example.com>"))
+	assert.Empty(t, s.To, "a new MAIL starts a new transaction")
+
+	assert.Error(t, s.handleRcpt("TO:<not-a-number@mms.example.com>"))
+	assert.Empty(t, s.To)
+}
+
+func TestMM4Hostname(t *testing.T) {
+	_, gw := newTestRouter(1)
+	config := &gw.Config
+	assert.Equal(t, "localhost", config.mm4Hostname())
+	assert.Equal(t, "localhost", config.mm4MessageIDHost())
+	assert.Equal(t, "system@yourdomain.com", config.mm4OriginatorSystem())
+
+	config.MM4Hostname = "mms.gw.example.com"
+	assert.Equal(t, "mms.gw.example.com", config.mm4Hostname())
+	assert.Equal(t, "mms.gw.example.com", config.mm4MessageIDHost())
+	assert.Equal(t, "system@mms.gw.example.com", config.mm4OriginatorSystem())
+
+	msg := (&MM4Server{gateway: gw}).createMM4Message(MsgQueueItem{LogID: "abc123", From: "+15551234567", To: "+15557654321"})
+	assert.Equal(t, "<abc123@mms.gw.example.com>", msg.Headers.Get("X-Mms-Message-ID"))
+	assert.Equal(t, "system@mms.gw.example.com", msg.Headers.Get("X-Mms-Originator-System"))
+
+	config.MM4MsgIDHost = "ids.example.com"
+	config.MM4OriginatorSystem = "mmsc@example.com"
+	assert.Equal(t, "ids.example.com", config.mm4MessageIDHost())
+	assert.Equal(t, "mmsc@example.com", config.mm4OriginatorSystem(), "explicit settings win")
+}
+
+func TestSession_EHLOAnnouncesHostname(t *testing.T) {
+	_, gw := newTestRouter(1)
+	gw.Config.MM4Hostname = "mms.gw.example.com"
+	srv := &MM4Server{gateway: gw, clientStates: map[string]*MM4ClientState{}}
+
+	var out bytes.Buffer
+	s := &Session{Server: srv, Writer: bufio.NewWriter(&out)}
+	require.NoError(t, s.handleCommand("EHLO mmsc.carrier.example", srv))
+	assert.Equal(t, "250 mms.gw.example.com Hello\r\n", out.String())
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+)
+
+// audioPathDefault is the MMS_AUDIO_CODEC key that applies to every outbound
+// path without its own entry.
+const audioPathDefault = "*"
+
+// audioTarget describes how a carrier-friendly audio codec is encoded.
+type audioTarget struct {
+	args map[string]interface{}
+	mime string
+	ext  string
+}
+
+var audioTargets = map[string]audioTarget{
+	"mp3": {
+		args: map[string]interface{}{"c:a": "libmp3lame", "b:a": "128k", "ar": "44100", "f": "mp3"},
+		mime: "audio/mp3",
+		ext:  ".mp3",
+	},
+	"aac": {
+		args: map[string]interface{}{"c:a": "aac", "b:a": "64k", "f": "adts"},
+		mime: "audio/aac",
+		ext:  ".aac",
+	},
+	"amr": {
+		args: map[string]interface{}{"c:a": "libopencore_amrnb", "b:a": "12.2k", "ar": "8000", "ac": "1", "f": "amr"},
+		mime: "audio/amr",
+		ext:  ".amr",
+	},
+}
+
+// normalizeAudioCodec maps ffprobe codec names onto the audioTargets keys.
+func normalizeAudioCodec(codec string) string {
+	codec = strings.ToLower(strings.TrimSpace(codec))
+	switch {
+	case strings.HasPrefix(codec, "amr"):
+		return "amr"
+	case codec == "aac":
+		return "aac"
+	case codec == "mp3" || codec == "libmp3lame":
+		return "mp3"
+	}
+	return codec
+}
+
+// parseAudioCodecs reads MMS_AUDIO_CODEC: either a single codec for every
+// path, or comma-separated path=codec pairs where path is a carrier name,
+// "mm4", "web" or "*".
+func parseAudioCodecs(val string) map[string]string {
+	codecs := make(map[string]string)
+	for _, entry := range strings.Split(val, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		path, codec := audioPathDefault, entry
+		if k, v, ok := strings.Cut(entry, "="); ok {
+			path, codec = strings.ToLower(strings.TrimSpace(k)), v
+		}
+		codec = normalizeAudioCodec(codec)
+		if _, ok := audioTargets[codec]; ok && path != "" {
+			codecs[path] = codec
+		}
+	}
+	return codecs
+}
+
+// audioCodecFor returns the forced audio codec for an outbound path, or ""
+// to keep carrier-compatible input as is.
+func (c TranscodeConfig) audioCodecFor(path string) string {
+	if codec, ok := c.AudioCodecs[strings.ToLower(path)]; ok {
+		return codec
+	}
+	return c.AudioCodecs[audioPathDefault]
+}
+
+// chooseAudioTarget decides what to do with an audio attachment whose probed
+// codec is codec. It returns "" to pass the content through unchanged, or
+// the codec to transcode to.
+func chooseAudioTarget(codec string, size, maxSize int, forced string) string {
+	codec = normalizeAudioCodec(codec)
+	fits := size <= maxSize
+
+	if forced != "" {
+		if codec == forced && fits {
+			return ""
+		}
+		return forced
+	}
+
+	switch codec {
+	case "amr", "aac":
+		if fits {
+			return ""
+		}
+		// Too large: re-encode in the same codec at our bitrate.
+		return codec
+	}
+	return "mp3"
+}
+
+// convertAudio transcodes audio content to codec using ffmpeg. The size
+// limit is checked against the encoded output.
+func convertAudio(content []byte, codec string, maxSize int) ([]byte, string, string, error) {
+	target, ok := audioTargets[codec]
+	if !ok {
+		return nil, "", "", fmt.Errorf("unsupported audio codec %q", codec)
+	}
+
+	pr, pw := io.Pipe()
+	prOut, pwOut := io.Pipe()
+
+	go func() {
+		_, _ = pw.Write(content)
+		_ = pw.Close()
+	}()
+
+	var outputBuffer bytes.Buffer
+	var runErr error
+
+	go func() {
+		runErr = ffmpeg.Input("pipe:0").
+			Output("pipe:1", ffmpeg.KwArgs(target.args)).
+			WithInput(pr).
+			WithOutput(pwOut).
+			OverWriteOutput().
+			Run()
+		_ = pwOut.Close()
+	}()
+
+	_, _ = io.Copy(&outputBuffer, prOut)
+
+	if outputBuffer.Len() == 0 {
+		if runErr != nil {
+			return nil, "", "", fmt.Errorf("audio transcode to %s failed: %w", codec, runErr)
+		}
+		return nil, "", "", fmt.Errorf("audio transcode to %s produced no output", codec)
+	}
+	if outputBuffer.Len() > maxSize {
+		return nil, "", "", fmt.Errorf("compressed audio file exceeds size limit of %.2f KB", float64(maxSize)/1024)
+	}
+
+	return outputBuffer.Bytes(), target.mime, target.ext, nil
+}
+
+// outboundMediaPath names where an MM4 submission is headed, for choosing a
+// forced audio codec: "mm4" or "web" for local clients (including legacy
+// clients that take MMS on their webhook), otherwise the
+// carrier selected for the destination.
+func (s *MM4Server) outboundMediaPath(m *MM4Message) string {
+	router := s.gateway.Router
+	if client, _ := router.findClientByNumber(m.To); client != nil {
+		if client.Type == "web" || client.usesWebhookDelivery(MsgQueueItemType.MMS) {
+			return "web"
+		}
+		return "mm4"
+	}
+	return router.selectCarrier(m.From, m.To)
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MM4 capture modes (MM4_CAPTURE).
+const (
+	MM4CaptureOff    = "off"    // nothing is stored (default)
+	MM4CaptureErrors = "errors" // sessions that hit dumpFullMM4
+	MM4CaptureAll    = "all"    // every DATA transaction
+)
+
+const (
+	defaultMM4CaptureDB         = "gomsggw"
+	defaultMM4CaptureCollection = "mm4_captures"
+	defaultMM4CaptureTTLHours   = 72
+
+	mm4CaptureTimeout = 5 * time.Second
+)
+
+var errMM4CaptureNotFound = errors.New("capture not found")
+
+// mm4CaptureConfig controls raw MM4 capture to Mongo.
+type mm4CaptureConfig struct {
+	Mode       string // MM4_CAPTURE
+	URI        string // MONGODB_URI
+	Database   string // MM4_CAPTURE_DB
+	Collection string // MM4_CAPTURE_COLLECTION
+	TTL        time.Duration
+}
+
+func loadMM4CaptureConfig(env *envReader) mm4CaptureConfig {
+	return mm4CaptureConfig{
+		Mode:       env.oneOf("MM4_CAPTURE", MM4CaptureOff, validMM4CaptureMode, "off, errors or all"),
+		URI:        env.str("MONGODB_URI", ""),
+		Database:   env.str("MM4_CAPTURE_DB", defaultMM4CaptureDB),
+		Collection: env.str("MM4_CAPTURE_COLLECTION", defaultMM4CaptureCollection),
+		TTL:        time.Duration(env.int("MM4_CAPTURE_TTL_HOURS", defaultMM4CaptureTTLHours, 1)) * time.Hour,
+	}
+}
+
+func validMM4CaptureMode(mode string) bool {
+	switch mode {
+	case MM4CaptureOff, MM4CaptureErrors, MM4CaptureAll:
+		return true
+	}
+	return false
+}
+
+// enabled reports whether captures should be stored at all.
+func (cfg mm4CaptureConfig) enabled() bool {
+	return cfg.Mode != MM4CaptureOff && cfg.URI != ""
+}
+
+// MM4Capture is a complete inbound MM4 DATA transaction, kept for debugging.
+// Documents expire CreatedAt + TTL via a Mongo TTL index.
+type MM4Capture struct {
+	ID           primitive.ObjectID  `bson:"_id" json:"id"`
+	CreatedAt    time.Time           `bson:"created_at" json:"created_at"`
+	Reason       string              `bson:"reason" json:"reason"`
+	Client       string              `bson:"client" json:"client"`
+	ClientIP     string              `bson:"client_ip" json:"client_ip"`
+	SessionID    string              `bson:"session_id" json:"session_id"`
+	EnvelopeFrom string              `bson:"envelope_from" json:"envelope_from"`
+	EnvelopeTo   []string            `bson:"envelope_to" json:"envelope_to"`
+	Headers      map[string][]string `bson:"headers" json:"headers"`
+	Body         []byte              `bson:"body" json:"-"`
+}
+
+// raw rebuilds the DATA section (headers, blank line, body) as received.
+func (c *MM4Capture) raw() []byte {
+	keys := make([]string, 0, len(c.Headers))
+	for k := range c.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, k := range keys {
+		for _, v := range c.Headers[k] {
+			buf.WriteString(fmt.Sprintf("%s: %s\r\n", k, v))
+		}
+	}
+	buf.WriteString("\r\n")
+	buf.Write(c.Body)
+	return buf.Bytes()
+}
+
+// mm4CaptureStore writes captures to a Mongo collection with a TTL index.
+type mm4CaptureStore struct {
+	mode string
+	coll *mongo.Collection
+}
+
+// newMM4CaptureStore connects to Mongo and ensures the TTL index exists.
+func newMM4CaptureStore(ctx context.Context, cfg mm4CaptureConfig) (*mm4CaptureStore, *mongo.Client, error) {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.URI))
+	if err != nil {
+		return nil, nil, fmt.Errorf("connect: %w", err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		_ = client.Disconnect(context.Background())
+		return nil, nil, fmt.Errorf("ping: %w", err)
+	}
+
+	coll := client.Database(cfg.Database).Collection(cfg.Collection)
+	_, err = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(cfg.TTL.Seconds())),
+	})
+	if err != nil {
+		_ = client.Disconnect(context.Background())
+		return nil, nil, fmt.Errorf("create TTL index: %w", err)
+	}
+
+	return &mm4CaptureStore{mode: cfg.Mode, coll: coll}, client, nil
+}
+
+func (c *mm4CaptureStore) save(ctx context.Context, capture *MM4Capture) (string, error) {
+	if capture.ID.IsZero() {
+		capture.ID = primitive.NewObjectID()
+	}
+	if capture.CreatedAt.IsZero() {
+		capture.CreatedAt = time.Now().UTC()
+	}
+	if _, err := c.coll.InsertOne(ctx, capture); err != nil {
+		return "", err
+	}
+	return capture.ID.Hex(), nil
+}
+
+func (c *mm4CaptureStore) get(ctx context.Context, id string) (*MM4Capture, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errMM4CaptureNotFound
+	}
+	var capture MM4Capture
+	err = c.coll.FindOne(ctx, bson.M{"_id": oid}).Decode(&capture)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, errMM4CaptureNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &capture, nil
+}
+
+// startCapture connects the capture store when MM4_CAPTURE is enabled.
+// Failures are logged and leave capture off; they never stop the server.
+func (s *MM4Server) startCapture() {
+	cfg := s.gateway.Config.MM4Capture
+	if !cfg.enabled() {
+		return
+	}
+
+	lm := s.gateway.LogManager
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	store, client, err := newMM4CaptureStore(ctx, cfg)
+	if err != nil {
+		lm.SendLog(lm.BuildLog(
+			"Server.MM4.Capture",
+			"StartError",
+			logrus.ErrorLevel,
+			map[string]interface{}{
+				"mode":       cfg.Mode,
+				"database":   cfg.Database,
+				"collection": cfg.Collection,
+			},
+			err,
+		))
+		return
+	}
+
+	s.mongo = client
+	s.capture = store
+	lm.SendLog(lm.BuildLog(
+		"Server.MM4.Capture",
+		"Enabled",
+		logrus.InfoLevel,
+		map[string]interface{}{
+			"mode":       cfg.Mode,
+			"database":   cfg.Database,
+			"collection": cfg.Collection,
+			"ttl_hours":  int(cfg.TTL.Hours()),
+		},
+	))
+}
+
+// newMM4Capture snapshots the session's current DATA transaction.
+func (s *Session) newMM4Capture(reason string) *MM4Capture {
+	headers := make(map[string][]string, len(s.Headers))
+	for k, v := range s.Headers {
+		headers[k] = append([]string(nil), v...)
+	}
+	return &MM4Capture{
+		Reason:       reason,
+		Client:       safeClientUsername(s.Client),
+		ClientIP:     s.ClientIP,
+		SessionID:    s.SessionID,
+		EnvelopeFrom: s.From,
+		EnvelopeTo:   append([]string(nil), s.To...),
+		Headers:      headers,
+		Body:         append([]byte(nil), s.Data...),
+	}
+}
+
+// captureMM4 stores the current transaction and returns its capture ID. A
+// transaction is stored at most once; with capture off nothing is stored and
+// "" is returned.
+func (s *Session) captureMM4(reason string) string {
+	store := s.Server.capture
+	if store == nil || s.CaptureID != "" {
+		return s.CaptureID
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mm4CaptureTimeout)
+	defer cancel()
+
+	id, err := store.save(ctx, s.newMM4Capture(reason))
+	if err != nil {
+		lm := s.Server.gateway.LogManager
+		lm.SendLog(lm.BuildLog(
+			"Server.MM4.Capture",
+			"SaveError",
+			logrus.ErrorLevel,
+			map[string]interface{}{
+				"client":     safeClientUsername(s.Client),
+				"session_id": s.SessionID,
+				"reason":     reason,
+			},
+			err,
+		))
+		return ""
+	}
+	s.CaptureID = id
+	return id
+}
+
+// SetupDebugRoutes sets up the HTTP routes for retrieving debug captures.
+func SetupDebugRoutes(app *iris.Application, gateway *Gateway) {
+	debug := app.Party("/debug", gateway.basicAuthMiddleware)
+	{
+		// GET /debug/mm4/{id} - raw MM4 capture (?format=json for metadata only)
+		debug.Get("/mm4/{id}", gateway.webMM4Capture)
+	}
+}
+
+func (gateway *Gateway) webMM4Capture(ctx iris.Context) {
+	if gateway.MM4Server == nil || gateway.MM4Server.capture == nil {
+		apiError(ctx, iris.StatusNotFound, errCodeCaptureNotFound, "MM4 capture is disabled")
+		return
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx.Request().Context(), mm4CaptureTimeout)
+	defer cancel()
+
+	capture, err := gateway.MM4Server.capture.get(reqCtx, ctx.Params().Get("id"))
+	if errors.Is(err, errMM4CaptureNotFound) {
+		apiError(ctx, iris.StatusNotFound, errCodeCaptureNotFound, "Capture not found")
+		return
+	}
+	if err != nil {
+		apiError(ctx, iris.StatusInternalServerError, errCodeInternal, "Failed to load capture")
+		return
+	}
+
+	if ctx.URLParam("format") == "json" {
+		ctx.JSON(iris.Map{
+			"capture":  capture,
+			"body_len": len(capture.Body),
+		})
+		return
+	}
+
+	ctx.ContentType("message/rfc822")
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"mm4-%s.eml\"", capture.ID.Hex()))
+	ctx.Write(capture.raw())
+}
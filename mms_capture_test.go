@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadMM4CaptureConfig(t *testing.T) {
+	t.Setenv("MM4_CAPTURE", "")
+	t.Setenv("MONGODB_URI", "mongodb://localhost:27017")
+	cfg := loadMM4CaptureConfig(&envReader{})
+	assert.Equal(t, MM4CaptureOff, cfg.Mode)
+	assert.False(t, cfg.enabled(), "capture is off by default")
+	assert.Equal(t, 72*time.Hour, cfg.TTL)
+
+	t.Setenv("MM4_CAPTURE", "Errors")
+	t.Setenv("MM4_CAPTURE_TTL_HOURS", "6")
+	t.Setenv("MM4_CAPTURE_COLLECTION", "raw_mm4")
+	cfg = loadMM4CaptureConfig(&envReader{})
+	assert.Equal(t, MM4CaptureErrors, cfg.Mode)
+	assert.True(t, cfg.enabled())
+	assert.Equal(t, 6*time.Hour, cfg.TTL)
+	assert.Equal(t, "raw_mm4", cfg.Collection)
+
+	t.Setenv("MONGODB_URI", "")
+	assert.False(t, loadMM4CaptureConfig(&envReader{}).enabled(), "no URI, no capture")
+
+	t.Setenv("MONGODB_URI", "mongodb://localhost:27017")
+	t.Setenv("MM4_CAPTURE", "sometimes")
+	assert.False(t, loadMM4CaptureConfig(&envReader{}).enabled())
+}
+
+func TestMM4Capture_Raw(t *testing.T) {
+	s := &Session{
+		From:      "+15551234567",
+		To:        []string{"+15557654321"},
+		SessionID: "sess-1",
+		Headers: textproto.MIMEHeader{
+			"Content-Type":       {"multipart/related; boundary=b1"},
+			"X-Mms-Message-Type": {MM4ForwardReq},
+		},
+		Data: []byte("--b1\r\nContent-Type: text/plain\r\n\r\nhi\r\n--b1--\r\n"),
+	}
+
+	capture := s.newMM4Capture("parse_mime_parts_error")
+	assert.Equal(t, "parse_mime_parts_error", capture.Reason)
+	assert.Equal(t, "sess-1", capture.SessionID)
+	assert.Equal(t, []string{"+15557654321"}, capture.EnvelopeTo)
+
+	raw := string(capture.raw())
+	assert.True(t, strings.HasPrefix(raw, "Content-Type: multipart/related; boundary=b1\r\nX-Mms-Message-Type: MM4_forward.REQ\r\n\r\n"))
+	assert.True(t, strings.HasSuffix(raw, string(s.Data)), "body is kept in full")
+
+	s.Data[0] = 'x'
+	assert.Equal(t, byte('-'), capture.Body[0], "capture owns its copy of the body")
+}
+
+func TestSession_CaptureDisabled(t *testing.T) {
+	_, gw := newTestRouter(1)
+	s := &Session{Server: &MM4Server{gateway: gw}, Data: []byte("body")}
+
+	assert.Empty(t, s.captureMM4("handle_mm4_message_error"))
+	assert.Empty(t, s.CaptureID, "nothing is stored when capture is off")
+	s.dumpFullMM4("handle_mm4_message_error")
+}
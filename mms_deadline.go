@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net"
+	"time"
+)
+
+// mm4DeadlineChunk is the most written to an outbound MM4 connection before
+// its deadline is pushed out again.
+const mm4DeadlineChunk = 32 * 1024
+
+// mm4Timeouts holds the effective timeouts for an outbound MM4 session.
+type mm4Timeouts struct {
+	Dial time.Duration // connecting to the client's MM4 server
+	Idle time.Duration // waiting on any single read or write
+}
+
+// mm4Timeouts resolves the outbound MM4 timeouts for a client: its own
+// settings where set, otherwise MM4_DIAL_TIMEOUT and MM4_SESSION_DEADLINE.
+func (gateway *Gateway) mm4Timeouts(client *Client) mm4Timeouts {
+	dial := gateway.Config.MM4DialTimeoutSecs
+	idle := gateway.Config.MM4SessionDeadlineSecs
+
+	if client != nil && client.Settings != nil {
+		if client.Settings.MM4DialTimeoutSecs > 0 {
+			dial = client.Settings.MM4DialTimeoutSecs
+		}
+		if client.Settings.MM4SessionDeadlineSecs > 0 {
+			idle = client.Settings.MM4SessionDeadlineSecs
+		}
+	}
+
+	if dial <= 0 {
+		dial = 10
+	}
+	if idle <= 0 {
+		idle = 30
+	}
+
+	return mm4Timeouts{
+		Dial: time.Duration(dial) * time.Second,
+		Idle: time.Duration(idle) * time.Second,
+	}
+}
+
+// mm4DeadlineConn moves the connection deadline idle into the future before
+// every read and every chunk written, so a large transfer that keeps making
+// progress is not cut off while a stalled peer still fails within idle.
+type mm4DeadlineConn struct {
+	net.Conn
+	idle time.Duration
+}
+
+func (c *mm4DeadlineConn) Read(p []byte) (int, error) {
+	_ = c.Conn.SetDeadline(time.Now().Add(c.idle))
+	return c.Conn.Read(p)
+}
+
+func (c *mm4DeadlineConn) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		end := written + mm4DeadlineChunk
+		if end > len(p) {
+			end = len(p)
+		}
+		_ = c.Conn.SetDeadline(time.Now().Add(c.idle))
+		n, err := c.Conn.Write(p[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGateway_MM4Timeouts(t *testing.T) {
+	gw := &Gateway{Config: GatewayConfig{MM4DialTimeoutSecs: 5, MM4SessionDeadlineSecs: 45}}
+
+	got := gw.mm4Timeouts(&Client{})
+	assert.Equal(t, 5*time.Second, got.Dial)
+	assert.Equal(t, 45*time.Second, got.Idle)
+
+	got = gw.mm4Timeouts(&Client{Settings: &ClientSettings{MM4SessionDeadlineSecs: 120}})
+	assert.Equal(t, 5*time.Second, got.Dial)
+	assert.Equal(t, 120*time.Second, got.Idle)
+
+	gw.Config = GatewayConfig{}
+	got = gw.mm4Timeouts(nil)
+	assert.Equal(t, 10*time.Second, got.Dial)
+	assert.Equal(t, 30*time.Second, got.Idle)
+}
+
+func TestLoadGatewayConfig_MM4Timeouts(t *testing.T) {
+	t.Setenv("ENCRYPTION_KEY", "test-key")
+	t.Setenv("MM4_DIAL_TIMEOUT", "3")
+	t.Setenv("MM4_SESSION_DEADLINE", "90")
+
+	cfg := mustLoadGatewayConfig(t)
+	assert.Equal(t, 3, cfg.MM4DialTimeoutSecs)
+	assert.Equal(t, 90, cfg.MM4SessionDeadlineSecs)
+}
+
+func TestMM4DeadlineConn_ExtendsWhileDataFlows(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	conn := &mm4DeadlineConn{Conn: local, idle: 100 * time.Millisecond}
+
+	// The peer drains slowly: the whole write takes well past idle, but
+	// no single chunk waits longer than it.
+	payload := make([]byte, 6*mm4DeadlineChunk)
+	go func() {
+		buf := make([]byte, mm4DeadlineChunk)
+		for {
+			time.Sleep(40 * time.Millisecond)
+			if _, err := remote.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	n, err := conn.Write(payload)
+	require.NoError(t, err)
+	assert.Equal(t, len(payload), n)
+}
+
+func TestMM4DeadlineConn_StalledPeerTimesOut(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	conn := &mm4DeadlineConn{Conn: local, idle: 50 * time.Millisecond}
+
+	_, err := conn.Read(make([]byte, 1))
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	assert.True(t, netErr.Timeout())
+	assert.NotErrorIs(t, err, io.EOF)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+)
+
+// exifOrientationTag is the IFD0 tag holding the EXIF orientation, 1 to 8.
+const exifOrientationTag = 0x0112
+
+// exifOrientation returns the EXIF orientation of a JPEG (APP1 segment) or
+// PNG (eXIf chunk), or 1, upright, when there is none or it cannot be read.
+func exifOrientation(content []byte) int {
+	var tiff []byte
+	switch {
+	case bytes.HasPrefix(content, []byte{0xFF, 0xD8}):
+		tiff = jpegExif(content)
+	case bytes.HasPrefix(content, []byte("\x89PNG\r\n\x1a\n")):
+		tiff = pngExif(content)
+	}
+	if o := tiffOrientation(tiff); o >= 1 && o <= 8 {
+		return o
+	}
+	return 1
+}
+
+// jpegExif returns the TIFF data of a JPEG's EXIF APP1 segment, or nil.
+func jpegExif(content []byte) []byte {
+	for i := 2; i+4 <= len(content); {
+		if content[i] != 0xFF {
+			return nil
+		}
+		marker := content[i+1]
+		if marker == 0xDA { // start of scan: no more metadata
+			return nil
+		}
+		n := int(binary.BigEndian.Uint16(content[i+2:]))
+		if n < 2 || i+2+n > len(content) {
+			return nil
+		}
+		segment := content[i+4 : i+2+n]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		i += 2 + n
+	}
+	return nil
+}
+
+// pngExif returns the TIFF data of a PNG's eXIf chunk, or nil.
+func pngExif(content []byte) []byte {
+	for i := 8; i+8 <= len(content); {
+		n := int(binary.BigEndian.Uint32(content[i:]))
+		kind := string(content[i+4 : i+8])
+		if kind == "IDAT" || n < 0 || i+12+n > len(content) {
+			return nil
+		}
+		if kind == "eXIf" {
+			return content[i+8 : i+8+n]
+		}
+		i += 12 + n
+	}
+	return nil
+}
+
+// tiffOrientation reads the orientation tag from the first IFD of EXIF TIFF
+// data, or returns 0.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + 12*i
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == exifOrientationTag {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 0
+}
+
+// orientImage returns img turned upright for an EXIF orientation: rotated
+// and/or mirrored as the orientation says the stored pixels must be shown.
+func orientImage(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	src := toRGBA(img)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirror horizontally
+				sx, sy = w-1-x, y
+			case 3: // rotate 180°
+				sx, sy = w-1-x, h-1-y
+			case 4: // mirror vertically
+				sx, sy = x, h-1-y
+			case 5: // transpose
+				sx, sy = y, x
+			case 6: // rotate 90° clockwise
+				sx, sy = y, h-1-x
+			case 7: // transverse
+				sx, sy = w-1-y, h-1-x
+			case 8: // rotate 90° counter-clockwise
+				sx, sy = w-1-y, x
+			}
+			i := src.PixOffset(src.Rect.Min.X+sx, src.Rect.Min.Y+sy)
+			copy(dst.Pix[dst.PixOffset(x, y):], src.Pix[i:i+4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// exifJPEG returns img as a JPEG with an EXIF APP1 segment holding the given
+// orientation and a GPS IFD pointer, as phone cameras write them.
+func exifJPEG(t *testing.T, img image.Image, orientation uint16) []byte {
+	t.Helper()
+	var tiff bytes.Buffer
+	tiff.WriteString("MM\x00\x2A")
+	_ = binary.Write(&tiff, binary.BigEndian, uint32(8))
+	_ = binary.Write(&tiff, binary.BigEndian, uint16(2))
+	_ = binary.Write(&tiff, binary.BigEndian, []uint16{exifOrientationTag, 3})
+	_ = binary.Write(&tiff, binary.BigEndian, uint32(1))
+	_ = binary.Write(&tiff, binary.BigEndian, []uint16{orientation, 0})
+	_ = binary.Write(&tiff, binary.BigEndian, []uint16{0x8825, 4}) // GPSInfo
+	_ = binary.Write(&tiff, binary.BigEndian, []uint32{1, 0})
+	_ = binary.Write(&tiff, binary.BigEndian, uint32(0))
+
+	var encoded bytes.Buffer
+	require.NoError(t, jpeg.Encode(&encoded, img, &jpeg.Options{Quality: 95}))
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	var out bytes.Buffer
+	out.Write(encoded.Bytes()[:2])
+	out.Write([]byte{0xFF, 0xE1})
+	_ = binary.Write(&out, binary.BigEndian, uint16(len(segment)+2))
+	out.Write(segment)
+	out.Write(encoded.Bytes()[2:])
+	return out.Bytes()
+}
+
+// redOverBlue returns a w×h image whose top half is red and bottom half blue.
+func redOverBlue(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		c := color.RGBA{R: 255, A: 255}
+		if y >= h/2 {
+			c = color.RGBA{B: 255, A: 255}
+		}
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestExifOrientation(t *testing.T) {
+	content := exifJPEG(t, redOverBlue(16, 16), 6)
+	assert.Equal(t, 6, exifOrientation(content))
+
+	var plain bytes.Buffer
+	require.NoError(t, jpeg.Encode(&plain, redOverBlue(16, 16), nil))
+	assert.Equal(t, 1, exifOrientation(plain.Bytes()))
+	assert.Equal(t, 1, exifOrientation([]byte("not an image")))
+}
+
+func TestOrientImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	marker := color.RGBA{G: 255, A: 255}
+	img.Set(0, 0, marker) // stored top-left
+
+	cases := []struct {
+		orientation int
+		x, y        int
+	}{
+		{1, 0, 0}, {2, 2, 0}, {3, 2, 1}, {4, 0, 1},
+		{5, 0, 0}, {6, 1, 0}, {7, 1, 2}, {8, 0, 2},
+	}
+	for _, c := range cases {
+		out := orientImage(img, c.orientation)
+		if c.orientation >= 5 {
+			assert.Equal(t, image.Rect(0, 0, 2, 3), out.Bounds(), "orientation %d", c.orientation)
+		} else {
+			assert.Equal(t, image.Rect(0, 0, 3, 2), out.Bounds(), "orientation %d", c.orientation)
+		}
+		assert.Equal(t, color.RGBA(marker), color.RGBAModel.Convert(out.At(c.x, c.y)), "orientation %d", c.orientation)
+	}
+}
+
+func TestCompressJPEG_AppliesOrientationAndStripsExif(t *testing.T) {
+	// Stored 64×32 with red on top; orientation 6 says to rotate it
+	// clockwise, which puts red on the right.
+	content := exifJPEG(t, redOverBlue(64, 32), 6)
+
+	out, err := compressJPEG(content, targetOutputSize, 0)
+	require.NoError(t, err)
+	assert.False(t, bytes.Contains(out, []byte("Exif")), "no EXIF in the output")
+	assert.Equal(t, 1, exifOrientation(out))
+
+	img, err := jpeg.Decode(bytes.NewReader(out))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 32, 64), img.Bounds())
+
+	r, _, b, _ := img.At(24, 32).RGBA()
+	assert.Greater(t, r>>8, uint32(200), "right half is red")
+	assert.Less(t, b>>8, uint32(60))
+	r, _, b, _ = img.At(8, 32).RGBA()
+	assert.Greater(t, b>>8, uint32(200), "left half is blue")
+	assert.Less(t, r>>8, uint32(60))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+)
+
+// minImageDimension is the longest side, in pixels, below which images are
+// no longer halved while searching for an encoding that fits.
+const minImageDimension = 64
+
+// longestSide returns the larger of img's width and height.
+func longestSide(img image.Image) int {
+	b := img.Bounds()
+	if b.Dx() > b.Dy() {
+		return b.Dx()
+	}
+	return b.Dy()
+}
+
+// capImageDimension halves img until its longest side is at most maxDim.
+// A maxDim of zero or less leaves img unchanged.
+func capImageDimension(img image.Image, maxDim int) image.Image {
+	if maxDim <= 0 {
+		return img
+	}
+	for longestSide(img) > maxDim && longestSide(img) > 1 {
+		img = halveImage(img)
+	}
+	return img
+}
+
+// halveImage returns img at half its width and height, averaging each 2×2
+// block of source pixels so the aspect ratio is preserved and detail is
+// smoothed rather than dropped.
+func halveImage(img image.Image) image.Image {
+	src := toRGBA(img)
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	w, h := sw/2, sh/2
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sum [4]int
+			n := 0
+			for sy := 2 * y; sy < 2*y+2 && sy < sh; sy++ {
+				for sx := 2 * x; sx < 2*x+2 && sx < sw; sx++ {
+					i := src.PixOffset(src.Rect.Min.X+sx, src.Rect.Min.Y+sy)
+					for c := 0; c < 4; c++ {
+						sum[c] += int(src.Pix[i+c])
+					}
+					n++
+				}
+			}
+			j := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[j+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
+
+// toRGBA returns img as an *image.RGBA, converting it if needed.
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	return rgba
+}
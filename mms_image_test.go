@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noisyImage returns a w×h image of random pixels, which JPEG cannot
+// compress well, so only downscaling brings it under a small cap.
+func noisyImage(w, h int) *image.RGBA {
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	rng.Read(img.Pix)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xFF
+	}
+	return img
+}
+
+func TestHalveImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	img.Set(0, 0, color.RGBA{R: 200, A: 255})
+	img.Set(1, 0, color.RGBA{R: 100, A: 255})
+	img.Set(0, 1, color.RGBA{A: 255})
+	img.Set(1, 1, color.RGBA{A: 255})
+
+	half := halveImage(img)
+	assert.Equal(t, image.Rect(0, 0, 2, 1), half.Bounds())
+	r, _, _, _ := half.At(0, 0).RGBA()
+	assert.Equal(t, uint32(75), r>>8, "2×2 block is averaged")
+}
+
+func TestCapImageDimension(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4000, 3000))
+
+	capped := capImageDimension(img, 1000)
+	assert.Equal(t, image.Rect(0, 0, 1000, 750), capped.Bounds())
+	assert.Same(t, img, capImageDimension(img, 0))
+}
+
+func TestCompressJPEG_DownscalesLargePhoto(t *testing.T) {
+	var src bytes.Buffer
+	require.NoError(t, jpeg.Encode(&src, noisyImage(3200, 2400), &jpeg.Options{Quality: 90}))
+
+	const maxSize = 300 * 1024
+	require.Greater(t, src.Len(), maxSize)
+
+	out, err := compressJPEG(src.Bytes(), maxSize, 0)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(out), maxSize)
+
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(out))
+	require.NoError(t, err)
+	assert.Less(t, cfg.Width, 3200)
+	assert.Equal(t, cfg.Width*3, cfg.Height*4, "aspect ratio preserved")
+}
+
+func TestCompressPNG_MaxDimension(t *testing.T) {
+	var src bytes.Buffer
+	require.NoError(t, png.Encode(&src, image.NewRGBA(image.Rect(0, 0, 2000, 1000))))
+
+	out, err := compressPNG(src.Bytes(), targetOutputSize, 640)
+	require.NoError(t, err)
+
+	cfg, err := png.DecodeConfig(bytes.NewReader(out))
+	require.NoError(t, err)
+	assert.Equal(t, 500, cfg.Width)
+	assert.Equal(t, 250, cfg.Height)
+}
+
+func TestLoadTranscodeConfig_MaxImageDimension(t *testing.T) {
+	t.Setenv("MMS_MAX_IMAGE_DIMENSION", "")
+	assert.Equal(t, 0, loadTranscodeConfig(&envReader{}).MaxImageDimension)
+
+	t.Setenv("MMS_MAX_IMAGE_DIMENSION", "1600")
+	assert.Equal(t, 1600, loadTranscodeConfig(&envReader{}).MaxImageDimension)
+}
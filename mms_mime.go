@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"path/filepath"
+	"strings"
+)
+
+// extToMime maps file extensions to content types for checking declared MM4
+// part types. It is fixed rather than taken from the OS mime tables so the
+// result does not depend on the host.
+var extToMime = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".bmp":  "image/bmp",
+	".webp": "image/webp",
+	".3gp":  "video/3gpp",
+	".3g2":  "video/3gpp2",
+	".mp4":  "video/mp4",
+	".mov":  "video/quicktime",
+	".mp3":  "audio/mpeg",
+	".wav":  "audio/wav",
+	".ogg":  "audio/ogg",
+	".amr":  "audio/amr",
+	".pdf":  "application/pdf",
+	".txt":  "text/plain",
+	".smil": "application/smil",
+	".smi":  "application/smil",
+}
+
+// baseMediaType returns the lower-cased media type of a Content-Type value
+// without its parameters.
+func baseMediaType(contentType string) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mediaType
+	}
+	return strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+}
+
+// isGenericContentType reports whether a declared type says nothing useful
+// about the content.
+func isGenericContentType(mediaType string) bool {
+	switch mediaType {
+	case "", "application/octet-stream", "binary/octet-stream", "application/unknown":
+		return true
+	}
+	return false
+}
+
+// isKnownBinaryType reports whether a sniffed type is a format whose
+// signature is reliable enough to trust over a declared type.
+func isKnownBinaryType(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "image/") ||
+		strings.HasPrefix(mediaType, "video/") ||
+		strings.HasPrefix(mediaType, "audio/") ||
+		mediaType == "application/pdf"
+}
+
+// resolvePartContentType returns the content type to use for a decoded MM4
+// part. The declared type is kept unless it is missing or generic, is a text
+// type, or disagrees with the filename extension; in those cases the content
+// is sniffed, and a sniffed image, video, audio or PDF type wins over the
+// declared one. overridden is true when a specific declared type was replaced.
+func resolvePartContentType(declared, filename string, content []byte) (resolved string, overridden bool) {
+	declaredType := baseMediaType(declared)
+	extType := extToMime[strings.ToLower(filepath.Ext(filename))]
+	generic := isGenericContentType(declaredType)
+
+	if !generic && !strings.HasPrefix(declaredType, "text/") && (extType == "" || extType == declaredType) {
+		return declared, false
+	}
+
+	sniffed := detectMIMEType(content)
+	sniffedType := baseMediaType(sniffed)
+
+	switch {
+	case isKnownBinaryType(sniffedType) && sniffedType != declaredType:
+		return sniffed, !generic
+	case !generic:
+		return declared, false
+	case isGenericContentType(sniffedType) && extType != "":
+		return extType, false
+	}
+	return sniffed, false
+}
+
+// readTransferEncoded reads a MIME body sent with Content-Transfer-Encoding
+// cte and returns it in the form parsed MM4 parts are kept in: base64 for
+// media, which the transcoder decodes, and as sent for SMIL. Quoted-printable,
+// 7bit, 8bit and binary bodies are decoded and re-encoded. A body without the
+// header is taken to be base64, which is how MMSCs send media.
+func readTransferEncoded(cte, contentType string, r io.Reader) ([]byte, error) {
+	cte = strings.ToLower(strings.TrimSpace(cte))
+	switch cte {
+	case "quoted-printable":
+		r = quotedprintable.NewReader(r)
+	case "", "base64", "7bit", "8bit", "binary":
+	default:
+		return nil, fmt.Errorf("unsupported Content-Transfer-Encoding %q", cte)
+	}
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if cte == "" || cte == "base64" || strings.Contains(contentType, "application/smil") {
+		return content, nil
+	}
+	return []byte(base64.StdEncoding.EncodeToString(content)), nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testJPEG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 16, 16)), nil))
+	return buf.Bytes()
+}
+
+func TestResolvePartContentType_JPEGAsOctetStream(t *testing.T) {
+	got, overridden := resolvePartContentType("application/octet-stream", "", testJPEG(t))
+	assert.Equal(t, "image/jpeg", got)
+	assert.False(t, overridden, "filling in a generic type is not an override")
+
+	got, _ = resolvePartContentType("", "photo", testJPEG(t))
+	assert.Equal(t, "image/jpeg", got)
+}
+
+func TestResolvePartContentType_JPEGAsTextPlain(t *testing.T) {
+	got, overridden := resolvePartContentType("text/plain", "", testJPEG(t))
+	assert.Equal(t, "image/jpeg", got)
+	assert.True(t, overridden)
+
+	got, overridden = resolvePartContentType("text/plain; charset=utf-8", "photo.jpg", testJPEG(t))
+	assert.Equal(t, "image/jpeg", got)
+	assert.True(t, overridden)
+}
+
+func TestResolvePartContentType_KeepsConsistentTypes(t *testing.T) {
+	got, overridden := resolvePartContentType("text/plain; charset=utf-8", "text_0.txt", []byte("hello"))
+	assert.Equal(t, "text/plain; charset=utf-8", got)
+	assert.False(t, overridden)
+
+	// Matching declared type and extension is trusted without sniffing.
+	got, _ = resolvePartContentType("image/jpeg", "photo.jpg", []byte("not really a jpeg"))
+	assert.Equal(t, "image/jpeg", got)
+}
+
+func TestResolvePartContentType_ExtensionMismatch(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))))
+
+	got, overridden := resolvePartContentType("image/jpeg", "photo.png", buf.Bytes())
+	assert.Equal(t, "image/png", got)
+	assert.True(t, overridden)
+
+	// Unrecognisable content with a generic type falls back to the extension.
+	got, _ = resolvePartContentType("application/octet-stream", "clip.amr", []byte{0x00, 0x01, 0x02})
+	assert.Equal(t, "audio/amr", got)
+}
+
+func TestProcessAndConvertFiles_MislabeledJPEG(t *testing.T) {
+	_, gw := newTestRouter(1)
+	content := []byte(base64.StdEncoding.EncodeToString(testJPEG(t)))
+	m := &MM4Message{
+		TransactionID: "tx-1",
+		Files: []MsgFile{
+			{Filename: "photo.jpg", ContentType: "text/plain", Content: content},
+		},
+	}
+
+	files, _, err := m.processAndConvertFiles(gw.LogManager, loadTranscodeConfig(&envReader{}), "")
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, "image/jpeg", files[0].ContentType)
+}
+
+func TestParseMIMEParts_TransferEncodings(t *testing.T) {
+	jpg := testJPEG(t)
+	encoded := base64.StdEncoding.EncodeToString(jpg)
+	var wrapped strings.Builder
+	for len(encoded) > 76 {
+		wrapped.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	wrapped.WriteString(encoded)
+
+	body := "--b1\r\n" +
+		"Content-Type: application/smil\r\n" +
+		"Content-Transfer-Encoding: 7bit\r\n\r\n" +
+		"<smil><body/></smil>\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n\r\n" +
+		"Caf=C3=A9 at 5? This line is long enough that it is wrapped with a soft =\r\n" +
+		"line break.\r\n" +
+		"--b1\r\n" +
+		"Content-Type: image/jpeg; name=\"photo.jpg\"\r\n" +
+		"Content-Disposition: attachment; filename=\"photo.jpg\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n\r\n" +
+		wrapped.String() + "\r\n" +
+		"--b1--\r\n"
+	m := &MM4Message{
+		Headers: textproto.MIMEHeader{"Content-Type": {`multipart/related; boundary="b1"`}},
+		Content: []byte(body),
+	}
+
+	_, err := m.parseMIMEParts()
+	require.NoError(t, err)
+	require.Len(t, m.Files, 3)
+	assert.Equal(t, "<smil><body/></smil>", string(m.Files[0].Content), "SMIL is kept as sent")
+
+	files, _, err := m.passthroughFiles(1 << 20)
+	require.NoError(t, err)
+	assert.Equal(t, "Café at 5? This line is long enough that it is wrapped with a soft line break.", string(files[1].Content))
+	assert.Equal(t, "text/plain; charset=utf-8", files[1].ContentType)
+	assert.Equal(t, jpg, files[2].Content)
+	assert.Equal(t, "image/jpeg", baseMediaType(files[2].ContentType))
+	assert.Equal(t, "photo.jpg", files[2].Filename)
+}
+
+func TestParseMIMEParts_SinglePart7bit(t *testing.T) {
+	m := &MM4Message{
+		Headers: textproto.MIMEHeader{
+			"Content-Type":              {"text/plain"},
+			"Content-Transfer-Encoding": {"7bit"},
+		},
+		Content: []byte("test"),
+	}
+	_, err := m.parseMIMEParts()
+	require.NoError(t, err)
+	files, _, err := m.passthroughFiles(1 << 20)
+	require.NoError(t, err)
+	assert.Equal(t, "test", string(files[0].Content))
+
+	m = &MM4Message{
+		Headers: textproto.MIMEHeader{
+			"Content-Type":              {"text/plain"},
+			"Content-Transfer-Encoding": {"x-uuencode"},
+		},
+		Content: []byte("begin 644 a"),
+	}
+	_, err = m.parseMIMEParts()
+	require.Error(t, err)
+}
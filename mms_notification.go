@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Values of the mms_delivery client setting, which picks how MMS reaches an
+// MM4 client.
+const (
+	// MMSDeliveryInline sends the media inline in an MM4_forward.REQ.
+	MMSDeliveryInline = "inline"
+	// MMSDeliveryNotification stores the media and sends an MM4 message with
+	// an M-Notification.ind part pointing at it, plus the links as text.
+	MMSDeliveryNotification = "notification"
+	// MMSDeliveryLink stores the media and sends the text and the links as an
+	// SMS over the client's SMPP bind, for handsets that take no MMS at all.
+	MMSDeliveryLink = "link"
+)
+
+// mmsNotificationContentType is the content type of an MMS PDU such as
+// M-Notification.ind (OMA-MMS-ENC).
+const mmsNotificationContentType = "application/vnd.wap.mms-message"
+
+// validMMSDelivery reports whether mode is an MMS delivery mode, or empty.
+func validMMSDelivery(mode string) bool {
+	switch mode {
+	case "", MMSDeliveryInline, MMSDeliveryNotification, MMSDeliveryLink:
+		return true
+	}
+	return false
+}
+
+// mmsDeliveryMode returns how MMS is delivered to client.
+func mmsDeliveryMode(client *Client) string {
+	if client != nil && client.Settings != nil && client.Settings.MMSDelivery != "" {
+		return client.Settings.MMSDelivery
+	}
+	return MMSDeliveryInline
+}
+
+// mmsLinkText is the text sent in place of an MMS: its text, then one media
+// link per line.
+func mmsLinkText(message string, urls []string) string {
+	lines := make([]string, 0, len(urls)+1)
+	if message = strings.TrimSpace(message); message != "" {
+		lines = append(lines, message)
+	}
+	return strings.Join(append(lines, urls...), "\n")
+}
+
+// mediaSize returns the bytes of media in files, leaving out SMIL.
+func mediaSize(files []MsgFile) int {
+	size := 0
+	for _, f := range files {
+		if f.ContentType != "application/smil" {
+			size += len(f.Content)
+		}
+	}
+	return size
+}
+
+// mmsNotification holds the fields of an M-Notification.ind
+// (OMA-MMS-ENC 6.2) telling a handset where to retrieve a message.
+type mmsNotification struct {
+	TransactionID   string
+	From            string
+	Subject         string
+	Size            int
+	Expiry          time.Duration
+	ContentLocation string
+}
+
+// Header field codes and values of OMA-MMS-ENC 7.3 and 7.4, with the high
+// bit set as they are sent.
+const (
+	mmsContentLocation = 0x83
+	mmsExpiry          = 0x88
+	mmsFrom            = 0x89
+	mmsMessageClass    = 0x8A
+	mmsMessageType     = 0x8C
+	mmsVersion         = 0x8D
+	mmsMessageSize     = 0x8E
+	mmsSubject         = 0x96
+	mmsTransactionID   = 0x98
+
+	mmsNotificationInd = 0x82 // m-notification-ind
+	mmsVersion12       = 0x92 // 1.2
+	mmsClassPersonal   = 0x80
+	mmsAddressPresent  = 0x80
+	mmsRelativeToken   = 0x81
+	mmsCharsetUTF8     = 0xEA // MIBenum 106
+	mmsQuote           = 0x7F
+	mmsMaxShortLength  = 30
+	mmsLengthQuote     = 0x1F
+)
+
+// encode returns n as a binary M-Notification.ind PDU. Message type,
+// transaction ID and version come first, as the encoding requires.
+func (n mmsNotification) encode() []byte {
+	var b bytes.Buffer
+	b.Write([]byte{mmsMessageType, mmsNotificationInd})
+	b.WriteByte(mmsTransactionID)
+	writeMMSText(&b, n.TransactionID)
+	b.Write([]byte{mmsVersion, mmsVersion12})
+
+	if n.From != "" {
+		var from bytes.Buffer
+		from.WriteByte(mmsAddressPresent)
+		writeMMSText(&from, n.From+"/TYPE=PLMN")
+		b.WriteByte(mmsFrom)
+		writeMMSValue(&b, from.Bytes())
+	}
+	if n.Subject != "" {
+		b.WriteByte(mmsSubject)
+		writeMMSEncodedString(&b, n.Subject)
+	}
+	b.Write([]byte{mmsMessageClass, mmsClassPersonal})
+	b.WriteByte(mmsMessageSize)
+	writeMMSLongInt(&b, uint64(n.Size))
+
+	var expiry bytes.Buffer
+	expiry.WriteByte(mmsRelativeToken)
+	writeMMSLongInt(&expiry, uint64(n.Expiry/time.Second))
+	b.WriteByte(mmsExpiry)
+	writeMMSValue(&b, expiry.Bytes())
+
+	b.WriteByte(mmsContentLocation)
+	writeMMSText(&b, n.ContentLocation)
+	return b.Bytes()
+}
+
+// writeMMSText writes s as a null-terminated Text-string, quoted when its
+// first octet has the high bit set.
+func writeMMSText(b *bytes.Buffer, s string) {
+	if len(s) > 0 && s[0] >= 0x80 {
+		b.WriteByte(mmsQuote)
+	}
+	b.WriteString(s)
+	b.WriteByte(0)
+}
+
+// writeMMSEncodedString writes s as an Encoded-string-value, tagged UTF-8
+// when it is not plain ASCII.
+func writeMMSEncodedString(b *bytes.Buffer, s string) {
+	if utf8.ValidString(s) && len(s) == utf8.RuneCountInString(s) {
+		writeMMSText(b, s)
+		return
+	}
+	var value bytes.Buffer
+	value.WriteByte(mmsCharsetUTF8)
+	writeMMSText(&value, s)
+	writeMMSValue(b, value.Bytes())
+}
+
+// writeMMSValue writes value preceded by its Value-length.
+func writeMMSValue(b *bytes.Buffer, value []byte) {
+	if len(value) <= mmsMaxShortLength {
+		b.WriteByte(byte(len(value)))
+	} else {
+		b.WriteByte(mmsLengthQuote)
+		writeUintvar(b, uint64(len(value)))
+	}
+	b.Write(value)
+}
+
+// writeMMSLongInt writes v as a Long-integer: a short length, then the
+// fewest big-endian octets that hold v.
+func writeMMSLongInt(b *bytes.Buffer, v uint64) {
+	var octets []byte
+	for ; v > 0 || len(octets) == 0; v >>= 8 {
+		octets = append([]byte{byte(v)}, octets...)
+	}
+	b.WriteByte(byte(len(octets)))
+	b.Write(octets)
+}
+
+// writeUintvar writes v as a WSP variable-length unsigned integer: 7 bits
+// per octet, most significant first, the high bit marking continuation.
+func writeUintvar(b *bytes.Buffer, v uint64) {
+	octets := []byte{byte(v & 0x7F)}
+	for v >>= 7; v > 0; v >>= 7 {
+		octets = append([]byte{byte(v&0x7F) | 0x80}, octets...)
+	}
+	b.Write(octets)
+}
+
+// mmsNotificationFiles returns the parts sent in place of item's media: the
+// text with a link to each stored file, and an M-Notification.ind for the
+// first. A notification carries one content location, so handsets that
+// retrieve it get the first file and the text part lists them all.
+func mmsNotificationFiles(item MsgQueueItem, urls []string, expiry time.Duration) []MsgFile {
+	files := []MsgFile{{
+		Filename:    mm4TextFilename,
+		ContentType: "text/plain",
+		Content:     []byte(mmsLinkText(item.message, urls)),
+	}}
+	if len(urls) == 0 {
+		return files
+	}
+	notification := mmsNotification{
+		TransactionID:   item.LogID,
+		From:            item.From,
+		Subject:         item.Subject,
+		Size:            mediaSize(item.files),
+		Expiry:          expiry,
+		ContentLocation: urls[0],
+	}
+	return append(files, MsgFile{
+		Filename:    "notification.mms",
+		ContentType: mmsNotificationContentType,
+		Content:     notification.encode(),
+	})
+}
+
+// writeMM4NotificationBody writes the session's notification parts to w as a
+// multipart/mixed message with no SMIL part, since there is no media to lay
+// out. Like writeMM4Body, the output is not dot-stuffed.
+func (s *Session) writeMM4NotificationBody(w io.Writer, boundary string, contentID func() string) error {
+	var header bytes.Buffer
+	s.writeMM4Headers(&header, fmt.Sprintf("multipart/mixed; boundary=\"%s\"", boundary))
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+
+	for _, file := range s.Files {
+		header.Reset()
+		header.WriteString(fmt.Sprintf("--%s\r\n", boundary))
+		header.WriteString(fmt.Sprintf("Content-Id: <%s>\r\n", contentID()))
+		header.WriteString(fmt.Sprintf("Content-Type: %s; name=\"%s\"\r\n", file.ContentType, file.Filename))
+		header.WriteString(fmt.Sprintf("Content-Disposition: inline; filename=\"%s\"\r\n", file.Filename))
+		header.WriteString("Content-Transfer-Encoding: base64\r\n")
+		header.WriteString("\r\n")
+		if _, err := w.Write(header.Bytes()); err != nil {
+			return err
+		}
+		if err := writeBase64Lines(w, file.Content); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "--%s--\r\n", boundary)
+	return err
+}
+
+// sendMMSLink delivers item to client as an SMS over its SMPP bind, with
+// links to the stored media in place of the media itself.
+func (gateway *Gateway) sendMMSLink(item MsgQueueItem, client *Client) error {
+	session, err := gateway.SMPPServer.getSessionByUsername(client.Username)
+	if err != nil {
+		return fmt.Errorf("no SMPP session for MMS link delivery: %w", err)
+	}
+	if session == nil {
+		return fmt.Errorf("no SMPP session for MMS link delivery")
+	}
+
+	urls, err := gateway.uploadMediaGetUrls(&item)
+	if err != nil {
+		return fmt.Errorf("storing media: %w", err)
+	}
+
+	sms := item
+	sms.Type = MsgQueueItemType.SMS
+	sms.message = mmsLinkText(item.message, urls)
+	sms.files = nil
+	_, err = gateway.SMPPServer.sendSMPP(sms, session)
+	return err
+}
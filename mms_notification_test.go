@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMMSDeliveryMode(t *testing.T) {
+	assert.Equal(t, MMSDeliveryInline, mmsDeliveryMode(nil))
+	assert.Equal(t, MMSDeliveryInline, mmsDeliveryMode(&Client{Settings: &ClientSettings{}}))
+	assert.Equal(t, MMSDeliveryLink, mmsDeliveryMode(&Client{Settings: &ClientSettings{MMSDelivery: MMSDeliveryLink}}))
+	assert.True(t, validMMSDelivery(""))
+	assert.True(t, validMMSDelivery(MMSDeliveryNotification))
+	assert.False(t, validMMSDelivery("wap"))
+}
+
+func TestMMSLinkText(t *testing.T) {
+	urls := []string{"https://gw.example.com/media/a.jpg", "https://gw.example.com/media/b.mp4"}
+	assert.Equal(t, "look\nhttps://gw.example.com/media/a.jpg\nhttps://gw.example.com/media/b.mp4", mmsLinkText(" look\n", urls))
+	assert.Equal(t, urls[0], mmsLinkText("", urls[:1]))
+}
+
+func TestMMSNotification_Encode(t *testing.T) {
+	n := mmsNotification{
+		TransactionID:   "tx1",
+		From:            "+15551234567",
+		Size:            1000,
+		Expiry:          48 * time.Hour,
+		ContentLocation: "http://gw/media/abc.jpg",
+	}
+
+	var want []byte
+	want = append(want, 0x8C, 0x82)
+	want = append(want, 0x98)
+	want = append(want, "tx1\x00"...)
+	want = append(want, 0x8D, 0x92)
+	want = append(want, 0x89, 24, 0x80)
+	want = append(want, "+15551234567/TYPE=PLMN\x00"...)
+	want = append(want, 0x8A, 0x80)
+	want = append(want, 0x8E, 0x02, 0x03, 0xE8)
+	want = append(want, 0x88, 0x05, 0x81, 0x03, 0x02, 0xA3, 0x00) // 172800 seconds
+	want = append(want, 0x83)
+	want = append(want, "http://gw/media/abc.jpg\x00"...)
+
+	assert.Equal(t, want, n.encode())
+}
+
+func TestMMSNotification_EncodeSubject(t *testing.T) {
+	var b bytes.Buffer
+	writeMMSEncodedString(&b, "hi")
+	assert.Equal(t, []byte("hi\x00"), b.Bytes())
+
+	b.Reset()
+	writeMMSEncodedString(&b, "día")
+	assert.Equal(t, append([]byte{0x06, 0xEA}, "día\x00"...), b.Bytes(), "non-ASCII subjects are tagged UTF-8")
+
+	b.Reset()
+	writeMMSValue(&b, make([]byte, 200))
+	assert.Equal(t, []byte{0x1F, 0x81, 0x48}, b.Bytes()[:3], "lengths over 30 are quoted uintvars")
+}
+
+func TestMMSNotificationFiles(t *testing.T) {
+	item := MsgQueueItem{
+		From:    "+15551234567",
+		To:      "+15557654321",
+		LogID:   "log-1",
+		message: "see this",
+		files: []MsgFile{
+			{Filename: "0.smil", ContentType: "application/smil", Content: []byte("<smil/>")},
+			{Filename: "photo.jpg", ContentType: "image/jpeg", Content: make([]byte, 2048)},
+			{Filename: "clip.mp4", ContentType: "video/mp4", Content: make([]byte, 4096)},
+		},
+	}
+	urls := []string{"https://gw.example.com/media/a.jpg", "https://gw.example.com/media/b.mp4"}
+
+	files := mmsNotificationFiles(item, urls, time.Hour)
+	require.Len(t, files, 2)
+	assert.Equal(t, "text/plain", files[0].ContentType)
+	assert.Equal(t, mmsLinkText(item.message, urls), string(files[0].Content))
+
+	assert.Equal(t, mmsNotificationContentType, files[1].ContentType)
+	want := mmsNotification{
+		TransactionID:   "log-1",
+		From:            "+15551234567",
+		Size:            2048 + 4096,
+		Expiry:          time.Hour,
+		ContentLocation: urls[0],
+	}
+	assert.Equal(t, want.encode(), files[1].Content)
+
+	files = mmsNotificationFiles(MsgQueueItem{message: "no media"}, nil, time.Hour)
+	require.Len(t, files, 1, "nothing to notify without stored media")
+}
+
+func TestWriteMM4NotificationBody(t *testing.T) {
+	item := MsgQueueItem{From: "+15551234567", LogID: "log-1", message: "hi",
+		files: []MsgFile{{Filename: "photo.jpg", ContentType: "image/jpeg", Content: []byte{1, 2, 3}}}}
+	s := &Session{
+		Server:       &MM4Server{},
+		From:         "+15551234567/TYPE=PLMN",
+		To:           []string{"+15557654321/TYPE=PLMN"},
+		Headers:      textproto.MIMEHeader{"X-Mms-Message-Type": {MM4ForwardReq}},
+		Files:        mmsNotificationFiles(item, []string{"https://gw.example.com/media/a.jpg"}, time.Hour),
+		Notification: true,
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, s.writeMM4NotificationBody(&out, "=====n", func() string { return "part" }))
+
+	body := bufio.NewReader(&out)
+	msg, err := textproto.NewReader(body).ReadMIMEHeader()
+	require.NoError(t, err)
+	mediaType, params, err := mime.ParseMediaType(msg.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/mixed", mediaType)
+
+	var types []string
+	var parts [][]byte
+	reader := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		mediaType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		types = append(types, mediaType)
+		content, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+		require.NoError(t, err)
+		parts = append(parts, content)
+	}
+	assert.Equal(t, []string{"text/plain", mmsNotificationContentType}, types, "no SMIL and no media inline")
+	assert.True(t, strings.HasSuffix(string(parts[0]), "https://gw.example.com/media/a.jpg"))
+	assert.Equal(t, s.Files[1].Content, parts[1])
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// PendingMMS statuses.
+const (
+	PendingMMSStatusPending   = "pending"
+	PendingMMSStatusReplaying = "replaying"
+	PendingMMSStatusFailed    = "failed"
+)
+
+// PendingMMS is an MMS that exhausted its in-memory retries. It is kept in
+// the database so it survives a restart and can be replayed by the sweeper.
+// Media is stored as MediaFile rows and referenced by access token.
+type PendingMMS struct {
+	ID                uint      `gorm:"primaryKey" json:"id"`
+	ServerID          string    `gorm:"index" json:"server_id"`
+	Status            string    `gorm:"index;default:'pending'" json:"status"`
+	Origin            string    `json:"origin"` // "client" or "carrier"
+	LogID             string    `gorm:"index" json:"log_id"`
+	TraceID           string    `json:"trace_id"`
+	To                string    `json:"to"`
+	From              string    `json:"from"`
+	Message           string    `gorm:"type:text" json:"message,omitempty"`
+	Subject           string    `json:"subject,omitempty"`
+	SourceCarrier     string    `json:"source_carrier,omitempty"`
+	SourceIP          string    `json:"source_ip,omitempty"`
+	OriginalSizeBytes int       `json:"original_size_bytes"`
+	MediaJSON         string    `gorm:"column:media;type:text" json:"-"` // JSON []PendingMMSMedia
+	Error             string    `json:"error,omitempty"`
+	RetryCount        int       `json:"retry_count"` // number of replays attempted
+	ReceivedAt        time.Time `json:"received_at"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// PendingMMSMedia references one attachment of a PendingMMS.
+type PendingMMSMedia struct {
+	AccessToken string `json:"access_token"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+}
+
+// defaultMMSReplayMaxRetries is used when MMS_REPLAY_MAX_RETRIES is unset.
+const defaultMMSReplayMaxRetries = 5
+
+// mmsReplayMaxRetries is how many times a persisted MMS is replayed before it
+// is marked failed.
+func (c *GatewayConfig) mmsReplayMaxRetries() int {
+	if c.MMSReplayMaxRetries > 0 {
+		return c.MMSReplayMaxRetries
+	}
+	return defaultMMSReplayMaxRetries
+}
+
+// EnqueueMMS persists an undelivered MMS for later replay. If the message was
+// itself a replay, the existing row is returned to pending instead.
+func (gateway *Gateway) EnqueueMMS(msg *MsgQueueItem, origin string, reason string) error {
+	if msg.PendingMMSID != 0 {
+		return gateway.IncrementRetryCount(msg.PendingMMSID, reason)
+	}
+
+	media := make([]PendingMMSMedia, 0, len(msg.files))
+	for _, f := range msg.files {
+		if len(f.Base64Data) == 0 && len(f.Content) > 0 {
+			f.Base64Data = base64.StdEncoding.EncodeToString(f.Content)
+		}
+		token, err := gateway.saveMsgFileMedia(f)
+		if err != nil {
+			return fmt.Errorf("failed to store media for pending MMS: %v", err)
+		}
+		media = append(media, PendingMMSMedia{
+			AccessToken: token,
+			Filename:    f.Filename,
+			ContentType: f.ContentType,
+		})
+	}
+
+	mediaJSON, err := json.Marshal(media)
+	if err != nil {
+		return err
+	}
+
+	pending := PendingMMS{
+		ServerID:          gateway.ServerID,
+		Status:            PendingMMSStatusPending,
+		Origin:            origin,
+		LogID:             msg.LogID,
+		TraceID:           msg.TraceID,
+		To:                msg.To,
+		From:              msg.From,
+		Message:           msg.message,
+		Subject:           msg.Subject,
+		SourceCarrier:     msg.SourceCarrier,
+		SourceIP:          msg.SourceIP,
+		OriginalSizeBytes: msg.OriginalSizeBytes,
+		MediaJSON:         string(mediaJSON),
+		Error:             reason,
+		ReceivedAt:        msg.ReceivedTimestamp,
+	}
+	return gateway.DB.Create(&pending).Error
+}
+
+// DequeueMMS claims pending MMS rows for serverID, marking them as replaying.
+func (gateway *Gateway) DequeueMMS(serverID string, limit int) ([]PendingMMS, error) {
+	var items []PendingMMS
+	err := gateway.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("server_id = ? AND status = ?", serverID, PendingMMSStatusPending).
+			Order("id").Limit(limit).Find(&items).Error; err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			return nil
+		}
+		ids := make([]uint, len(items))
+		for i, item := range items {
+			ids[i] = item.ID
+		}
+		return tx.Model(&PendingMMS{}).Where("id IN ?", ids).
+			Update("status", PendingMMSStatusReplaying).Error
+	})
+	return items, err
+}
+
+// IncrementRetryCount records a failed replay and returns the row to pending,
+// or marks it failed once MMS_REPLAY_MAX_RETRIES is reached.
+func (gateway *Gateway) IncrementRetryCount(id uint, reason string) error {
+	var pending PendingMMS
+	if err := gateway.DB.First(&pending, id).Error; err != nil {
+		return err
+	}
+	pending.RetryCount++
+	pending.Error = reason
+	pending.Status = PendingMMSStatusPending
+	if pending.RetryCount >= gateway.Config.mmsReplayMaxRetries() {
+		pending.Status = PendingMMSStatusFailed
+	}
+	return gateway.DB.Save(&pending).Error
+}
+
+// completePendingMMS removes the persisted copy once a replayed MMS is delivered.
+func (gateway *Gateway) completePendingMMS(msg *MsgQueueItem) {
+	if msg.PendingMMSID == 0 || gateway.DB == nil {
+		return
+	}
+	if err := gateway.DB.Delete(&PendingMMS{}, msg.PendingMMSID).Error; err != nil {
+		lm := gateway.LogManager
+		lm.SendLog(lm.BuildLog(
+			"Router.MMS.Persist",
+			"DeletePendingError",
+			logrus.ErrorLevel,
+			map[string]interface{}{
+				"logID":        msg.LogID,
+				"traceID":      msg.TraceID,
+				"pendingMMSID": msg.PendingMMSID,
+			}, err,
+		))
+	}
+}
+
+// persistUndeliveredMMS stores an MMS whose retries are exhausted.
+func (router *Router) persistUndeliveredMMS(m *MsgQueueItem, origin string, reason string) {
+	lm := router.gateway.LogManager
+	if err := router.gateway.EnqueueMMS(m, origin, reason); err != nil {
+		lm.SendLog(lm.BuildLog(
+			"Router.MMS.Persist",
+			"PersistError",
+			logrus.ErrorLevel,
+			map[string]interface{}{
+				"logID":   m.LogID,
+				"traceID": m.TraceID,
+				"to":      m.To,
+				"from":    m.From,
+			}, err,
+		))
+		return
+	}
+	lm.SendLog(lm.BuildLog(
+		"Router.MMS.Persist",
+		"PersistedForReplay",
+		logrus.WarnLevel,
+		map[string]interface{}{
+			"logID":        m.LogID,
+			"traceID":      m.TraceID,
+			"to":           m.To,
+			"from":         m.From,
+			"reason":       reason,
+			"pendingMMSID": m.PendingMMSID,
+		},
+	))
+}
+
+// toMsgQueueItem rebuilds the queue item, loading media from storage.
+func (p *PendingMMS) toMsgQueueItem(gateway *Gateway) (*MsgQueueItem, error) {
+	var media []PendingMMSMedia
+	if p.MediaJSON != "" {
+		if err := json.Unmarshal([]byte(p.MediaJSON), &media); err != nil {
+			return nil, err
+		}
+	}
+
+	files := make([]MsgFile, 0, len(media))
+	for _, m := range media {
+		mediaFile, err := gateway.getMediaFileByToken(m.AccessToken)
+		if err != nil {
+			return nil, err
+		}
+		content, err := base64.StdEncoding.DecodeString(mediaFile.Base64Data)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, MsgFile{
+			Filename:    m.Filename,
+			ContentType: m.ContentType,
+			Content:     content,
+			Base64Data:  mediaFile.Base64Data,
+		})
+	}
+
+	return &MsgQueueItem{
+		To:                p.To,
+		From:              p.From,
+		ReceivedTimestamp: p.ReceivedAt,
+		QueuedTimestamp:   time.Now(),
+		Type:              MsgQueueItemType.MMS,
+		files:             files,
+		message:           p.Message,
+		Subject:           p.Subject,
+		LogID:             p.LogID,
+		TraceID:           p.TraceID,
+		SourceCarrier:     p.SourceCarrier,
+		SourceIP:          p.SourceIP,
+		OriginalSizeBytes: p.OriginalSizeBytes,
+		PendingMMSID:      p.ID,
+	}, nil
+}
+
+// replayPendingMMS re-enqueues persisted MMS for this SERVER_ID at startup and
+// then on every interval.
+func (gateway *Gateway) replayPendingMMS(interval time.Duration) {
+	lm := gateway.LogManager
+
+	// Anything left "replaying" was in flight when the process stopped.
+	if err := gateway.DB.Model(&PendingMMS{}).
+		Where("server_id = ? AND status = ?", gateway.ServerID, PendingMMSStatusReplaying).
+		Update("status", PendingMMSStatusPending).Error; err != nil {
+		lm.SendLog(lm.BuildLog(
+			"Router.MMS.Replay",
+			"ResetReplayingError",
+			logrus.ErrorLevel,
+			map[string]interface{}{
+				"serverID": gateway.ServerID,
+			}, err,
+		))
+	}
+
+	gateway.sweepPendingMMS()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		gateway.sweepPendingMMS()
+	}
+}
+
+func (gateway *Gateway) sweepPendingMMS() {
+	lm := gateway.LogManager
+
+	items, err := gateway.DequeueMMS(gateway.ServerID, 100)
+	if err != nil {
+		lm.SendLog(lm.BuildLog(
+			"Router.MMS.Replay",
+			"DequeueError",
+			logrus.ErrorLevel,
+			map[string]interface{}{
+				"serverID": gateway.ServerID,
+			}, err,
+		))
+		return
+	}
+
+	for _, pending := range items {
+		msg, err := pending.toMsgQueueItem(gateway)
+		if err != nil {
+			lm.SendLog(lm.BuildLog(
+				"Router.MMS.Replay",
+				"LoadError",
+				logrus.ErrorLevel,
+				map[string]interface{}{
+					"logID":        pending.LogID,
+					"traceID":      pending.TraceID,
+					"pendingMMSID": pending.ID,
+				}, err,
+			))
+			gateway.DB.Model(&PendingMMS{}).Where("id = ?", pending.ID).Updates(map[string]interface{}{
+				"status": PendingMMSStatusFailed,
+				"error":  err.Error(),
+			})
+			continue
+		}
+
+		lm.SendLog(lm.BuildLog(
+			"Router.MMS.Replay",
+			"Replaying",
+			logrus.InfoLevel,
+			map[string]interface{}{
+				"logID":        pending.LogID,
+				"traceID":      pending.TraceID,
+				"pendingMMSID": pending.ID,
+				"retryCount":   pending.RetryCount,
+				"mediaCount":   len(msg.files),
+			},
+		))
+
+		if pending.Origin == "client" {
+			msg.Priority = gateway.msgPriorityFor(msg.From, msg.Type)
+			convoID := computeCorrelationKey(msg.From, msg.To)
+			gateway.ConvoManager.AddMessage(convoID, *msg, gateway.Router)
+		} else {
+			msg.Priority = gateway.msgPriorityFor(msg.To, msg.Type)
+			gateway.Router.carrierQueue(msg.Priority) <- *msg
+		}
+	}
+}
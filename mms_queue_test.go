@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMMSReplayMaxRetries(t *testing.T) {
+	assert.Equal(t, 5, (&GatewayConfig{}).mmsReplayMaxRetries())
+
+	t.Setenv("ENCRYPTION_KEY", "test-key")
+	t.Setenv("MMS_REPLAY_MAX_RETRIES", "2")
+	config := mustLoadGatewayConfig(t)
+	assert.Equal(t, 2, config.mmsReplayMaxRetries())
+
+	t.Setenv("MMS_REPLAY_MAX_RETRIES", "-1")
+	_, err := loadGatewayConfig()
+	assert.ErrorContains(t, err, `MMS_REPLAY_MAX_RETRIES="-1"`)
+}
+
+func TestPendingMMS_ToMsgQueueItemWithoutMedia(t *testing.T) {
+	received := time.Now().Add(-time.Hour)
+	p := PendingMMS{
+		ID:            42,
+		LogID:         "mms-1",
+		TraceID:       "trace-1",
+		To:            "15551230000",
+		From:          "15559870000",
+		Message:       "hello",
+		SourceCarrier: "twilio",
+		ReceivedAt:    received,
+	}
+
+	msg, err := p.toMsgQueueItem(nil)
+	require.NoError(t, err)
+	assert.Equal(t, MsgQueueItemType.MMS, msg.Type)
+	assert.Equal(t, uint(42), msg.PendingMMSID)
+	assert.Equal(t, "mms-1", msg.LogID)
+	assert.Equal(t, "trace-1", msg.TraceID)
+	assert.Equal(t, "hello", msg.message)
+	assert.Equal(t, "twilio", msg.SourceCarrier)
+	assert.Equal(t, received, msg.ReceivedTimestamp)
+	assert.Empty(t, msg.files)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MM4 message types (3GPP TS 23.140, X-Mms-Message-Type).
+const (
+	MM4ForwardReq        = "MM4_forward.REQ"
+	MM4ForwardRes        = "MM4_forward.RES"
+	MM4DeliveryReportReq = "MM4_delivery_report.REQ"
+	MM4DeliveryReportRes = "MM4_delivery_report.RES"
+	MM4ReadReplyReq      = "MM4_read_reply_report.REQ"
+	MM4ReadReplyRes      = "MM4_read_reply_report.RES"
+)
+
+// mm4LogIDFromMessageID recovers our log ID from an X-Mms-Message-ID such as
+// "<abc123@mms.example.com>", as set by createMM4Message.
+func mm4LogIDFromMessageID(messageID string) string {
+	id := strings.Trim(strings.TrimSpace(messageID), "\"<>")
+	if i := strings.Index(id, "@"); i >= 0 {
+		id = id[:i]
+	}
+	return id
+}
+
+// mm4DeliveryStatus maps an X-Mms-MM-Status-Code from a delivery report to a
+// message status. Interim codes (Deferred, Forwarded, Indeterminate) return "".
+func mm4DeliveryStatus(code string) string {
+	switch strings.ToLower(strings.TrimSpace(code)) {
+	case "retrieved":
+		return MsgStatusDelivered
+	case "rejected", "expired", "unrecognised", "unrecognized":
+		return MsgStatusFailed
+	}
+	return ""
+}
+
+// mm4AckRequested reports whether the peer asked for a .RES to a request.
+func mm4AckRequested(headers textproto.MIMEHeader) bool {
+	return strings.EqualFold(strings.TrimSpace(headers.Get("X-Mms-Ack-Request")), "Yes")
+}
+
+// mm4ResponseHeaders builds the headers of the .RES answering req, sent from
+// the system address sender.
+func mm4ResponseHeaders(req textproto.MIMEHeader, resType, sender string) textproto.MIMEHeader {
+	version := req.Get("X-Mms-3GPP-MMS-Version")
+	if version == "" {
+		version = "6.10.0"
+	}
+	headers := textproto.MIMEHeader{}
+	headers.Set("X-Mms-3GPP-MMS-Version", version)
+	headers.Set("X-Mms-Message-Type", resType)
+	headers.Set("X-Mms-Transaction-ID", req.Get("X-Mms-Transaction-ID"))
+	headers.Set("X-Mms-Message-ID", req.Get("X-Mms-Message-ID"))
+	headers.Set("X-Mms-Request-Status-Code", "Ok")
+	headers.Set("Sender", sender)
+	if originator := req.Get("X-Mms-Originator-System"); originator != "" {
+		headers.Set("To", originator)
+	}
+	headers.Set("Date", time.Now().UTC().Format(time.RFC1123Z))
+	return headers
+}
+
+// handleForwardRes handles the peer's answer to an MM4_forward.REQ we sent.
+// A non-Ok status marks the message failed for this client.
+func (s *Session) handleForwardRes() error {
+	status := strings.TrimSpace(s.Headers.Get("X-Mms-Request-Status-Code"))
+	logID := mm4LogIDFromMessageID(s.Headers.Get("X-Mms-Message-ID"))
+
+	s.debugLog("MM4ForwardRes", map[string]interface{}{
+		"log_id":      logID,
+		"status_code": status,
+		"status_text": s.Headers.Get("X-Mms-Status-Text"),
+	})
+
+	if status != "" && !strings.EqualFold(status, "Ok") {
+		s.recordMM4Status(logID, MsgStatusFailed, fmt.Sprintf("MM4_forward.RES %s", status))
+	}
+	return nil
+}
+
+// handleDeliveryReportReq records the final delivery status the peer reports
+// for a message we forwarded, and answers with MM4_delivery_report.RES.
+func (s *Session) handleDeliveryReportReq() error {
+	code := s.Headers.Get("X-Mms-MM-Status-Code")
+	logID := mm4LogIDFromMessageID(s.Headers.Get("X-Mms-Message-ID"))
+	status := mm4DeliveryStatus(code)
+
+	s.debugLog("MM4DeliveryReport", map[string]interface{}{
+		"log_id":      logID,
+		"status_code": code,
+		"status":      status,
+	})
+
+	if status != "" {
+		errMsg := ""
+		if status == MsgStatusFailed {
+			errMsg = fmt.Sprintf("MM4 delivery report %s", strings.TrimSpace(code))
+		}
+		s.recordMM4Status(logID, status, errMsg)
+	}
+
+	s.sendMM4Ack(MM4DeliveryReportRes)
+	return nil
+}
+
+// handleReadReplyReq logs a read-reply report and answers with
+// MM4_read_reply_report.RES.
+func (s *Session) handleReadReplyReq() error {
+	s.debugLog("MM4ReadReplyReport", map[string]interface{}{
+		"log_id":      mm4LogIDFromMessageID(s.Headers.Get("X-Mms-Message-ID")),
+		"read_status": s.Headers.Get("X-Mms-Read-Status"),
+	})
+
+	s.sendMM4Ack(MM4ReadReplyRes)
+	return nil
+}
+
+// recordMM4Status updates the status of the message this session's client
+// received as logID.
+func (s *Session) recordMM4Status(logID, status, errMsg string) {
+	gateway := s.Server.gateway
+	if logID == "" || s.Client == nil || gateway.MsgRecordChan == nil {
+		return
+	}
+	gateway.MsgRecordChan <- MsgRecord{
+		MsgQueueItem:   MsgQueueItem{LogID: logID, Type: MsgQueueItemType.MMS},
+		ClientID:       s.Client.ID,
+		Direction:      "inbound",
+		DeliveryMethod: "mm4",
+		Status:         status,
+		Error:          errMsg,
+	}
+}
+
+// sendMM4Ack sends resType back to the peer when the request asked for an
+// acknowledgement. The .RES goes out as its own SMTP transaction, after the
+// current one has been accepted.
+func (s *Session) sendMM4Ack(resType string) {
+	if !mm4AckRequested(s.Headers) {
+		return
+	}
+
+	rcpt := ""
+	if originator, ok := mm4SystemAddress(s.Headers.Get("X-Mms-Originator-System")); ok {
+		rcpt = originator
+	} else if strings.Contains(s.From, "@") {
+		rcpt = s.From
+	}
+
+	lm := s.Server.gateway.LogManager
+	if rcpt == "" || s.Client == nil {
+		lm.SendLog(lm.BuildLog(
+			"Server.MM4.Response",
+			"NoResponseAddress",
+			logrus.WarnLevel,
+			map[string]interface{}{
+				"client":   safeClientUsername(s.Client),
+				"ip":       s.ClientIP,
+				"res_type": resType,
+			},
+		))
+		return
+	}
+
+	headers := mm4ResponseHeaders(s.Headers, resType, s.Server.gateway.Config.mm4OriginatorSystem())
+	client := s.Client
+	go func() {
+		if err := s.Server.sendMM4Response(client, rcpt, headers); err != nil {
+			lm.SendLog(lm.BuildLog(
+				"Server.MM4.Response",
+				"SendFailed",
+				logrus.ErrorLevel,
+				map[string]interface{}{
+					"client":   client.Username,
+					"rcpt":     rcpt,
+					"res_type": resType,
+				},
+				err,
+			))
+		}
+	}()
+}
+
+// sendMM4Response delivers a header-only MM4 .RES to a client's MM4 server.
+func (s *MM4Server) sendMM4Response(client *Client, rcpt string, headers textproto.MIMEHeader) error {
+	session, address, err := s.openMM4Session(client)
+	if err != nil {
+		return err
+	}
+	defer session.Conn.Close()
+
+	session.From = s.gateway.Config.mm4OriginatorSystem()
+	session.To = []string{rcpt}
+	session.Headers = headers
+
+	if err := session.startMM4Data(); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var msg strings.Builder
+	for _, k := range keys {
+		for _, v := range headers[k] {
+			msg.WriteString(fmt.Sprintf("%s: %s\r\n", k, v))
+		}
+	}
+	msg.WriteString("\r\n.\r\n")
+
+	if err := session.finishMM4Data(msg.String()); err != nil {
+		return err
+	}
+	return session.quitMM4Session(address)
+}
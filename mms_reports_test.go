@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/textproto"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newReportSession(t *testing.T, msgType string) (*Session, chan MsgRecord) {
+	t.Helper()
+	_, gw := newTestRouter(1)
+	records := make(chan MsgRecord, 1)
+	gw.MsgRecordChan = records
+
+	s := &Session{
+		Server: &MM4Server{gateway: gw},
+		Client: &Client{ID: 7, Username: "pbx1"},
+		From:   "mms@pbx.example.com",
+		To:     []string{"system@yourdomain.com"},
+		Headers: textproto.MIMEHeader{
+			"X-Mms-3gpp-Mms-Version": {"6.10.0"},
+			"X-Mms-Message-Type":     {msgType},
+			"X-Mms-Transaction-Id":   {"tx-1"},
+			"X-Mms-Message-Id":       {"<abc123@mms.example.com>"},
+		},
+	}
+	return s, records
+}
+
+func TestMM4DeliveryStatus(t *testing.T) {
+	assert.Equal(t, MsgStatusDelivered, mm4DeliveryStatus("Retrieved"))
+	assert.Equal(t, MsgStatusFailed, mm4DeliveryStatus("Rejected"))
+	assert.Equal(t, MsgStatusFailed, mm4DeliveryStatus(" expired "))
+	assert.Equal(t, MsgStatusFailed, mm4DeliveryStatus("Unrecognised"))
+	assert.Empty(t, mm4DeliveryStatus("Deferred"))
+	assert.Empty(t, mm4DeliveryStatus("Forwarded"))
+
+	assert.Equal(t, "abc123", mm4LogIDFromMessageID(`"<abc123@mms.example.com>"`))
+	assert.Equal(t, "abc123", mm4LogIDFromMessageID("abc123"))
+}
+
+func TestMM4ResponseHeaders(t *testing.T) {
+	req := textproto.MIMEHeader{}
+	req.Set("X-Mms-3GPP-MMS-Version", "5.0.0")
+	req.Set("X-Mms-Transaction-ID", "tx-9")
+	req.Set("X-Mms-Message-ID", "<m9@pbx.example.com>")
+	req.Set("X-Mms-Originator-System", "mms@pbx.example.com")
+
+	h := mm4ResponseHeaders(req, MM4DeliveryReportRes, "system@gw.example.com")
+	assert.Equal(t, "5.0.0", h.Get("X-Mms-3GPP-MMS-Version"))
+	assert.Equal(t, MM4DeliveryReportRes, h.Get("X-Mms-Message-Type"))
+	assert.Equal(t, "tx-9", h.Get("X-Mms-Transaction-ID"))
+	assert.Equal(t, "<m9@pbx.example.com>", h.Get("X-Mms-Message-ID"))
+	assert.Equal(t, "Ok", h.Get("X-Mms-Request-Status-Code"))
+	assert.Equal(t, "system@gw.example.com", h.Get("Sender"))
+	assert.Equal(t, "mms@pbx.example.com", h.Get("To"))
+}
+
+func TestSession_DeliveryReportRecordsStatus(t *testing.T) {
+	s, records := newReportSession(t, "mm4_delivery_report.req")
+	s.Headers.Set("X-Mms-MM-Status-Code", "Retrieved")
+
+	require.NoError(t, s.handleMM4Message(), "reports carry no body")
+
+	rec := <-records
+	assert.Equal(t, "abc123", rec.MsgQueueItem.LogID)
+	assert.Equal(t, uint(7), rec.ClientID)
+	assert.Equal(t, "inbound", rec.Direction)
+	assert.Equal(t, MsgStatusDelivered, rec.Status)
+}
+
+func TestSession_ForwardResRecordsFailure(t *testing.T) {
+	s, records := newReportSession(t, MM4ForwardRes)
+	s.Headers.Set("X-Mms-Request-Status-Code", "Ok")
+	require.NoError(t, s.handleMM4Message())
+	assert.Empty(t, records, "an Ok response changes nothing")
+
+	s.Headers.Set("X-Mms-Request-Status-Code", "Error-service-denied")
+	require.NoError(t, s.handleMM4Message())
+	rec := <-records
+	assert.Equal(t, MsgStatusFailed, rec.Status)
+	assert.Contains(t, rec.Error, "Error-service-denied")
+}
+
+func TestSession_HandleMM4MessageTypes(t *testing.T) {
+	s, _ := newReportSession(t, MM4ReadReplyReq)
+	s.Headers.Set("X-Mms-Read-Status", "Read")
+	assert.NoError(t, s.handleMM4Message())
+
+	s.Headers.Set("X-Mms-Message-Type", "MM4_something_new.REQ")
+	assert.NoError(t, s.handleMM4Message(), "unknown types are accepted")
+
+	s.Headers.Set("X-Mms-Message-Type", MM4ForwardReq)
+	assert.EqualError(t, s.handleMM4Message(), "empty message body")
+
+	s.Data = []byte("body")
+	s.Headers.Set("From", "+15551234567/TYPE=PLMN")
+	s.Headers.Set("To", "+15557654321/TYPE=PLMN")
+	assert.Error(t, s.handleMM4Message(), "a forward needs number envelopes")
+
+	s.Headers.Del("X-Mms-Transaction-ID")
+	assert.EqualError(t, s.handleMM4Message(), "missing required header: X-Mms-Transaction-ID")
+}
+
+func TestSession_SystemEnvelope(t *testing.T) {
+	_, gw := newTestRouter(1)
+	gw.Config.MM4OriginatorSystem = "system@gw.example.com"
+	s := &Session{Server: &MM4Server{gateway: gw}}
+
+	require.NoError(t, s.handleMail("FROM:<mms@pbx.example.com> SIZE=512"))
+	assert.Equal(t, "mms@pbx.example.com", s.From)
+
+	assert.Error(t, s.handleRcpt("TO:<someone@gw.example.com>"))
+	require.NoError(t, s.handleRcpt("TO:<SYSTEM@gw.example.com>"))
+	assert.Len(t, s.To, 1)
+}
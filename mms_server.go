@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -19,7 +20,6 @@ import (
 	"mime/multipart"
 	"net"
 	"net/textproto"
-	"os"
 	"strconv"
 	"strings"
 	"sync"
@@ -29,6 +29,7 @@ import (
 type MM4Message struct {
 	From          string
 	To            string
+	Recipients    []string // every RCPT TO, deduplicated; To is the first
 	Content       []byte
 	Headers       textproto.MIMEHeader
 	Client        *Client
@@ -36,6 +37,8 @@ type MM4Message struct {
 	MessageID     string
 	Files         []MsgFile
 	TransactionID string
+	TraceID       string
+	ReceivedAt    time.Time // When the gateway accepted it; transcoding comes after
 }
 
 // MM4ClientState tracks connection state for a single MM4 client (by IP)
@@ -84,10 +87,17 @@ type MM4Server struct {
 	routing            *Router
 	mu                 sync.RWMutex
 	listener           net.Listener
+	closing            bool // set by stopListening; Accept errors are then expected
 	mongo              *mongo.Client
+	capture            *mm4CaptureStore           // nil unless MM4_CAPTURE is enabled
 	clientStates       map[string]*MM4ClientState // hashedIP -> client state
 	gateway            *Gateway
 	MediaTranscodeChan chan *MM4Message
+	TranscodeConfig    TranscodeConfig
+	SMILLayout         smilLayout   // root-layout for SMIL sent to MM4 clients
+	deliveryPort       string       // port of clients' MM4 servers; "" means 25
+	TLS                *tls.Config  // STARTTLS config; nil when MM4_TLS_CERT/KEY are unset
+	connLimits         *connLimiter // connections per client, for MAX_CONNS_*
 }
 
 // getOrCreateClientState returns the state for a client IP, creating if needed
@@ -120,6 +130,39 @@ func (s *MM4Server) cleanupClientState(hashedIP string) {
 	}
 }
 
+// isListening reports whether the MM4 listener has been bound.
+func (s *MM4Server) isListening() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.listener != nil
+}
+
+// stopListening closes the MM4 listener so no new connections are accepted.
+// Sessions already in progress are allowed to finish.
+func (s *MM4Server) stopListening() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.closing = true
+	listener := s.listener
+	s.listener = nil
+	s.mu.Unlock()
+
+	if listener != nil {
+		_ = listener.Close()
+	}
+}
+
+func (s *MM4Server) isClosing() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.closing
+}
+
 // generateSessionID creates a unique session identifier
 func generateSessionID() string {
 	return fmt.Sprintf("%d-%s", time.Now().UnixNano(), randomString(8))
@@ -128,19 +171,51 @@ func generateSessionID() string {
 // Start begins listening for incoming SMTP connections.
 func (s *MM4Server) Start() error {
 	s.clientStates = make(map[string]*MM4ClientState)
+	s.connLimits = newConnLimiter("mm4")
 	s.MediaTranscodeChan = make(chan *MM4Message)
+	s.TranscodeConfig = s.gateway.Config.Transcode
+	s.SMILLayout = s.gateway.Config.SMILLayout
+	s.startCapture()
 
-	go s.transcodeMedia()
+	s.transcodeMedia(s.TranscodeConfig.Workers)
 
 	lm := s.gateway.LogManager
+
+	tlsConfig, err := loadMM4TLSConfig(s.gateway.Config.MM4TLSCert, s.gateway.Config.MM4TLSKey)
+	if err != nil {
+		lm.SendLog(lm.BuildLog(
+			"Server.MM4.Start",
+			"MM4TLSConfigError",
+			logrus.ErrorLevel,
+			nil,
+			err,
+		))
+		return err
+	}
+	s.TLS = tlsConfig
+	lm.SendLog(lm.BuildLog(
+		"Server.MM4.Start",
+		"TranscodeLimits",
+		logrus.InfoLevel,
+		map[string]interface{}{
+			"max_image_bytes": s.TranscodeConfig.MaxImageBytes,
+			"max_video_bytes": s.TranscodeConfig.MaxVideoBytes,
+			"max_file_bytes":  s.TranscodeConfig.MaxFileBytes,
+			"max_input_bytes": maxInputSize,
+			"audio_codecs":    s.TranscodeConfig.AudioCodecs,
+			"smil_layout":     fmt.Sprintf("%dx%d", s.SMILLayout.Width, s.SMILLayout.Height),
+		},
+	))
 	lm.SendLog(lm.BuildLog(
 		"Server.MM4.Start",
 		"MM4ServerStarting",
 		logrus.InfoLevel,
 		map[string]interface{}{
 			"addr":            s.Addr,
-			"proxy_protocol":  os.Getenv("HAPROXY_PROXY_PROTOCOL"),
-			"mm4_debug":       os.Getenv("MM4_DEBUG"),
+			"hostname":        s.gateway.Config.mm4Hostname(),
+			"proxy_protocol":  s.gateway.Config.ProxyProtocol,
+			"mm4_debug":       s.gateway.Config.MM4Debug,
+			"starttls":        s.TLS != nil,
 			"connected_count": 0,
 		},
 	))
@@ -162,7 +237,7 @@ func (s *MM4Server) Start() error {
 
 	var proxyListener net.Listener
 
-	if os.Getenv("HAPROXY_PROXY_PROTOCOL") == "true" {
+	if s.gateway.Config.ProxyProtocol {
 		proxyListener = &proxyproto.Listener{Listener: listen}
 		defer proxyListener.Close()
 
@@ -196,7 +271,9 @@ func (s *MM4Server) Start() error {
 		proxyListener = listen
 	}
 
+	s.mu.Lock()
 	s.listener = proxyListener
+	s.mu.Unlock()
 
 	lm.SendLog(lm.BuildLog(
 		"Server.MM4.Start",
@@ -210,6 +287,9 @@ func (s *MM4Server) Start() error {
 	for {
 		conn, err := proxyListener.Accept()
 		if err != nil {
+			if s.isClosing() {
+				return nil
+			}
 			lm.SendLog(lm.BuildLog(
 				"Server.MM4.Start",
 				"AcceptError",
@@ -260,10 +340,10 @@ func (s *MM4Server) handleConnection(conn net.Conn) {
 	writer := bufio.NewWriter(conn)
 
 	// Send initial greeting
-	writeResponse(writer, "220 localhost SMTP server ready")
+	writeResponse(writer, fmt.Sprintf("220 %s SMTP server ready", s.gateway.Config.mm4Hostname()))
 
 	// Identify the client based on the IP address
-	client := s.getClientByIP(ip)
+	client, addressRule := s.getClientByIP(ip)
 	if client == nil {
 		writeResponse(writer, "550 Access denied")
 
@@ -293,6 +373,22 @@ func (s *MM4Server) handleConnection(conn net.Conn) {
 		return
 	}
 
+	if !s.connLimits.acquire(conn, client.Username, s.gateway.maxClientConns(client), s.gateway.Config.MaxConnsTotal) {
+		writeResponse(writer, "421 Too many connections, try again later")
+		lm.SendLog(lm.BuildLog(
+			"Server.MM4.HandleConnection",
+			"TooManyConnections",
+			logrus.WarnLevel,
+			map[string]interface{}{
+				"client":  client.Username,
+				"ip":      ip,
+				"ip_hash": hashedIP,
+			},
+		))
+		return
+	}
+	defer s.connLimits.release(conn)
+
 	// Generate unique session ID
 	sessionID := generateSessionID()
 
@@ -349,6 +445,7 @@ func (s *MM4Server) handleConnection(conn net.Conn) {
 			"first_session":   isFirstSession,
 			"ip":              ip,
 			"ip_hash":         hashedIP,
+			"address_rule":    addressRule,
 		},
 	))
 
@@ -367,21 +464,22 @@ func (s *MM4Server) handleConnection(conn net.Conn) {
 			},
 			err,
 		))
-		writeResponse(writer, "451 Internal server error")
+		writeResponse(session.Writer, "451 Internal server error")
 	}
 }
 
-// getClientByIP returns the client associated with the given IP address.
-func (s *MM4Server) getClientByIP(ip string) *Client {
+// getClientByIP returns the client whose Address allows the given IP, along
+// with the address entry (IP, CIDR or hostname) that matched.
+func (s *MM4Server) getClientByIP(ip string) (*Client, string) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	for _, client := range s.gateway.Clients {
-		if client.Address == ip {
-			return client
+		if rule, ok := client.matchAddress(ip); ok {
+			return client, rule
 		}
 	}
-	return nil
+	return nil, ""
 }
 
 // hashIP hashes an IP address using SHA-256.
@@ -404,10 +502,19 @@ type Session struct {
 	IPHash     string
 	ClientIP   string
 	RemoteAddr string
+	TLS        bool // STARTTLS has completed
 	Files      []MsgFile
 	mongo      *mongo.Client
 	SessionID  string // Unique identifier for log correlation
+	CaptureID  string // Raw capture of the current DATA transaction, if stored
 	State      int    // 0: Init, 1: Helo, 2: Mail, 3: Rcpt, 4: Data
+
+	// SingleImageMode is how an outbound message holding one image is sent
+	// (MM4SingleImageSMIL or MM4SingleImageMixed).
+	SingleImageMode string
+	// Notification is set when Files hold an MMS notification in place of
+	// the media, which is sent without SMIL.
+	Notification bool
 }
 
 // debugLog is a helper to send debug logs via LogManager.
@@ -488,9 +595,23 @@ func (s *Session) handleCommand(line string, srv *MM4Server) error {
 	}
 
 	switch cmd {
-	case "HELO", "EHLO":
+	case "HELO":
 		s.State = 1
-		writeResponse(s.Writer, "250 Hello")
+		writeResponse(s.Writer, fmt.Sprintf("250 %s Hello", srv.gateway.Config.mm4Hostname()))
+	case "EHLO":
+		s.State = 1
+		if s.Server.TLS != nil && !s.TLS {
+			writeResponse(s.Writer, fmt.Sprintf("250-%s Hello\r\n250 STARTTLS", srv.gateway.Config.mm4Hostname()))
+		} else {
+			writeResponse(s.Writer, fmt.Sprintf("250 %s Hello", srv.gateway.Config.mm4Hostname()))
+		}
+	case "STARTTLS":
+		if s.State < 1 {
+			s.debugLog("StateError", map[string]interface{}{"error": "Need EHLO first"})
+			writeResponse(s.Writer, "503 Bad sequence of commands: Send EHLO first")
+			return nil
+		}
+		return s.startTLS()
 	case "MAIL":
 		if s.State < 1 {
 			s.debugLog("StateError", map[string]interface{}{"error": "Need HELO first"})
@@ -502,7 +623,7 @@ func (s *Session) handleCommand(line string, srv *MM4Server) error {
 				"arg":   arg,
 				"error": err.Error(),
 			})
-			writeResponse(s.Writer, fmt.Sprintf("550 %v", err))
+			writeResponse(s.Writer, fmt.Sprintf("501 %v", err))
 		} else {
 			s.State = 2
 			writeResponse(s.Writer, "250 OK")
@@ -518,7 +639,7 @@ func (s *Session) handleCommand(line string, srv *MM4Server) error {
 				"arg":   arg,
 				"error": err.Error(),
 			})
-			writeResponse(s.Writer, fmt.Sprintf("550 %v", err))
+			writeResponse(s.Writer, fmt.Sprintf("501 %v", err))
 		} else {
 			s.State = 3
 			writeResponse(s.Writer, "250 OK")
@@ -543,7 +664,11 @@ func (s *Session) handleCommand(line string, srv *MM4Server) error {
 				},
 				err,
 			))
-			writeResponse(s.Writer, fmt.Sprintf("554 %v", err))
+			if errors.Is(err, errMM4MessageTooLarge) {
+				writeResponse(s.Writer, fmt.Sprintf("552 %v", err))
+			} else {
+				writeResponse(s.Writer, fmt.Sprintf("554 %v", err))
+			}
 		} else {
 			// Reset state for next message in same session
 			s.State = 1 // Back to HELO state (ready for MAIL)? Or back to 1 (Authenticated/Helo'd)
@@ -551,6 +676,8 @@ func (s *Session) handleCommand(line string, srv *MM4Server) error {
 		}
 	case "RSET":
 		s.State = 1 // Reset to HELO state
+		s.From = ""
+		s.To = nil
 		writeResponse(s.Writer, "250 OK")
 	case "NOOP":
 		writeResponse(s.Writer, "250 OK")
@@ -567,14 +694,26 @@ func (s *Session) handleCommand(line string, srv *MM4Server) error {
 	return nil
 }
 
-// handleMail processes the MAIL FROM command.
+// handleMail processes the MAIL FROM command. It starts a new transaction,
+// so recipients from a previous message are cleared.
 func (s *Session) handleMail(arg string) error {
 	if !strings.HasPrefix(strings.ToUpper(arg), "FROM:") {
 		return errors.New("syntax error in MAIL command")
 	}
-	s.From = strings.TrimSpace(arg[5:])
+	from, err := parseMM4Address(arg[5:])
+	if err != nil {
+		// .RES and report messages come from the peer's system address
+		system, ok := mm4SystemAddress(arg[5:])
+		if !ok {
+			return err
+		}
+		from = system
+	}
+	s.From = from
+	s.To = nil
 	s.debugLog("MAILFROM", map[string]interface{}{
 		"from": s.From,
+		"raw":  strings.TrimSpace(arg[5:]),
 	})
 	return nil
 }
@@ -584,33 +723,91 @@ func (s *Session) handleRcpt(arg string) error {
 	if !strings.HasPrefix(strings.ToUpper(arg), "TO:") {
 		return errors.New("syntax error in RCPT command")
 	}
-	recipient := strings.TrimSpace(arg[3:])
+	recipient, err := parseMM4Address(arg[3:])
+	if err != nil {
+		// .RES and report messages are addressed to our system address
+		system, ok := mm4SystemAddress(arg[3:])
+		if !ok || !strings.EqualFold(system, s.Server.gateway.Config.mm4OriginatorSystem()) {
+			return err
+		}
+		recipient = system
+	}
 	s.To = append(s.To, recipient)
 	s.debugLog("RCPTTO", map[string]interface{}{
 		"recipient": recipient,
+		"raw":       strings.TrimSpace(arg[3:]),
 		"count":     len(s.To),
 	})
 	return nil
 }
 
+// defaultMM4MaxMessageBytes fits three media parts of maxInputSize after
+// base64 encoding, which adds a third, plus headers and SMIL.
+const defaultMM4MaxMessageBytes = 3*maxInputSize*4/3 + 1<<20
+
+// errMM4MessageTooLarge is returned by handleData for a message, headers
+// included, over MM4_MAX_MESSAGE_BYTES and answered with 552.
+var errMM4MessageTooLarge = errors.New("message size exceeds fixed maximum message size")
+
 // handleData processes the DATA command and reads message content.
 func (s *Session) handleData() error {
-	tp := textproto.NewReader(s.Reader)
+	// Everything up to the terminating dot, headers included, counts
+	// towards MM4_MAX_MESSAGE_BYTES.
+	data := textproto.NewReader(s.Reader).DotReader()
+	limit := int64(s.Server.gateway.Config.MM4MaxMessageBytes)
+	src := &io.LimitedReader{R: data, N: limit + 1}
+	if limit <= 0 {
+		src.N = 1<<63 - 1
+	}
+	tp := textproto.NewReader(bufio.NewReader(src))
 
 	// Read headers
 	headers, err := tp.ReadMIMEHeader()
-	if err != nil {
+	if err != nil && src.N > 0 {
+		// Drop the rest of the message so the session stays in step.
+		io.Copy(io.Discard, data)
 		return err
 	}
 	s.Headers = headers
+	s.CaptureID = ""
 
-	// Read the body using DotReader
+	// Read the body
 	var bodyBuilder strings.Builder
-	if _, err := io.Copy(&bodyBuilder, tp.DotReader()); err != nil {
-		return err
+	if err == nil {
+		if _, err := io.Copy(&bodyBuilder, tp.R); err != nil {
+			return err
+		}
+	}
+	if src.N == 0 {
+		// Read to the terminating dot without keeping it, so the session
+		// stays in step with the client.
+		rest, err := io.Copy(io.Discard, data)
+		lm := s.Server.gateway.LogManager
+		lm.SendLog(lm.BuildLog(
+			"Server.MM4.HandleData",
+			"MessageTooLarge",
+			logrus.WarnLevel,
+			map[string]interface{}{
+				"client":      safeClientUsername(s.Client),
+				"ip":          s.ClientIP,
+				"ip_hash":     s.IPHash,
+				"session_id":  s.SessionID,
+				"from":        s.From,
+				"limit_bytes": limit,
+				"size_bytes":  limit + 1 + rest,
+			},
+		))
+		if err != nil {
+			return err
+		}
+		return errMM4MessageTooLarge
 	}
 	s.Data = []byte(bodyBuilder.String())
 
+	if s.Server.capture != nil && s.Server.capture.mode == MM4CaptureAll {
+		s.captureMM4("all")
+	}
+
 	// Handle MM4 message
 	if err := s.handleMM4Message(); err != nil {
 		// Only dump full MM4 if it's NOT an empty body error (which is likely a keep-alive/zombie)
@@ -629,15 +826,56 @@ func (s *Session) handleData() error {
 
 // handleMM4Message processes the MM4 message based on its type.
 func (s *Session) handleMM4Message() error {
+	requiredHeaders := []string{
+		"X-Mms-3GPP-MMS-Version",
+		"X-Mms-message-Type",
+		"X-Mms-Transaction-ID",
+	}
+	for _, header := range requiredHeaders {
+		if s.Headers.Get(header) == "" {
+			s.dumpFullMM4("missing_required_header_" + header)
+			return fmt.Errorf("missing required header: %s", header)
+		}
+	}
+
+	msgType := strings.TrimSpace(s.Headers.Get("X-Mms-message-Type"))
+	switch {
+	case strings.EqualFold(msgType, MM4ForwardReq):
+		return s.handleForwardReq()
+	case strings.EqualFold(msgType, MM4ForwardRes):
+		return s.handleForwardRes()
+	case strings.EqualFold(msgType, MM4DeliveryReportReq):
+		return s.handleDeliveryReportReq()
+	case strings.EqualFold(msgType, MM4ReadReplyReq):
+		return s.handleReadReplyReq()
+	}
+
+	// Anything else (e.g. a .RES to a report we never send) is accepted and
+	// dropped so the peer does not keep retrying it.
+	lm := s.Server.gateway.LogManager
+	lm.SendLog(lm.BuildLog(
+		"Server.MM4.Message",
+		"UnknownMessageType",
+		logrus.WarnLevel,
+		map[string]interface{}{
+			"client":         safeClientUsername(s.Client),
+			"ip":             s.ClientIP,
+			"message_type":   msgType,
+			"transaction_id": s.Headers.Get("X-Mms-Transaction-ID"),
+		},
+	))
+	return nil
+}
+
+// handleForwardReq queues an inbound MM4_forward.REQ for transcoding and
+// delivery, answering with MM4_forward.RES when the peer asked for one.
+func (s *Session) handleForwardReq() error {
 	if len(s.Data) == 0 {
 		return fmt.Errorf("empty message body")
 	}
 
 	requiredHeaders := []string{
-		"X-Mms-3GPP-MMS-Version",
-		"X-Mms-message-Type",
 		"X-Mms-message-ID",
-		"X-Mms-Transaction-ID",
 		"From",
 		"To",
 	}
@@ -648,24 +886,40 @@ func (s *Session) handleMM4Message() error {
 		}
 	}
 
+	// Reports may travel between system mailboxes, but a forwarded message
+	// must be addressed from and to phone numbers.
+	recipients, ok := mm4Recipients(s.To, s.Server.gateway.defaultCountryCode(s.Client))
+	if !mm4NumberRegex.MatchString(s.From) || !ok {
+		s.dumpFullMM4("forward_req_non_number_envelope")
+		return fmt.Errorf("MM4_forward.REQ envelope must use phone numbers")
+	}
+
 	transactionID := strings.Trim(s.Headers.Get("X-Mms-Transaction-ID"), "\"")
 	messageID := strings.Trim(s.Headers.Get("X-Mms-message-ID"), "\"")
+	traceID := newTraceID()
 
 	s.debugLog("MM4HeadersValidated", map[string]interface{}{
 		"transaction_id": transactionID,
+		"trace_id":       traceID,
 		"message_id":     messageID,
 		"from":           s.Headers.Get("From"),
 		"to":             s.Headers.Get("To"),
 	})
 
+	// Envelope addresses were normalized at MAIL/RCPT time; the header From/To
+	// are only used for logging. Group MMS is fanned out per recipient after
+	// transcoding.
 	mm4Message := &MM4Message{
-		From:          s.Headers.Get("From"),
-		To:            s.Headers.Get("To"),
+		From:          s.From,
+		To:            recipients[0],
+		Recipients:    recipients,
 		Content:       s.Data,
 		Headers:       s.Headers,
 		Client:        s.Client,
 		TransactionID: transactionID,
 		MessageID:     messageID,
+		TraceID:       traceID,
+		ReceivedAt:    time.Now(),
 	}
 
 	// Parse MIME parts to extract files
@@ -676,16 +930,20 @@ func (s *Session) handleMM4Message() error {
 	}
 
 	s.debugLog("MIMEPartsParsed", map[string]interface{}{
+		"trace_id":   traceID,
 		"file_count": len(mm.Files),
 	})
 
 	s.Server.MediaTranscodeChan <- mm
 
+	s.sendMM4Ack(MM4ForwardRes)
 	return nil
 }
 
 // dumpFullMM4 logs the full MM4 request (headers + body preview).
-// No env gating; relies purely on log level filtering.
+// No env gating; relies purely on log level filtering. When MM4_CAPTURE is
+// enabled the complete request is also stored and its ID logged as
+// capture_id, retrievable from /debug/mm4/{id}.
 func (s *Session) dumpFullMM4(reason string) {
 	lm := s.Server.gateway.LogManager
 
@@ -721,6 +979,7 @@ func (s *Session) dumpFullMM4(reason string) {
 			"body_len":         len(s.Data),
 			"body_truncated":   truncated,
 			"body_preview_raw": string(bodyPreview),
+			"capture_id":       s.captureMM4(reason),
 		},
 	))
 }
@@ -743,9 +1002,11 @@ func (m *MM4Message) parseMIMEParts() (*MM4Message, error) {
 			return nil, fmt.Errorf("no boundary parameter in Msg-Type")
 		}
 
+		// Raw parts, so quoted-printable is decoded with the other
+		// transfer encodings rather than behind our back.
 		reader := multipart.NewReader(bytes.NewReader(m.Content), boundary)
 		for {
-			part, err := reader.NextPart()
+			part, err := reader.NextRawPart()
 			if err == io.EOF {
 				break
 			}
@@ -753,23 +1014,28 @@ func (m *MM4Message) parseMIMEParts() (*MM4Message, error) {
 				return nil, fmt.Errorf("failed to read part: %v", err)
 			}
 
-			var buf bytes.Buffer
-			if _, err := io.Copy(&buf, part); err != nil {
+			partType := part.Header.Get("Content-Type")
+			content, err := readTransferEncoded(part.Header.Get("Content-Transfer-Encoding"), partType, part)
+			if err != nil {
 				return nil, fmt.Errorf("failed to read part content: %v", err)
 			}
 
 			file := MsgFile{
 				Filename:    part.FileName(),
-				ContentType: part.Header.Get("Content-Type"),
-				Content:     buf.Bytes(),
+				ContentType: partType,
+				Content:     content,
 			}
 			m.Files = append(m.Files, file)
 		}
 	} else {
+		content, err := readTransferEncoded(m.Headers.Get("Content-Transfer-Encoding"), mediaType, bytes.NewReader(m.Content))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read content: %v", err)
+		}
 		file := MsgFile{
 			Filename:    "",
 			ContentType: mediaType,
-			Content:     m.Content,
+			Content:     content,
 		}
 		m.Files = append(m.Files, file)
 	}
@@ -778,9 +1044,10 @@ func (m *MM4Message) parseMIMEParts() (*MM4Message, error) {
 }
 
 // sendMM4 sends an MM4 message to a client over plain TCP with base64-encoded media.
+// A message without media is sent as a text/plain part.
 func (s *MM4Server) sendMM4(item MsgQueueItem) error {
-	if item.files == nil {
-		return fmt.Errorf("files are nil")
+	if len(item.files) == 0 && item.message == "" {
+		return fmt.Errorf("message has no text or files")
 	}
 
 	s.mu.RLock()
@@ -799,60 +1066,110 @@ func (s *MM4Server) sendMM4(item MsgQueueItem) error {
 		"MM4SendStart",
 		logrus.InfoLevel,
 		map[string]interface{}{
+			"trace_id":           item.TraceID,
 			"to":                 item.To,
 			"from":               item.From,
 			"file_count":         len(item.files),
 			"destination_client": client.Username,
-			"destination_addr":   client.Address,
+			"destination_addr":   client.deliveryHost(),
 		},
 	))
 
-	port := "25" // Default SMTP port todo
-	address := net.JoinHostPort(client.Address, port)
-
-	conn, err := net.DialTimeout("tcp", address, 10*time.Second)
+	session, address, err := s.openMM4Session(client)
 	if err != nil {
+		return err
+	}
+	defer session.Conn.Close()
+
+	mm4Message := s.createMM4Message(item)
+	session.Headers = mm4Message.Headers
+	session.From = mm4Message.From
+	session.To = []string{mm4Message.To}
+	session.Data = mm4Message.Content
+	session.Files = mm4Message.Files
+	session.SingleImageMode = s.gateway.mm4SingleImageMode(client)
+	if mmsDeliveryMode(client) == MMSDeliveryNotification && hasMediaParts(item.files) {
+		urls, err := s.gateway.uploadMediaGetUrls(&item)
+		if err != nil {
+			return fmt.Errorf("storing media for MMS notification: %w", err)
+		}
+		session.Files = mmsNotificationFiles(item, urls, s.gateway.mediaTTL())
+		session.Notification = true
+	}
+
+	// Proceed to send the MM4 message
+	if err := session.sendMM4Message(); err != nil {
 		lm.SendLog(lm.BuildLog(
 			"Server.MM4.Outbound",
-			"ConnectError",
+			"MM4SendError",
 			logrus.ErrorLevel,
 			map[string]interface{}{
-				"address": address,
+				"trace_id": item.TraceID,
+				"address":  address,
 			},
 			err,
 		))
-		return fmt.Errorf("failed to connect to client's MM4 server at %s", address)
+		return fmt.Errorf("send MM4 failed: %v", err)
 	}
-	defer conn.Close()
 
-	if err := conn.SetDeadline(time.Now().Add(30 * time.Second)); err != nil {
+	if err := session.quitMM4Session(address); err != nil {
+		return err
+	}
+
+	lm.SendLog(lm.BuildLog(
+		"Server.MM4.Outbound",
+		"MM4SendSuccess",
+		logrus.InfoLevel,
+		map[string]interface{}{
+			"trace_id":         item.TraceID,
+			"to":               item.To,
+			"from":             item.From,
+			"file_count":       len(item.files),
+			"destination_addr": address,
+		},
+	))
+
+	return nil
+}
+
+// openMM4Session connects to a client's MM4 server and completes the
+// greeting and EHLO. The caller must close session.Conn.
+func (s *MM4Server) openMM4Session(client *Client) (*Session, string, error) {
+	lm := s.gateway.LogManager
+
+	port := s.deliveryPort
+	if port == "" {
+		port = "25" // Default SMTP port todo
+	}
+	address := net.JoinHostPort(client.deliveryHost(), port)
+
+	timeouts := s.gateway.mm4Timeouts(client)
+	conn, err := net.DialTimeout("tcp", address, timeouts.Dial)
+	if err != nil {
 		lm.SendLog(lm.BuildLog(
 			"Server.MM4.Outbound",
-			"SetDeadlineError",
-			logrus.WarnLevel,
+			"ConnectError",
+			logrus.ErrorLevel,
 			map[string]interface{}{
 				"address": address,
 			},
 			err,
 		))
+		return nil, "", fmt.Errorf("failed to connect to client's MM4 server at %s", address)
 	}
 
+	// The deadline follows the data rather than capping the whole session.
+	conn = &mm4DeadlineConn{Conn: conn, idle: timeouts.Idle}
+
 	reader := bufio.NewReader(conn)
 	writer := bufio.NewWriter(conn)
 
-	mm4Message := s.createMM4Message(item)
-
 	session := &Session{
-		Conn:    conn,
-		Reader:  reader,
-		Writer:  writer,
-		Server:  s,
-		Client:  client,
-		Headers: mm4Message.Headers,
-		From:    mm4Message.From,
-		To:      []string{mm4Message.To},
-		Data:    mm4Message.Content,
-		Files:   mm4Message.Files,
+		Conn:   conn,
+		Reader: reader,
+		Writer: writer,
+		Server: s,
+		Client: client,
 	}
 
 	// Read server's initial response
@@ -867,7 +1184,8 @@ func (s *MM4Server) sendMM4(item MsgQueueItem) error {
 			},
 			err,
 		))
-		return fmt.Errorf("failed to read server greeting: %v", err)
+		_ = conn.Close()
+		return nil, "", fmt.Errorf("failed to read server greeting: %v", err)
 	}
 	if !strings.HasPrefix(response, "220") {
 		lm.SendLog(lm.BuildLog(
@@ -879,11 +1197,12 @@ func (s *MM4Server) sendMM4(item MsgQueueItem) error {
 				"response": response,
 			},
 		))
-		return fmt.Errorf("unexpected server greeting: %s", response)
+		_ = conn.Close()
+		return nil, "", fmt.Errorf("unexpected server greeting: %s", response)
 	}
 
 	// Send EHLO command
-	if err := session.sendCommand("EHLO localhost"); err != nil {
+	if err := session.sendCommand("EHLO " + s.gateway.Config.mm4Hostname()); err != nil {
 		lm.SendLog(lm.BuildLog(
 			"Server.MM4.Outbound",
 			"EHLOSendError",
@@ -893,7 +1212,8 @@ func (s *MM4Server) sendMM4(item MsgQueueItem) error {
 			},
 			err,
 		))
-		return err
+		_ = conn.Close()
+		return nil, "", err
 	}
 	response, err = session.readResponse()
 	if err != nil {
@@ -906,7 +1226,8 @@ func (s *MM4Server) sendMM4(item MsgQueueItem) error {
 			},
 			err,
 		))
-		return err
+		_ = conn.Close()
+		return nil, "", err
 	}
 	if !strings.HasPrefix(response, "250") {
 		lm.SendLog(lm.BuildLog(
@@ -918,25 +1239,19 @@ func (s *MM4Server) sendMM4(item MsgQueueItem) error {
 				"response": response,
 			},
 		))
-		return fmt.Errorf("EHLO command failed: %s", response)
+		_ = conn.Close()
+		return nil, "", fmt.Errorf("EHLO command failed: %s", response)
 	}
 
-	// Proceed to send the MM4 message
-	if err := session.sendMM4Message(); err != nil {
-		lm.SendLog(lm.BuildLog(
-			"Server.MM4.Outbound",
-			"MM4SendError",
-			logrus.ErrorLevel,
-			map[string]interface{}{
-				"address": address,
-			},
-			err,
-		))
-		return fmt.Errorf("send MM4 failed: %v", err)
-	}
+	return session, address, nil
+}
+
+// quitMM4Session ends an outbound MM4 session with QUIT.
+func (s *Session) quitMM4Session(address string) error {
+	lm := s.Server.gateway.LogManager
 
 	// Send QUIT command to terminate the session gracefully
-	if err := session.sendCommand("QUIT"); err != nil {
+	if err := s.sendCommand("QUIT"); err != nil {
 		lm.SendLog(lm.BuildLog(
 			"Server.MM4.Outbound",
 			"QUITSendError",
@@ -948,7 +1263,7 @@ func (s *MM4Server) sendMM4(item MsgQueueItem) error {
 		))
 		return fmt.Errorf("send QUIT failed: %v", err)
 	}
-	response, err = session.readResponse()
+	response, err := s.readResponse()
 	if err != nil {
 		lm.SendLog(lm.BuildLog(
 			"Server.MM4.Outbound",
@@ -974,22 +1289,15 @@ func (s *MM4Server) sendMM4(item MsgQueueItem) error {
 		return fmt.Errorf("QUIT command failed: %s", response)
 	}
 
-	lm.SendLog(lm.BuildLog(
-		"Server.MM4.Outbound",
-		"MM4SendSuccess",
-		logrus.InfoLevel,
-		map[string]interface{}{
-			"to":               item.To,
-			"from":             item.From,
-			"file_count":       len(item.files),
-			"destination_addr": address,
-		},
-	))
-
 	return nil
 }
 
+// mm4TextFilename names the text/plain part generated for MMS without media.
+const mm4TextFilename = "text_0.txt"
+
 // createMM4Message constructs an MM4Message with the provided media files.
+// When there are none, the message text becomes a single text/plain part so
+// the SMIL has something to present.
 func (s *MM4Server) createMM4Message(msgItem MsgQueueItem) *MM4Message {
 	headers := textproto.MIMEHeader{}
 	headers.Set("To", fmt.Sprintf("%s/TYPE=PLMN", msgItem.To))
@@ -997,16 +1305,16 @@ func (s *MM4Server) createMM4Message(msgItem MsgQueueItem) *MM4Message {
 	headers.Set("MIME-Version", "1.0")
 	headers.Set("X-Mms-3GPP-Mms-Version", "6.10.0")
 	headers.Set("X-Mms-message-Type", "MM4_forward.REQ")
-	headers.Set("X-Mms-message-Id", fmt.Sprintf("<%s@%s>", msgItem.LogID, os.Getenv("MM4_MSG_ID_HOST")))
+	headers.Set("X-Mms-message-Id", fmt.Sprintf("<%s@%s>", msgItem.LogID, s.gateway.Config.mm4MessageIDHost()))
 	headers.Set("X-Mms-Transaction-Id", msgItem.LogID)
 	headers.Set("X-Mms-Ack-Request", "Yes")
 
-	originatorSystem := os.Getenv("MM4_ORIGINATOR_SYSTEM")
-	if originatorSystem == "" {
-		originatorSystem = "system@yourdomain.com"
-	}
-	headers.Set("X-Mms-Originator-System", originatorSystem)
+	headers.Set("X-Mms-Originator-System", s.gateway.Config.mm4OriginatorSystem())
 	headers.Set("Date", time.Now().UTC().Format(time.RFC1123Z))
+	if msgItem.Subject != "" {
+		subject := strings.NewReplacer("\r", " ", "\n", " ").Replace(msgItem.Subject)
+		headers.Set("Subject", mime.QEncoding.Encode("utf-8", subject))
+	}
 
 	files := make([]MsgFile, 0)
 	for _, f := range msgItem.files {
@@ -1016,6 +1324,13 @@ func (s *MM4Server) createMM4Message(msgItem MsgQueueItem) *MM4Message {
 			Content:     f.Content,
 		})
 	}
+	if !hasMediaParts(files) && msgItem.message != "" {
+		files = append(files, MsgFile{
+			Filename:    mm4TextFilename,
+			ContentType: "text/plain",
+			Content:     []byte(msgItem.message),
+		})
+	}
 
 	return &MM4Message{
 		From:          msgItem.From,
@@ -1025,7 +1340,18 @@ func (s *MM4Server) createMM4Message(msgItem MsgQueueItem) *MM4Message {
 		TransactionID: msgItem.LogID,
 		MessageID:     msgItem.LogID,
 		Files:         files,
+		TraceID:       msgItem.TraceID,
+	}
+}
+
+// hasMediaParts reports whether files holds anything besides a SMIL part.
+func hasMediaParts(files []MsgFile) bool {
+	for _, f := range files {
+		if f.ContentType != "application/smil" {
+			return true
+		}
 	}
+	return false
 }
 
 // sendCommand sends a command to the SMTP/MM4 server.
@@ -1071,57 +1397,29 @@ func (s *Session) readResponse() (string, error) {
 	return response, nil
 }
 
+// mm4Rand is the random source for Content-IDs, boundaries and session IDs.
+// It is seeded once; a *rand.Rand is not safe for concurrent use, so callers
+// hold the mutex.
+var mm4Rand = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
 // generateContentID creates a unique Msg-ID.
 func generateContentID() string {
-	rand.Seed(time.Now().UnixNano())
+	mm4Rand.Lock()
+	defer mm4Rand.Unlock()
 	return fmt.Sprintf("%08x-%04x-%04x-%04x-%012x",
-		rand.Uint32(),
-		rand.Uint32()&0xffff,
-		rand.Uint32()&0xffff,
-		rand.Uint32()&0xffff,
-		rand.Uint32())
-}
-
-// generateSMIL generates a SMIL content that references the provided media files.
-func generateSMIL(files []MsgFile) ([]byte, error) {
-	if len(files) == 0 {
-		return nil, fmt.Errorf("no media files to include in SMIL")
-	}
-
-	var smilBuffer bytes.Buffer
-	smilBuffer.WriteString("<smil>\n<head>\n")
-	smilBuffer.WriteString("<layout>\n")
-	smilBuffer.WriteString("<root-layout width=\"320px\" height=\"480px\"/>\n")
-	smilBuffer.WriteString("<region id=\"Image\" width=\"100%\" height=\"100%\" fit=\"meet\"/>\n")
-	smilBuffer.WriteString("</layout>\n")
-	smilBuffer.WriteString("</head>\n")
-	smilBuffer.WriteString("<body>\n")
-
-	for _, file := range files {
-		if strings.HasPrefix(file.ContentType, "image/") {
-			smilBuffer.WriteString("<par dur=\"5000ms\">\n")
-			smilBuffer.WriteString(fmt.Sprintf("<img src=\"%s\" region=\"Image\"/>\n", file.Filename))
-			smilBuffer.WriteString("</par>\n")
-		}
-	}
-
-	smilBuffer.WriteString("</body>\n")
-	smilBuffer.WriteString("</smil>\n")
-
-	return smilBuffer.Bytes(), nil
+		mm4Rand.Uint32(),
+		mm4Rand.Uint32()&0xffff,
+		mm4Rand.Uint32()&0xffff,
+		mm4Rand.Uint32()&0xffff,
+		mm4Rand.Int63()&0xffffffffffff)
 }
 
-func (s *Session) sendMM4Message() error {
-	if len(s.Files) <= 0 {
-		return fmt.Errorf("no files found")
-	}
-
-	s.debugLog("MM4BuildMessage", map[string]interface{}{
-		"from":       s.From,
-		"to":         strings.Join(s.To, ","),
-		"file_count": len(s.Files),
-	})
-
+// startMM4Data sends MAIL FROM, RCPT TO and DATA for the session envelope,
+// leaving the peer ready to receive the message content.
+func (s *Session) startMM4Data() error {
 	// Step 1: MAIL FROM Command
 	mailFromCmd := fmt.Sprintf("MAIL FROM:<%s>", s.From)
 	if err := s.sendCommand(mailFromCmd); err != nil {
@@ -1162,46 +1460,105 @@ func (s *Session) sendMM4Message() error {
 		return fmt.Errorf("DATA command failed: %s", response)
 	}
 
-	// Step 4: Building the MIME Multipart message
-	var messageBuffer bytes.Buffer
+	return nil
+}
 
-	boundary := generateBoundary()
+// finishMM4Data writes the message content (including the terminating
+// ".\r\n") and waits for the peer to accept it.
+func (s *Session) finishMM4Data(msgData string) error {
+	_, err := s.Writer.WriteString(msgData)
+	if err != nil {
+		return err
+	}
+	err = s.Writer.Flush()
+	if err != nil {
+		return err
+	}
+	return s.confirmMM4Data()
+}
 
-	messageBuffer.WriteString(fmt.Sprintf("To: %s\r\n", strings.Join(s.To, ", ")))
-	messageBuffer.WriteString(fmt.Sprintf("From: %s\r\n", s.From))
-	messageBuffer.WriteString(fmt.Sprintf("Content-Type: multipart/related; boundary=\"%s\"\r\n", boundary))
-	messageBuffer.WriteString("MIME-Version: 1.0\r\n")
+// confirmMM4Data reads the peer's reply to the end of DATA.
+func (s *Session) confirmMM4Data() error {
+	response, err := s.readResponse()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(response, "250") {
+		return fmt.Errorf("DATA send failed: %s", response)
+	}
+	return nil
+}
 
-	essentialHeaders := []string{
-		"X-Mms-3GPP-Mms-Version",
-		"X-Mms-message-Type",
-		"X-Mms-message-Id",
-		"X-Mms-Transaction-Id",
-		"X-Mms-Ack-Request",
-		"X-Mms-Originator-System",
-		"Date",
-		"message-ID",
+func (s *Session) sendMM4Message() error {
+	if len(s.Files) <= 0 {
+		return fmt.Errorf("no files found")
 	}
-	for _, header := range essentialHeaders {
-		if value := s.Headers.Get(header); value != "" {
-			messageBuffer.WriteString(fmt.Sprintf("%s: %s\r\n", header, value))
-		}
+
+	s.debugLog("MM4BuildMessage", map[string]interface{}{
+		"from":       s.From,
+		"to":         strings.Join(s.To, ","),
+		"file_count": len(s.Files),
+	})
+
+	if err := s.startMM4Data(); err != nil {
+		return err
 	}
 
-	messageBuffer.WriteString("\r\n")
+	// Step 4: Stream the MIME multipart message, so large attachments are
+	// never held in memory as a whole.
+	writeBody := s.writeMM4Body
+	if s.Notification {
+		writeBody = s.writeMM4NotificationBody
+	} else if _, ok := singleImage(s.Files); ok && s.SingleImageMode == MM4SingleImageMixed {
+		writeBody = s.writeMM4MixedBody
+	}
+	dw := &dotWriter{w: s.Writer}
+	if err := writeBody(dw, generateBoundary(), generateContentID); err != nil {
+		// Closing dw would end DATA and have the peer accept a truncated
+		// message; the caller drops the connection instead.
+		return err
+	}
+	if err := dw.Close(); err != nil {
+		return err
+	}
+	if err := s.Writer.Flush(); err != nil {
+		return err
+	}
+	if err := s.confirmMM4Data(); err != nil {
+		return err
+	}
+
+	s.debugLog("MM4MessageSent", map[string]interface{}{
+		"to":         strings.Join(s.To, ","),
+		"file_count": len(s.Files),
+	})
+
+	return nil
+}
+
+// writeMM4Body writes the headers and MIME parts of the session's message to
+// w, base64-encoding each attachment as it is written. The output is not
+// dot-stuffed; w is expected to do that.
+func (s *Session) writeMM4Body(w io.Writer, boundary string, contentID func() string) error {
+	// Headers are small, so each header block is built up before writing.
+	var header bytes.Buffer
+	s.writeMM4Headers(&header, fmt.Sprintf("multipart/related; boundary=\"%s\"", boundary))
 
 	if len(s.Files) > 0 {
-		smilContent, err := generateSMIL(s.Files)
+		smilContent, err := generateSMIL(s.Files, s.Server.SMILLayout)
 		if err != nil {
 			return err
 		}
 
-		messageBuffer.WriteString(fmt.Sprintf("--%s\r\n", boundary))
-		messageBuffer.WriteString("Content-Id: <0.smil>\r\n")
-		messageBuffer.WriteString("Content-Type: application/smil; name=\"0.smil\"\r\n")
-		messageBuffer.WriteString("\r\n")
-		messageBuffer.Write(smilContent)
-		messageBuffer.WriteString("\r\n")
+		header.WriteString(fmt.Sprintf("--%s\r\n", boundary))
+		header.WriteString("Content-Id: <0.smil>\r\n")
+		header.WriteString("Content-Type: application/smil; name=\"0.smil\"\r\n")
+		header.WriteString("\r\n")
+		header.Write(smilContent)
+		header.WriteString("\r\n")
+	}
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
 	}
 
 	for _, file := range s.Files {
@@ -1209,61 +1566,160 @@ func (s *Session) sendMM4Message() error {
 			continue
 		}
 
-		contentID := generateContentID()
-
-		messageBuffer.WriteString(fmt.Sprintf("--%s\r\n", boundary))
-		messageBuffer.WriteString(fmt.Sprintf("Content-Id: <%s>\r\n", contentID))
-		messageBuffer.WriteString(fmt.Sprintf("Content-Type: %s; name=\"%s\"\r\n", file.ContentType, file.Filename))
-		messageBuffer.WriteString(fmt.Sprintf("Content-Disposition: attachment; filename=\"%s\"\r\n", file.Filename))
-		messageBuffer.WriteString(fmt.Sprintf("Content-Location: %s\r\n", file.Filename))
-		messageBuffer.WriteString("Content-Transfer-Encoding: base64\r\n")
-		messageBuffer.WriteString("\r\n")
-
-		encoded := base64.StdEncoding.EncodeToString(file.Content)
-		for i := 0; i < len(encoded); i += 76 {
-			end := i + 76
-			if end > len(encoded) {
-				end = len(encoded)
-			}
-			messageBuffer.WriteString(encoded[i:end] + "\r\n")
+		header.Reset()
+		header.WriteString(fmt.Sprintf("--%s\r\n", boundary))
+		header.WriteString(fmt.Sprintf("Content-Id: <%s>\r\n", contentID()))
+		header.WriteString(fmt.Sprintf("Content-Type: %s; name=\"%s\"\r\n", file.ContentType, file.Filename))
+		header.WriteString(fmt.Sprintf("Content-Disposition: attachment; filename=\"%s\"\r\n", file.Filename))
+		header.WriteString(fmt.Sprintf("Content-Location: %s\r\n", file.Filename))
+		header.WriteString("Content-Transfer-Encoding: base64\r\n")
+		header.WriteString("\r\n")
+		if _, err := w.Write(header.Bytes()); err != nil {
+			return err
+		}
+
+		if err := writeBase64Lines(w, file.Content); err != nil {
+			return err
 		}
 	}
 
-	messageBuffer.WriteString(fmt.Sprintf("--%s--\r\n", boundary))
-	messageBuffer.WriteString(".\r\n")
+	_, err := fmt.Fprintf(w, "--%s--\r\n", boundary)
+	return err
+}
 
-	msgData := messageBuffer.String()
-	_, err = s.Writer.WriteString(msgData)
-	if err != nil {
+// writeMM4Headers writes the message headers and the blank line after them.
+func (s *Session) writeMM4Headers(header *bytes.Buffer, contentType string) {
+	header.WriteString(fmt.Sprintf("To: %s\r\n", strings.Join(s.To, ", ")))
+	header.WriteString(fmt.Sprintf("From: %s\r\n", s.From))
+	header.WriteString(fmt.Sprintf("Content-Type: %s\r\n", contentType))
+	header.WriteString("MIME-Version: 1.0\r\n")
+
+	essentialHeaders := []string{
+		"X-Mms-3GPP-Mms-Version",
+		"X-Mms-message-Type",
+		"X-Mms-message-Id",
+		"X-Mms-Transaction-Id",
+		"X-Mms-Ack-Request",
+		"X-Mms-Originator-System",
+		"Date",
+		"message-ID",
+		"Subject",
+	}
+	for _, h := range essentialHeaders {
+		if value := s.Headers.Get(h); value != "" {
+			header.WriteString(fmt.Sprintf("%s: %s\r\n", h, value))
+		}
+	}
+
+	header.WriteString("\r\n")
+}
+
+// mm4Base64LineLen is the base64 line length used for MM4 attachments.
+const mm4Base64LineLen = 76
+
+// writeBase64Lines writes content to w as base64 in CRLF-terminated lines of
+// mm4Base64LineLen characters.
+func writeBase64Lines(w io.Writer, content []byte) error {
+	lw := &lineWrapWriter{w: w, width: mm4Base64LineLen}
+	enc := base64.NewEncoder(base64.StdEncoding, lw)
+	if _, err := enc.Write(content); err != nil {
 		return err
 	}
-	err = s.Writer.Flush()
-	if err != nil {
+	if err := enc.Close(); err != nil {
 		return err
 	}
+	return lw.endLine()
+}
 
-	response, err = s.readResponse()
-	if err != nil {
-		return err
+// lineWrapWriter breaks the bytes written to it into CRLF-terminated lines
+// of width bytes.
+type lineWrapWriter struct {
+	w     io.Writer
+	width int
+	col   int
+}
+
+func (l *lineWrapWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := l.width - l.col
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := l.w.Write(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		l.col += n
+		p = p[n:]
+		if l.col == l.width {
+			if err := l.endLine(); err != nil {
+				return written, err
+			}
+		}
 	}
-	if !strings.HasPrefix(response, "250") {
-		return fmt.Errorf("DATA send failed: %s", response)
+	return written, nil
+}
+
+// endLine terminates a partly written line.
+func (l *lineWrapWriter) endLine() error {
+	if l.col == 0 {
+		return nil
 	}
+	l.col = 0
+	_, err := io.WriteString(l.w, "\r\n")
+	return err
+}
 
-	s.debugLog("MM4MessageSent", map[string]interface{}{
-		"to":         strings.Join(s.To, ","),
-		"file_count": len(s.Files),
-	})
+// dotWriter dot-stuffs SMTP DATA content: a '.' starting a line is doubled
+// so the peer does not take it for the end of the message. Line endings are
+// passed through as written.
+type dotWriter struct {
+	w       io.Writer
+	midLine bool
+}
 
-	return nil
+func (d *dotWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if !d.midLine && p[0] == '.' {
+			if _, err := d.w.Write([]byte{'.'}); err != nil {
+				return written, err
+			}
+		}
+		n := bytes.IndexByte(p, '\n') + 1
+		d.midLine = n == 0
+		if n == 0 {
+			n = len(p)
+		}
+		if _, err := d.w.Write(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Close ends the DATA content with the "." line.
+func (d *dotWriter) Close() error {
+	end := ".\r\n"
+	if d.midLine {
+		end = "\r\n" + end
+	}
+	d.midLine = false
+	_, err := io.WriteString(d.w, end)
+	return err
 }
 
 // randomString generates a random alphanumeric string of the given length.
 func randomString(length int) string {
 	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 	result := make([]byte, length)
+	mm4Rand.Lock()
+	defer mm4Rand.Unlock()
 	for i := range result {
-		result[i] = charset[rand.Intn(len(charset))]
+		result[i] = charset[mm4Rand.Intn(len(charset))]
 	}
 	return string(result)
 }
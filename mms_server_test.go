@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMM4RandomIDsUniqueUnderConcurrency(t *testing.T) {
+	const workers, perWorker = 16, 500
+
+	var mu sync.Mutex
+	contentIDs := make(map[string]bool, workers*perWorker)
+	boundaries := make(map[string]bool, workers*perWorker)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				id, boundary := generateContentID(), generateBoundary()
+				mu.Lock()
+				contentIDs[id] = true
+				boundaries[boundary] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, contentIDs, workers*perWorker, "Content-IDs must not collide")
+	assert.Len(t, boundaries, workers*perWorker, "boundaries must not collide")
+}
+
+func TestGenerateContentIDFormat(t *testing.T) {
+	re := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+	for i := 0; i < 100; i++ {
+		assert.Regexp(t, re, generateContentID())
+	}
+	assert.Len(t, randomString(8), 8)
+}
+
+// fakeMM4Peer accepts one MM4 session on ln, answering each command
+// successfully, and sends the DATA content it received on the returned
+// channel.
+func fakeMM4Peer(t *testing.T, ln net.Listener) <-chan string {
+	t.Helper()
+	data := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+
+		reply("220 fake MM4")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case cmd == "DATA":
+				reply("354 go ahead")
+				var body strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					body.WriteString(l)
+				}
+				data <- body.String()
+				reply("250 queued")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return data
+}
+
+func TestMM4Server_SendTextOnlyMMS(t *testing.T) {
+	_, gw := newTestRouter(1)
+	gw.Clients = map[string]*Client{
+		"pbx1": {Username: "pbx1", Address: "127.0.0.1", Numbers: []ClientNumber{{Number: "12505551234"}}},
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	received := fakeMM4Peer(t, ln)
+
+	srv := &MM4Server{gateway: gw, deliveryPort: port, SMILLayout: smilLayout{Width: 320, Height: 480}}
+	err = srv.sendMM4(MsgQueueItem{
+		To:      "+12505551234",
+		From:    "+14155559876",
+		LogID:   "log-1",
+		Type:    MsgQueueItemType.MMS,
+		message: "hello without media",
+	})
+	require.NoError(t, err)
+
+	body := <-received
+	assert.Contains(t, body, "Content-Type: application/smil")
+	assert.Contains(t, body, `<text src="`+mm4TextFilename+`" region="Text"/>`)
+	assert.Contains(t, body, `Content-Type: text/plain; name="`+mm4TextFilename+`"`)
+	assert.Contains(t, body, "aGVsbG8gd2l0aG91dCBtZWRpYQ==") // base64 of the text
+}
+
+func TestMM4Server_SendSingleImageMixed(t *testing.T) {
+	_, gw := newTestRouter(1)
+	gw.Clients = map[string]*Client{
+		"pbx1": {
+			Username: "pbx1",
+			Address:  "127.0.0.1",
+			Numbers:  []ClientNumber{{Number: "12505551234"}},
+			Settings: &ClientSettings{MM4SingleImage: MM4SingleImageMixed},
+		},
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	received := fakeMM4Peer(t, ln)
+
+	srv := &MM4Server{gateway: gw, deliveryPort: port}
+	err = srv.sendMM4(MsgQueueItem{
+		To:      "+12505551234",
+		From:    "+14155559876",
+		LogID:   "log-1",
+		Type:    MsgQueueItemType.MMS,
+		Subject: "Party pics",
+		files:   []MsgFile{{Filename: "photo.jpg", ContentType: "image/jpeg", Content: []byte("jpeg bytes")}},
+	})
+	require.NoError(t, err)
+
+	body := <-received
+	assert.Contains(t, body, "Content-Type: multipart/mixed;")
+	assert.Contains(t, body, "Subject: Party pics\r\n")
+	assert.Contains(t, body, `Content-Type: image/jpeg; name="photo.jpg"`)
+	assert.Contains(t, body, "anBlZyBieXRlcw==") // base64 of the image
+	assert.NotContains(t, body, "smil")
+	assert.NotContains(t, body, "multipart/related")
+}
+
+func TestMM4SingleImageMode(t *testing.T) {
+	_, gw := newTestRouter(1)
+	assert.Equal(t, MM4SingleImageSMIL, gw.mm4SingleImageMode(nil))
+
+	gw.Config.MM4SingleImage = MM4SingleImageMixed
+	assert.Equal(t, MM4SingleImageMixed, gw.mm4SingleImageMode(&Client{}))
+	assert.Equal(t, MM4SingleImageSMIL, gw.mm4SingleImageMode(&Client{Settings: &ClientSettings{MM4SingleImage: MM4SingleImageSMIL}}))
+}
+
+func TestSingleImage(t *testing.T) {
+	image := MsgFile{Filename: "a.png", ContentType: "image/png"}
+	smil := MsgFile{Filename: "0.smil", ContentType: "application/smil"}
+	text := MsgFile{Filename: "text.txt", ContentType: "text/plain"}
+
+	got, ok := singleImage([]MsgFile{smil, image})
+	assert.True(t, ok)
+	assert.Equal(t, "a.png", got.Filename)
+
+	_, ok = singleImage([]MsgFile{image, text})
+	assert.False(t, ok, "image with text keeps SMIL")
+	_, ok = singleImage([]MsgFile{image, image})
+	assert.False(t, ok)
+	_, ok = singleImage([]MsgFile{{ContentType: "video/mp4"}})
+	assert.False(t, ok)
+}
+
+func TestMM4Server_SendMM4RequiresTextOrFiles(t *testing.T) {
+	srv := &MM4Server{}
+	assert.Error(t, srv.sendMM4(MsgQueueItem{To: "+12505551234", Type: MsgQueueItemType.MMS}))
+}
+
+func TestSession_ForwardReqFansOutPerRecipient(t *testing.T) {
+	r, gw := newTestRouter(4)
+	srv := &MM4Server{gateway: gw, MediaTranscodeChan: make(chan *MM4Message, 1), TranscodeConfig: loadTranscodeConfig(&envReader{})}
+	s := &Session{
+		Server: srv,
+		Client: &Client{ID: 7, Username: "pbx1"},
+		Headers: textproto.MIMEHeader{
+			"X-Mms-3gpp-Mms-Version": {"6.10.0"},
+			"X-Mms-Message-Type":     {MM4ForwardReq},
+			"X-Mms-Transaction-Id":   {"tx-1"},
+			"X-Mms-Message-Id":       {"<abc123@mms.example.com>"},
+			"From":                   {"+12505551234/TYPE=PLMN"},
+			"To":                     {"+14155550001/TYPE=PLMN"},
+			"Content-Type":           {"text/plain"},
+		},
+		Data: []byte("Z3JvdXAgaGVsbG8="), // base64 "group hello"
+	}
+
+	require.NoError(t, s.handleMail("FROM:<+12505551234/TYPE=PLMN@mms.example.com>"))
+	for _, rcpt := range []string{"+14155550001", "+14155550002", "14155550003", "+14155550001"} {
+		require.NoError(t, s.handleRcpt("TO:<"+rcpt+"/TYPE=PLMN@mms.example.com>"))
+	}
+	require.NoError(t, s.handleMM4Message())
+
+	mm := <-srv.MediaTranscodeChan
+	assert.Equal(t, []string{"+14155550001", "+14155550002", "+14155550003"}, mm.Recipients)
+	require.NotEmpty(t, mm.TraceID)
+	srv.transcodeMessage(mm)
+
+	require.Len(t, r.ClientMsgChan, 3)
+	var to, logIDs []string
+	for i := 0; i < 3; i++ {
+		item := <-r.ClientMsgChan
+		to = append(to, item.To)
+		logIDs = append(logIDs, item.LogID)
+		assert.Equal(t, "+12505551234", item.From)
+		assert.Len(t, item.files, 1, "media is shared by every recipient")
+		assert.Equal(t, mm.TraceID, item.TraceID, "every copy keeps the forward's trace ID")
+	}
+	assert.Equal(t, []string{"+14155550001", "+14155550002", "+14155550003"}, to)
+	assert.Equal(t, []string{"tx-1-1", "tx-1-2", "tx-1-3"}, logIDs)
+}
+
+func TestSession_DataOverMaxMessageBytes(t *testing.T) {
+	_, gw := newTestRouter(1)
+	gw.Config.MM4MaxMessageBytes = 64
+	srv := &MM4Server{gateway: gw, MediaTranscodeChan: make(chan *MM4Message, 1)}
+
+	body := strings.Repeat("QUFB", 16) + "A" // 65 bytes
+	in := strings.Join([]string{
+		"EHLO mmsc.carrier.example",
+		"MAIL FROM:<+12505551234/TYPE=PLMN@mms.example.com>",
+		"RCPT TO:<+14155550001/TYPE=PLMN@mms.example.com>",
+		"DATA",
+		"X-Mms-3GPP-MMS-Version: 6.10.0",
+		"Content-Type: text/plain",
+		"",
+		body,
+		".",
+		"NOOP",
+		"QUIT",
+	}, "\r\n") + "\r\n"
+
+	var out bytes.Buffer
+	s := &Session{
+		Server:   srv,
+		Client:   &Client{Username: "pbx1"},
+		ClientIP: "192.0.2.10",
+		Reader:   bufio.NewReader(strings.NewReader(in)),
+		Writer:   bufio.NewWriter(&out),
+	}
+	require.Error(t, s.handleSession(srv), "ends on QUIT")
+
+	replies := strings.Split(strings.TrimSpace(out.String()), "\r\n")
+	require.Len(t, replies, 7)
+	assert.True(t, strings.HasPrefix(replies[4], "552 "), replies[4])
+	assert.Equal(t, "250 OK", replies[5], "the rest of the body is not read as commands")
+	assert.Nil(t, s.Data)
+	assert.Empty(t, srv.MediaTranscodeChan)
+}
+
+func TestSession_DataHeadersOverMaxMessageBytes(t *testing.T) {
+	_, gw := newTestRouter(1)
+	gw.Config.MM4MaxMessageBytes = 64
+	srv := &MM4Server{gateway: gw, MediaTranscodeChan: make(chan *MM4Message, 1)}
+
+	lines := []string{
+		"EHLO mmsc.carrier.example",
+		"MAIL FROM:<+12505551234/TYPE=PLMN@mms.example.com>",
+		"RCPT TO:<+14155550001/TYPE=PLMN@mms.example.com>",
+		"DATA",
+	}
+	for i := 0; i < 10; i++ {
+		lines = append(lines, fmt.Sprintf("X-Padding-%d: %s", i, strings.Repeat("x", 20)))
+	}
+	lines = append(lines, "", "body", ".", "NOOP", "QUIT")
+	in := strings.Join(lines, "\r\n") + "\r\n"
+
+	var out bytes.Buffer
+	s := &Session{
+		Server:   srv,
+		Client:   &Client{Username: "pbx1"},
+		ClientIP: "192.0.2.10",
+		Reader:   bufio.NewReader(strings.NewReader(in)),
+		Writer:   bufio.NewWriter(&out),
+	}
+	require.Error(t, s.handleSession(srv), "ends on QUIT")
+
+	replies := strings.Split(strings.TrimSpace(out.String()), "\r\n")
+	require.Len(t, replies, 7)
+	assert.True(t, strings.HasPrefix(replies[4], "552 "), replies[4])
+	assert.Equal(t, "250 OK", replies[5], "the rest of the message is not read as commands")
+	assert.Nil(t, s.Data)
+	assert.Empty(t, srv.MediaTranscodeChan)
+}
+
+// bufferedMM4Body builds the message the way sendMM4Message did before it
+// streamed, as a reference for writeMM4Body.
+func bufferedMM4Body(s *Session, boundary string, contentID func() string) string {
+	var messageBuffer bytes.Buffer
+
+	messageBuffer.WriteString(fmt.Sprintf("To: %s\r\n", strings.Join(s.To, ", ")))
+	messageBuffer.WriteString(fmt.Sprintf("From: %s\r\n", s.From))
+	messageBuffer.WriteString(fmt.Sprintf("Content-Type: multipart/related; boundary=\"%s\"\r\n", boundary))
+	messageBuffer.WriteString("MIME-Version: 1.0\r\n")
+	for _, header := range []string{
+		"X-Mms-3GPP-Mms-Version", "X-Mms-message-Type", "X-Mms-message-Id", "X-Mms-Transaction-Id",
+		"X-Mms-Ack-Request", "X-Mms-Originator-System", "Date", "message-ID",
+	} {
+		if value := s.Headers.Get(header); value != "" {
+			messageBuffer.WriteString(fmt.Sprintf("%s: %s\r\n", header, value))
+		}
+	}
+	messageBuffer.WriteString("\r\n")
+
+	smilContent, _ := generateSMIL(s.Files, s.Server.SMILLayout)
+	messageBuffer.WriteString(fmt.Sprintf("--%s\r\n", boundary))
+	messageBuffer.WriteString("Content-Id: <0.smil>\r\n")
+	messageBuffer.WriteString("Content-Type: application/smil; name=\"0.smil\"\r\n")
+	messageBuffer.WriteString("\r\n")
+	messageBuffer.Write(smilContent)
+	messageBuffer.WriteString("\r\n")
+
+	for _, file := range s.Files {
+		if file.ContentType == "application/smil" {
+			continue
+		}
+		messageBuffer.WriteString(fmt.Sprintf("--%s\r\n", boundary))
+		messageBuffer.WriteString(fmt.Sprintf("Content-Id: <%s>\r\n", contentID()))
+		messageBuffer.WriteString(fmt.Sprintf("Content-Type: %s; name=\"%s\"\r\n", file.ContentType, file.Filename))
+		messageBuffer.WriteString(fmt.Sprintf("Content-Disposition: attachment; filename=\"%s\"\r\n", file.Filename))
+		messageBuffer.WriteString(fmt.Sprintf("Content-Location: %s\r\n", file.Filename))
+		messageBuffer.WriteString("Content-Transfer-Encoding: base64\r\n")
+		messageBuffer.WriteString("\r\n")
+
+		encoded := base64.StdEncoding.EncodeToString(file.Content)
+		for i := 0; i < len(encoded); i += 76 {
+			end := i + 76
+			if end > len(encoded) {
+				end = len(encoded)
+			}
+			messageBuffer.WriteString(encoded[i:end] + "\r\n")
+		}
+	}
+	messageBuffer.WriteString(fmt.Sprintf("--%s--\r\n", boundary))
+	return messageBuffer.String()
+}
+
+func TestSession_WriteMM4BodyMatchesBuffered(t *testing.T) {
+	payload := func(n int) []byte {
+		b := make([]byte, n)
+		_, _ = rand.New(rand.NewSource(int64(n))).Read(b)
+		return b
+	}
+	s := &Session{
+		Server: &MM4Server{SMILLayout: smilLayout{Width: 320, Height: 480}},
+		From:   "+14155559876/TYPE=PLMN",
+		To:     []string{"+12505551234/TYPE=PLMN"},
+		Headers: textproto.MIMEHeader{
+			"X-Mms-3gpp-Mms-Version": {"6.10.0"},
+			"X-Mms-Message-Type":     {MM4ForwardReq},
+			"X-Mms-Transaction-Id":   {"tx-1"},
+		},
+		Files: []MsgFile{
+			{Filename: "photo.jpg", ContentType: "image/jpeg", Content: payload(300 * 1024)},
+			{Filename: "clip.amr", ContentType: "audio/amr", Content: payload(57 * 40)}, // whole lines only
+			{Filename: "text.txt", ContentType: "text/plain", Content: []byte("hello")},
+			{Filename: "empty.bin", ContentType: "application/octet-stream"},
+		},
+	}
+	ids := func() func() string {
+		n := 0
+		return func() string { n++; return fmt.Sprintf("part-%d", n) }
+	}
+
+	want := bufferedMM4Body(s, "=====b", ids())
+
+	var out bytes.Buffer
+	dw := &dotWriter{w: &out}
+	require.NoError(t, s.writeMM4Body(dw, "=====b", ids()))
+	require.NoError(t, dw.Close())
+
+	assert.Equal(t, want+".\r\n", out.String())
+}
+
+func TestDotWriter_StuffsLeadingDots(t *testing.T) {
+	var out bytes.Buffer
+	dw := &dotWriter{w: &out}
+	for _, chunk := range []string{".first\r\nmid.dle\r\n", ".", "split\n", "..two\r\nno newline"} {
+		_, err := dw.Write([]byte(chunk))
+		require.NoError(t, err)
+	}
+	require.NoError(t, dw.Close())
+
+	assert.Equal(t, "..first\r\nmid.dle\r\n..split\n...two\r\nno newline\r\n.\r\n", out.String())
+
+	body, err := io.ReadAll(textproto.NewReader(bufio.NewReader(&out)).DotReader())
+	require.NoError(t, err)
+	assert.Equal(t, ".first\nmid.dle\n.split\n..two\nno newline\n", string(body))
+}
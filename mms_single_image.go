@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Values of MM4_SINGLE_IMAGE and the mm4_single_image client setting.
+const (
+	// MM4SingleImageSMIL sends every MMS as multipart/related with a SMIL
+	// presentation.
+	MM4SingleImageSMIL = "smil"
+	// MM4SingleImageMixed sends an MMS whose only part is an image as a plain
+	// multipart/mixed message without SMIL, which some legacy handsets
+	// otherwise render as a blank slideshow.
+	MM4SingleImageMixed = "mixed"
+)
+
+// validMM4SingleImage reports whether mode is a single image mode, or empty.
+func validMM4SingleImage(mode string) bool {
+	switch mode {
+	case "", MM4SingleImageSMIL, MM4SingleImageMixed:
+		return true
+	}
+	return false
+}
+
+// mm4SingleImageMode resolves how a lone image is delivered to a client: its
+// own setting where set, otherwise MM4_SINGLE_IMAGE.
+func (gateway *Gateway) mm4SingleImageMode(client *Client) string {
+	if client != nil && client.Settings != nil && client.Settings.MM4SingleImage != "" {
+		return client.Settings.MM4SingleImage
+	}
+	if gateway.Config.MM4SingleImage != "" {
+		return gateway.Config.MM4SingleImage
+	}
+	return MM4SingleImageSMIL
+}
+
+// singleImage returns the image in files if it is the only part besides SMIL.
+func singleImage(files []MsgFile) (MsgFile, bool) {
+	var image MsgFile
+	count := 0
+	for _, file := range files {
+		if file.ContentType == "application/smil" {
+			continue
+		}
+		count++
+		image = file
+	}
+	if count != 1 || !strings.HasPrefix(strings.ToLower(image.ContentType), "image/") {
+		return MsgFile{}, false
+	}
+	return image, true
+}
+
+// writeMM4MixedBody writes the session's lone image to w as a
+// multipart/mixed message with no SMIL part. Like writeMM4Body, the output
+// is not dot-stuffed.
+func (s *Session) writeMM4MixedBody(w io.Writer, boundary string, contentID func() string) error {
+	image, ok := singleImage(s.Files)
+	if !ok {
+		return fmt.Errorf("message is not a single image")
+	}
+
+	var header bytes.Buffer
+	s.writeMM4Headers(&header, fmt.Sprintf("multipart/mixed; boundary=\"%s\"", boundary))
+
+	header.WriteString(fmt.Sprintf("--%s\r\n", boundary))
+	header.WriteString(fmt.Sprintf("Content-Id: <%s>\r\n", contentID()))
+	header.WriteString(fmt.Sprintf("Content-Type: %s; name=\"%s\"\r\n", image.ContentType, image.Filename))
+	header.WriteString(fmt.Sprintf("Content-Disposition: inline; filename=\"%s\"\r\n", image.Filename))
+	header.WriteString(fmt.Sprintf("Content-Location: %s\r\n", image.Filename))
+	header.WriteString("Content-Transfer-Encoding: base64\r\n")
+	header.WriteString("\r\n")
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+
+	if err := writeBase64Lines(w, image.Content); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "--%s--\r\n", boundary)
+	return err
+}
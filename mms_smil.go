@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	defaultSMILWidth  = 320
+	defaultSMILHeight = 480
+
+	smilImageDur = "5000ms" // slides with a still image or text
+)
+
+// smilLayout is the root-layout of generated SMIL (MMS_SMIL_WIDTH/HEIGHT).
+type smilLayout struct {
+	Width  int
+	Height int
+}
+
+func loadSMILLayout(env *envReader) smilLayout {
+	return smilLayout{
+		Width:  env.int("MMS_SMIL_WIDTH", defaultSMILWidth, 1),
+		Height: env.int("MMS_SMIL_HEIGHT", defaultSMILHeight, 1),
+	}
+}
+
+// smilPartRank orders slides image → text → audio → video → anything else.
+func smilPartRank(contentType string) int {
+	switch {
+	case strings.HasPrefix(contentType, "image/"):
+		return 0
+	case strings.HasPrefix(contentType, "text/"):
+		return 1
+	case strings.HasPrefix(contentType, "audio/"):
+		return 2
+	case strings.HasPrefix(contentType, "video/"):
+		return 3
+	}
+	return 4
+}
+
+func smilEscape(s string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// generateSMIL generates a SMIL presentation with one slide per media file.
+// Images and video share the Image region and text uses the Text region
+// below it; audio has no region. Still slides last smilImageDur, while
+// audio and video slides run for the length of the clip.
+func generateSMIL(files []MsgFile, layout smilLayout) ([]byte, error) {
+	var parts []MsgFile
+	for _, file := range files {
+		if file.ContentType != "application/smil" {
+			parts = append(parts, file)
+		}
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("no media files to include in SMIL")
+	}
+	sort.SliceStable(parts, func(i, j int) bool {
+		return smilPartRank(parts[i].ContentType) < smilPartRank(parts[j].ContentType)
+	})
+
+	if layout.Width <= 0 {
+		layout.Width = defaultSMILWidth
+	}
+	if layout.Height <= 0 {
+		layout.Height = defaultSMILHeight
+	}
+
+	hasText := false
+	for _, part := range parts {
+		if smilPartRank(part.ContentType) == 1 {
+			hasText = true
+			break
+		}
+	}
+	imageHeight := "100%"
+	if hasText {
+		imageHeight = "70%"
+	}
+
+	var smilBuffer bytes.Buffer
+	smilBuffer.WriteString("<smil>\n<head>\n")
+	smilBuffer.WriteString("<layout>\n")
+	smilBuffer.WriteString(fmt.Sprintf("<root-layout width=\"%dpx\" height=\"%dpx\"/>\n", layout.Width, layout.Height))
+	smilBuffer.WriteString(fmt.Sprintf("<region id=\"Image\" top=\"0\" left=\"0\" width=\"100%%\" height=\"%s\" fit=\"meet\"/>\n", imageHeight))
+	if hasText {
+		smilBuffer.WriteString("<region id=\"Text\" top=\"70%\" left=\"0\" width=\"100%\" height=\"30%\" fit=\"scroll\"/>\n")
+	}
+	smilBuffer.WriteString("</layout>\n")
+	smilBuffer.WriteString("</head>\n")
+	smilBuffer.WriteString("<body>\n")
+
+	for _, part := range parts {
+		src := smilEscape(part.Filename)
+		switch smilPartRank(part.ContentType) {
+		case 0:
+			smilBuffer.WriteString(fmt.Sprintf("<par dur=\"%s\">\n", smilImageDur))
+			smilBuffer.WriteString(fmt.Sprintf("<img src=\"%s\" region=\"Image\"/>\n", src))
+		case 1:
+			smilBuffer.WriteString(fmt.Sprintf("<par dur=\"%s\">\n", smilImageDur))
+			smilBuffer.WriteString(fmt.Sprintf("<text src=\"%s\" region=\"Text\"/>\n", src))
+		case 2:
+			smilBuffer.WriteString("<par>\n")
+			smilBuffer.WriteString(fmt.Sprintf("<audio src=\"%s\"/>\n", src))
+		case 3:
+			smilBuffer.WriteString("<par>\n")
+			smilBuffer.WriteString(fmt.Sprintf("<video src=\"%s\" region=\"Image\"/>\n", src))
+		default:
+			smilBuffer.WriteString(fmt.Sprintf("<par dur=\"%s\">\n", smilImageDur))
+			smilBuffer.WriteString(fmt.Sprintf("<ref src=\"%s\"/>\n", src))
+		}
+		smilBuffer.WriteString("</par>\n")
+	}
+
+	smilBuffer.WriteString("</body>\n")
+	smilBuffer.WriteString("</smil>\n")
+
+	return smilBuffer.Bytes(), nil
+}
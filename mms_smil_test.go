@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateSMIL_ReferencesEveryPart(t *testing.T) {
+	files := []MsgFile{
+		{Filename: "0.smil", ContentType: "application/smil"},
+		{Filename: "clip.3gp", ContentType: "video/3gpp"},
+		{Filename: "voice.amr", ContentType: "audio/amr"},
+		{Filename: "message.txt", ContentType: "text/plain"},
+		{Filename: "photo.jpg", ContentType: "image/jpeg"},
+		{Filename: "card.vcf", ContentType: "text/x-vcard"},
+		{Filename: "doc.pdf", ContentType: "application/pdf"},
+	}
+
+	out, err := generateSMIL(files, smilLayout{Width: 640, Height: 960})
+	require.NoError(t, err)
+	smil := string(out)
+
+	for _, f := range files {
+		if f.ContentType == "application/smil" {
+			assert.NotContains(t, smil, f.Filename)
+			continue
+		}
+		assert.Contains(t, smil, `src="`+f.Filename+`"`, "SMIL must reference %s", f.Filename)
+	}
+
+	assert.Contains(t, smil, `<root-layout width="640px" height="960px"/>`)
+	assert.Contains(t, smil, `<region id="Text"`)
+	assert.Contains(t, smil, `<audio src="voice.amr"/>`)
+	assert.Contains(t, smil, `<video src="clip.3gp" region="Image"/>`)
+
+	// image → text → audio → video → other
+	order := []string{"photo.jpg", "message.txt", "card.vcf", "voice.amr", "clip.3gp", "doc.pdf"}
+	last := -1
+	for _, name := range order {
+		idx := strings.Index(smil, name)
+		assert.Greater(t, idx, last, "%s out of order", name)
+		last = idx
+	}
+}
+
+func TestGenerateSMIL_DefaultsAndEscaping(t *testing.T) {
+	out, err := generateSMIL([]MsgFile{{Filename: `a&b".png`, ContentType: "image/png"}}, smilLayout{})
+	require.NoError(t, err)
+	smil := string(out)
+
+	assert.Contains(t, smil, `<root-layout width="320px" height="480px"/>`)
+	assert.Contains(t, smil, `height="100%"`, "no text region: image fills the layout")
+	assert.NotContains(t, smil, `<region id="Text"`)
+	assert.Contains(t, smil, `src="a&amp;b&#34;.png"`)
+
+	_, err = generateSMIL([]MsgFile{{Filename: "0.smil", ContentType: "application/smil"}}, smilLayout{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// loadMM4TLSConfig builds the STARTTLS config from PEM cert/key paths. With
+// neither path set it returns nil and the MM4 server stays plaintext-only.
+func loadMM4TLSConfig(certPath, keyPath string) (*tls.Config, error) {
+	if certPath == "" && keyPath == "" {
+		return nil, nil
+	}
+	if certPath == "" || keyPath == "" {
+		return nil, errors.New("MM4_TLS_CERT and MM4_TLS_KEY must be set together")
+	}
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load MM4 TLS key pair: %w", err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// startTLS answers STARTTLS and upgrades the session's connection. Per RFC
+// 3207 the session starts over: the client must EHLO again, and anything it
+// sent before the handshake is discarded. A failed handshake ends the session.
+func (s *Session) startTLS() error {
+	config := s.Server.TLS
+	switch {
+	case config == nil:
+		writeResponse(s.Writer, "454 TLS not available")
+		return nil
+	case s.TLS:
+		writeResponse(s.Writer, "503 Bad sequence of commands: TLS already active")
+		return nil
+	}
+	writeResponse(s.Writer, "220 Ready to start TLS")
+
+	conn := tls.Server(s.Conn, config)
+	if err := conn.Handshake(); err != nil {
+		return fmt.Errorf("TLS handshake failed: %w", err)
+	}
+
+	s.Conn = conn
+	s.Reader = bufio.NewReaderSize(conn, 65536)
+	s.Writer = bufio.NewWriter(conn)
+	s.TLS = true
+	s.State = 0
+	s.From = ""
+	s.To = nil
+
+	state := conn.ConnectionState()
+	lm := s.Server.gateway.LogManager
+	lm.SendLog(lm.BuildLog(
+		"Server.MM4.Session",
+		"TLSStarted",
+		logrus.InfoLevel,
+		map[string]interface{}{
+			"client":       safeClientUsername(s.Client),
+			"ip":           s.ClientIP,
+			"session_id":   s.SessionID,
+			"tls_version":  tls.VersionName(state.Version),
+			"cipher_suite": tls.CipherSuiteName(state.CipherSuite),
+		},
+	))
+	return nil
+}
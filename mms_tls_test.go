@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMM4TLSConfig(t *testing.T) {
+	config, err := loadMM4TLSConfig("", "")
+	assert.NoError(t, err)
+	assert.Nil(t, config, "plaintext only when unconfigured")
+
+	_, err = loadMM4TLSConfig("/tmp/cert", "")
+	assert.Error(t, err)
+	_, err = loadMM4TLSConfig("/nonexistent/cert", "/nonexistent/key")
+	assert.Error(t, err)
+
+	certPath, keyPath := writeTestKeyPair(t)
+	config, err = loadMM4TLSConfig(certPath, keyPath)
+	require.NoError(t, err)
+	assert.Len(t, config.Certificates, 1)
+}
+
+// startMM4Session runs an inbound session for srv on one end of a pipe and
+// returns the other end for the test to act as the peer MMSC.
+func startMM4Session(t *testing.T, srv *MM4Server) (net.Conn, <-chan error) {
+	t.Helper()
+	serverConn, peerConn := net.Pipe()
+	t.Cleanup(func() { peerConn.Close() })
+
+	s := &Session{
+		Conn:     serverConn,
+		Reader:   bufio.NewReader(serverConn),
+		Writer:   bufio.NewWriter(serverConn),
+		Server:   srv,
+		Client:   &Client{Username: "mmsc1"},
+		ClientIP: "192.0.2.20",
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.handleSession(srv)
+		serverConn.Close()
+	}()
+	return peerConn, done
+}
+
+func TestSession_STARTTLSThenForward(t *testing.T) {
+	certPath, keyPath := writeTestKeyPair(t)
+	config, err := loadMM4TLSConfig(certPath, keyPath)
+	require.NoError(t, err)
+
+	_, gw := newTestRouter(1)
+	srv := &MM4Server{gateway: gw, TLS: config, MediaTranscodeChan: make(chan *MM4Message, 1)}
+	peerConn, done := startMM4Session(t, srv)
+
+	peer := textproto.NewConn(peerConn)
+	require.NoError(t, peer.PrintfLine("EHLO mmsc.carrier.example"))
+	_, msg, err := peer.ReadResponse(250)
+	require.NoError(t, err)
+	assert.Contains(t, msg, "STARTTLS")
+
+	require.NoError(t, peer.PrintfLine("STARTTLS"))
+	_, _, err = peer.ReadResponse(220)
+	require.NoError(t, err)
+
+	tlsConn := tls.Client(peerConn, &tls.Config{InsecureSkipVerify: true})
+	require.NoError(t, tlsConn.Handshake())
+	peer = textproto.NewConn(tlsConn)
+
+	// The session starts over after the upgrade.
+	require.NoError(t, peer.PrintfLine("MAIL FROM:<+12505551234/TYPE=PLMN@mms.example.com>"))
+	_, _, err = peer.ReadResponse(503)
+	require.NoError(t, err)
+
+	require.NoError(t, peer.PrintfLine("EHLO mmsc.carrier.example"))
+	_, msg, err = peer.ReadResponse(250)
+	require.NoError(t, err)
+	assert.NotContains(t, msg, "STARTTLS", "not offered twice")
+
+	for _, cmd := range []string{
+		"MAIL FROM:<+12505551234/TYPE=PLMN@mms.example.com>",
+		"RCPT TO:<+14155550001/TYPE=PLMN@mms.example.com>",
+	} {
+		require.NoError(t, peer.PrintfLine("%s", cmd))
+		_, _, err = peer.ReadResponse(250)
+		require.NoError(t, err, cmd)
+	}
+	require.NoError(t, peer.PrintfLine("DATA"))
+	_, _, err = peer.ReadResponse(354)
+	require.NoError(t, err)
+
+	w := peer.DotWriter()
+	_, err = w.Write([]byte("X-Mms-3GPP-MMS-Version: 6.10.0\r\n" +
+		"X-Mms-Message-Type: MM4_forward.REQ\r\n" +
+		"X-Mms-Transaction-ID: tx-tls\r\n" +
+		"X-Mms-Message-ID: <tx-tls@mms.example.com>\r\n" +
+		"From: +12505551234/TYPE=PLMN\r\n" +
+		"To: +14155550001/TYPE=PLMN\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"aGVsbG8gb3ZlciB0bHM=\r\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	_, _, err = peer.ReadResponse(250)
+	require.NoError(t, err)
+
+	select {
+	case mm := <-srv.MediaTranscodeChan:
+		assert.Equal(t, "tx-tls", mm.TransactionID)
+		assert.Equal(t, "+12505551234", mm.From)
+	case <-time.After(time.Second):
+		t.Fatal("forward was not queued")
+	}
+
+	require.NoError(t, peer.PrintfLine("QUIT"))
+	_, _, err = peer.ReadResponse(221)
+	require.NoError(t, err)
+	assert.Error(t, <-done, "session ends on QUIT")
+}
+
+func TestSession_STARTTLSUnconfigured(t *testing.T) {
+	_, gw := newTestRouter(1)
+	srv := &MM4Server{gateway: gw}
+	peerConn, _ := startMM4Session(t, srv)
+
+	peer := textproto.NewConn(peerConn)
+	require.NoError(t, peer.PrintfLine("EHLO mmsc.carrier.example"))
+	_, msg, err := peer.ReadResponse(250)
+	require.NoError(t, err)
+	assert.NotContains(t, msg, "STARTTLS")
+
+	require.NoError(t, peer.PrintfLine("STARTTLS"))
+	_, _, err = peer.ReadResponse(454)
+	require.NoError(t, err)
+
+	// The plaintext session carries on.
+	require.NoError(t, peer.PrintfLine("NOOP"))
+	_, _, err = peer.ReadResponse(250)
+	require.NoError(t, err)
+}
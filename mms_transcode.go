@@ -13,6 +13,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"time"
 
@@ -41,11 +42,55 @@ const (
 	maxVideoSize = 1 * 1024 * 1024 // 1 MB - video output after transcoding
 	maxOtherSize = 600 * 1024      // 600 KB - other file types (PDFs, docs, etc.)
 
-	// Legacy aliases
-	maxImageSize = targetOutputSize
-	maxFileSize  = targetOutputSize
+	// Hard cap on original media forwarded to clients with transcoding off
+	defaultPassthroughMaxBytes = 5 * 1024 * 1024 // 5 MB
 )
 
+// TranscodeConfig holds the output size limits applied by the transcoder.
+// Carriers differ in what they accept, so these are configurable per deployment.
+type TranscodeConfig struct {
+	MaxImageBytes int // MMS_MAX_IMAGE_BYTES - JPEG/PNG compression target
+	MaxVideoBytes int // MMS_MAX_VIDEO_BYTES - 3GPP output limit
+	MaxFileBytes  int // MMS_MAX_FILE_BYTES - audio and other file types
+
+	// MMS_MAX_IMAGE_DIMENSION - longest image side in pixels; larger images
+	// are halved until they fit. 0 leaves dimensions to the size search.
+	MaxImageDimension int
+
+	// MMS_AUDIO_CODEC - forced audio codec keyed by outbound path ("*" = all).
+	// Paths without an entry pass through AMR/AAC that fits MaxFileBytes.
+	AudioCodecs map[string]string
+
+	Workers int // TRANSCODE_WORKERS - messages transcoded at once
+
+	// MMS_PASSTHROUGH_MAX_BYTES - total media forwarded untouched to clients
+	// with transcode_media off; larger messages are transcoded anyway.
+	PassthroughMaxBytes int
+
+	// MMS_ALLOWED_TYPES - media types forwarded after transcoding, keyed by
+	// lower-cased base type; "type/*" entries allow a whole top-level type.
+	AllowedTypes map[string]bool
+
+	TempPath string // TRANSCODE_TEMP_PATH - ffmpeg work files
+}
+
+// loadTranscodeConfig reads size limits from the environment, falling back to
+// targetOutputSize for anything unset. The worker count defaults to the
+// number of CPUs.
+func loadTranscodeConfig(env *envReader) TranscodeConfig {
+	return TranscodeConfig{
+		MaxImageBytes:       env.int("MMS_MAX_IMAGE_BYTES", targetOutputSize, 1),
+		MaxVideoBytes:       env.int("MMS_MAX_VIDEO_BYTES", targetOutputSize, 1),
+		MaxFileBytes:        env.int("MMS_MAX_FILE_BYTES", targetOutputSize, 1),
+		MaxImageDimension:   env.int("MMS_MAX_IMAGE_DIMENSION", 0, 0),
+		AudioCodecs:         parseAudioCodecs(env.str("MMS_AUDIO_CODEC", "")),
+		Workers:             env.int("TRANSCODE_WORKERS", runtime.NumCPU(), 1),
+		PassthroughMaxBytes: env.int("MMS_PASSTHROUGH_MAX_BYTES", defaultPassthroughMaxBytes, 1),
+		AllowedTypes:        parseAllowedTypes(env.str("MMS_ALLOWED_TYPES", "")),
+		TempPath:            env.str("TRANSCODE_TEMP_PATH", os.TempDir()),
+	}
+}
+
 // TranscodeError provides user-friendly error messages for MMS transcoding failures
 type TranscodeError struct {
 	Code        string // Machine-readable error code
@@ -74,6 +119,11 @@ var (
 		UserMessage: "Your media couldn't be compressed enough for MMS. Please try a smaller file.",
 		Details:     "failed to compress media to target size",
 	}
+	ErrAttachmentTooLarge = TranscodeError{
+		Code:        "ATTACHMENT_TOO_LARGE",
+		UserMessage: "Your attachment is too large for MMS and documents can't be compressed. Please send a smaller file.",
+		Details:     "attachment exceeds size limit",
+	}
 	ErrUnsupportedFormat = TranscodeError{
 		Code:        "UNSUPPORTED_FORMAT",
 		UserMessage: "This file type is not supported for MMS. Supported formats: JPEG, PNG, GIF, MP4, 3GP.",
@@ -94,6 +144,7 @@ type MsgFile struct {
 func safeClientInfo(m *MM4Message) map[string]interface{} {
 	fields := map[string]interface{}{
 		"transaction_id": m.TransactionID,
+		"trace_id":       m.TraceID,
 		"message_id":     m.MessageID,
 		"from":           m.From,
 		"to":             m.To,
@@ -104,175 +155,270 @@ func safeClientInfo(m *MM4Message) map[string]interface{} {
 	return fields
 }
 
-func (s *MM4Server) transcodeMedia() {
+// transcodeMedia starts the transcode worker pool on MediaTranscodeChan.
+func (s *MM4Server) transcodeMedia(workers int) {
+	runTranscodeWorkers(workers, s.MediaTranscodeChan, s.transcodeMessage)
+}
+
+// runTranscodeWorkers processes messages from in with the given number of
+// goroutines. Each message is handled by exactly one worker.
+func runTranscodeWorkers(workers int, in <-chan *MM4Message, process func(*MM4Message)) {
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for mm4Message := range in {
+				process(mm4Message)
+			}
+		}()
+	}
+}
+
+// transcodeMessage transcodes one message's media and queues the result, or
+// replies to the sender with an error. Panics are recovered per message.
+func (s *MM4Server) transcodeMessage(mm4Message *MM4Message) {
 	lm := s.gateway.LogManager
 
-	for {
-		mm4Message := <-s.MediaTranscodeChan
+	if mm4Message.TraceID == "" {
+		mm4Message.TraceID = newTraceID()
+	}
 
-		start := time.Now()
-		baseFields := safeClientInfo(mm4Message)
-		baseFields["file_count"] = len(mm4Message.Files)
+	start := time.Now()
+	baseFields := safeClientInfo(mm4Message)
+	baseFields["file_count"] = len(mm4Message.Files)
+
+	lm.SendLog(lm.BuildLog(
+		"Server.MM4.TranscodeMedia",
+		"TranscodeStart",
+		logrus.DebugLevel,
+		baseFields,
+	))
 
+	// Per-file summary before processing
+	for i, f := range mm4Message.Files {
 		lm.SendLog(lm.BuildLog(
 			"Server.MM4.TranscodeMedia",
-			"TranscodeStart",
+			"InputFileSummary",
 			logrus.DebugLevel,
-			baseFields,
+			map[string]interface{}{
+				"transaction_id": mm4Message.TransactionID,
+				"trace_id":       mm4Message.TraceID,
+				"index":          i,
+				"filename":       f.Filename,
+				"content_type":   f.ContentType,
+				"size_bytes":     len(f.Content),
+			},
 		))
+	}
 
-		// Per-file summary before processing
-		for i, f := range mm4Message.Files {
-			lm.SendLog(lm.BuildLog(
-				"Server.MM4.TranscodeMedia",
-				"InputFileSummary",
-				logrus.DebugLevel,
-				map[string]interface{}{
-					"transaction_id": mm4Message.TransactionID,
-					"index":          i,
-					"filename":       f.Filename,
-					"content_type":   f.ContentType,
-					"size_bytes":     len(f.Content),
-				},
-			))
-		}
-
-		// Panic guard around media processing
-		func() {
-			defer func() {
-				if r := recover(); r != nil {
-					lm.SendLog(lm.BuildLog(
-						"Server.MM4.TranscodeMedia",
-						"PanicRecovered",
-						logrus.ErrorLevel,
-						map[string]interface{}{
-							"transaction_id": mm4Message.TransactionID,
-							"panic":          fmt.Sprintf("%v", r),
-							"duration_ms":    time.Since(start).Milliseconds(),
-						},
-					))
+	// Panic guard around media processing
+	func() {
+		defer func() { transcodeDuration.Observe(time.Since(start).Seconds()) }()
+		defer func() {
+			if r := recover(); r != nil {
+				lm.SendLog(lm.BuildLog(
+					"Server.MM4.TranscodeMedia",
+					"PanicRecovered",
+					logrus.ErrorLevel,
+					map[string]interface{}{
+						"transaction_id": mm4Message.TransactionID,
+						"trace_id":       mm4Message.TraceID,
+						"panic":          fmt.Sprintf("%v", r),
+						"duration_ms":    time.Since(start).Milliseconds(),
+					},
+				))
 
-					msg := &MsgQueueItem{
-						To:              mm4Message.From,
-						From:            mm4Message.To,
-						Type:            "sms",
-						message:         "An internal error occurred while processing your media. Please try again later. ID: " + mm4Message.TransactionID,
-						SkipNumberCheck: false,
-						LogID:           mm4Message.TransactionID,
-						Delivery: &MsgQueueDelivery{
-							Error:      "discard after first attempt (panic)",
-							RetryTime:  time.Now(),
-							RetryCount: 666,
-						},
-					}
-					s.gateway.Router.CarrierMsgChan <- *msg
+				if reply, ok := s.gateway.transcodePanicReply(mm4Message.Client, mm4Message.TransactionID); ok {
+					s.gateway.Router.CarrierMsgChan <- transcodeReplyItem(mm4Message, reply, "discard after first attempt (panic)")
 				}
-			}()
+			}
+		}()
 
-			ff, originalSizeBytes, err := mm4Message.processAndConvertFiles(lm)
+		var (
+			ff                []MsgFile
+			originalSizeBytes int
+			err               error
+			passthrough       bool
+		)
+		if s.skipTranscoding(mm4Message) {
+			ff, originalSizeBytes, err = mm4Message.passthroughFiles(s.TranscodeConfig.PassthroughMaxBytes)
+			passthrough = err == nil
 			if err != nil {
-				// scrub large / sensitive stuff before logging
-				mm4Message.Files = nil
-				mm4Message.Content = nil
-				if mm4Message.Client != nil {
-					mm4Message.Client.Password = "***"
-				}
-
-				errFields := safeClientInfo(mm4Message)
-				errFields["logID"] = mm4Message.TransactionID
-
+				fields := safeClientInfo(mm4Message)
+				fields["max_bytes"] = s.TranscodeConfig.PassthroughMaxBytes
 				lm.SendLog(lm.BuildLog(
 					"Server.MM4.TranscodeMedia",
-					"TranscodeError",
-					logrus.ErrorLevel,
-					errFields,
+					"PassthroughFallback",
+					logrus.WarnLevel,
+					fields,
 					err,
 				))
+			}
+		}
+		if !passthrough {
+			ff, originalSizeBytes, err = mm4Message.processAndConvertFiles(lm, s.TranscodeConfig, s.outboundMediaPath(mm4Message))
+		}
+		if err == nil {
+			ff, err = mm4Message.filterAllowedParts(lm, s.TranscodeConfig, ff)
+		}
+		if err != nil {
+			// safeClientInfo copies only identifiers into the log fields;
+			// the message and its shared Client are left untouched.
+			mm4Message.Files = nil
+			mm4Message.Content = nil
 
-				// Use user-friendly message from TranscodeError if available
-				userMsg := "An error occurred. Please try again later or contact support. ID: " + mm4Message.TransactionID
-				if te, ok := err.(TranscodeError); ok {
-					userMsg = te.UserMessage + " ID: " + mm4Message.TransactionID
-					errFields["error_code"] = te.Code
-				}
+			errFields := safeClientInfo(mm4Message)
+			errFields["logID"] = mm4Message.TransactionID
 
-				msg := &MsgQueueItem{
-					To:              mm4Message.From,
-					From:            mm4Message.To,
-					Type:            "sms",
-					message:         userMsg,
-					SkipNumberCheck: false,
-					LogID:           mm4Message.TransactionID,
-					Delivery: &MsgQueueDelivery{
-						Error:      "discard after first attempt",
-						RetryTime:  time.Now(),
-						RetryCount: 666,
-					},
-				}
+			lm.SendLog(lm.BuildLog(
+				"Server.MM4.TranscodeMedia",
+				"TranscodeError",
+				logrus.ErrorLevel,
+				errFields,
+				err,
+			))
 
-				s.gateway.Router.CarrierMsgChan <- *msg
-				return
+			// The reply uses the user-friendly message of a TranscodeError if available
+			if reply, ok := s.gateway.transcodeFailureReply(mm4Message.Client, mm4Message.TransactionID, err); ok {
+				s.gateway.Router.CarrierMsgChan <- transcodeReplyItem(mm4Message, reply, "discard after first attempt")
 			}
+			return
+		}
 
-			// Calculate total transcoded size
-			var totalTranscodedSize int
-			for _, f := range ff {
-				totalTranscodedSize += len(f.Content)
-			}
+		// Calculate total transcoded size
+		var totalTranscodedSize int
+		for _, f := range ff {
+			totalTranscodedSize += len(f.Content)
+		}
 
-			// Calculate overall compression ratio
-			var overallCompressionPct float64
-			if originalSizeBytes > 0 {
-				overallCompressionPct = float64(originalSizeBytes-totalTranscodedSize) / float64(originalSizeBytes) * 100
-			}
+		// Calculate overall compression ratio
+		var overallCompressionPct float64
+		if originalSizeBytes > 0 {
+			overallCompressionPct = float64(originalSizeBytes-totalTranscodedSize) / float64(originalSizeBytes) * 100
+		}
+
+		lm.SendLog(lm.BuildLog(
+			"Server.MM4.TranscodeMedia",
+			"TranscodeSuccess",
+			logrus.InfoLevel,
+			map[string]interface{}{
+				"transaction_id":         mm4Message.TransactionID,
+				"trace_id":               mm4Message.TraceID,
+				"file_count":             len(ff),
+				"original_total_bytes":   originalSizeBytes,
+				"transcoded_total_bytes": totalTranscodedSize,
+				"size_delta_bytes":       originalSizeBytes - totalTranscodedSize,
+				"compression_pct":        fmt.Sprintf("%.1f%%", overallCompressionPct),
+				"duration_ms":            time.Since(start).Milliseconds(),
+				"passthrough":            passthrough,
+			},
+		))
 
+		for i, f := range ff {
 			lm.SendLog(lm.BuildLog(
 				"Server.MM4.TranscodeMedia",
-				"TranscodeSuccess",
-				logrus.InfoLevel,
+				"OutputFileSummary",
+				logrus.DebugLevel,
 				map[string]interface{}{
-					"transaction_id":         mm4Message.TransactionID,
-					"file_count":             len(ff),
-					"original_total_bytes":   originalSizeBytes,
-					"transcoded_total_bytes": totalTranscodedSize,
-					"size_delta_bytes":       originalSizeBytes - totalTranscodedSize,
-					"compression_pct":        fmt.Sprintf("%.1f%%", overallCompressionPct),
-					"duration_ms":            time.Since(start).Milliseconds(),
+					"transaction_id": mm4Message.TransactionID,
+					"trace_id":       mm4Message.TraceID,
+					"index":          i,
+					"filename":       f.Filename,
+					"content_type":   f.ContentType,
+					"size_bytes":     len(f.Content),
 				},
 			))
+		}
 
-			for i, f := range ff {
-				lm.SendLog(lm.BuildLog(
-					"Server.MM4.TranscodeMedia",
-					"OutputFileSummary",
-					logrus.DebugLevel,
-					map[string]interface{}{
-						"transaction_id": mm4Message.TransactionID,
-						"index":          i,
-						"filename":       f.Filename,
-						"content_type":   f.ContentType,
-						"size_bytes":     len(f.Content),
-					},
-				))
-			}
+		// Transcoding counts towards delivery latency, not queue wait.
+		queued := time.Now()
+		received := mm4Message.ReceivedAt
+		if received.IsZero() {
+			received = queued
+		}
 
+		// One item per recipient, sharing the transcoded media. Each gets
+		// its own log ID so records and receipts stay separate.
+		recipients := mm4Message.Recipients
+		if len(recipients) == 0 {
+			recipients = []string{mm4Message.To}
+		}
+		for i, to := range recipients {
+			logID := mm4Message.TransactionID
+			if len(recipients) > 1 {
+				logID = fmt.Sprintf("%s-%d", mm4Message.TransactionID, i+1)
+			}
 			msgItem := MsgQueueItem{
-				To:                mm4Message.To,
+				To:                to,
 				From:              mm4Message.From,
-				ReceivedTimestamp: time.Now(),
+				ReceivedTimestamp: received,
+				QueuedTimestamp:   queued,
 				Type:              MsgQueueItemType.MMS,
-				files:             ff,
-				LogID:             mm4Message.TransactionID,
+				files:             append([]MsgFile(nil), ff...),
+				LogID:             logID,
+				TraceID:           mm4Message.TraceID,
 				OriginalSizeBytes: originalSizeBytes,
+				Priority:          s.gateway.msgPriority(mm4Message.Client, MsgQueueItemType.MMS),
 			}
 
-			s.gateway.Router.ClientMsgChan <- msgItem
-		}()
+			s.gateway.Router.clientQueue(msgItem.Priority) <- msgItem
+		}
+	}()
+}
+
+// skipTranscoding reports whether every destination of m is a client with
+// transcode_media turned off. Media is transcoded once per message, so a
+// message that also goes to a carrier or another client is still transcoded.
+func (s *MM4Server) skipTranscoding(m *MM4Message) bool {
+	recipients := m.Recipients
+	if len(recipients) == 0 {
+		recipients = []string{m.To}
+	}
+	for _, to := range recipients {
+		client, _ := s.gateway.Router.findClientByNumber(to)
+		if client == nil || client.TranscodeMedia {
+			return false
+		}
 	}
+	return true
 }
 
-// List of compatible MIME types
+// passthroughFiles decodes m's media without recompressing it. It fails when
+// the decoded total exceeds maxBytes, so the caller can transcode instead.
+func (m *MM4Message) passthroughFiles(maxBytes int) ([]MsgFile, int, error) {
+	var files []MsgFile
+	var total int
+
+	for _, file := range m.Files {
+		if strings.Contains(file.ContentType, "application/smil") {
+			files = append(files, file)
+			continue
+		}
+
+		decoded, err := decodeBase64(file.Content)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to decode Base64 content: %v", err)
+		}
+		total += len(decoded)
+		if total > maxBytes {
+			return nil, 0, fmt.Errorf("media totals more than %d bytes", maxBytes)
+		}
+
+		file.ContentType, _ = resolvePartContentType(file.ContentType, file.Filename, decoded)
+		if file.Filename == "" {
+			file.Filename = uuid.New().String() + getExtensionForContentType(baseMediaType(file.ContentType))
+		}
+		file.Content = decoded
+		file.Base64Data = encodeToBase64(decoded)
+		files = append(files, file)
+	}
+	return files, total, nil
+}
+
+// List of compatible MIME types, the default MMS_ALLOWED_TYPES
 var compatibleTypes = map[string]bool{
+	"text/plain": true, "text/vcard": true, "text/x-vcard": true,
 	"image/jpeg": true, "image/jpg": true, "image/gif": true, "image/png": true,
 	"audio/basic": true, "audio/L24": true, "audio/mp4": true, "audio/mpeg": true,
 	"audio/ogg": true, "audio/vnd.rn-realaudio": true, "audio/vnd.wave": true,
@@ -287,9 +433,79 @@ var compatibleTypes = map[string]bool{
 	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   true,
 }
 
+// parseAllowedTypes reads MMS_ALLOWED_TYPES, a comma-separated list of media
+// types such as "image/jpeg,video/*". An empty value allows compatibleTypes.
+func parseAllowedTypes(val string) map[string]bool {
+	allowed := make(map[string]bool)
+	for _, entry := range strings.Split(val, ",") {
+		if t := baseMediaType(entry); t != "" {
+			allowed[t] = true
+		}
+	}
+	if len(allowed) == 0 {
+		for t := range compatibleTypes {
+			allowed[baseMediaType(t)] = true
+		}
+	}
+	return allowed
+}
+
+// allowsType reports whether contentType is in the allowlist, either by its
+// base type or by a "type/*" entry. An unset allowlist allows compatibleTypes.
+func (c TranscodeConfig) allowsType(contentType string) bool {
+	allowed := c.AllowedTypes
+	if allowed == nil {
+		allowed = parseAllowedTypes("")
+	}
+	mediaType := baseMediaType(contentType)
+	if allowed[mediaType] {
+		return true
+	}
+	top, _, _ := strings.Cut(mediaType, "/")
+	return allowed[top+"/*"]
+}
+
+// filterAllowedParts drops the parts of files whose final content type is not
+// allowed, logging each one. SMIL is always kept. When parts were dropped and
+// only SMIL remains, the message is refused with ErrUnsupportedFormat rather
+// than forwarded empty.
+func (m *MM4Message) filterAllowedParts(lm *LogManager, cfg TranscodeConfig, files []MsgFile) ([]MsgFile, error) {
+	var kept []MsgFile
+	var content, rejected int
+	for i, f := range files {
+		if strings.Contains(f.ContentType, "application/smil") {
+			kept = append(kept, f)
+			continue
+		}
+		if !cfg.allowsType(f.ContentType) {
+			rejected++
+			lm.SendLog(lm.BuildLog(
+				"Server.MM4.TranscodeMedia",
+				"FilePartRejected",
+				logrus.WarnLevel,
+				map[string]interface{}{
+					"transaction_id": m.TransactionID,
+					"trace_id":       m.TraceID,
+					"index":          i,
+					"filename":       f.Filename,
+					"content_type":   f.ContentType,
+					"size_bytes":     len(f.Content),
+				},
+			))
+			continue
+		}
+		content++
+		kept = append(kept, f)
+	}
+	if rejected > 0 && content == 0 {
+		return nil, ErrUnsupportedFormat
+	}
+	return kept, nil
+}
+
 // NOTE: requires a *LogManager so we can log without using logrus directly.
 // Returns: processedFiles, originalDecodedSize, error
-func (m *MM4Message) processAndConvertFiles(lm *LogManager) ([]MsgFile, int, error) {
+func (m *MM4Message) processAndConvertFiles(lm *LogManager, cfg TranscodeConfig, outPath string) ([]MsgFile, int, error) {
 	var processedFiles []MsgFile
 	var originalDecodedSize int
 
@@ -300,6 +516,7 @@ func (m *MM4Message) processAndConvertFiles(lm *LogManager) ([]MsgFile, int, err
 
 		entryFields := map[string]interface{}{
 			"transaction_id": m.TransactionID,
+			"trace_id":       m.TraceID,
 			"index":          idx,
 			"filename_in":    file.Filename,
 			"content_type":   file.ContentType,
@@ -355,6 +572,29 @@ func (m *MM4Message) processAndConvertFiles(lm *LogManager) ([]MsgFile, int, err
 			return nil, 0, ErrFileTooLarge
 		}
 
+		// MMSCs send parts with no type, a generic type or the wrong one;
+		// check the declared type against the content before branching on it.
+		if detected, overridden := resolvePartContentType(file.ContentType, file.Filename, decodedContent); detected != file.ContentType {
+			entryFields["detected_mime"] = detected
+			file.ContentType = detected
+
+			if overridden {
+				lm.SendLog(lm.BuildLog(
+					"Server.MM4.TranscodeMedia",
+					"FileMimeOverride",
+					logrus.WarnLevel,
+					entryFields,
+				))
+			} else {
+				lm.SendLog(lm.BuildLog(
+					"Server.MM4.TranscodeMedia",
+					"FileMimeDetected",
+					logrus.DebugLevel,
+					entryFields,
+				))
+			}
+		}
+
 		// Check for animated GIF (cannot be resized per Telnyx docs)
 		if strings.Contains(file.ContentType, "image/gif") {
 			if isAnimatedGIF(decodedContent) {
@@ -376,19 +616,6 @@ func (m *MM4Message) processAndConvertFiles(lm *LogManager) ([]MsgFile, int, err
 			}
 		}
 
-		if strings.Contains(file.ContentType, "application/octet-stream") || file.ContentType == "" {
-			detected := detectMIMEType(decodedContent)
-			entryFields["detected_mime"] = detected
-			file.ContentType = detected
-
-			lm.SendLog(lm.BuildLog(
-				"Server.MM4.TranscodeMedia",
-				"FileMimeDetected",
-				logrus.DebugLevel,
-				entryFields,
-			))
-		}
-
 		entryFields["branch_content_type"] = file.ContentType
 
 		var convertedContent []byte
@@ -405,18 +632,18 @@ func (m *MM4Message) processAndConvertFiles(lm *LogManager) ([]MsgFile, int, err
 			))
 
 			if strings.Contains(file.ContentType, "jpeg") || strings.Contains(file.ContentType, "jpg") {
-				convertedContent, err = compressJPEG(decodedContent, int(maxImageSize))
+				convertedContent, err = compressJPEG(decodedContent, cfg.MaxImageBytes, cfg.MaxImageDimension)
 				newType = "image/jpeg"
 				newExt = ".jpg"
 			} else if strings.Contains(file.ContentType, "png") {
-				convertedContent, err = compressPNG(decodedContent, int(maxImageSize))
+				convertedContent, err = compressPNG(decodedContent, cfg.MaxImageBytes, cfg.MaxImageDimension)
 				newType = "image/png"
 				newExt = ".png"
 			} else {
 				convertedContent, newType, err = convertImageToPNG(decodedContent)
 				newExt = ".png"
 				if err == nil {
-					convertedContent, err = compressPNG(convertedContent, int(maxImageSize))
+					convertedContent, err = compressPNG(convertedContent, cfg.MaxImageBytes, cfg.MaxImageDimension)
 				}
 			}
 			if err != nil {
@@ -438,7 +665,7 @@ func (m *MM4Message) processAndConvertFiles(lm *LogManager) ([]MsgFile, int, err
 				entryFields,
 			))
 
-			convertedContent, newType, err = processVideoContent(decodedContent)
+			convertedContent, newType, err = processVideoContent(decodedContent, cfg.MaxVideoBytes, cfg.TempPath)
 			newExt = ".3gp"
 			if err != nil {
 				lm.SendLog(lm.BuildLog(
@@ -459,8 +686,25 @@ func (m *MM4Message) processAndConvertFiles(lm *LogManager) ([]MsgFile, int, err
 				entryFields,
 			))
 
-			convertedContent, newType, err = convertToMP3(decodedContent)
-			newExt = ".mp3"
+			_, audioCodec, probeErr := detectCodecs(decodedContent)
+			forced := cfg.audioCodecFor(outPath)
+			target := chooseAudioTarget(audioCodec, len(decodedContent), cfg.MaxFileBytes, forced)
+			entryFields["audio_codec"] = audioCodec
+			entryFields["audio_path"] = outPath
+			entryFields["audio_forced"] = forced
+			entryFields["audio_target"] = target
+			if probeErr != nil {
+				entryFields["probe_error"] = probeErr.Error()
+			}
+
+			if target == "" {
+				// Already carrier-compatible and within limits
+				convertedContent = decodedContent
+				newType = file.ContentType
+				newExt = filepath.Ext(file.Filename)
+			} else {
+				convertedContent, newType, newExt, err = convertAudio(decodedContent, target, cfg.MaxFileBytes)
+			}
 			if err != nil {
 				lm.SendLog(lm.BuildLog(
 					"Server.MM4.TranscodeMedia",
@@ -480,7 +724,7 @@ func (m *MM4Message) processAndConvertFiles(lm *LogManager) ([]MsgFile, int, err
 				entryFields,
 			))
 
-			convertedContent, err = compressFile(decodedContent, int(maxFileSize))
+			convertedContent, err = checkAttachmentSize(decodedContent, cfg.MaxFileBytes)
 			if err != nil {
 				lm.SendLog(lm.BuildLog(
 					"Server.MM4.TranscodeMedia",
@@ -489,7 +733,7 @@ func (m *MM4Message) processAndConvertFiles(lm *LogManager) ([]MsgFile, int, err
 					entryFields,
 					err,
 				))
-				return nil, 0, fmt.Errorf("failed to compress file: %v", err)
+				return nil, 0, err
 			}
 			newType = file.ContentType
 			newExt = filepath.Ext(file.Filename)
@@ -536,11 +780,10 @@ func (m *MM4Message) processAndConvertFiles(lm *LogManager) ([]MsgFile, int, err
 }
 
 // convertTo3GPP compresses and converts video content to 3GPP format suitable for MMS transmission.
-func convertTo3GPP(content []byte, transcodeVideo, transcodeAudio bool) ([]byte, error) {
-	// Determine temporary file path
-	tempPath := os.Getenv("TRANSCODE_TEMP_PATH")
+// Work files go in tempPath, the OS temp directory when empty.
+func convertTo3GPP(content []byte, transcodeVideo, transcodeAudio bool, maxSize int, tempPath string) ([]byte, error) {
 	if tempPath == "" {
-		tempPath = os.TempDir() // Use OS temp directory as fallback
+		tempPath = os.TempDir()
 	}
 
 	// Generate unique file names for input and output
@@ -608,9 +851,9 @@ func convertTo3GPP(content []byte, transcodeVideo, transcodeAudio bool) ([]byte,
 		return nil, fmt.Errorf("failed to read temporary output file: %v", err)
 	}
 
-	// Validate file size (600 KB limit for MMS)
-	if len(processedContent) > int(maxFileSize) {
-		return nil, fmt.Errorf("compressed video file exceeds size limit of %.2f KB", float64(maxFileSize)/1024)
+	// Validate file size against the configured video limit
+	if len(processedContent) > maxSize {
+		return nil, fmt.Errorf("compressed video file exceeds size limit of %.2f KB", float64(maxSize)/1024)
 	}
 
 	return processedContent, nil
@@ -640,12 +883,14 @@ func isAnimatedGIF(content []byte) bool {
 	return len(g.Image) > 1
 }
 
-// convertImageToPNG converts an image to PNG format.
+// convertImageToPNG converts an image to PNG format, turned upright per its
+// EXIF orientation.
 func convertImageToPNG(content []byte) ([]byte, string, error) {
 	img, _, err := image.Decode(bytes.NewReader(content))
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to decode image: %v", err)
 	}
+	img = orientImage(img, exifOrientation(content))
 
 	var buf bytes.Buffer
 	err = png.Encode(&buf, img)
@@ -657,7 +902,7 @@ func convertImageToPNG(content []byte) ([]byte, string, error) {
 }
 
 // processVideoContent converts video content if needed.
-func processVideoContent(content []byte) ([]byte, string, error) {
+func processVideoContent(content []byte, maxSize int, tempPath string) ([]byte, string, error) {
 	_, _, err := detectCodecs(content)
 	if err != nil {
 		return nil, "", err
@@ -670,78 +915,35 @@ func processVideoContent(content []byte) ([]byte, string, error) {
 		return content, "video/3gpp", nil
 	}*/
 
-	data, err := convertTo3GPP(content, true, false)
+	data, err := convertTo3GPP(content, true, false, maxSize, tempPath)
 
 	return data, "video/3gpp", err
 }
 
 // compressJPEG compresses JPEG images with progressive quality reduction and dimension resizing.
 // Targets Tier 2 carrier limit (600KB) for maximum compatibility.
-func compressJPEG(content []byte, maxSize int) ([]byte, error) {
+// The image is turned upright per its EXIF orientation first. The encoder
+// writes no metadata, so the output carries no EXIF, GPS position included.
+func compressJPEG(content []byte, maxSize, maxDim int) ([]byte, error) {
 	img, err := jpeg.Decode(bytes.NewReader(content))
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode JPEG: %v", err)
 	}
+	img = orientImage(img, exifOrientation(content))
 
-	// Quality levels to try (aggressive reduction)
-	qualityLevels := []int{85, 70, 55, 40, 25, 15, 5}
-	// Dimension scale factors to try if quality alone isn't enough
-	scaleFactors := []float64{1.0, 0.75, 0.5, 0.35, 0.25}
-
-	var buf bytes.Buffer
-	var currentImg image.Image = img
-
-	for _, scale := range scaleFactors {
-		if scale < 1.0 {
-			// Resize the image
-			currentImg = resizeImage(img, scale)
-		}
-
-		for _, quality := range qualityLevels {
-			buf.Reset()
-			err = jpeg.Encode(&buf, currentImg, &jpeg.Options{Quality: quality})
-			if err != nil {
-				return nil, fmt.Errorf("failed to encode JPEG: %v", err)
-			}
-
-			if buf.Len() <= maxSize {
-				return buf.Bytes(), nil
-			}
-		}
-	}
-
-	// If we still can't fit, return ErrCompressionFailed
-	return nil, ErrCompressionFailed
-}
-
-// resizeImage scales an image by the given factor while maintaining aspect ratio
-func resizeImage(img image.Image, scale float64) image.Image {
-	bounds := img.Bounds()
-	newWidth := int(float64(bounds.Dx()) * scale)
-	newHeight := int(float64(bounds.Dy()) * scale)
-
-	// Use simple nearest-neighbor resize (good enough for compression purposes)
-	newImg := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
-
-	for y := 0; y < newHeight; y++ {
-		for x := 0; x < newWidth; x++ {
-			srcX := int(float64(x) / scale)
-			srcY := int(float64(y) / scale)
-			newImg.Set(x, y, img.At(srcX, srcY))
-		}
-	}
-
-	return newImg
+	return compressImageToJPEG(img, maxSize, maxDim)
 }
 
 // compressPNG attempts PNG compression first, then falls back to JPEG if still too large.
 // PNG is lossless and cannot be effectively compressed, so we convert to JPEG when needed.
 // Note: This changes the output format from PNG to JPEG when fallback is used.
-func compressPNG(content []byte, maxSize int) ([]byte, error) {
+func compressPNG(content []byte, maxSize, maxDim int) ([]byte, error) {
 	img, err := png.Decode(bytes.NewReader(content))
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode PNG: %v", err)
 	}
+	img = orientImage(img, exifOrientation(content))
+	img = capImageDimension(img, maxDim)
 
 	// Try PNG first
 	var buf bytes.Buffer
@@ -756,25 +958,23 @@ func compressPNG(content []byte, maxSize int) ([]byte, error) {
 	}
 
 	// PNG too large - fall back to JPEG compression with progressive quality/resize
-	return compressImageToJPEG(img, maxSize)
+	return compressImageToJPEG(img, maxSize, maxDim)
 }
 
-// compressImageToJPEG compresses an already-decoded image to JPEG with progressive quality/resize
-func compressImageToJPEG(img image.Image, maxSize int) ([]byte, error) {
+// compressImageToJPEG compresses an already-decoded image to JPEG. The image
+// is first halved down to maxDim (if set), then each quality level is tried;
+// if none fits, the longest side is halved again and the quality loop
+// repeated, until the output fits or the image reaches minImageDimension.
+func compressImageToJPEG(img image.Image, maxSize, maxDim int) ([]byte, error) {
 	qualityLevels := []int{85, 70, 55, 40, 25, 15, 5}
-	scaleFactors := []float64{1.0, 0.75, 0.5, 0.35, 0.25}
 
 	var buf bytes.Buffer
-	var currentImg image.Image = img
-
-	for _, scale := range scaleFactors {
-		if scale < 1.0 {
-			currentImg = resizeImage(img, scale)
-		}
+	img = capImageDimension(img, maxDim)
 
+	for {
 		for _, quality := range qualityLevels {
 			buf.Reset()
-			err := jpeg.Encode(&buf, currentImg, &jpeg.Options{Quality: quality})
+			err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
 			if err != nil {
 				return nil, fmt.Errorf("failed to encode JPEG: %v", err)
 			}
@@ -783,46 +983,24 @@ func compressImageToJPEG(img image.Image, maxSize int) ([]byte, error) {
 				return buf.Bytes(), nil
 			}
 		}
-	}
 
-	return nil, ErrCompressionFailed
+		if longestSide(img) <= minImageDimension {
+			return nil, ErrCompressionFailed
+		}
+		img = halveImage(img)
+	}
 }
 
-// compressFile compresses any other file type to be under the specified max size.
-func compressFile(content []byte, maxSize int) ([]byte, error) {
+// checkAttachmentSize passes a document or other non-media attachment
+// through unchanged. Such files cannot be recompressed without corrupting
+// them, so anything over maxSize is refused with ErrAttachmentTooLarge.
+func checkAttachmentSize(content []byte, maxSize int) ([]byte, error) {
 	if len(content) <= maxSize {
 		return content, nil
 	}
-
-	pr, pw := io.Pipe()
-	prOut, pwOut := io.Pipe()
-	defer pr.Close()
-	defer pwOut.Close()
-
-	go func() {
-		_, _ = pw.Write(content)
-		_ = pw.Close()
-	}()
-
-	var outputBuffer bytes.Buffer
-	ffmpegCmd := ffmpeg.Input("pipe:0").
-		Output("pipe:1", ffmpeg.KwArgs{"c:v": "libx264", "crf": 28, "preset": "slow"}).
-		WithInput(pr).
-		WithOutput(pwOut).
-		OverWriteOutput().
-		Run()
-
-	go func() {
-		_ = ffmpegCmd
-		_ = pwOut.Close()
-	}()
-
-	_, _ = io.Copy(&outputBuffer, prOut)
-	if outputBuffer.Len() > maxSize {
-		return nil, fmt.Errorf("file exceeds size limit after compression")
-	}
-
-	return outputBuffer.Bytes(), nil
+	err := ErrAttachmentTooLarge
+	err.Details = fmt.Sprintf("attachment is %d bytes, limit is %d", len(content), maxSize)
+	return nil, err
 }
 
 // detectCodecs probes the input content to determine its codecs.
@@ -870,7 +1048,7 @@ func detectCodecs(content []byte) (string, string, error) {
 }
 
 // convertToMP4 compresses and converts video content to MP4 format using ffmpeg.
-func convertToMP4(content []byte, transcodeVideo, transcodeAudio bool) ([]byte, error) {
+func convertToMP4(content []byte, transcodeVideo, transcodeAudio bool, maxSize int) ([]byte, error) {
 	pr, pw := io.Pipe()
 	prOut, pwOut := io.Pipe()
 
@@ -912,54 +1090,14 @@ func convertToMP4(content []byte, transcodeVideo, transcodeAudio bool) ([]byte,
 
 	_, _ = io.Copy(&outputBuffer, prOut)
 
-	// Check if the output is larger than the allowed limit (5MB)
-	if outputBuffer.Len() > maxFileSize {
-		return nil, fmt.Errorf("compressed video file exceeds size limit of 5MB")
+	// Check if the output is larger than the allowed limit
+	if outputBuffer.Len() > maxSize {
+		return nil, fmt.Errorf("compressed video file exceeds size limit of %.2f KB", float64(maxSize)/1024)
 	}
 
 	return outputBuffer.Bytes(), nil
 }
 
-// convertToMP3 compresses and converts audio content to MP3 format using ffmpeg.
-func convertToMP3(content []byte) ([]byte, string, error) {
-	pr, pw := io.Pipe()
-	prOut, pwOut := io.Pipe()
-
-	go func() {
-		_, _ = pw.Write(content)
-		_ = pw.Close()
-	}()
-
-	var outputBuffer bytes.Buffer
-
-	go func() {
-		err := ffmpeg.Input("pipe:0").
-			Output("pipe:1", ffmpeg.KwArgs{
-				"c:a": "libmp3lame",
-				"b:a": "128k", // Set bitrate for compression
-				"ar":  "44100",
-			}).
-			WithInput(pr).
-			WithOutput(pwOut).
-			OverWriteOutput().
-			Run()
-		if err != nil {
-			// error gets returned via caller, no direct logging here
-			_ = err
-		}
-		_ = pwOut.Close()
-	}()
-
-	_, _ = io.Copy(&outputBuffer, prOut)
-
-	// Check if the output is larger than the allowed limit (5MB)
-	if outputBuffer.Len() > maxFileSize {
-		return nil, "", fmt.Errorf("compressed audio file exceeds size limit of 5MB")
-	}
-
-	return outputBuffer.Bytes(), "audio/mp3", nil
-}
-
 // encodeToBase64 converts raw bytes to Base64.
 func encodeToBase64(data []byte) string {
 	return base64.StdEncoding.EncodeToString(data)
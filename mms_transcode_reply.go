@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// TranscodeReplyNone as a transcode reply template sends no reply.
+const TranscodeReplyNone = "none"
+
+// Default transcode reply templates. {id} is the MM4 transaction ID and
+// {reason} the user message of a TranscodeError, or a generic one.
+const (
+	defaultTranscodeFailureReply = "{reason} ID: {id}"
+	defaultTranscodePanicReply   = "An internal error occurred while processing your media. Please try again later. ID: {id}"
+	defaultTranscodeFailReason   = "An error occurred. Please try again later or contact support."
+)
+
+// transcodeReplyTemplate returns the template to use: the client's setting,
+// then the gateway's, then fallback.
+func transcodeReplyTemplate(clientValue, gatewayValue, fallback string) string {
+	if clientValue != "" {
+		return clientValue
+	}
+	if gatewayValue != "" {
+		return gatewayValue
+	}
+	return fallback
+}
+
+// renderTranscodeReply fills template in. ok is false when the template
+// asks for no reply.
+func renderTranscodeReply(template, id, reason string) (reply string, ok bool) {
+	if strings.EqualFold(strings.TrimSpace(template), TranscodeReplyNone) {
+		return "", false
+	}
+	return strings.NewReplacer("{id}", id, "{reason}", reason).Replace(template), true
+}
+
+// transcodeFailureReply returns the reply to the sender of an MMS whose media
+// could not be transcoded (TRANSCODE_FAILURE_REPLY, or the client's
+// transcode_failure_reply).
+func (gateway *Gateway) transcodeFailureReply(client *Client, id string, err error) (string, bool) {
+	clientValue := ""
+	if client != nil && client.Settings != nil {
+		clientValue = client.Settings.TranscodeFailureReply
+	}
+	reason := defaultTranscodeFailReason
+	if te, ok := err.(TranscodeError); ok {
+		reason = te.UserMessage
+	}
+	template := transcodeReplyTemplate(clientValue, gateway.Config.TranscodeFailureReply, defaultTranscodeFailureReply)
+	return renderTranscodeReply(template, id, reason)
+}
+
+// transcodePanicReply returns the reply to the sender of an MMS whose
+// transcoding panicked (TRANSCODE_PANIC_REPLY, or the client's
+// transcode_panic_reply).
+func (gateway *Gateway) transcodePanicReply(client *Client, id string) (string, bool) {
+	clientValue := ""
+	if client != nil && client.Settings != nil {
+		clientValue = client.Settings.TranscodePanicReply
+	}
+	template := transcodeReplyTemplate(clientValue, gateway.Config.TranscodePanicReply, defaultTranscodePanicReply)
+	return renderTranscodeReply(template, id, defaultTranscodeFailReason)
+}
+
+// transcodeReplyItem builds the SMS sent back to the sender of m. It is tried
+// once and then discarded (RetryCount 666).
+func transcodeReplyItem(m *MM4Message, reply string, deliveryErr string) MsgQueueItem {
+	return MsgQueueItem{
+		To:              m.From,
+		From:            m.To,
+		Type:            "sms",
+		message:         reply,
+		SkipNumberCheck: false,
+		LogID:           m.TransactionID,
+		TraceID:         m.TraceID,
+		Delivery: &MsgQueueDelivery{
+			Error:      deliveryErr,
+			RetryTime:  time.Now(),
+			RetryCount: 666,
+		},
+	}
+}
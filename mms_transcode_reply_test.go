@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTranscodeFailureReply_Defaults(t *testing.T) {
+	gateway := &Gateway{}
+
+	reply, ok := gateway.transcodeFailureReply(nil, "tx1", ErrFileTooLarge)
+	assert.True(t, ok)
+	assert.Equal(t, ErrFileTooLarge.UserMessage+" ID: tx1", reply)
+
+	reply, ok = gateway.transcodeFailureReply(nil, "tx1", errors.New("ffmpeg exited"))
+	assert.True(t, ok)
+	assert.Equal(t, "An error occurred. Please try again later or contact support. ID: tx1", reply)
+
+	reply, ok = gateway.transcodePanicReply(nil, "tx1")
+	assert.True(t, ok)
+	assert.Equal(t, "An internal error occurred while processing your media. Please try again later. ID: tx1", reply)
+}
+
+func TestTranscodeFailureReply_ConfiguredTemplate(t *testing.T) {
+	gateway := &Gateway{Config: GatewayConfig{
+		TranscodeFailureReply: "Média refusé ({reason}) réf. {id}",
+		TranscodePanicReply:   "Erreur interne, réf. {id}",
+	}}
+
+	reply, ok := gateway.transcodeFailureReply(&Client{}, "tx2", ErrUnsupportedFormat)
+	assert.True(t, ok)
+	assert.Equal(t, "Média refusé ("+ErrUnsupportedFormat.UserMessage+") réf. tx2", reply)
+
+	reply, ok = gateway.transcodePanicReply(&Client{}, "tx2")
+	assert.True(t, ok)
+	assert.Equal(t, "Erreur interne, réf. tx2", reply)
+
+	client := &Client{Settings: &ClientSettings{TranscodeFailureReply: "Bad media {id}"}}
+	reply, ok = gateway.transcodeFailureReply(client, "tx3", ErrUnsupportedFormat)
+	assert.True(t, ok)
+	assert.Equal(t, "Bad media tx3", reply, "the client's template wins")
+
+	reply, ok = gateway.transcodePanicReply(client, "tx3")
+	assert.True(t, ok)
+	assert.Equal(t, "Erreur interne, réf. tx3", reply, "unset client templates fall back")
+}
+
+func TestTranscodeFailureReply_None(t *testing.T) {
+	gateway := &Gateway{Config: GatewayConfig{TranscodePanicReply: TranscodeReplyNone}}
+
+	_, ok := gateway.transcodePanicReply(nil, "tx4")
+	assert.False(t, ok)
+
+	client := &Client{Settings: &ClientSettings{TranscodeFailureReply: "None"}}
+	_, ok = gateway.transcodeFailureReply(client, "tx4", ErrFileTooLarge)
+	assert.False(t, ok)
+}
+
+func TestTranscodeReplyItem_DiscardedAfterOneAttempt(t *testing.T) {
+	m := &MM4Message{From: "+15551234567", To: "+15557654321", TransactionID: "tx5", TraceID: "trace"}
+
+	item := transcodeReplyItem(m, "sorry", "discard after first attempt")
+	assert.Equal(t, "+15557654321", item.From, "the reply goes back to the sender")
+	assert.Equal(t, "+15551234567", item.To)
+	assert.Equal(t, "sorry", item.message)
+	assert.Equal(t, "trace", item.TraceID)
+	assert.Equal(t, 666, item.Delivery.RetryCount)
+}
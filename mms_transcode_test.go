@@ -0,0 +1,341 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image/jpeg"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTranscodeConfig_Defaults(t *testing.T) {
+	t.Setenv("MMS_MAX_IMAGE_BYTES", "")
+	t.Setenv("MMS_MAX_VIDEO_BYTES", "")
+	t.Setenv("MMS_MAX_FILE_BYTES", "")
+
+	cfg := loadTranscodeConfig(&envReader{})
+	assert.Equal(t, targetOutputSize, cfg.MaxImageBytes)
+	assert.Equal(t, targetOutputSize, cfg.MaxVideoBytes)
+	assert.Equal(t, targetOutputSize, cfg.MaxFileBytes)
+}
+
+func TestLoadTranscodeConfig_EnvOverrides(t *testing.T) {
+	t.Setenv("MMS_MAX_IMAGE_BYTES", "1048576")
+	t.Setenv("MMS_MAX_VIDEO_BYTES", "2097152")
+	t.Setenv("MMS_MAX_FILE_BYTES", "512000")
+
+	cfg := loadTranscodeConfig(&envReader{})
+	assert.Equal(t, 1048576, cfg.MaxImageBytes)
+	assert.Equal(t, 2097152, cfg.MaxVideoBytes)
+	assert.Equal(t, 512000, cfg.MaxFileBytes)
+}
+
+func TestLoadTranscodeConfig_Workers(t *testing.T) {
+	t.Setenv("TRANSCODE_WORKERS", "6")
+	assert.Equal(t, 6, loadTranscodeConfig(&envReader{}).Workers)
+
+	t.Setenv("TRANSCODE_WORKERS", "0")
+	assert.Greater(t, loadTranscodeConfig(&envReader{}).Workers, 0)
+}
+
+func TestRunTranscodeWorkers_Concurrent(t *testing.T) {
+	const workers = 4
+	in := make(chan *MM4Message, workers)
+
+	// Each message blocks until all workers hold one, so the test only
+	// completes if they are processed concurrently.
+	var (
+		mu      sync.Mutex
+		seen    = map[string]bool{}
+		arrived sync.WaitGroup
+		release = make(chan struct{})
+		done    sync.WaitGroup
+	)
+	arrived.Add(workers)
+	done.Add(workers)
+	runTranscodeWorkers(workers, in, func(m *MM4Message) {
+		mu.Lock()
+		seen[m.MessageID] = true
+		mu.Unlock()
+		arrived.Done()
+		<-release
+		done.Done()
+	})
+
+	for i := 0; i < workers; i++ {
+		in <- &MM4Message{MessageID: fmt.Sprintf("msg-%d", i)}
+	}
+
+	allArrived := make(chan struct{})
+	go func() {
+		arrived.Wait()
+		close(allArrived)
+	}()
+	select {
+	case <-allArrived:
+	case <-time.After(2 * time.Second):
+		t.Fatal("messages were not processed concurrently")
+	}
+	close(release)
+	done.Wait()
+	close(in)
+
+	assert.Len(t, seen, workers)
+}
+
+func TestLoadTranscodeConfig_InvalidFallsBack(t *testing.T) {
+	t.Setenv("MMS_MAX_IMAGE_BYTES", "lots")
+	t.Setenv("MMS_MAX_VIDEO_BYTES", "0")
+	t.Setenv("MMS_MAX_FILE_BYTES", "-5")
+
+	cfg := loadTranscodeConfig(&envReader{})
+	assert.Equal(t, targetOutputSize, cfg.MaxImageBytes)
+	assert.Equal(t, targetOutputSize, cfg.MaxVideoBytes)
+	assert.Equal(t, targetOutputSize, cfg.MaxFileBytes)
+}
+
+func TestCheckAttachmentSize_PassthroughUnderLimit(t *testing.T) {
+	content := []byte("small attachment")
+	got, err := checkAttachmentSize(content, len(content))
+	assert.NoError(t, err)
+	assert.Equal(t, content, got)
+}
+
+func TestCheckAttachmentSize_TooLarge(t *testing.T) {
+	_, err := checkAttachmentSize(make([]byte, 11), 10)
+	require.Error(t, err)
+	te, ok := err.(TranscodeError)
+	require.True(t, ok)
+	assert.Equal(t, "ATTACHMENT_TOO_LARGE", te.Code)
+}
+
+func testDOCX(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, body := range map[string]string{
+		"[Content_Types].xml": `<?xml version="1.0"?><Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"/>`,
+		"word/document.xml":   `<?xml version="1.0"?><w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"/>`,
+	} {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestProcessAndConvertFiles_DocumentsPassThrough(t *testing.T) {
+	_, gw := newTestRouter(1)
+	pdf := []byte("%PDF-1.4\n1 0 obj<</Type/Catalog>>endobj\ntrailer<</Root 1 0 R>>\n%%EOF\n")
+	docx := testDOCX(t)
+	const docxType = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+
+	m := &MM4Message{
+		TransactionID: "tx-docs",
+		Files: []MsgFile{
+			{Filename: "invoice.pdf", ContentType: "application/pdf", Content: []byte(base64.StdEncoding.EncodeToString(pdf))},
+			{Filename: "letter.docx", ContentType: docxType, Content: []byte(base64.StdEncoding.EncodeToString(docx))},
+		},
+	}
+
+	files, _, err := m.processAndConvertFiles(gw.LogManager, loadTranscodeConfig(&envReader{}), "")
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	assert.Equal(t, pdf, files[0].Content)
+	assert.Equal(t, "application/pdf", files[0].ContentType)
+	assert.Equal(t, ".pdf", filepath.Ext(files[0].Filename))
+	assert.Equal(t, docx, files[1].Content)
+	assert.Equal(t, docxType, files[1].ContentType)
+	assert.Equal(t, ".docx", filepath.Ext(files[1].Filename))
+}
+
+func TestProcessAndConvertFiles_OversizedDocument(t *testing.T) {
+	_, gw := newTestRouter(1)
+	cfg := loadTranscodeConfig(&envReader{})
+	cfg.MaxFileBytes = 16
+	docx := testDOCX(t)
+
+	m := &MM4Message{
+		TransactionID: "tx-big-doc",
+		Files: []MsgFile{{
+			Filename:    "letter.docx",
+			ContentType: "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+			Content:     []byte(base64.StdEncoding.EncodeToString(docx)),
+		}},
+	}
+
+	_, _, err := m.processAndConvertFiles(gw.LogManager, cfg, "")
+	require.Error(t, err)
+	te, ok := err.(TranscodeError)
+	require.True(t, ok, "sender gets the user message")
+	assert.Equal(t, ErrAttachmentTooLarge.Code, te.Code)
+}
+
+func TestLoadTranscodeConfig_AudioCodecs(t *testing.T) {
+	t.Setenv("MMS_AUDIO_CODEC", "aac, Telnyx=AMR, mm4=amr_nb, web=flac")
+
+	cfg := loadTranscodeConfig(&envReader{})
+	assert.Equal(t, map[string]string{"*": "aac", "telnyx": "amr", "mm4": "amr"}, cfg.AudioCodecs)
+	assert.Equal(t, "amr", cfg.audioCodecFor("telnyx"))
+	assert.Equal(t, "aac", cfg.audioCodecFor("twilio"), "unlisted paths use the default")
+	assert.Equal(t, "aac", cfg.audioCodecFor("web"), "unsupported codecs are ignored")
+
+	t.Setenv("MMS_AUDIO_CODEC", "")
+	assert.Equal(t, "", loadTranscodeConfig(&envReader{}).audioCodecFor("telnyx"))
+}
+
+func TestChooseAudioTarget(t *testing.T) {
+	const limit = 1000
+
+	// Carrier-compatible codecs within the limit pass through.
+	assert.Equal(t, "", chooseAudioTarget("amr_nb", 500, limit, ""))
+	assert.Equal(t, "", chooseAudioTarget("amr_wb", 500, limit, ""))
+	assert.Equal(t, "", chooseAudioTarget("aac", 500, limit, ""))
+
+	// Oversized compatible audio is re-encoded in the same codec.
+	assert.Equal(t, "aac", chooseAudioTarget("aac", 5000, limit, ""))
+
+	// Anything else falls back to MP3.
+	assert.Equal(t, "mp3", chooseAudioTarget("pcm_s16le", 500, limit, ""))
+	assert.Equal(t, "mp3", chooseAudioTarget("", 500, limit, ""))
+
+	// A forced codec always wins unless the input already matches and fits.
+	assert.Equal(t, "amr", chooseAudioTarget("aac", 500, limit, "amr"))
+	assert.Equal(t, "", chooseAudioTarget("amr_nb", 500, limit, "amr"))
+	assert.Equal(t, "amr", chooseAudioTarget("amr_nb", 5000, limit, "amr"))
+	assert.Equal(t, "mp3", chooseAudioTarget("mp3", 5000, limit, "mp3"))
+}
+
+func TestLoadTranscodeConfig_PassthroughMaxBytes(t *testing.T) {
+	t.Setenv("MMS_PASSTHROUGH_MAX_BYTES", "")
+	assert.Equal(t, defaultPassthroughMaxBytes, loadTranscodeConfig(&envReader{}).PassthroughMaxBytes)
+
+	t.Setenv("MMS_PASSTHROUGH_MAX_BYTES", "2097152")
+	assert.Equal(t, 2097152, loadTranscodeConfig(&envReader{}).PassthroughMaxBytes)
+}
+
+func TestTranscodeMessage_ClientWithTranscodingOff(t *testing.T) {
+	var src bytes.Buffer
+	require.NoError(t, jpeg.Encode(&src, noisyImage(1024, 768), &jpeg.Options{Quality: 95}))
+	require.Greater(t, src.Len(), targetOutputSize, "would be recompressed if transcoded")
+
+	r, gw := newTestRouter(2)
+	gw.Clients = map[string]*Client{
+		"full": {Username: "full", Numbers: []ClientNumber{{Number: "14155550001"}}},
+		"std":  {Username: "std", TranscodeMedia: true, Numbers: []ClientNumber{{Number: "14155550002"}}},
+	}
+	srv := &MM4Server{gateway: gw, TranscodeConfig: loadTranscodeConfig(&envReader{})}
+
+	newMessage := func(to string) *MM4Message {
+		return &MM4Message{
+			TransactionID: "tx-" + to,
+			From:          "+12505551234",
+			To:            to,
+			Files: []MsgFile{{
+				Filename:    "photo.jpg",
+				ContentType: "image/jpeg",
+				Content:     []byte(base64.StdEncoding.EncodeToString(src.Bytes())),
+			}},
+		}
+	}
+
+	srv.transcodeMessage(newMessage("+14155550001"))
+	item := <-r.ClientMsgChan
+	require.Len(t, item.files, 1)
+	assert.Equal(t, src.Bytes(), item.files[0].Content, "original media is forwarded")
+	assert.Equal(t, "photo.jpg", item.files[0].Filename)
+
+	srv.transcodeMessage(newMessage("+14155550002"))
+	item = <-r.ClientMsgChan
+	require.Len(t, item.files, 1)
+	assert.LessOrEqual(t, len(item.files[0].Content), targetOutputSize)
+
+	// Over the hard cap the media is transcoded anyway.
+	srv.TranscodeConfig.PassthroughMaxBytes = src.Len() - 1
+	srv.transcodeMessage(newMessage("+14155550001"))
+	item = <-r.ClientMsgChan
+	require.Len(t, item.files, 1)
+	assert.LessOrEqual(t, len(item.files[0].Content), targetOutputSize)
+}
+
+func TestLoadTranscodeConfig_AllowedTypes(t *testing.T) {
+	t.Setenv("MMS_ALLOWED_TYPES", "")
+	cfg := loadTranscodeConfig(&envReader{})
+	assert.True(t, cfg.allowsType("image/jpeg"))
+	assert.True(t, cfg.allowsType("audio/L24; rate=8000"), "compared by lower-cased base type")
+	assert.True(t, cfg.allowsType("text/plain; charset=utf-8"))
+	assert.False(t, cfg.allowsType("application/x-msdownload"))
+	assert.False(t, cfg.allowsType(""))
+
+	t.Setenv("MMS_ALLOWED_TYPES", "Image/JPEG, video/*,text/plain")
+	cfg = loadTranscodeConfig(&envReader{})
+	assert.True(t, cfg.allowsType("image/jpeg"))
+	assert.True(t, cfg.allowsType("video/3gpp"))
+	assert.False(t, cfg.allowsType("image/png"))
+	assert.False(t, cfg.allowsType("application/pdf"))
+}
+
+func TestTranscodeMessage_AllowedTypes(t *testing.T) {
+	pdf := []byte("%PDF-1.4\n1 0 obj<</Type/Catalog>>endobj\ntrailer<</Root 1 0 R>>\n%%EOF\n")
+	exe := append([]byte("MZ"), make([]byte, 64)...)
+
+	r, gw := newTestRouter(2)
+	gw.Clients = map[string]*Client{
+		"std": {Username: "std", TranscodeMedia: true, Numbers: []ClientNumber{{Number: "14155550002"}}},
+	}
+	srv := &MM4Server{gateway: gw, TranscodeConfig: loadTranscodeConfig(&envReader{})}
+
+	part := func(name, contentType string, content []byte) MsgFile {
+		return MsgFile{Filename: name, ContentType: contentType, Content: []byte(base64.StdEncoding.EncodeToString(content))}
+	}
+
+	// Disallowed parts are dropped and the rest is delivered.
+	srv.transcodeMessage(&MM4Message{
+		TransactionID: "tx-mixed",
+		From:          "+12505551234",
+		To:            "+14155550002",
+		Files: []MsgFile{
+			{Filename: "mms.smil", ContentType: "application/smil", Content: []byte("<smil/>")},
+			part("invoice.pdf", "application/pdf", pdf),
+			part("setup.exe", "application/x-msdownload", exe),
+		},
+	})
+	item := <-r.ClientMsgChan
+	require.Len(t, item.files, 2)
+	assert.Equal(t, "application/smil", item.files[0].ContentType)
+	assert.Equal(t, "application/pdf", item.files[1].ContentType)
+
+	// With nothing acceptable left the sender gets an error instead.
+	srv.transcodeMessage(&MM4Message{
+		TransactionID: "tx-exe",
+		From:          "+12505551234",
+		To:            "+14155550002",
+		Files: []MsgFile{
+			{Filename: "mms.smil", ContentType: "application/smil", Content: []byte("<smil/>")},
+			part("setup.exe", "application/x-msdownload", exe),
+		},
+	})
+	reply := <-r.CarrierMsgChan
+	assert.Equal(t, "+12505551234", reply.To)
+	assert.Contains(t, reply.message, ErrUnsupportedFormat.UserMessage)
+	assert.Empty(t, r.ClientMsgChan)
+}
+
+func TestSafeClientInfo_NoCredentials(t *testing.T) {
+	client := &Client{Username: "pbx1", Password: "secret"}
+	fields := safeClientInfo(&MM4Message{TransactionID: "tx-1", Client: client})
+	assert.Equal(t, "pbx1", fields["client"])
+	for _, v := range fields {
+		assert.NotEqual(t, "secret", v)
+	}
+	assert.Equal(t, "secret", client.Password, "the shared client is not modified")
+}
@@ -0,0 +1,63 @@
+package main
+
+import "strings"
+
+// MsgPriority selects the router lane a message is queued on. The router
+// drains the high lane first; each lane is FIFO.
+type MsgPriority int
+
+const (
+	MsgPriorityNormal MsgPriority = iota
+	MsgPriorityHigh
+)
+
+// Client priority settings (ClientSettings.Priority).
+const (
+	ClientPriorityHigh   = "high"
+	ClientPriorityNormal = "normal"
+)
+
+// msgPriority returns the lane for a message of msgType sent by or to client.
+// A client's own priority setting wins; otherwise message types listed in
+// HIGH_PRIORITY_TYPES are high priority.
+func (gateway *Gateway) msgPriority(client *Client, msgType MsgQueueType) MsgPriority {
+	if client != nil && client.Settings != nil {
+		switch strings.ToLower(client.Settings.Priority) {
+		case ClientPriorityHigh:
+			return MsgPriorityHigh
+		case ClientPriorityNormal:
+			return MsgPriorityNormal
+		}
+	}
+	for _, t := range gateway.Config.HighPriorityTypes {
+		if t == string(msgType) {
+			return MsgPriorityHigh
+		}
+	}
+	return MsgPriorityNormal
+}
+
+// msgPriorityFor is msgPriority for the client owning number, if any.
+func (gateway *Gateway) msgPriorityFor(number string, msgType MsgQueueType) MsgPriority {
+	var client *Client
+	if gateway.Router != nil {
+		client, _ = gateway.Router.findClientByNumber(number)
+	}
+	return gateway.msgPriority(client, msgType)
+}
+
+// clientQueue returns the client-origin channel for priority p.
+func (router *Router) clientQueue(p MsgPriority) chan MsgQueueItem {
+	if p == MsgPriorityHigh {
+		return router.ClientPriorityMsgChan
+	}
+	return router.ClientMsgChan
+}
+
+// carrierQueue returns the carrier-origin channel for priority p.
+func (router *Router) carrierQueue(p MsgPriority) chan MsgQueueItem {
+	if p == MsgPriorityHigh {
+		return router.CarrierPriorityMsgChan
+	}
+	return router.CarrierMsgChan
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_MsgPriority(t *testing.T) {
+	_, gw := newTestRouter(1)
+	gw.Config.HighPriorityTypes = []string{"sms"}
+
+	assert.Equal(t, MsgPriorityHigh, gw.msgPriority(nil, MsgQueueItemType.SMS))
+	assert.Equal(t, MsgPriorityNormal, gw.msgPriority(nil, MsgQueueItemType.MMS))
+
+	bulk := &Client{Settings: &ClientSettings{Priority: "normal"}}
+	assert.Equal(t, MsgPriorityNormal, gw.msgPriority(bulk, MsgQueueItemType.SMS), "client setting wins over type")
+	otp := &Client{Settings: &ClientSettings{Priority: "High"}}
+	assert.Equal(t, MsgPriorityHigh, gw.msgPriority(otp, MsgQueueItemType.MMS))
+	assert.Equal(t, MsgPriorityHigh, gw.msgPriority(&Client{Settings: &ClientSettings{}}, MsgQueueItemType.SMS))
+
+	gw.Config.HighPriorityTypes = nil
+	assert.Equal(t, MsgPriorityNormal, gw.msgPriority(nil, MsgQueueItemType.SMS))
+
+	gw.Clients = map[string]*Client{"otp": {Username: "otp", Settings: otp.Settings, Numbers: []ClientNumber{{Number: "12505551234"}}}}
+	assert.Equal(t, MsgPriorityHigh, gw.msgPriorityFor("+12505551234", MsgQueueItemType.MMS))
+	assert.Equal(t, MsgPriorityNormal, gw.msgPriorityFor("+14155559876", MsgQueueItemType.SMS))
+}
+
+func TestLoadGatewayConfig_HighPriorityTypes(t *testing.T) {
+	t.Setenv("ENCRYPTION_KEY", "test-key")
+	assert.Equal(t, []string{"sms"}, mustLoadGatewayConfig(t).HighPriorityTypes)
+
+	t.Setenv("HIGH_PRIORITY_TYPES", " SMS, mms ")
+	assert.Equal(t, []string{"sms", "mms"}, mustLoadGatewayConfig(t).HighPriorityTypes)
+
+	t.Setenv("HIGH_PRIORITY_TYPES", "")
+	assert.Empty(t, mustLoadGatewayConfig(t).HighPriorityTypes, "empty disables type-based priority")
+}
+
+func TestRouter_NextMessagePrefersHighPriority(t *testing.T) {
+	r, _ := newTestRouter(4)
+
+	r.clientQueue(MsgPriorityNormal) <- MsgQueueItem{LogID: "bulk-1"}
+	r.clientQueue(MsgPriorityNormal) <- MsgQueueItem{LogID: "bulk-2"}
+	r.carrierQueue(MsgPriorityNormal) <- MsgQueueItem{LogID: "inbound-1"}
+	r.clientQueue(MsgPriorityHigh) <- MsgQueueItem{LogID: "otp-1"}
+	r.carrierQueue(MsgPriorityHigh) <- MsgQueueItem{LogID: "otp-in"}
+	r.clientQueue(MsgPriorityHigh) <- MsgQueueItem{LogID: "otp-2"}
+
+	high := map[string]string{}
+	var clientHigh []string
+	for i := 0; i < 3; i++ {
+		msg, origin := r.nextMessage()
+		high[msg.LogID] = origin
+		if origin == "client" {
+			clientHigh = append(clientHigh, msg.LogID)
+		}
+	}
+	assert.Equal(t, map[string]string{"otp-1": "client", "otp-2": "client", "otp-in": "carrier"}, high,
+		"all high-priority messages come first")
+	assert.Equal(t, []string{"otp-1", "otp-2"}, clientHigh, "FIFO within a lane")
+
+	var clientNormal []string
+	for i := 0; i < 3; i++ {
+		msg, origin := r.nextMessage()
+		if origin == "client" {
+			clientNormal = append(clientNormal, msg.LogID)
+		}
+	}
+	assert.Equal(t, []string{"bulk-1", "bulk-2"}, clientNormal)
+}
@@ -1,6 +1,12 @@
 package main
 
-import "time"
+import (
+	"time"
+	"zultys-smpp-mm4/smpp/coding"
+	"zultys-smpp-mm4/smpp/pdu"
+
+	"github.com/google/uuid"
+)
 
 type MsgQueueType string
 
@@ -20,24 +26,83 @@ type MsgQueueItem struct {
 	Type              MsgQueueType `json:"type"` // mms or sms
 	files             []MsgFile
 	message           string
+	Subject           string // MMS subject, sent to MM4 clients as the Subject header
 	SkipNumberCheck   bool
-	LogID             string `json:"log_id"`
-	SourceCarrier     string // Carrier name for inbound messages from carrier (e.g., "telnyx")
-	SourceIP          string // Originating IP address for web/API messages
-	OriginalSizeBytes int    // Original media size before transcoding (MMS only)
+	LogID             string              `json:"log_id"`
+	TraceID           string              `json:"trace_id"` // Set once at ingress and kept across retries, fan-out and replies
+	SourceCarrier     string              // Carrier name for inbound messages from carrier (e.g., "telnyx")
+	SourceIP          string              // Originating IP address for web/API messages
+	OriginalSizeBytes int                 // Original media size before transcoding (MMS only)
+	DeliveryReceipt   *SMPPReceiptRequest // Set when an SMPP submit_sm requested a delivery receipt
+	PendingMMSID      uint                // Set when replayed from the persisted MMS queue
+	Priority          MsgPriority         // Router lane; set by the intake from the client/type policy
+	SendAfter         time.Time           `json:"send_after,omitempty"`  // Held by the router until this time when set
+	ValidUntil        time.Time           `json:"valid_until,omitempty"` // Given up on if not sent to a carrier by then, when set
+	// SMPP passthrough, set from submit_sm and applied to deliver_sm
+	ServiceType  string             `json:"service_type,omitempty"`
+	ESMClass     pdu.ESMClass       `json:"esm_class"` // UDHI and reply path only
+	UDH          pdu.UserDataHeader // Non-concatenation UDH elements of a binary payload
+	binary       []byte             // 8-bit payload sent unchanged in place of message
+	binaryCoding coding.DataCoding
+	receipt      *clientReceiptPayload // Set on a delivery receipt queued for a client webhook
 	//Delivery          *amqp.Delivery
 	Delivery *MsgQueueDelivery
 }
 
+// newTraceID returns the trace ID for a message entering the gateway. Every
+// log line about the message carries it as "traceID", so one search in Loki
+// follows it from SMPP, MM4 or a carrier webhook through to delivery.
+func newTraceID() string {
+	return uuid.New().String()
+}
+
+// LogSummary describes m for logs by its metadata only: type, sizes, and the
+// count and types of its attachments. Log it in place of m, whose text and
+// media must not reach the logs.
+func (msg *MsgQueueItem) LogSummary() map[string]interface{} {
+	summary := map[string]interface{}{
+		"type":       string(msg.Type),
+		"textLength": len(msg.message),
+		"files":      len(msg.files),
+	}
+	if len(msg.binary) > 0 {
+		summary["binaryBytes"] = len(msg.binary)
+	}
+	if msg.Subject != "" {
+		summary["subjectLength"] = len(msg.Subject)
+	}
+	if len(msg.files) > 0 {
+		mediaBytes := 0
+		contentTypes := make([]string, len(msg.files))
+		for i, f := range msg.files {
+			mediaBytes += msgFileSize(f)
+			contentTypes[i] = f.ContentType
+		}
+		summary["mediaBytes"] = mediaBytes
+		summary["contentTypes"] = contentTypes
+	}
+	if msg.OriginalSizeBytes > 0 {
+		summary["originalSizeBytes"] = msg.OriginalSizeBytes
+	}
+	if msg.SourceCarrier != "" {
+		summary["sourceCarrier"] = msg.SourceCarrier
+	}
+	if msg.Delivery != nil {
+		summary["retryCount"] = msg.Delivery.RetryCount
+	}
+	return summary
+}
+
 type MsgQueueDelivery struct {
 	Error      string
 	RetryTime  time.Time
 	RetryCount int
 }
 
-// Retry returns true if discarded
+// Retry schedules the message to be re-queued after an exponential backoff.
+// It returns true if the message has exhausted its retries and was discarded,
+// so the caller can notify the sender.
 func (msg *MsgQueueItem) Retry(err string, queue chan MsgQueueItem) bool {
-	// todo check if the retry count is already set, same with the time, etc.
 	if msg.Delivery == nil {
 		msg.Delivery = &MsgQueueDelivery{
 			Error:      "",
@@ -51,22 +116,23 @@ func (msg *MsgQueueItem) Retry(err string, queue chan MsgQueueItem) bool {
 		return false
 	}
 
-	if msg.Delivery.RetryCount >= 3 {
-		// discard if already tried 3 times
+	if err != "" {
+		msg.Delivery.Error = err
+	}
 
+	policy := msgRetryScheduler.getPolicy()
+	if msg.Delivery.RetryCount >= policy.maxRetries(msg.Type) {
 		// this will return true on discard, but we want to send the copy of the message pointer to a "failure"
 		// channel so that we can reverse the to/from and send an error to the client that sent it if the carrier fails
-
+		msgRetryScheduler.logPermanentFailure(msg)
+		msgRetryScheduler.notifyStatus(msg, MsgStatusFailed)
 		return true
 	}
 
 	msg.Delivery.RetryCount++
+	msg.Delivery.RetryTime = time.Now().Add(policy.backoff(msg.Delivery.RetryCount))
 
-	if err != "" {
-		msg.Delivery.Error = err
-	}
-	// sleep for retry
-	time.Sleep(10 * time.Second)
-	queue <- *msg
+	msgRetryScheduler.schedule(*msg, queue)
+	msgRetryScheduler.notifyStatus(msg, MsgStatusQueued)
 	return false
 }
@@ -9,7 +9,26 @@ import (
 func (gateway *Gateway) processMsgRecords() {
 	for {
 		msg := <-gateway.MsgRecordChan
-		if msg.ClientID == 0 {
+		if msg.Status == "" {
+			msg.Status = MsgStatusSent
+		}
+
+		if err := gateway.upsertMessageStatus(msg); err != nil {
+			gateway.LogManager.SendLog(gateway.LogManager.BuildLog(
+				"MsgRecords",
+				"StatusUpsertError",
+				logrus.ErrorLevel,
+				map[string]interface{}{
+					"logID":        msg.MsgQueueItem.LogID,
+					"clientID":     msg.ClientID,
+					"carrierMsgID": msg.CarrierMsgID,
+					"status":       msg.Status,
+				}, err,
+			))
+		}
+
+		// Only completed sends count towards usage; status transitions don't.
+		if msg.ClientID == 0 || msg.Status != MsgStatusSent {
 			continue
 		}
 		err := gateway.InsertMsgRecord(msg)
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gorm.io/gorm"
+)
+
+// Reasons a manual resend is refused. errResendNotFound is reported as 404,
+// the rest as 409.
+var (
+	errResendNotFound  = errors.New("no message record with that log ID")
+	errResendSucceeded = errors.New("message was already sent; resend with force=true")
+	errResendResent    = errors.New("message was already resent; resend with force=true")
+	errResendNoContent = errors.New("message content is no longer stored")
+	errResendInFlight  = errors.New("message is being replayed")
+)
+
+// checkResend returns why record may not be resent, or nil. A message that
+// reached the client or carrier, or was resent before, is only resent again
+// when forced.
+func checkResend(record MessageRecord, force bool) error {
+	if force {
+		return nil
+	}
+	switch {
+	case record.Status == MsgStatusSent || record.Status == MsgStatusDelivered:
+		return errResendSucceeded
+	case record.ResentAs != "":
+		return fmt.Errorf("%w (as %s)", errResendResent, record.ResentAs)
+	}
+	return nil
+}
+
+// resendOrigin returns where a recorded message entered the gateway:
+// "carrier" for inbound records, otherwise "client".
+func resendOrigin(record MessageRecord) string {
+	if record.Direction == "inbound" {
+		return "carrier"
+	}
+	return "client"
+}
+
+// smsResendItem rebuilds the SMS of record, failed with its body kept, as a
+// new message with its own log ID and a fresh retry budget.
+func smsResendItem(record MessageRecord) *MsgQueueItem {
+	msg := &MsgQueueItem{
+		To:                record.To,
+		From:              record.From,
+		ReceivedTimestamp: record.CreatedAt,
+		QueuedTimestamp:   time.Now(),
+		Type:              MsgQueueItemType.SMS,
+		message:           record.Message,
+		LogID:             primitive.NewObjectID().Hex(),
+		TraceID:           newTraceID(),
+	}
+	if resendOrigin(record) == "carrier" {
+		msg.SourceCarrier = record.Carrier
+	}
+	return msg
+}
+
+// mmsResendItem rebuilds the MMS logged as logID from the persisted MMS
+// queue, claiming its row as a replay would, with its retry count reset. The
+// caller gives the message a new log ID; the row is removed once it is
+// delivered.
+func (gateway *Gateway) mmsResendItem(logID string) (*MsgQueueItem, error) {
+	var pending PendingMMS
+	err := gateway.DB.Where("log_id = ?", logID).Order("id DESC").First(&pending).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errResendNoContent
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	claim := gateway.DB.Model(&PendingMMS{}).
+		Where("id = ? AND status <> ?", pending.ID, PendingMMSStatusReplaying).
+		Updates(map[string]interface{}{
+			"status":      PendingMMSStatusReplaying,
+			"retry_count": 0,
+		})
+	if claim.Error != nil {
+		return nil, claim.Error
+	}
+	if claim.RowsAffected == 0 {
+		return nil, errResendInFlight
+	}
+
+	msg, err := pending.toMsgQueueItem(gateway)
+	if err != nil {
+		gateway.DB.Model(&PendingMMS{}).Where("id = ?", pending.ID).Update("status", pending.Status)
+		return nil, err
+	}
+	return msg, nil
+}
+
+// resendMessage re-injects the message logged as logID as a new message and
+// returns its log ID. SMS are rebuilt from the body kept with their failed
+// record, MMS from the persisted MMS queue with their media. The original
+// records are claimed first by setting their resent_as to the new log ID
+// where it is still empty, so of two concurrent resends only one goes out;
+// the new log ID is recorded as queued.
+func (gateway *Gateway) resendMessage(logID string, force bool) (string, error) {
+	var record MessageRecord
+	err := gateway.DB.Where("log_id = ?", logID).Order("id").First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", errResendNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := checkResend(record, force); err != nil {
+		return "", err
+	}
+	if record.Type != string(MsgQueueItemType.MMS) && record.Message == "" {
+		return "", errResendNoContent
+	}
+
+	// Claim the records before anything is sent, so two concurrent resends
+	// cannot both go out.
+	newLogID := primitive.NewObjectID().Hex()
+	claim := gateway.DB.Model(&MessageRecord{}).Where("log_id = ?", logID)
+	if !force {
+		claim = claim.Where("resent_as = ?", "")
+	}
+	if claim = claim.Update("resent_as", newLogID); claim.Error != nil {
+		return "", claim.Error
+	}
+	if claim.RowsAffected == 0 {
+		return "", errResendResent
+	}
+
+	var msg *MsgQueueItem
+	if record.Type == string(MsgQueueItemType.MMS) {
+		msg, err = gateway.mmsResendItem(logID)
+	} else {
+		msg = smsResendItem(record)
+	}
+	if err != nil {
+		gateway.DB.Model(&MessageRecord{}).Where("log_id = ? AND resent_as = ?", logID, newLogID).
+			Update("resent_as", record.ResentAs)
+		return "", err
+	}
+	msg.LogID = newLogID
+
+	origin := resendOrigin(record)
+	lm := gateway.LogManager
+	lm.SendLog(lm.BuildLog(
+		"Gateway.Resend",
+		"ManualResend",
+		logrus.InfoLevel,
+		map[string]interface{}{
+			"logID":         msg.LogID,
+			"traceID":       msg.TraceID,
+			"resentLogID":   logID,
+			"type":          msg.Type,
+			"origin":        origin,
+			"status":        record.Status,
+			"force":         force,
+			"pendingMMSID":  msg.PendingMMSID,
+			"sourceCarrier": msg.SourceCarrier,
+		},
+	))
+	gateway.recordRetryStatus(*msg, MsgStatusQueued)
+	gateway.injectResend(msg, origin)
+	return msg.LogID, nil
+}
+
+// injectResend hands a resent message to the router as the MMS replay does:
+// client messages through their conversation queue, carrier messages onto
+// the carrier channel.
+func (gateway *Gateway) injectResend(msg *MsgQueueItem, origin string) {
+	if origin == "client" {
+		msg.Priority = gateway.msgPriorityFor(msg.From, msg.Type)
+		gateway.ConvoManager.AddMessage(computeCorrelationKey(msg.From, msg.To), *msg, gateway.Router)
+		return
+	}
+	msg.Priority = gateway.msgPriorityFor(msg.To, msg.Type)
+	gateway.Router.carrierQueue(msg.Priority) <- *msg
+}
+
+// webResendMessage handles POST /messages/{logID}/resend.
+func (gateway *Gateway) webResendMessage(ctx iris.Context) {
+	force, err := strconv.ParseBool(ctx.URLParamDefault("force", "false"))
+	if err != nil {
+		apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid force")
+		return
+	}
+
+	logID, err := gateway.resendMessage(ctx.Params().Get("logID"), force)
+	switch {
+	case errors.Is(err, errResendNotFound):
+		apiError(ctx, iris.StatusNotFound, errCodeMessageNotFound, err.Error())
+		return
+	case errors.Is(err, errResendSucceeded), errors.Is(err, errResendResent),
+		errors.Is(err, errResendNoContent), errors.Is(err, errResendInFlight):
+		apiError(ctx, iris.StatusConflict, errCodeResendRefused, err.Error())
+		return
+	case err != nil:
+		apiError(ctx, iris.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	ctx.StatusCode(iris.StatusAccepted)
+	ctx.JSON(iris.Map{"log_id": logID})
+}
+
+// SetupResendRoutes registers the manual resend endpoint. It takes admin
+// auth, unlike the client routes under /messages.
+func SetupResendRoutes(app *iris.Application, gateway *Gateway) {
+	app.Post("/messages/{logID}/resend", gateway.basicAuthMiddleware, gateway.webResendMessage)
+}
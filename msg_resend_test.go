@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckResend(t *testing.T) {
+	assert.NoError(t, checkResend(MessageRecord{Status: MsgStatusFailed}, false))
+	assert.NoError(t, checkResend(MessageRecord{Status: MsgStatusQueued}, false))
+
+	for _, status := range []string{MsgStatusSent, MsgStatusDelivered} {
+		assert.ErrorIs(t, checkResend(MessageRecord{Status: status}, false), errResendSucceeded, status)
+		assert.NoError(t, checkResend(MessageRecord{Status: status}, true), "force resends %s messages", status)
+	}
+
+	resent := MessageRecord{Status: MsgStatusFailed, ResentAs: "next"}
+	err := checkResend(resent, false)
+	assert.ErrorIs(t, err, errResendResent)
+	assert.Contains(t, err.Error(), "next")
+	assert.NoError(t, checkResend(resent, true))
+}
+
+func TestSMSResendItem(t *testing.T) {
+	outbound := MessageRecord{
+		LogID:     "orig",
+		Direction: "outbound",
+		Type:      "sms",
+		From:      "+15551234567",
+		To:        "+15557654321",
+		Carrier:   "telnyx",
+		Message:   "hello",
+	}
+	msg := smsResendItem(outbound)
+	assert.NotEqual(t, "orig", msg.LogID, "a resend is a new message")
+	assert.NotEmpty(t, msg.TraceID)
+	assert.Equal(t, MsgQueueItemType.SMS, msg.Type)
+	assert.Equal(t, "+15551234567", msg.From)
+	assert.Equal(t, "+15557654321", msg.To)
+	assert.Equal(t, "hello", msg.message)
+	assert.Empty(t, msg.SourceCarrier, "outbound messages came from a client")
+	assert.Nil(t, msg.Delivery, "retries start over")
+
+	inbound := outbound
+	inbound.Direction = "inbound"
+	msg = smsResendItem(inbound)
+	assert.Equal(t, "telnyx", msg.SourceCarrier)
+	assert.Equal(t, "carrier", resendOrigin(inbound))
+}
+
+func TestFailedSMSBody(t *testing.T) {
+	_, gw := newTestRouter(1)
+	gw.Clients = map[string]*Client{
+		"pbx1":    {ID: 1, Username: "pbx1"},
+		"private": {ID: 2, Username: "private", LogPrivacyLevel: LogPrivacyFull},
+	}
+	record := MsgRecord{
+		MsgQueueItem: MsgQueueItem{Type: MsgQueueItemType.SMS, message: "hello"},
+		ClientID:     1,
+		Status:       MsgStatusFailed,
+	}
+	assert.Equal(t, "hello", gw.failedSMSBody(record))
+
+	record.ClientID = 2
+	assert.Empty(t, gw.failedSMSBody(record), "full log privacy keeps no body")
+	record.ClientID = 1
+	gw.Config.LogPrivacy = LogPrivacyFull
+	assert.Empty(t, gw.failedSMSBody(record), "nor does a full LOG_PRIVACY default")
+	gw.Config.LogPrivacy = ""
+
+	record.Status = MsgStatusSent
+	assert.Empty(t, gw.failedSMSBody(record), "only failed messages keep their body")
+
+	record.Status = MsgStatusFailed
+	record.MsgQueueItem.Type = MsgQueueItemType.MMS
+	assert.Empty(t, gw.failedSMSBody(record), "MMS are resent from the persisted queue")
+}
+
+func TestInjectResend(t *testing.T) {
+	r, gw := newTestRouter(1)
+	gw.ConvoManager = NewConvoManager()
+
+	gw.injectResend(&MsgQueueItem{LogID: "c1", From: "+15551234567", To: "+15557654321"}, "client")
+	select {
+	case got := <-r.ClientMsgChan:
+		assert.Equal(t, "c1", got.LogID)
+	default:
+		t.Fatal("client resend not dispatched")
+	}
+
+	gw.injectResend(&MsgQueueItem{LogID: "k1", SourceCarrier: "telnyx"}, "carrier")
+	select {
+	case got := <-r.CarrierMsgChan:
+		assert.Equal(t, "k1", got.LogID)
+	default:
+		t.Fatal("carrier resend not dispatched")
+	}
+}
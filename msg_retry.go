@@ -0,0 +1,275 @@
+package main
+
+import (
+	"container/heap"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RetryPolicy controls how failed deliveries are re-queued.
+type RetryPolicy struct {
+	BaseDelay     time.Duration // delay before the first retry; doubles each attempt
+	MaxDelay      time.Duration // upper bound on any single delay
+	Jitter        float64       // +/- fraction applied to each delay (0.2 = ±20%)
+	MaxRetriesSMS int
+	MaxRetriesMMS int
+	SpillAfter    int // retries from this attempt on go through the spill queue; 0 only when the channel is busy
+}
+
+func retryPolicyFromConfig(config GatewayConfig) RetryPolicy {
+	return RetryPolicy{
+		BaseDelay:     time.Duration(config.RetryBaseDelaySecs) * time.Second,
+		MaxDelay:      time.Duration(config.RetryMaxDelaySecs) * time.Second,
+		Jitter:        0.2,
+		MaxRetriesSMS: config.SMPPRetries,
+		MaxRetriesMMS: config.MM4Retries,
+		SpillAfter:    config.RetrySpillAfter,
+	}
+}
+
+func (p RetryPolicy) maxRetries(msgType MsgQueueType) int {
+	if msgType == MsgQueueItemType.MMS {
+		return p.MaxRetriesMMS
+	}
+	return p.MaxRetriesSMS
+}
+
+// backoff returns the delay before retry number attempt (1-based):
+// BaseDelay * 2^(attempt-1), jittered, and capped at MaxDelay.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		delay = time.Duration(float64(delay) * (1 + p.Jitter*(2*rand.Float64()-1)))
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+type pendingRetry struct {
+	msg   MsgQueueItem
+	queue chan MsgQueueItem
+}
+
+// retryHeap orders pending retries by Delivery.RetryTime.
+type retryHeap []pendingRetry
+
+func (h retryHeap) Len() int { return len(h) }
+func (h retryHeap) Less(i, j int) bool {
+	return h[i].msg.Delivery.RetryTime.Before(h[j].msg.Delivery.RetryTime)
+}
+func (h retryHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *retryHeap) Push(x any)   { *h = append(*h, x.(pendingRetry)) }
+func (h *retryHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// retryScheduler holds messages waiting for their RetryTime and re-pushes
+// them onto their queue from a single goroutine once it has passed.
+type retryScheduler struct {
+	mu       sync.Mutex
+	policy   RetryPolicy
+	lm       *LogManager
+	onStatus func(msg MsgQueueItem, status string)
+	spill    MsgSpillQueue
+	spillFor map[chan MsgQueueItem]string // spill queue name by channel
+	pending  retryHeap
+	wake     chan struct{}
+	once     sync.Once
+}
+
+var msgRetryScheduler = newRetryScheduler(RetryPolicy{
+	BaseDelay:     10 * time.Second,
+	MaxDelay:      5 * time.Minute,
+	Jitter:        0.2,
+	MaxRetriesSMS: 3,
+	MaxRetriesMMS: 3,
+})
+
+func newRetryScheduler(policy RetryPolicy) *retryScheduler {
+	return &retryScheduler{
+		policy: policy,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// configure replaces the policy and log manager used for future retries.
+func (s *retryScheduler) configure(policy RetryPolicy, lm *LogManager) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policy = policy
+	s.lm = lm
+}
+
+// setSpill registers the queue that takes retries for the channels in
+// queues, keyed by spill queue name.
+func (s *retryScheduler) setSpill(spill MsgSpillQueue, queues map[string]chan MsgQueueItem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.spill = spill
+	s.spillFor = make(map[chan MsgQueueItem]string, len(queues))
+	for name, ch := range queues {
+		s.spillFor[ch] = name
+	}
+}
+
+// setStatusHook registers fn to be told when a message is queued for retry
+// or has permanently failed.
+func (s *retryScheduler) setStatusHook(fn func(msg MsgQueueItem, status string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onStatus = fn
+}
+
+// notifyStatus passes msg to the status hook, if any.
+func (s *retryScheduler) notifyStatus(msg *MsgQueueItem, status string) {
+	s.mu.Lock()
+	fn := s.onStatus
+	s.mu.Unlock()
+	if fn != nil {
+		fn(*msg, status)
+	}
+}
+
+func (s *retryScheduler) getPolicy() RetryPolicy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.policy
+}
+
+func (s *retryScheduler) logPermanentFailure(msg *MsgQueueItem) {
+	s.mu.Lock()
+	lm := s.lm
+	s.mu.Unlock()
+	if lm == nil {
+		return
+	}
+	lm.SendLog(lm.BuildLog(
+		"Router.Retry",
+		"PermanentFailure",
+		logrus.ErrorLevel,
+		map[string]interface{}{
+			"logID":      msg.LogID,
+			"traceID":    msg.TraceID,
+			"type":       msg.Type,
+			"from":       msg.From,
+			"to":         msg.To,
+			"retryCount": msg.Delivery.RetryCount,
+			"lastError":  msg.Delivery.Error,
+		},
+	))
+}
+
+// schedule queues a copy of msg to be pushed onto queue at its RetryTime.
+func (s *retryScheduler) schedule(msg MsgQueueItem, queue chan MsgQueueItem) {
+	s.once.Do(func() { go s.run() })
+
+	s.mu.Lock()
+	heap.Push(&s.pending, pendingRetry{msg: msg, queue: queue})
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pendingCount returns the number of messages waiting to be retried.
+func (s *retryScheduler) pendingCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pending)
+}
+
+func (s *retryScheduler) run() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		now := time.Now()
+		var due []pendingRetry
+		for len(s.pending) > 0 && !s.pending[0].msg.Delivery.RetryTime.After(now) {
+			due = append(due, heap.Pop(&s.pending).(pendingRetry))
+		}
+		wait := time.Hour
+		if len(s.pending) > 0 {
+			wait = s.pending[0].msg.Delivery.RetryTime.Sub(now)
+		}
+		s.mu.Unlock()
+
+		for _, p := range due {
+			p.msg.QueuedTimestamp = now
+			s.push(p)
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// push hands a due retry to its queue without holding up the schedule. When
+// the channel has no receiver waiting, or the message has been retried
+// SpillAfter times, it goes to the spill queue if that is ready; otherwise
+// it is pushed from a goroutine of its own.
+func (s *retryScheduler) push(p pendingRetry) {
+	s.mu.Lock()
+	spill, name, spillAfter, lm := s.spill, s.spillFor[p.queue], s.policy.SpillAfter, s.lm
+	s.mu.Unlock()
+
+	late := spillAfter > 0 && p.msg.Delivery != nil && p.msg.Delivery.RetryCount >= spillAfter
+	if !late {
+		select {
+		case p.queue <- p.msg:
+			return
+		default:
+		}
+	}
+	if spill != nil && name != "" && spill.Ready() {
+		err := spill.Spill(name, p.msg)
+		if err == nil {
+			return
+		}
+		if lm != nil {
+			lm.SendLog(lm.BuildLog(
+				"Router.Retry",
+				"SpillError",
+				logrus.WarnLevel,
+				map[string]interface{}{
+					"logID":   p.msg.LogID,
+					"traceID": p.msg.TraceID,
+					"queue":   name,
+				}, err,
+			))
+		}
+	}
+	// The router channels are unbuffered; don't let one busy queue hold up
+	// the rest of the schedule.
+	go func(p pendingRetry) { p.queue <- p.msg }(p)
+}
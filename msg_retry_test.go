@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicy_BackoffGrowsAndCaps(t *testing.T) {
+	p := RetryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	assert.Equal(t, 1*time.Second, p.backoff(1))
+	assert.Equal(t, 2*time.Second, p.backoff(2))
+	assert.Equal(t, 4*time.Second, p.backoff(3))
+	assert.Equal(t, 8*time.Second, p.backoff(4))
+	assert.Equal(t, 10*time.Second, p.backoff(5))
+	assert.Equal(t, 10*time.Second, p.backoff(50))
+}
+
+func TestRetryPolicy_JitterStaysInBounds(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 10 * time.Second, MaxDelay: time.Minute, Jitter: 0.2}
+	for i := 0; i < 100; i++ {
+		d := p.backoff(1)
+		assert.GreaterOrEqual(t, d, 8*time.Second)
+		assert.LessOrEqual(t, d, 12*time.Second)
+		assert.LessOrEqual(t, p.backoff(10), time.Minute)
+	}
+}
+
+// withRetryPolicy swaps the global scheduler policy for the duration of a test.
+func withRetryPolicy(t *testing.T, policy RetryPolicy) {
+	t.Helper()
+	prev := msgRetryScheduler.getPolicy()
+	msgRetryScheduler.configure(policy, nil)
+	t.Cleanup(func() { msgRetryScheduler.configure(prev, nil) })
+}
+
+func TestMsgQueueItem_RetrySchedulesWithBackoff(t *testing.T) {
+	withRetryPolicy(t, RetryPolicy{BaseDelay: 50 * time.Millisecond, MaxDelay: time.Second, MaxRetriesSMS: 3})
+
+	queue := make(chan MsgQueueItem, 1)
+	msg := MsgQueueItem{LogID: "r1", Type: MsgQueueItemType.SMS}
+
+	start := time.Now()
+	require.False(t, msg.Retry("send failed", queue))
+	assert.Equal(t, 1, msg.Delivery.RetryCount)
+	assert.Equal(t, "send failed", msg.Delivery.Error)
+	assert.True(t, msg.Delivery.RetryTime.After(start))
+
+	select {
+	case got := <-queue:
+		assert.Equal(t, "r1", got.LogID)
+		assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond, "must not re-queue before RetryTime")
+	case <-time.After(2 * time.Second):
+		t.Fatal("message was never re-queued")
+	}
+}
+
+func TestMsgQueueItem_RetryDiscardsAfterMax(t *testing.T) {
+	withRetryPolicy(t, RetryPolicy{BaseDelay: time.Hour, MaxDelay: time.Hour, MaxRetriesSMS: 2})
+
+	queue := make(chan MsgQueueItem, 1)
+	msg := MsgQueueItem{LogID: "r2", Type: MsgQueueItemType.SMS, Delivery: &MsgQueueDelivery{RetryCount: 2}}
+
+	before := msgRetryScheduler.pendingCount()
+	assert.True(t, msg.Retry("still failing", queue))
+	assert.Equal(t, 2, msg.Delivery.RetryCount)
+	assert.Equal(t, before, msgRetryScheduler.pendingCount())
+}
+
+func TestMsgQueueItem_RetryBlackHoleSentinel(t *testing.T) {
+	withRetryPolicy(t, RetryPolicy{BaseDelay: time.Hour, MaxDelay: time.Hour, MaxRetriesSMS: 3})
+
+	queue := make(chan MsgQueueItem, 1)
+	msg := MsgQueueItem{LogID: "r3", Delivery: &MsgQueueDelivery{RetryCount: 666}}
+
+	before := msgRetryScheduler.pendingCount()
+	assert.False(t, msg.Retry("discard", queue))
+	assert.Equal(t, 666, msg.Delivery.RetryCount)
+	assert.Equal(t, before, msgRetryScheduler.pendingCount())
+}
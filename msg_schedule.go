@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+	"zultys-smpp-mm4/smpp/coding"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// ScheduledMessage statuses.
+const (
+	ScheduledMessageStatusScheduled = "scheduled"
+	ScheduledMessageStatusReleasing = "releasing"
+)
+
+// ScheduledMessage is a message held until SendAfter, e.g. an SMPP submit_sm
+// with a schedule_delivery_time. It is kept in the database so it survives a
+// restart; the release sweeper hands it to the normal intake once due.
+type ScheduledMessage struct {
+	ID          uint         `gorm:"primaryKey" json:"id"`
+	ServerID    string       `gorm:"index" json:"server_id"`
+	Status      string       `gorm:"index;default:'scheduled'" json:"status"`
+	LogID       string       `gorm:"index" json:"log_id"`
+	TraceID     string       `json:"trace_id"`
+	Type        MsgQueueType `json:"type"`
+	To          string       `json:"to"`
+	From        string       `json:"from"`
+	Message     string       `gorm:"type:text" json:"message,omitempty"`
+	Priority    MsgPriority  `json:"priority"`
+	ReceiptJSON string       `gorm:"column:receipt;type:text" json:"-"` // JSON SMPPReceiptRequest
+	SendAfter   time.Time    `gorm:"index" json:"send_after"`
+	ValidUntil  time.Time    `json:"valid_until,omitempty"`
+	ReceivedAt  time.Time    `json:"received_at"`
+	CreatedAt   time.Time    `json:"created_at"`
+
+	// SMPP passthrough of the submit_sm
+	ServiceType  string `json:"service_type,omitempty"`
+	ESMClass     byte   `json:"esm_class"`
+	UDHJSON      string `gorm:"column:udh;type:text" json:"-"` // JSON pdu.UserDataHeader
+	Binary       []byte `json:"-"`
+	BinaryCoding byte   `json:"-"`
+}
+
+// holdScheduled persists msg until its SendAfter time.
+func (router *Router) holdScheduled(msg MsgQueueItem) error {
+	gateway := router.gateway
+
+	scheduled, err := newScheduledMessage(gateway.ServerID, msg)
+	if err != nil {
+		return err
+	}
+	if err := gateway.DB.Create(&scheduled).Error; err != nil {
+		return err
+	}
+
+	lm := gateway.LogManager
+	lm.SendLog(lm.BuildLog(
+		"Router.Schedule",
+		"MessageScheduled",
+		logrus.InfoLevel,
+		map[string]interface{}{
+			"logID":     msg.LogID,
+			"traceID":   msg.TraceID,
+			"to":        msg.To,
+			"from":      msg.From,
+			"sendAfter": msg.SendAfter,
+		},
+	))
+	return nil
+}
+
+// newScheduledMessage returns the row that holds msg for serverID.
+func newScheduledMessage(serverID string, msg MsgQueueItem) (ScheduledMessage, error) {
+	var receiptJSON string
+	if msg.DeliveryReceipt != nil {
+		b, err := json.Marshal(msg.DeliveryReceipt)
+		if err != nil {
+			return ScheduledMessage{}, err
+		}
+		receiptJSON = string(b)
+	}
+	var udhJSON string
+	if msg.UDH != nil {
+		b, err := json.Marshal(msg.UDH)
+		if err != nil {
+			return ScheduledMessage{}, err
+		}
+		udhJSON = string(b)
+	}
+	esmClass, _ := msg.ESMClass.ReadByte()
+
+	return ScheduledMessage{
+		ServerID:     serverID,
+		Status:       ScheduledMessageStatusScheduled,
+		LogID:        msg.LogID,
+		TraceID:      msg.TraceID,
+		Type:         msg.Type,
+		To:           msg.To,
+		From:         msg.From,
+		Message:      msg.message,
+		Priority:     msg.Priority,
+		ReceiptJSON:  receiptJSON,
+		SendAfter:    msg.SendAfter,
+		ValidUntil:   msg.ValidUntil,
+		ReceivedAt:   msg.ReceivedTimestamp,
+		ServiceType:  msg.ServiceType,
+		ESMClass:     esmClass,
+		UDHJSON:      udhJSON,
+		Binary:       msg.binary,
+		BinaryCoding: byte(msg.binaryCoding),
+	}, nil
+}
+
+// toMsgQueueItem rebuilds the queue item for a released message.
+func (s *ScheduledMessage) toMsgQueueItem() (MsgQueueItem, error) {
+	msg := MsgQueueItem{
+		To:                s.To,
+		From:              s.From,
+		ReceivedTimestamp: s.ReceivedAt,
+		Type:              s.Type,
+		message:           s.Message,
+		LogID:             s.LogID,
+		TraceID:           s.TraceID,
+		Priority:          s.Priority,
+		SendAfter:         s.SendAfter,
+		ValidUntil:        s.ValidUntil,
+		ServiceType:       s.ServiceType,
+		binary:            s.Binary,
+		binaryCoding:      coding.DataCoding(s.BinaryCoding),
+	}
+	_ = msg.ESMClass.WriteByte(s.ESMClass)
+	if s.UDHJSON != "" {
+		if err := json.Unmarshal([]byte(s.UDHJSON), &msg.UDH); err != nil {
+			return msg, err
+		}
+	}
+	if s.ReceiptJSON != "" {
+		var receipt SMPPReceiptRequest
+		if err := json.Unmarshal([]byte(s.ReceiptJSON), &receipt); err != nil {
+			return msg, err
+		}
+		msg.DeliveryReceipt = &receipt
+	}
+	return msg, nil
+}
+
+// releaseScheduledMessages hands due scheduled messages for this SERVER_ID to
+// the conversation manager at startup and then on every interval.
+func (gateway *Gateway) releaseScheduledMessages(interval time.Duration) {
+	lm := gateway.LogManager
+
+	// Anything left "releasing" was claimed when the process stopped and may
+	// not have reached the router; it is released again.
+	if err := gateway.DB.Model(&ScheduledMessage{}).
+		Where("server_id = ? AND status = ?", gateway.ServerID, ScheduledMessageStatusReleasing).
+		Update("status", ScheduledMessageStatusScheduled).Error; err != nil {
+		lm.SendLog(lm.BuildLog(
+			"Router.Schedule",
+			"ResetReleasingError",
+			logrus.ErrorLevel,
+			map[string]interface{}{
+				"serverID": gateway.ServerID,
+			}, err,
+		))
+	}
+
+	gateway.sweepScheduledMessages(time.Now())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		gateway.sweepScheduledMessages(now)
+	}
+}
+
+// dueScheduledMessages returns the query for scheduled messages of serverID
+// due at now that no sweep has claimed.
+func dueScheduledMessages(db *gorm.DB, serverID string, now time.Time) *gorm.DB {
+	return db.Where("server_id = ? AND status = ? AND send_after <= ?", serverID, ScheduledMessageStatusScheduled, now).
+		Order("send_after").Limit(500)
+}
+
+func (gateway *Gateway) sweepScheduledMessages(now time.Time) {
+	lm := gateway.LogManager
+
+	// Rows are claimed by marking them releasing, so another sweep cannot
+	// release the same message twice, and deleted once handed to the router.
+	var due []ScheduledMessage
+	err := gateway.DB.Transaction(func(tx *gorm.DB) error {
+		if err := dueScheduledMessages(tx, gateway.ServerID, now).Find(&due).Error; err != nil {
+			return err
+		}
+		if len(due) == 0 {
+			return nil
+		}
+		ids := make([]uint, len(due))
+		for i, s := range due {
+			ids[i] = s.ID
+		}
+		return tx.Model(&ScheduledMessage{}).Where("id IN ?", ids).
+			Update("status", ScheduledMessageStatusReleasing).Error
+	})
+	if err != nil {
+		lm.SendLog(lm.BuildLog(
+			"Router.Schedule",
+			"ReleaseError",
+			logrus.ErrorLevel,
+			map[string]interface{}{
+				"serverID": gateway.ServerID,
+			}, err,
+		))
+		return
+	}
+
+	for _, scheduled := range due {
+		msg, err := scheduled.toMsgQueueItem()
+		if err != nil {
+			lm.SendLog(lm.BuildLog(
+				"Router.Schedule",
+				"LoadError",
+				logrus.ErrorLevel,
+				map[string]interface{}{
+					"logID":   scheduled.LogID,
+					"traceID": scheduled.TraceID,
+				}, err,
+			))
+			// The text still goes out, just without what failed to load.
+		}
+		msg.QueuedTimestamp = now
+		if gateway.SMPPServer != nil {
+			gateway.SMPPServer.submitStates.release(msg.LogID)
+		}
+
+		lm.SendLog(lm.BuildLog(
+			"Router.Schedule",
+			"MessageReleased",
+			logrus.InfoLevel,
+			map[string]interface{}{
+				"logID":     msg.LogID,
+				"traceID":   msg.TraceID,
+				"to":        msg.To,
+				"from":      msg.From,
+				"sendAfter": scheduled.SendAfter,
+			},
+		))
+		gateway.ConvoManager.AddMessage(computeCorrelationKey(msg.From, msg.To), msg, gateway.Router)
+
+		// A row that fails to delete stays releasing and is released again
+		// after a restart.
+		if err := gateway.DB.Delete(&ScheduledMessage{}, scheduled.ID).Error; err != nil {
+			lm.SendLog(lm.BuildLog(
+				"Router.Schedule",
+				"DeleteScheduledError",
+				logrus.ErrorLevel,
+				map[string]interface{}{
+					"logID":       msg.LogID,
+					"traceID":     msg.TraceID,
+					"scheduledID": scheduled.ID,
+				}, err,
+			))
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// defaultSpillLimit bounds the retries the in-memory spill queue holds.
+const defaultSpillLimit = 10000
+
+var errSpillFull = errors.New("spill queue full")
+
+// MsgSpillQueue takes retries the scheduler cannot hand straight to their
+// router channel and re-injects them once the router takes them. Queues are
+// named after the channels they feed: "client", "client_priority",
+// "carrier" and "carrier_priority". A broker-backed implementation, such as
+// the AMQP client commented out in main.go publishing to those queues and
+// consuming them back onto the channels, would carry retries across a
+// restart; memorySpillQueue, the default, keeps them in process.
+type MsgSpillQueue interface {
+	// Ready reports whether Spill can take messages now. While it cannot,
+	// the scheduler keeps retries in memory.
+	Ready() bool
+	// Spill queues msg for the named router channel.
+	Spill(queue string, msg MsgQueueItem) error
+}
+
+// spillQueues returns the router channels retries are pushed onto, by
+// spill queue name.
+func (router *Router) spillQueues() map[string]chan MsgQueueItem {
+	return map[string]chan MsgQueueItem{
+		"client":           router.ClientMsgChan,
+		"client_priority":  router.ClientPriorityMsgChan,
+		"carrier":          router.CarrierMsgChan,
+		"carrier_priority": router.CarrierPriorityMsgChan,
+	}
+}
+
+// memorySpillQueue holds spilled retries in memory, in order per queue, and
+// feeds each queue's channel from one goroutine while it has messages, so a
+// saturated router costs one goroutine per channel rather than one per
+// retry. It is not ready once it holds limit messages.
+type memorySpillQueue struct {
+	mu       sync.Mutex
+	queues   map[string]chan MsgQueueItem
+	pending  map[string][]MsgQueueItem
+	draining map[string]bool
+	held     int
+	limit    int
+}
+
+func newMemorySpillQueue(queues map[string]chan MsgQueueItem, limit int) *memorySpillQueue {
+	return &memorySpillQueue{
+		queues:   queues,
+		pending:  make(map[string][]MsgQueueItem),
+		draining: make(map[string]bool),
+		limit:    limit,
+	}
+}
+
+func (q *memorySpillQueue) Ready() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.held < q.limit
+}
+
+func (q *memorySpillQueue) Spill(queue string, msg MsgQueueItem) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	ch, ok := q.queues[queue]
+	if !ok {
+		return fmt.Errorf("unknown spill queue %q", queue)
+	}
+	if q.held >= q.limit {
+		return errSpillFull
+	}
+	q.pending[queue] = append(q.pending[queue], msg)
+	q.held++
+	if !q.draining[queue] {
+		q.draining[queue] = true
+		go q.drain(queue, ch)
+	}
+	return nil
+}
+
+// drain pushes queue's messages onto ch until none are left.
+func (q *memorySpillQueue) drain(queue string, ch chan MsgQueueItem) {
+	for {
+		q.mu.Lock()
+		if len(q.pending[queue]) == 0 {
+			q.draining[queue] = false
+			q.mu.Unlock()
+			return
+		}
+		msg := q.pending[queue][0]
+		q.pending[queue] = q.pending[queue][1:]
+		q.mu.Unlock()
+
+		ch <- msg
+
+		q.mu.Lock()
+		q.held--
+		q.mu.Unlock()
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingSpill is a MsgSpillQueue that keeps what it is given.
+type recordingSpill struct {
+	mu      sync.Mutex
+	ready   bool
+	spilled map[string][]string
+}
+
+func (s *recordingSpill) Ready() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ready
+}
+
+func (s *recordingSpill) Spill(queue string, msg MsgQueueItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.spilled == nil {
+		s.spilled = make(map[string][]string)
+	}
+	s.spilled[queue] = append(s.spilled[queue], msg.LogID)
+	return nil
+}
+
+func (s *recordingSpill) logIDs(queue string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.spilled[queue]...)
+}
+
+func dueRetry(logID string, retries int) MsgQueueItem {
+	return MsgQueueItem{LogID: logID, Delivery: &MsgQueueDelivery{RetryTime: time.Now(), RetryCount: retries}}
+}
+
+func TestRetryScheduler_SpillsWhenChannelSaturated(t *testing.T) {
+	s := newRetryScheduler(RetryPolicy{})
+	queue := make(chan MsgQueueItem) // nobody receiving
+	spill := &recordingSpill{ready: true}
+	s.setSpill(spill, map[string]chan MsgQueueItem{"carrier": queue})
+
+	for _, id := range []string{"s1", "s2", "s3"} {
+		s.schedule(dueRetry(id, 1), queue)
+	}
+
+	require.Eventually(t, func() bool { return len(spill.logIDs("carrier")) == 3 }, 2*time.Second, 5*time.Millisecond)
+	assert.ElementsMatch(t, []string{"s1", "s2", "s3"}, spill.logIDs("carrier"))
+}
+
+func TestRetryScheduler_SpillNotReadyFallsBackToMemory(t *testing.T) {
+	s := newRetryScheduler(RetryPolicy{})
+	queue := make(chan MsgQueueItem)
+	spill := &recordingSpill{ready: false}
+	s.setSpill(spill, map[string]chan MsgQueueItem{"client": queue})
+
+	s.schedule(dueRetry("m1", 1), queue)
+
+	// Nobody is receiving when the retry comes due; it must still arrive
+	// once the router catches up.
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case got := <-queue:
+		assert.Equal(t, "m1", got.LogID)
+	case <-time.After(2 * time.Second):
+		t.Fatal("retry was lost while the spill queue was not ready")
+	}
+	assert.Empty(t, spill.logIDs("client"))
+}
+
+func TestRetryScheduler_SpillsAfterThreshold(t *testing.T) {
+	s := newRetryScheduler(RetryPolicy{SpillAfter: 2})
+	queue := make(chan MsgQueueItem, 2) // room to spare
+	spill := &recordingSpill{ready: true}
+	s.setSpill(spill, map[string]chan MsgQueueItem{"client": queue})
+
+	s.schedule(dueRetry("early", 1), queue)
+	s.schedule(dueRetry("late", 2), queue)
+
+	require.Eventually(t, func() bool { return len(spill.logIDs("client")) == 1 }, 2*time.Second, 5*time.Millisecond)
+	assert.Equal(t, []string{"late"}, spill.logIDs("client"))
+	select {
+	case got := <-queue:
+		assert.Equal(t, "early", got.LogID)
+	case <-time.After(2 * time.Second):
+		t.Fatal("retry under the threshold never reached its channel")
+	}
+}
+
+func TestMemorySpillQueue_ReinjectsInOrder(t *testing.T) {
+	queue := make(chan MsgQueueItem) // saturated until the reader starts
+	q := newMemorySpillQueue(map[string]chan MsgQueueItem{"carrier": queue}, 2)
+
+	require.NoError(t, q.Spill("carrier", dueRetry("q1", 1)))
+	require.NoError(t, q.Spill("carrier", dueRetry("q2", 1)))
+	assert.False(t, q.Ready(), "a full spill queue must not take more")
+	assert.ErrorIs(t, q.Spill("carrier", dueRetry("q3", 1)), errSpillFull)
+	assert.Error(t, q.Spill("nowhere", dueRetry("q4", 1)))
+
+	for _, want := range []string{"q1", "q2"} {
+		select {
+		case got := <-queue:
+			assert.Equal(t, want, got.LogID)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s was never re-injected", want)
+		}
+	}
+	require.Eventually(t, q.Ready, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestRetryScheduler_SaturatedChannelDrainsThroughMemorySpill(t *testing.T) {
+	s := newRetryScheduler(RetryPolicy{})
+	queue := make(chan MsgQueueItem)
+	queues := map[string]chan MsgQueueItem{"client": queue}
+	s.setSpill(newMemorySpillQueue(queues, defaultSpillLimit), queues)
+
+	ids := []string{"d1", "d2", "d3", "d4"}
+	for _, id := range ids {
+		s.schedule(dueRetry(id, 1), queue)
+	}
+	require.Eventually(t, func() bool { return s.pendingCount() == 0 }, 2*time.Second, 5*time.Millisecond)
+
+	var got []string
+	for range ids {
+		select {
+		case msg := <-queue:
+			got = append(got, msg.LogID)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("only %v re-injected", got)
+		}
+	}
+	assert.ElementsMatch(t, ids, got)
+}
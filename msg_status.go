@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Message outcome statuses tracked in message_records.
+const (
+	MsgStatusQueued    = "queued"
+	MsgStatusSent      = "sent"
+	MsgStatusFailed    = "failed"
+	MsgStatusDelivered = "delivered"
+	MsgStatusBlocked   = "blocked" // refused by the gateway, e.g. recipient opted out
+)
+
+// MessageRecord is the latest known outcome of a message for one client and
+// direction. It is upserted by processMsgRecords as the status changes.
+type MessageRecord struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	LogID          string    `gorm:"uniqueIndex:idx_message_record_key;not null" json:"log_id"`
+	ClientID       uint      `gorm:"uniqueIndex:idx_message_record_key;index;index:idx_message_record_client_time,priority:1" json:"client_id"`
+	Direction      string    `gorm:"uniqueIndex:idx_message_record_key" json:"direction"` // "inbound" or "outbound"
+	Type           string    `json:"type"`                                                // "sms" or "mms"
+	From           string    `json:"from_number"`
+	To             string    `json:"to_number"`
+	Carrier        string    `json:"carrier,omitempty"`
+	CarrierMsgID   string    `gorm:"index" json:"carrier_msg_id,omitempty"` // Provider message ID, for matching DLRs
+	DeliveryMethod string    `json:"delivery_method,omitempty"`
+	Status         string    `gorm:"index;not null" json:"status"`
+	Error          string    `json:"error,omitempty"`
+	Message        string    `gorm:"type:text" json:"-"`  // Body of a failed SMS, kept for resending
+	ResentAs       string    `json:"resent_as,omitempty"` // LogID of the manual resend, if any
+	CreatedAt      time.Time `gorm:"index;index:idx_message_record_client_time,priority:2" json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// msgStatusRank orders statuses so late or duplicate events cannot move a
+// record backwards (e.g. a retry "queued" after a DLR "delivered").
+func msgStatusRank(status string) int {
+	switch status {
+	case MsgStatusQueued:
+		return 1
+	case MsgStatusSent:
+		return 2
+	case MsgStatusFailed, MsgStatusDelivered, MsgStatusBlocked:
+		return 3
+	}
+	return 0
+}
+
+// shouldAdvanceStatus reports whether a record in status current may move to next.
+func shouldAdvanceStatus(current, next string) bool {
+	return msgStatusRank(next) >= msgStatusRank(current)
+}
+
+// upsertMessageStatus creates or advances the message_records row for record.
+// Records carrying only a CarrierMsgID (from carrier DLRs) update whichever
+// rows were sent with that provider ID.
+func (gateway *Gateway) upsertMessageStatus(record MsgRecord) error {
+	item := record.MsgQueueItem
+
+	if record.ClientID == 0 {
+		if record.CarrierMsgID == "" {
+			return nil
+		}
+		var rows []MessageRecord
+		if err := gateway.DB.Where("carrier_msg_id = ?", record.CarrierMsgID).Find(&rows).Error; err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if !shouldAdvanceStatus(row.Status, record.Status) {
+				continue
+			}
+			if err := gateway.DB.Model(&row).Updates(map[string]interface{}{
+				"status": record.Status,
+				"error":  record.Error,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var row MessageRecord
+	err := gateway.DB.Where("log_id = ? AND client_id = ? AND direction = ?", item.LogID, record.ClientID, record.Direction).
+		First(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return gateway.DB.Create(&MessageRecord{
+			LogID:          item.LogID,
+			ClientID:       record.ClientID,
+			Direction:      record.Direction,
+			Type:           string(item.Type),
+			From:           item.From,
+			To:             item.To,
+			Carrier:        record.Carrier,
+			CarrierMsgID:   record.CarrierMsgID,
+			DeliveryMethod: record.DeliveryMethod,
+			Status:         record.Status,
+			Error:          record.Error,
+			Message:        gateway.failedSMSBody(record),
+		}).Error
+	}
+	if err != nil {
+		return err
+	}
+	if !shouldAdvanceStatus(row.Status, record.Status) {
+		return nil
+	}
+
+	updates := map[string]interface{}{
+		"status": record.Status,
+		"error":  record.Error,
+	}
+	if record.Carrier != "" {
+		updates["carrier"] = record.Carrier
+	}
+	if record.CarrierMsgID != "" {
+		updates["carrier_msg_id"] = record.CarrierMsgID
+	}
+	if record.DeliveryMethod != "" {
+		updates["delivery_method"] = record.DeliveryMethod
+	}
+	if body := gateway.failedSMSBody(record); body != "" {
+		updates["message"] = body
+	}
+	return gateway.DB.Model(&row).Updates(updates).Error
+}
+
+// failedSMSBody returns the text kept with a record moving to failed so the
+// message can be resent by POST /messages/{logID}/resend. Only SMS keep
+// theirs here; MMS are resent from the persisted MMS queue, and no other
+// record keeps a body. Clients whose log privacy level is full keep none,
+// as their content is not to be stored in the clear.
+func (gateway *Gateway) failedSMSBody(record MsgRecord) string {
+	if record.Status != MsgStatusFailed || record.MsgQueueItem.Type != MsgQueueItemType.SMS {
+		return ""
+	}
+	if gateway.logPrivacyLevel(gateway.getClientByID(record.ClientID)) == LogPrivacyFull {
+		return ""
+	}
+	return record.MsgQueueItem.message
+}
+
+// recordRetryStatus is the retry scheduler's status hook: it marks a message
+// queued when a retry is scheduled and failed once retries are exhausted.
+// Inbound carrier traffic is attributed to the receiving client, everything
+// else to the sending client.
+func (gateway *Gateway) recordRetryStatus(msg MsgQueueItem, status string) {
+	if gateway.MsgRecordChan == nil || gateway.Router == nil {
+		return
+	}
+
+	direction := "outbound"
+	number := msg.From
+	if msg.SourceCarrier != "" {
+		direction = "inbound"
+		number = msg.To
+	}
+	client, _ := gateway.Router.findClientByNumber(number)
+	if client == nil {
+		return
+	}
+
+	errMsg := ""
+	if msg.Delivery != nil {
+		errMsg = msg.Delivery.Error
+	}
+
+	gateway.MsgRecordChan <- MsgRecord{
+		MsgQueueItem: msg,
+		ClientID:     client.ID,
+		Carrier:      msg.SourceCarrier,
+		Direction:    direction,
+		Status:       status,
+		Error:        errMsg,
+	}
+}
+
+// recordCarrierStatus records a carrier delivery report for the message the
+// carrier knows as carrierMsgID.
+func (gateway *Gateway) recordCarrierStatus(carrierMsgID, status, errMsg string) {
+	if gateway.MsgRecordChan == nil || carrierMsgID == "" {
+		return
+	}
+	gateway.MsgRecordChan <- MsgRecord{
+		CarrierMsgID: carrierMsgID,
+		Status:       status,
+		Error:        errMsg,
+	}
+}
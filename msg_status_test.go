@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldAdvanceStatus(t *testing.T) {
+	assert.True(t, shouldAdvanceStatus("", MsgStatusQueued))
+	assert.True(t, shouldAdvanceStatus(MsgStatusQueued, MsgStatusSent))
+	assert.True(t, shouldAdvanceStatus(MsgStatusQueued, MsgStatusFailed))
+	assert.True(t, shouldAdvanceStatus(MsgStatusSent, MsgStatusDelivered))
+	assert.True(t, shouldAdvanceStatus(MsgStatusSent, MsgStatusFailed))
+	assert.True(t, shouldAdvanceStatus(MsgStatusFailed, MsgStatusDelivered), "a late DLR overrides an earlier failure")
+
+	assert.False(t, shouldAdvanceStatus(MsgStatusDelivered, MsgStatusSent))
+	assert.False(t, shouldAdvanceStatus(MsgStatusSent, MsgStatusQueued))
+	assert.False(t, shouldAdvanceStatus(MsgStatusFailed, MsgStatusQueued))
+}
+
+func TestMsgQueueItem_RetryReportsStatus(t *testing.T) {
+	withRetryPolicy(t, RetryPolicy{BaseDelay: time.Hour, MaxDelay: time.Hour, MaxRetriesSMS: 1})
+
+	var statuses []string
+	msgRetryScheduler.setStatusHook(func(msg MsgQueueItem, status string) {
+		assert.Equal(t, "s1", msg.LogID)
+		statuses = append(statuses, status)
+	})
+	t.Cleanup(func() { msgRetryScheduler.setStatusHook(nil) })
+
+	queue := make(chan MsgQueueItem, 1)
+	msg := MsgQueueItem{LogID: "s1", Type: MsgQueueItemType.SMS}
+
+	require.False(t, msg.Retry("carrier down", queue))
+	require.True(t, msg.Retry("carrier down", queue))
+	assert.Equal(t, []string{MsgStatusQueued, MsgStatusFailed}, statuses)
+}
+
+func TestGateway_RecordRetryStatus(t *testing.T) {
+	_, gw := newTestRouter(1)
+	gw.MsgRecordChan = make(chan MsgRecord, 2)
+	gw.Clients = map[string]*Client{
+		"pbx1": {ID: 3, Username: "pbx1", Numbers: []ClientNumber{{Number: "12505551234"}}},
+	}
+
+	// Outbound from a client is attributed to the sender.
+	gw.recordRetryStatus(MsgQueueItem{
+		LogID:    "o1",
+		From:     "+12505551234",
+		To:       "+14155559876",
+		Delivery: &MsgQueueDelivery{Error: "carrier down"},
+	}, MsgStatusFailed)
+	rec := <-gw.MsgRecordChan
+	assert.Equal(t, uint(3), rec.ClientID)
+	assert.Equal(t, "outbound", rec.Direction)
+	assert.Equal(t, MsgStatusFailed, rec.Status)
+	assert.Equal(t, "carrier down", rec.Error)
+
+	// Inbound from a carrier is attributed to the recipient.
+	gw.recordRetryStatus(MsgQueueItem{
+		LogID:         "i1",
+		From:          "+14155559876",
+		To:            "+12505551234",
+		SourceCarrier: "telnyx",
+	}, MsgStatusQueued)
+	rec = <-gw.MsgRecordChan
+	assert.Equal(t, uint(3), rec.ClientID)
+	assert.Equal(t, "inbound", rec.Direction)
+	assert.Equal(t, "telnyx", rec.Carrier)
+	assert.Equal(t, MsgStatusQueued, rec.Status)
+
+	// Unknown numbers are ignored.
+	gw.recordRetryStatus(MsgQueueItem{LogID: "x1", From: "+19995550000"}, MsgStatusFailed)
+	assert.Len(t, gw.MsgRecordChan, 0)
+}
+
+func TestParseDateParam(t *testing.T) {
+	start, ok := parseDateParam("2026-03-01", false)
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), start)
+
+	end, ok := parseDateParam("2026-03-01", true)
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), end)
+
+	ts, ok := parseDateParam("2026-03-01T12:30:00Z", true)
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC), ts)
+
+	_, ok = parseDateParam("yesterday", false)
+	assert.False(t, ok)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NumberValidator checks that an E.164 destination (as returned by
+// FormatToE164) is dialable before a message is handed to a carrier.
+type NumberValidator interface {
+	Validate(e164 string) error
+}
+
+// Values of NUMBER_VALIDATION.
+const (
+	NumberValidationOff    = "off"
+	NumberValidationStrict = "strict"
+)
+
+// validNumberValidation reports whether mode is a NUMBER_VALIDATION mode.
+func validNumberValidation(mode string) bool {
+	_, err := newNumberValidator(mode)
+	return err == nil
+}
+
+// newNumberValidator returns the validator for a NUMBER_VALIDATION mode, or
+// nil when validation is off.
+func newNumberValidator(mode string) (NumberValidator, error) {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "", NumberValidationOff:
+		return nil, nil
+	case NumberValidationStrict:
+		return strictNumberValidator{}, nil
+	}
+	return nil, fmt.Errorf("unknown number validation mode %q (want off or strict)", mode)
+}
+
+// validateDestination runs the configured validator on an E.164 number.
+// With no validator every number FormatToE164 accepts is valid.
+func (gateway *Gateway) validateDestination(e164 string) error {
+	if gateway.NumberValidator == nil {
+		return nil
+	}
+	if !isE164(e164) {
+		return fmt.Errorf("%s is not an E.164 number", e164)
+	}
+	return gateway.NumberValidator.Validate(e164)
+}
+
+// nationalLengths is the allowed length of the national significant number,
+// after the country code, for countries where it is well defined. Countries
+// not listed only get the E.164 check done by FormatToE164.
+var nationalLengths = map[string][2]int{
+	"1":   {10, 10}, // NANP, structure checked by validateNANP
+	"7":   {10, 10},
+	"20":  {8, 10},
+	"27":  {9, 9},
+	"30":  {10, 10},
+	"31":  {9, 9},
+	"32":  {8, 9},
+	"33":  {9, 9},
+	"34":  {9, 9},
+	"36":  {8, 9},
+	"39":  {6, 11},
+	"40":  {9, 9},
+	"41":  {9, 9},
+	"43":  {4, 13},
+	"44":  {7, 10},
+	"45":  {8, 8},
+	"46":  {7, 13},
+	"47":  {5, 8},
+	"48":  {9, 9},
+	"49":  {6, 13},
+	"52":  {10, 10},
+	"54":  {10, 11},
+	"55":  {10, 11},
+	"56":  {9, 9},
+	"57":  {10, 10},
+	"60":  {7, 10},
+	"61":  {9, 9},
+	"62":  {8, 12},
+	"63":  {8, 10},
+	"64":  {8, 10},
+	"65":  {8, 8},
+	"66":  {8, 9},
+	"81":  {9, 10},
+	"82":  {8, 10},
+	"84":  {9, 10},
+	"86":  {10, 11},
+	"90":  {10, 10},
+	"91":  {10, 10},
+	"92":  {10, 10},
+	"234": {8, 10},
+	"254": {9, 9},
+	"351": {9, 9},
+	"353": {7, 9},
+	"358": {5, 12},
+	"380": {9, 9},
+	"420": {9, 9},
+	"852": {8, 8},
+	"886": {8, 9},
+	"966": {9, 9},
+	"971": {8, 9},
+	"972": {8, 9},
+}
+
+// strictNumberValidator checks NANP structure for +1 numbers and the
+// national number length for the countries in nationalLengths.
+type strictNumberValidator struct{}
+
+func (strictNumberValidator) Validate(e164 string) error {
+	digits := strings.TrimPrefix(e164, "+")
+	if digits == "" {
+		return fmt.Errorf("empty number")
+	}
+
+	// Country codes are prefix-free, so the first match is the only one.
+	for n := 1; n <= 3 && n < len(digits); n++ {
+		cc := digits[:n]
+		lengths, ok := nationalLengths[cc]
+		if !ok {
+			continue
+		}
+		national := digits[n:]
+		if len(national) < lengths[0] || len(national) > lengths[1] {
+			if lengths[0] == lengths[1] {
+				return fmt.Errorf("+%s numbers have %d digits after the country code, got %d", cc, lengths[0], len(national))
+			}
+			return fmt.Errorf("+%s numbers have %d to %d digits after the country code, got %d", cc, lengths[0], lengths[1], len(national))
+		}
+		if cc == "1" {
+			return validateNANP(national)
+		}
+		return nil
+	}
+	return nil
+}
+
+// validateNANP checks a 10-digit NANP number, NPA-NXX-XXXX: neither the area
+// code nor the exchange may start with 0 or 1 or be an N11 service code, and
+// N9X area codes are reserved.
+func validateNANP(national string) error {
+	npa, nxx := national[:3], national[3:6]
+	switch {
+	case npa[0] < '2':
+		return fmt.Errorf("area code %s starts with %c", npa, npa[0])
+	case npa[1:] == "11":
+		return fmt.Errorf("area code %s is a service code", npa)
+	case npa[1] == '9':
+		return fmt.Errorf("area code %s is reserved", npa)
+	case nxx[0] < '2':
+		return fmt.Errorf("exchange %s starts with %c", nxx, nxx[0])
+	case nxx[1:] == "11":
+		return fmt.Errorf("exchange %s is a service code", nxx)
+	}
+	return nil
+}
+
+// validateClientDest checks the destination of a message a client submits,
+// so it can be refused up front. Numbers that belong to a client are
+// delivered internally and are not checked.
+func (gateway *Gateway) validateClientDest(dest string) error {
+	if gateway.NumberValidator == nil {
+		return nil
+	}
+	to, _ := FormatToE164(dest)
+	if client, _ := gateway.Router.findClientByNumber(to); client != nil {
+		return nil
+	}
+	return gateway.validateDestination(to)
+}
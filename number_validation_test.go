@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewNumberValidator(t *testing.T) {
+	v, err := newNumberValidator("")
+	require.NoError(t, err)
+	assert.Nil(t, v)
+
+	v, err = newNumberValidator("off")
+	require.NoError(t, err)
+	assert.Nil(t, v)
+
+	v, err = newNumberValidator(" Strict ")
+	require.NoError(t, err)
+	assert.NotNil(t, v)
+
+	_, err = newNumberValidator("lenient")
+	assert.Error(t, err)
+}
+
+func TestStrictNumberValidator_NANP(t *testing.T) {
+	v := strictNumberValidator{}
+
+	for _, number := range []string{"+14155552671", "+12505551234", "+18005550199"} {
+		assert.NoError(t, v.Validate(number), number)
+	}
+	for _, number := range []string{
+		"+1415555267",   // 9 digits
+		"+141555526710", // 11 digits
+		"+10155552671",  // area code starts with 0
+		"+11155552671",  // area code starts with 1
+		"+19115552671",  // N11 area code
+		"+14915552671",  // reserved N9X area code
+		"+14150552671",  // exchange starts with 0
+		"+14154112671",  // N11 exchange
+	} {
+		assert.Error(t, v.Validate(number), number)
+	}
+}
+
+func TestStrictNumberValidator_CountryLengths(t *testing.T) {
+	v := strictNumberValidator{}
+
+	assert.NoError(t, v.Validate("+447700900123"))  // UK mobile, 10 digits
+	assert.Error(t, v.Validate("+4477009001234"))   // 11 digits
+	assert.NoError(t, v.Validate("+61412345678"))   // Australia, 9 digits
+	assert.Error(t, v.Validate("+6141234567"))      // 8 digits
+	assert.NoError(t, v.Validate("+353851234567"))  // Ireland, 3-digit code
+	assert.NoError(t, v.Validate("+2997001234567")) // unlisted country code
+}
+
+func TestRouter_DecideRouteValidatesCarrierDestinations(t *testing.T) {
+	r := newDecisionRouter(t)
+
+	// Lenient by default.
+	d := r.decideRoute("+12505551234", "+11115552671", MsgQueueItemType.SMS, "client")
+	assert.Equal(t, RoutePathCarrier, d.Path)
+
+	r.gateway.NumberValidator = strictNumberValidator{}
+
+	d = r.decideRoute("+12505551234", "+11115552671", MsgQueueItemType.SMS, "client")
+	assert.Equal(t, RoutePathRejected, d.Path)
+	assert.Contains(t, d.Error, "Invalid destination number")
+
+	d = r.decideRoute("+12505551234", "+14155552671", MsgQueueItemType.SMS, "client")
+	assert.Equal(t, RoutePathCarrier, d.Path)
+	assert.Empty(t, d.Error)
+
+	// Client numbers are delivered internally and never checked.
+	d = r.decideRoute("+14155559876", "+12505551234", MsgQueueItemType.SMS, "carrier")
+	assert.Equal(t, RoutePathSMPP, d.Path)
+}
+
+func TestGateway_ValidateClientDest(t *testing.T) {
+	r := newDecisionRouter(t)
+	gw := r.gateway
+
+	assert.NoError(t, gw.validateClientDest("1115552671"), "validation off")
+
+	gw.NumberValidator = strictNumberValidator{}
+	assert.Error(t, gw.validateClientDest("1115552671"))
+	assert.NoError(t, gw.validateClientDest("14155552671"))
+	assert.NoError(t, gw.validateClientDest("12505551234"), "client number")
+	assert.Error(t, gw.validateClientDest("not-a-number"))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// numberSearchResult is one row of GET /numbers: a number and its owner.
+type numberSearchResult struct {
+	ID             uint   `json:"id"`
+	Number         string `json:"number"`
+	Carrier        string `json:"carrier"`
+	Tag            string `json:"tag"`
+	Group          string `json:"group"`
+	ClientID       uint   `json:"client_id"`
+	ClientUsername string `json:"client_username"`
+}
+
+// numberSearchQuery selects client numbers containing the digits of q and,
+// if set, using carrier, joined to their owning client. The number match is
+// served by the trigram index created in createIndexes.
+func numberSearchQuery(db *gorm.DB, q, carrier string) *gorm.DB {
+	query := db.Table("client_numbers").
+		Select("client_numbers.id, client_numbers.number, client_numbers.carrier, client_numbers.tag, " +
+			"client_numbers.\"group\", client_numbers.client_id, clients.username AS client_username").
+		Joins("JOIN clients ON clients.id = client_numbers.client_id")
+
+	if digits := normalizeRoutePrefix(q); digits != "" {
+		query = query.Where("client_numbers.number LIKE ?", "%"+digits+"%")
+	}
+	if carrier = strings.TrimSpace(carrier); carrier != "" {
+		query = query.Where("client_numbers.carrier = ?", carrier)
+	}
+	return query
+}
+
+// searchNumbers returns one page of numberSearchQuery, ordered by number, and
+// the total number of matches.
+func (gateway *Gateway) searchNumbers(q, carrier string, offset, limit int) ([]numberSearchResult, int64, error) {
+	query := numberSearchQuery(gateway.DB, q, carrier).Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	results := []numberSearchResult{}
+	if err := query.Order("client_numbers.number").Offset(offset).Limit(limit).Scan(&results).Error; err != nil {
+		return nil, 0, err
+	}
+	return results, total, nil
+}
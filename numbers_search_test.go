@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// dryRunDB builds SQL without a database connection.
+func dryRunDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=127.0.0.1 sslmode=disable"}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+	})
+	require.NoError(t, err)
+	return db
+}
+
+func TestNumberSearchQuery(t *testing.T) {
+	db := dryRunDB(t)
+
+	var results []numberSearchResult
+	stmt := numberSearchQuery(db, "+1 (555) 123", "telnyx").Scan(&results).Statement
+	sql := stmt.SQL.String()
+	assert.Contains(t, sql, "JOIN clients ON clients.id = client_numbers.client_id")
+	assert.Contains(t, sql, "clients.username AS client_username")
+	assert.Contains(t, sql, "client_numbers.number LIKE $1 AND client_numbers.carrier = $2")
+	assert.Equal(t, []interface{}{"%1555123%", "telnyx"}, stmt.Vars, "q is reduced to digits")
+
+	stmt = numberSearchQuery(db, "", "").Scan(&results).Statement
+	assert.NotContains(t, stmt.SQL.String(), "WHERE", "no filters lists every number")
+
+	stmt = numberSearchQuery(db, "+", " twilio ").Scan(&results).Statement
+	assert.NotContains(t, stmt.SQL.String(), "LIKE")
+	assert.Equal(t, []interface{}{"twilio"}, stmt.Vars)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Opt-out keywords, as classified by optOutKeyword.
+const (
+	OptOutKeywordStop  = "stop"
+	OptOutKeywordStart = "start"
+	OptOutKeywordHelp  = "help"
+)
+
+// optOutKeywords maps a whole inbound message (upper-cased) to its keyword.
+var optOutKeywords = map[string]string{
+	"STOP":        OptOutKeywordStop,
+	"STOPALL":     OptOutKeywordStop,
+	"UNSUBSCRIBE": OptOutKeywordStop,
+	"CANCEL":      OptOutKeywordStop,
+	"END":         OptOutKeywordStop,
+	"QUIT":        OptOutKeywordStop,
+	"START":       OptOutKeywordStart,
+	"UNSTOP":      OptOutKeywordStart,
+	"HELP":        OptOutKeywordHelp,
+	"INFO":        OptOutKeywordHelp,
+}
+
+// optOutKeyword returns the keyword an inbound message body consists of, or
+// "" when it is ordinary text. Only the whole message counts, so "stop by
+// later" is not an opt-out.
+func optOutKeyword(text string) string {
+	word := strings.ToUpper(strings.Trim(strings.TrimSpace(text), ".!"))
+	return optOutKeywords[word]
+}
+
+// OptOut records that Remote texted STOP to the client number Number. While
+// the row exists the router refuses outbound messages from Number to Remote.
+// Both numbers are stored as digits only.
+type OptOut struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Number    string    `gorm:"uniqueIndex:idx_opt_out_pair;not null" json:"number"`
+	Remote    string    `gorm:"uniqueIndex:idx_opt_out_pair;not null" json:"remote_number"`
+	ClientID  uint      `gorm:"index" json:"client_id"`
+	Keyword   string    `json:"keyword"` // the word that opted out, e.g. "UNSUBSCRIBE"
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// optOutSet is the in-memory copy of the opt_outs table, keyed by
+// (number, remote). The zero value is ready to use.
+type optOutSet struct {
+	mu    sync.RWMutex
+	pairs map[string]bool
+}
+
+func optOutPairKey(number, remote string) string {
+	return strings.TrimPrefix(number, "+") + "|" + strings.TrimPrefix(remote, "+")
+}
+
+// has reports whether remote has opted out of messages from number.
+func (s *optOutSet) has(number, remote string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.pairs[optOutPairKey(number, remote)]
+}
+
+// set marks the pair opted out (or back in) and reports whether that changed
+// anything.
+func (s *optOutSet) set(number, remote string, optedOut bool) bool {
+	key := optOutPairKey(number, remote)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pairs[key] == optedOut {
+		return false
+	}
+	if s.pairs == nil {
+		s.pairs = make(map[string]bool)
+	}
+	if optedOut {
+		s.pairs[key] = true
+	} else {
+		delete(s.pairs, key)
+	}
+	return true
+}
+
+// loadOptOuts loads the opt_outs table into memory.
+func (gateway *Gateway) loadOptOuts() error {
+	var rows []OptOut
+	if err := gateway.DB.Find(&rows).Error; err != nil {
+		return err
+	}
+	pairs := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		pairs[optOutPairKey(row.Number, row.Remote)] = true
+	}
+
+	gateway.optOuts.mu.Lock()
+	gateway.optOuts.pairs = pairs
+	gateway.optOuts.mu.Unlock()
+	return nil
+}
+
+// isOptedOut reports whether remote has texted STOP to the client number.
+func (gateway *Gateway) isOptedOut(number, remote string) bool {
+	return gateway.optOuts.has(number, remote)
+}
+
+// setOptOut opts remote out of (or back into) messages from the client number
+// and persists the change. It reports whether the state changed; repeating
+// STOP or START is a no-op.
+func (gateway *Gateway) setOptOut(client *Client, number, remote, keyword string, optedOut bool) (bool, error) {
+	if gateway.optOuts.has(number, remote) == optedOut {
+		return false, nil
+	}
+
+	if gateway.DB != nil {
+		row := OptOut{
+			Number: strings.TrimPrefix(number, "+"),
+			Remote: strings.TrimPrefix(remote, "+"),
+		}
+		var err error
+		if optedOut {
+			if client != nil {
+				row.ClientID = client.ID
+			}
+			row.Keyword = keyword
+			err = gateway.DB.Where("number = ? AND remote = ?", row.Number, row.Remote).FirstOrCreate(&row).Error
+		} else {
+			err = gateway.DB.Where("number = ? AND remote = ?", row.Number, row.Remote).Delete(&OptOut{}).Error
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return gateway.optOuts.set(number, remote, optedOut), nil
+}
+
+// ResolveHelpReply returns the HELP reply for a client number: the number's
+// own message, else HELP_REPLY_MESSAGE. "" means HELP gets no reply.
+func (gateway *Gateway) ResolveHelpReply(numberSettings *NumberSettings) string {
+	if numberSettings != nil && numberSettings.HelpReplyMessage != "" {
+		return numberSettings.HelpReplyMessage
+	}
+	return gateway.HelpReplyDefaultMsg
+}
+
+// handleOptOutKeyword acts on a STOP, START or HELP texted by m.From to the
+// client number m.To. The message itself is still delivered to the client.
+func (router *Router) handleOptOutKeyword(m *MsgQueueItem, toClient *Client, keyword string) {
+	lm := router.gateway.LogManager
+	fields := map[string]interface{}{
+		"logID":   m.LogID,
+		"client":  safeClientUsername(toClient),
+		"from":    m.From,
+		"to":      m.To,
+		"keyword": keyword,
+	}
+
+	switch keyword {
+	case OptOutKeywordStop, OptOutKeywordStart:
+		optedOut := keyword == OptOutKeywordStop
+		changed, err := router.gateway.setOptOut(toClient, m.To, m.From, strings.ToUpper(strings.TrimSpace(m.message)), optedOut)
+		if err != nil {
+			lm.SendLog(lm.BuildLog("Router.OptOut", "SaveError", logrus.ErrorLevel, fields, err))
+			return
+		}
+		if !changed {
+			return
+		}
+		event := "OptedIn"
+		if optedOut {
+			event = "OptedOut"
+		}
+		lm.SendLog(lm.BuildLog("Router.OptOut", event, logrus.InfoLevel, fields))
+
+	case OptOutKeywordHelp:
+		reply := router.gateway.ResolveHelpReply(findNumberSettingsForClient(toClient, m.To))
+		if reply == "" {
+			return
+		}
+		// Share the auto-reply cooldown so a HELP never draws two replies.
+		if !globalAutoReplyCooldown.allow(m.From, m.To, 60) {
+			lm.SendLog(lm.BuildLog("Router.OptOut", "HelpSuppressedByCooldown", logrus.DebugLevel, fields))
+			return
+		}
+		lm.SendLog(lm.BuildLog("Router.OptOut", "HelpReply", logrus.InfoLevel, fields))
+		router.sendAutoReply(reply, m)
+	}
+}
+
+// blockOptedOut drops a client message to a recipient who texted STOP,
+// reporting it to the sender as undeliverable.
+func (router *Router) blockOptedOut(m *MsgQueueItem, fromClient *Client) {
+	lm := router.gateway.LogManager
+	lm.SendLog(lm.BuildLog(
+		"Router.OptOut",
+		"BlockedOptedOut",
+		logrus.WarnLevel,
+		map[string]interface{}{
+			"logID":  m.LogID,
+			"client": safeClientUsername(fromClient),
+			"from":   m.From,
+			"to":     m.To,
+		},
+	))
+
+	router.gateway.SMPPServer.sendDeliveryReceipt(m, DLRStatUndeliverable, DLRErrStopBlocked)
+	if router.gateway.MsgRecordChan != nil {
+		router.gateway.MsgRecordChan <- MsgRecord{
+			MsgQueueItem: *m,
+			ClientID:     fromClient.ID,
+			Direction:    "outbound",
+			Status:       MsgStatusBlocked,
+			Error:        "recipient opted out",
+		}
+	}
+}
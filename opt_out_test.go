@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptOutKeyword(t *testing.T) {
+	assert.Equal(t, OptOutKeywordStop, optOutKeyword("STOP"))
+	assert.Equal(t, OptOutKeywordStop, optOutKeyword("  stop.\n"))
+	assert.Equal(t, OptOutKeywordStop, optOutKeyword("Unsubscribe"))
+	assert.Equal(t, OptOutKeywordStop, optOutKeyword("quit!"))
+	assert.Equal(t, OptOutKeywordStart, optOutKeyword("start"))
+	assert.Equal(t, OptOutKeywordStart, optOutKeyword("UNSTOP"))
+	assert.Equal(t, OptOutKeywordHelp, optOutKeyword("Help"))
+	assert.Equal(t, OptOutKeywordHelp, optOutKeyword("info"))
+
+	assert.Empty(t, optOutKeyword("stop by later"), "only the whole message counts")
+	assert.Empty(t, optOutKeyword("STOPPED"))
+	assert.Empty(t, optOutKeyword(""))
+}
+
+func TestGateway_SetOptOutTransitions(t *testing.T) {
+	_, gw := newTestRouter(1)
+	client := &Client{ID: 3, Username: "pbx1"}
+
+	assert.False(t, gw.isOptedOut("+12505551234", "+14155559876"))
+
+	changed, err := gw.setOptOut(client, "+12505551234", "+14155559876", "STOP", true)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.True(t, gw.isOptedOut("+12505551234", "+14155559876"))
+	assert.True(t, gw.isOptedOut("12505551234", "14155559876"), "pairs match with or without +")
+	assert.False(t, gw.isOptedOut("+12505550000", "+14155559876"), "opt-out is per client number")
+
+	changed, err = gw.setOptOut(client, "+12505551234", "+14155559876", "STOPALL", true)
+	require.NoError(t, err)
+	assert.False(t, changed, "a repeated STOP changes nothing")
+
+	changed, err = gw.setOptOut(client, "+12505551234", "+14155559876", "START", false)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.False(t, gw.isOptedOut("+12505551234", "+14155559876"))
+
+	changed, err = gw.setOptOut(client, "+12505551234", "+14155559876", "UNSTOP", false)
+	require.NoError(t, err)
+	assert.False(t, changed)
+}
+
+func TestRouter_HandleOptOutKeyword(t *testing.T) {
+	r, gw := newTestRouter(1)
+	client := &Client{ID: 3, Username: "pbx1"}
+	m := &MsgQueueItem{From: "+14155559876", To: "+12505551234", message: "Stop"}
+
+	r.handleOptOutKeyword(m, client, optOutKeyword(m.message))
+	assert.True(t, gw.isOptedOut(m.To, m.From))
+
+	m.message = "HELP"
+	r.handleOptOutKeyword(m, client, optOutKeyword(m.message))
+	assert.True(t, gw.isOptedOut(m.To, m.From), "HELP leaves the opt-out alone")
+
+	m.message = "start"
+	r.handleOptOutKeyword(m, client, optOutKeyword(m.message))
+	assert.False(t, gw.isOptedOut(m.To, m.From))
+}
+
+func TestGateway_ResolveHelpReply(t *testing.T) {
+	_, gw := newTestRouter(1)
+	assert.Empty(t, gw.ResolveHelpReply(nil), "no reply configured")
+
+	gw.HelpReplyDefaultMsg = "Acme support: 800-555-0100"
+	assert.Equal(t, "Acme support: 800-555-0100", gw.ResolveHelpReply(nil))
+	assert.Equal(t, "Acme support: 800-555-0100", gw.ResolveHelpReply(&NumberSettings{}))
+	assert.Equal(t, "Call us", gw.ResolveHelpReply(&NumberSettings{HelpReplyMessage: "Call us"}))
+}
+
+func TestRouter_DecideRouteOptedOut(t *testing.T) {
+	r := newDecisionRouter(t)
+	_, err := r.gateway.setOptOut(nil, "+12505551234", "+14155559876", "STOP", true)
+	require.NoError(t, err)
+
+	d := r.decideRoute("+12505551234", "+14155559876", MsgQueueItemType.SMS, "client")
+	assert.Equal(t, RoutePathCarrier, d.Path)
+	assert.True(t, d.OptedOut)
+	assert.Equal(t, "Recipient opted out", d.Error)
+
+	d = r.decideRoute("+14155559876", "+12505551234", MsgQueueItemType.SMS, "carrier")
+	assert.False(t, d.OptedOut, "the opted-out party can still text in")
+	assert.Equal(t, RoutePathSMPP, d.Path)
+
+	d = r.decideRoute("+12505551234", "+447700900123", MsgQueueItemType.SMS, "client")
+	assert.False(t, d.OptedOut, "other recipients are unaffected")
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -20,6 +21,115 @@ func (e *PrometheusExporter) Start() error {
 	return http.ListenAndServe(e.Listen, nil)
 }
 
+// Message metric results.
+const (
+	MetricResultReceived = "received" // accepted from a carrier webhook
+	MetricResultSuccess  = "success"  // handed off to a client or carrier
+	MetricResultFailure  = "failure"  // delivery attempt failed (retries count separately)
+)
+
+// knownMetricCarriers bounds the carrier label; anything else is "other".
+var knownMetricCarriers = map[string]bool{
+	"twilio":       true,
+	"telnyx":       true,
+	"onevoiceplus": true,
+	"vonage":       true,
+	"aws":          true,
+	"sinch":        true,
+	"smpp":         true,
+}
+
+var (
+	messagesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_messages_total",
+		Help: "Messages processed by direction, type, carrier and result",
+	}, []string{"direction", "type", "carrier", "result"})
+
+	lokiDroppedLogs = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gateway_loki_dropped_logs_total",
+		Help: "Logs not shipped to Loki because the log channel was full",
+	})
+
+	mediaFiles = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_media_files",
+		Help: "Media files stored for carrier fetches, as of the last media GC run",
+	})
+
+	mediaBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_media_stored_bytes",
+		Help: "Size of stored media (base64-encoded), as of the last media GC run",
+	})
+
+	gatewayConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_client_connections",
+		Help: "Open client connections by server (smpp, mm4) and client",
+	}, []string{"server", "client"})
+
+	transcodeDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "gateway_transcode_duration_seconds",
+		Help:    "Time spent transcoding MM4 media per message",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	})
+
+	deliveryLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gateway_delivery_latency_seconds",
+		Help:    "Time from a message entering the gateway to its carrier accepting it, retries included",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 900, 3600},
+	}, []string{"type", "carrier"})
+
+	queueWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gateway_queue_wait_seconds",
+		Help:    "Time from a message's last queueing for the router to its carrier accepting it",
+		Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300},
+	}, []string{"type", "carrier"})
+)
+
+// boundedCarrierLabel maps a carrier type to a fixed label set.
+func boundedCarrierLabel(carrierType string) string {
+	carrierType = strings.ToLower(carrierType)
+	if carrierType == "" {
+		return "none"
+	}
+	if knownMetricCarriers[carrierType] {
+		return carrierType
+	}
+	return "other"
+}
+
+// carrierMetricLabel resolves a configured carrier name to its handler type
+// so operator-chosen names don't create new label values.
+func (gateway *Gateway) carrierMetricLabel(name string) string {
+	if name == "" {
+		return "none"
+	}
+	gateway.mu.RLock()
+	handler, ok := gateway.Carriers[name]
+	gateway.mu.RUnlock()
+	if ok {
+		name = handler.Name()
+	}
+	return boundedCarrierLabel(name)
+}
+
+// recordMessage increments gateway_messages_total. direction is "inbound"
+// (towards a client) or "outbound" (towards a carrier).
+func (gateway *Gateway) recordMessage(direction string, msgType MsgQueueType, carrier string, result string) {
+	messagesTotal.WithLabelValues(direction, string(msgType), gateway.carrierMetricLabel(carrier), result).Inc()
+}
+
+// observeDelivery records the delivery latency and queue wait of m, which
+// carrier has just accepted. Unset timestamps are not observed.
+func (gateway *Gateway) observeDelivery(m *MsgQueueItem, carrier string) {
+	now := time.Now()
+	labels := []string{string(m.Type), gateway.carrierMetricLabel(carrier)}
+	if !m.ReceivedTimestamp.IsZero() {
+		deliveryLatency.WithLabelValues(labels...).Observe(now.Sub(m.ReceivedTimestamp).Seconds())
+	}
+	if !m.QueuedTimestamp.IsZero() {
+		queueWait.WithLabelValues(labels...).Observe(now.Sub(m.QueuedTimestamp).Seconds())
+	}
+}
+
 // MetricExporter for managing and exposing Prometheus metrics.
 type MetricExporter struct {
 	desc    map[string]*prometheus.Desc
@@ -30,12 +140,19 @@ type MetricExporter struct {
 // NewMetricExporter initializes the MetricExporter with descriptions for each required metric.
 func NewMetricExporter(id string, gateway *Gateway) *MetricExporter {
 	metricDesc := map[string]*prometheus.Desc{
-		"connected_clients": prometheus.NewDesc("connected_clients", "Number of connected clients", []string{"protocol"}, nil),
-		"total_clients":     prometheus.NewDesc("total_clients", "Total number of clients", []string{"protocol"}, nil),
-		"message_retries":   prometheus.NewDesc("message_retries", "Count of message retries", []string{"protocol"}, nil),
-		"messages_sent":     prometheus.NewDesc("messages_sent", "Messages sent in the last minute", []string{"protocol", "direction"}, nil),
-		"server_status":     prometheus.NewDesc("server_status", "General OK status of the server", []string{"service"}, nil),
-		"client_stats":      prometheus.NewDesc("client_stats", "Total clients and numbers", []string{"protocol", "stat"}, nil),
+		"connected_clients":  prometheus.NewDesc("connected_clients", "Number of connected clients", []string{"protocol"}, nil),
+		"total_clients":      prometheus.NewDesc("total_clients", "Total number of clients", []string{"protocol"}, nil),
+		"message_retries":    prometheus.NewDesc("message_retries", "Count of message retries", []string{"protocol"}, nil),
+		"messages_sent":      prometheus.NewDesc("messages_sent", "Messages sent in the last minute", []string{"protocol", "direction"}, nil),
+		"server_status":      prometheus.NewDesc("server_status", "General OK status of the server", []string{"service"}, nil),
+		"client_stats":       prometheus.NewDesc("client_stats", "Total clients and numbers", []string{"protocol", "stat"}, nil),
+		"conversations":      prometheus.NewDesc("gateway_conversations", "Conversations in flight or with messages queued", nil, nil),
+		"convo_queued":       prometheus.NewDesc("gateway_conversation_queued_messages", "Messages queued behind an in-flight conversation message", nil, nil),
+		"convo_pending_acks": prometheus.NewDesc("gateway_conversation_pending_acks", "In-flight conversation messages waiting on a carrier ack", nil, nil),
+		"convo_oldest_ack":   prometheus.NewDesc("gateway_conversation_oldest_pending_ack_seconds", "Age of the longest-waiting carrier ack", nil, nil),
+		"bind_lockouts":      prometheus.NewDesc("gateway_smpp_bind_lockouts", "Source IPs and system_ids currently locked out of SMPP bind", []string{"scope"}, nil),
+		"carrier_in_flight":  prometheus.NewDesc("gateway_carrier_sends_in_flight", "Sends in progress to each carrier", []string{"carrier"}, nil),
+		"carrier_queued":     prometheus.NewDesc("gateway_carrier_sends_queued", "Sends waiting for a carrier's concurrency or TPS limit", []string{"carrier"}, nil),
 	}
 
 	return &MetricExporter{
@@ -58,6 +175,41 @@ func (e *MetricExporter) Collect(ch chan<- prometheus.Metric) {
 	e.collectClientStats(ch)
 	e.collectMessageMetrics(ch)
 	e.collectServerStatus(ch)
+	e.collectConversationStats(ch)
+	e.collectBindLockouts(ch)
+	e.collectCarrierLoads(ch)
+}
+
+// collectCarrierLoads reports the sends in flight and queued per carrier.
+func (e *MetricExporter) collectCarrierLoads(ch chan<- prometheus.Metric) {
+	for _, load := range e.gateway.carrierLimiters.loads() {
+		ch <- prometheus.MustNewConstMetric(e.desc["carrier_in_flight"], prometheus.GaugeValue, float64(load.InFlight), load.Carrier)
+		ch <- prometheus.MustNewConstMetric(e.desc["carrier_queued"], prometheus.GaugeValue, float64(load.Queued), load.Carrier)
+	}
+}
+
+// collectBindLockouts reports the SMPP bind lockouts in force.
+func (e *MetricExporter) collectBindLockouts(ch chan<- prometheus.Metric) {
+	if e.gateway.SMPPServer == nil {
+		return
+	}
+	ips, systemIDs := e.gateway.SMPPServer.bindLockout.lockedOut()
+
+	ch <- prometheus.MustNewConstMetric(e.desc["bind_lockouts"], prometheus.GaugeValue, float64(ips), "ip")
+	ch <- prometheus.MustNewConstMetric(e.desc["bind_lockouts"], prometheus.GaugeValue, float64(systemIDs), "system_id")
+}
+
+// collectConversationStats reports the ConvoManager's queue and ack state.
+func (e *MetricExporter) collectConversationStats(ch chan<- prometheus.Metric) {
+	if e.gateway.ConvoManager == nil {
+		return
+	}
+	stats := e.gateway.ConvoManager.Stats()
+
+	ch <- prometheus.MustNewConstMetric(e.desc["conversations"], prometheus.GaugeValue, float64(stats.ActiveConversations))
+	ch <- prometheus.MustNewConstMetric(e.desc["convo_queued"], prometheus.GaugeValue, float64(stats.QueuedMessages))
+	ch <- prometheus.MustNewConstMetric(e.desc["convo_pending_acks"], prometheus.GaugeValue, float64(stats.PendingAcks))
+	ch <- prometheus.MustNewConstMetric(e.desc["convo_oldest_ack"], prometheus.GaugeValue, stats.OldestPendingAckSecs)
 }
 
 // collectConnectedClients collects the count of connected clients for both SMPP and MM4.
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"github.com/kataras/iris/v12"
+	"github.com/sirupsen/logrus"
+)
+
+// reloadSummary counts what a full reload loaded.
+type reloadSummary struct {
+	Carriers      int `json:"carriers"`
+	CarrierRoutes int `json:"carrier_routes"`
+	Clients       int `json:"clients"`
+	Numbers       int `json:"numbers"`
+	Blocklist     int `json:"blocklist"`
+}
+
+// reload reloads carriers, destination routes, clients, numbers and the
+// blocklist from the database and swaps them in at once. Everything is read
+// first, so a failed read leaves the running configuration untouched; the
+// swap then happens under the gateway lock, with the router's carrier routes
+// replaced before it is released, so no message is routed against a mix of
+// old and new tables. Replaced carrier handlers are closed after the swap;
+// SMPP carriers whose row is unchanged keep their handler and bind.
+func (gateway *Gateway) reload() (reloadSummary, error) {
+	current := gateway.currentCarriers()
+	carriers, carrierUUIDs, err := gateway.readCarriers()
+	if err != nil {
+		return reloadSummary{}, err
+	}
+	carrierRoutes, err := gateway.readCarrierRoutes()
+	if err != nil {
+		closeCarriers(carriers, current)
+		return reloadSummary{}, err
+	}
+	clients, err := gateway.readClients()
+	if err != nil {
+		closeCarriers(carriers, current)
+		return reloadSummary{}, err
+	}
+	numbers, err := gateway.readNumbers()
+	if err != nil {
+		closeCarriers(carriers, current)
+		return reloadSummary{}, err
+	}
+	blocked, err := gateway.readBlocklist()
+	if err != nil {
+		closeCarriers(carriers, current)
+		return reloadSummary{}, err
+	}
+
+	gateway.mu.Lock()
+	old := gateway.Carriers
+	gateway.Carriers = carriers
+	gateway.CarrierUUIDs = carrierUUIDs
+	gateway.CarrierRoutes = carrierRoutes
+	gateway.Clients = clients
+	gateway.Numbers = numbers
+	gateway.blocklist.set.Store(blocked)
+	if gateway.Router != nil {
+		gateway.Router.setCarrierRoutes(carriers)
+	}
+	gateway.mu.Unlock()
+	closeCarriers(old, carriers)
+	gateway.syncLogPrivacy()
+
+	summary := reloadSummary{
+		Carriers:      len(carriers),
+		CarrierRoutes: len(carrierRoutes),
+		Clients:       len(clients),
+		Numbers:       len(numbers),
+		Blocklist:     blocked.size(),
+	}
+	lm := gateway.LogManager
+	lm.SendLog(lm.BuildLog(
+		"Gateway.Reload",
+		"Reloaded configuration from the database",
+		logrus.InfoLevel,
+		map[string]interface{}{
+			"carriers":       summary.Carriers,
+			"carrier_routes": summary.CarrierRoutes,
+			"clients":        summary.Clients,
+			"numbers":        summary.Numbers,
+			"blocklist":      summary.Blocklist,
+		},
+	))
+	return summary, nil
+}
+
+// webReload handles POST /reload.
+func (gateway *Gateway) webReload(ctx iris.Context) {
+	summary, err := gateway.reload()
+	if err != nil {
+		apiError(ctx, iris.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	ctx.JSON(summary)
+}
+
+// SetupReloadRoutes registers the full configuration reload endpoint and
+// its older /reload_data alias.
+func SetupReloadRoutes(app *iris.Application, gateway *Gateway) {
+	app.Post("/reload", gateway.basicAuthMiddleware, gateway.webReload)
+	app.Post("/reload_data", gateway.basicAuthMiddleware, gateway.webReloadData)
+}
@@ -4,12 +4,15 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -25,34 +28,76 @@ type Route struct {
 type Router struct {
 	gateway          *Gateway
 	Routes           []*Route
+	routesMu         sync.RWMutex // guards Routes
 	ClientMsgChan    chan MsgQueueItem
 	CarrierMsgChan   chan MsgQueueItem
 	MessageAckStatus chan MsgQueueItem
+
+	// High-priority lanes, drained before the channels above.
+	ClientPriorityMsgChan  chan MsgQueueItem
+	CarrierPriorityMsgChan chan MsgQueueItem
+
+	inFlight atomic.Int64 // messages currently in processMessage
+
+	receiptQueue chan MsgQueueItem // delivery receipts for client webhooks
+	receiptOnce  sync.Once
 }
 
-// UnifiedRouter listens on both client and carrier channels and processes messages.
+// UnifiedRouter listens on both client and carrier channels and processes
+// messages. The high-priority channels are always checked first.
 func (router *Router) UnifiedRouter() {
 	for {
-		select {
-		case msg := <-router.ClientMsgChan:
-			// "client" origin
-			go router.processMessage(&msg, "client")
-		case msg := <-router.CarrierMsgChan:
-			// "carrier" origin
-			go router.processMessage(&msg, "carrier")
-		}
+		msg, origin := router.nextMessage()
+		router.dispatch(msg, origin)
 	}
 }
 
+// nextMessage blocks until a message is queued and returns it with its
+// origin ("client" or "carrier"). A waiting high-priority message is always
+// taken before a normal one.
+func (router *Router) nextMessage() (MsgQueueItem, string) {
+	select {
+	case msg := <-router.ClientPriorityMsgChan:
+		return msg, "client"
+	case msg := <-router.CarrierPriorityMsgChan:
+		return msg, "carrier"
+	default:
+	}
+
+	select {
+	case msg := <-router.ClientPriorityMsgChan:
+		return msg, "client"
+	case msg := <-router.CarrierPriorityMsgChan:
+		return msg, "carrier"
+	case msg := <-router.ClientMsgChan:
+		return msg, "client"
+	case msg := <-router.CarrierMsgChan:
+		return msg, "carrier"
+	}
+}
+
+// dispatch processes msg from origin ("client" or "carrier") in its own goroutine.
+func (router *Router) dispatch(msg MsgQueueItem, origin string) {
+	router.inFlight.Add(1)
+	go func() {
+		defer router.inFlight.Add(-1)
+		router.processMessage(&msg, origin)
+	}()
+}
+
 // processMessage handles a message from either channel.
 func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 	lm := router.gateway.LogManager
 
+	// Ingress sets the trace ID; anything queued without one gets it here.
+	if m.TraceID == "" {
+		m.TraceID = newTraceID()
+	}
+
 	// Format numbers
-	to, _ := FormatToE164(m.To)
+	to, _ := router.gateway.formatClientNumber(m.To, nil)
 	m.To = to
-	from, _ := FormatToE164(m.From)
-	m.From = from
+	m.From = router.gateway.formatClientSender(m.From, nil)
 
 	// Compute convoID for queue management
 	convoID := computeCorrelationKey(m.From, m.To)
@@ -67,9 +112,10 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 		}()
 	}
 
-	// Lookup clients
-	toClient, toClientErr := router.findClientByNumber(m.To)
-	fromClient, fromClientErr := router.findClientByNumber(m.From)
+	// Resolve clients and delivery path
+	decision := router.decideRoute(m.From, m.To, m.Type, origin)
+	decision = router.applySenderPool(m, decision, origin)
+	toClient, fromClient := decision.ToClient, decision.FromClient
 
 	// Debug: Log routing decision info
 	toClientUsername := ""
@@ -86,33 +132,54 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 		logrus.DebugLevel,
 		map[string]interface{}{
 			"logID":              m.LogID,
+			"traceID":            m.TraceID,
 			"origin":             origin,
 			"msgType":            m.Type,
 			"from":               m.From,
 			"to":                 m.To,
 			"toClientFound":      toClient != nil,
 			"toClientUsername":   toClientUsername,
-			"toClientErr":        fmt.Sprintf("%v", toClientErr),
 			"fromClientFound":    fromClient != nil,
 			"fromClientUsername": fromClientUsername,
-			"fromClientErr":      fmt.Sprintf("%v", fromClientErr),
+			"routePath":          decision.Path,
 		},
 	))
 
-	if origin == "client" && fromClient == nil {
-		lm.SendLog(lm.BuildLog("Router", "Invalid sender number", logrus.ErrorLevel, map[string]interface{}{
-			"logID": m.LogID,
-			"from":  m.From,
+	// Unknown sender from a client, or unknown destination from a carrier.
+	if decision.Path == RoutePathRejected {
+		lm.SendLog(lm.BuildLog("Router", decision.Error, logrus.ErrorLevel, map[string]interface{}{
+			"logID":   m.LogID,
+			"traceID": m.TraceID,
+			"from":    m.From,
 		}))
 		return
 	}
-	// If both client lookups fail, log and return.
-	// If both client lookups fail, log and return.
-	if origin == "carrier" && toClient == nil {
-		lm.SendLog(lm.BuildLog("Router", "Invalid destination number", logrus.ErrorLevel, map[string]interface{}{
-			"logID": m.LogID,
-			"from":  m.From,
+
+	// The destination number discards everything sent to it.
+	if decision.Path == RoutePathDropped {
+		lm.SendLog(lm.BuildLog("Router", "InboundDropped", logrus.InfoLevel, map[string]interface{}{
+			"logID":    m.LogID,
+			"traceID":  m.TraceID,
+			"from":     m.From,
+			"to":       m.To,
+			"toClient": toClient.Username,
+			"msgType":  string(m.Type),
 		}))
+		if m.Type == MsgQueueItemType.MMS {
+			router.gateway.completePendingMMS(m)
+		}
+		return
+	}
+
+	// Source (inbound) or destination (outbound) is on the blocklist.
+	if decision.Path == RoutePathBlocked {
+		router.blockBlocklisted(m, decision, origin)
+		return
+	}
+
+	// Recipient texted STOP to the sending number.
+	if decision.OptedOut {
+		router.blockOptedOut(m, fromClient)
 		return
 	}
 
@@ -126,6 +193,7 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 		if limitResult != nil && !limitResult.Allowed {
 			lm.SendLog(lm.BuildLog("Router", "Message limit exceeded", logrus.WarnLevel, map[string]interface{}{
 				"logID":     m.LogID,
+				"traceID":   m.TraceID,
 				"client":    fromClient.Username,
 				"from":      m.From,
 				"limitType": limitResult.LimitType,
@@ -140,6 +208,14 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 	}
 	// --- END LIMIT CHECK ---
 
+	// STOP/START/HELP from a carrier sender to a client number. The message
+	// is still delivered so the client sees it.
+	if origin == "carrier" && toClient != nil && m.Type == MsgQueueItemType.SMS {
+		if keyword := optOutKeyword(m.message); keyword != "" {
+			router.handleOptOutKeyword(m, toClient, keyword)
+		}
+	}
+
 	// --- AUTO-REPLY HOOK ---
 	// Fires for both carrier→client (origin=="carrier", toClient!=nil) and
 	// client→client (origin=="client", toClient!=nil, fromClient!=nil).
@@ -154,9 +230,10 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 				"SkippedLoopGuard",
 				logrus.DebugLevel,
 				map[string]interface{}{
-					"logID": m.LogID,
-					"from":  m.From,
-					"to":    m.To,
+					"logID":   m.LogID,
+					"traceID": m.TraceID,
+					"from":    m.From,
+					"to":      m.To,
 				},
 			))
 		} else {
@@ -184,6 +261,7 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 						logrus.DebugLevel,
 						map[string]interface{}{
 							"logID":       m.LogID,
+							"traceID":     m.TraceID,
 							"from":        m.From,
 							"to":          m.To,
 							"cooldownSec": cooldown,
@@ -205,9 +283,9 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 	// --- END AUTO-REPLY HOOK ---
 
 	// Retry on the channel where the message came from
-	retryChan := router.ClientMsgChan
+	retryChan := router.clientQueue(m.Priority)
 	if origin == "carrier" {
-		retryChan = router.CarrierMsgChan
+		retryChan = router.carrierQueue(m.Priority)
 	}
 
 	// Process based on message type
@@ -219,23 +297,16 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 		smsBytesLength := len([]byte(m.message))
 
 		// Debug: Log which path we're taking
-		routePath := "CARRIER"
-		if toClient != nil {
-			if toClient.Type == "web" {
-				routePath = "WEB_CLIENT"
-			} else {
-				routePath = "SMPP_CLIENT"
-			}
-		}
 		lm.SendLog(lm.BuildLog(
 			"Router.DEBUG.SMS",
 			"RoutingDecision",
 			logrus.DebugLevel,
 			map[string]interface{}{
 				"logID":     m.LogID,
+				"traceID":   m.TraceID,
 				"from":      m.From,
 				"to":        m.To,
-				"routePath": routePath,
+				"routePath": decision.Path,
 				"encoding":  smsEncoding,
 				"segments":  smsSegments,
 			},
@@ -243,27 +314,16 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 
 		if toClient != nil {
 			// Check if destination is a WEB Client
-			if toClient.Type == "web" {
+			if decision.Path == RoutePathWebClient {
 				// WEB CLIENT DELIVERY LOGIC
-				// Find valid webhook - first check number-specific, then fall back to client default
-				webhookURL := ""
-				for _, n := range toClient.Numbers {
-					if strings.Contains(m.To, n.Number) {
-						webhookURL = n.WebHook
-						break
-					}
-				}
-
-				// Fall back to client default webhook if number doesn't have one
-				if webhookURL == "" && toClient.Settings != nil && toClient.Settings.DefaultWebhook != "" {
-					webhookURL = toClient.Settings.DefaultWebhook
-				}
-
+				// Number-specific webhook, falling back to the client default
+				webhookURL := decision.WebhookURL
 				if webhookURL == "" {
-					lm.SendLog(lm.BuildLog("Router.SMS", "No webhook defined for web client number or default", logrus.ErrorLevel, map[string]interface{}{
+					lm.SendLog(lm.BuildLog("Router.SMS", decision.Error, logrus.ErrorLevel, map[string]interface{}{
 						"toClient": toClient.Username,
 						"to":       m.To,
 						"logID":    m.LogID,
+						"traceID":  m.TraceID,
 					}))
 					return
 				}
@@ -274,7 +334,9 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 						"toClient": toClient.Username,
 						"url":      webhookURL,
 						"logID":    m.LogID,
+						"traceID":  m.TraceID,
 					}, err))
+					router.gateway.recordMessage("inbound", m.Type, m.SourceCarrier, MetricResultFailure)
 					// Retry logic?
 					if m.Retry("failed to dispatch webhook", retryChan) {
 					}
@@ -288,11 +350,13 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 					logrus.InfoLevel,
 					map[string]interface{}{
 						"logID":    m.LogID,
+						"traceID":  m.TraceID,
 						"toClient": toClient.Username,
 						"url":      webhookURL,
 					},
 				))
 
+				router.gateway.recordMessage("inbound", m.Type, m.SourceCarrier, MetricResultSuccess)
 				internal := (fromClient != nil && toClient != nil)
 				fromClientType := "carrier"
 				carrierName := m.SourceCarrier // Source carrier for inbound from carrier
@@ -331,6 +395,15 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 				return
 			}
 
+			if decision.Path == RoutePathClientWebhook {
+				router.routeClientWebhook(m, toClient, fromClient, decision.WebhookURL, retryChan, origin, MsgRecord{
+					Encoding:            smsEncoding,
+					TotalSegments:       smsSegments,
+					OriginalBytesLength: smsBytesLength,
+				})
+				return
+			}
+
 			// ... Legacy SMPP Handling with Failover ...
 			// Try primary client's session first, then failovers if offline or send fails
 			deliveryClient := toClient // tracks which client actually receives the message
@@ -344,6 +417,7 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 				logrus.DebugLevel,
 				map[string]interface{}{
 					"logID":        m.LogID,
+					"traceID":      m.TraceID,
 					"to":           m.To,
 					"toClient":     toClient.Username,
 					"sessionFound": sessionFound,
@@ -356,6 +430,7 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 				lm.SendLog(lm.BuildLog("Router.SMS", "Primary SMPP session offline, trying failovers", logrus.WarnLevel, map[string]interface{}{
 					"toClient": toClient.Username,
 					"logID":    m.LogID,
+					"traceID":  m.TraceID,
 				}, err))
 
 				fallbackClient, fbErr := router.gateway.resolveFailoverSession(toClient)
@@ -363,7 +438,9 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 					lm.SendLog(lm.BuildLog("Router.SMS", "No failover session available", logrus.ErrorLevel, map[string]interface{}{
 						"toClient": toClient.Username,
 						"logID":    m.LogID,
+						"traceID":  m.TraceID,
 					}, fbErr))
+					router.gateway.recordMessage("inbound", m.Type, m.SourceCarrier, MetricResultFailure)
 					if m.Retry("no SMPP session available (primary or failover)", retryChan) {
 					}
 					return
@@ -377,7 +454,9 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 						"toClient":       toClient.Username,
 						"fallbackClient": fallbackClient.Username,
 						"logID":          m.LogID,
+						"traceID":        m.TraceID,
 					}, err))
+					router.gateway.recordMessage("inbound", m.Type, m.SourceCarrier, MetricResultFailure)
 					if m.Retry("failover session lookup failed", retryChan) {
 					}
 					return
@@ -391,6 +470,7 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 				logrus.DebugLevel,
 				map[string]interface{}{
 					"logID":          m.LogID,
+					"traceID":        m.TraceID,
 					"from":           m.From,
 					"to":             m.To,
 					"deliveryClient": deliveryClient.Username,
@@ -398,20 +478,21 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 				},
 			))
 
-			sendErr := router.gateway.SMPPServer.sendSMPP(*m, session)
+			messageID, sendErr := router.gateway.SMPPServer.sendSMPP(*m, session)
 			if sendErr != nil {
 				// Primary send failed — try failover if we haven't already
 				if deliveryClient.ID == toClient.ID {
 					lm.SendLog(lm.BuildLog("Router.SMS", "Primary SMPP send failed, trying failovers", logrus.WarnLevel, map[string]interface{}{
 						"toClient": toClient.Username,
 						"logID":    m.LogID,
+						"traceID":  m.TraceID,
 					}, sendErr))
 
 					fallbackClient, fbErr := router.gateway.resolveFailoverSession(toClient)
 					if fbErr == nil && fallbackClient != nil {
 						fbSession, fbSessErr := router.gateway.SMPPServer.getSessionByUsername(fallbackClient.Username)
 						if fbSessErr == nil && fbSession != nil {
-							sendErr = router.gateway.SMPPServer.sendSMPP(*m, fbSession)
+							messageID, sendErr = router.gateway.SMPPServer.sendSMPP(*m, fbSession)
 							if sendErr == nil {
 								deliveryClient = fallbackClient
 								session = fbSession
@@ -425,8 +506,12 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 					lm.SendLog(lm.BuildLog("Router.SMS", "Failed to send via SMPP (all attempts)", logrus.ErrorLevel, map[string]interface{}{
 						"toClient": toClient.Username,
 						"logID":    m.LogID,
-						"msg":      m,
+						"traceID":  m.TraceID,
+						"from":     m.From,
+						"to":       m.To,
+						"msg":      m.LogSummary(),
 					}, sendErr))
+					router.gateway.recordMessage("inbound", m.Type, m.SourceCarrier, MetricResultFailure)
 					if m.Retry("failed to send SMPP", retryChan) {
 					}
 					return
@@ -440,15 +525,18 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 				logrus.InfoLevel,
 				map[string]interface{}{
 					"logID":          m.LogID,
+					"traceID":        m.TraceID,
 					"from":           m.From,
 					"to":             m.To,
 					"deliveryClient": deliveryClient.Username,
 					"isFailover":     deliveryClient.ID != toClient.ID,
 					"internal":       fromClient != nil && toClient != nil,
+					"messageID":      messageID,
 				},
 			))
 
 			// Record the successful send
+			router.gateway.recordMessage("inbound", m.Type, m.SourceCarrier, MetricResultSuccess)
 			internal := (fromClient != nil && toClient != nil)
 			fromClientType := "carrier"
 			carrierName := m.SourceCarrier // Source carrier for inbound from carrier
@@ -487,15 +575,39 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 				}
 			}
 		} else {
-			carrier, _ := router.gateway.getClientCarrier(m.From)
+			carrier := decision.Carrier
 			if carrier != "" {
 				// add to outbound carrier queue
 				route := router.gateway.Router.findRouteByName("carrier", carrier)
+				if route != nil && m.binary != nil && !carrierSupportsBinarySMS(route.Handler) {
+					lm.SendLog(lm.BuildLog(
+						"Router.SMS",
+						"BinaryNotSupported",
+						logrus.ErrorLevel,
+						map[string]interface{}{
+							"client":  safeClientUsername(fromClient),
+							"carrier": carrier,
+							"logID":   m.LogID,
+							"traceID": m.TraceID,
+						},
+					))
+					router.gateway.recordMessage("outbound", m.Type, carrier, MetricResultFailure)
+					router.gateway.SMPPServer.sendDeliveryReceipt(m, DLRStatUndeliverable, DLRErrCarrierFailed)
+					return
+				}
 				if route != nil {
-					ackID, err := route.Handler.SendSMS(m)
+					ackID, sentVia, err := router.sendWithFallback(carrier, decision.Route, m, func(handler CarrierHandler) (string, error) {
+						return sendCarrierSMS(handler, m)
+					})
+					carrier = sentVia
+					if err != nil && errors.Is(err, errMessageExpired) {
+						router.expireCarrierMessage(m, carrier, err)
+						return
+					}
 					if err != nil {
 
 						if ackID == "STOP_MESSAGE" {
+							router.gateway.recordMessage("outbound", m.Type, carrier, MetricResultFailure)
 							msg := &MsgQueueItem{
 								To:              m.From,
 								From:            m.To,
@@ -503,6 +615,7 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 								message:         "Blocked due to STOP message. Please try again later or contact our support if the issue persists. ID: " + m.LogID,
 								SkipNumberCheck: false,
 								LogID:           m.LogID,
+								TraceID:         m.TraceID,
 								Delivery: &MsgQueueDelivery{
 									Error:      "discard after first attempt",
 									RetryTime:  time.Now(),
@@ -511,18 +624,28 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 							}
 
 							router.CarrierMsgChan <- *msg
+							router.gateway.SMPPServer.sendDeliveryReceipt(m, DLRStatUndeliverable, DLRErrStopBlocked)
 							return
 						} else {
+							// An open breaker means the send was never attempted;
+							// the retry re-resolves and may pick another carrier.
+							event, level := "RouterSendCarrier", logrus.ErrorLevel
+							if errors.Is(err, errCarrierUnavailable) {
+								event, level = "CarrierUnavailable", logrus.WarnLevel
+							}
 							lm.SendLog(lm.BuildLog(
 								"ROUTER.SMS",
-								"RouterSendCarrier",
-								logrus.ErrorLevel,
+								event,
+								level,
 								map[string]interface{}{
-									"client": safeClientUsername(fromClient),
-									"logID":  m.LogID,
+									"client":  safeClientUsername(fromClient),
+									"logID":   m.LogID,
+									"traceID": m.TraceID,
 								}, err,
 							))
+							router.gateway.recordMessage("outbound", m.Type, carrier, MetricResultFailure)
 							if m.Retry("failed to send SMPP to carrier", retryChan) {
+								router.gateway.SMPPServer.sendDeliveryReceipt(m, DLRStatUndeliverable, DLRErrCarrierFailed)
 								// todo send error message back to sender if it is a found client as the sender
 								msg := &MsgQueueItem{
 									To:              m.From,
@@ -531,6 +654,7 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 									message:         "An error occurred. Please try again later or contact our support if the issue persists. ID: " + m.LogID,
 									SkipNumberCheck: false,
 									LogID:           m.LogID,
+									TraceID:         m.TraceID,
 									Delivery: &MsgQueueDelivery{
 										Error:      "discard after first attempt",
 										RetryTime:  time.Now(),
@@ -550,12 +674,23 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 						logrus.InfoLevel,
 						map[string]interface{}{
 							"logID":     m.LogID,
+							"traceID":   m.TraceID,
 							"carrierID": ackID,
+							"carrier":   carrier,
 							"from":      m.From,
 							"to":        m.To,
 						}, nil,
 					))
 
+					router.gateway.recordMessage("outbound", m.Type, carrier, MetricResultSuccess)
+					router.gateway.observeDelivery(m, carrier)
+					if router.gateway.Config.SMPPDLRFromCarrier && ackID != "" {
+						// Wait for the carrier's delivery report (handleCarrierStatus).
+						router.gateway.carrierReceipts.hold(ackID, m)
+					} else {
+						router.gateway.SMPPServer.sendDeliveryReceipt(m, DLRStatDelivered, DLRErrNone)
+					}
+
 					// Compute the conversation hash.
 					convoID = computeCorrelationKey(m.From, m.To)
 					// Update the conversation queue with the expected ack.
@@ -574,6 +709,7 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 							Direction:           "outbound",
 							FromClientType:      fromClient.Type,
 							ToClientType:        "carrier",
+							CarrierMsgID:        ackID,
 							DeliveryMethod:      "carrier_api",
 							Encoding:            smsEncoding,
 							TotalSegments:       smsSegments,
@@ -591,27 +727,16 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 	case MsgQueueItemType.MMS:
 		if toClient != nil {
 			// Check if destination is a WEB Client - use webhook delivery
-			if toClient.Type == "web" {
+			if decision.Path == RoutePathWebClient {
 				// WEB CLIENT MMS DELIVERY LOGIC
-				// First check number-specific webhook, then fall back to client default
-				webhookURL := ""
-				for _, n := range toClient.Numbers {
-					if strings.Contains(m.To, n.Number) {
-						webhookURL = n.WebHook
-						break
-					}
-				}
-
-				// Fall back to client default webhook if number doesn't have one
-				if webhookURL == "" && toClient.Settings != nil && toClient.Settings.DefaultWebhook != "" {
-					webhookURL = toClient.Settings.DefaultWebhook
-				}
-
+				// Number-specific webhook, falling back to the client default
+				webhookURL := decision.WebhookURL
 				if webhookURL == "" {
-					lm.SendLog(lm.BuildLog("Router.MMS", "No webhook defined for web client number or default", logrus.ErrorLevel, map[string]interface{}{
+					lm.SendLog(lm.BuildLog("Router.MMS", decision.Error, logrus.ErrorLevel, map[string]interface{}{
 						"toClient": toClient.Username,
 						"to":       m.To,
 						"logID":    m.LogID,
+						"traceID":  m.TraceID,
 					}))
 					return
 				}
@@ -622,8 +747,11 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 						"toClient": toClient.Username,
 						"url":      webhookURL,
 						"logID":    m.LogID,
+						"traceID":  m.TraceID,
 					}, err))
+					router.gateway.recordMessage("inbound", m.Type, m.SourceCarrier, MetricResultFailure)
 					if m.Retry("failed to dispatch MMS webhook", retryChan) {
+						router.persistUndeliveredMMS(m, origin, "failed to dispatch MMS webhook")
 					}
 					return
 				}
@@ -635,12 +763,15 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 					logrus.InfoLevel,
 					map[string]interface{}{
 						"logID":      m.LogID,
+						"traceID":    m.TraceID,
 						"toClient":   toClient.Username,
 						"url":        webhookURL,
 						"mediaCount": len(m.files),
 					},
 				))
 
+				router.gateway.recordMessage("inbound", m.Type, m.SourceCarrier, MetricResultSuccess)
+				router.gateway.completePendingMMS(m)
 				internal := (fromClient != nil && toClient != nil)
 				fromClientType := "carrier"
 				carrierName := m.SourceCarrier // Source carrier for inbound from carrier
@@ -677,17 +808,38 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 				return
 			}
 
-			// Legacy MM4 Client delivery
-			if err := router.gateway.MM4Server.sendMM4(*m); err != nil {
+			if decision.Path == RoutePathClientWebhook {
+				router.routeClientWebhook(m, toClient, fromClient, decision.WebhookURL, retryChan, origin, MsgRecord{
+					MediaCount:        len(m.files),
+					OriginalSizeBytes: m.OriginalSizeBytes,
+				})
+				return
+			}
+
+			// Legacy MM4 Client delivery, or an SMS with media links for
+			// clients that take no MMS
+			deliveryMethod := "mm4"
+			sendMMS := router.gateway.MM4Server.sendMM4
+			if mmsDeliveryMode(toClient) == MMSDeliveryLink {
+				deliveryMethod = "smpp_link"
+				sendMMS = func(item MsgQueueItem) error { return router.gateway.sendMMSLink(item, toClient) }
+			}
+			if err := sendMMS(*m); err != nil {
 				lm.SendLog(lm.BuildLog("Router", "Failed to send MM4: %s", logrus.ErrorLevel, map[string]interface{}{
-					"toClient": toClient.Username,
-					"logID":    m.LogID,
+					"toClient":       toClient.Username,
+					"deliveryMethod": deliveryMethod,
+					"logID":          m.LogID,
+					"traceID":        m.TraceID,
 				}, err))
+				router.gateway.recordMessage("inbound", m.Type, m.SourceCarrier, MetricResultFailure)
 				if m.Retry("failed to send MM4", retryChan) {
 					// todo send error message back to sender if it is a found client as the sender
+					router.persistUndeliveredMMS(m, origin, "failed to send MM4")
 				}
 				return
 			}
+			router.gateway.recordMessage("inbound", m.Type, m.SourceCarrier, MetricResultSuccess)
+			router.gateway.completePendingMMS(m)
 			internal := (fromClient != nil && toClient != nil)
 			fromClientType := "carrier"
 			carrierName := m.SourceCarrier // Source carrier for inbound from carrier
@@ -702,7 +854,7 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 					Direction:         "outbound",
 					FromClientType:    fromClientType,
 					ToClientType:      "legacy",
-					DeliveryMethod:    "mm4",
+					DeliveryMethod:    deliveryMethod,
 					MediaCount:        len(m.files),
 					OriginalSizeBytes: m.OriginalSizeBytes,
 					SourceIP:          m.SourceIP,
@@ -717,7 +869,7 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 					Direction:         "inbound",
 					FromClientType:    fromClientType,
 					ToClientType:      "legacy",
-					DeliveryMethod:    "mm4",
+					DeliveryMethod:    deliveryMethod,
 					MediaCount:        len(m.files),
 					OriginalSizeBytes: m.OriginalSizeBytes,
 					SourceIP:          m.SourceIP,
@@ -725,15 +877,27 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 			}
 		} else {
 			// For MMS, if no client is found, try routing via carrier
-			carrier, _ := router.gateway.getClientCarrier(m.From)
+			carrier := decision.Carrier
 			if carrier != "" {
 				// add to outbound carrier queue
 				route := router.gateway.Router.findRouteByName("carrier", carrier)
 				if route != nil {
-					ackID, err := route.Handler.SendMMS(m)
+					ackID, sentVia, err := router.sendWithFallback(carrier, decision.Route, m, func(handler CarrierHandler) (string, error) {
+						return router.gateway.sendMMS(handler, m)
+					})
+					carrier = sentVia
+					if err != nil && errors.Is(err, errMessageExpired) {
+						router.expireCarrierMessage(m, carrier, err)
+						return
+					}
+					if err != nil && errors.Is(err, errMMSOverLimit) {
+						router.rejectOverLimitMMS(m, carrier, err)
+						return
+					}
 					if err != nil {
 
 						if ackID == "STOP_MESSAGE" {
+							router.gateway.recordMessage("outbound", m.Type, carrier, MetricResultFailure)
 							msg := &MsgQueueItem{
 								To:              m.From,
 								From:            m.To,
@@ -741,6 +905,7 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 								message:         "Blocked due to STOP message. Please try again later or contact our support if the issue persists. ID: " + m.LogID,
 								SkipNumberCheck: false,
 								LogID:           m.LogID,
+								TraceID:         m.TraceID,
 								Delivery: &MsgQueueDelivery{
 									Error:      "discard after first attempt",
 									RetryTime:  time.Now(),
@@ -751,17 +916,26 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 							router.CarrierMsgChan <- *msg
 							return
 						} else {
+							// An open breaker means the send was never attempted;
+							// the retry re-resolves and may pick another carrier.
+							event, level := "RouterSendCarrier", logrus.ErrorLevel
+							if errors.Is(err, errCarrierUnavailable) {
+								event, level = "CarrierUnavailable", logrus.WarnLevel
+							}
 							lm.SendLog(lm.BuildLog(
 								"ROUTER.MMS",
-								"RouterSendCarrier",
-								logrus.ErrorLevel,
+								event,
+								level,
 								map[string]interface{}{
-									"client": safeClientUsername(fromClient),
-									"logID":  m.LogID,
+									"client":  safeClientUsername(fromClient),
+									"logID":   m.LogID,
+									"traceID": m.TraceID,
 								}, err,
 							))
 
+							router.gateway.recordMessage("outbound", m.Type, carrier, MetricResultFailure)
 							if m.Retry("failed to send MMS to carrier", retryChan) {
+								router.persistUndeliveredMMS(m, origin, "failed to send MMS to carrier")
 								msg := &MsgQueueItem{
 									To:              m.From,
 									From:            m.To,
@@ -769,6 +943,7 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 									message:         "An error occurred. Please try again later or contact our support if the issue persists. ID: " + m.LogID,
 									SkipNumberCheck: false,
 									LogID:           m.LogID,
+									TraceID:         m.TraceID,
 									Delivery: &MsgQueueDelivery{
 										Error:      "discard after first attempt",
 										RetryTime:  time.Now(),
@@ -789,12 +964,18 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 						logrus.InfoLevel,
 						map[string]interface{}{
 							"logID":     m.LogID,
+							"traceID":   m.TraceID,
 							"carrierID": ackID,
+							"carrier":   carrier,
 							"from":      m.From,
 							"to":        m.To,
 						}, nil,
 					))
 
+					router.gateway.recordMessage("outbound", m.Type, carrier, MetricResultSuccess)
+					router.gateway.observeDelivery(m, carrier)
+					router.gateway.completePendingMMS(m)
+
 					if fromClient != nil {
 						router.gateway.MsgRecordChan <- MsgRecord{
 							MsgQueueItem:      *m,
@@ -804,6 +985,7 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 							Direction:         "outbound",
 							FromClientType:    fromClient.Type,
 							ToClientType:      "carrier",
+							CarrierMsgID:      ackID,
 							DeliveryMethod:    "carrier_api",
 							MediaCount:        len(m.files),
 							OriginalSizeBytes: m.OriginalSizeBytes,
@@ -819,8 +1001,9 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 					"RouterFindCarrier",
 					logrus.ErrorLevel,
 					map[string]interface{}{
-						"client": safeClientUsername(fromClient),
-						"logID":  m.LogID,
+						"client":  safeClientUsername(fromClient),
+						"logID":   m.LogID,
+						"traceID": m.TraceID,
 					},
 				))
 			}
@@ -831,8 +1014,9 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 				"RouterSendFailed",
 				logrus.ErrorLevel,
 				map[string]interface{}{
-					"client": safeClientUsername(fromClient),
-					"logID":  m.LogID,
+					"client":  safeClientUsername(fromClient),
+					"logID":   m.LogID,
+					"traceID": m.TraceID,
 				},
 			))
 			return
@@ -841,10 +1025,47 @@ func (router *Router) processMessage(m *MsgQueueItem, origin string) {
 }
 
 func (router *Router) AddRoute(routeType, endpoint string, handler CarrierHandler) {
+	router.routesMu.Lock()
+	defer router.routesMu.Unlock()
 	router.Routes = append(router.Routes, &Route{Type: routeType, Endpoint: endpoint, Handler: handler})
 }
 
+// syncCarrierRoutes rebuilds the carrier routes from gateway.Carriers, one
+// per carrier keyed by carrier name, and swaps them in at once. Routes of
+// other types are kept.
+func (router *Router) syncCarrierRoutes() {
+	router.gateway.mu.RLock()
+	carriers := router.gateway.Carriers
+	router.gateway.mu.RUnlock()
+	router.setCarrierRoutes(carriers)
+}
+
+// setCarrierRoutes replaces the carrier routes with one per carrier in
+// carriers. It takes only the routes lock, so the caller may hold the
+// gateway lock.
+func (router *Router) setCarrierRoutes(carriers map[string]CarrierHandler) {
+	carrierRoutes := make([]*Route, 0, len(carriers))
+	for name, handler := range carriers {
+		carrierRoutes = append(carrierRoutes, &Route{Type: "carrier", Endpoint: name, Handler: handler})
+	}
+	sort.Slice(carrierRoutes, func(i, j int) bool {
+		return carrierRoutes[i].Endpoint < carrierRoutes[j].Endpoint
+	})
+
+	router.routesMu.Lock()
+	defer router.routesMu.Unlock()
+	routes := make([]*Route, 0, len(router.Routes)+len(carrierRoutes))
+	for _, route := range router.Routes {
+		if route.Type != "carrier" {
+			routes = append(routes, route)
+		}
+	}
+	router.Routes = append(routes, carrierRoutes...)
+}
+
 func (router *Router) findRouteByName(routeType, routeName string) *Route {
+	router.routesMu.RLock()
+	defer router.routesMu.RUnlock()
 	for _, route := range router.Routes {
 		if route.Type == routeType && route.Endpoint == routeName {
 			return route
@@ -854,16 +1075,27 @@ func (router *Router) findRouteByName(routeType, routeName string) *Route {
 }
 
 // findClientByNumber searches for a client using an E.164 number.
-// The client's number list does not have the `+` prefix.
+// The client's number list does not have the `+` prefix. Alphanumeric
+// sender IDs only match exactly.
 func (router *Router) findClientByNumber(number string) (*Client, error) {
 	// Normalize the input number by removing the leading `+`, if present
 	searchNumber := strings.TrimPrefix(number, "+")
+	alpha := isAlphanumericSender(number)
 
 	router.gateway.mu.RLock()
 	defer router.gateway.mu.RUnlock()
 
 	for _, client := range router.gateway.Clients {
 		for _, num := range client.Numbers {
+			if num.Number == "" {
+				continue
+			}
+			if alpha || isAlphanumericSender(num.Number) {
+				if number == num.Number {
+					return client, nil
+				}
+				continue
+			}
 			// Compare the normalized input number with the stored number
 			if strings.Contains(searchNumber, num.Number) {
 				return client, nil
@@ -932,6 +1164,7 @@ func (router *Router) DispatchWebhook(webhookURL string, item *MsgQueueItem, toC
 		logrus.InfoLevel,
 		map[string]interface{}{
 			"logID":      item.LogID,
+			"traceID":    item.TraceID,
 			"webhookURL": webhookURL,
 			"apiFormat":  apiFormat,
 			"msgType":    string(item.Type),
@@ -970,6 +1203,7 @@ func (router *Router) DispatchWebhook(webhookURL string, item *MsgQueueItem, toC
 					logrus.ErrorLevel,
 					map[string]interface{}{
 						"logID":      item.LogID,
+						"traceID":    item.TraceID,
 						"error":      err.Error(),
 						"mediaCount": len(item.files),
 					},
@@ -1062,6 +1296,7 @@ func (router *Router) DispatchWebhook(webhookURL string, item *MsgQueueItem, toC
 			logrus.ErrorLevel,
 			map[string]interface{}{
 				"logID":      item.LogID,
+				"traceID":    item.TraceID,
 				"webhookURL": webhookURL,
 				"error":      err.Error(),
 			},
@@ -1095,6 +1330,7 @@ func (router *Router) DispatchWebhook(webhookURL string, item *MsgQueueItem, toC
 			logrus.InfoLevel,
 			map[string]interface{}{
 				"logID":       item.LogID,
+				"traceID":     item.TraceID,
 				"webhookURL":  webhookURL,
 				"from":        item.From,
 				"to":          item.To,
@@ -1115,6 +1351,7 @@ func (router *Router) DispatchWebhook(webhookURL string, item *MsgQueueItem, toC
 		logrus.DebugLevel,
 		map[string]interface{}{
 			"logID":       item.LogID,
+			"traceID":     item.TraceID,
 			"webhookURL":  webhookURL,
 			"authType":    authType,
 			"payloadSize": len(jsonBytes),
@@ -1130,6 +1367,7 @@ func (router *Router) DispatchWebhook(webhookURL string, item *MsgQueueItem, toC
 			logrus.ErrorLevel,
 			map[string]interface{}{
 				"logID":      item.LogID,
+				"traceID":    item.TraceID,
 				"webhookURL": webhookURL,
 				"apiFormat":  apiFormat,
 				"error":      err.Error(),
@@ -1149,6 +1387,7 @@ func (router *Router) DispatchWebhook(webhookURL string, item *MsgQueueItem, toC
 			logrus.ErrorLevel,
 			map[string]interface{}{
 				"logID":        item.LogID,
+				"traceID":      item.TraceID,
 				"webhookURL":   webhookURL,
 				"apiFormat":    apiFormat,
 				"statusCode":   resp.StatusCode,
@@ -1165,6 +1404,7 @@ func (router *Router) DispatchWebhook(webhookURL string, item *MsgQueueItem, toC
 		logrus.InfoLevel,
 		map[string]interface{}{
 			"logID":        item.LogID,
+			"traceID":      item.TraceID,
 			"webhookURL":   webhookURL,
 			"apiFormat":    apiFormat,
 			"statusCode":   resp.StatusCode,
@@ -1235,6 +1475,7 @@ func logAutoReplyAttempt(lm *LogManager, m *MsgQueueItem, toClient *Client, repl
 		logrus.InfoLevel,
 		map[string]interface{}{
 			"logID":             m.LogID,
+			"traceID":           m.TraceID,
 			"reason":            reason,
 			"origin":            "suppressed-by-auto-reply",
 			"msgType":           string(m.Type),
@@ -1252,6 +1493,7 @@ func logAutoReplyAttempt(lm *LogManager, m *MsgQueueItem, toClient *Client, repl
 		logrus.InfoLevel,
 		map[string]interface{}{
 			"logID":             m.LogID,
+			"traceID":           m.TraceID,
 			"destinationClient": toClientUsername(toClient),
 			"inboundFrom":       m.From,
 			"inboundTo":         m.To,
@@ -1267,7 +1509,7 @@ func logAutoReplyAttempt(lm *LogManager, m *MsgQueueItem, toClient *Client, repl
 func (router *Router) sendAutoReply(text string, original *MsgQueueItem) bool {
 	lm := router.gateway.LogManager
 
-	carrier, _ := router.gateway.getClientCarrier(original.To)
+	carrier := router.selectCarrier(original.To, original.From)
 	if carrier == "" {
 		lm.SendLog(lm.BuildLog(
 			"Router.AutoReply",
@@ -1275,6 +1517,7 @@ func (router *Router) sendAutoReply(text string, original *MsgQueueItem) bool {
 			logrus.WarnLevel,
 			map[string]interface{}{
 				"logID":             original.LogID,
+				"traceID":           original.TraceID,
 				"destinationNumber": original.To,
 			},
 		))
@@ -1289,20 +1532,24 @@ func (router *Router) sendAutoReply(text string, original *MsgQueueItem) bool {
 			logrus.ErrorLevel,
 			map[string]interface{}{
 				"logID":   original.LogID,
+				"traceID": original.TraceID,
 				"carrier": carrier,
 			},
 		))
 		return false
 	}
 
+	received := time.Now()
 	reply := &MsgQueueItem{
 		To:                original.From, // back to the texter
 		From:              original.To,   // from the destination number
-		ReceivedTimestamp: time.Now(),
+		ReceivedTimestamp: received,
+		QueuedTimestamp:   received,
 		Type:              MsgQueueItemType.SMS,
 		message:           text,
 		SkipNumberCheck:   true,
 		LogID:             primitive.NewObjectID().Hex(),
+		TraceID:           original.TraceID,
 		SourceCarrier:     carrier,
 		Delivery: &MsgQueueDelivery{
 			Error:      "auto-reply — no retry",
@@ -1317,6 +1564,7 @@ func (router *Router) sendAutoReply(text string, original *MsgQueueItem) bool {
 		logrus.InfoLevel,
 		map[string]interface{}{
 			"logID":             original.LogID,
+			"traceID":           original.TraceID,
 			"replyLogID":        reply.LogID,
 			"replyFrom":         reply.From,
 			"replyTo":           reply.To,
@@ -1326,7 +1574,9 @@ func (router *Router) sendAutoReply(text string, original *MsgQueueItem) bool {
 		},
 	))
 
-	ackID, err := route.Handler.SendSMS(reply)
+	ackID, err := router.gateway.carrierSend(carrier, reply, func() (string, error) {
+		return route.Handler.SendSMS(reply)
+	})
 	if err != nil {
 		lm.SendLog(lm.BuildLog(
 			"Router.AutoReply",
@@ -1334,6 +1584,7 @@ func (router *Router) sendAutoReply(text string, original *MsgQueueItem) bool {
 			logrus.ErrorLevel,
 			map[string]interface{}{
 				"logID":      original.LogID,
+				"traceID":    original.TraceID,
 				"replyLogID": reply.LogID,
 				"carrier":    carrier,
 			},
@@ -1348,6 +1599,7 @@ func (router *Router) sendAutoReply(text string, original *MsgQueueItem) bool {
 		logrus.InfoLevel,
 		map[string]interface{}{
 			"logID":      original.LogID,
+			"traceID":    original.TraceID,
 			"replyLogID": reply.LogID,
 			"carrierID":  ackID,
 			"carrier":    carrier,
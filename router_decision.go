@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kataras/iris/v12"
+)
+
+// Delivery paths chosen by decideRoute.
+const (
+	RoutePathWebClient     = "web_client"     // webhook to a web client
+	RoutePathClientWebhook = "client_webhook" // signed webhook to a legacy client
+	RoutePathSMPP          = "smpp"           // deliver_sm to a legacy client
+	RoutePathMM4           = "mm4"            // MM4 forward to a legacy client
+	RoutePathCarrier       = "carrier"        // out through a carrier API
+	RoutePathRejected      = "rejected"       // dropped before delivery
+	RoutePathDropped       = "dropped"        // discarded by the number's inbound_route
+	RoutePathBlocked       = "blocked"        // source or destination is on the blocklist
+)
+
+// routeDecision is where processMessage sends a message. decideRoute only
+// reads routing state, so the same decision can be reported by /route/test
+// without sending anything.
+type routeDecision struct {
+	Path       string         `json:"path"`
+	FromClient *Client        `json:"-"`
+	ToClient   *Client        `json:"-"`
+	WebhookURL string         `json:"webhook_url,omitempty"` // RoutePathWebClient, RoutePathClientWebhook
+	Carrier    string         `json:"carrier,omitempty"`     // RoutePathCarrier
+	Route      *CarrierRoute  `json:"carrier_route,omitempty"`
+	OptedOut   bool           `json:"opted_out,omitempty"` // recipient texted STOP to the sender
+	Blocked    *BlockedNumber `json:"blocked,omitempty"`   // RoutePathBlocked
+	Error      string         `json:"error,omitempty"`     // why the message cannot be delivered
+}
+
+// webClientWebhookURL returns the webhook for a web client number, falling
+// back to the client's default webhook.
+func webClientWebhookURL(client *Client, to string) string {
+	for _, n := range client.Numbers {
+		if strings.Contains(to, n.Number) {
+			if n.WebHook != "" {
+				return n.WebHook
+			}
+			break
+		}
+	}
+	if client.Settings != nil {
+		return client.Settings.DefaultWebhook
+	}
+	return ""
+}
+
+// decideRoute resolves the clients and transport for a message between
+// E.164 numbers from and to, arriving from origin ("client" or "carrier").
+func (router *Router) decideRoute(from, to string, msgType MsgQueueType, origin string) routeDecision {
+	var d routeDecision
+	d.ToClient, _ = router.findClientByNumber(to)
+	d.FromClient, _ = router.findClientByNumber(from)
+
+	if origin == "client" && d.FromClient == nil {
+		d.Path = RoutePathRejected
+		d.Error = "Invalid sender number"
+		return d
+	}
+	if origin == "carrier" && d.ToClient == nil {
+		d.Path = RoutePathRejected
+		d.Error = "Invalid destination number"
+		return d
+	}
+	if d.Blocked = router.gateway.blockedNumber(from, to, origin); d.Blocked != nil {
+		d.Path = RoutePathBlocked
+		d.Error = "Number is blocklisted"
+		if d.Blocked.Reason != "" {
+			d.Error += ": " + d.Blocked.Reason
+		}
+		return d
+	}
+
+	if d.ToClient != nil {
+		// The number's own inbound route wins over the client's default.
+		route, numberWebhook := numberInboundRoute(d.ToClient, to)
+		switch {
+		case route == InboundRouteDrop:
+			d.Path = RoutePathDropped
+		case d.ToClient.Type == "web":
+			d.Path = RoutePathWebClient
+			d.WebhookURL = webClientWebhookURL(d.ToClient, to)
+			if d.WebhookURL == "" {
+				d.Error = "No webhook defined for web client number or default"
+			}
+		case route == InboundRouteWebhook:
+			d.Path = RoutePathClientWebhook
+			d.WebhookURL = numberWebhook
+			if d.WebhookURL == "" {
+				d.WebhookURL = d.ToClient.WebhookURL
+			}
+			if d.WebhookURL == "" {
+				d.Path = RoutePathRejected
+				d.Error = "No webhook defined for number or client"
+			}
+		case route == InboundRouteSMPP && msgType == MsgQueueItemType.SMS:
+			d.Path = RoutePathSMPP
+		case route == InboundRouteMM4 && msgType == MsgQueueItemType.MMS:
+			d.Path = RoutePathMM4
+		case d.ToClient.usesWebhookDelivery(msgType):
+			d.Path = RoutePathClientWebhook
+			d.WebhookURL = d.ToClient.WebhookURL
+		case msgType == MsgQueueItemType.MMS:
+			d.Path = RoutePathMM4
+		default:
+			d.Path = RoutePathSMPP
+		}
+		return d
+	}
+
+	d.Path = RoutePathCarrier
+	if err := router.gateway.validateDestination(to); err != nil {
+		d.Path = RoutePathRejected
+		d.Error = "Invalid destination number: " + err.Error()
+		return d
+	}
+	d.Carrier, d.Route = router.resolveCarrier(from, to)
+	d.OptedOut = origin == "client" && router.gateway.isOptedOut(from, to)
+	alpha := isAlphanumericSender(from)
+	carrier := router.findRouteByName("carrier", d.Carrier)
+	switch {
+	case alpha && !isE164(to):
+		d.Path = RoutePathRejected
+		d.Error = "Invalid destination number"
+	case d.OptedOut:
+		d.Error = "Recipient opted out"
+	case d.Carrier == "":
+		d.Error = "No carrier for sender number"
+	case carrier == nil:
+		d.Error = fmt.Sprintf("Carrier %s is not loaded", d.Carrier)
+	case alpha && msgType != MsgQueueItemType.SMS:
+		d.Error = "Alphanumeric sender IDs are SMS only"
+	case alpha && !carrierSupportsAlphaSender(carrier.Handler):
+		d.Error = fmt.Sprintf("Carrier %s does not support alphanumeric sender IDs", d.Carrier)
+	}
+	return d
+}
+
+// routeTestRequest is the body of POST /route/test.
+type routeTestRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Type string `json:"type"` // "sms" (default) or "mms"
+}
+
+// routeTestResult reports what processMessage would do with a message.
+type routeTestResult struct {
+	From         string `json:"from"`
+	To           string `json:"to"`
+	Type         string `json:"type"`
+	Origin       string `json:"origin"` // "client" when the sender is a client number, else "carrier"
+	FromClient   string `json:"from_client,omitempty"`
+	ToClient     string `json:"to_client,omitempty"`
+	ToClientType string `json:"to_client_type,omitempty"`
+	routeDecision
+	SMPPSessionActive *bool `json:"smpp_session_active,omitempty"` // RoutePathSMPP only
+}
+
+// testRoute runs decideRoute for req without enqueueing or sending anything.
+func (router *Router) testRoute(req routeTestRequest) (routeTestResult, error) {
+	msgType := MsgQueueType(strings.ToLower(strings.TrimSpace(req.Type)))
+	if msgType == "" {
+		msgType = MsgQueueItemType.SMS
+	}
+	if msgType != MsgQueueItemType.SMS && msgType != MsgQueueItemType.MMS {
+		return routeTestResult{}, fmt.Errorf("type must be sms or mms")
+	}
+	if req.From == "" || req.To == "" {
+		return routeTestResult{}, fmt.Errorf("from and to are required")
+	}
+
+	from := formatSender(req.From)
+	to, _ := FormatToE164(req.To)
+
+	origin := "carrier"
+	if sender, _ := router.findClientByNumber(from); sender != nil {
+		origin = "client"
+	}
+
+	d := router.decideRoute(from, to, msgType, origin)
+	res := routeTestResult{
+		From:          from,
+		To:            to,
+		Type:          string(msgType),
+		Origin:        origin,
+		routeDecision: d,
+	}
+	if d.FromClient != nil {
+		res.FromClient = d.FromClient.Username
+	}
+	if d.ToClient != nil {
+		res.ToClient = d.ToClient.Username
+		res.ToClientType = d.ToClient.Type
+	}
+	if d.Path == RoutePathSMPP && router.gateway.SMPPServer != nil {
+		active := router.gateway.SMPPServer.isSessionActive(d.ToClient.Username)
+		res.SMPPSessionActive = &active
+	}
+	return res, nil
+}
+
+// SetupRouteTestRoutes sets up the HTTP route for routing dry runs.
+func SetupRouteTestRoutes(app *iris.Application, gateway *Gateway) {
+	route := app.Party("/route", gateway.basicAuthMiddleware)
+	{
+		// POST /route/test - report the routing decision for a message without sending it
+		route.Post("/test", func(ctx iris.Context) {
+			var req routeTestRequest
+			if err := ctx.ReadJSON(&req); err != nil {
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Invalid request body")
+				return
+			}
+
+			res, err := gateway.Router.testRoute(req)
+			if err != nil {
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, err.Error())
+				return
+			}
+			ctx.JSON(res)
+		})
+	}
+}
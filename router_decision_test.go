@@ -0,0 +1,211 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newDecisionRouter(t *testing.T) *Router {
+	t.Helper()
+	r, gw := newTestRouter(1)
+	gw.Clients = map[string]*Client{
+		"pbx1": {Username: "pbx1", Type: "legacy", Numbers: []ClientNumber{{Number: "12505551234", Carrier: "telnyx"}}},
+		"pbx2": {Username: "pbx2", Type: "legacy", WebhookURL: "https://pbx2.example.com/hook", Numbers: []ClientNumber{{Number: "12505550000"}}},
+		"app1": {
+			Username: "app1",
+			Type:     "web",
+			Numbers:  []ClientNumber{{Number: "16045551111"}, {Number: "16045552222", WebHook: "https://app.example.com/n2"}},
+			Settings: &ClientSettings{DefaultWebhook: "https://app.example.com/default"},
+		},
+	}
+	gw.CarrierRoutes = sortedRoutes(CarrierRoute{Prefix: "44", Carrier: "vonage"})
+	r.AddRoute("carrier", "telnyx", nil)
+	r.AddRoute("carrier", "vonage", nil)
+	return r
+}
+
+func TestRouter_DecideRoute(t *testing.T) {
+	r := newDecisionRouter(t)
+
+	d := r.decideRoute("+14155559876", "+12505551234", MsgQueueItemType.SMS, "carrier")
+	assert.Equal(t, RoutePathSMPP, d.Path)
+	assert.Equal(t, "pbx1", d.ToClient.Username)
+
+	d = r.decideRoute("+14155559876", "+12505551234", MsgQueueItemType.MMS, "carrier")
+	assert.Equal(t, RoutePathMM4, d.Path)
+
+	d = r.decideRoute("+14155559876", "+12505550000", MsgQueueItemType.SMS, "carrier")
+	assert.Equal(t, RoutePathClientWebhook, d.Path)
+
+	d = r.decideRoute("+14155559876", "+16045551111", MsgQueueItemType.SMS, "carrier")
+	assert.Equal(t, RoutePathWebClient, d.Path)
+	assert.Equal(t, "https://app.example.com/default", d.WebhookURL)
+	d = r.decideRoute("+14155559876", "+16045552222", MsgQueueItemType.SMS, "carrier")
+	assert.Equal(t, "https://app.example.com/n2", d.WebhookURL)
+
+	d = r.decideRoute("+12505551234", "+447700900123", MsgQueueItemType.SMS, "client")
+	assert.Equal(t, RoutePathCarrier, d.Path)
+	assert.Equal(t, "vonage", d.Carrier)
+	require.NotNil(t, d.Route)
+	assert.Equal(t, "44", d.Route.Prefix)
+
+	d = r.decideRoute("+12505551234", "+14155559876", MsgQueueItemType.SMS, "client")
+	assert.Equal(t, "telnyx", d.Carrier)
+	assert.Nil(t, d.Route, "sender's own carrier, no destination route")
+	assert.Empty(t, d.Error)
+}
+
+func TestRouter_DecideRouteRejects(t *testing.T) {
+	r := newDecisionRouter(t)
+
+	d := r.decideRoute("+14155559876", "+12505551234", MsgQueueItemType.SMS, "client")
+	assert.Equal(t, RoutePathRejected, d.Path)
+	assert.Equal(t, "Invalid sender number", d.Error)
+
+	d = r.decideRoute("+14155559876", "+17785550000", MsgQueueItemType.SMS, "carrier")
+	assert.Equal(t, RoutePathRejected, d.Path)
+	assert.Equal(t, "Invalid destination number", d.Error)
+
+	r.gateway.Clients["app1"].Settings = nil
+	d = r.decideRoute("+14155559876", "+16045551111", MsgQueueItemType.SMS, "carrier")
+	assert.Equal(t, RoutePathWebClient, d.Path)
+	assert.NotEmpty(t, d.Error, "web client without a webhook cannot be delivered")
+}
+
+func TestRouter_TestRoute(t *testing.T) {
+	r := newDecisionRouter(t)
+
+	res, err := r.testRoute(routeTestRequest{From: "12505551234", To: "+447700900123"})
+	require.NoError(t, err)
+	assert.Equal(t, "client", res.Origin)
+	assert.Equal(t, "sms", res.Type)
+	assert.Equal(t, "pbx1", res.FromClient)
+	assert.Equal(t, RoutePathCarrier, res.Path)
+	assert.Equal(t, "vonage", res.Carrier)
+
+	res, err = r.testRoute(routeTestRequest{From: "+14155559876", To: "+12505551234", Type: "MMS"})
+	require.NoError(t, err)
+	assert.Equal(t, "carrier", res.Origin)
+	assert.Equal(t, "pbx1", res.ToClient)
+	assert.Equal(t, RoutePathMM4, res.Path)
+
+	_, err = r.testRoute(routeTestRequest{From: "+14155559876", To: "+12505551234", Type: "fax"})
+	assert.Error(t, err)
+	_, err = r.testRoute(routeTestRequest{To: "+12505551234"})
+	assert.Error(t, err)
+}
+
+func TestRouter_DecideRouteInboundRoute(t *testing.T) {
+	r := newDecisionRouter(t)
+	setRoute := func(client, route, webhook string) {
+		n := &r.gateway.Clients[client].Numbers[0]
+		n.InboundRoute, n.WebHook = route, webhook
+	}
+
+	t.Run("drop", func(t *testing.T) {
+		setRoute("pbx1", InboundRouteDrop, "")
+		for _, msgType := range []MsgQueueType{MsgQueueItemType.SMS, MsgQueueItemType.MMS} {
+			d := r.decideRoute("+14155559876", "+12505551234", msgType, "carrier")
+			assert.Equal(t, RoutePathDropped, d.Path, msgType)
+			assert.Equal(t, "pbx1", d.ToClient.Username)
+		}
+		setRoute("app1", InboundRouteDrop, "")
+		assert.Equal(t, RoutePathDropped, r.decideRoute("+14155559876", "+16045551111", MsgQueueItemType.SMS, "carrier").Path)
+		assert.Equal(t, RoutePathDropped, r.decideRoute("+12505550000", "+16045551111", MsgQueueItemType.SMS, "client").Path, "client-to-client too")
+	})
+
+	t.Run("webhook", func(t *testing.T) {
+		setRoute("pbx1", InboundRouteWebhook, "https://pbx1.example.com/old-number")
+		d := r.decideRoute("+14155559876", "+12505551234", MsgQueueItemType.MMS, "carrier")
+		assert.Equal(t, RoutePathClientWebhook, d.Path)
+		assert.Equal(t, "https://pbx1.example.com/old-number", d.WebhookURL)
+
+		r.gateway.Clients["pbx1"].WebhookURL = "https://pbx1.example.com/hook"
+		setRoute("pbx1", InboundRouteWebhook, "")
+		d = r.decideRoute("+14155559876", "+12505551234", MsgQueueItemType.SMS, "carrier")
+		assert.Equal(t, RoutePathClientWebhook, d.Path)
+		assert.Equal(t, "https://pbx1.example.com/hook", d.WebhookURL, "falls back to the client webhook")
+
+		r.gateway.Clients["pbx1"].WebhookURL = ""
+		d = r.decideRoute("+14155559876", "+12505551234", MsgQueueItemType.SMS, "carrier")
+		assert.Equal(t, RoutePathRejected, d.Path)
+		assert.NotEmpty(t, d.Error)
+	})
+
+	t.Run("smpp", func(t *testing.T) {
+		// pbx2 has a webhook, so it normally gets everything there.
+		setRoute("pbx2", InboundRouteSMPP, "")
+		assert.Equal(t, RoutePathSMPP, r.decideRoute("+14155559876", "+12505550000", MsgQueueItemType.SMS, "carrier").Path)
+		assert.Equal(t, RoutePathClientWebhook, r.decideRoute("+14155559876", "+12505550000", MsgQueueItemType.MMS, "carrier").Path, "MMS keeps the client default")
+	})
+
+	t.Run("mm4", func(t *testing.T) {
+		setRoute("pbx2", InboundRouteMM4, "")
+		assert.Equal(t, RoutePathMM4, r.decideRoute("+14155559876", "+12505550000", MsgQueueItemType.MMS, "carrier").Path)
+		assert.Equal(t, RoutePathClientWebhook, r.decideRoute("+14155559876", "+12505550000", MsgQueueItemType.SMS, "carrier").Path, "SMS keeps the client default")
+	})
+
+	t.Run("default", func(t *testing.T) {
+		setRoute("pbx2", InboundRouteDefault, "")
+		d := r.decideRoute("+14155559876", "+12505550000", MsgQueueItemType.SMS, "carrier")
+		assert.Equal(t, RoutePathClientWebhook, d.Path)
+		assert.Equal(t, "https://pbx2.example.com/hook", d.WebhookURL)
+	})
+}
+
+func TestRouter_ProcessMessageInboundRoute(t *testing.T) {
+	var hits []string
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		hits = append(hits, req.URL.Path)
+	}))
+	defer hook.Close()
+
+	r := newDecisionRouter(t)
+	gw := r.gateway
+	gw.Config.WebhookTimeoutSecs = 5
+	gw.MsgRecordChan = make(chan MsgRecord, 4)
+	pbx1 := &gw.Clients["pbx1"].Numbers[0]
+
+	pbx1.InboundRoute, pbx1.WebHook = InboundRouteWebhook, hook.URL+"/old-number"
+	r.processMessage(&MsgQueueItem{LogID: "in-1", From: "+14155559876", To: "+12505551234", Type: MsgQueueItemType.SMS, message: "hi"}, "carrier")
+	assert.Equal(t, []string{"/old-number"}, hits)
+	assert.Equal(t, "inbound", (<-gw.MsgRecordChan).Direction)
+
+	pbx1.InboundRoute = InboundRouteDrop
+	r.processMessage(&MsgQueueItem{LogID: "in-2", From: "+14155559876", To: "+12505551234", Type: MsgQueueItemType.SMS, message: "hi"}, "carrier")
+	assert.Len(t, hits, 1, "dropped messages are not delivered")
+	assert.Empty(t, gw.MsgRecordChan)
+	assert.Empty(t, r.CarrierMsgChan, "or answered")
+}
+
+func TestValidInboundRouteFor(t *testing.T) {
+	legacy, web := &Client{Type: "legacy"}, &Client{Type: "web"}
+	for _, route := range []string{"", InboundRouteSMPP, InboundRouteMM4, InboundRouteWebhook, InboundRouteDrop} {
+		assert.True(t, validInboundRouteFor(legacy, route), route)
+	}
+	assert.False(t, validInboundRouteFor(legacy, "fax"))
+	assert.True(t, validInboundRouteFor(web, InboundRouteDrop))
+	assert.False(t, validInboundRouteFor(web, InboundRouteSMPP))
+	assert.False(t, validInboundRouteFor(web, InboundRouteMM4))
+}
+
+func TestRouter_FindClientByNumber_AlphaSender(t *testing.T) {
+	r := newDecisionRouter(t)
+	r.gateway.Clients["brand"] = &Client{Username: "brand", Numbers: []ClientNumber{{Number: "MYBRAND"}, {Number: ""}}}
+
+	client, err := r.findClientByNumber("+12505551234")
+	require.NoError(t, err)
+	assert.Equal(t, "pbx1", client.Username, "an alphanumeric or empty number matches nothing else")
+	_, err = r.findClientByNumber("+19995550000")
+	assert.Error(t, err)
+
+	client, err = r.findClientByNumber("MYBRAND")
+	require.NoError(t, err)
+	assert.Equal(t, "brand", client.Username)
+	_, err = r.findClientByNumber("MYBRAND2")
+	assert.Error(t, err)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Values of the sender_pool_policy client setting.
+const (
+	// SenderPoolPassthrough sends from whatever number the client used.
+	SenderPoolPassthrough = "passthrough"
+	// SenderPoolSticky maps each recipient to the same pool number every
+	// time, so a conversation stays on one number.
+	SenderPoolSticky = "sticky"
+	// SenderPoolRoundRobin rotates through the pool on every send.
+	SenderPoolRoundRobin = "roundrobin"
+)
+
+// validSenderPoolPolicy reports whether policy is a sender pool policy, or
+// empty.
+func validSenderPoolPolicy(policy string) bool {
+	switch policy {
+	case "", SenderPoolPassthrough, SenderPoolSticky, SenderPoolRoundRobin:
+		return true
+	}
+	return false
+}
+
+// normalizeSenderPool checks that every number of a comma-separated pool
+// belongs to client and returns the pool in the client's stored format.
+func normalizeSenderPool(client *Client, val string) (string, error) {
+	var pool []string
+	for _, n := range strings.Split(val, ",") {
+		n = strings.TrimSpace(n)
+		if n == "" {
+			continue
+		}
+		i := findClientNumber(client, n)
+		if i < 0 {
+			return "", fmt.Errorf("sender_pool number %s does not belong to the client", n)
+		}
+		pool = append(pool, client.Numbers[i].Number)
+	}
+	return strings.Join(pool, ","), nil
+}
+
+// senderPool returns client's pool as E.164 numbers, skipping any that no
+// longer belong to it.
+func senderPool(client *Client) []string {
+	if client == nil || client.Settings == nil || client.Settings.SenderPool == "" {
+		return nil
+	}
+	var pool []string
+	for _, n := range strings.Split(client.Settings.SenderPool, ",") {
+		n = strings.TrimSpace(n)
+		if findClientNumber(client, n) < 0 {
+			continue
+		}
+		if e164, err := FormatToE164(n); err == nil {
+			pool = append(pool, e164)
+		}
+	}
+	return pool
+}
+
+// senderPoolCursors holds the round-robin position of each client.
+type senderPoolCursors struct {
+	mu   sync.Mutex
+	next map[uint]int
+}
+
+// advance returns the next position for clientID.
+func (c *senderPoolCursors) advance(clientID uint) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.next == nil {
+		c.next = make(map[uint]int)
+	}
+	n := c.next[clientID]
+	c.next[clientID] = n + 1
+	return n
+}
+
+// stickySender picks the pool number for recipient by rendezvous hashing:
+// the number with the highest hash of number and recipient wins, so adding
+// or removing a number only moves the recipients that hashed to it.
+func stickySender(pool []string, recipient string) string {
+	var (
+		best      string
+		bestScore uint64
+	)
+	for _, n := range pool {
+		sum := sha256.Sum256([]byte(n + "|" + recipient))
+		if score := binary.BigEndian.Uint64(sum[:8]); best == "" || score > bestScore {
+			best, bestScore = n, score
+		}
+	}
+	return best
+}
+
+// poolSender returns the number a carrier-bound message from client to to
+// should be sent from. Only messages sent from a pool number are rewritten,
+// so numbers outside the pool keep their own From.
+func (gateway *Gateway) poolSender(client *Client, from, to string) string {
+	if client == nil || client.Settings == nil {
+		return from
+	}
+	policy := client.Settings.SenderPoolPolicy
+	if policy == "" || policy == SenderPoolPassthrough {
+		return from
+	}
+	pool := senderPool(client)
+	inPool := false
+	for _, n := range pool {
+		if n == from {
+			inPool = true
+			break
+		}
+	}
+	if !inPool {
+		return from
+	}
+
+	switch policy {
+	case SenderPoolSticky:
+		return stickySender(pool, to)
+	case SenderPoolRoundRobin:
+		return pool[gateway.senderPoolCursors.advance(client.ID)%len(pool)]
+	}
+	return from
+}
+
+// applySenderPool rewrites the From of a carrier-bound client message per
+// the sender's pool policy, and re-runs the routing decision for the new
+// number so its carrier and opt-outs apply.
+func (router *Router) applySenderPool(m *MsgQueueItem, d routeDecision, origin string) routeDecision {
+	if origin != "client" || d.Path != RoutePathCarrier {
+		return d
+	}
+	from := router.gateway.poolSender(d.FromClient, m.From, m.To)
+	if from == m.From {
+		return d
+	}
+
+	lm := router.gateway.LogManager
+	lm.SendLog(lm.BuildLog(
+		"Router",
+		"SenderPoolRewrite",
+		logrus.DebugLevel,
+		map[string]interface{}{
+			"logID":   m.LogID,
+			"traceID": m.TraceID,
+			"client":  d.FromClient.Username,
+			"policy":  d.FromClient.Settings.SenderPoolPolicy,
+			"fromWas": m.From,
+			"from":    from,
+			"to":      m.To,
+		},
+	))
+	m.From = from
+	return router.decideRoute(m.From, m.To, m.Type, origin)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newPoolClient(policy string) *Client {
+	return &Client{
+		ID:       9,
+		Username: "pool",
+		Numbers: []ClientNumber{
+			{ID: 1, Number: "12505551001", Carrier: "telnyx"},
+			{ID: 2, Number: "12505551002", Carrier: "telnyx"},
+			{ID: 3, Number: "12505551003", Carrier: "telnyx"},
+			{ID: 4, Number: "12505559999", Carrier: "telnyx"}, // not pooled
+		},
+		Settings: &ClientSettings{
+			SenderPoolPolicy: policy,
+			SenderPool:       "12505551001,12505551002,12505551003",
+		},
+	}
+}
+
+func TestPoolSender_StickyIsConsistent(t *testing.T) {
+	gw := &Gateway{}
+	client := newPoolClient(SenderPoolSticky)
+
+	used := map[string]bool{}
+	for i := 0; i < 30; i++ {
+		to := fmt.Sprintf("+1415555%04d", i)
+		first := gw.poolSender(client, "+12505551001", to)
+		for j := 0; j < 5; j++ {
+			assert.Equal(t, first, gw.poolSender(client, "+12505551002", to), "same recipient, same sender")
+		}
+		used[first] = true
+	}
+	assert.Len(t, used, 3, "recipients spread across the pool")
+
+	// Dropping one number only moves the recipients that were on it.
+	smaller := newPoolClient(SenderPoolSticky)
+	smaller.Settings.SenderPool = "12505551001,12505551002"
+	for i := 0; i < 30; i++ {
+		to := fmt.Sprintf("+1415555%04d", i)
+		if before := gw.poolSender(client, "+12505551001", to); before != "+12505551003" {
+			assert.Equal(t, before, gw.poolSender(smaller, "+12505551001", to))
+		}
+	}
+}
+
+func TestPoolSender_RoundRobin(t *testing.T) {
+	gw := &Gateway{}
+	client := newPoolClient(SenderPoolRoundRobin)
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		got = append(got, gw.poolSender(client, "+12505551001", "+14155550000"))
+	}
+	assert.Equal(t, []string{"+12505551001", "+12505551002", "+12505551003", "+12505551001"}, got)
+}
+
+func TestPoolSender_Passthrough(t *testing.T) {
+	gw := &Gateway{}
+	assert.Equal(t, "+12505551002", gw.poolSender(newPoolClient(""), "+12505551002", "+14155550000"))
+	assert.Equal(t, "+12505551002", gw.poolSender(newPoolClient(SenderPoolPassthrough), "+12505551002", "+14155550000"))
+	assert.Equal(t, "+12505559999", gw.poolSender(newPoolClient(SenderPoolRoundRobin), "+12505559999", "+14155550000"),
+		"numbers outside the pool keep their From")
+}
+
+func TestNormalizeSenderPool(t *testing.T) {
+	client := newPoolClient(SenderPoolSticky)
+	pool, err := normalizeSenderPool(client, " +12505551001, 2, ")
+	require.NoError(t, err)
+	assert.Equal(t, "12505551001,12505551002", pool)
+
+	_, err = normalizeSenderPool(client, "+12505551001,+16045550000")
+	assert.ErrorContains(t, err, "+16045550000")
+}
+
+func TestRouter_ApplySenderPool(t *testing.T) {
+	r, gw := newTestRouter(1)
+	client := newPoolClient(SenderPoolSticky)
+	gw.Clients = map[string]*Client{"pool": client}
+	r.AddRoute("carrier", "telnyx", nil)
+
+	m := &MsgQueueItem{From: "+12505551001", To: "+14155550123", Type: MsgQueueItemType.SMS}
+	d := r.applySenderPool(m, r.decideRoute(m.From, m.To, m.Type, "client"), "client")
+	assert.Equal(t, RoutePathCarrier, d.Path)
+	assert.Equal(t, stickySender(senderPool(client), "+14155550123"), m.From)
+	assert.Equal(t, "telnyx", d.Carrier)
+
+	// Client-to-client messages are not rewritten.
+	m = &MsgQueueItem{From: "+12505551001", To: "+12505551002", Type: MsgQueueItemType.SMS}
+	r.applySenderPool(m, r.decideRoute(m.From, m.To, m.Type, "client"), "client")
+	assert.Equal(t, "+12505551001", m.From)
+}
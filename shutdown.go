@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Shutdown stops the gateway in order: the SMPP and MM4 listeners are closed,
+// messages already in the router are given until ctx expires to finish, bound
+// SMPP clients are unbound, and the log manager is flushed. Clients stay bound
+// while the router drains so queued deliver_sm can still reach them.
+// The returned error is ctx.Err() if the router did not drain in time.
+func (gateway *Gateway) Shutdown(ctx context.Context) error {
+	lm := gateway.LogManager
+	lm.SendLog(lm.BuildLog(
+		"System.Shutdown",
+		"ShutdownStarted",
+		logrus.InfoLevel,
+		nil,
+	))
+
+	gateway.SMPPServer.stopListening()
+	gateway.MM4Server.stopListening()
+
+	drainErr := gateway.Router.drain(ctx)
+	if drainErr != nil {
+		lm.SendLog(lm.BuildLog(
+			"System.Shutdown",
+			"DrainTimeout",
+			logrus.WarnLevel,
+			map[string]interface{}{
+				"inFlight": gateway.Router.inFlight.Load(),
+			}, drainErr,
+		))
+	}
+
+	// Unbind gets its own short deadline so it still runs after a drain timeout.
+	unbindCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	gateway.SMPPServer.unbindAll(unbindCtx)
+	cancel()
+
+	lm.SendLog(lm.BuildLog(
+		"System.Shutdown",
+		"ShutdownComplete",
+		logrus.InfoLevel,
+		nil,
+	))
+	lm.CloseLogManager()
+
+	return drainErr
+}
+
+// drain waits until all router channels are empty and no message is being
+// processed, or ctx is done.
+func (router *Router) drain(ctx context.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		if len(router.ClientMsgChan) == 0 && len(router.CarrierMsgChan) == 0 &&
+			len(router.ClientPriorityMsgChan) == 0 && len(router.CarrierPriorityMsgChan) == 0 &&
+			router.inFlight.Load() == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
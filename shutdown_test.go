@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter_DrainWaitsForQueuedAndInFlight(t *testing.T) {
+	r, _ := newTestRouter(1)
+	r.CarrierMsgChan <- MsgQueueItem{LogID: "queued"}
+	r.inFlight.Add(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, r.drain(ctx), context.DeadlineExceeded)
+
+	<-r.CarrierMsgChan
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		r.inFlight.Add(-1)
+	}()
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel2()
+	assert.NoError(t, r.drain(ctx2))
+}
+
+func TestGateway_ShutdownStopsListenersAndFlushesLogs(t *testing.T) {
+	_, gw := newTestRouter(1)
+
+	smppLn, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	mm4Ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	gw.SMPPServer = &SMPPServer{gateway: gw, listener: smppLn}
+	gw.MM4Server = &MM4Server{gateway: gw, listener: mm4Ln}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, gw.Shutdown(ctx))
+
+	assert.False(t, gw.SMPPServer.isListening())
+	assert.False(t, gw.MM4Server.isListening())
+	assert.True(t, gw.SMPPServer.isClosing())
+	assert.True(t, gw.MM4Server.isClosing())
+
+	_, err = smppLn.Accept()
+	assert.Error(t, err, "SMPP listener must be closed")
+	_, err = mm4Ln.Accept()
+	assert.Error(t, err, "MM4 listener must be closed")
+
+	// Logging after shutdown must not panic on the closed channel.
+	assert.NotPanics(t, func() {
+		gw.LogManager.SendLog(gw.LogManager.BuildLog("Test", "AfterShutdown", logrus.InfoLevel, nil))
+		gw.LogManager.CloseLogManager()
+	})
+}
@@ -0,0 +1,246 @@
+package gsm7bit
+
+import "fmt"
+
+// Language identifies a national language table (3GPP TS 23.038, 6.2.1.2.4).
+// It is the value of the national language shift information elements.
+type Language byte
+
+const (
+	Default    Language = 0
+	Turkish    Language = 1
+	Spanish    Language = 2
+	Portuguese Language = 3
+)
+
+// UDH information elements selecting national language tables
+// (3GPP TS 23.040, 9.2.3.24.15 and 9.2.3.24.16).
+const (
+	SingleShiftIEI  byte = 0x24
+	LockingShiftIEI byte = 0x25
+)
+
+// lockingShifts holds the national locking shift tables, which replace the
+// default alphabet. Only the code points that differ from it are listed.
+var lockingShifts = map[Language]map[byte]rune{
+	Turkish: {
+		0x04: '€', 0x07: 'ı', 0x0B: 'Ğ', 0x0C: 'ğ', 0x1C: 'Ş', 0x1D: 'ş',
+		0x40: 'İ', 0x60: 'ç',
+	},
+}
+
+// singleShifts holds the national single shift tables, which replace the
+// extension table reached through an escape.
+var singleShifts = map[Language]map[byte]rune{
+	Turkish: {
+		0x0A: 0x0C, 0x14: '^', 0x28: '{', 0x29: '}', 0x2F: '\\', 0x3C: '[',
+		0x3D: '~', 0x3E: ']', 0x40: '|', 0x47: 'Ğ', 0x49: 'İ', 0x53: 'Ş',
+		0x63: 'ç', 0x65: '€', 0x67: 'ğ', 0x69: 'ı', 0x73: 'ş',
+	},
+	Spanish: {
+		0x09: 'ç', 0x0A: 0x0C, 0x14: '^', 0x28: '{', 0x29: '}', 0x2F: '\\',
+		0x3C: '[', 0x3D: '~', 0x3E: ']', 0x40: '|', 0x41: 'Á', 0x49: 'Í',
+		0x4F: 'Ó', 0x55: 'Ú', 0x61: 'á', 0x65: '€', 0x69: 'í', 0x6F: 'ó',
+		0x75: 'ú',
+	},
+	Portuguese: {
+		0x05: 'ê', 0x09: 'ç', 0x0A: 0x0C, 0x0B: 'Ô', 0x0C: 'ô', 0x0E: 'Á',
+		0x0F: 'á', 0x12: 'Φ', 0x13: 'Γ', 0x14: '^', 0x15: 'Ω', 0x16: 'Π',
+		0x17: 'Ψ', 0x18: 'Σ', 0x19: 'Θ', 0x1F: 'Ê', 0x28: '{', 0x29: '}',
+		0x2F: '\\', 0x3C: '[', 0x3D: '~', 0x3E: ']', 0x40: '|', 0x41: 'À',
+		0x49: 'Í', 0x4F: 'Ó', 0x55: 'Ú', 0x5B: 'Ã', 0x5C: 'Õ', 0x61: 'Â',
+		0x65: '€', 0x69: 'í', 0x6F: 'ó', 0x75: 'ú', 0x7B: 'ã', 0x7C: 'õ',
+		0x7F: 'â',
+	},
+}
+
+// shiftTable is one table in both directions.
+type shiftTable struct {
+	decode map[byte]rune
+	encode map[rune]byte
+}
+
+func newShiftTable(decode map[byte]rune) shiftTable {
+	t := shiftTable{decode: decode, encode: make(map[rune]byte, len(decode))}
+	for b, r := range decode {
+		t.encode[r] = b
+	}
+	return t
+}
+
+var lockingTables, singleTables map[Language]shiftTable
+
+func init() {
+	defaultLocking := make(map[byte]rune, 127)
+	for b := 0; b < 0x80; b++ {
+		if byte(b) != esc {
+			defaultLocking[byte(b)] = reverseLookup[b]
+		}
+	}
+	defaultSingle := make(map[byte]rune, len(forwardEscapes))
+	for r, b := range forwardEscapes {
+		defaultSingle[b] = r
+	}
+
+	lockingTables = map[Language]shiftTable{Default: newShiftTable(defaultLocking)}
+	for lang, diff := range lockingShifts {
+		table := make(map[byte]rune, len(defaultLocking))
+		for b, r := range defaultLocking {
+			table[b] = r
+		}
+		for b, r := range diff {
+			table[b] = r
+		}
+		lockingTables[lang] = newShiftTable(table)
+	}
+	singleTables = map[Language]shiftTable{Default: newShiftTable(defaultSingle)}
+	for lang, table := range singleShifts {
+		singleTables[lang] = newShiftTable(table)
+	}
+}
+
+// Tables is the pair of tables a message is encoded with. The zero value is
+// the default alphabet and extension table.
+type Tables struct {
+	Locking Language
+	Single  Language
+}
+
+// TablesFor returns the cheapest tables of lang that can encode input, and
+// false if none can. Tables that need no shift are preferred when they fit.
+func TablesFor(lang Language, input string) (Tables, bool) {
+	candidates := []Tables{{}, {Single: lang}, {Locking: lang}, {Locking: lang, Single: lang}}
+	var (
+		best     Tables
+		bestCost = -1
+	)
+	for _, t := range candidates {
+		if !t.supported() {
+			continue
+		}
+		septets, ok := t.Len(input)
+		if !ok {
+			continue
+		}
+		// Each shift element costs 3 octets of UDH.
+		cost := septets*7 + len(t.Elements())*3*8
+		if bestCost < 0 || cost < bestCost {
+			best, bestCost = t, cost
+		}
+	}
+	return best, bestCost >= 0
+}
+
+// TablesFromElements returns the tables selected by the shift elements of a
+// UDH, or the default tables when it has none.
+func TablesFromElements(udh map[byte][]byte) Tables {
+	var t Tables
+	if v := udh[LockingShiftIEI]; len(v) == 1 {
+		t.Locking = Language(v[0])
+	}
+	if v := udh[SingleShiftIEI]; len(v) == 1 {
+		t.Single = Language(v[0])
+	}
+	return t
+}
+
+func (t Tables) supported() bool {
+	_, locking := lockingTables[t.Locking]
+	_, single := singleTables[t.Single]
+	return locking && single
+}
+
+// Elements returns the UDH information elements selecting t. The default
+// tables need none.
+func (t Tables) Elements() map[byte][]byte {
+	elements := make(map[byte][]byte)
+	if t.Locking != Default {
+		elements[LockingShiftIEI] = []byte{byte(t.Locking)}
+	}
+	if t.Single != Default {
+		elements[SingleShiftIEI] = []byte{byte(t.Single)}
+	}
+	return elements
+}
+
+// Septets returns how many septets r takes in t: 1 from the locking shift
+// table, 2 from the single shift table, or 0 if t cannot encode it.
+func (t Tables) Septets(r rune) int {
+	if _, ok := lockingTables[t.Locking].encode[r]; ok {
+		return 1
+	}
+	if _, ok := singleTables[t.Single].encode[r]; ok {
+		return 2
+	}
+	return 0
+}
+
+// Len returns the septets input takes in t, and false if t cannot encode it.
+func (t Tables) Len(input string) (int, bool) {
+	n := 0
+	for _, r := range input {
+		septets := t.Septets(r)
+		if septets == 0 {
+			return 0, false
+		}
+		n += septets
+	}
+	return n, true
+}
+
+// Split cuts input into segments of at most limit septets, never between an
+// escape and the character it shifts.
+func (t Tables) Split(input string, limit int) []string {
+	var segments []string
+	runes := []rune(input)
+	start, length := 0, 0
+	for i, r := range runes {
+		septets := t.Septets(r)
+		if length+septets > limit && i > start {
+			segments = append(segments, string(runes[start:i]))
+			start, length = i, 0
+		}
+		length += septets
+	}
+	if start < len(runes) {
+		segments = append(segments, string(runes[start:]))
+	}
+	return segments
+}
+
+// Encode returns input as unpacked septets, one per octet.
+func (t Tables) Encode(input string) ([]byte, error) {
+	locking, single := lockingTables[t.Locking], singleTables[t.Single]
+	septets := make([]byte, 0, len(input))
+	for _, r := range input {
+		if b, ok := locking.encode[r]; ok {
+			septets = append(septets, b)
+		} else if b, ok := single.encode[r]; ok {
+			septets = append(septets, esc, b)
+		} else {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidCharacter, r)
+		}
+	}
+	return septets, nil
+}
+
+// Decode returns the text of unpacked septets, one per octet.
+func (t Tables) Decode(septets []byte) (string, error) {
+	locking, single := lockingTables[t.Locking], singleTables[t.Single]
+	runes := make([]rune, 0, len(septets))
+	for i := 0; i < len(septets); i++ {
+		table := locking.decode
+		if septets[i] == esc {
+			if i++; i == len(septets) {
+				return "", ErrInvalidByte
+			}
+			table = single.decode
+		}
+		r, ok := table[septets[i]]
+		if !ok {
+			return "", ErrInvalidByte
+		}
+		runes = append(runes, r)
+	}
+	return string(runes), nil
+}
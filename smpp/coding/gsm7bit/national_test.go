@@ -0,0 +1,65 @@
+package gsm7bit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNationalTables(t *testing.T) {
+	cases := []struct {
+		lang     Language
+		input    string
+		tables   Tables
+		elements map[byte][]byte
+	}{
+		{Turkish, "Şişli'de buluşalım, ağabey İsmail", Tables{Locking: Turkish}, map[byte][]byte{LockingShiftIEI: {1}}},
+		{Turkish, "Yarın görüşürüz ç", Tables{Locking: Turkish}, map[byte][]byte{LockingShiftIEI: {1}}},
+		{Spanish, "¿Cómo estás? Él llegó ayer.", Tables{Single: Spanish}, map[byte][]byte{SingleShiftIEI: {2}}},
+		{Portuguese, "Não há ônibus amanhã", Tables{Single: Portuguese}, map[byte][]byte{SingleShiftIEI: {3}}},
+		{Spanish, "plain text", Tables{}, map[byte][]byte{}},
+	}
+	for _, c := range cases {
+		tables, ok := TablesFor(c.lang, c.input)
+		require.True(t, ok, c.input)
+		require.Equal(t, c.tables, tables, c.input)
+		require.Equal(t, c.elements, tables.Elements(), c.input)
+
+		septets, err := tables.Encode(c.input)
+		require.NoError(t, err, c.input)
+		n, _ := tables.Len(c.input)
+		require.Len(t, septets, n, c.input)
+		for _, s := range septets {
+			require.Less(t, s, byte(0x80), c.input)
+		}
+
+		decoded, err := TablesFromElements(tables.Elements()).Decode(septets)
+		require.NoError(t, err, c.input)
+		require.Equal(t, c.input, decoded)
+	}
+}
+
+func TestNationalTables_Septets(t *testing.T) {
+	turkish := Tables{Locking: Turkish, Single: Turkish}
+	require.Equal(t, 1, turkish.Septets('ş'))
+	require.Equal(t, 2, turkish.Septets('['), "extension characters stay in the single shift table")
+	require.Equal(t, 0, turkish.Septets('è'), "replaced by € in the Turkish locking table")
+
+	spanish := Tables{Single: Spanish}
+	septets, err := spanish.Encode("á€")
+	require.NoError(t, err)
+	require.Equal(t, []byte{esc, 0x61, esc, 0x65}, septets)
+
+	_, ok := TablesFor(Spanish, "Şişli")
+	require.False(t, ok)
+	_, err = spanish.Encode("ş")
+	require.ErrorIs(t, err, ErrInvalidCharacter)
+	_, err = spanish.Decode([]byte{0x41, esc})
+	require.ErrorIs(t, err, ErrInvalidByte)
+}
+
+func TestNationalTables_Split(t *testing.T) {
+	spanish := Tables{Single: Spanish}
+	require.Equal(t, []string{"aáa", "áa"}, spanish.Split("aáaáa", 4))
+	require.Equal(t, []string{"aá", "aá", "a"}, spanish.Split("aáaáa", 3), "a shifted character is never split from its escape")
+}
@@ -26,5 +26,13 @@ const (
 	ErrInvalidSystemID      CommandStatus = 0x0000000F // ESME_RINVSYSID
 	ErrInvalidPasswd        CommandStatus = 0x0000000E // ESME_RINVPASWD
 	ErrBindFail             CommandStatus = 0x00000005 // ESME_RBINDFAIL
+	ESME_RTHROTTLED         CommandStatus = 0x00000058
+	ESME_RINVSCHED          CommandStatus = 0x00000061
+	ESME_RINVMSGID          CommandStatus = 0x0000000C
+	ESME_RINVDSTADR         CommandStatus = 0x0000000B
+	ESME_RINVDLNAME         CommandStatus = 0x00000034
+	ESME_RINVBNDSTS         CommandStatus = 0x00000004
+	ESME_RMISSINGTLV        CommandStatus = 0x000000C3
+	ESME_RSYSERR            CommandStatus = 0x00000008
 	ESME_ROK                CommandStatus = 0x00000000
 )
@@ -254,7 +254,7 @@ type QuerySMResp struct {
 	MessageID    string
 	FinalDate    string
 	MessageState MessageState
-	ErrorCode    CommandStatus
+	ErrorCode    byte // network error code, one octet (SMPP v5 4.7.9)
 }
 
 // ReplaceSM see SMPP v5, section 4.5.3.1 (104p)
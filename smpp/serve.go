@@ -4,9 +4,7 @@ import (
 	"context"
 	"crypto/tls"
 	"github.com/pires/go-proxyproto"
-	log "github.com/sirupsen/logrus"
 	"net"
-	"os"
 )
 
 type Handler interface{ Serve(*Session) }
@@ -34,43 +32,30 @@ func ServeTCP(address string, handler Handler, config *tls.Config) (err error) {
 	}
 }*/
 
-func ServeTCP(address string, handler Handler, config *tls.Config) (err error) {
-	var list net.Listener
+func ServeTCP(address string, handler Handler, config *tls.Config, proxyProtocol bool) (err error) {
+	list, err := Listen(address, config)
+	if err != nil {
+		return err
+	}
+	return Serve(list, handler, proxyProtocol)
+}
+
+// Listen binds the SMPP listener without accepting connections, so callers
+// can tell when the port is bound before handing it to Serve.
+func Listen(address string, config *tls.Config) (net.Listener, error) {
 	if config == nil {
-		list, err = net.Listen("tcp", address)
-	} else {
-		list, err = tls.Listen("tcp", address, config)
+		return net.Listen("tcp", address)
 	}
+	return tls.Listen("tcp", address, config)
+}
 
+// Serve accepts sessions on an already bound listener. With proxyProtocol
+// set, connections start with an HAProxy PROXY header.
+func Serve(list net.Listener, handler Handler, proxyProtocol bool) (err error) {
 	var proxyListener net.Listener
 
-	if os.Getenv("HAPROXY_PROXY_PROTOCOL") == "true" {
+	if proxyProtocol {
 		proxyListener = &proxyproto.Listener{Listener: list}
-		/*defer proxyListener.Close()*/
-
-		// Wait for a connection and accept it
-		conn, err := proxyListener.Accept()
-		if err != nil {
-			return err
-		}
-
-		/*defer func(conn net.Conn) {
-			err := conn.Close()
-			if err != nil {
-				log.Fatal("couldn't close proxy connection")
-			}
-		}(conn)*/
-
-		// Print connection details
-		if conn.LocalAddr() == nil {
-			log.Fatal("couldn't retrieve local address")
-		}
-		log.Printf("local address: %q", conn.LocalAddr().String())
-
-		if conn.RemoteAddr() == nil {
-			log.Fatal("couldn't retrieve remote address")
-		}
-		log.Printf("remote address: %q", conn.RemoteAddr().String())
 	} else {
 		proxyListener = list
 	}
@@ -18,6 +18,7 @@ type Session struct {
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
 	LastSeen     time.Time
+	Version      pdu.InterfaceVersion // negotiated at bind; zero until bound
 }
 
 func NewSession(ctx context.Context, parent net.Conn) (session *Session) {
@@ -75,17 +76,18 @@ func (c *Session) watch(ctx context.Context) {
 func (c *Session) Submit(ctx context.Context, packet pdu.Responsable) (resp any, err error) {
 	sequence := c.NextSequence()
 	pdu.WriteSequence(packet, sequence)
+	// Register before sending: a fast peer can answer before Send returns.
+	returns := make(chan any, 1)
+	c.pending.Store(sequence, func(resp any) { returns <- resp })
+	defer c.pending.Delete(sequence)
 	if err = c.Send(packet); err != nil {
 		return
 	}
-	returns := make(chan any, 1)
-	c.pending.Store(sequence, func(resp any) { returns <- resp })
 	select {
 	case <-ctx.Done():
 		err = ErrConnectionClosed
 	case resp = <-returns:
 	}
-	c.pending.Delete(sequence)
 	return
 }
 
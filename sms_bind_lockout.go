@@ -0,0 +1,155 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- SMPP bind lockout (sliding window, in-memory) ---
+
+// bindLockout counts failed binds per source IP and per system_id. A key
+// with maxFailures failures inside window is locked out for cooldown, during
+// which binds for it are refused without checking credentials. A successful
+// bind clears both keys, so a client that mistypes a password now and then
+// never builds up towards a lockout.
+type bindLockout struct {
+	mu          sync.Mutex
+	maxFailures int
+	window      time.Duration
+	cooldown    time.Duration
+	failures    map[string][]time.Time // key -> failure times inside window
+	lockedUntil map[string]time.Time   // key -> end of its lockout
+	lastSweep   time.Time
+	now         func() time.Time
+}
+
+// newBindLockout returns a lockout for the SMPP_BIND_* settings, or nil when
+// maxFailures is 0 (no lockout).
+func newBindLockout(maxFailures int, window, cooldown time.Duration) *bindLockout {
+	if maxFailures <= 0 {
+		return nil
+	}
+	return &bindLockout{
+		maxFailures: maxFailures,
+		window:      window,
+		cooldown:    cooldown,
+		failures:    make(map[string][]time.Time),
+		lockedUntil: make(map[string]time.Time),
+		now:         time.Now,
+	}
+}
+
+func bindLockoutKeys(ip, systemID string) []string {
+	keys := make([]string, 0, 2)
+	if ip != "" {
+		keys = append(keys, "ip:"+ip)
+	}
+	if systemID != "" {
+		keys = append(keys, "system_id:"+systemID)
+	}
+	return keys
+}
+
+// locked reports whether binds from ip or for systemID are locked out.
+func (l *bindLockout) locked(ip, systemID string) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for _, key := range bindLockoutKeys(ip, systemID) {
+		if until, ok := l.lockedUntil[key]; ok && now.Before(until) {
+			return true
+		}
+	}
+	return false
+}
+
+// fail records a failed bind and reports whether it started a lockout.
+func (l *bindLockout) fail(ip, systemID string) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	started := false
+	for _, key := range bindLockoutKeys(ip, systemID) {
+		recent := l.failures[key][:0]
+		for _, t := range l.failures[key] {
+			if now.Sub(t) < l.window {
+				recent = append(recent, t)
+			}
+		}
+		recent = append(recent, now)
+
+		if len(recent) >= l.maxFailures {
+			l.lockedUntil[key] = now.Add(l.cooldown)
+			delete(l.failures, key)
+			started = true
+			continue
+		}
+		l.failures[key] = recent
+	}
+	return started
+}
+
+// succeed forgets the failures of a client that bound successfully.
+func (l *bindLockout) succeed(ip, systemID string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range bindLockoutKeys(ip, systemID) {
+		delete(l.failures, key)
+	}
+}
+
+// sweep drops expired lockouts and stale failures, at most once per window.
+// The caller holds the lock.
+func (l *bindLockout) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.window {
+		return
+	}
+	for key, until := range l.lockedUntil {
+		if !now.Before(until) {
+			delete(l.lockedUntil, key)
+		}
+	}
+	for key, times := range l.failures {
+		if len(times) == 0 || now.Sub(times[len(times)-1]) >= l.window {
+			delete(l.failures, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// lockedOut returns how many source IPs and system_ids are locked out now.
+func (l *bindLockout) lockedOut() (ips, systemIDs int) {
+	if l == nil {
+		return 0, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for key, until := range l.lockedUntil {
+		if !now.Before(until) {
+			continue
+		}
+		if strings.HasPrefix(key, "ip:") {
+			ips++
+		} else {
+			systemIDs++
+		}
+	}
+	return ips, systemIDs
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+	"zultys-smpp-mm4/smpp"
+	"zultys-smpp-mm4/smpp/pdu"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBindLockout_SlidingWindow(t *testing.T) {
+	l := newBindLockout(3, time.Minute, 10*time.Minute)
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	assert.False(t, l.fail("10.0.0.1", "pbx1"))
+	now = now.Add(50 * time.Second)
+	assert.False(t, l.fail("10.0.0.1", "pbx1"))
+
+	// The first failure has left the window by now.
+	now = now.Add(20 * time.Second)
+	assert.False(t, l.fail("10.0.0.1", "pbx1"))
+	assert.False(t, l.locked("10.0.0.1", "pbx1"))
+
+	assert.True(t, l.fail("10.0.0.1", "pbx1"), "third failure inside the window")
+	assert.True(t, l.locked("10.0.0.1", ""))
+	assert.True(t, l.locked("10.0.0.9", "pbx1"), "system_id is locked from any IP")
+	assert.False(t, l.locked("10.0.0.9", "pbx2"))
+
+	ips, systemIDs := l.lockedOut()
+	assert.Equal(t, 1, ips)
+	assert.Equal(t, 1, systemIDs)
+
+	now = now.Add(10 * time.Minute)
+	assert.False(t, l.locked("10.0.0.1", "pbx1"), "cooldown over")
+	ips, systemIDs = l.lockedOut()
+	assert.Zero(t, ips+systemIDs)
+}
+
+func TestBindLockout_SuccessForgetsTypos(t *testing.T) {
+	l := newBindLockout(3, time.Minute, time.Minute)
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 5; i++ {
+		assert.False(t, l.fail("10.0.0.1", "pbx1"))
+		assert.False(t, l.fail("10.0.0.1", "pbx1"))
+		l.succeed("10.0.0.1", "pbx1")
+	}
+	assert.False(t, l.locked("10.0.0.1", "pbx1"))
+}
+
+func TestBindLockout_Disabled(t *testing.T) {
+	l := newBindLockout(0, time.Minute, time.Minute)
+	assert.Nil(t, l)
+	assert.False(t, l.fail("10.0.0.1", "pbx1"))
+	assert.False(t, l.locked("10.0.0.1", "pbx1"))
+}
+
+func TestHandleBind_LockedOutSkipsAuth(t *testing.T) {
+	_, gw := newTestRouter(1)
+	gw.Clients = map[string]*Client{"pbx1": {Username: "pbx1", Password: "secret"}}
+
+	srv := &SMPPServer{
+		gateway:     gw,
+		conns:       map[string]*smpp.Session{},
+		bindLockout: newBindLockout(2, time.Minute, time.Minute),
+	}
+	h := NewSimpleHandler(srv)
+
+	bind := func(password string) pdu.CommandStatus {
+		serverConn, clientConn := net.Pipe()
+		defer clientConn.Close()
+		session := smpp.NewSession(context.Background(), serverConn)
+
+		req := &pdu.BindTransceiver{SystemID: "pbx1", Password: password, Version: pdu.SMPPVersion34}
+		req.Header.Sequence = 1
+		go h.handleBind(session, req)
+
+		resp, ok := readTestPDU(t, clientConn).(*pdu.BindTransceiverResp)
+		require.True(t, ok)
+		return resp.Header.CommandStatus
+	}
+
+	assert.Equal(t, pdu.ErrInvalidPasswd, bind("wrong"))
+	assert.Equal(t, pdu.ErrInvalidPasswd, bind("wrong"))
+	assert.Equal(t, pdu.ErrInvalidPasswd, bind("secret"), "locked out, credentials not checked")
+	assert.False(t, srv.isSessionActive("pbx1"))
+}
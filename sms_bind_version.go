@@ -0,0 +1,22 @@
+package main
+
+import "zultys-smpp-mm4/smpp/pdu"
+
+// tlvSCInterfaceVersion is the sc_interface_version TLV, telling a bound
+// ESME which SMPP version the gateway speaks to it.
+const tlvSCInterfaceVersion uint16 = 0x0210
+
+// negotiateInterfaceVersion returns the SMPP version a session bound with
+// interface_version v runs at: 5.0, the newest the gateway speaks, for 5.0
+// and later, otherwise 3.4. Versions below 3.4, which SMPP 3.3 ESMEs send as
+// 0x00 to 0x33, are refused: 3.3 has no TLVs, which receipts, data_sm and
+// message_payload rely on.
+func negotiateInterfaceVersion(v pdu.InterfaceVersion) (pdu.InterfaceVersion, bool) {
+	switch {
+	case v < pdu.SMPPVersion34:
+		return 0, false
+	case v < pdu.SMPPVersion50:
+		return pdu.SMPPVersion34, true
+	}
+	return pdu.SMPPVersion50, true
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+	"zultys-smpp-mm4/smpp"
+	"zultys-smpp-mm4/smpp/pdu"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateInterfaceVersion(t *testing.T) {
+	for _, tc := range []struct {
+		advertised pdu.InterfaceVersion
+		want       pdu.InterfaceVersion
+		ok         bool
+	}{
+		{0x00, 0, false},
+		{pdu.SMPPVersion33, 0, false},
+		{pdu.SMPPVersion34, pdu.SMPPVersion34, true},
+		{0x40, pdu.SMPPVersion34, true},
+		{pdu.SMPPVersion50, pdu.SMPPVersion50, true},
+		{0x51, pdu.SMPPVersion50, true},
+	} {
+		got, ok := negotiateInterfaceVersion(tc.advertised)
+		assert.Equal(t, tc.ok, ok, tc.advertised.String())
+		assert.Equal(t, tc.want, got, tc.advertised.String())
+	}
+}
+
+func TestHandleBind_InterfaceVersion(t *testing.T) {
+	_, gw := newTestRouter(1)
+	gw.Clients = map[string]*Client{
+		"pbx33": {Username: "pbx33", Password: "secret"},
+		"pbx34": {Username: "pbx34", Password: "secret"},
+		"pbx50": {Username: "pbx50", Password: "secret"},
+	}
+
+	srv := &SMPPServer{
+		gateway: gw,
+		conns:   map[string]*smpp.Session{},
+	}
+	h := NewSimpleHandler(srv)
+
+	bind := func(username string, version pdu.InterfaceVersion) (*smpp.Session, *pdu.BindTransceiverResp) {
+		serverConn, clientConn := net.Pipe()
+		t.Cleanup(func() { _ = clientConn.Close() })
+		session := smpp.NewSession(context.Background(), serverConn)
+
+		req := &pdu.BindTransceiver{SystemID: username, Password: "secret", Version: version}
+		req.Header.Sequence = 1
+		go h.handleBind(session, req)
+
+		resp, ok := readTestPDU(t, clientConn).(*pdu.BindTransceiverResp)
+		require.True(t, ok)
+		return session, resp
+	}
+
+	_, resp := bind("pbx33", pdu.SMPPVersion33)
+	assert.Equal(t, pdu.ErrBindFail, resp.Header.CommandStatus, "SMPP 3.3 is refused")
+	assert.False(t, srv.isSessionActive("pbx33"))
+
+	for username, version := range map[string]pdu.InterfaceVersion{"pbx34": pdu.SMPPVersion34, "pbx50": pdu.SMPPVersion50} {
+		session, resp := bind(username, version)
+		assert.Equal(t, pdu.ESME_ROK, resp.Header.CommandStatus, version.String())
+		assert.Equal(t, []byte{byte(version)}, resp.Tags[tlvSCInterfaceVersion], version.String())
+		require.Eventually(t, func() bool {
+			srv.mu.RLock()
+			defer srv.mu.RUnlock()
+			return srv.conns[username] == session
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, version, session.Version, "the session records the negotiated version")
+	}
+}
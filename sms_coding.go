@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"zultys-smpp-mm4/smpp/coding"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Values of the sms_coding client setting.
+const (
+	// SMSCodingAuto sends GSM7 when every character fits and UCS2 otherwise.
+	SMSCodingAuto = "auto"
+	// SMSCodingGSM7 transliterates characters outside GSM7 so the message is
+	// always sent as GSM7, for handsets that render UCS2 poorly.
+	SMSCodingGSM7 = "force_gsm7_transliterate"
+	// SMSCodingUCS2 always sends UCS2.
+	SMSCodingUCS2 = "force_ucs2"
+)
+
+// validSMSCoding reports whether policy is a coding policy, or empty.
+func validSMSCoding(policy string) bool {
+	switch policy {
+	case "", SMSCodingAuto, SMSCodingGSM7, SMSCodingUCS2:
+		return true
+	}
+	return false
+}
+
+// smsCodingPolicy returns the coding policy for SMS delivered to client over
+// SMPP, defaulting to auto.
+func smsCodingPolicy(client *Client) string {
+	if client != nil && client.Settings != nil && client.Settings.SMSCoding != "" {
+		return client.Settings.SMSCoding
+	}
+	return SMSCodingAuto
+}
+
+// applySMSCoding returns the text to send under policy and the data coding
+// to send it in. extra holds replacements from SMS_GSM7_REPLACEMENTS, which
+// take precedence over the built-in replacementMap when transliterating.
+func applySMSCoding(policy, message string, extra map[rune]string) (string, coding.DataCoding) {
+	switch policy {
+	case SMSCodingUCS2:
+		return message, coding.UCS2Coding
+	case SMSCodingGSM7:
+		return transliterateGSM7(message, extra), coding.GSM7BitCoding
+	}
+	return message, coding.BestSafeCoding(message)
+}
+
+func isGSM7Rune(r rune) bool {
+	if _, ok := gsm7DefaultMap[r]; ok {
+		return true
+	}
+	_, ok := gsm7ExtMap[r]
+	return ok
+}
+
+func isGSM7String(s string) bool {
+	for _, r := range s {
+		if !isGSM7Rune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// transliterateGSM7 rewrites input so every character is in the GSM7
+// alphabet. Characters outside it are replaced from extra, then from
+// replacementMap, then by their base letter with accents stripped (á -> a);
+// anything left is sent as '?'.
+func transliterateGSM7(input string, extra map[rune]string) string {
+	var output strings.Builder
+	for _, r := range input {
+		if isGSM7Rune(r) {
+			output.WriteRune(r)
+			continue
+		}
+		if replacement, ok := extra[r]; ok {
+			output.WriteString(replacement)
+			continue
+		}
+		if replacement, ok := replacementMap[r]; ok {
+			output.WriteString(replacement)
+			continue
+		}
+		if base := stripAccents(r); base != "" && isGSM7String(base) {
+			output.WriteString(base)
+			continue
+		}
+		output.WriteByte('?')
+	}
+	return output.String()
+}
+
+// stripAccents decomposes r and drops its combining marks.
+func stripAccents(r rune) string {
+	var base strings.Builder
+	for _, d := range norm.NFD.String(string(r)) {
+		if !unicode.Is(unicode.Mn, d) {
+			base.WriteRune(d)
+		}
+	}
+	return base.String()
+}
+
+// parseGSM7Replacements parses SMS_GSM7_REPLACEMENTS, comma-separated
+// "char=replacement" pairs such as "ł=l,™=TM". Pairs whose key is not a
+// single character or whose replacement is not GSM7 are skipped.
+func parseGSM7Replacements(val string) map[rune]string {
+	replacements := make(map[rune]string)
+	for _, pair := range strings.Split(val, ",") {
+		from, to, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		runes := []rune(from)
+		if !ok || len(runes) != 1 || !isGSM7String(to) {
+			continue
+		}
+		replacements[runes[0]] = to
+	}
+	return replacements
+}
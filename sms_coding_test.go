@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"zultys-smpp-mm4/smpp/coding"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const mixedCodingInput = "Café “quoted” naïve – łódź 😀 ™ {ok}"
+
+func TestApplySMSCoding_Policies(t *testing.T) {
+	t.Run("auto keeps the text and picks UCS2 when needed", func(t *testing.T) {
+		text, dc := applySMSCoding(SMSCodingAuto, mixedCodingInput, nil)
+		assert.Equal(t, mixedCodingInput, text)
+		assert.Equal(t, coding.UCS2Coding, dc)
+
+		text, dc = applySMSCoding(SMSCodingAuto, "plain {ok} é", nil)
+		assert.Equal(t, "plain {ok} é", text)
+		assert.Equal(t, coding.GSM7BitCoding, dc)
+	})
+
+	t.Run("force_ucs2 keeps GSM7 text as UCS2", func(t *testing.T) {
+		text, dc := applySMSCoding(SMSCodingUCS2, "plain text", nil)
+		assert.Equal(t, "plain text", text)
+		assert.Equal(t, coding.UCS2Coding, dc)
+	})
+
+	t.Run("force_gsm7_transliterate rewrites to GSM7", func(t *testing.T) {
+		text, dc := applySMSCoding(SMSCodingGSM7, mixedCodingInput, nil)
+		assert.Equal(t, coding.GSM7BitCoding, dc)
+		// é is GSM7 and kept; ï loses its accent; ł comes from replacementMap;
+		// ó and ź are stripped; the emoji and ™ have no replacement.
+		assert.Equal(t, "Café \"quoted\" naive - lodz ? ? {ok}", text)
+		assert.True(t, isGSM7String(text))
+	})
+
+	t.Run("extra replacements take precedence", func(t *testing.T) {
+		extra := map[rune]string{'™': "TM", '😀': ":)", 'ł': "L"}
+		text, _ := applySMSCoding(SMSCodingGSM7, mixedCodingInput, extra)
+		assert.Equal(t, "Café \"quoted\" naive - Lodz :) TM {ok}", text)
+	})
+
+	t.Run("empty policy behaves as auto", func(t *testing.T) {
+		assert.Equal(t, SMSCodingAuto, smsCodingPolicy(&Client{}))
+		assert.Equal(t, SMSCodingGSM7, smsCodingPolicy(&Client{Settings: &ClientSettings{SMSCoding: SMSCodingGSM7}}))
+		_, dc := applySMSCoding("", mixedCodingInput, nil)
+		assert.Equal(t, coding.UCS2Coding, dc)
+	})
+}
+
+func TestValidSMSCoding(t *testing.T) {
+	for _, policy := range []string{"", SMSCodingAuto, SMSCodingGSM7, SMSCodingUCS2} {
+		assert.True(t, validSMSCoding(policy), policy)
+	}
+	assert.False(t, validSMSCoding("gsm7"))
+}
+
+func TestParseGSM7Replacements(t *testing.T) {
+	got := parseGSM7Replacements(" ł=l , ™=TM,bad,ab=x,€=€,😀=☺")
+	assert.Equal(t, map[rune]string{'ł': "l", '™': "TM", '€': "€"}, got)
+}
+
+func TestComposeDeliverSMsAs_ForcedGSM7(t *testing.T) {
+	text, dc := applySMSCoding(SMSCodingGSM7, mixedCodingInput, nil)
+	parts, got, err := composeDeliverSMsAs("15551234567", "15557654321", text, dc, func() uint16 { return 1 })
+	require.NoError(t, err)
+	assert.Equal(t, coding.GSM7BitCoding, got)
+	require.Len(t, parts, 1)
+	assert.Equal(t, coding.GSM7BitCoding, parts[0].pdu.Message.DataCoding)
+}
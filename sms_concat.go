@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"time"
+	"zultys-smpp-mm4/smpp"
+	"zultys-smpp-mm4/smpp/coding"
+	"zultys-smpp-mm4/smpp/pdu"
+)
+
+// concatKey identifies one concatenated message from a bound client.
+type concatKey struct {
+	username string
+	source   string
+	dest     string
+	ref      uint16
+}
+
+type concatPart struct {
+	dataCoding coding.DataCoding
+	raw        []byte
+}
+
+// concatEntry buffers the segments of a multi-part submit_sm until every
+// part has arrived or the reassembly timeout fires.
+type concatEntry struct {
+	key      concatKey
+	parts    []*concatPart
+	received int
+	first    *pdu.SubmitSM // first segment received; used for addressing/receipts
+	session  *smpp.Session
+	client   *Client
+	transId  string // LogID of the first segment, reused for the whole message
+	traceID  string // TraceID of the first segment, likewise
+	started  time.Time
+	timer    *time.Timer
+	timedOut bool
+}
+
+// concatBuffer holds in-flight concatenated messages keyed by
+// (username, source, dest, reference).
+type concatBuffer struct {
+	mu      sync.Mutex
+	entries map[concatKey]*concatEntry
+	timeout time.Duration
+	onDone  func(*concatEntry)
+}
+
+func newConcatBuffer(timeout time.Duration, onDone func(*concatEntry)) *concatBuffer {
+	return &concatBuffer{
+		entries: make(map[concatKey]*concatEntry),
+		timeout: timeout,
+		onDone:  onDone,
+	}
+}
+
+// concatHeader returns the concatenation IE of the submit_sm, or nil when the
+// message is a single segment or the header is malformed.
+func concatHeader(submitSM *pdu.SubmitSM) *pdu.ConcatenatedHeader {
+	udh := submitSM.Message.UDHeader
+	if udh == nil {
+		return nil
+	}
+	if data, ok := udh[0x00]; ok && len(data) < 3 {
+		return nil
+	}
+	if data, ok := udh[0x08]; ok && len(data) < 4 {
+		return nil
+	}
+	header := udh.ConcatenatedHeader()
+	if header == nil || header.TotalParts < 2 || header.Sequence == 0 || header.Sequence > header.TotalParts {
+		return nil
+	}
+	return header
+}
+
+// add stores one segment. When it completes the message the entry is removed
+// and onDone is called; otherwise a timer flushes whatever has arrived once
+// the timeout expires. It returns the log ID the message is routed under,
+// that of its first segment.
+func (b *concatBuffer) add(key concatKey, header *pdu.ConcatenatedHeader, submitSM *pdu.SubmitSM,
+	session *smpp.Session, client *Client, transId, traceID string) string {
+	b.mu.Lock()
+	entry, exists := b.entries[key]
+	if !exists {
+		entry = &concatEntry{
+			key:     key,
+			parts:   make([]*concatPart, header.TotalParts),
+			first:   submitSM,
+			session: session,
+			client:  client,
+			transId: transId,
+			traceID: traceID,
+			started: time.Now(),
+		}
+		b.entries[key] = entry
+		entry.timer = time.AfterFunc(b.timeout, func() { b.expire(key, entry) })
+	}
+
+	idx := int(header.Sequence) - 1
+	if idx < len(entry.parts) {
+		if entry.parts[idx] == nil {
+			entry.received++
+		}
+		entry.parts[idx] = &concatPart{
+			dataCoding: submitSM.Message.DataCoding,
+			raw:        submitSM.Message.Message,
+		}
+	}
+
+	complete := entry.received == len(entry.parts)
+	if complete {
+		entry.timer.Stop()
+		delete(b.entries, key)
+	}
+	b.mu.Unlock()
+
+	if complete {
+		b.onDone(entry)
+	}
+	return entry.transId
+}
+
+func (b *concatBuffer) expire(key concatKey, entry *concatEntry) {
+	b.mu.Lock()
+	if b.entries[key] != entry {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.entries, key)
+	entry.timedOut = true
+	b.mu.Unlock()
+
+	b.onDone(entry)
+}
+
+// text decodes the buffered segments in order. When every segment shares a
+// data coding the raw payloads are joined before decoding, so characters that
+// straddle a segment boundary (UCS2 surrogate pairs, GSM7 escapes) survive.
+func (e *concatEntry) text() (string, error) {
+	var (
+		present []*concatPart
+		mixed   bool
+	)
+	for _, p := range e.parts {
+		if p == nil {
+			continue
+		}
+		if len(present) > 0 && p.dataCoding != present[0].dataCoding {
+			mixed = true
+		}
+		present = append(present, p)
+	}
+	if len(present) == 0 {
+		return "", nil
+	}
+
+	if !mixed {
+		var raw bytes.Buffer
+		for _, p := range present {
+			raw.Write(p.raw)
+		}
+		text, _, err := decodeSubmitSMText(present[0].dataCoding, raw.Bytes())
+		return text, err
+	}
+
+	var sb strings.Builder
+	for _, p := range present {
+		text, _, err := decodeSubmitSMText(p.dataCoding, p.raw)
+		if err != nil {
+			return sb.String(), err
+		}
+		sb.WriteString(text)
+	}
+	return sb.String(), nil
+}
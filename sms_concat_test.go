@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+	"time"
+	"zultys-smpp-mm4/smpp/coding"
+	"zultys-smpp-mm4/smpp/pdu"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func concatSubmitSM(ref, total, seq byte, dc coding.DataCoding, raw []byte) *pdu.SubmitSM {
+	return &pdu.SubmitSM{
+		SourceAddr: pdu.Address{TON: 1, NPI: 1, No: "15551234567"},
+		DestAddr:   pdu.Address{TON: 1, NPI: 1, No: "15557654321"},
+		ESMClass:   pdu.ESMClass{UDHIndicator: true},
+		Message: pdu.ShortMessage{
+			DataCoding: dc,
+			UDHeader:   pdu.UserDataHeader{0x00: {ref, total, seq}},
+			Message:    raw,
+		},
+	}
+}
+
+func testConcatKey(ref uint16) concatKey {
+	return concatKey{username: "pbx1", source: "15551234567", dest: "15557654321", ref: ref}
+}
+
+func TestConcatHeader(t *testing.T) {
+	assert.Nil(t, concatHeader(&pdu.SubmitSM{}), "no UDH is the single-segment path")
+
+	h := concatHeader(concatSubmitSM(7, 3, 2, coding.GSM7BitCoding, nil))
+	require.NotNil(t, h)
+	assert.Equal(t, uint16(7), h.Reference)
+	assert.Equal(t, byte(3), h.TotalParts)
+	assert.Equal(t, byte(2), h.Sequence)
+
+	assert.Nil(t, concatHeader(concatSubmitSM(7, 1, 1, coding.GSM7BitCoding, nil)), "single part")
+	assert.Nil(t, concatHeader(concatSubmitSM(7, 3, 0, coding.GSM7BitCoding, nil)), "sequence zero")
+	assert.Nil(t, concatHeader(concatSubmitSM(7, 3, 4, coding.GSM7BitCoding, nil)), "sequence past total")
+
+	short := concatSubmitSM(7, 3, 1, coding.GSM7BitCoding, nil)
+	short.Message.UDHeader = pdu.UserDataHeader{0x00: {7, 3}}
+	assert.Nil(t, concatHeader(short), "truncated IE must not panic")
+}
+
+func TestConcatBuffer_ReassemblesOutOfOrderGSM7(t *testing.T) {
+	done := make(chan *concatEntry, 1)
+	buf := newConcatBuffer(time.Minute, func(e *concatEntry) { done <- e })
+
+	p1, _ := encodeUnpackedGSM7("Hello, ")
+	p2, _ := encodeUnpackedGSM7("wide ")
+	p3, _ := encodeUnpackedGSM7("world")
+
+	for _, sm := range []*pdu.SubmitSM{
+		concatSubmitSM(9, 3, 3, coding.GSM7BitCoding, p3),
+		concatSubmitSM(9, 3, 1, coding.GSM7BitCoding, p1),
+		concatSubmitSM(9, 3, 2, coding.GSM7BitCoding, p2),
+	} {
+		buf.add(testConcatKey(9), concatHeader(sm), sm, nil, &Client{Username: "pbx1"}, "first", "trace-first")
+	}
+
+	select {
+	case e := <-done:
+		text, err := e.text()
+		require.NoError(t, err)
+		assert.Equal(t, "Hello, wide world", text)
+		assert.False(t, e.timedOut)
+		assert.Equal(t, "first", e.transId)
+		assert.Equal(t, "trace-first", e.traceID)
+	default:
+		t.Fatal("message was not completed")
+	}
+	assert.Empty(t, buf.entries)
+}
+
+func TestConcatBuffer_UCS2SurrogateAcrossSegments(t *testing.T) {
+	done := make(chan *concatEntry, 1)
+	buf := newConcatBuffer(time.Minute, func(e *concatEntry) { done <- e })
+
+	raw, err := coding.UCS2Coding.Encoding().NewEncoder().Bytes([]byte("hi 😀"))
+	require.NoError(t, err)
+	// Split inside the surrogate pair of the emoji.
+	cut := len(raw) - 2
+
+	sm1 := concatSubmitSM(3, 2, 1, coding.UCS2Coding, raw[:cut])
+	sm2 := concatSubmitSM(3, 2, 2, coding.UCS2Coding, raw[cut:])
+	buf.add(testConcatKey(3), concatHeader(sm1), sm1, nil, &Client{}, "a", "")
+	buf.add(testConcatKey(3), concatHeader(sm2), sm2, nil, &Client{}, "b", "")
+
+	e := <-done
+	text, err := e.text()
+	require.NoError(t, err)
+	assert.Equal(t, "hi 😀", text)
+}
+
+func TestConcatBuffer_TimeoutFlushesPartial(t *testing.T) {
+	done := make(chan *concatEntry, 1)
+	buf := newConcatBuffer(20*time.Millisecond, func(e *concatEntry) { done <- e })
+
+	p1, _ := encodeUnpackedGSM7("only part")
+	sm := concatSubmitSM(1, 2, 1, coding.GSM7BitCoding, p1)
+	buf.add(testConcatKey(1), concatHeader(sm), sm, nil, &Client{}, "x", "")
+
+	select {
+	case e := <-done:
+		assert.True(t, e.timedOut)
+		assert.Equal(t, 1, e.received)
+		text, err := e.text()
+		require.NoError(t, err)
+		assert.Equal(t, "only part", text)
+	case <-time.After(time.Second):
+		t.Fatal("timeout did not flush the partial message")
+	}
+
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+	assert.Empty(t, buf.entries)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"zultys-smpp-mm4/smpp"
+	"zultys-smpp-mm4/smpp/pdu"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// tlvMessagePayload carries the user data of a data_sm.
+const tlvMessagePayload uint16 = 0x0424
+
+// Values of the deliver_pdu client setting: the PDU messages are delivered
+// to the client in.
+const (
+	DeliverPDUDeliverSM = "deliver_sm"
+	DeliverPDUDataSM    = "data_sm"
+)
+
+// validDeliverPDU reports whether value is a deliver_pdu setting, or empty.
+func validDeliverPDU(value string) bool {
+	switch value {
+	case "", DeliverPDUDeliverSM, DeliverPDUDataSM:
+		return true
+	}
+	return false
+}
+
+// usesDataSM reports whether messages to client go out as data_sm.
+func usesDataSM(client *Client) bool {
+	return client != nil && client.Settings != nil && client.Settings.DeliverPDU == DeliverPDUDataSM
+}
+
+// dataSMAsSubmitSM returns the submit_sm equivalent of a data_sm, with the
+// message_payload TLV as the short message, so it can share the submit_sm
+// reassembly and enqueue path.
+func dataSMAsSubmitSM(dataSM *pdu.DataSM) (*pdu.SubmitSM, error) {
+	payload, ok := dataSM.Tags[tlvMessagePayload]
+	if !ok {
+		return nil, errors.New("data_sm has no message_payload")
+	}
+
+	message := pdu.ShortMessage{DataCoding: dataSM.DataCoding, Message: payload}
+	if dataSM.ESMClass.UDHIndicator && len(payload) > 0 {
+		n := 1 + int(payload[0])
+		if n > len(payload) {
+			return nil, errors.New("user data header is longer than message_payload")
+		}
+		var udh pdu.UserDataHeader
+		if _, err := udh.ReadFrom(bytes.NewReader(payload[:n])); err != nil {
+			return nil, err
+		}
+		message.UDHeader, message.Message = udh, payload[n:]
+	}
+
+	return &pdu.SubmitSM{
+		Header:             dataSM.Header,
+		ServiceType:        dataSM.ServiceType,
+		SourceAddr:         dataSM.SourceAddr,
+		DestAddr:           dataSM.DestAddr,
+		ESMClass:           dataSM.ESMClass,
+		RegisteredDelivery: dataSM.RegisteredDelivery,
+		Message:            message,
+	}, nil
+}
+
+// deliverSMAsDataSM returns the data_sm equivalent of a composed deliver_sm:
+// the user data header and text move into message_payload.
+func deliverSMAsDataSM(deliverSM *pdu.DeliverSM) *pdu.DataSM {
+	var payload bytes.Buffer
+	_, _ = deliverSM.Message.UDHeader.WriteTo(&payload)
+	payload.Write(deliverSM.Message.Message)
+
+	tags := pdu.Tags{tlvMessagePayload: payload.Bytes()}
+	for tag, value := range deliverSM.Tags {
+		tags[tag] = value
+	}
+
+	esmClass := deliverSM.ESMClass
+	esmClass.UDHIndicator = deliverSM.Message.UDHeader != nil
+
+	return &pdu.DataSM{
+		Header:             deliverSM.Header,
+		ServiceType:        deliverSM.ServiceType,
+		SourceAddr:         deliverSM.SourceAddr,
+		DestAddr:           deliverSM.DestAddr,
+		ESMClass:           esmClass,
+		RegisteredDelivery: deliverSM.RegisteredDelivery,
+		DataCoding:         deliverSM.Message.DataCoding,
+		Tags:               tags,
+	}
+}
+
+// outboundPDU returns deliverSM as the PDU client expects it in.
+func outboundPDU(client *Client, deliverSM *pdu.DeliverSM) any {
+	if usesDataSM(client) {
+		return deliverSMAsDataSM(deliverSM)
+	}
+	return deliverSM
+}
+
+// handleDataSM accepts a data_sm from a bound client as it would the
+// equivalent submit_sm. data_sm has no scheduled delivery, so it is always
+// routed straight away.
+func (h *SimpleHandler) handleDataSM(session *smpp.Session, dataSM *pdu.DataSM) {
+	transId := primitive.NewObjectID().Hex()
+	traceID := newTraceID()
+	lm := h.server.gateway.LogManager
+
+	respond := func(status pdu.CommandStatus, messageID string) {
+		resp := dataSM.Resp().(*pdu.DataSMResp)
+		resp.Header.CommandStatus = status
+		resp.MessageID = messageID
+		if err := session.Send(resp); err != nil {
+			lm.SendLog(lm.BuildLog(
+				"Server.SMPP.HandleDataSM",
+				"SMPPPDUError",
+				logrus.ErrorLevel,
+				map[string]interface{}{
+					"ip":    session.Parent.RemoteAddr().String(),
+					"logID": transId,
+				}, err,
+			))
+		}
+	}
+
+	username, client := h.server.getSessionClientInfo(session)
+	if client == nil {
+		lm.SendLog(lm.BuildLog(
+			"Server.SMPP.HandleDataSM",
+			"SMPPUnknownOrUnauthedSession",
+			logrus.ErrorLevel,
+			map[string]interface{}{
+				"ip": session.Parent.RemoteAddr().String(),
+			},
+		))
+		respond(pdu.ESME_RINVBNDSTS, "")
+		return
+	}
+
+	fields := map[string]interface{}{
+		"logID":    transId,
+		"traceID":  traceID,
+		"ip":       session.Parent.RemoteAddr().String(),
+		"client":   client.Username,
+		"username": username,
+		"from":     dataSM.SourceAddr.String(),
+		"to":       dataSM.DestAddr.String(),
+		"sequence": dataSM.Header.Sequence,
+	}
+
+	if rate := h.server.gateway.submitRateLimit(client); !h.server.submitLimiter.allow(username, rate) {
+		fields["rateLimit"] = rate
+		lm.SendLog(lm.BuildLog("Server.SMPP.HandleDataSM", "SubmitThrottled", logrus.WarnLevel, fields))
+		respond(pdu.ESME_RTHROTTLED, "")
+		return
+	}
+
+	if err := h.server.gateway.validateClientDest(dataSM.DestAddr.String()); err != nil {
+		lm.SendLog(lm.BuildLog("Server.SMPP.HandleDataSM", "InvalidDestination", logrus.WarnLevel, fields, err))
+		respond(pdu.ESME_RINVDSTADR, "")
+		return
+	}
+
+	submitSM, err := dataSMAsSubmitSM(dataSM)
+	if err != nil {
+		lm.SendLog(lm.BuildLog("Server.SMPP.HandleDataSM", "InvalidMessagePayload", logrus.WarnLevel, fields, err))
+		respond(pdu.ESME_RMISSINGTLV, "")
+		return
+	}
+
+	decodedMsg, encoding, _ := decodeSubmitSMText(submitSM.Message.DataCoding, submitSM.Message.Message)
+	if encoding != submitSM.Message.DataCoding {
+		fields["dataCoding"] = byte(submitSM.Message.DataCoding)
+		fields["fallback"] = dataCodingName(encoding)
+		lm.SendLog(lm.BuildLog("Server.SMPP.HandleDataSM", "UnknownDataCoding", logrus.WarnLevel, fields))
+	}
+
+	firstID := transId
+	if header := concatHeader(submitSM); header != nil {
+		key := concatKey{
+			username: username,
+			source:   submitSM.SourceAddr.String(),
+			dest:     submitSM.DestAddr.String(),
+			ref:      header.Reference,
+		}
+		firstID = h.server.concat.add(key, header, submitSM, session, client, transId, traceID)
+	} else if decodedMsg == "" {
+		lm.SendLog(lm.BuildLog("Server.SMPP.HandleDataSM", "EmptyDecodedMessage", logrus.DebugLevel, fields))
+		respond(pdu.ESME_ROK, "")
+		return
+	} else if queued, err := h.enqueueSubmitSM(session, client, username, transId, traceID, submitSM, decodedMsg, 1); err != nil {
+		respond(pdu.ESME_RSYSERR, "")
+		return
+	} else if !queued {
+		respond(pdu.ESME_ROK, "")
+		return
+	}
+
+	h.server.submitStates.submitted(transId, username, msgStateEnroute)
+	h.server.submitStates.link(firstID, transId)
+	respond(pdu.ESME_ROK, transId)
+}
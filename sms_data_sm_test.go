@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"zultys-smpp-mm4/smpp/coding"
+	"zultys-smpp-mm4/smpp/pdu"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// roundTripPDU marshals packet and unmarshals it again, as a peer would.
+// Sequence numbers must be set; zero is refused by Marshal.
+func roundTripPDU(t *testing.T, packet any) any {
+	t.Helper()
+	var buf bytes.Buffer
+	_, err := pdu.Marshal(&buf, packet)
+	require.NoError(t, err)
+	decoded, err := pdu.Unmarshal(&buf)
+	require.NoError(t, err)
+	return decoded
+}
+
+func TestDataSMAsSubmitSM_RoundTrip(t *testing.T) {
+	sent := &pdu.DataSM{
+		Header:     pdu.Header{Sequence: 7},
+		SourceAddr: pdu.Address{TON: 1, NPI: 1, No: "15550001111"},
+		DestAddr:   pdu.Address{TON: 1, NPI: 1, No: "15550002222"},
+		DataCoding: coding.GSM7BitCoding,
+		Tags:       pdu.Tags{tlvMessagePayload: []byte("hello")},
+	}
+	dataSM, ok := roundTripPDU(t, sent).(*pdu.DataSM)
+	require.True(t, ok)
+
+	submitSM, err := dataSMAsSubmitSM(dataSM)
+	require.NoError(t, err)
+	assert.Equal(t, int32(7), submitSM.Header.Sequence)
+	assert.Equal(t, "+15550001111", submitSM.SourceAddr.String())
+	assert.Equal(t, "+15550002222", submitSM.DestAddr.String())
+	assert.Nil(t, concatHeader(submitSM))
+
+	text, enc, err := decodeSubmitSMText(submitSM.Message.DataCoding, submitSM.Message.Message)
+	require.NoError(t, err)
+	assert.Equal(t, coding.GSM7BitCoding, enc)
+	assert.Equal(t, "hello", text)
+}
+
+func TestDataSMAsSubmitSM_Concatenated(t *testing.T) {
+	udh := make(pdu.UserDataHeader)
+	pdu.ConcatenatedHeader{Reference: 0x42, TotalParts: 2, Sequence: 1}.Set(udh)
+	var payload bytes.Buffer
+	_, _ = udh.WriteTo(&payload)
+	payload.WriteString("part one")
+
+	sent := &pdu.DataSM{
+		Header:     pdu.Header{Sequence: 8},
+		SourceAddr: pdu.Address{TON: 1, NPI: 1, No: "15550001111"},
+		DestAddr:   pdu.Address{TON: 1, NPI: 1, No: "15550002222"},
+		ESMClass:   pdu.ESMClass{UDHIndicator: true},
+		Tags:       pdu.Tags{tlvMessagePayload: payload.Bytes()},
+	}
+	submitSM, err := dataSMAsSubmitSM(roundTripPDU(t, sent).(*pdu.DataSM))
+	require.NoError(t, err)
+
+	header := concatHeader(submitSM)
+	require.NotNil(t, header)
+	assert.Equal(t, uint16(0x42), header.Reference)
+	assert.Equal(t, byte(2), header.TotalParts)
+	assert.Equal(t, byte(1), header.Sequence)
+	assert.Equal(t, []byte("part one"), submitSM.Message.Message)
+}
+
+func TestDataSMAsSubmitSM_Invalid(t *testing.T) {
+	_, err := dataSMAsSubmitSM(&pdu.DataSM{})
+	assert.Error(t, err, "no message_payload")
+
+	_, err = dataSMAsSubmitSM(&pdu.DataSM{
+		ESMClass: pdu.ESMClass{UDHIndicator: true},
+		Tags:     pdu.Tags{tlvMessagePayload: []byte{0x05, 0x00}},
+	})
+	assert.Error(t, err, "truncated user data header")
+}
+
+func TestDeliverSMAsDataSM_RoundTrip(t *testing.T) {
+	parts, _, err := composeDeliverSMs("15550001111", "15550002222", "hello there", func() uint16 { return 1 })
+	require.NoError(t, err)
+	require.Len(t, parts, 1)
+
+	parts[0].pdu.Header.Sequence = 1
+	dataSM, ok := roundTripPDU(t, deliverSMAsDataSM(parts[0].pdu)).(*pdu.DataSM)
+	require.True(t, ok)
+	assert.Equal(t, "+15550001111", dataSM.SourceAddr.String())
+	assert.Equal(t, "+15550002222", dataSM.DestAddr.String())
+	assert.False(t, dataSM.ESMClass.UDHIndicator)
+
+	submitSM, err := dataSMAsSubmitSM(dataSM)
+	require.NoError(t, err)
+	assert.Equal(t, parts[0].pdu.Message.Message, submitSM.Message.Message)
+}
+
+func TestDeliverSMAsDataSM_Concatenated(t *testing.T) {
+	long := ""
+	for len(long) < 200 {
+		long += "0123456789"
+	}
+	parts, _, err := composeDeliverSMs("15550001111", "15550002222", long, func() uint16 { return 9 })
+	require.NoError(t, err)
+	require.Len(t, parts, 2)
+
+	for i, part := range parts {
+		part.pdu.Header.Sequence = int32(i + 1)
+		dataSM := roundTripPDU(t, deliverSMAsDataSM(part.pdu)).(*pdu.DataSM)
+		assert.True(t, dataSM.ESMClass.UDHIndicator)
+
+		submitSM, err := dataSMAsSubmitSM(dataSM)
+		require.NoError(t, err)
+		header := concatHeader(submitSM)
+		require.NotNil(t, header)
+		assert.Equal(t, uint16(9), header.Reference)
+		assert.Equal(t, byte(i+1), header.Sequence)
+		assert.Equal(t, part.pdu.Message.Message, submitSM.Message.Message)
+	}
+}
+
+func TestOutboundPDU(t *testing.T) {
+	deliverSM := &pdu.DeliverSM{Message: pdu.ShortMessage{Message: []byte("hi")}}
+
+	assert.IsType(t, &pdu.DeliverSM{}, outboundPDU(nil, deliverSM))
+	assert.IsType(t, &pdu.DeliverSM{}, outboundPDU(&Client{Settings: &ClientSettings{}}, deliverSM))
+	assert.IsType(t, &pdu.DataSM{}, outboundPDU(&Client{Settings: &ClientSettings{DeliverPDU: DeliverPDUDataSM}}, deliverSM))
+
+	assert.True(t, validDeliverPDU(""))
+	assert.True(t, validDeliverPDU(DeliverPDUDataSM))
+	assert.False(t, validDeliverPDU("submit_sm"))
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"time"
+	"zultys-smpp-mm4/smpp/coding"
+	"zultys-smpp-mm4/smpp/pdu"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Delivery receipt states (SMPP v3.4 Appendix B short-form stat values).
+const (
+	DLRStatDelivered     = "DELIVRD"
+	DLRStatUndeliverable = "UNDELIV"
+	DLRStatRejected      = "REJECTD"
+	DLRStatExpired       = "EXPIRED"
+)
+
+// Delivery receipt error codes carried in the "err:" field.
+const (
+	DLRErrNone          = 0
+	DLRErrCarrierFailed = 1
+	DLRErrStopBlocked   = 2
+	DLRErrBlocklisted   = 3
+)
+
+// Optional TLV tags set on receipts so ESMEs don't have to parse the body.
+const (
+	tlvReceiptedMessageID uint16 = 0x001E
+	tlvMessageState       uint16 = 0x0427
+
+	// tlvGatewayMessageID is a vendor-specific TLV carrying the gateway's
+	// message_id on the deliver_sm of an MO message. receipted_message_id
+	// is not used there so clients do not take the message for a receipt.
+	tlvGatewayMessageID uint16 = 0x1400
+)
+
+// Values of the delivery_receipts and webhook_delivery_receipts client
+// settings. Empty sends the receipts the submit_sm asked for in
+// registered_delivery; the others apply whatever it asked for.
+const (
+	DeliveryReceiptsRequested    = ""
+	DeliveryReceiptsOff          = "off"
+	DeliveryReceiptsFailuresOnly = "failures_only"
+	DeliveryReceiptsAll          = "all"
+)
+
+// dlrTimeLayout is the YYMMDDhhmm layout used for submit/done dates.
+const dlrTimeLayout = "0601021504"
+
+// SMPPReceiptRequest carries what is needed to return a delivery receipt to
+// the ESME that submitted a message. It is attached to the MsgQueueItem in
+// handleSubmitSM when registered_delivery requests an MC delivery receipt.
+type SMPPReceiptRequest struct {
+	Username   string // bound system_id of the originating session
+	MessageID  string // message_id returned in submit_sm_resp
+	Sequence   int32  // sequence number of the original submit_sm
+	SubmitDate time.Time
+	Mode       byte // RegisteredDelivery.MCDeliveryReceipt value
+	SourceAddr pdu.Address
+	DestAddr   pdu.Address
+	Text       string
+}
+
+// wants reports whether a receipt with the given stat was requested.
+// 1 = success and failure, 2 = failure only, 3 = success only (SMPP v5 4.7.21).
+func (r *SMPPReceiptRequest) wants(stat string) bool {
+	if r == nil {
+		return false
+	}
+	switch r.Mode {
+	case 1:
+		return true
+	case 2:
+		return stat != DLRStatDelivered
+	case 3:
+		return stat == DLRStatDelivered
+	default:
+		return false
+	}
+}
+
+// normalizeDeliveryReceipts returns policy in its stored form, accepting
+// "failures-only" for failures_only.
+func normalizeDeliveryReceipts(policy string) string {
+	if policy == "failures-only" {
+		return DeliveryReceiptsFailuresOnly
+	}
+	return policy
+}
+
+// validDeliveryReceipts reports whether policy is a delivery receipt policy.
+func validDeliveryReceipts(policy string) bool {
+	switch policy {
+	case DeliveryReceiptsRequested, DeliveryReceiptsOff, DeliveryReceiptsFailuresOnly, DeliveryReceiptsAll:
+		return true
+	}
+	return false
+}
+
+// receiptPolicy returns the receipt policy for c: webhook_delivery_receipts
+// when its SMS go to its webhook, since receipts follow them there, and
+// delivery_receipts otherwise.
+func (c *Client) receiptPolicy() string {
+	if c == nil {
+		return DeliveryReceiptsRequested
+	}
+	if c.usesWebhookDelivery(MsgQueueItemType.SMS) {
+		return c.WebhookDeliveryReceipts
+	}
+	return c.DeliveryReceipts
+}
+
+// receiptMode returns the registered_delivery receipt mode to act on for a
+// submit_sm from c that asked for requested. 0 means no receipt.
+func (c *Client) receiptMode(requested byte) byte {
+	switch normalizeDeliveryReceipts(c.receiptPolicy()) {
+	case DeliveryReceiptsOff:
+		return 0
+	case DeliveryReceiptsFailuresOnly:
+		return 2
+	case DeliveryReceiptsAll:
+		return 1
+	}
+	return requested
+}
+
+// formatDeliveryReceipt builds the standard receipt body:
+// id:IIIIIIIIII sub:SSS dlvrd:DDD submit date:YYMMDDhhmm done date:YYMMDDhhmm stat:DDDDDDD err:E text:...
+func formatDeliveryReceipt(id, stat string, errCode int, submit, done time.Time, text string) string {
+	dlvrd := 0
+	if stat == DLRStatDelivered {
+		dlvrd = 1
+	}
+	runes := []rune(text)
+	if len(runes) > 20 {
+		runes = runes[:20]
+	}
+	return fmt.Sprintf("id:%s sub:001 dlvrd:%03d submit date:%s done date:%s stat:%s err:%03d text:%s",
+		id, dlvrd, submit.Format(dlrTimeLayout), done.Format(dlrTimeLayout), stat, errCode, string(runes))
+}
+
+// newDeliveryReceiptPDU builds the deliver_sm carrying a delivery receipt for
+// the given request. Source/destination are swapped relative to the submit.
+func newDeliveryReceiptPDU(req *SMPPReceiptRequest, stat string, errCode int, done time.Time) *pdu.DeliverSM {
+	body := formatDeliveryReceipt(req.MessageID, stat, errCode, req.SubmitDate, done, req.Text)
+
+	encoded, err := encodeUnpackedGSM7(body)
+	if err != nil {
+		encoded = []byte(body)
+	}
+
+	state := dlrMessageState(stat)
+
+	deliverSM := &pdu.DeliverSM{
+		SourceAddr: req.DestAddr,
+		DestAddr:   req.SourceAddr,
+		ESMClass:   pdu.ESMClass{MessageType: 1}, // MC delivery receipt
+		Message:    pdu.ShortMessage{Message: encoded, DataCoding: coding.GSM7BitCoding},
+		Tags: pdu.Tags{
+			tlvReceiptedMessageID: append([]byte(req.MessageID), 0),
+			tlvMessageState:       {byte(state)},
+		},
+	}
+	return deliverSM
+}
+
+// sendDeliveryReceipt records the final state for query_sm and emits a
+// delivery receipt to the originating ESME if one was requested, as a
+// deliver_sm or, for clients whose SMS go to their webhook, as a webhook
+// POST. It is a no-op for messages that did not come in over SMPP.
+func (s *SMPPServer) sendDeliveryReceipt(msg *MsgQueueItem, stat string, errCode int) {
+	if s != nil && msg != nil {
+		s.submitStates.finish(msg.LogID, stat, errCode)
+	}
+	if s == nil || s.gateway == nil || msg == nil || !msg.DeliveryReceipt.wants(stat) {
+		return
+	}
+	lm := s.gateway.LogManager
+	req := msg.DeliveryReceipt
+
+	s.gateway.mu.RLock()
+	client := s.gateway.Clients[req.Username]
+	s.gateway.mu.RUnlock()
+	if client != nil && client.usesWebhookDelivery(MsgQueueItemType.SMS) {
+		s.gateway.Router.queueReceiptWebhook(msg, newClientReceiptPayload(msg, stat, errCode, time.Now()))
+		return
+	}
+
+	session, err := s.getSessionByUsername(req.Username)
+	if err != nil {
+		lm.SendLog(lm.BuildLog(
+			"Server.SMPP.DeliveryReceipt",
+			"NoSessionForReceipt",
+			logrus.WarnLevel,
+			map[string]interface{}{
+				"logID":     msg.LogID,
+				"traceID":   msg.TraceID,
+				"username":  req.Username,
+				"messageID": req.MessageID,
+				"stat":      stat,
+			}, err,
+		))
+		return
+	}
+
+	deliverSM := newDeliveryReceiptPDU(req, stat, errCode, time.Now())
+	deliverSM.Header.Sequence = session.NextSequence()
+
+	if err := session.Send(deliverSM); err != nil {
+		lm.SendLog(lm.BuildLog(
+			"Server.SMPP.DeliveryReceipt",
+			"SendError",
+			logrus.ErrorLevel,
+			map[string]interface{}{
+				"logID":     msg.LogID,
+				"traceID":   msg.TraceID,
+				"username":  req.Username,
+				"messageID": req.MessageID,
+				"stat":      stat,
+			}, err,
+		))
+		return
+	}
+
+	lm.SendLog(lm.BuildLog(
+		"Server.SMPP.DeliveryReceipt",
+		"ReceiptSent",
+		logrus.InfoLevel,
+		map[string]interface{}{
+			"logID":          msg.LogID,
+			"traceID":        msg.TraceID,
+			"username":       req.Username,
+			"messageID":      req.MessageID,
+			"stat":           stat,
+			"err":            errCode,
+			"sequence":       deliverSM.Header.Sequence,
+			"submitSequence": req.Sequence,
+		},
+	))
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"zultys-smpp-mm4/smpp/pdu"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testReceiptRequest(mode byte) *SMPPReceiptRequest {
+	return &SMPPReceiptRequest{
+		Username:   "pbx1",
+		MessageID:  "65a1b2c3d4e5f60718293a4b",
+		Sequence:   42,
+		SubmitDate: time.Date(2024, 3, 9, 14, 5, 0, 0, time.UTC),
+		Mode:       mode,
+		SourceAddr: pdu.Address{TON: 1, NPI: 1, No: "15551234567"},
+		DestAddr:   pdu.Address{TON: 1, NPI: 1, No: "15557654321"},
+		Text:       "hello there, this is a longer message body",
+	}
+}
+
+func TestFormatDeliveryReceipt(t *testing.T) {
+	submit := time.Date(2024, 3, 9, 14, 5, 0, 0, time.UTC)
+	done := time.Date(2024, 3, 9, 14, 6, 0, 0, time.UTC)
+
+	got := formatDeliveryReceipt("abc123", DLRStatDelivered, DLRErrNone, submit, done, "hello")
+	assert.Equal(t, "id:abc123 sub:001 dlvrd:001 submit date:2403091405 done date:2403091406 stat:DELIVRD err:000 text:hello", got)
+
+	got = formatDeliveryReceipt("abc123", DLRStatUndeliverable, DLRErrCarrierFailed, submit, done, "hello")
+	assert.Equal(t, "id:abc123 sub:001 dlvrd:000 submit date:2403091405 done date:2403091406 stat:UNDELIV err:001 text:hello", got)
+}
+
+func TestFormatDeliveryReceipt_TruncatesText(t *testing.T) {
+	now := time.Now()
+	got := formatDeliveryReceipt("x", DLRStatDelivered, DLRErrNone, now, now, "0123456789abcdefghijKLMNOP")
+	assert.True(t, strings.HasSuffix(got, "text:0123456789abcdefghij"))
+}
+
+func TestSMPPReceiptRequest_Wants(t *testing.T) {
+	var nilReq *SMPPReceiptRequest
+	assert.False(t, nilReq.wants(DLRStatDelivered))
+
+	assert.False(t, testReceiptRequest(0).wants(DLRStatDelivered))
+
+	assert.True(t, testReceiptRequest(1).wants(DLRStatDelivered))
+	assert.True(t, testReceiptRequest(1).wants(DLRStatUndeliverable))
+
+	assert.False(t, testReceiptRequest(2).wants(DLRStatDelivered))
+	assert.True(t, testReceiptRequest(2).wants(DLRStatUndeliverable))
+
+	assert.True(t, testReceiptRequest(3).wants(DLRStatDelivered))
+	assert.False(t, testReceiptRequest(3).wants(DLRStatUndeliverable))
+}
+
+// roundTripDeliverSM marshals and unmarshals the PDU so the test exercises
+// the same wire encoding the ESME will see.
+func roundTripDeliverSM(t *testing.T, p *pdu.DeliverSM) *pdu.DeliverSM {
+	t.Helper()
+	var buf bytes.Buffer
+	_, err := pdu.Marshal(&buf, p)
+	require.NoError(t, err)
+	parsed, err := pdu.Unmarshal(&buf)
+	require.NoError(t, err)
+	out, ok := parsed.(*pdu.DeliverSM)
+	require.True(t, ok, "expected *pdu.DeliverSM, got %T", parsed)
+	return out
+}
+
+func TestNewDeliveryReceiptPDU_Delivered(t *testing.T) {
+	req := testReceiptRequest(1)
+	done := time.Date(2024, 3, 9, 14, 6, 0, 0, time.UTC)
+
+	p := newDeliveryReceiptPDU(req, DLRStatDelivered, DLRErrNone, done)
+	p.Header.Sequence = 7
+	got := roundTripDeliverSM(t, p)
+
+	assert.Equal(t, byte(1), got.ESMClass.MessageType)
+	assert.Equal(t, "15557654321", got.SourceAddr.No)
+	assert.Equal(t, "15551234567", got.DestAddr.No)
+
+	body, err := decodeUnpackedGSM7(got.Message.Message)
+	require.NoError(t, err)
+	assert.Contains(t, body, "id:"+req.MessageID)
+	assert.Contains(t, body, "submit date:2403091405")
+	assert.Contains(t, body, "done date:2403091406")
+	assert.Contains(t, body, "stat:DELIVRD")
+	assert.Contains(t, body, "err:000")
+
+	assert.Equal(t, []byte{2}, got.Tags[tlvMessageState])
+	assert.Equal(t, append([]byte(req.MessageID), 0), got.Tags[tlvReceiptedMessageID])
+}
+
+func TestNewDeliveryReceiptPDU_Undeliverable(t *testing.T) {
+	req := testReceiptRequest(1)
+	done := time.Date(2024, 3, 9, 14, 7, 0, 0, time.UTC)
+
+	p := newDeliveryReceiptPDU(req, DLRStatUndeliverable, DLRErrCarrierFailed, done)
+	p.Header.Sequence = 8
+	got := roundTripDeliverSM(t, p)
+
+	body, err := decodeUnpackedGSM7(got.Message.Message)
+	require.NoError(t, err)
+	assert.Contains(t, body, "dlvrd:000")
+	assert.Contains(t, body, "done date:2403091407")
+	assert.Contains(t, body, "stat:UNDELIV")
+	assert.Contains(t, body, "err:001")
+
+	assert.Equal(t, []byte{5}, got.Tags[tlvMessageState])
+}
+
+func TestSendDeliveryReceipt_NoopWithoutRequest(t *testing.T) {
+	// Must not panic for nil servers or messages without a receipt request.
+	var nilServer *SMPPServer
+	nilServer.sendDeliveryReceipt(&MsgQueueItem{LogID: "m1"}, DLRStatDelivered, DLRErrNone)
+
+	_, gw := newTestRouter(1)
+	srv := &SMPPServer{gateway: gw}
+	srv.sendDeliveryReceipt(&MsgQueueItem{LogID: "m2"}, DLRStatDelivered, DLRErrNone)
+}
+
+func TestClient_ReceiptMode(t *testing.T) {
+	type outcome struct{ delivered, undeliverable bool }
+	for _, tc := range []struct {
+		policy    string
+		requested byte
+		want      outcome
+	}{
+		{DeliveryReceiptsRequested, 0, outcome{false, false}},
+		{DeliveryReceiptsRequested, 1, outcome{true, true}},
+		{DeliveryReceiptsRequested, 2, outcome{false, true}},
+		{DeliveryReceiptsOff, 1, outcome{false, false}},
+		{DeliveryReceiptsFailuresOnly, 0, outcome{false, true}},
+		{DeliveryReceiptsFailuresOnly, 1, outcome{false, true}},
+		{DeliveryReceiptsAll, 0, outcome{true, true}},
+		{DeliveryReceiptsAll, 3, outcome{true, true}},
+	} {
+		client := &Client{Username: "pbx1", DeliveryReceipts: tc.policy}
+		req := testReceiptRequest(client.receiptMode(tc.requested))
+		got := outcome{req.wants(DLRStatDelivered), req.wants(DLRStatUndeliverable)}
+		assert.Equal(t, tc.want, got, "policy %q, registered_delivery %d", tc.policy, tc.requested)
+	}
+
+	var noClient *Client
+	assert.Equal(t, byte(1), noClient.receiptMode(1), "no client honours the submit")
+
+	webhook := &Client{WebhookURL: "https://pbx.example.com/hook", DeliveryReceipts: DeliveryReceiptsAll, WebhookDeliveryReceipts: DeliveryReceiptsOff}
+	assert.Equal(t, byte(0), webhook.receiptMode(1), "webhook clients use webhook_delivery_receipts")
+	assert.False(t, validDeliveryReceipts("failures"))
+	assert.True(t, validDeliveryReceipts(DeliveryReceiptsFailuresOnly))
+	assert.Equal(t, DeliveryReceiptsFailuresOnly, normalizeDeliveryReceipts("failures-only"))
+	assert.Equal(t, byte(2), (&Client{DeliveryReceipts: "failures-only"}).receiptMode(1))
+}
+
+func TestSendDeliveryReceipt_Webhook(t *testing.T) {
+	receipts := make(chan clientReceiptPayload, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload clientReceiptPayload
+		if json.NewDecoder(r.Body).Decode(&payload) == nil {
+			receipts <- payload
+		}
+	}))
+	defer srv.Close()
+
+	_, gw := newTestRouter(1)
+	gw.Config.WebhookTimeoutSecs = 5
+	client := &Client{Username: "pbx1", WebhookURL: srv.URL, WebhookDeliveryReceipts: DeliveryReceiptsFailuresOnly}
+	gw.Clients = map[string]*Client{"pbx1": client}
+	server := &SMPPServer{gateway: gw}
+
+	msg := &MsgQueueItem{LogID: "r1", DeliveryReceipt: testReceiptRequest(client.receiptMode(1))}
+	server.sendDeliveryReceipt(msg, DLRStatDelivered, DLRErrNone)
+	server.sendDeliveryReceipt(msg, DLRStatUndeliverable, DLRErrCarrierFailed)
+
+	select {
+	case got := <-receipts:
+		assert.Equal(t, "delivery_receipt", got.Type)
+		assert.Equal(t, DLRStatUndeliverable, got.Stat)
+		assert.Equal(t, DLRErrCarrierFailed, got.Err)
+		assert.Equal(t, "65a1b2c3d4e5f60718293a4b", got.MessageID)
+		assert.Equal(t, "15551234567", got.From)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no receipt posted")
+	}
+	select {
+	case got := <-receipts:
+		t.Fatalf("unexpected receipt %+v", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSendDeliveryReceipt_WebhookRetry(t *testing.T) {
+	withRetryPolicy(t, RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond, MaxRetriesSMS: 2})
+
+	var attempts atomic.Int32
+	delivered := make(chan clientReceiptPayload, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var payload clientReceiptPayload
+		if json.NewDecoder(r.Body).Decode(&payload) == nil {
+			delivered <- payload
+		}
+	}))
+	defer srv.Close()
+
+	_, gw := newTestRouter(1)
+	gw.Config.WebhookTimeoutSecs = 5
+	client := &Client{Username: "pbx1", WebhookURL: srv.URL, WebhookDeliveryReceipts: DeliveryReceiptsAll}
+	gw.Clients = map[string]*Client{"pbx1": client}
+	server := &SMPPServer{gateway: gw}
+
+	msg := &MsgQueueItem{LogID: "r1", DeliveryReceipt: testReceiptRequest(client.receiptMode(0))}
+	server.sendDeliveryReceipt(msg, DLRStatDelivered, DLRErrNone)
+
+	select {
+	case got := <-delivered:
+		assert.Equal(t, DLRStatDelivered, got.Stat)
+		assert.Equal(t, "r1", got.LogID)
+	case <-time.After(2 * time.Second):
+		t.Fatal("receipt not retried")
+	}
+	assert.Equal(t, int32(2), attempts.Load())
+	assert.Nil(t, msg.Delivery, "the message's own retry state is untouched")
+}
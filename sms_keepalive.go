@@ -0,0 +1,51 @@
+package main
+
+import "time"
+
+// smppKeepalive holds the effective enquire_link and response timings for an
+// SMPP session.
+type smppKeepalive struct {
+	EnquireInterval time.Duration
+	EnquireTimeout  time.Duration
+	ResponseTimeout time.Duration
+}
+
+// smppKeepalive resolves the keepalive timings for a client: its own settings
+// where set, otherwise the gateway defaults. client may be nil for sessions
+// that have not bound yet.
+func (gateway *Gateway) smppKeepalive(client *Client) smppKeepalive {
+	interval := gateway.Config.SMPPEnquireIntervalSecs
+	timeout := gateway.Config.SMPPEnquireTimeoutSecs
+	if timeout <= 0 {
+		timeout = gateway.Config.SMPPTimeoutSecs
+	}
+	response := gateway.Config.SMPPResponseTimeoutSecs
+
+	if client != nil && client.Settings != nil {
+		if client.Settings.EnquireIntervalSecs > 0 {
+			interval = client.Settings.EnquireIntervalSecs
+		}
+		if client.Settings.EnquireTimeoutSecs > 0 {
+			timeout = client.Settings.EnquireTimeoutSecs
+		}
+		if client.Settings.ResponseTimeoutSecs > 0 {
+			response = client.Settings.ResponseTimeoutSecs
+		}
+	}
+
+	if interval <= 0 {
+		interval = 15
+	}
+	if timeout <= 0 {
+		timeout = 30
+	}
+	if response <= 0 {
+		response = 5
+	}
+
+	return smppKeepalive{
+		EnquireInterval: time.Duration(interval) * time.Second,
+		EnquireTimeout:  time.Duration(timeout) * time.Second,
+		ResponseTimeout: time.Duration(response) * time.Second,
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_SMPPKeepalive(t *testing.T) {
+	_, gw := newTestRouter(1)
+	gw.Config.SMPPTimeoutSecs = 30
+	gw.Config.SMPPEnquireIntervalSecs = 15
+	gw.Config.SMPPResponseTimeoutSecs = 5
+
+	ka := gw.smppKeepalive(nil)
+	assert.Equal(t, 15*time.Second, ka.EnquireInterval)
+	assert.Equal(t, 30*time.Second, ka.EnquireTimeout, "falls back to SMPP_TIMEOUT_SECS")
+	assert.Equal(t, 5*time.Second, ka.ResponseTimeout)
+
+	gw.Config.SMPPEnquireTimeoutSecs = 10
+	assert.Equal(t, 10*time.Second, gw.smppKeepalive(&Client{}).EnquireTimeout)
+
+	ka = gw.smppKeepalive(&Client{Settings: &ClientSettings{
+		EnquireIntervalSecs: 5,
+		ResponseTimeoutSecs: 20,
+	}})
+	assert.Equal(t, 5*time.Second, ka.EnquireInterval)
+	assert.Equal(t, 10*time.Second, ka.EnquireTimeout, "unset override uses gateway value")
+	assert.Equal(t, 20*time.Second, ka.ResponseTimeout)
+}
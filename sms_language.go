@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"zultys-smpp-mm4/smpp/coding"
+	"zultys-smpp-mm4/smpp/coding/gsm7bit"
+	"zultys-smpp-mm4/smpp/pdu"
+)
+
+// Values of the sms_language client setting, naming the GSM7 national
+// language tables used for SMS delivered to the client over SMPP.
+const (
+	SMSLanguageTurkish    = "turkish"
+	SMSLanguageSpanish    = "spanish"
+	SMSLanguagePortuguese = "portuguese"
+)
+
+var smsLanguages = map[string]gsm7bit.Language{
+	SMSLanguageTurkish:    gsm7bit.Turkish,
+	SMSLanguageSpanish:    gsm7bit.Spanish,
+	SMSLanguagePortuguese: gsm7bit.Portuguese,
+}
+
+// validSMSLanguage reports whether language is an SMS language, or empty.
+func validSMSLanguage(language string) bool {
+	_, ok := smsLanguages[language]
+	return ok || language == ""
+}
+
+// smsLanguage returns the national language tables client's SMS may use,
+// or gsm7bit.Default for the default alphabet only.
+func smsLanguage(client *Client) gsm7bit.Language {
+	if client != nil && client.Settings != nil {
+		return smsLanguages[client.Settings.SMSLanguage]
+	}
+	return gsm7bit.Default
+}
+
+// applySMSCodingLang is applySMSCoding for a client with national language
+// tables: text those tables can encode is sent as GSM7 rather than UCS2, and
+// is kept rather than transliterated.
+func applySMSCodingLang(policy, message string, extra map[rune]string, lang gsm7bit.Language) (string, coding.DataCoding) {
+	if lang == gsm7bit.Default || policy == SMSCodingUCS2 {
+		return applySMSCoding(policy, message, extra)
+	}
+	if _, ok := gsm7bit.TablesFor(lang, message); ok {
+		return message, coding.GSM7BitCoding
+	}
+	if policy != SMSCodingGSM7 {
+		return applySMSCoding(policy, message, extra)
+	}
+
+	// Transliterate only what the language's single shift table, which
+	// always goes with the default alphabet, cannot encode.
+	shifted := gsm7bit.Tables{Single: lang}
+	var output []rune
+	for _, r := range message {
+		if shifted.Septets(r) > 0 {
+			output = append(output, r)
+			continue
+		}
+		output = append(output, []rune(transliterateGSM7(string(r), extra))...)
+	}
+	return string(output), coding.GSM7BitCoding
+}
+
+// composeDeliverSMsLang is composeDeliverSMsAs for a client with national
+// language tables. GSM7 text outside the default alphabet is encoded with the
+// cheapest of lang's tables.
+func composeDeliverSMsLang(from, to, message string, bestCoding coding.DataCoding, lang gsm7bit.Language, ref func() uint16) ([]outboundSegment, coding.DataCoding, error) {
+	if bestCoding == coding.GSM7BitCoding && lang != gsm7bit.Default && !isGSM7String(message) {
+		if tables, ok := gsm7bit.TablesFor(lang, message); ok && tables != (gsm7bit.Tables{}) {
+			parts, err := composeNationalDeliverSMs(from, to, message, tables, ref)
+			return parts, bestCoding, err
+		}
+	}
+	return composeDeliverSMsAs(from, to, message, bestCoding, ref)
+}
+
+// gsm7UDHSeptets returns the septets left for text after a UDH of udhLen
+// octets, which is padded to a septet boundary.
+func gsm7UDHSeptets(udhLen int) int {
+	return 160 - (udhLen*8+6)/7
+}
+
+// composeNationalDeliverSMs encodes GSM7 message with national language
+// tables. Every part carries the shift elements in its UDH, alongside the
+// concatenation header when there is more than one.
+func composeNationalDeliverSMs(from, to, message string, tables gsm7bit.Tables, ref func() uint16) ([]outboundSegment, error) {
+	elements := tables.Elements()
+	udhLen := 1 + 3*len(elements)
+
+	segments := []string{message}
+	if n, _ := tables.Len(message); n > gsm7UDHSeptets(udhLen) {
+		segments = tables.Split(message, gsm7UDHSeptets(udhLen+smsConcatUDHLen-1))
+	}
+	if len(segments) > 0xFF {
+		return nil, pdu.ErrMultipartTooMuch
+	}
+
+	var header *pdu.ConcatenatedHeader
+	if len(segments) > 1 {
+		header = &pdu.ConcatenatedHeader{
+			Reference:  ref() & 0xFF,
+			TotalParts: byte(len(segments)),
+		}
+	}
+
+	parts := make([]outboundSegment, 0, len(segments))
+	for i, segment := range segments {
+		encoded, err := tables.Encode(segment)
+		if err != nil {
+			return nil, fmt.Errorf("GSM7 encode error in segment %d: %w", i, err)
+		}
+		udh := make(pdu.UserDataHeader)
+		for id, value := range elements {
+			udh[id] = value
+		}
+		if header != nil {
+			header.Sequence = byte(i + 1)
+			header.Set(udh)
+		}
+		parts = append(parts, outboundSegment{text: segment, pdu: &pdu.DeliverSM{
+			SourceAddr: smppAddress(from),
+			DestAddr:   pdu.Address{TON: 0x01, NPI: 0x01, No: to},
+			ESMClass:   pdu.ESMClass{UDHIndicator: true},
+			Message:    pdu.ShortMessage{Message: encoded, DataCoding: coding.GSM7BitCoding, UDHeader: udh},
+			RegisteredDelivery: pdu.RegisteredDelivery{
+				MCDeliveryReceipt: 1,
+			},
+		}})
+	}
+	return parts, nil
+}
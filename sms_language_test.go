@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"zultys-smpp-mm4/smpp/coding"
+	"zultys-smpp-mm4/smpp/coding/gsm7bit"
+	"zultys-smpp-mm4/smpp/pdu"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	turkishSample = "Şişli'de buluşalım, ağabey İsmail"
+	spanishSample = "¿Cómo estás? Él llegó ayer."
+)
+
+func TestApplySMSCodingLang(t *testing.T) {
+	text, dc := applySMSCodingLang(SMSCodingAuto, turkishSample, nil, gsm7bit.Turkish)
+	assert.Equal(t, turkishSample, text)
+	assert.Equal(t, coding.GSM7BitCoding, dc)
+
+	_, dc = applySMSCodingLang(SMSCodingAuto, turkishSample, nil, gsm7bit.Default)
+	assert.Equal(t, coding.UCS2Coding, dc, "without a language the text needs UCS2")
+
+	_, dc = applySMSCodingLang(SMSCodingAuto, turkishSample, nil, gsm7bit.Spanish)
+	assert.Equal(t, coding.UCS2Coding, dc, "Spanish tables cannot encode it")
+
+	text, dc = applySMSCodingLang(SMSCodingGSM7, spanishSample+" łódź", nil, gsm7bit.Spanish)
+	assert.Equal(t, coding.GSM7BitCoding, dc)
+	assert.Equal(t, spanishSample+" lódz", text, "only characters outside the Spanish tables are transliterated")
+
+	_, dc = applySMSCodingLang(SMSCodingUCS2, spanishSample, nil, gsm7bit.Spanish)
+	assert.Equal(t, coding.UCS2Coding, dc)
+}
+
+func TestSMSLanguageSetting(t *testing.T) {
+	assert.Equal(t, gsm7bit.Default, smsLanguage(nil))
+	assert.Equal(t, gsm7bit.Turkish, smsLanguage(&Client{Settings: &ClientSettings{SMSLanguage: SMSLanguageTurkish}}))
+	assert.True(t, validSMSLanguage(""))
+	assert.True(t, validSMSLanguage(SMSLanguagePortuguese))
+	assert.False(t, validSMSLanguage("greek"))
+}
+
+// decodeNational decodes a deliver_sm composed with national language tables.
+func decodeNational(t *testing.T, deliverSM *pdu.DeliverSM) string {
+	t.Helper()
+	tables := gsm7bit.TablesFromElements(deliverSM.Message.UDHeader)
+	text, err := tables.Decode(deliverSM.Message.Message)
+	require.NoError(t, err)
+	return text
+}
+
+func TestComposeDeliverSMsLang_Turkish(t *testing.T) {
+	parts, dc, err := composeDeliverSMsLang("15551234567", "905321234567", turkishSample, coding.GSM7BitCoding, gsm7bit.Turkish, func() uint16 { return 1 })
+	require.NoError(t, err)
+	assert.Equal(t, coding.GSM7BitCoding, dc)
+	require.Len(t, parts, 1)
+
+	deliverSM := parts[0].pdu
+	assert.True(t, deliverSM.ESMClass.UDHIndicator)
+	assert.Equal(t, pdu.UserDataHeader{gsm7bit.LockingShiftIEI: {byte(gsm7bit.Turkish)}}, deliverSM.Message.UDHeader)
+	assert.Len(t, deliverSM.Message.Message, len([]rune(turkishSample)), "one septet per character with the locking shift")
+	assert.Equal(t, turkishSample, decodeNational(t, deliverSM))
+}
+
+func TestComposeDeliverSMsLang_Spanish(t *testing.T) {
+	parts, _, err := composeDeliverSMsLang("15551234567", "34612345678", spanishSample, coding.GSM7BitCoding, gsm7bit.Spanish, func() uint16 { return 1 })
+	require.NoError(t, err)
+	require.Len(t, parts, 1)
+
+	deliverSM := parts[0].pdu
+	assert.Equal(t, pdu.UserDataHeader{gsm7bit.SingleShiftIEI: {byte(gsm7bit.Spanish)}}, deliverSM.Message.UDHeader)
+	assert.Contains(t, string(deliverSM.Message.Message), "\x1B\x6F", "ó is escaped into the single shift table")
+	assert.Equal(t, spanishSample, decodeNational(t, deliverSM))
+}
+
+func TestComposeDeliverSMsLang_Multipart(t *testing.T) {
+	message := strings.Repeat(turkishSample+" ", 8)
+	parts, _, err := composeDeliverSMsLang("15551234567", "905321234567", message, coding.GSM7BitCoding, gsm7bit.Turkish, func() uint16 { return 9 })
+	require.NoError(t, err)
+	require.Greater(t, len(parts), 1)
+
+	var rebuilt strings.Builder
+	for i, part := range parts {
+		udh := part.pdu.Message.UDHeader
+		assert.Equal(t, []byte{byte(gsm7bit.Turkish)}, udh[gsm7bit.LockingShiftIEI])
+		concat := udh.ConcatenatedHeader()
+		require.NotNil(t, concat)
+		assert.Equal(t, byte(i+1), concat.Sequence)
+		assert.Equal(t, byte(len(parts)), concat.TotalParts)
+		assert.LessOrEqual(t, len(part.pdu.Message.Message), gsm7UDHSeptets(udh.Len()))
+		rebuilt.WriteString(decodeNational(t, part.pdu))
+	}
+	assert.Equal(t, message, rebuilt.String())
+}
+
+func TestComposeDeliverSMsLang_DefaultAlphabetUnchanged(t *testing.T) {
+	parts, _, err := composeDeliverSMsLang("15551234567", "34612345678", "plain {ok} é", coding.GSM7BitCoding, gsm7bit.Spanish, func() uint16 { return 1 })
+	require.NoError(t, err)
+	require.Len(t, parts, 1)
+	assert.Nil(t, parts[0].pdu.Message.UDHeader, "no shift elements when the default alphabet will do")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"zultys-smpp-mm4/smpp/pdu"
+)
+
+// Values of the smpp_address_format client setting, the type of number used
+// for the addresses of messages delivered to the client over SMPP.
+const (
+	// SMPPAddressInternational sends every number in international format
+	// with TON 0x01, as it is routed.
+	SMPPAddressInternational = "international"
+	// SMPPAddressNational sends numbers in the client's country without the
+	// country code, with TON 0x02.
+	SMPPAddressNational = "national"
+	// SMPPAddressUnknown sends numbers in the client's country without the
+	// country code, with TON 0x00.
+	SMPPAddressUnknown = "unknown"
+)
+
+// SMPP address types of a phone number.
+const (
+	smppTONUnknown       = 0x00
+	smppTONInternational = 0x01
+	smppTONNational      = 0x02
+	smppNPIISDN          = 0x01
+)
+
+// defaultSMPPCountryCode is the country whose numbers are sent in national
+// format when a client sets none.
+const defaultSMPPCountryCode = "1"
+
+var countryCodeRegex = regexp.MustCompile(`^[1-9]\d{0,2}$`)
+
+// validSMPPAddressFormat reports whether format is an SMPP address format,
+// or empty.
+func validSMPPAddressFormat(format string) bool {
+	switch format {
+	case "", SMPPAddressInternational, SMPPAddressNational, SMPPAddressUnknown:
+		return true
+	}
+	return false
+}
+
+// validCountryCode reports whether cc is an E.164 country code, or empty.
+func validCountryCode(cc string) bool {
+	return cc == "" || countryCodeRegex.MatchString(cc)
+}
+
+// smppNumbering is how a client wants the numbers of outbound PDUs written.
+type smppNumbering struct {
+	Format      string
+	CountryCode string // stripped from national numbers
+}
+
+// smppNumberingFor returns client's numbering, international by default.
+func smppNumberingFor(client *Client) smppNumbering {
+	n := smppNumbering{Format: SMPPAddressInternational, CountryCode: defaultSMPPCountryCode}
+	if client == nil || client.Settings == nil {
+		return n
+	}
+	if client.Settings.SMPPAddressFormat != "" {
+		n.Format = client.Settings.SMPPAddressFormat
+	}
+	if client.Settings.SMPPCountryCode != "" {
+		n.CountryCode = client.Settings.SMPPCountryCode
+	}
+	return n
+}
+
+// address rewrites a phone number address in n's format. Numbers outside
+// the client's country, and alphanumeric senders, are left as they are.
+func (n smppNumbering) address(addr pdu.Address) pdu.Address {
+	if n.Format == SMPPAddressInternational || addr.TON != smppTONInternational {
+		return addr
+	}
+	digits := strings.TrimPrefix(addr.No, "+")
+	national := strings.TrimPrefix(digits, n.CountryCode)
+	if len(national) == len(digits) || national == "" {
+		return addr
+	}
+
+	var ton byte = smppTONNational
+	if n.Format == SMPPAddressUnknown {
+		ton = smppTONUnknown
+	}
+	return pdu.Address{TON: ton, NPI: smppNPIISDN, No: national}
+}
+
+// applySMPPNumbering rewrites the source and destination of an outbound
+// deliver_sm in n's format.
+func applySMPPNumbering(deliverSM *pdu.DeliverSM, n smppNumbering) {
+	deliverSM.SourceAddr = n.address(deliverSM.SourceAddr)
+	deliverSM.DestAddr = n.address(deliverSM.DestAddr)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"zultys-smpp-mm4/smpp/pdu"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSMPPNumbering_International(t *testing.T) {
+	n := smppNumberingFor(nil)
+	assert.Equal(t, smppNumbering{Format: SMPPAddressInternational, CountryCode: "1"}, n)
+
+	addr := smppAddress("14155559876")
+	assert.Equal(t, pdu.Address{TON: 0x01, NPI: 0x01, No: "14155559876"}, n.address(addr), "international is sent as routed")
+}
+
+func TestSMPPNumbering_National(t *testing.T) {
+	n := smppNumberingFor(&Client{Settings: &ClientSettings{SMPPAddressFormat: SMPPAddressNational}})
+
+	assert.Equal(t, pdu.Address{TON: 0x02, NPI: 0x01, No: "4155559876"}, n.address(smppAddress("14155559876")))
+	assert.Equal(t, pdu.Address{TON: 0x02, NPI: 0x01, No: "4155559876"}, n.address(smppAddress("+14155559876")))
+	assert.Equal(t, smppAddress("447700900123"), n.address(smppAddress("447700900123")), "foreign numbers stay international")
+	assert.Equal(t, smppAddress("MYBRAND"), n.address(smppAddress("MYBRAND")), "alphanumeric senders are untouched")
+}
+
+func TestSMPPNumbering_UnknownWithCountryCode(t *testing.T) {
+	n := smppNumberingFor(&Client{Settings: &ClientSettings{SMPPAddressFormat: SMPPAddressUnknown, SMPPCountryCode: "44"}})
+
+	assert.Equal(t, pdu.Address{TON: 0x00, NPI: 0x01, No: "7700900123"}, n.address(smppAddress("447700900123")))
+	assert.Equal(t, smppAddress("14155559876"), n.address(smppAddress("14155559876")))
+}
+
+func TestSMPPNumberingSettings(t *testing.T) {
+	assert.True(t, validSMPPAddressFormat(""))
+	assert.True(t, validSMPPAddressFormat(SMPPAddressNational))
+	assert.False(t, validSMPPAddressFormat("subscriber"))
+	assert.True(t, validCountryCode(""))
+	assert.True(t, validCountryCode("353"))
+	assert.False(t, validCountryCode("0"))
+	assert.False(t, validCountryCode("1234"))
+}
+
+func TestSendSMPP_NationalNumbering(t *testing.T) {
+	peer := newSMPPPeer(t, 1, 0, nil)
+	peer.srv.gateway.Clients["pbx1"].Settings = &ClientSettings{SMPPAddressFormat: SMPPAddressNational}
+
+	item := MsgQueueItem{From: "14155559876", To: "12505551234", message: "hello", LogID: "log1"}
+	_, err := peer.srv.sendSMPP(item, peer.session)
+	require.NoError(t, err)
+
+	peer.mu.Lock()
+	delivered := peer.delivered
+	peer.mu.Unlock()
+	require.Len(t, delivered, 1)
+	assert.Equal(t, pdu.Address{TON: 0x02, NPI: 0x01, No: "4155559876"}, delivered[0].SourceAddr)
+	assert.Equal(t, pdu.Address{TON: 0x02, NPI: 0x01, No: "2505551234"}, delivered[0].DestAddr)
+}
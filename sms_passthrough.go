@@ -0,0 +1,93 @@
+package main
+
+import (
+	"zultys-smpp-mm4/smpp/coding"
+	"zultys-smpp-mm4/smpp/pdu"
+)
+
+// isBinaryDataCoding reports whether dc is an 8-bit data coding whose
+// payload is not text. Messages in these codings (WAP push, OTA settings,
+// ...) are passed through unchanged.
+func isBinaryDataCoding(dc coding.DataCoding) bool {
+	return dc == 0x02 || dc == 0x04
+}
+
+// passthroughUDH returns the UDH elements of h other than concatenation,
+// which the gateway strips on reassembly and adds back when segmenting.
+func passthroughUDH(h pdu.UserDataHeader) pdu.UserDataHeader {
+	var out pdu.UserDataHeader
+	for id, data := range h {
+		if id == 0x00 || id == 0x08 {
+			continue
+		}
+		if out == nil {
+			out = make(pdu.UserDataHeader)
+		}
+		out[id] = data
+	}
+	return out
+}
+
+// setSMPPPassthrough copies the fields of submitSM that are delivered as-is
+// onto item: service_type, the reply-path bit and, for binary payloads, the
+// raw bytes with their UDH (UDHI is implied by a non-empty UDH).
+func setSMPPPassthrough(item *MsgQueueItem, submitSM *pdu.SubmitSM) {
+	item.ServiceType = submitSM.ServiceType
+	item.ESMClass = pdu.ESMClass{ReplyPath: submitSM.ESMClass.ReplyPath}
+	if !isBinaryDataCoding(submitSM.Message.DataCoding) {
+		return
+	}
+	item.binary = submitSM.Message.Message
+	item.binaryCoding = submitSM.Message.DataCoding
+	item.UDH = passthroughUDH(submitSM.Message.UDHeader)
+	item.ESMClass.UDHIndicator = item.UDH != nil
+}
+
+// applySMPPPassthrough sets the passed-through fields of msg on an outbound
+// deliver_sm. UDHI is left to the composer, which knows the final UDH.
+func applySMPPPassthrough(deliverSM *pdu.DeliverSM, msg MsgQueueItem) {
+	deliverSM.ServiceType = msg.ServiceType
+	deliverSM.ESMClass.ReplyPath = msg.ESMClass.ReplyPath
+}
+
+// composeBinaryDeliverSM builds the single deliver_sm for a binary payload.
+// Binary messages are never re-segmented, so payload and UDH must fit one
+// short message.
+func composeBinaryDeliverSM(from, to string, msg MsgQueueItem) (outboundSegment, error) {
+	if len(msg.binary)+msg.UDH.Len() > smsSingleLimitBytes {
+		return outboundSegment{}, pdu.ErrShortMessageTooLarge
+	}
+	deliverSM := &pdu.DeliverSM{
+		SourceAddr: smppAddress(from),
+		DestAddr:   pdu.Address{TON: 0x01, NPI: 0x01, No: to},
+		ESMClass:   pdu.ESMClass{UDHIndicator: msg.UDH != nil},
+		Message:    pdu.ShortMessage{Message: msg.binary, DataCoding: msg.binaryCoding, UDHeader: msg.UDH},
+		RegisteredDelivery: pdu.RegisteredDelivery{
+			MCDeliveryReceipt: 1,
+		},
+	}
+	return outboundSegment{pdu: deliverSM}, nil
+}
+
+// binarySMSCarrier is implemented by carrier handlers whose API can send an
+// 8-bit binary SMS with a UDH.
+type binarySMSCarrier interface {
+	SendBinarySMS(sms *MsgQueueItem) (string, error)
+}
+
+// carrierSupportsBinarySMS reports whether handler can send binary SMS.
+func carrierSupportsBinarySMS(handler CarrierHandler) bool {
+	_, ok := handler.(binarySMSCarrier)
+	return ok
+}
+
+// sendCarrierSMS sends sms through handler, as binary when it carries a
+// binary payload. Callers check carrierSupportsBinarySMS first.
+func sendCarrierSMS(handler CarrierHandler, sms *MsgQueueItem) (string, error) {
+	if sms.binary != nil {
+		if c, ok := handler.(binarySMSCarrier); ok {
+			return c.SendBinarySMS(sms)
+		}
+	}
+	return handler.SendSMS(sms)
+}
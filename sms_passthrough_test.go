@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"zultys-smpp-mm4/smpp/coding"
+	"zultys-smpp-mm4/smpp/pdu"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// wapPushUDH addresses the WAP push port (2948) from the WAP connectionless
+// port (9200).
+var wapPushUDH = pdu.UserDataHeader{0x05: {0x0B, 0x84, 0x23, 0xF0}}
+
+// submitAndDeliver feeds submitSM through handleSubmitSM and sends the
+// resulting queue item back out to the same peer as a deliver_sm.
+func submitAndDeliver(t *testing.T, submitSM *pdu.SubmitSM) (MsgQueueItem, *pdu.DeliverSM) {
+	t.Helper()
+	peer := newSMPPPeer(t, 1, 0, nil)
+	srv := peer.srv
+	srv.submitLimiter = newSubmitRateLimiter()
+	srv.gateway.SMPPServer = srv
+	srv.gateway.ConvoManager = NewConvoManager()
+
+	// Go through the wire format so the UDH is parsed like a real submit.
+	submitSM.Header.Sequence = 7
+	var wire bytes.Buffer
+	_, err := pdu.Marshal(&wire, submitSM)
+	require.NoError(t, err)
+	parsed, err := pdu.Unmarshal(&wire)
+	require.NoError(t, err)
+
+	NewSimpleHandler(srv).handleSubmitSM(peer.session, parsed.(*pdu.SubmitSM))
+
+	var item MsgQueueItem
+	select {
+	case item = <-srv.gateway.Router.ClientMsgChan:
+	case <-time.After(time.Second):
+		t.Fatal("submit_sm was not queued")
+	}
+	_, err = srv.sendSMPP(item, peer.session)
+	require.NoError(t, err)
+
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	require.Len(t, peer.delivered, 1)
+	return item, peer.delivered[0]
+}
+
+func TestSMPPPassthrough_BinaryRoundTrip(t *testing.T) {
+	payload := []byte{0x01, 0x06, 0x04, 0x03, 0xAE, 0x81, 0xEA, 0x02, 0x05, 0x6A, 0x00}
+	submitSM := &pdu.SubmitSM{
+		ServiceType: "WAP",
+		SourceAddr:  pdu.Address{TON: 1, NPI: 1, No: "15551234567"},
+		DestAddr:    pdu.Address{TON: 1, NPI: 1, No: "15557654321"},
+		ESMClass:    pdu.ESMClass{UDHIndicator: true, ReplyPath: true},
+		Message:     pdu.ShortMessage{DataCoding: coding.DataCoding(0x04), UDHeader: wapPushUDH, Message: payload},
+	}
+
+	item, deliverSM := submitAndDeliver(t, submitSM)
+	assert.Equal(t, "WAP", item.ServiceType)
+	assert.Equal(t, payload, item.binary)
+	assert.Equal(t, wapPushUDH, item.UDH)
+
+	assert.Equal(t, "WAP", deliverSM.ServiceType)
+	assert.True(t, deliverSM.ESMClass.UDHIndicator)
+	assert.True(t, deliverSM.ESMClass.ReplyPath)
+	assert.Equal(t, coding.DataCoding(0x04), deliverSM.Message.DataCoding)
+	assert.Equal(t, wapPushUDH, deliverSM.Message.UDHeader)
+	assert.Equal(t, payload, deliverSM.Message.Message)
+}
+
+func TestSMPPPassthrough_TextKeepsServiceType(t *testing.T) {
+	submitSM := &pdu.SubmitSM{
+		ServiceType: "CMT",
+		SourceAddr:  pdu.Address{TON: 1, NPI: 1, No: "15551234567"},
+		DestAddr:    pdu.Address{TON: 1, NPI: 1, No: "15557654321"},
+		Message:     pdu.ShortMessage{Message: []byte("hello")},
+	}
+
+	item, deliverSM := submitAndDeliver(t, submitSM)
+	assert.Nil(t, item.binary)
+	assert.Equal(t, "CMT", deliverSM.ServiceType)
+	assert.False(t, deliverSM.ESMClass.UDHIndicator)
+	assert.False(t, deliverSM.ESMClass.ReplyPath)
+	assert.Equal(t, []byte("hello"), deliverSM.Message.Message)
+}
+
+func TestPassthroughUDH_DropsConcatenation(t *testing.T) {
+	assert.Nil(t, passthroughUDH(pdu.UserDataHeader{0x00: {1, 2, 1}}))
+	assert.Equal(t, wapPushUDH, passthroughUDH(pdu.UserDataHeader{0x00: {1, 2, 1}, 0x05: wapPushUDH[0x05]}))
+}
+
+func TestComposeBinaryDeliverSM_TooLarge(t *testing.T) {
+	_, err := composeBinaryDeliverSM("1", "2", MsgQueueItem{binary: make([]byte, 135), UDH: wapPushUDH})
+	assert.ErrorIs(t, err, pdu.ErrShortMessageTooLarge)
+}
+
+func TestSinchHandler_SendBinarySMS(t *testing.T) {
+	var gotBatch map[string]interface{}
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&gotBatch))
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"id":"batch-1"}`))
+	}))
+	defer api.Close()
+
+	_, gw := newTestRouter(1)
+	h := NewSinchHandler(gw, &Carrier{Name: "sinch"}, "plan-1", "token-1")
+	h.apiBase = api.URL
+	require.True(t, carrierSupportsBinarySMS(h))
+
+	id, err := sendCarrierSMS(h, &MsgQueueItem{From: "+15551230000", To: "+15559870000", binary: []byte{0xAE, 0x81}, UDH: wapPushUDH})
+	require.NoError(t, err)
+	assert.Equal(t, "batch-1", id)
+	assert.Equal(t, "mt_binary", gotBatch["type"])
+	assert.Equal(t, "roE=", gotBatch["body"])
+	assert.Equal(t, "0605040b8423f0", gotBatch["udh"])
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"sync"
+	"time"
+	"zultys-smpp-mm4/smpp"
+	"zultys-smpp-mm4/smpp/pdu"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Message states reported in query_sm_resp and receipt TLVs (SMPP v5 4.7.15).
+const (
+	msgStateScheduled     pdu.MessageState = 0
+	msgStateEnroute       pdu.MessageState = 1
+	msgStateDelivered     pdu.MessageState = 2
+	msgStateExpired       pdu.MessageState = 3
+	msgStateUndeliverable pdu.MessageState = 5
+	msgStateRejected      pdu.MessageState = 8
+)
+
+// submitStateTTL bounds how long a submitted message can be queried.
+const submitStateTTL = 24 * time.Hour
+
+// dlrMessageState maps a receipt stat to its message_state.
+func dlrMessageState(stat string) pdu.MessageState {
+	switch stat {
+	case DLRStatDelivered:
+		return msgStateDelivered
+	case DLRStatRejected:
+		return msgStateRejected
+	case DLRStatExpired:
+		return msgStateExpired
+	}
+	return msgStateUndeliverable
+}
+
+type submitState struct {
+	username  string
+	logID     string // for deliveries, the log ID of the message delivered
+	state     pdu.MessageState
+	errCode   byte
+	finalDate time.Time // set once the state is final
+	submitted time.Time
+
+	// For an ID linked to log IDs: how many have yet to finish, and the
+	// state they add up to so far, the first failure taking precedence.
+	waiting    int
+	outcome    pdu.MessageState
+	outcomeErr byte
+	hasOutcome bool
+}
+
+// submitStates tracks the state of messages accepted by submit_sm, keyed by
+// the message_id returned in submit_sm_resp, so ESMEs can poll them with
+// query_sm. The zero value is ready to use.
+type submitStates struct {
+	mu        sync.Mutex
+	states    map[string]*submitState
+	follows   map[string][]string // log ID -> message IDs linked to it
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func (s *submitStates) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+// submitted records messageID as accepted from username. An ID already
+// recorded is left untouched.
+func (s *submitStates) submitted(messageID, username string, state pdu.MessageState) {
+	if s == nil || messageID == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock()
+	if s.states == nil {
+		s.states = make(map[string]*submitState)
+	}
+	// Forget old messages, at most once per hour.
+	if now.Sub(s.lastSweep) >= time.Hour {
+		for id, st := range s.states {
+			if now.Sub(st.submitted) >= submitStateTTL {
+				delete(s.states, id)
+			}
+		}
+		for logID, ids := range s.follows {
+			live := false
+			for _, id := range ids {
+				if _, ok := s.states[id]; ok {
+					live = true
+					break
+				}
+			}
+			if !live {
+				delete(s.follows, logID)
+			}
+		}
+		s.lastSweep = now
+	}
+	if _, ok := s.states[messageID]; ok {
+		return
+	}
+	s.states[messageID] = &submitState{username: username, state: state, submitted: now}
+}
+
+// delivering records messageID as a message being delivered to username
+// for logID. Only username can query it.
+func (s *submitStates) delivering(messageID, username, logID string) {
+	s.submitted(messageID, username, msgStateEnroute)
+	if s == nil || messageID == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if st, ok := s.states[messageID]; ok {
+		st.logID = logID
+	}
+}
+
+// setOutboundMessageID carries messageID on an outbound deliver_sm in the
+// vendor-specific tlvGatewayMessageID.
+func setOutboundMessageID(deliverSM *pdu.DeliverSM, messageID string) {
+	if deliverSM.Tags == nil {
+		deliverSM.Tags = pdu.Tags{}
+	}
+	deliverSM.Tags[tlvGatewayMessageID] = append([]byte(messageID), 0)
+}
+
+// link makes messageID follow the message routed under logID: the later
+// segments of a multi-part submit_sm follow the first segment's ID, and a
+// submit_multi's ID follows each of its destinations. A linked ID is final
+// once every log ID it follows is, delivered only if they all were.
+func (s *submitStates) link(logID, messageID string) {
+	if s == nil || logID == "" || messageID == "" || logID == messageID {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range s.follows[logID] {
+		if id == messageID {
+			return
+		}
+	}
+	st, ok := s.states[messageID]
+	if !ok {
+		return
+	}
+	st.waiting++
+	if own, ok := s.states[logID]; ok && !own.finalDate.IsZero() {
+		s.settle(messageID, own.state, own.errCode)
+		return
+	}
+	if s.follows == nil {
+		s.follows = make(map[string][]string)
+	}
+	s.follows[logID] = append(s.follows[logID], messageID)
+}
+
+// unlink undoes link for a log ID that will not be routed after all.
+func (s *submitStates) unlink(logID, messageID string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := s.follows[logID]
+	for i, id := range ids {
+		if id != messageID {
+			continue
+		}
+		s.follows[logID] = append(ids[:i:i], ids[i+1:]...)
+		if st, ok := s.states[messageID]; ok && st.finalDate.IsZero() {
+			st.waiting--
+			if st.waiting <= 0 && st.hasOutcome {
+				st.state, st.errCode, st.finalDate = st.outcome, st.outcomeErr, s.clock()
+			}
+		}
+		return
+	}
+}
+
+// finish moves messageID, and the IDs linked to it, to the final state for a
+// receipt stat. Unknown IDs are ignored.
+func (s *submitStates) finish(messageID, stat string, errCode int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := dlrMessageState(stat)
+	if st, ok := s.states[messageID]; ok && st.finalDate.IsZero() && st.waiting == 0 {
+		st.state = state
+		st.errCode = byte(errCode)
+		st.finalDate = s.clock()
+	}
+	for _, id := range s.follows[messageID] {
+		s.settle(id, state, byte(errCode))
+	}
+	delete(s.follows, messageID)
+}
+
+// settle records that one of the log IDs messageID follows ended in state.
+// The caller holds s.mu.
+func (s *submitStates) settle(messageID string, state pdu.MessageState, errCode byte) {
+	st, ok := s.states[messageID]
+	if !ok || !st.finalDate.IsZero() {
+		return
+	}
+	st.waiting--
+	if !st.hasOutcome || (st.outcome == msgStateDelivered && state != msgStateDelivered) {
+		st.outcome, st.outcomeErr, st.hasOutcome = state, errCode, true
+	}
+	if st.waiting <= 0 {
+		st.state, st.errCode, st.finalDate = st.outcome, st.outcomeErr, s.clock()
+	}
+}
+
+// release moves a scheduled messageID, and the IDs linked to it, to enroute
+// once it is handed on.
+func (s *submitStates) release(messageID string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range append([]string{messageID}, s.follows[messageID]...) {
+		if st, ok := s.states[id]; ok && st.state == msgStateScheduled {
+			st.state = msgStateEnroute
+		}
+	}
+}
+
+// lookup returns the state of messageID if it was submitted by username.
+func (s *submitStates) lookup(messageID, username string) (submitState, bool) {
+	if s == nil {
+		return submitState{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.states[messageID]
+	if !ok || st.username != username || s.clock().Sub(st.submitted) >= submitStateTTL {
+		return submitState{}, false
+	}
+	return *st, true
+}
+
+// handleQuerySM answers a query_sm with the current state of a message this
+// session's client submitted. Unknown IDs get ESME_RINVMSGID.
+func (h *SimpleHandler) handleQuerySM(session *smpp.Session, querySM *pdu.QuerySM) {
+	lm := h.server.gateway.LogManager
+	username, _ := h.server.getSessionClientInfo(session)
+
+	resp := querySM.Resp().(*pdu.QuerySMResp)
+	resp.MessageID = querySM.MessageID
+
+	st, ok := h.server.submitStates.lookup(querySM.MessageID, username)
+	if username == "" || !ok {
+		resp.Header.CommandStatus = pdu.ESME_RINVMSGID
+	} else {
+		resp.MessageState = st.state
+		resp.ErrorCode = st.errCode
+		if !st.finalDate.IsZero() {
+			resp.FinalDate = pdu.Time{Time: st.finalDate.UTC()}.String()
+		}
+	}
+
+	lm.SendLog(lm.BuildLog(
+		"Server.SMPP.HandleQuerySM",
+		"QuerySM",
+		logrus.DebugLevel,
+		map[string]interface{}{
+			"ip":        session.Parent.RemoteAddr().String(),
+			"username":  username,
+			"messageID": querySM.MessageID,
+			"found":     ok,
+			"state":     resp.MessageState.String(),
+			"sequence":  querySM.Header.Sequence,
+		},
+	))
+
+	if err := session.Send(resp); err != nil {
+		lm.SendLog(lm.BuildLog(
+			"Server.SMPP.HandleQuerySM",
+			"SMPPPDUError",
+			logrus.ErrorLevel,
+			map[string]interface{}{
+				"ip":       session.Parent.RemoteAddr().String(),
+				"username": username,
+			}, err,
+		))
+	}
+}
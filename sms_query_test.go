@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+	"zultys-smpp-mm4/smpp"
+	"zultys-smpp-mm4/smpp/pdu"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readTestPDU reads one PDU written to the other end of a net.Pipe.
+func readTestPDU(t *testing.T, conn net.Conn) any {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	raw := make([]byte, 4)
+	_, err := io.ReadFull(conn, raw)
+	require.NoError(t, err)
+	body := make([]byte, binary.BigEndian.Uint32(raw)-4)
+	_, err = io.ReadFull(conn, body)
+	require.NoError(t, err)
+
+	packet, err := pdu.Unmarshal(bytes.NewReader(append(raw, body...)))
+	require.NoError(t, err)
+	return packet
+}
+
+func TestSubmitStates_Lifecycle(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	s := &submitStates{now: func() time.Time { return now }}
+
+	s.submitted("m1", "pbx1", msgStateScheduled)
+	s.submitted("m1", "pbx1", msgStateEnroute) // later segment, ignored
+	st, ok := s.lookup("m1", "pbx1")
+	require.True(t, ok)
+	assert.Equal(t, msgStateScheduled, st.state)
+
+	s.release("m1")
+	st, _ = s.lookup("m1", "pbx1")
+	assert.Equal(t, msgStateEnroute, st.state)
+
+	s.finish("m1", DLRStatUndeliverable, DLRErrCarrierFailed)
+	s.finish("m1", DLRStatDelivered, DLRErrNone) // already final
+	st, _ = s.lookup("m1", "pbx1")
+	assert.Equal(t, msgStateUndeliverable, st.state)
+	assert.Equal(t, byte(DLRErrCarrierFailed), st.errCode)
+	assert.Equal(t, now, st.finalDate)
+
+	_, ok = s.lookup("m1", "pbx2")
+	assert.False(t, ok, "other clients cannot query the message")
+
+	now = now.Add(submitStateTTL)
+	_, ok = s.lookup("m1", "pbx1")
+	assert.False(t, ok, "expired")
+}
+
+func TestSubmitStates_Linked(t *testing.T) {
+	s := &submitStates{}
+
+	// The later segments of a multi-part message follow the first's ID.
+	s.submitted("seg1", "pbx1", msgStateEnroute)
+	s.submitted("seg2", "pbx1", msgStateEnroute)
+	s.link("seg1", "seg2")
+	s.finish("seg1", DLRStatDelivered, DLRErrNone)
+	st, _ := s.lookup("seg2", "pbx1")
+	assert.Equal(t, msgStateDelivered, st.state)
+
+	// Linked after the message already finished.
+	s.submitted("seg3", "pbx1", msgStateEnroute)
+	s.link("seg1", "seg3")
+	st, _ = s.lookup("seg3", "pbx1")
+	assert.Equal(t, msgStateDelivered, st.state)
+
+	// A submit_multi's ID waits for every destination; a failure wins.
+	s.submitted("multi", "pbx1", msgStateEnroute)
+	for _, logID := range []string{"d1", "d2", "d3"} {
+		s.link(logID, "multi")
+	}
+	s.unlink("d3", "multi") // never routed
+	s.finish("d1", DLRStatUndeliverable, DLRErrCarrierFailed)
+	st, _ = s.lookup("multi", "pbx1")
+	assert.Equal(t, msgStateEnroute, st.state)
+	s.finish("d2", DLRStatDelivered, DLRErrNone)
+	st, _ = s.lookup("multi", "pbx1")
+	assert.Equal(t, msgStateUndeliverable, st.state)
+	assert.Equal(t, byte(DLRErrCarrierFailed), st.errCode)
+	assert.False(t, st.finalDate.IsZero())
+}
+
+func TestSubmitThenQuerySM(t *testing.T) {
+	r, gw := newTestRouter(1)
+	gw.ConvoManager = NewConvoManager()
+	gw.Clients = map[string]*Client{"pbx1": {Username: "pbx1"}}
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	session := smpp.NewSession(ctx, serverConn)
+
+	srv := &SMPPServer{
+		gateway:       gw,
+		conns:         map[string]*smpp.Session{"pbx1": session},
+		submitLimiter: newSubmitRateLimiter(),
+		submitStates:  &submitStates{},
+	}
+	gw.SMPPServer = srv
+	h := NewSimpleHandler(srv)
+
+	source := pdu.Address{TON: 1, NPI: 1, No: "15551234567"}
+	submitSM := &pdu.SubmitSM{
+		SourceAddr: source,
+		DestAddr:   pdu.Address{TON: 1, NPI: 1, No: "15557654321"},
+		Message:    pdu.ShortMessage{Message: []byte("hello")},
+	}
+	submitSM.Header.Sequence = 1
+
+	go h.handleSubmitSM(session, submitSM)
+	submitResp, ok := readTestPDU(t, clientConn).(*pdu.SubmitSMResp)
+	require.True(t, ok)
+	require.NotEmpty(t, submitResp.MessageID)
+	msg := <-r.ClientMsgChan
+
+	query := func(id string, seq int32) *pdu.QuerySMResp {
+		q := &pdu.QuerySM{MessageID: id, SourceAddr: source}
+		q.Header.Sequence = seq
+		go h.handlePDU(session, q)
+		resp, ok := readTestPDU(t, clientConn).(*pdu.QuerySMResp)
+		require.True(t, ok)
+		assert.Equal(t, seq, resp.Header.Sequence)
+		return resp
+	}
+
+	resp := query(submitResp.MessageID, 2)
+	assert.Equal(t, pdu.ESME_ROK, resp.Header.CommandStatus)
+	assert.Equal(t, submitResp.MessageID, resp.MessageID)
+	assert.Equal(t, msgStateEnroute, resp.MessageState)
+	assert.Empty(t, resp.FinalDate)
+
+	srv.sendDeliveryReceipt(&msg, DLRStatDelivered, DLRErrNone)
+
+	resp = query(submitResp.MessageID, 3)
+	assert.Equal(t, pdu.ESME_ROK, resp.Header.CommandStatus)
+	assert.Equal(t, msgStateDelivered, resp.MessageState)
+	assert.Equal(t, byte(0), resp.ErrorCode)
+	assert.Len(t, resp.FinalDate, 16)
+
+	resp = query("no-such-id", 4)
+	assert.Equal(t, pdu.ESME_RINVMSGID, resp.Header.CommandStatus)
+}
+
+func TestSendSMPP_MessageIDRoundTrip(t *testing.T) {
+	peer := newSMPPPeer(t, 1, 0, nil)
+	peer.srv.submitStates = &submitStates{}
+
+	item := MsgQueueItem{From: "14155559876", To: "12505551234", message: strings.Repeat("long message ", 20), LogID: "log1"}
+	messageID, err := peer.srv.sendSMPP(item, peer.session)
+	require.NoError(t, err)
+	require.NotEmpty(t, messageID)
+
+	// Every segment carries the id, and it survives the wire format.
+	peer.mu.Lock()
+	delivered := peer.delivered
+	peer.mu.Unlock()
+	require.Greater(t, len(delivered), 1)
+	for _, deliverSM := range delivered {
+		assert.Equal(t, append([]byte(messageID), 0), []byte(deliverSM.Tags[tlvGatewayMessageID]))
+		assert.NotContains(t, deliverSM.Tags, tlvReceiptedMessageID, "an MO message is not a receipt")
+	}
+	dataSM := roundTripPDU(t, deliverSMAsDataSM(delivered[0])).(*pdu.DataSM)
+	assert.Equal(t, append([]byte(messageID), 0), []byte(dataSM.Tags[tlvGatewayMessageID]))
+
+	st, ok := peer.srv.submitStates.lookup(messageID, "pbx1")
+	require.True(t, ok, "the receiving client can query the id")
+	assert.Equal(t, msgStateDelivered, st.state)
+	assert.Equal(t, "log1", st.logID)
+
+	other, err := peer.srv.sendSMPP(item, peer.session)
+	require.NoError(t, err)
+	assert.NotEqual(t, messageID, other, "each delivery gets its own id")
+}
+
+func TestConcatenatedSubmitThenQuerySM(t *testing.T) {
+	r, gw := newTestRouter(1)
+	gw.ConvoManager = NewConvoManager()
+	gw.Clients = map[string]*Client{"pbx1": {Username: "pbx1"}}
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	session := smpp.NewSession(ctx, serverConn)
+
+	srv := &SMPPServer{
+		gateway:       gw,
+		conns:         map[string]*smpp.Session{"pbx1": session},
+		submitLimiter: newSubmitRateLimiter(),
+		submitStates:  &submitStates{},
+	}
+	gw.SMPPServer = srv
+	h := NewSimpleHandler(srv)
+	srv.concat = newConcatBuffer(time.Minute, h.finishConcatenated)
+
+	source := pdu.Address{TON: 1, NPI: 1, No: "15551234567"}
+	var ids []string
+	for i, text := range []string{"part one, ", "part two"} {
+		submitSM := &pdu.SubmitSM{
+			SourceAddr: source,
+			DestAddr:   pdu.Address{TON: 1, NPI: 1, No: "15557654321"},
+			ESMClass:   pdu.ESMClass{UDHIndicator: true},
+			Message: pdu.ShortMessage{
+				UDHeader: pdu.UserDataHeader{0x00: {0x2A, 2, byte(i + 1)}},
+				Message:  []byte(text),
+			},
+		}
+		submitSM.Header.Sequence = int32(i + 1)
+		go h.handleSubmitSM(session, submitSM)
+		resp, ok := readTestPDU(t, clientConn).(*pdu.SubmitSMResp)
+		require.True(t, ok)
+		require.NotEmpty(t, resp.MessageID)
+		ids = append(ids, resp.MessageID)
+	}
+	require.NotEqual(t, ids[0], ids[1], "each segment has its own message_id")
+
+	msg := <-r.ClientMsgChan
+	assert.Equal(t, ids[0], msg.LogID, "routed under the first segment's ID")
+	assert.Equal(t, "part one, part two", msg.message)
+	srv.sendDeliveryReceipt(&msg, DLRStatDelivered, DLRErrNone)
+
+	for i, id := range ids {
+		q := &pdu.QuerySM{MessageID: id, SourceAddr: source}
+		q.Header.Sequence = int32(10 + i)
+		go h.handlePDU(session, q)
+		resp, ok := readTestPDU(t, clientConn).(*pdu.QuerySMResp)
+		require.True(t, ok)
+		assert.Equal(t, pdu.ESME_ROK, resp.Header.CommandStatus, "segment %d", i+1)
+		assert.Equal(t, msgStateDelivered, resp.MessageState, "segment %d", i+1)
+	}
+}
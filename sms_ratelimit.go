@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// --- SMPP submit_sm Rate Limiter (token bucket, in-memory) ---
+
+type tokenBucket struct {
+	tokens float64
+	rate   int // tokens per second; also the bucket capacity
+	last   time.Time
+}
+
+type submitRateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket // username -> bucket
+	now     func() time.Time
+}
+
+func newSubmitRateLimiter() *submitRateLimiter {
+	return &submitRateLimiter{
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// allow returns true if username may submit another message at ratePerSec.
+// The bucket holds one second's worth of tokens, so short bursts up to the
+// rate are accepted. A rate of 0 or less means unlimited.
+func (rl *submitRateLimiter) allow(username string, ratePerSec int) bool {
+	if rl == nil || ratePerSec <= 0 {
+		return true
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	b, ok := rl.buckets[username]
+	if !ok || b.rate != ratePerSec {
+		b = &tokenBucket{tokens: float64(ratePerSec), rate: ratePerSec, last: now}
+		rl.buckets[username] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * float64(b.rate)
+	if b.tokens > float64(b.rate) {
+		b.tokens = float64(b.rate)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// submitRateLimit returns the effective submit_sm rate (messages/sec) for a
+// client: its own SubmitRateLimit if set, otherwise the gateway default.
+func (gateway *Gateway) submitRateLimit(client *Client) int {
+	if client != nil && client.Settings != nil && client.Settings.SubmitRateLimit > 0 {
+		return client.Settings.SubmitRateLimit
+	}
+	return gateway.Config.SMPPSubmitRateLimit
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+	"zultys-smpp-mm4/smpp"
+	"zultys-smpp-mm4/smpp/pdu"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubmitRateLimiter_TokenBucket(t *testing.T) {
+	rl := newSubmitRateLimiter()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rl.now = func() time.Time { return now }
+
+	for i := 0; i < 5; i++ {
+		assert.True(t, rl.allow("pbx1", 5), "message %d within burst", i)
+	}
+	assert.False(t, rl.allow("pbx1", 5), "bucket exhausted")
+	assert.True(t, rl.allow("pbx2", 5), "buckets are per client")
+
+	now = now.Add(200 * time.Millisecond)
+	assert.True(t, rl.allow("pbx1", 5), "one token refilled")
+	assert.False(t, rl.allow("pbx1", 5))
+
+	assert.True(t, rl.allow("pbx1", 0), "zero is unlimited")
+}
+
+func TestGateway_SubmitRateLimit(t *testing.T) {
+	_, gw := newTestRouter(1)
+	gw.Config.SMPPSubmitRateLimit = 50
+
+	assert.Equal(t, 50, gw.submitRateLimit(&Client{}))
+	assert.Equal(t, 50, gw.submitRateLimit(&Client{Settings: &ClientSettings{}}))
+	assert.Equal(t, 10, gw.submitRateLimit(&Client{Settings: &ClientSettings{SubmitRateLimit: 10}}))
+}
+
+func TestHandleSubmitSM_ThrottledStatus(t *testing.T) {
+	_, gw := newTestRouter(1)
+	client := &Client{Username: "pbx1", Settings: &ClientSettings{SubmitRateLimit: 2}}
+	gw.Clients = map[string]*Client{"pbx1": client}
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	session := smpp.NewSession(ctx, serverConn)
+
+	srv := &SMPPServer{
+		gateway:       gw,
+		conns:         map[string]*smpp.Session{"pbx1": session},
+		submitLimiter: newSubmitRateLimiter(),
+	}
+	gw.SMPPServer = srv
+	h := NewSimpleHandler(srv)
+
+	// Drain the client's bucket.
+	for srv.submitLimiter.allow("pbx1", 2) {
+	}
+
+	submitSM := &pdu.SubmitSM{
+		SourceAddr: pdu.Address{TON: 1, NPI: 1, No: "15551234567"},
+		DestAddr:   pdu.Address{TON: 1, NPI: 1, No: "15557654321"},
+	}
+	submitSM.Header.Sequence = 99
+
+	go h.handleSubmitSM(session, submitSM)
+
+	// Read exactly one PDU off the pipe before decoding it.
+	_ = clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	raw := make([]byte, 4)
+	_, err := io.ReadFull(clientConn, raw)
+	require.NoError(t, err)
+	body := make([]byte, binary.BigEndian.Uint32(raw)-4)
+	_, err = io.ReadFull(clientConn, body)
+	require.NoError(t, err)
+
+	packet, err := pdu.Unmarshal(bytes.NewReader(append(raw, body...)))
+	require.NoError(t, err)
+	resp, ok := packet.(*pdu.SubmitSMResp)
+	require.True(t, ok, "expected *pdu.SubmitSMResp, got %T", packet)
+	assert.Equal(t, pdu.ESME_RTHROTTLED, resp.Header.CommandStatus)
+	assert.Equal(t, int32(99), resp.Header.Sequence)
+}
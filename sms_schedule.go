@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"zultys-smpp-mm4/smpp/pdu"
+)
+
+// parseScheduleDeliveryTime converts a submit_sm schedule_delivery_time into
+// an absolute time. Both SMPP forms are accepted (SMPP v5 4.7.23):
+//
+//	absolute: YYMMDDhhmmsstnn+ or YYMMDDhhmmsstnn- (nn quarter hours from UTC)
+//	relative: YYMMDDhhmmss000R, added to now
+//
+// An empty value returns the zero time, meaning deliver immediately.
+func parseScheduleDeliveryTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if len(value) != 16 {
+		return time.Time{}, fmt.Errorf("schedule_delivery_time %q is not 16 characters", value)
+	}
+	for i := 0; i < 15; i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return time.Time{}, fmt.Errorf("schedule_delivery_time %q has a non-digit at %d", value, i)
+		}
+	}
+
+	if value[15] == 'R' {
+		var d pdu.Duration
+		if err := d.From(value); err != nil {
+			return time.Time{}, fmt.Errorf("schedule_delivery_time %q: %w", value, err)
+		}
+		return now.Add(d.Duration), nil
+	}
+
+	var t pdu.Time
+	if err := t.From(value); err != nil {
+		return time.Time{}, fmt.Errorf("schedule_delivery_time %q: %w", value, err)
+	}
+	// time.Date normalises out-of-range fields, so round-trip to reject them.
+	if t.Month() != time.Month(atoi2(value[2:4])) || t.Day() != atoi2(value[4:6]) ||
+		t.Hour() != atoi2(value[6:8]) || t.Minute() != atoi2(value[8:10]) || t.Second() != atoi2(value[10:12]) {
+		return time.Time{}, fmt.Errorf("schedule_delivery_time %q is not a valid date", value)
+	}
+	if atoi2(value[13:15]) > 48 {
+		return time.Time{}, fmt.Errorf("schedule_delivery_time %q has a UTC offset over 12 hours", value)
+	}
+	return t.Time, nil
+}
+
+// atoi2 parses two ASCII digits already known to be valid.
+func atoi2(s string) int {
+	return int(s[0]-'0')*10 + int(s[1]-'0')
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"zultys-smpp-mm4/smpp"
+	"zultys-smpp-mm4/smpp/pdu"
+)
+
+func TestParseScheduleDeliveryTime_Empty(t *testing.T) {
+	got, err := parseScheduleDeliveryTime("", time.Now())
+	require.NoError(t, err)
+	assert.True(t, got.IsZero())
+}
+
+func TestParseScheduleDeliveryTime_Absolute(t *testing.T) {
+	// 2026-10-16 14:30:05.3 at UTC-7 (28 quarter hours behind)
+	got, err := parseScheduleDeliveryTime("261016143005328-", time.Now())
+	require.NoError(t, err)
+	want := time.Date(2026, 10, 16, 21, 30, 5, 3e8, time.UTC)
+	assert.True(t, want.Equal(got), "got %v", got.UTC())
+
+	got, err = parseScheduleDeliveryTime("261016143005004+", time.Now())
+	require.NoError(t, err)
+	want = time.Date(2026, 10, 16, 13, 30, 5, 0, time.UTC)
+	assert.True(t, want.Equal(got), "got %v", got.UTC())
+}
+
+func TestParseScheduleDeliveryTime_Relative(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	got, err := parseScheduleDeliveryTime("000001023000000R", now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(24*time.Hour+2*time.Hour+30*time.Minute), got)
+
+	got, err = parseScheduleDeliveryTime("000000000045000R", now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(45*time.Second), got)
+}
+
+func TestParseScheduleDeliveryTime_Invalid(t *testing.T) {
+	for _, value := range []string{
+		"2610161430",       // too short
+		"26101614300500+",  // 15 characters
+		"26101614300500X+", // non-digit
+		"261016143005000X", // unknown symbol
+		"261332143005000+", // month 13
+		"261016253005000+", // hour 25
+		"261016143005049+", // offset over 12 hours
+	} {
+		_, err := parseScheduleDeliveryTime(value, time.Now())
+		assert.Error(t, err, value)
+	}
+}
+
+func TestScheduledMessage_ToMsgQueueItem(t *testing.T) {
+	sendAfter := time.Date(2026, 10, 16, 21, 30, 0, 0, time.UTC)
+	receipt, err := json.Marshal(&SMPPReceiptRequest{
+		Username:  "pbx1",
+		MessageID: "log-1",
+		Mode:      1,
+		DestAddr:  pdu.Address{TON: 1, NPI: 1, No: "12505551234"},
+	})
+	require.NoError(t, err)
+
+	s := ScheduledMessage{
+		LogID:       "log-1",
+		Type:        MsgQueueItemType.SMS,
+		To:          "+12505551234",
+		From:        "+14155559876",
+		Message:     "later",
+		Priority:    MsgPriorityHigh,
+		ReceiptJSON: string(receipt),
+		SendAfter:   sendAfter,
+	}
+
+	msg, err := s.toMsgQueueItem()
+	require.NoError(t, err)
+	assert.Equal(t, "later", msg.message)
+	assert.Equal(t, MsgPriorityHigh, msg.Priority)
+	assert.Equal(t, sendAfter, msg.SendAfter)
+	require.NotNil(t, msg.DeliveryReceipt)
+	assert.Equal(t, "pbx1", msg.DeliveryReceipt.Username)
+	assert.Equal(t, "12505551234", msg.DeliveryReceipt.DestAddr.No)
+}
+
+func TestScheduledMessage_RoundTrip(t *testing.T) {
+	msg := MsgQueueItem{
+		LogID:        "log-2",
+		Type:         MsgQueueItemType.SMS,
+		To:           "+12505551234",
+		From:         "+14155559876",
+		SendAfter:    time.Date(2026, 10, 16, 21, 30, 0, 0, time.UTC),
+		ValidUntil:   time.Date(2026, 10, 17, 21, 30, 0, 0, time.UTC),
+		ServiceType:  "WAP",
+		ESMClass:     pdu.ESMClass{UDHIndicator: true, ReplyPath: true},
+		UDH:          pdu.UserDataHeader{0x05: {0x0B, 0x84, 0x23, 0xF0}},
+		binary:       []byte{0x01, 0x06, 0xFF},
+		binaryCoding: 0x04,
+	}
+
+	s, err := newScheduledMessage("gw-1", msg)
+	require.NoError(t, err)
+	assert.Equal(t, "gw-1", s.ServerID)
+	assert.Equal(t, ScheduledMessageStatusScheduled, s.Status)
+
+	got, err := s.toMsgQueueItem()
+	require.NoError(t, err)
+	assert.Equal(t, msg.ValidUntil, got.ValidUntil)
+	assert.Equal(t, msg.ServiceType, got.ServiceType)
+	assert.Equal(t, msg.ESMClass, got.ESMClass)
+	assert.Equal(t, msg.UDH, got.UDH)
+	assert.Equal(t, msg.binary, got.binary)
+	assert.Equal(t, msg.binaryCoding, got.binaryCoding)
+}
+
+func TestDueScheduledMessages(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	var due []ScheduledMessage
+	stmt := dueScheduledMessages(dryRunDB(t), "gw-1", now).Find(&due).Statement
+	assert.Contains(t, stmt.SQL.String(), "server_id = $1 AND status = $2 AND send_after <= $3")
+	assert.Equal(t, []interface{}{"gw-1", ScheduledMessageStatusScheduled, now, 500}, stmt.Vars, "claimed rows are skipped")
+}
+
+func TestSubmitSM_ScheduleStoreFails(t *testing.T) {
+	r, gw := newTestRouter(1)
+	gw.ConvoManager = NewConvoManager()
+	gw.Clients = map[string]*Client{"pbx1": {Username: "pbx1"}}
+	gw.DB = dryRunDB(t).Session(&gorm.Session{SkipDefaultTransaction: true})
+	require.NoError(t, gw.DB.Callback().Create().Before("gorm:create").Register("test:fail", func(db *gorm.DB) {
+		_ = db.AddError(errors.New("database is down"))
+	}))
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	session := smpp.NewSession(ctx, serverConn)
+
+	srv := &SMPPServer{
+		gateway:       gw,
+		conns:         map[string]*smpp.Session{"pbx1": session},
+		submitLimiter: newSubmitRateLimiter(),
+		submitStates:  &submitStates{},
+	}
+	h := NewSimpleHandler(srv)
+
+	submitSM := &pdu.SubmitSM{
+		SourceAddr:           pdu.Address{TON: 1, NPI: 1, No: "15551234567"},
+		DestAddr:             pdu.Address{TON: 1, NPI: 1, No: "15557654321"},
+		ScheduleDeliveryTime: "000000010000000R",
+		Message:              pdu.ShortMessage{Message: []byte("later")},
+	}
+	submitSM.Header.Sequence = 9
+
+	go h.handleSubmitSM(session, submitSM)
+	resp, ok := readTestPDU(t, clientConn).(*pdu.SubmitSMResp)
+	require.True(t, ok)
+	assert.Equal(t, pdu.ESME_RSYSERR, resp.Header.CommandStatus)
+	assert.Equal(t, int32(9), resp.Header.Sequence)
+	assert.Empty(t, resp.MessageID)
+
+	select {
+	case msg := <-r.ClientMsgChan:
+		t.Fatalf("unexpected message %s", msg.LogID)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
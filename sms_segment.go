@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"zultys-smpp-mm4/smpp"
+	"zultys-smpp-mm4/smpp/coding"
+	"zultys-smpp-mm4/smpp/pdu"
+)
+
+const (
+	smsSingleLimitBytes    = 140
+	smsConcatUDHLen        = 6 // UDHL + IEI 0x00 + IEL + ref + total + seq
+	smsMultipartLimitBytes = smsSingleLimitBytes - smsConcatUDHLen
+)
+
+// gsm7Splitter counts extension-table characters as two septets, since they
+// go out as an escape followed by the character.
+var gsm7Splitter coding.Splitter = func(r rune) int {
+	if _, ok := gsm7ExtMap[r]; ok {
+		return 14
+	}
+	return 7
+}
+
+// splitOutboundSMS splits message into the segments sendSMPP will deliver.
+// Multi-part segments leave room for the 6-byte concatenation UDH.
+func splitOutboundSMS(message string, dc coding.DataCoding) []string {
+	if dc != coding.GSM7BitCoding {
+		return coding.SplitSMS(message, byte(dc))
+	}
+	if gsm7Splitter.Len(message) <= smsSingleLimitBytes {
+		return []string{message}
+	}
+	return gsm7Splitter.Split(message, smsMultipartLimitBytes)
+}
+
+// segmentRefs hands out a rolling 8-bit concatenation reference per session
+// so consecutive long messages to the same client are not merged.
+type segmentRefs struct {
+	mu   sync.Mutex
+	last map[*smpp.Session]byte
+}
+
+func newSegmentRefs() *segmentRefs {
+	return &segmentRefs{last: make(map[*smpp.Session]byte)}
+}
+
+func (r *segmentRefs) next(session *smpp.Session) uint16 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.last[session]++
+	return uint16(r.last[session])
+}
+
+func (r *segmentRefs) remove(session *smpp.Session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.last, session)
+}
+
+// outboundSegment is one deliver_sm of a (possibly multi-part) message.
+type outboundSegment struct {
+	text string
+	pdu  *pdu.DeliverSM
+}
+
+// composeDeliverSMs encodes message into one deliver_sm per segment, in GSM7
+// when it fits and UCS2 otherwise. When more than one segment is needed, ref
+// is called once and every part carries a concatenation UDH with that
+// reference. Sequence numbers are left unset.
+func composeDeliverSMs(from, to, message string, ref func() uint16) ([]outboundSegment, coding.DataCoding, error) {
+	return composeDeliverSMsAs(from, to, message, coding.BestSafeCoding(message), ref)
+}
+
+// composeDeliverSMsAs is composeDeliverSMs with the data coding chosen by the
+// caller; message must be encodable in it.
+func composeDeliverSMsAs(from, to, message string, bestCoding coding.DataCoding, ref func() uint16) ([]outboundSegment, coding.DataCoding, error) {
+	segments := splitOutboundSMS(message, bestCoding)
+	if len(segments) > 0xFF {
+		return nil, bestCoding, pdu.ErrMultipartTooMuch
+	}
+
+	var header *pdu.ConcatenatedHeader
+	if len(segments) > 1 {
+		header = &pdu.ConcatenatedHeader{
+			Reference:  ref() & 0xFF,
+			TotalParts: byte(len(segments)),
+		}
+	}
+
+	encoder := bestCoding.Encoding().NewEncoder()
+	parts := make([]outboundSegment, 0, len(segments))
+	for i, segment := range segments {
+		var encoded []byte
+		var err error
+		if bestCoding == coding.GSM7BitCoding {
+			if encoded, err = encodeUnpackedGSM7(segment); err != nil {
+				return nil, bestCoding, fmt.Errorf("GSM7 encode error in segment %d: %w", i, err)
+			}
+		} else {
+			encoder.Reset()
+			if encoded, err = encoder.Bytes([]byte(segment)); err != nil {
+				return nil, bestCoding, fmt.Errorf("encoding error in segment %d: %w", i, err)
+			}
+		}
+
+		deliverSM := &pdu.DeliverSM{
+			SourceAddr: smppAddress(from),
+			DestAddr:   pdu.Address{TON: 0x01, NPI: 0x01, No: to},
+			Message:    pdu.ShortMessage{Message: encoded, DataCoding: bestCoding},
+			RegisteredDelivery: pdu.RegisteredDelivery{
+				MCDeliveryReceipt: 1,
+			},
+		}
+		if header != nil {
+			header.Sequence = byte(i + 1)
+			deliverSM.ESMClass.UDHIndicator = true
+			deliverSM.Message.UDHeader = make(pdu.UserDataHeader)
+			header.Set(deliverSM.Message.UDHeader)
+		}
+		parts = append(parts, outboundSegment{text: segment, pdu: deliverSM})
+	}
+	return parts, bestCoding, nil
+}
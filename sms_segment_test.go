@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"zultys-smpp-mm4/smpp"
+	"zultys-smpp-mm4/smpp/coding"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComposeDeliverSMs_UCS2EmojiCarriesConcatUDH(t *testing.T) {
+	message := strings.Repeat("😀", 500)
+	refCalls := 0
+	parts, dc, err := composeDeliverSMs("15551234567", "15557654321", message, func() uint16 {
+		refCalls++
+		return 0x2A
+	})
+	require.NoError(t, err)
+	assert.Equal(t, coding.UCS2Coding, dc)
+	assert.Equal(t, 1, refCalls, "one reference per message")
+	require.Greater(t, len(parts), 1)
+
+	var rebuilt strings.Builder
+	for i, part := range parts {
+		sm := part.pdu
+		assert.True(t, sm.ESMClass.UDHIndicator, "part %d", i+1)
+		assert.Equal(t, []byte{0x2A, byte(len(parts)), byte(i + 1)}, []byte(sm.Message.UDHeader[0x00]), "part %d", i+1)
+		assert.LessOrEqual(t, len(sm.Message.Message)+smsConcatUDHLen, smsSingleLimitBytes, "part %d", i+1)
+
+		var buf bytes.Buffer
+		_, err := sm.Message.UDHeader.WriteTo(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0x05, 0x00, 0x03, 0x2A, byte(len(parts)), byte(i + 1)}, buf.Bytes())
+
+		decoded, err := coding.UCS2Coding.Encoding().NewDecoder().Bytes(sm.Message.Message)
+		require.NoError(t, err)
+		rebuilt.Write(decoded)
+	}
+	assert.Equal(t, message, rebuilt.String(), "surrogate pairs must not be split across parts")
+}
+
+func TestComposeDeliverSMs_SinglePartHasNoUDH(t *testing.T) {
+	parts, dc, err := composeDeliverSMs("1", "2", "hello", func() uint16 {
+		t.Fatal("single-part messages must not allocate a reference")
+		return 0
+	})
+	require.NoError(t, err)
+	assert.Equal(t, coding.GSM7BitCoding, dc)
+	require.Len(t, parts, 1)
+	assert.Nil(t, parts[0].pdu.Message.UDHeader)
+	assert.False(t, parts[0].pdu.ESMClass.UDHIndicator)
+}
+
+func TestSplitOutboundSMS_GSM7(t *testing.T) {
+	assert.Len(t, splitOutboundSMS(strings.Repeat("a", 160), coding.GSM7BitCoding), 1)
+
+	segments := splitOutboundSMS(strings.Repeat("a", 161), coding.GSM7BitCoding)
+	require.Len(t, segments, 2)
+	assert.Len(t, segments[0], 153)
+
+	// Extension characters take two septets each.
+	segments = splitOutboundSMS(strings.Repeat("€", 100), coding.GSM7BitCoding)
+	require.Len(t, segments, 2)
+	assert.Equal(t, 76, len([]rune(segments[0])))
+}
+
+func TestSegmentRefs_RollPerSession(t *testing.T) {
+	refs := newSegmentRefs()
+	a, b := &smpp.Session{}, &smpp.Session{}
+
+	assert.Equal(t, uint16(1), refs.next(a))
+	assert.Equal(t, uint16(2), refs.next(a))
+	assert.Equal(t, uint16(1), refs.next(b))
+
+	for i := 0; i < 253; i++ {
+		refs.next(a)
+	}
+	assert.Equal(t, uint16(0), refs.next(a), "reference wraps at 8 bits")
+
+	refs.remove(a)
+	assert.Equal(t, uint16(1), refs.next(a))
+}
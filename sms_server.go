@@ -7,7 +7,6 @@ import (
 	"encoding/hex"
 	"fmt"
 	"net"
-	"os"
 	"strings"
 	"sync"
 	"time"
@@ -29,19 +28,34 @@ type SMPPServer struct {
 
 	pendingAcks   map[int32]chan *pdu.DeliverSMResp
 	pendingAcksMu sync.Mutex
+
+	listener    net.Listener // set once SMPP_LISTEN is bound
+	tlsListener net.Listener // set once SMPP_TLS_LISTEN is bound (optional)
+	closing     bool         // set by stopListening; Serve errors are then expected
+
+	concat        *concatBuffer      // reassembly of multi-part submit_sm
+	submitLimiter *submitRateLimiter // per-client submit_sm throttling
+	segmentRefs   *segmentRefs       // concatenation references for outbound deliver_sm
+	windows       *smppWindows       // per-session deliver_sm windows
+	submitStates  *submitStates      // message states for query_sm
+	bindLockout   *bindLockout       // failed bind tracking; nil when disabled
+	connLimits    *connLimiter       // bound sessions per client, for MAX_CONNS_*
 }
 
 func (srv *SMPPServer) Start(gateway *Gateway) {
 	handler := NewSimpleHandler(gateway.SMPPServer)
-	smppListen := os.Getenv("SMPP_LISTEN")
-	if smppListen == "" {
-		smppListen = "0.0.0.0:2775"
-	}
+	smppListen := gateway.Config.SMPPListen
 
 	srv.gateway = gateway
 	lm := srv.gateway.LogManager
 
 	srv.pendingAcks = make(map[int32]chan *pdu.DeliverSMResp)
+	srv.concat = newConcatBuffer(time.Duration(gateway.Config.SMPPConcatTimeoutSecs)*time.Second, handler.finishConcatenated)
+	srv.bindLockout = newBindLockout(
+		gateway.Config.SMPPBindMaxFailures,
+		time.Duration(gateway.Config.SMPPBindFailureWindowSecs)*time.Second,
+		time.Duration(gateway.Config.SMPPBindLockoutSecs)*time.Second,
+	)
 
 	lm.SendLog(lm.BuildLog(
 		"Server.SMPP.Start",
@@ -52,9 +66,25 @@ func (srv *SMPPServer) Start(gateway *Gateway) {
 		},
 	))
 
+	listener, err := smpp.Listen(smppListen, nil)
+	if err != nil {
+		lm.SendLog(lm.BuildLog(
+			"Server.SMPP.Start",
+			"SMPPServeTCPError",
+			logrus.FatalLevel,
+			map[string]interface{}{
+				"listen_addr": smppListen,
+			}, err,
+		))
+		panic(err)
+	}
+	srv.mu.Lock()
+	srv.listener = listener
+	srv.mu.Unlock()
+
 	go func() {
-		err := smpp.ServeTCP(smppListen, handler, nil)
-		if err != nil {
+		err := smpp.Serve(listener, handler, srv.gateway.Config.ProxyProtocol)
+		if err != nil && !srv.isClosing() {
 			lm.SendLog(lm.BuildLog(
 				"Server.SMPP.Start",
 				"SMPPServeTCPError",
@@ -67,13 +97,95 @@ func (srv *SMPPServer) Start(gateway *Gateway) {
 		}
 	}()
 
+	if tlsListen := gateway.Config.SMPPTLSListen; tlsListen != "" {
+		srv.startTLS(tlsListen, handler)
+	}
+
 	select {}
 }
 
+// isListening reports whether the SMPP listener has been bound.
+func (srv *SMPPServer) isListening() bool {
+	if srv == nil {
+		return false
+	}
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+	return srv.listener != nil
+}
+
+// stopListening closes the SMPP listeners so no new sessions are accepted.
+// Existing sessions are left open.
+func (srv *SMPPServer) stopListening() {
+	if srv == nil {
+		return
+	}
+	srv.mu.Lock()
+	srv.closing = true
+	listeners := []net.Listener{srv.listener, srv.tlsListener}
+	srv.listener, srv.tlsListener = nil, nil
+	srv.mu.Unlock()
+
+	for _, l := range listeners {
+		if l != nil {
+			_ = l.Close()
+		}
+	}
+}
+
+func (srv *SMPPServer) isClosing() bool {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+	return srv.closing
+}
+
+// unbindAll sends unbind to every bound client and closes its session.
+func (srv *SMPPServer) unbindAll(ctx context.Context) {
+	if srv == nil {
+		return
+	}
+	lm := srv.gateway.LogManager
+
+	srv.mu.RLock()
+	sessions := make(map[string]*smpp.Session, len(srv.conns))
+	for username, session := range srv.conns {
+		sessions[username] = session
+	}
+	srv.mu.RUnlock()
+
+	for username, session := range sessions {
+		if err := session.Close(ctx); err != nil {
+			_ = session.Parent.Close()
+			lm.SendLog(lm.BuildLog(
+				"Server.SMPP.Shutdown",
+				"UnbindError",
+				logrus.WarnLevel,
+				map[string]interface{}{
+					"username": username,
+				}, err,
+			))
+			continue
+		}
+		lm.SendLog(lm.BuildLog(
+			"Server.SMPP.Shutdown",
+			"Unbound",
+			logrus.InfoLevel,
+			map[string]interface{}{
+				"username": username,
+			},
+		))
+	}
+}
+
 func initSmppServer() (*SMPPServer, error) {
 	return &SMPPServer{
 		conns:            make(map[string]*smpp.Session),
 		reconnectChannel: make(chan string),
+		submitLimiter:    newSubmitRateLimiter(),
+		segmentRefs:      newSegmentRefs(),
+		windows:          newSMPPWindows(),
+		submitStates:     &submitStates{},
+		connLimits:       newConnLimiter("smpp"),
 	}, nil
 }
 
@@ -119,6 +231,12 @@ func (srv *SMPPServer) removeSession(session *smpp.Session) {
 	for username, sess := range srv.conns {
 		if sess == session {
 			delete(srv.conns, username)
+			if srv.segmentRefs != nil {
+				srv.segmentRefs.remove(session)
+			}
+			if srv.windows != nil {
+				srv.windows.remove(session)
+			}
 
 			if lm != nil {
 				ip := ""
@@ -203,6 +321,7 @@ func (h *SimpleHandler) Serve(session *smpp.Session) {
 
 		// Remove from our tracking map.
 		h.server.removeSession(session)
+		h.server.connLimits.release(session)
 
 		lm.SendLog(lm.BuildLog(
 			"Server.SMPP.Serve",
@@ -218,8 +337,9 @@ func (h *SimpleHandler) Serve(session *smpp.Session) {
 		))
 	}()
 
-	// Periodic enquire_link
-	go h.enquireLink(session, ctx)
+	// Periodic enquire_link starts once the session binds, so the client's
+	// own keepalive settings can be applied.
+	keepaliveStarted := false
 
 	for {
 		select {
@@ -269,26 +389,45 @@ func (h *SimpleHandler) Serve(session *smpp.Session) {
 			// (which will set closedByServer on the next select iteration),
 			// or you can change handlePDU to return an error and set closedByServer here.
 			h.handlePDU(session, packet)
+
+			if !keepaliveStarted {
+				if _, client := h.server.getSessionClientInfo(session); client != nil {
+					keepaliveStarted = true
+					go h.enquireLink(session, ctx)
+				}
+			}
 		}
 	}
 }
 func (h *SimpleHandler) enquireLink(session *smpp.Session, ctx context.Context) {
 	lm := h.server.gateway.LogManager
 
-	// Use configured timeout or default to 30s
-	timeout := time.Duration(h.server.gateway.Config.SMPPTimeoutSecs) * time.Second
-	if timeout == 0 {
-		timeout = 30 * time.Second
+	username, client := h.server.getSessionClientInfo(session)
+	clientName := ""
+	if client != nil {
+		clientName = client.Username
 	}
 
+	keepalive := h.server.gateway.smppKeepalive(client)
+	timeout := keepalive.EnquireTimeout
+
+	lm.SendLog(lm.BuildLog(
+		"Server.SMPP.EnquireLink",
+		"EnquireLinkConfig",
+		logrus.InfoLevel,
+		map[string]interface{}{
+			"ip":               session.Parent.RemoteAddr().String(),
+			"username":         username,
+			"client":           clientName,
+			"enquire_interval": keepalive.EnquireInterval.String(),
+			"enquire_timeout":  keepalive.EnquireTimeout.String(),
+			"response_timeout": keepalive.ResponseTimeout.String(),
+		},
+	))
+
 	// session.EnquireLink blocks and handles the ticker internally
-	err := session.EnquireLink(ctx, 15*time.Second, timeout)
+	err := session.EnquireLink(ctx, keepalive.EnquireInterval, timeout)
 	if err != nil {
-		username, client := h.server.getSessionClientInfo(session)
-		clientName := ""
-		if client != nil {
-			clientName = client.Username
-		}
 
 		// Only log as warning if it's not just a context cancellation
 		if ctx.Err() == nil {
@@ -323,6 +462,22 @@ func (s *SMPPServer) removePendingAck(seq int32) {
 	s.pendingAcksMu.Unlock()
 }
 
+// resolvePendingAck hands a deliver_sm_resp to the sendSMPP call waiting on
+// its sequence, if any.
+func (s *SMPPServer) resolvePendingAck(p *pdu.DeliverSMResp) {
+	seq := p.Header.Sequence
+	s.pendingAcksMu.Lock()
+	ackCh, exists := s.pendingAcks[seq]
+	s.pendingAcksMu.Unlock()
+	if exists {
+		select {
+		case ackCh <- p:
+		default:
+		}
+		s.removePendingAck(seq)
+	}
+}
+
 func (h *SimpleHandler) handlePDU(session *smpp.Session, packet any) {
 	lm := h.server.gateway.LogManager
 
@@ -340,18 +495,21 @@ func (h *SimpleHandler) handlePDU(session *smpp.Session, packet any) {
 		// Auth/session check is done inside handleSubmitSM using conns map.
 		h.handleSubmitSM(session, p)
 
+	case *pdu.DataSM:
+		h.handleDataSM(session, p)
+
+	case *pdu.SubmitMulti:
+		h.handleSubmitMulti(session, p)
+
+	case *pdu.QuerySM:
+		h.handleQuerySM(session, p)
+
 	case *pdu.DeliverSMResp:
-		seq := p.Header.Sequence
-		h.server.pendingAcksMu.Lock()
-		ackCh, exists := h.server.pendingAcks[seq]
-		h.server.pendingAcksMu.Unlock()
-		if exists {
-			select {
-			case ackCh <- p:
-			default:
-			}
-			h.server.removePendingAck(seq)
-		}
+		h.server.resolvePendingAck(p)
+
+	case *pdu.DataSMResp:
+		// A data_sm sent in place of a deliver_sm is acked the same way.
+		h.server.resolvePendingAck(&pdu.DeliverSMResp{Header: p.Header, MessageID: p.MessageID, Tags: p.Tags})
 
 	case *pdu.DeliverSM:
 		h.handleDeliverSM(session, p)
@@ -463,8 +621,53 @@ func (h *SimpleHandler) handleBind(session *smpp.Session, bindReq *pdu.BindTrans
 		))
 	}
 
+	// A locked out IP or system_id is refused without checking credentials.
+	if h.server.bindLockout.locked(ip, username) {
+		sendBindError(pdu.ErrInvalidPasswd, "AuthLockedOut", nil)
+		return
+	}
+
+	// bindFailed refuses a bind with bad credentials and counts it towards a
+	// lockout.
+	bindFailed := func(status pdu.CommandStatus, reason string) {
+		// Count the failure before answering, so an immediate retry sees it.
+		lockedOut := h.server.bindLockout.fail(ip, username)
+		sendBindError(status, reason, nil)
+		if lockedOut {
+			lm.SendLog(lm.BuildLog(
+				"Server.SMPP.HandleBind",
+				"BindLockoutStarted",
+				logrus.WarnLevel,
+				map[string]interface{}{
+					"ip":           ip,
+					"username":     username,
+					"lockout_secs": h.server.gateway.Config.SMPPBindLockoutSecs,
+					"max_failures": h.server.gateway.Config.SMPPBindMaxFailures,
+					"window_secs":  h.server.gateway.Config.SMPPBindFailureWindowSecs,
+				},
+			))
+		}
+	}
+
+	version, ok := negotiateInterfaceVersion(bindReq.Version)
+	if !ok {
+		sendBindError(pdu.ErrBindFail, "UnsupportedInterfaceVersion", nil)
+		lm.SendLog(lm.BuildLog(
+			"Server.SMPP.HandleBind",
+			"BindVersionRejected",
+			logrus.WarnLevel,
+			map[string]interface{}{
+				"ip":       ip,
+				"username": username,
+				"version":  bindReq.Version.String(),
+				"reason":   "SMPP 3.4 or later is required",
+			},
+		))
+		return
+	}
+
 	if username == "" || password == "" {
-		sendBindError(pdu.ErrInvalidSystemID, "AuthFailedMissingCredentials", nil)
+		bindFailed(pdu.ErrInvalidSystemID, "AuthFailedMissingCredentials")
 		return
 	}
 
@@ -475,9 +678,10 @@ func (h *SimpleHandler) handleBind(session *smpp.Session, bindReq *pdu.BindTrans
 	}
 
 	if !authed {
-		sendBindError(pdu.ErrInvalidPasswd, "AuthFailedInvalidCredentials", nil)
+		bindFailed(pdu.ErrInvalidPasswd, "AuthFailedInvalidCredentials")
 		return
 	}
+	h.server.bindLockout.succeed(ip, username)
 
 	// Verify client is legacy type - web clients cannot use SMPP
 	h.server.gateway.mu.RLock()
@@ -499,7 +703,22 @@ func (h *SimpleHandler) handleBind(session *smpp.Session, bindReq *pdu.BindTrans
 		return
 	}
 
-	resp := bindReq.Resp(pdu.ESME_ROK)
+	// Enforce the connection caps. A session this bind replaces is closed
+	// below, so it does not count; it keeps its slot if this bind is refused.
+	h.server.mu.RLock()
+	replaced := h.server.conns[username]
+	h.server.mu.RUnlock()
+	var replacedOwner any
+	if replaced != nil {
+		replacedOwner = replaced
+	}
+	if !h.server.connLimits.acquireReplacing(session, replacedOwner, username, h.server.gateway.maxClientConns(client), h.server.gateway.Config.MaxConnsTotal) {
+		sendBindError(pdu.ErrBindFail, "TooManyConnections", nil)
+		return
+	}
+
+	resp := bindReq.Resp(pdu.ESME_ROK).(*pdu.BindTransceiverResp)
+	resp.Tags = pdu.Tags{tlvSCInterfaceVersion: {byte(version)}}
 	if err = session.Send(resp); err != nil {
 		lm.SendLog(lm.BuildLog(
 			"Server.SMPP.HandleBind",
@@ -510,6 +729,7 @@ func (h *SimpleHandler) handleBind(session *smpp.Session, bindReq *pdu.BindTrans
 				"username": username,
 			}, err,
 		))
+		h.server.connLimits.release(session)
 		_ = session.Close(context.Background())
 		return
 	}
@@ -529,6 +749,7 @@ func (h *SimpleHandler) handleBind(session *smpp.Session, bindReq *pdu.BindTrans
 			"ip":       ip,
 			"username": username,
 			"client":   clientName,
+			"version":  version.String(),
 		},
 	))
 
@@ -547,12 +768,14 @@ func (h *SimpleHandler) handleBind(session *smpp.Session, bindReq *pdu.BindTrans
 		))
 		_ = oldSession.Close(context.Background())
 	}
+	session.Version = version
 	h.server.conns[username] = session
 	h.server.mu.Unlock()
 }
 
 func (h *SimpleHandler) handleSubmitSM(session *smpp.Session, submitSM *pdu.SubmitSM) {
 	transId := primitive.NewObjectID().Hex()
+	traceID := newTraceID()
 	lm := h.server.gateway.LogManager
 
 	// Find the client associated with this session via the conns map
@@ -584,42 +807,85 @@ func (h *SimpleHandler) handleSubmitSM(session *smpp.Session, submitSM *pdu.Subm
 		return
 	}
 
-	var decodedMsg string
-	var decodeErr error
-	encoding := coding.GSM7BitCoding
+	if rate := h.server.gateway.submitRateLimit(client); !h.server.submitLimiter.allow(username, rate) {
+		lm.SendLog(lm.BuildLog(
+			"Server.SMPP.HandleSubmitSM",
+			"SubmitThrottled",
+			logrus.WarnLevel,
+			map[string]interface{}{
+				"logID":     transId,
+				"traceID":   traceID,
+				"ip":        session.Parent.RemoteAddr().String(),
+				"client":    client.Username,
+				"username":  username,
+				"rateLimit": rate,
+				"sequence":  submitSM.Header.Sequence,
+			},
+		))
+		resp := submitSM.Resp().(*pdu.SubmitSMResp)
+		resp.Header.CommandStatus = pdu.ESME_RTHROTTLED
+		_ = session.Send(resp)
+		return
+	}
 
-	switch submitSM.Message.DataCoding {
-	case 0:
-		decodedMsg, decodeErr = decodeUnpackedGSM7(submitSM.Message.Message)
-	case 1:
-		encoding = coding.ASCIICoding
-		decodedMsg, decodeErr = encoding.Encoding().NewDecoder().String(string(submitSM.Message.Message))
-	case 8:
-		encoding = coding.UCS2Coding
-		decodedMsg, decodeErr = encoding.Encoding().NewDecoder().String(string(submitSM.Message.Message))
-	case 3:
-		encoding = coding.Latin1Coding
-		decodedMsg, decodeErr = encoding.Encoding().NewDecoder().String(string(submitSM.Message.Message))
-	case 5:
-		encoding = coding.ShiftJISCoding
-		decodedMsg, decodeErr = encoding.Encoding().NewDecoder().String(string(submitSM.Message.Message))
-	case 6:
-		encoding = coding.CyrillicCoding
-		decodedMsg, decodeErr = encoding.Encoding().NewDecoder().String(string(submitSM.Message.Message))
-	case 7:
-		encoding = coding.HebrewCoding
-		decodedMsg, decodeErr = encoding.Encoding().NewDecoder().String(string(submitSM.Message.Message))
-	case 10:
-		encoding = coding.ISO2022JPCoding
-		decodedMsg, decodeErr = encoding.Encoding().NewDecoder().String(string(submitSM.Message.Message))
-	case 13:
-		encoding = coding.EUCJPCoding
-		decodedMsg, decodeErr = encoding.Encoding().NewDecoder().String(string(submitSM.Message.Message))
-	case 14:
-		encoding = coding.EUCKRCoding
-		decodedMsg, decodeErr = encoding.Encoding().NewDecoder().String(string(submitSM.Message.Message))
-	default:
-		decodedMsg, decodeErr = decodeUnpackedGSM7(submitSM.Message.Message)
+	if _, err := parseScheduleDeliveryTime(submitSM.ScheduleDeliveryTime, time.Now()); err != nil {
+		lm.SendLog(lm.BuildLog(
+			"Server.SMPP.HandleSubmitSM",
+			"InvalidScheduleDeliveryTime",
+			logrus.WarnLevel,
+			map[string]interface{}{
+				"logID":    transId,
+				"traceID":  traceID,
+				"ip":       session.Parent.RemoteAddr().String(),
+				"client":   client.Username,
+				"username": username,
+				"sequence": submitSM.Header.Sequence,
+			}, err,
+		))
+		resp := submitSM.Resp().(*pdu.SubmitSMResp)
+		resp.Header.CommandStatus = pdu.ESME_RINVSCHED
+		_ = session.Send(resp)
+		return
+	}
+
+	if err := h.server.gateway.validateClientDest(submitSM.DestAddr.String()); err != nil {
+		lm.SendLog(lm.BuildLog(
+			"Server.SMPP.HandleSubmitSM",
+			"InvalidDestination",
+			logrus.WarnLevel,
+			map[string]interface{}{
+				"logID":    transId,
+				"traceID":  traceID,
+				"ip":       session.Parent.RemoteAddr().String(),
+				"client":   client.Username,
+				"username": username,
+				"to":       submitSM.DestAddr.String(),
+				"sequence": submitSM.Header.Sequence,
+			}, err,
+		))
+		resp := submitSM.Resp().(*pdu.SubmitSMResp)
+		resp.Header.CommandStatus = pdu.ESME_RINVDSTADR
+		_ = session.Send(resp)
+		return
+	}
+
+	decodedMsg, encoding, decodeErr := decodeSubmitSMText(submitSM.Message.DataCoding, submitSM.Message.Message)
+	if encoding != submitSM.Message.DataCoding {
+		// Unsupported coding: the text was decoded as GSM7 and may be garbled.
+		lm.SendLog(lm.BuildLog(
+			"Server.SMPP.HandleSubmitSM",
+			"UnknownDataCoding",
+			logrus.WarnLevel,
+			map[string]interface{}{
+				"logID":       transId,
+				"traceID":     traceID,
+				"client":      client.Username,
+				"ip":          session.Parent.RemoteAddr().String(),
+				"dataCoding":  byte(submitSM.Message.DataCoding),
+				"rawMsgBytes": hex.EncodeToString(submitSM.Message.Message),
+				"fallback":    dataCodingName(encoding),
+			},
+		))
 	}
 
 	lm.SendLog(lm.BuildLog(
@@ -628,6 +894,7 @@ func (h *SimpleHandler) handleSubmitSM(session *smpp.Session, submitSM *pdu.Subm
 		logrus.DebugLevel,
 		map[string]interface{}{
 			"logID":            transId,
+			"traceID":          traceID,
 			"client":           client.Username,
 			"username":         username,
 			"ip":               session.Parent.RemoteAddr().String(),
@@ -661,6 +928,23 @@ func (h *SimpleHandler) handleSubmitSM(session *smpp.Session, submitSM *pdu.Subm
 		},
 	))
 
+	// Multi-part messages are buffered until every segment has arrived; each
+	// segment is acknowledged on its own so the client keeps sending.
+	if header := concatHeader(submitSM); header != nil {
+		key := concatKey{
+			username: username,
+			source:   submitSM.SourceAddr.String(),
+			dest:     submitSM.DestAddr.String(),
+			ref:      header.Reference,
+		}
+		firstID := h.server.concat.add(key, header, submitSM, session, client, transId, traceID)
+		h.sendSubmitSMResp(session, submitSM, client, username, transId)
+		// Each segment has its own message_id; the message is routed, and
+		// its receipt sent, under the first segment's.
+		h.server.submitStates.link(firstID, transId)
+		return
+	}
+
 	if decodedMsg == "" {
 		lm.SendLog(lm.BuildLog(
 			"Server.SMPP.HandleSubmitSM",
@@ -669,6 +953,7 @@ func (h *SimpleHandler) handleSubmitSM(session *smpp.Session, submitSM *pdu.Subm
 			map[string]interface{}{
 				"client":   client.Username,
 				"logID":    transId,
+				"traceID":  traceID,
 				"username": username,
 				"ip":       session.Parent.RemoteAddr().String(),
 				"coding":   submitSM.Message.DataCoding,
@@ -692,6 +977,102 @@ func (h *SimpleHandler) handleSubmitSM(session *smpp.Session, submitSM *pdu.Subm
 		return
 	}
 
+	queued, err := h.enqueueSubmitSM(session, client, username, transId, traceID, submitSM, decodedMsg, 1)
+	if err != nil {
+		resp := submitSM.Resp().(*pdu.SubmitSMResp)
+		resp.Header.CommandStatus = pdu.ESME_RSYSERR
+		_ = session.Send(resp)
+		return
+	}
+	if !queued {
+		return
+	}
+
+	h.sendSubmitSMResp(session, submitSM, client, username, transId)
+}
+
+// sendSubmitSMResp acknowledges a submit_sm, returning our log ID as the
+// message_id so receipts can be correlated.
+func (h *SimpleHandler) sendSubmitSMResp(session *smpp.Session, submitSM *pdu.SubmitSM, client *Client, username, transId string) {
+	lm := h.server.gateway.LogManager
+
+	state := msgStateEnroute
+	now := time.Now()
+	if sendAfter, _ := parseScheduleDeliveryTime(submitSM.ScheduleDeliveryTime, now); sendAfter.After(now) {
+		state = msgStateScheduled
+	}
+	h.server.submitStates.submitted(transId, username, state)
+
+	resp := submitSM.Resp().(*pdu.SubmitSMResp)
+	resp.MessageID = transId
+	if err := session.Send(resp); err != nil {
+		lm.SendLog(lm.BuildLog(
+			"Server.SMPP.HandleSubmitSM",
+			"SMPPPDUError",
+			logrus.ErrorLevel,
+			map[string]interface{}{
+				"ip":       session.Parent.RemoteAddr().String(),
+				"client":   client.Username,
+				"username": username,
+			}, err,
+		))
+	}
+}
+
+// finishConcatenated is called by the reassembly buffer once all segments of
+// a multi-part submit_sm have arrived, or when the timeout flushes a partial
+// message. The reassembled text is enqueued under the first segment's log ID.
+func (h *SimpleHandler) finishConcatenated(entry *concatEntry) {
+	lm := h.server.gateway.LogManager
+
+	decodedMsg, err := entry.text()
+
+	level := logrus.InfoLevel
+	if entry.timedOut {
+		level = logrus.WarnLevel
+	}
+	lm.SendLog(lm.BuildLog(
+		"Server.SMPP.HandleSubmitSM",
+		"ConcatenatedReassembled",
+		level,
+		map[string]interface{}{
+			"logID":     entry.transId,
+			"traceID":   entry.traceID,
+			"client":    entry.client.Username,
+			"username":  entry.key.username,
+			"from":      entry.key.source,
+			"to":        entry.key.dest,
+			"reference": entry.key.ref,
+			"received":  entry.received,
+			"total":     len(entry.parts),
+			"timedOut":  entry.timedOut,
+			"elapsed":   time.Since(entry.started).String(),
+		}, err,
+	))
+
+	if decodedMsg == "" {
+		return
+	}
+
+	// Every segment has been acknowledged; a failure to hold the message is
+	// logged by enqueueSubmitSM.
+	_, _ = h.enqueueSubmitSM(entry.session, entry.client, entry.key.username, entry.transId, entry.traceID, entry.first, decodedMsg, entry.received)
+}
+
+// enqueueSubmitSM hands a fully decoded submit_sm (single segment or
+// reassembled) to the conversation manager. It returns false if the message
+// was dropped by a client rule, and an error if a scheduled message could
+// not be stored; the client should then be answered with ESME_RSYSERR.
+func (h *SimpleHandler) enqueueSubmitSM(session *smpp.Session, client *Client, username, transId, traceID string, submitSM *pdu.SubmitSM, decodedMsg string, parts int) (bool, error) {
+	return h.enqueueSubmitSMAs(session, client, username, transId, transId, traceID, submitSM, decodedMsg, parts)
+}
+
+// enqueueSubmitSMAs is enqueueSubmitSM for a message the client knows by
+// messageID rather than its log ID, as each destination of a submit_multi
+// is; delivery receipts carry messageID.
+func (h *SimpleHandler) enqueueSubmitSMAs(session *smpp.Session, client *Client, username, transId, messageID, traceID string, submitSM *pdu.SubmitSM, decodedMsg string, parts int) (bool, error) {
+	lm := h.server.gateway.LogManager
+
 	numData := h.server.gateway.getNumber(submitSM.SourceAddr.String())
 	if numData != nil && numData.IgnoreStopCmdSending && decodedMsg == "Reply STOP to end messages." {
 		lm.SendLog(lm.BuildLog(
@@ -705,21 +1086,41 @@ func (h *SimpleHandler) handleSubmitSM(session *smpp.Session, submitSM *pdu.Subm
 				"username": username,
 			},
 		))
-		return
+		return false, nil
 	}
 
 	// Normalize numbers to ensure consistent ConvoID hash
-	toFormatted, _ := FormatToE164(submitSM.DestAddr.String())
-	fromFormatted, _ := FormatToE164(submitSM.SourceAddr.String())
+	toFormatted, _ := h.server.gateway.formatClientNumber(submitSM.DestAddr.String(), client)
+	fromFormatted := h.server.gateway.formatClientSender(submitSM.SourceAddr.String(), client)
 
+	received := time.Now()
 	msgQueueItem := MsgQueueItem{
 		To:                toFormatted,
 		From:              fromFormatted,
-		ReceivedTimestamp: time.Now(),
+		ReceivedTimestamp: received,
+		QueuedTimestamp:   received,
 		Type:              MsgQueueItemType.SMS,
 		message:           decodedMsg,
 		SkipNumberCheck:   false,
 		LogID:             transId,
+		TraceID:           traceID,
+		Priority:          h.server.gateway.msgPriority(client, MsgQueueItemType.SMS),
+	}
+	setSMPPPassthrough(&msgQueueItem, submitSM)
+	// An unreadable validity_period leaves the message without one.
+	msgQueueItem.ValidUntil, _ = parseScheduleDeliveryTime(submitSM.ValidityPeriod, msgQueueItem.ReceivedTimestamp)
+
+	if mode := client.receiptMode(submitSM.RegisteredDelivery.MCDeliveryReceipt); mode != 0 {
+		msgQueueItem.DeliveryReceipt = &SMPPReceiptRequest{
+			Username:   username,
+			MessageID:  messageID,
+			Sequence:   submitSM.Header.Sequence,
+			SubmitDate: msgQueueItem.ReceivedTimestamp,
+			Mode:       mode,
+			SourceAddr: submitSM.SourceAddr,
+			DestAddr:   submitSM.DestAddr,
+			Text:       decodedMsg,
+		}
 	}
 
 	lm.SendLog(lm.BuildLog(
@@ -730,30 +1131,90 @@ func (h *SimpleHandler) handleSubmitSM(session *smpp.Session, submitSM *pdu.Subm
 			"client":     client.Username,
 			"username":   username,
 			"logID":      transId,
+			"traceID":    traceID,
 			"from":       msgQueueItem.From,
 			"to":         msgQueueItem.To,
 			"decodedMsg": decodedMsg,
+			"receipt":    msgQueueItem.DeliveryReceipt != nil,
+			"parts":      parts,
 		},
 	))
 
+	// A schedule_delivery_time in the future holds the message in the router;
+	// it was validated when the (first) segment arrived.
+	sendAfter, _ := parseScheduleDeliveryTime(submitSM.ScheduleDeliveryTime, msgQueueItem.ReceivedTimestamp)
+	if sendAfter.After(msgQueueItem.ReceivedTimestamp) {
+		msgQueueItem.SendAfter = sendAfter
+		if err := h.server.gateway.Router.holdScheduled(msgQueueItem); err != nil {
+			lm.SendLog(lm.BuildLog(
+				"Server.SMPP.HandleSubmitSM",
+				"ScheduleError",
+				logrus.ErrorLevel,
+				map[string]interface{}{
+					"client":    client.Username,
+					"username":  username,
+					"logID":     transId,
+					"traceID":   traceID,
+					"sendAfter": sendAfter,
+				}, err,
+			))
+			return false, err
+		}
+		return true, nil
+	}
+
 	// Compute conversation hash.
 	convoID := computeCorrelationKey(msgQueueItem.From, msgQueueItem.To)
 	// Add the message to the conversation manager.
 	h.server.gateway.ConvoManager.AddMessage(convoID, msgQueueItem, h.server.gateway.Router)
 
-	resp := submitSM.Resp()
-	if err := session.Send(resp); err != nil {
-		lm.SendLog(lm.BuildLog(
-			"Server.SMPP.HandleSubmitSM",
-			"SMPPPDUError",
-			logrus.ErrorLevel,
-			map[string]interface{}{
-				"ip":       session.Parent.RemoteAddr().String(),
-				"client":   client.Username,
-				"username": username,
-			}, err,
-		))
+	return true, nil
+}
+
+// decodeSubmitSMText decodes a short message payload according to its
+// data_coding, returning the text and the coding used. Binary payloads are
+// returned as hex; they are delivered from the raw bytes. Unsupported codings
+// are decoded as GSM7; the returned coding then differs from dataCoding.
+func decodeSubmitSMText(dataCoding coding.DataCoding, raw []byte) (decodedMsg string, encoding coding.DataCoding, decodeErr error) {
+	encoding = coding.GSM7BitCoding
+
+	switch dataCoding {
+	case 0:
+		decodedMsg, decodeErr = decodeUnpackedGSM7(raw)
+	case 1:
+		encoding = coding.ASCIICoding
+		decodedMsg, decodeErr = encoding.Encoding().NewDecoder().String(string(raw))
+	case 8:
+		encoding = coding.UCS2Coding
+		decodedMsg, decodeErr = encoding.Encoding().NewDecoder().String(string(raw))
+	case 3:
+		encoding = coding.Latin1Coding
+		decodedMsg, decodeErr = encoding.Encoding().NewDecoder().String(string(raw))
+	case 5:
+		encoding = coding.ShiftJISCoding
+		decodedMsg, decodeErr = encoding.Encoding().NewDecoder().String(string(raw))
+	case 6:
+		encoding = coding.CyrillicCoding
+		decodedMsg, decodeErr = encoding.Encoding().NewDecoder().String(string(raw))
+	case 7:
+		encoding = coding.HebrewCoding
+		decodedMsg, decodeErr = encoding.Encoding().NewDecoder().String(string(raw))
+	case 10:
+		encoding = coding.ISO2022JPCoding
+		decodedMsg, decodeErr = encoding.Encoding().NewDecoder().String(string(raw))
+	case 13:
+		encoding = coding.EUCJPCoding
+		decodedMsg, decodeErr = encoding.Encoding().NewDecoder().String(string(raw))
+	case 14:
+		encoding = coding.EUCKRCoding
+		decodedMsg, decodeErr = encoding.Encoding().NewDecoder().String(string(raw))
+	case 2, 4:
+		encoding = dataCoding
+		decodedMsg = hex.EncodeToString(raw)
+	default:
+		decodedMsg, decodeErr = decodeUnpackedGSM7(raw)
 	}
+	return
 }
 
 func (h *SimpleHandler) handleDeliverSM(session *smpp.Session, deliverSM *pdu.DeliverSM) {
@@ -840,6 +1301,19 @@ var replacementMap = map[rune]string{
 	'\u2013': "-",   // En dash replaced with hyphen
 	'\u2014': "-",   // Em dash replaced with hyphen
 	'\u2026': "...", // Ellipsis replaced with three dots
+	'\u201E': "\"",  // Double low-9 quotation mark
+	'\u00AB': "\"",  // Left-pointing double angle quotation mark
+	'\u00BB': "\"",  // Right-pointing double angle quotation mark
+	'\u2032': "'",   // Prime
+	'\u00B4': "'",   // Acute accent
+	'`':      "'",   // Grave accent
+	'\u00A0': " ",   // No-break space
+	'\t':     " ",   // Tab
+	'\u2022': "-",   // Bullet
+	'\u0153': "oe",  // Latin small ligature oe
+	'\u0152': "OE",  // Latin capital ligature OE
+	'\u0142': "l",   // Latin small letter l with stroke
+	'\u0141': "L",   // Latin capital letter L with stroke
 }
 
 // isEmoji returns true if the rune falls within common emoji ranges.
@@ -928,6 +1402,8 @@ func dataCodingName(dc coding.DataCoding) string {
 		return "EUCJP"
 	case coding.EUCKRCoding:
 		return "EUCKR"
+	case 0x02, 0x04:
+		return "Binary"
 	default:
 		return fmt.Sprintf("Unknown(%d)", dc)
 	}
@@ -935,7 +1411,9 @@ func dataCodingName(dc coding.DataCoding) string {
 
 // sendSMPP attempts to send an SMPPMessage via the SMPP server.
 // The session parameter should be a valid, already-looked-up session from the router.
-func (s *SMPPServer) sendSMPP(msg MsgQueueItem, session *smpp.Session) error {
+// It returns the message_id the message was delivered under, which the
+// receiving client can use with query_sm.
+func (s *SMPPServer) sendSMPP(msg MsgQueueItem, session *smpp.Session) (string, error) {
 	lm := s.gateway.LogManager
 
 	lm.SendLog(lm.BuildLog(
@@ -943,9 +1421,10 @@ func (s *SMPPServer) sendSMPP(msg MsgQueueItem, session *smpp.Session) error {
 		"AttemptSend",
 		logrus.DebugLevel,
 		map[string]interface{}{
-			"to":    msg.To,
-			"from":  msg.From,
-			"logID": msg.LogID,
+			"to":      msg.To,
+			"from":    msg.From,
+			"logID":   msg.LogID,
+			"traceID": msg.TraceID,
 		},
 	))
 
@@ -956,11 +1435,12 @@ func (s *SMPPServer) sendSMPP(msg MsgQueueItem, session *smpp.Session) error {
 			"NilSessionError",
 			logrus.ErrorLevel,
 			map[string]interface{}{
-				"to":    msg.To,
-				"logID": msg.LogID,
+				"to":      msg.To,
+				"logID":   msg.LogID,
+				"traceID": msg.TraceID,
 			},
 		))
-		return fmt.Errorf("session is nil for destination: %s", msg.To)
+		return "", fmt.Errorf("session is nil for destination: %s", msg.To)
 	}
 
 	username, client := s.getSessionClientInfo(session)
@@ -968,6 +1448,7 @@ func (s *SMPPServer) sendSMPP(msg MsgQueueItem, session *smpp.Session) error {
 	if client != nil {
 		clientName = client.Username
 	}
+	responseTimeout := s.gateway.smppKeepalive(client).ResponseTimeout
 
 	if msg.From == "" || msg.To == "" {
 		lm.SendLog(lm.BuildLog(
@@ -979,72 +1460,218 @@ func (s *SMPPServer) sendSMPP(msg MsgQueueItem, session *smpp.Session) error {
 				"username": username,
 				"client":   clientName,
 				"logID":    msg.LogID,
+				"traceID":  msg.TraceID,
 			},
 		))
-		return fmt.Errorf("destination cannot be empty")
+		return "", fmt.Errorf("destination cannot be empty")
 	}
 
 	nextSeq := session.NextSequence
 
-	// Determine best encoding + segmenting
-	bestCoding := coding.BestSafeCoding(msg.message)
-	segments := coding.SplitSMS(msg.message, byte(bestCoding))
-	encoder := bestCoding.Encoding().NewEncoder()
-
-	var err error
-	for i, segment := range segments {
-		var encoded []byte
-		if bestCoding == coding.GSM7BitCoding {
-			encoded, err = encodeUnpackedGSM7(segment)
-			if err != nil {
+	// Apply the client's coding policy, then segment. Binary payloads are
+	// sent as-is in a single deliver_sm.
+	var (
+		parts      []outboundSegment
+		bestCoding coding.DataCoding
+		err        error
+	)
+	if msg.binary != nil {
+		var part outboundSegment
+		bestCoding = msg.binaryCoding
+		part, err = composeBinaryDeliverSM(msg.From, msg.To, msg)
+		parts = []outboundSegment{part}
+	} else {
+		lang := smsLanguage(client)
+		text, dc := applySMSCodingLang(smsCodingPolicy(client), msg.message, s.gateway.Config.GSM7Replacements, lang)
+		parts, bestCoding, err = composeDeliverSMsLang(msg.From, msg.To, text, dc, lang, func() uint16 {
+			return s.segmentRefs.next(session)
+		})
+	}
+	if err != nil {
+		lm.SendLog(lm.BuildLog(
+			"Server.SMPP.sendSMPP",
+			"EncodingError",
+			logrus.ErrorLevel,
+			map[string]interface{}{
+				"to":       msg.To,
+				"from":     msg.From,
+				"encoding": dataCodingName(bestCoding),
+				"username": username,
+				"client":   clientName,
+				"logID":    msg.LogID,
+				"traceID":  msg.TraceID,
+			}, err,
+		))
+		return "", err
+	}
+	// Every segment carries the same message_id, recorded for the receiving
+	// client's query_sm until the last segment is acknowledged.
+	messageID := primitive.NewObjectID().Hex()
+	numbering := smppNumberingFor(client)
+	for _, part := range parts {
+		applySMPPPassthrough(part.pdu, msg)
+		applySMPPNumbering(part.pdu, numbering)
+		setOutboundMessageID(part.pdu, messageID)
+	}
+	s.submitStates.delivering(messageID, username, msg.LogID)
+	delivered := false
+	defer func() {
+		if delivered {
+			s.submitStates.finish(messageID, DLRStatDelivered, DLRErrNone)
+		} else {
+			s.submitStates.finish(messageID, DLRStatUndeliverable, DLRErrCarrierFailed)
+		}
+	}()
+
+	// Up to the session's window of segments may be awaiting deliver_sm_resp
+	// at once. Segments always go out in order; if the window is full we wait
+	// on our own oldest segment first, then for a slot freed by other sends.
+	window := s.windows.get(session, s.gateway.smppWindowSize(client))
+	var inflight []inflightSegment
+	defer func() {
+		for _, f := range inflight {
+			s.removePendingAck(f.seq)
+			window.release()
+		}
+	}()
+
+	udhLen := func(deliverSM *pdu.DeliverSM) int {
+		if deliverSM.Message.UDHeader != nil {
+			return deliverSM.Message.UDHeader.Len()
+		}
+		return 0
+	}
+
+	awaitAck := func(f inflightSegment) error {
+		defer window.release()
+		encoded := f.pdu.Message.Message
+
+		timer := time.NewTimer(time.Until(f.deadline))
+		defer timer.Stop()
+
+		select {
+		case respPDU := <-f.ackCh:
+			if respPDU.Header.CommandStatus != 0 {
 				lm.SendLog(lm.BuildLog(
 					"Server.SMPP.sendSMPP",
-					"GSM7EncodeError",
+					"AckNonOK",
 					logrus.ErrorLevel,
 					map[string]interface{}{
-						"to":       msg.To,
-						"from":     msg.From,
-						"segment":  i,
-						"username": username,
-						"client":   clientName,
-						"logID":    msg.LogID,
-					}, err,
+						"to":                msg.To,
+						"from":              msg.From,
+						"sequence":          f.seq,
+						"commandStatus":     respPDU.Header.CommandStatus,
+						"commandStatusName": respPDU.Header.CommandStatus.String(),
+						"username":          username,
+						"client":            clientName,
+						"encoding":          dataCodingName(bestCoding),
+						"dataCoding":        bestCoding,
+						"encodedByteLen":    len(encoded),
+						"encodedHex":        fmt.Sprintf("%x", encoded),
+						"originalMessage":   msg.message,
+						"segment":           f.text,
+						"segmentIndex":      f.index + 1,
+						"totalSegments":     len(parts),
+						"logID":             msg.LogID,
+						"traceID":           msg.TraceID,
+						"segmentCharLen":    len(f.text),
+						"udhPresent":        f.pdu.Message.UDHeader != nil,
+						"udhLen":            udhLen(f.pdu),
+					},
 				))
-				return fmt.Errorf("GSM7 encode error: %w", err)
+				return fmt.Errorf("non-OK response for sequence %d: %s (%d)", f.seq, respPDU.Header.CommandStatus.String(), respPDU.Header.CommandStatus)
 			}
-			//encoded = packSeptets(encoded)
-		} else {
-			encoded, err = encoder.Bytes([]byte(segment))
-			if err != nil {
+			lm.SendLog(lm.BuildLog(
+				"Server.SMPP.sendSMPP",
+				"AckOK",
+				logrus.DebugLevel,
+				map[string]interface{}{
+					"ip":              session.Parent.RemoteAddr().String(),
+					"sequence":        f.seq,
+					"to":              msg.To,
+					"from":            msg.From,
+					"username":        username,
+					"client":          clientName,
+					"logID":           msg.LogID,
+					"traceID":         msg.TraceID,
+					"messageID":       messageID,
+					"clientMessageID": respPDU.MessageID,
+				},
+			))
+			return nil
+		case <-timer.C:
+			s.removePendingAck(f.seq)
+			lm.SendLog(lm.BuildLog(
+				"Server.SMPP.sendSMPP",
+				"AckTimeout",
+				logrus.WarnLevel,
+				map[string]interface{}{
+					"to":              msg.To,
+					"from":            msg.From,
+					"sequence":        f.seq,
+					"username":        username,
+					"client":          clientName,
+					"encoding":        dataCodingName(bestCoding),
+					"dataCoding":      bestCoding,
+					"encodedByteLen":  len(encoded),
+					"encodedHex":      fmt.Sprintf("%x", encoded),
+					"originalMessage": msg.message,
+					"segment":         f.text,
+					"segmentIndex":    f.index + 1,
+					"totalSegments":   len(parts),
+					"logID":           msg.LogID,
+					"traceID":         msg.TraceID,
+					"segmentCharLen":  len(f.text),
+					"udhPresent":      f.pdu.Message.UDHeader != nil,
+					"udhLen":          udhLen(f.pdu),
+				},
+			))
+			return fmt.Errorf("timeout waiting for ack for sequence %d", f.seq)
+		}
+	}
+
+	// awaitOldest waits for the earliest outstanding segment of this message.
+	awaitOldest := func() error {
+		f := inflight[0]
+		inflight = inflight[1:]
+		return awaitAck(f)
+	}
+
+	for i, part := range parts {
+		segment := part.text
+		deliverSM := part.pdu
+		encoded := deliverSM.Message.Message
+
+		for !window.tryAcquire() {
+			if len(inflight) > 0 {
+				if err := awaitOldest(); err != nil {
+					return "", err
+				}
+				continue
+			}
+			if !window.acquire(responseTimeout) {
 				lm.SendLog(lm.BuildLog(
 					"Server.SMPP.sendSMPP",
-					"EncodingError",
-					logrus.ErrorLevel,
+					"WindowFull",
+					logrus.WarnLevel,
 					map[string]interface{}{
-						"to":       msg.To,
-						"from":     msg.From,
-						"segment":  i,
-						"username": username,
-						"client":   clientName,
-						"logID":    msg.LogID,
-					}, err,
+						"to":            msg.To,
+						"from":          msg.From,
+						"username":      username,
+						"client":        clientName,
+						"segmentIndex":  i + 1,
+						"totalSegments": len(parts),
+						"logID":         msg.LogID,
+						"traceID":       msg.TraceID,
+					},
 				))
-				return fmt.Errorf("encoding error: %w", err)
+				return "", fmt.Errorf("timeout waiting for a free SMPP window slot")
 			}
+			break
 		}
 
 		seq := nextSeq()
-		deliverSM := &pdu.DeliverSM{
-			SourceAddr: pdu.Address{TON: 0x01, NPI: 0x01, No: msg.From},
-			DestAddr:   pdu.Address{TON: 0x01, NPI: 0x01, No: msg.To},
-			Message:    pdu.ShortMessage{Message: encoded, DataCoding: bestCoding},
-			RegisteredDelivery: pdu.RegisteredDelivery{
-				MCDeliveryReceipt: 1,
-			},
-			Header: pdu.Header{
-				Sequence: seq,
-			},
-		}
+		deliverSM.Header.Sequence = seq
 
 		lm.SendLog(lm.BuildLog(
 			"Server.SMPP.sendSMPP",
@@ -1055,29 +1682,26 @@ func (s *SMPPServer) sendSMPP(msg MsgQueueItem, session *smpp.Session) error {
 				"from":                  msg.From,
 				"segment":               i,
 				"sequence":              seq,
-				"num_parts":             len(segments),
+				"num_parts":             len(parts),
 				"username":              username,
 				"client":                clientName,
 				"logID":                 msg.LogID,
+				"traceID":               msg.TraceID,
 				"encoding":              dataCodingName(bestCoding),
 				"dataCoding":            bestCoding,
 				"segmentCharLen":        len(segment),
 				"segmentEncodedByteLen": len(encoded),
 				"segmentEncodedHex":     fmt.Sprintf("%x", encoded),
-				"totalSegments":         len(segments),
+				"totalSegments":         len(parts),
 				"udhPresent":            deliverSM.Message.UDHeader != nil,
-				"udhLen": func() int {
-					if deliverSM.Message.UDHeader != nil {
-						return deliverSM.Message.UDHeader.Len()
-					}
-					return 0
-				}(),
+				"udhLen":                udhLen(deliverSM),
 			},
 		))
 
 		ackCh := s.addPendingAck(seq)
-		if err := session.Send(deliverSM); err != nil {
+		if err := session.Send(outboundPDU(client, deliverSM)); err != nil {
 			s.removePendingAck(seq)
+			window.release()
 			lm.SendLog(lm.BuildLog(
 				"Server.SMPP.sendSMPP",
 				"SendError",
@@ -1095,107 +1719,35 @@ func (s *SMPPServer) sendSMPP(msg MsgQueueItem, session *smpp.Session) error {
 					"originalMessage": msg.message,
 					"segment":         segment,
 					"segmentIndex":    i + 1,
-					"totalSegments":   len(segments),
+					"totalSegments":   len(parts),
 					"logID":           msg.LogID,
+					"traceID":         msg.TraceID,
 					"segmentCharLen":  len(segment),
 					"udhPresent":      deliverSM.Message.UDHeader != nil,
-					"udhLen": func() int {
-						if deliverSM.Message.UDHeader != nil {
-							return deliverSM.Message.UDHeader.Len()
-						}
-						return 0
-					}(),
+					"udhLen":          udhLen(deliverSM),
 				}, err,
 			))
-			return fmt.Errorf("error sending SubmitSM: %v", err)
+			return "", fmt.Errorf("error sending SubmitSM: %v", err)
 		}
 
-		select {
-		case respPDU := <-ackCh:
-			if respPDU.Header.CommandStatus != 0 {
-				lm.SendLog(lm.BuildLog(
-					"Server.SMPP.sendSMPP",
-					"AckNonOK",
-					logrus.ErrorLevel,
-					map[string]interface{}{
-						"to":                msg.To,
-						"from":              msg.From,
-						"sequence":          seq,
-						"commandStatus":     respPDU.Header.CommandStatus,
-						"commandStatusName": respPDU.Header.CommandStatus.String(),
-						"username":          username,
-						"client":            clientName,
-						"encoding":          dataCodingName(bestCoding),
-						"dataCoding":        bestCoding,
-						"encodedByteLen":    len(encoded),
-						"encodedHex":        fmt.Sprintf("%x", encoded),
-						"originalMessage":   msg.message,
-						"segment":           segment,
-						"segmentIndex":      i + 1,
-						"totalSegments":     len(segments),
-						"logID":             msg.LogID,
-						"segmentCharLen":    len(segment),
-						"udhPresent":        deliverSM.Message.UDHeader != nil,
-						"udhLen": func() int {
-							if deliverSM.Message.UDHeader != nil {
-								return deliverSM.Message.UDHeader.Len()
-							}
-							return 0
-						}(),
-					},
-				))
-				return fmt.Errorf("non-OK response for sequence %d: %s (%d)", seq, respPDU.Header.CommandStatus.String(), respPDU.Header.CommandStatus)
-			}
-			lm.SendLog(lm.BuildLog(
-				"Server.SMPP.sendSMPP",
-				"AckOK",
-				logrus.DebugLevel,
-				map[string]interface{}{
-					"ip":       session.Parent.RemoteAddr().String(),
-					"sequence": seq,
-					"to":       msg.To,
-					"from":     msg.From,
-					"username": username,
-					"client":   clientName,
-					"logID":    msg.LogID,
-				},
-			))
-		case <-time.After(5 * time.Second):
-			s.removePendingAck(seq)
-			lm.SendLog(lm.BuildLog(
-				"Server.SMPP.sendSMPP",
-				"AckTimeout",
-				logrus.WarnLevel,
-				map[string]interface{}{
-					"to":              msg.To,
-					"from":            msg.From,
-					"sequence":        seq,
-					"username":        username,
-					"client":          clientName,
-					"encoding":        dataCodingName(bestCoding),
-					"dataCoding":      bestCoding,
-					"encodedByteLen":  len(encoded),
-					"encodedHex":      fmt.Sprintf("%x", encoded),
-					"originalMessage": msg.message,
-					"segment":         segment,
-					"segmentIndex":    i + 1,
-					"totalSegments":   len(segments),
-					"logID":           msg.LogID,
-					"segmentCharLen":  len(segment),
-					"udhPresent":      deliverSM.Message.UDHeader != nil,
-					"udhLen": func() int {
-						if deliverSM.Message.UDHeader != nil {
-							return deliverSM.Message.UDHeader.Len()
-						}
-						return 0
-					}(),
-				},
-			))
-			return fmt.Errorf("timeout waiting for ack for sequence %d", seq)
+		inflight = append(inflight, inflightSegment{
+			index:    i,
+			seq:      seq,
+			text:     segment,
+			pdu:      deliverSM,
+			ackCh:    ackCh,
+			deadline: time.Now().Add(responseTimeout),
+		})
+	}
+
+	for len(inflight) > 0 {
+		if err := awaitOldest(); err != nil {
+			return "", err
 		}
 	}
 
-	return nil
+	delivered = true
+	return messageID, nil
 }
 
 func (srv *SMPPServer) findSmppSession(destination string) (*smpp.Session, error) {
@@ -1321,3 +1873,38 @@ func (srv *SMPPServer) isSessionActive(username string) bool {
 	_, ok := srv.conns[username]
 	return ok
 }
+
+// disconnectClient unbinds and closes the live session for username, if
+// any, and reports whether there was one. The lock is held until the session
+// is gone, so the client cannot rebind in the meantime.
+func (srv *SMPPServer) disconnectClient(username string) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	session, ok := srv.conns[username]
+	if !ok {
+		return false
+	}
+
+	if err := session.Close(context.Background()); err != nil {
+		_ = session.Parent.Close()
+	}
+	delete(srv.conns, username)
+	if srv.segmentRefs != nil {
+		srv.segmentRefs.remove(session)
+	}
+	if srv.windows != nil {
+		srv.windows.remove(session)
+	}
+
+	lm := srv.gateway.LogManager
+	lm.SendLog(lm.BuildLog(
+		"Server.SMPP.DisconnectClient",
+		"SessionDisconnected",
+		logrus.InfoLevel,
+		map[string]interface{}{
+			"username": username,
+		},
+	))
+	return true
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+	"zultys-smpp-mm4/smpp"
+	"zultys-smpp-mm4/smpp/coding"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSMPPServer_DisconnectClient(t *testing.T) {
+	_, gw := newTestRouter(1)
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	session := smpp.NewSession(context.Background(), serverConn)
+	srv := &SMPPServer{
+		gateway: gw,
+		conns:   map[string]*smpp.Session{"pbx1": session},
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(io.Discard, clientConn)
+		close(closed)
+	}()
+
+	assert.True(t, srv.disconnectClient("pbx1"))
+	assert.False(t, srv.isSessionActive("pbx1"))
+
+	select {
+	case <-closed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("connection was not closed")
+	}
+
+	// Unknown clients are a no-op.
+	assert.False(t, srv.disconnectClient("nobody"))
+}
+
+func TestDecodeSubmitSMText_Latin1(t *testing.T) {
+	// "Señor café" in ISO-8859-1: ñ = 0xF1, é = 0xE9.
+	raw := []byte{'S', 'e', 0xF1, 'o', 'r', ' ', 'c', 'a', 'f', 0xE9}
+
+	text, encoding, err := decodeSubmitSMText(coding.Latin1Coding, raw)
+	assert.NoError(t, err)
+	assert.Equal(t, coding.Latin1Coding, encoding)
+	assert.Equal(t, "Señor café", text)
+}
+
+func TestDecodeSubmitSMText_UnknownCodingFallsBack(t *testing.T) {
+	// 0xF0 (GSM class bits) is not handled; the payload is read as GSM7 and
+	// the differing coding tells the caller to log it.
+	text, encoding, err := decodeSubmitSMText(coding.DataCoding(0xF0), []byte("hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, coding.GSM7BitCoding, encoding)
+	assert.NotEqual(t, coding.DataCoding(0xF0), encoding)
+	assert.Equal(t, "hello", text)
+}
+
+func TestDecodeSubmitSMText_BinaryAsHex(t *testing.T) {
+	text, encoding, err := decodeSubmitSMText(coding.DataCoding(0x04), []byte{0xAE, 0x81})
+	assert.NoError(t, err)
+	assert.Equal(t, coding.DataCoding(0x04), encoding)
+	assert.Equal(t, "ae81", text)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"time"
+	"zultys-smpp-mm4/smpp"
+	"zultys-smpp-mm4/smpp/pdu"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// submitMultiAsSubmitSM returns the submit_sm a submit_multi amounts to for
+// one of its destination addresses.
+func submitMultiAsSubmitSM(submitMulti *pdu.SubmitMulti, dest pdu.Address) *pdu.SubmitSM {
+	return &pdu.SubmitSM{
+		Header:               submitMulti.Header,
+		ServiceType:          submitMulti.ServiceType,
+		SourceAddr:           submitMulti.SourceAddr,
+		DestAddr:             dest,
+		ESMClass:             submitMulti.ESMClass,
+		ProtocolID:           submitMulti.ProtocolID,
+		PriorityFlag:         submitMulti.PriorityFlag,
+		ScheduleDeliveryTime: submitMulti.ScheduleDeliveryTime,
+		ValidityPeriod:       submitMulti.ValidityPeriod,
+		RegisteredDelivery:   submitMulti.RegisteredDelivery,
+		ReplaceIfPresent:     submitMulti.ReplaceIfPresent,
+		Message:              submitMulti.Message,
+		Tags:                 submitMulti.Tags,
+	}
+}
+
+// expandSubmitMulti splits the destinations of a submit_multi into one
+// submit_sm per valid address, and an unsuccessful record for each address
+// that fails validate and each distribution list. The gateway keeps no
+// distribution lists, so every list name is reported as unknown.
+func expandSubmitMulti(submitMulti *pdu.SubmitMulti, validate func(dest string) error) ([]*pdu.SubmitSM, pdu.UnsuccessfulRecords) {
+	var (
+		submits []*pdu.SubmitSM
+		failed  = pdu.UnsuccessfulRecords{}
+	)
+	for _, dest := range submitMulti.DestAddrList.Addresses {
+		if err := validate(dest.String()); err != nil {
+			failed = append(failed, pdu.UnsuccessfulRecord{DestAddr: dest, ErrorStatusCode: pdu.ESME_RINVDSTADR})
+			continue
+		}
+		submits = append(submits, submitMultiAsSubmitSM(submitMulti, dest))
+	}
+	for _, list := range submitMulti.DestAddrList.DistributionList {
+		failed = append(failed, pdu.UnsuccessfulRecord{DestAddr: pdu.Address{No: list}, ErrorStatusCode: pdu.ESME_RINVDLNAME})
+	}
+	return submits, failed
+}
+
+// handleSubmitMulti accepts a submit_multi from a bound client as it would a
+// submit_sm to each of its destinations. Each destination is logged and
+// routed as a message of its own; the submit_multi_resp carries one message
+// ID for all of them, which their delivery receipts also carry and which
+// query_sm reports once they are all final, and lists the destinations that
+// were refused. Concatenated segments go through
+// reassembly per destination, whose receipts carry the destination's own
+// log ID as they would for submit_sm.
+func (h *SimpleHandler) handleSubmitMulti(session *smpp.Session, submitMulti *pdu.SubmitMulti) {
+	messageID := primitive.NewObjectID().Hex()
+	traceID := newTraceID()
+	lm := h.server.gateway.LogManager
+
+	respond := func(status pdu.CommandStatus, messageID string, failed pdu.UnsuccessfulRecords) {
+		resp := submitMulti.Resp().(*pdu.SubmitMultiResp)
+		resp.Header.CommandStatus = status
+		resp.MessageID = messageID
+		resp.UnsuccessfulSMEs = failed
+		if err := session.Send(resp); err != nil {
+			lm.SendLog(lm.BuildLog(
+				"Server.SMPP.HandleSubmitMulti",
+				"SMPPPDUError",
+				logrus.ErrorLevel,
+				map[string]interface{}{
+					"ip":    session.Parent.RemoteAddr().String(),
+					"logID": messageID,
+				}, err,
+			))
+		}
+	}
+
+	username, client := h.server.getSessionClientInfo(session)
+	if client == nil {
+		lm.SendLog(lm.BuildLog(
+			"Server.SMPP.HandleSubmitMulti",
+			"SMPPUnknownOrUnauthedSession",
+			logrus.ErrorLevel,
+			map[string]interface{}{
+				"ip": session.Parent.RemoteAddr().String(),
+			},
+		))
+		respond(pdu.ESME_RINVBNDSTS, "", nil)
+		return
+	}
+
+	fields := map[string]interface{}{
+		"messageID":    messageID,
+		"traceID":      traceID,
+		"ip":           session.Parent.RemoteAddr().String(),
+		"client":       client.Username,
+		"username":     username,
+		"from":         submitMulti.SourceAddr.String(),
+		"destinations": len(submitMulti.DestAddrList.Addresses),
+		"lists":        len(submitMulti.DestAddrList.DistributionList),
+		"sequence":     submitMulti.Header.Sequence,
+	}
+
+	if rate := h.server.gateway.submitRateLimit(client); !h.server.submitLimiter.allow(username, rate) {
+		fields["rateLimit"] = rate
+		lm.SendLog(lm.BuildLog("Server.SMPP.HandleSubmitMulti", "SubmitThrottled", logrus.WarnLevel, fields))
+		respond(pdu.ESME_RTHROTTLED, "", nil)
+		return
+	}
+
+	if _, err := parseScheduleDeliveryTime(submitMulti.ScheduleDeliveryTime, time.Now()); err != nil {
+		lm.SendLog(lm.BuildLog("Server.SMPP.HandleSubmitMulti", "InvalidScheduleDeliveryTime", logrus.WarnLevel, fields, err))
+		respond(pdu.ESME_RINVSCHED, "", nil)
+		return
+	}
+
+	submits, failed := expandSubmitMulti(submitMulti, h.server.gateway.validateClientDest)
+	fields["accepted"] = len(submits)
+	fields["refused"] = len(failed)
+	if len(submits) == 0 {
+		lm.SendLog(lm.BuildLog("Server.SMPP.HandleSubmitMulti", "NoValidDestination", logrus.WarnLevel, fields))
+		respond(pdu.ESME_RINVDSTADR, "", failed)
+		return
+	}
+
+	decodedMsg, encoding, _ := decodeSubmitSMText(submitMulti.Message.DataCoding, submitMulti.Message.Message)
+	if encoding != submitMulti.Message.DataCoding {
+		fields["dataCoding"] = byte(submitMulti.Message.DataCoding)
+		fields["fallback"] = dataCodingName(encoding)
+		lm.SendLog(lm.BuildLog("Server.SMPP.HandleSubmitMulti", "UnknownDataCoding", logrus.WarnLevel, fields))
+	}
+	lm.SendLog(lm.BuildLog("Server.SMPP.HandleSubmitMulti", "InboundSubmitMulti", logrus.InfoLevel, fields))
+
+	state := msgStateEnroute
+	now := time.Now()
+	if sendAfter, _ := parseScheduleDeliveryTime(submitMulti.ScheduleDeliveryTime, now); sendAfter.After(now) {
+		state = msgStateScheduled
+	}
+	// messageID is final once every destination's message is.
+	h.server.submitStates.submitted(messageID, username, state)
+
+	held := 0
+	for _, submitSM := range submits {
+		transId := primitive.NewObjectID().Hex()
+		if header := concatHeader(submitSM); header != nil {
+			// Segments are reassembled per destination, as they would be had
+			// they arrived as submit_sm.
+			key := concatKey{
+				username: username,
+				source:   submitSM.SourceAddr.String(),
+				dest:     submitSM.DestAddr.String(),
+				ref:      header.Reference,
+			}
+			firstID := h.server.concat.add(key, header, submitSM, session, client, transId, traceID)
+			h.server.submitStates.link(firstID, messageID)
+			held++
+			continue
+		}
+		if decodedMsg == "" {
+			held++
+			continue
+		}
+		// Linked before it is queued, so a fast receipt is not missed. A
+		// destination whose scheduled message could not be stored is
+		// reported as unsuccessful.
+		h.server.submitStates.link(transId, messageID)
+		queued, err := h.enqueueSubmitSMAs(session, client, username, transId, messageID, traceID, submitSM, decodedMsg, 1)
+		if !queued {
+			h.server.submitStates.unlink(transId, messageID)
+		}
+		if err != nil {
+			failed = append(failed, pdu.UnsuccessfulRecord{DestAddr: submitSM.DestAddr, ErrorStatusCode: pdu.ESME_RSYSERR})
+			continue
+		}
+		held++
+	}
+	if held == 0 {
+		respond(pdu.ESME_RSYSERR, "", failed)
+		return
+	}
+
+	respond(pdu.ESME_ROK, messageID, failed)
+}
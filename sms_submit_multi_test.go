@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"net"
+	"testing"
+	"zultys-smpp-mm4/smpp"
+	"zultys-smpp-mm4/smpp/pdu"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// submitMultiSample is the submit_multi of the pdu package's packet tests:
+// one address, Bob1, and the distribution lists List1 and List2.
+const submitMultiSample = "0000006D00000021000000000000000D585858000D0F416C6963650003010000426F623100024C6973743100024C69737432000D633D00001300080030006E006700681EAF0020006E00670068006900EA006E00670020006E0067006800691EC5006E00670020006E00671EA3"
+
+func TestExpandSubmitMulti_Sample(t *testing.T) {
+	raw, err := hex.DecodeString(submitMultiSample)
+	require.NoError(t, err)
+	packet, err := pdu.Unmarshal(bytes.NewReader(raw))
+	require.NoError(t, err)
+	submitMulti, ok := packet.(*pdu.SubmitMulti)
+	require.True(t, ok)
+
+	accept := func(string) error { return nil }
+	submits, failed := expandSubmitMulti(submitMulti, accept)
+	require.Len(t, submits, 1)
+	assert.Equal(t, pdu.Address{No: "Bob1"}, submits[0].DestAddr)
+	assert.Equal(t, submitMulti.SourceAddr, submits[0].SourceAddr)
+	assert.Equal(t, submitMulti.Message, submits[0].Message)
+	assert.Equal(t, submitMulti.RegisteredDelivery, submits[0].RegisteredDelivery)
+	assert.Equal(t, int32(13), submits[0].Header.Sequence)
+	assert.Equal(t, pdu.UnsuccessfulRecords{
+		{DestAddr: pdu.Address{No: "List1"}, ErrorStatusCode: pdu.ESME_RINVDLNAME},
+		{DestAddr: pdu.Address{No: "List2"}, ErrorStatusCode: pdu.ESME_RINVDLNAME},
+	}, failed, "the gateway keeps no distribution lists")
+
+	e164 := func(dest string) error { _, err := FormatToE164(dest); return err }
+	submits, failed = expandSubmitMulti(submitMulti, e164)
+	assert.Empty(t, submits)
+	require.Len(t, failed, 3)
+	assert.Equal(t, pdu.UnsuccessfulRecord{DestAddr: pdu.Address{No: "Bob1"}, ErrorStatusCode: pdu.ESME_RINVDSTADR}, failed[0])
+}
+
+func TestHandleSubmitMulti_FanOut(t *testing.T) {
+	r, gw := newTestRouter(1)
+	gw.ConvoManager = NewConvoManager()
+	gw.Clients = map[string]*Client{"pbx1": {Username: "pbx1"}}
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	session := smpp.NewSession(ctx, serverConn)
+
+	srv := &SMPPServer{
+		gateway:       gw,
+		conns:         map[string]*smpp.Session{"pbx1": session},
+		submitLimiter: newSubmitRateLimiter(),
+		submitStates:  &submitStates{},
+	}
+	gw.SMPPServer = srv
+	h := NewSimpleHandler(srv)
+
+	submitMulti := &pdu.SubmitMulti{
+		SourceAddr: pdu.Address{TON: 1, NPI: 1, No: "15551234567"},
+		DestAddrList: pdu.DestinationAddresses{
+			Addresses: []pdu.Address{
+				{TON: 1, NPI: 1, No: "15557654321"},
+				{TON: 1, NPI: 1, No: "15557654322"},
+			},
+			DistributionList: []string{"sales"},
+		},
+		RegisteredDelivery: pdu.RegisteredDelivery{MCDeliveryReceipt: 1},
+		Message:            pdu.ShortMessage{Message: []byte("hello all")},
+	}
+	submitMulti.Header.Sequence = 5
+
+	received := make(chan MsgQueueItem, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			received <- <-r.ClientMsgChan
+		}
+	}()
+
+	go h.handlePDU(session, submitMulti)
+	resp, ok := readTestPDU(t, clientConn).(*pdu.SubmitMultiResp)
+	require.True(t, ok)
+	assert.Equal(t, pdu.ESME_ROK, resp.Header.CommandStatus)
+	assert.Equal(t, int32(5), resp.Header.Sequence)
+	require.NotEmpty(t, resp.MessageID)
+	assert.Equal(t, pdu.UnsuccessfulRecords{
+		{DestAddr: pdu.Address{No: "sales"}, ErrorStatusCode: pdu.ESME_RINVDLNAME},
+	}, resp.UnsuccessfulSMEs)
+
+	var to []string
+	logIDs := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		msg := <-received
+		to = append(to, msg.To)
+		logIDs[msg.LogID] = true
+		assert.Equal(t, "+15551234567", msg.From)
+		assert.Equal(t, "hello all", msg.message)
+		if assert.NotNil(t, msg.DeliveryReceipt) {
+			assert.Equal(t, resp.MessageID, msg.DeliveryReceipt.MessageID, "receipts carry the submit_multi's message ID")
+		}
+	}
+	assert.ElementsMatch(t, []string{"+15557654321", "+15557654322"}, to)
+	assert.Len(t, logIDs, 2, "each destination is logged on its own")
+}
+
+func TestHandleSubmitMulti_ReceiptsThenQuerySM(t *testing.T) {
+	r, gw := newTestRouter(1)
+	gw.ConvoManager = NewConvoManager()
+	gw.Clients = map[string]*Client{"pbx1": {Username: "pbx1"}}
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	session := smpp.NewSession(ctx, serverConn)
+
+	srv := &SMPPServer{
+		gateway:       gw,
+		conns:         map[string]*smpp.Session{"pbx1": session},
+		submitLimiter: newSubmitRateLimiter(),
+		submitStates:  &submitStates{},
+	}
+	gw.SMPPServer = srv
+	h := NewSimpleHandler(srv)
+
+	source := pdu.Address{TON: 1, NPI: 1, No: "15551234567"}
+	submitMulti := &pdu.SubmitMulti{
+		SourceAddr: source,
+		DestAddrList: pdu.DestinationAddresses{
+			Addresses: []pdu.Address{
+				{TON: 1, NPI: 1, No: "15557654321"},
+				{TON: 1, NPI: 1, No: "15557654322"},
+			},
+		},
+		Message: pdu.ShortMessage{Message: []byte("hello all")},
+	}
+	submitMulti.Header.Sequence = 5
+
+	received := make(chan MsgQueueItem, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			received <- <-r.ClientMsgChan
+		}
+	}()
+
+	go h.handlePDU(session, submitMulti)
+	resp, ok := readTestPDU(t, clientConn).(*pdu.SubmitMultiResp)
+	require.True(t, ok)
+	require.NotEmpty(t, resp.MessageID)
+	first, second := <-received, <-received
+
+	query := func(seq int32) *pdu.QuerySMResp {
+		q := &pdu.QuerySM{MessageID: resp.MessageID, SourceAddr: source}
+		q.Header.Sequence = seq
+		go h.handlePDU(session, q)
+		resp, ok := readTestPDU(t, clientConn).(*pdu.QuerySMResp)
+		require.True(t, ok)
+		require.Equal(t, pdu.ESME_ROK, resp.Header.CommandStatus)
+		return resp
+	}
+
+	assert.Equal(t, msgStateEnroute, query(6).MessageState)
+
+	srv.sendDeliveryReceipt(&first, DLRStatDelivered, DLRErrNone)
+	assert.Equal(t, msgStateEnroute, query(7).MessageState, "one destination is still on its way")
+
+	srv.sendDeliveryReceipt(&second, DLRStatDelivered, DLRErrNone)
+	final := query(8)
+	assert.Equal(t, msgStateDelivered, final.MessageState)
+	assert.Len(t, final.FinalDate, 16)
+}
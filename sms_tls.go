@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"zultys-smpp-mm4/smpp"
+
+	"github.com/sirupsen/logrus"
+)
+
+// loadSMPPTLSConfig builds the server TLS config from PEM cert/key paths.
+func loadSMPPTLSConfig(certPath, keyPath string) (*tls.Config, error) {
+	if certPath == "" || keyPath == "" {
+		return nil, errors.New("SMPP_TLS_CERT and SMPP_TLS_KEY are required when SMPP_TLS_LISTEN is set")
+	}
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load SMPP TLS key pair: %w", err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// startTLS serves SMPP over TLS (SMPPS) on tlsListen. It uses the same
+// handler as the plaintext listener, so sessions share conns and the bind
+// and auth path.
+func (srv *SMPPServer) startTLS(tlsListen string, handler *SimpleHandler) {
+	lm := srv.gateway.LogManager
+
+	config, err := loadSMPPTLSConfig(srv.gateway.Config.SMPPTLSCert, srv.gateway.Config.SMPPTLSKey)
+	if err != nil {
+		lm.SendLog(lm.BuildLog(
+			"Server.SMPP.StartTLS",
+			"SMPPTLSConfigError",
+			logrus.FatalLevel,
+			map[string]interface{}{
+				"listen_addr": tlsListen,
+			}, err,
+		))
+		panic(err)
+	}
+	srv.TLS = config
+
+	lm.SendLog(lm.BuildLog(
+		"Server.SMPP.StartTLS",
+		"StartingSMPPTLSServer",
+		logrus.InfoLevel,
+		map[string]interface{}{
+			"listen_addr": tlsListen,
+		},
+	))
+
+	listener, err := smpp.Listen(tlsListen, srv.TLS)
+	if err != nil {
+		lm.SendLog(lm.BuildLog(
+			"Server.SMPP.StartTLS",
+			"SMPPServeTLSError",
+			logrus.FatalLevel,
+			map[string]interface{}{
+				"listen_addr": tlsListen,
+			}, err,
+		))
+		panic(err)
+	}
+	srv.mu.Lock()
+	srv.tlsListener = listener
+	srv.mu.Unlock()
+
+	go func() {
+		err := smpp.Serve(listener, handler, srv.gateway.Config.ProxyProtocol)
+		if err != nil && !srv.isClosing() {
+			lm.SendLog(lm.BuildLog(
+				"Server.SMPP.StartTLS",
+				"SMPPServeTLSError",
+				logrus.FatalLevel,
+				map[string]interface{}{
+					"listen_addr": tlsListen,
+				}, err,
+			))
+			panic(err)
+		}
+	}()
+}
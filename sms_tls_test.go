@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"zultys-smpp-mm4/smpp"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestKeyPair writes a self-signed localhost certificate and key.
+func writeTestKeyPair(t *testing.T) (certPath, keyPath string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certPath = filepath.Join(dir, "smpp.crt")
+	keyPath = filepath.Join(dir, "smpp.key")
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certPath, keyPath
+}
+
+func TestLoadSMPPTLSConfig_RequiresBothPaths(t *testing.T) {
+	_, err := loadSMPPTLSConfig("", "/tmp/key")
+	assert.Error(t, err)
+	_, err = loadSMPPTLSConfig("/tmp/cert", "")
+	assert.Error(t, err)
+	_, err = loadSMPPTLSConfig("/nonexistent/cert", "/nonexistent/key")
+	assert.Error(t, err)
+}
+
+func TestLoadSMPPTLSConfig_ServesTLS(t *testing.T) {
+	certPath, keyPath := writeTestKeyPair(t)
+	config, err := loadSMPPTLSConfig(certPath, keyPath)
+	require.NoError(t, err)
+	require.Len(t, config.Certificates, 1)
+
+	ln, err := smpp.Listen("127.0.0.1:0", config)
+	require.NoError(t, err)
+	defer ln.Close()
+
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			err = conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+		accepted <- err
+	}()
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	require.NoError(t, err)
+	conn.Close()
+	assert.NoError(t, <-accepted)
+}
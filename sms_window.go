@@ -0,0 +1,103 @@
+package main
+
+import (
+	"sync"
+	"time"
+	"zultys-smpp-mm4/smpp"
+	"zultys-smpp-mm4/smpp/pdu"
+)
+
+// smppWindow bounds the number of deliver_sm awaiting a deliver_sm_resp on
+// one session.
+type smppWindow struct {
+	slots chan struct{}
+}
+
+func newSMPPWindow(size int) *smppWindow {
+	if size < 1 {
+		size = 1
+	}
+	return &smppWindow{slots: make(chan struct{}, size)}
+}
+
+// tryAcquire takes a slot if one is free.
+func (w *smppWindow) tryAcquire() bool {
+	select {
+	case w.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// acquire waits up to timeout for a free slot.
+func (w *smppWindow) acquire(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case w.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (w *smppWindow) release() {
+	select {
+	case <-w.slots:
+	default:
+	}
+}
+
+// smppWindows holds the deliver_sm window of each bound session. A session's
+// window is sized when first used, so a changed window_size applies from the
+// client's next bind.
+type smppWindows struct {
+	mu      sync.Mutex
+	windows map[*smpp.Session]*smppWindow
+}
+
+func newSMPPWindows() *smppWindows {
+	return &smppWindows{windows: make(map[*smpp.Session]*smppWindow)}
+}
+
+func (w *smppWindows) get(session *smpp.Session, size int) *smppWindow {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	window, ok := w.windows[session]
+	if !ok {
+		window = newSMPPWindow(size)
+		w.windows[session] = window
+	}
+	return window
+}
+
+func (w *smppWindows) remove(session *smpp.Session) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.windows, session)
+}
+
+// inflightSegment is a deliver_sm that has been sent and is waiting for its
+// deliver_sm_resp.
+type inflightSegment struct {
+	index    int
+	seq      int32
+	text     string
+	pdu      *pdu.DeliverSM
+	ackCh    chan *pdu.DeliverSMResp
+	deadline time.Time
+}
+
+// smppWindowSize resolves how many deliver_sm may be outstanding on a
+// client's session: its own window_size, otherwise SMPP_WINDOW_SIZE.
+func (gateway *Gateway) smppWindowSize(client *Client) int {
+	size := gateway.Config.SMPPWindowSize
+	if client != nil && client.Settings != nil && client.Settings.WindowSize > 0 {
+		size = client.Settings.WindowSize
+	}
+	if size < 1 {
+		size = 1
+	}
+	return size
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"zultys-smpp-mm4/smpp"
+	"zultys-smpp-mm4/smpp/pdu"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSMPPWindow_Slots(t *testing.T) {
+	w := newSMPPWindow(2)
+	assert.True(t, w.tryAcquire())
+	assert.True(t, w.tryAcquire())
+	assert.False(t, w.tryAcquire(), "window of 2 is full")
+	assert.False(t, w.acquire(10*time.Millisecond))
+
+	w.release()
+	assert.True(t, w.acquire(10*time.Millisecond))
+
+	assert.Equal(t, 1, cap(newSMPPWindow(0).slots), "sizes below 1 are serial")
+}
+
+func TestGateway_SMPPWindowSize(t *testing.T) {
+	gw := &Gateway{Config: GatewayConfig{SMPPWindowSize: 4}}
+	assert.Equal(t, 4, gw.smppWindowSize(nil))
+	assert.Equal(t, 4, gw.smppWindowSize(&Client{Settings: &ClientSettings{}}))
+	assert.Equal(t, 10, gw.smppWindowSize(&Client{Settings: &ClientSettings{WindowSize: 10}}))
+
+	gw.Config.SMPPWindowSize = 0
+	assert.Equal(t, 1, gw.smppWindowSize(nil))
+}
+
+// smppPeer is the client end of a test SMPP session. It answers every
+// deliver_sm with a deliver_sm_resp after delay, and records each deliver_sm
+// and the concatenation part numbers in the order they arrived.
+type smppPeer struct {
+	srv     *SMPPServer
+	session *smpp.Session
+
+	mu        sync.Mutex
+	parts     []byte
+	delivered []*pdu.DeliverSM
+}
+
+func newSMPPPeer(t testing.TB, windowSize int, delay time.Duration, hold func(seq int32) bool) *smppPeer {
+	t.Helper()
+	_, gw := newTestRouter(1)
+	gw.Config.SMPPWindowSize = windowSize
+	gw.Clients = map[string]*Client{"pbx1": {Username: "pbx1"}}
+
+	serverConn, clientConn := net.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	session := smpp.NewSession(ctx, serverConn)
+	t.Cleanup(func() {
+		cancel()
+		_ = clientConn.Close()
+		_ = serverConn.Close()
+	})
+
+	srv := &SMPPServer{
+		gateway:     gw,
+		conns:       map[string]*smpp.Session{"pbx1": session},
+		pendingAcks: make(map[int32]chan *pdu.DeliverSMResp),
+		segmentRefs: newSegmentRefs(),
+		windows:     newSMPPWindows(),
+	}
+	peer := &smppPeer{srv: srv, session: session}
+
+	// Server side: route responses like handlePDU does.
+	go func() {
+		for packet := range session.PDU() {
+			if resp, ok := packet.(*pdu.DeliverSMResp); ok {
+				srv.resolvePendingAck(resp)
+			}
+		}
+	}()
+
+	// Client side: acknowledge each deliver_sm after delay.
+	var writeMu sync.Mutex
+	var held []int32
+	go func() {
+		for {
+			packet, err := pdu.Unmarshal(clientConn)
+			if err != nil {
+				return
+			}
+			sm, ok := packet.(*pdu.DeliverSM)
+			if !ok {
+				continue
+			}
+			peer.mu.Lock()
+			peer.delivered = append(peer.delivered, sm)
+			if h := sm.Message.UDHeader.ConcatenatedHeader(); h != nil {
+				peer.parts = append(peer.parts, h.Sequence)
+			}
+			peer.mu.Unlock()
+
+			ack := func(seq int32) {
+				writeMu.Lock()
+				defer writeMu.Unlock()
+				_, _ = pdu.Marshal(clientConn, &pdu.DeliverSMResp{Header: pdu.Header{Sequence: seq}})
+			}
+			seq := sm.Header.Sequence
+			if hold != nil && hold(seq) {
+				// Release held sequences newest first once the last one arrives.
+				held = append(held, seq)
+				continue
+			}
+			for i := len(held) - 1; i >= 0; i-- {
+				go ack(held[i])
+			}
+			held = nil
+			go func() {
+				time.Sleep(delay)
+				ack(seq)
+			}()
+		}
+	}()
+
+	return peer
+}
+
+func (p *smppPeer) send(text string) error {
+	_, err := p.srv.sendSMPP(MsgQueueItem{From: "14155559876", To: "12505551234", message: text, LogID: "w1"}, p.session)
+	return err
+}
+
+func TestSendSMPP_WindowedOutOfOrderAcks(t *testing.T) {
+	sent := 0
+	// Hold the first four parts so they are acknowledged in reverse order
+	// after the fifth arrives.
+	peer := newSMPPPeer(t, 8, 0, func(int32) bool {
+		sent++
+		return sent < 5
+	})
+
+	require.NoError(t, peer.send(strings.Repeat("windowed ", 80)))
+
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	assert.Equal(t, []byte{1, 2, 3, 4, 5}, peer.parts, "segments go out in order")
+}
+
+func TestSendSMPP_WindowTimesOutUnacked(t *testing.T) {
+	peer := newSMPPPeer(t, 4, 0, func(int32) bool { return true })
+	peer.srv.gateway.Config.SMPPResponseTimeoutSecs = 1
+
+	err := peer.send(strings.Repeat("windowed ", 40))
+	assert.ErrorContains(t, err, "timeout waiting for ack")
+
+	peer.srv.pendingAcksMu.Lock()
+	defer peer.srv.pendingAcksMu.Unlock()
+	assert.Empty(t, peer.srv.pendingAcks, "unacked sequences are cleaned up")
+}
+
+func benchmarkSendSMPP(b *testing.B, windowSize int) {
+	peer := newSMPPPeer(b, windowSize, 2*time.Millisecond, nil)
+	text := strings.Repeat("throughput ", 60) // 5 segments
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := peer.send(text); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkSendSMPP_Serial and BenchmarkSendSMPP_Window8 compare a
+// multi-part message over a link with a 2ms deliver_sm_resp round trip.
+func BenchmarkSendSMPP_Serial(b *testing.B)  { benchmarkSendSMPP(b, 1) }
+func BenchmarkSendSMPP_Window8(b *testing.B) { benchmarkSendSMPP(b, 8) }
@@ -55,6 +55,6 @@ func GetSMSSegmentCount(message string) int {
 		return 0
 	}
 	bestCoding := coding.BestSafeCoding(message)
-	segments := coding.SplitSMS(message, byte(bestCoding))
+	segments := splitOutboundSMS(message, bestCoding)
 	return len(segments)
 }
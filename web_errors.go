@@ -0,0 +1,58 @@
+package main
+
+import (
+	"github.com/google/uuid"
+	"github.com/kataras/iris/v12"
+)
+
+// Error codes returned in the "code" field of API error responses. They are
+// stable; the accompanying message is for humans and may change.
+const (
+	errCodeInvalidRequest   = "invalid_request"   // malformed body or failed validation
+	errCodeInvalidParameter = "invalid_parameter" // bad path or query parameter
+	errCodeUnauthorized     = "unauthorized"
+	errCodeForbidden        = "forbidden"
+	errCodeClientNotFound   = "client_not_found"
+	errCodeNumberNotFound   = "number_not_found"
+	errCodeCarrierNotFound  = "carrier_not_found"
+	errCodeRouteNotFound    = "route_not_found"
+	errCodeFailoverNotFound = "failover_not_found"
+	errCodeBlockNotFound    = "block_not_found"
+	errCodeMediaNotFound    = "media_not_found"
+	errCodeMessageNotFound  = "message_not_found"
+	errCodeBatchNotFound    = "batch_not_found"
+	errCodeAPIKeyNotFound   = "api_key_not_found"
+	errCodeCaptureNotFound  = "capture_not_found"
+	errCodeResendRefused    = "resend_refused"
+	errCodeCancelRefused    = "cancel_refused"
+	errCodeUnsupportedMedia = "unsupported_media_type"
+	errCodeInboundFailed    = "inbound_failed"
+	errCodeInternal         = "internal_error"
+)
+
+// apiErrorBody is the envelope of every error response:
+//
+//	{"error": {"code": "client_not_found", "message": "Client not found", "request_id": "..."}}
+type apiErrorBody struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// apiError writes an error response with the given HTTP status.
+func apiError(ctx iris.Context, status int, code, message string) {
+	ctx.StatusCode(status)
+	ctx.JSON(iris.Map{"error": apiErrorBody{
+		Code:      code,
+		Message:   message,
+		RequestID: ctx.Values().GetString("request_id"),
+	}})
+}
+
+// setRequestID tags the request with an ID, echoed in X-Request-ID and in
+// error responses so a failed call can be matched to the gateway logs.
+func setRequestID(ctx iris.Context) {
+	id := uuid.New().String()
+	ctx.Values().Set("request_id", id)
+	ctx.Header("X-Request-ID", id)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kataras/iris/v12"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeAPIError(t *testing.T, w *httptest.ResponseRecorder) apiErrorBody {
+	t.Helper()
+	var resp struct {
+		Error apiErrorBody `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp), w.Body.String())
+	return resp.Error
+}
+
+func TestAPIError_Envelope(t *testing.T) {
+	app := iris.New()
+	w := httptest.NewRecorder()
+	ctx := app.ContextPool.Acquire(w, httptest.NewRequest("GET", "/clients/9", nil))
+	setRequestID(ctx)
+
+	apiError(ctx, http.StatusNotFound, errCodeClientNotFound, "Client not found")
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	body := decodeAPIError(t, w)
+	assert.Equal(t, "client_not_found", body.Code)
+	assert.Equal(t, "Client not found", body.Message)
+	assert.NotEmpty(t, body.RequestID)
+	assert.Equal(t, w.Header().Get("X-Request-ID"), body.RequestID)
+}
+
+func TestWebInboundCarrier_UnknownCarrier(t *testing.T) {
+	_, gw := newTestRouter(1)
+	gw.CarrierUUIDs = map[string]Carrier{}
+
+	app := iris.New()
+	w := httptest.NewRecorder()
+	ctx := app.ContextPool.Acquire(w, httptest.NewRequest("POST", "/inbound/nope", nil))
+	ctx.Params().Set("carrier", "nope")
+
+	gw.webInboundCarrier(ctx)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "carrier_not_found", decodeAPIError(t, w).Code)
+}
+
+func TestUnauthorized_Envelope(t *testing.T) {
+	_, gw := newTestRouter(1)
+
+	app := iris.New()
+	w := httptest.NewRecorder()
+	ctx := app.ContextPool.Acquire(w, httptest.NewRequest("GET", "/clients", nil))
+
+	unauthorized(ctx, gw, "Authorization header missing")
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Basic realm="Restricted"`, w.Header().Get("WWW-Authenticate"))
+	body := decodeAPIError(t, w)
+	assert.Equal(t, "unauthorized", body.Code)
+	assert.Equal(t, "Authorization header missing", body.Message)
+}
+
+func TestWebMM4Capture_Disabled(t *testing.T) {
+	_, gw := newTestRouter(1)
+
+	app := iris.New()
+	w := httptest.NewRecorder()
+	ctx := app.ContextPool.Acquire(w, httptest.NewRequest("GET", "/debug/mm4/abc", nil))
+
+	gw.webMM4Capture(ctx)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "capture_not_found", decodeAPIError(t, w).Code)
+}
+
+func TestRequireBatchScope_Envelope(t *testing.T) {
+	app := iris.New()
+	w := httptest.NewRecorder()
+	ctx := app.ContextPool.Acquire(w, httptest.NewRequest("POST", "/messages/batch", nil))
+	ctx.Values().Set("api_key", &TenantAPIKey{Scopes: "send"})
+
+	assert.True(t, requireBatchScope(ctx))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "forbidden", decodeAPIError(t, w).Code)
+}
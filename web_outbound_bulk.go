@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kataras/iris/v12"
+	"github.com/sirupsen/logrus"
+)
+
+// bulkMessage is one entry of the POST /outbound/bulk body.
+type bulkMessage struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Message string `json:"message"`
+	Type    string `json:"type"` // "sms" (default) or "mms"
+}
+
+// bulkResult reports what happened to one entry, in request order.
+type bulkResult struct {
+	Index  int    `json:"index"`
+	Status string `json:"status"`          // "accepted" or "rejected"
+	ID     string `json:"id,omitempty"`    // BatchMessageItem ID, also the message's LogID
+	Error  string `json:"error,omitempty"` // why the message was rejected
+}
+
+// bulkResponse is returned by POST /outbound/bulk.
+type bulkResponse struct {
+	BatchID  string       `json:"batch_id"`
+	Accepted int          `json:"accepted"`
+	Rejected int          `json:"rejected"`
+	Messages []bulkResult `json:"messages"`
+}
+
+// webOutboundBulk accepts a JSON array of messages from one client. Every
+// entry is checked the way the router would check it, so undeliverable
+// messages are reported in the response instead of failing later. The
+// entries become a batch job, sent by processBatchJob at
+// BULK_TRICKLE_PER_SEC and reported by GET /messages/batch/{id}; rejected
+// entries are stored as failed items of the job.
+func (gateway *Gateway) webOutboundBulk(ctx iris.Context) {
+	if requireBatchScope(ctx) {
+		return
+	}
+
+	lm := gateway.LogManager
+	client := ctx.Values().Get("client").(*Client)
+	var apiKey *TenantAPIKey
+	if apiKeyVal := ctx.Values().Get("api_key"); apiKeyVal != nil {
+		apiKey = apiKeyVal.(*TenantAPIKey)
+	}
+
+	var messages []bulkMessage
+	if err := ctx.ReadJSON(&messages); err != nil {
+		apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Expected a JSON array of messages")
+		return
+	}
+	if len(messages) == 0 {
+		apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "At least one message is required")
+		return
+	}
+	if max := gateway.Config.BulkMaxMessages; max > 0 && len(messages) > max {
+		apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, fmt.Sprintf("At most %d messages per request", max))
+		return
+	}
+
+	now := time.Now()
+	job := &BatchJob{
+		ID:          uuid.New().String(),
+		ClientID:    client.ID,
+		Status:      "pending",
+		TotalCount:  len(messages),
+		ThrottleRPS: gateway.Config.BulkTricklePerSec,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if apiKey != nil {
+		job.APIKeyID = &apiKey.ID
+	}
+
+	resp := bulkResponse{BatchID: job.ID, Messages: make([]bulkResult, len(messages))}
+	items := make([]BatchMessageItem, len(messages))
+	for i, m := range messages {
+		items[i] = gateway.bulkItem(client, apiKey, job, i, m)
+		if items[i].Status == "failed" {
+			resp.Messages[i] = bulkResult{Index: i, Status: "rejected", Error: items[i].Error}
+			resp.Rejected++
+			continue
+		}
+		resp.Messages[i] = bulkResult{Index: i, Status: "accepted", ID: items[i].ID}
+		resp.Accepted++
+	}
+	job.FromNumber = bulkFromNumber(items)
+
+	if err := gateway.DB.Create(job).Error; err != nil {
+		apiError(ctx, iris.StatusInternalServerError, errCodeInternal, "Failed to create batch job")
+		return
+	}
+
+	lm.SendLog(lm.BuildLog(
+		"WebServer.Outbound.Bulk",
+		"BatchAccepted",
+		logrus.InfoLevel,
+		map[string]interface{}{
+			"batchID":    job.ID,
+			"clientID":   client.ID,
+			"clientName": client.Username,
+			"accepted":   resp.Accepted,
+			"rejected":   resp.Rejected,
+			"ip":         ctx.Values().GetString("client_ip"),
+			"request_id": ctx.Values().GetString("request_id"),
+		},
+	))
+
+	go gateway.processBatchJob(job, items, client)
+
+	ctx.StatusCode(iris.StatusAccepted)
+	ctx.JSON(resp)
+}
+
+// bulkItem validates m for client and builds its batch item. A message that
+// cannot be delivered comes back failed, with the reason to report to the
+// client.
+func (gateway *Gateway) bulkItem(client *Client, apiKey *TenantAPIKey, job *BatchJob, index int, m bulkMessage) BatchMessageItem {
+	item := BatchMessageItem{
+		ID:         uuid.New().String(),
+		BatchJobID: job.ID,
+		Index:      index,
+		From:       m.From,
+		To:         m.To,
+		Text:       m.Message,
+		Status:     "pending",
+		CreatedAt:  time.Now(),
+	}
+	reject := func(format string, args ...interface{}) BatchMessageItem {
+		item.Status = "failed"
+		item.Error = fmt.Sprintf(format, args...)
+		item.ErrorCode = 400
+		return item
+	}
+
+	msgType := MsgQueueType(strings.ToLower(strings.TrimSpace(m.Type)))
+	if msgType == "" {
+		msgType = MsgQueueItemType.SMS
+	}
+	item.Type = string(msgType)
+	if msgType != MsgQueueItemType.SMS && msgType != MsgQueueItemType.MMS {
+		return reject("type must be sms or mms")
+	}
+	if m.From == "" || m.To == "" || m.Message == "" {
+		return reject("from, to and message are required")
+	}
+
+	item.From = gateway.formatClientSender(m.From, client)
+	if findClientNumber(client, item.From) < 0 {
+		return reject("client does not own the 'from' number")
+	}
+	if apiKey != nil && !apiKey.IsNumberAllowed(item.From) {
+		return reject("API key is not authorized to send from this number")
+	}
+	to, err := gateway.formatClientNumber(m.To, client)
+	if err != nil {
+		return reject("Invalid destination number")
+	}
+	item.To = to
+	if d := gateway.Router.decideRoute(item.From, to, msgType, "client"); d.Error != "" {
+		return reject("%s", d.Error)
+	}
+	return item
+}
+
+// bulkFromNumber returns the sender shared by every accepted item, or "" when
+// they differ; each item keeps its own From either way.
+func bulkFromNumber(items []BatchMessageItem) string {
+	from := ""
+	for _, item := range items {
+		if item.Status == "failed" {
+			continue
+		}
+		if from != "" && item.From != from {
+			return ""
+		}
+		from = item.From
+	}
+	return from
+}
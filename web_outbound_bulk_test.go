@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kataras/iris/v12"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebOutboundBulk(t *testing.T) {
+	r := newDecisionRouter(t)
+	gw := r.gateway
+	gw.Clients["pbx1"].Password = "secret"
+	gw.Clients["app1"].Password = "secret"
+	gw.Config.BulkMaxMessages = 3
+
+	app := iris.New()
+	SetupOutboundRoutes(app, gw)
+	require.NoError(t, app.Build())
+	send := func(username, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/outbound/bulk", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth(username, "secret")
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		return w
+	}
+	one := `{"from": "16045551111", "to": "14155559876", "message": "x"}`
+
+	t.Run("authenticates like the rest of the client API", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, send("pbx1", "["+one+"]").Code, "legacy clients use SMPP")
+		assert.Equal(t, http.StatusUnauthorized, send("nobody", "["+one+"]").Code)
+	})
+
+	t.Run("caps the batch size", func(t *testing.T) {
+		w := send("app1", "["+strings.Repeat(one+",", 3)+one+"]")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, errCodeInvalidRequest, decodeAPIError(t, w).Code)
+	})
+}
+
+func TestBulkItem(t *testing.T) {
+	r := newDecisionRouter(t)
+	gw := r.gateway
+	client := gw.Clients["app1"]
+	client.Numbers[0].Carrier = "telnyx"
+	job := &BatchJob{ID: "job-1"}
+
+	item := gw.bulkItem(client, nil, job, 0, bulkMessage{From: "16045551111", To: "14155559876", Message: "one"})
+	assert.Equal(t, "pending", item.Status, item.Error)
+	assert.NotEmpty(t, item.ID)
+	assert.Equal(t, "job-1", item.BatchJobID)
+	assert.Equal(t, "+16045551111", item.From)
+	assert.Equal(t, "+14155559876", item.To)
+	assert.Equal(t, "sms", item.Type)
+
+	for _, tc := range []struct {
+		msg  bulkMessage
+		want string
+	}{
+		{bulkMessage{From: "16045559999", To: "14155559876", Message: "x"}, "own"},
+		{bulkMessage{From: "16045551111", To: "abc", Message: "x"}, "Invalid destination number"},
+		{bulkMessage{From: "16045551111", To: "14155559876", Message: "x", Type: "fax"}, "type"},
+		{bulkMessage{From: "16045551111", To: "14155559876"}, "required"},
+	} {
+		item := gw.bulkItem(client, nil, job, 1, tc.msg)
+		assert.Equal(t, "failed", item.Status, tc.msg)
+		assert.Contains(t, item.Error, tc.want)
+		assert.Equal(t, 400, item.ErrorCode)
+		assert.Equal(t, 1, item.Index)
+	}
+
+	apiKey := &TenantAPIKey{AllowedNumbers: []APIKeyNumber{{Number: "16045552222"}}}
+	item = gw.bulkItem(client, apiKey, job, 0, bulkMessage{From: "16045551111", To: "14155559876", Message: "x"})
+	assert.Equal(t, "failed", item.Status)
+	assert.Contains(t, item.Error, "API key")
+}
+
+func TestBulkFromNumber(t *testing.T) {
+	a := BatchMessageItem{From: "+16045551111"}
+	b := BatchMessageItem{From: "+16045552222"}
+	rejected := BatchMessageItem{From: "+16045559999", Status: "failed"}
+	assert.Equal(t, "+16045551111", bulkFromNumber([]BatchMessageItem{a, rejected, a}))
+	assert.Equal(t, "", bulkFromNumber([]BatchMessageItem{a, b}))
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/base64"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kataras/iris/v12"
+	"github.com/sirupsen/logrus"
+)
+
+// maxOutboundMMSUploadBytes caps the multipart body of POST /outbound/mms.
+// Carriers reject anything near this size anyway; the transcoder shrinks
+// what it can before sending.
+const maxOutboundMMSUploadBytes = 20 << 20
+
+// SetupOutboundRoutes registers the HTTP alternative to MM4 for clients that
+// would rather upload their outbound MMS than run an MM4 client.
+func SetupOutboundRoutes(app *iris.Application, gateway *Gateway) {
+	app.Post("/outbound/mms", gateway.anyClientAuthMiddleware, gateway.webOutboundMMS)
+	app.Post("/outbound/bulk", gateway.clientAuthMiddleware, gateway.webOutboundBulk)
+}
+
+// webOutboundMMS accepts an MMS as multipart/form-data: a "from" number owned
+// by the client, one or more "to" numbers, and any number of file parts. The
+// message is handed to the transcoder just like an MM4_forward.REQ, so it is
+// queued, fanned out and delivered the same way.
+func (gateway *Gateway) webOutboundMMS(ctx iris.Context) {
+	lm := gateway.LogManager
+
+	client := ctx.Values().Get("client").(*Client)
+	apiKey, _ := ctx.Values().Get("api_key").(*TenantAPIKey)
+	if apiKey != nil && !apiKey.HasScope("send") {
+		apiError(ctx, iris.StatusForbidden, errCodeForbidden, "API key does not have 'send' scope")
+		return
+	}
+
+	req := ctx.Request()
+	req.Body = http.MaxBytesReader(ctx.ResponseWriter(), req.Body, maxOutboundMMSUploadBytes)
+	if err := req.ParseMultipartForm(maxOutboundMMSUploadBytes); err != nil {
+		apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Expected a multipart/form-data body of at most 20MB")
+		return
+	}
+	defer req.MultipartForm.RemoveAll()
+
+	form := req.MultipartForm
+	if len(form.Value["from"]) == 0 || len(form.Value["to"]) == 0 {
+		apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "'from' and 'to' are required")
+		return
+	}
+
+	from, err := gateway.formatClientNumber(form.Value["from"][0], client)
+	if err != nil {
+		apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Invalid 'from' number")
+		return
+	}
+	if findClientNumber(client, from) < 0 {
+		apiError(ctx, iris.StatusForbidden, errCodeForbidden, "Client does not own the specified 'from' number")
+		return
+	}
+	if apiKey != nil && !apiKey.IsNumberAllowed(from) {
+		apiError(ctx, iris.StatusForbidden, errCodeForbidden, "API key is not authorized to send from this number")
+		return
+	}
+
+	recipients, ok := mm4Recipients(form.Value["to"], gateway.defaultCountryCode(client))
+	if !ok {
+		apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Invalid 'to' number")
+		return
+	}
+
+	files, err := outboundMMSFiles(form)
+	if err != nil {
+		apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Unable to read uploaded file")
+		return
+	}
+	if len(files) == 0 {
+		apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "At least one file part is required")
+		return
+	}
+
+	logID := uuid.New().String()
+	traceID := newTraceID()
+	mm4Message := &MM4Message{
+		From:          from,
+		To:            recipients[0],
+		Recipients:    recipients,
+		Files:         files,
+		TransactionID: logID,
+		MessageID:     logID,
+		TraceID:       traceID,
+		Client:        client,
+		ReceivedAt:    time.Now(),
+	}
+
+	lm.SendLog(lm.BuildLog(
+		"WebServer.Outbound.MMS",
+		"RoutingToTranscoder",
+		logrus.InfoLevel,
+		map[string]interface{}{
+			"logID":      logID,
+			"traceID":    traceID,
+			"clientID":   client.ID,
+			"clientName": client.Username,
+			"from":       from,
+			"to":         recipients,
+			"fileCount":  len(files),
+			"ip":         ctx.Values().GetString("client_ip"),
+			"request_id": ctx.Values().GetString("request_id"),
+		},
+	))
+
+	gateway.MM4Server.MediaTranscodeChan <- mm4Message
+
+	ctx.StatusCode(iris.StatusAccepted)
+	ctx.JSON(iris.Map{"status": "queued", "id": logID})
+}
+
+// outboundMMSFiles reads every file part of form, ordered by field name so
+// that "file1", "file2", ... keep their order. Content is base64-encoded,
+// which is what the transcoder expects from parsed MM4 MIME parts.
+func outboundMMSFiles(form *multipart.Form) ([]MsgFile, error) {
+	fields := make([]string, 0, len(form.File))
+	for field := range form.File {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var files []MsgFile
+	for _, field := range fields {
+		for _, fh := range form.File[field] {
+			content, err := readFormFile(fh)
+			if err != nil {
+				return nil, err
+			}
+			contentType := fh.Header.Get("Content-Type")
+			if contentType == "" || contentType == "application/octet-stream" {
+				contentType = http.DetectContentType(content)
+			}
+			files = append(files, MsgFile{
+				Filename:    fh.Filename,
+				ContentType: contentType,
+				Content:     []byte(base64.StdEncoding.EncodeToString(content)),
+			})
+		}
+	}
+	return files, nil
+}
+
+func readFormFile(fh *multipart.FileHeader) ([]byte, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+
+	"github.com/kataras/iris/v12"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newOutboundMMSRequest(t *testing.T, from string, to []string, withFile bool) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	require.NoError(t, mw.WriteField("from", from))
+	for _, n := range to {
+		require.NoError(t, mw.WriteField("to", n))
+	}
+	if withFile {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", `form-data; name="file1"; filename="cat.png"`)
+		h.Set("Content-Type", "image/png")
+		part, err := mw.CreatePart(h)
+		require.NoError(t, err)
+		_, _ = part.Write([]byte("png-bytes"))
+	}
+	require.NoError(t, mw.Close())
+
+	req := httptest.NewRequest("POST", "/outbound/mms", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.SetBasicAuth("pbx1", "secret")
+	return req
+}
+
+func TestWebOutboundMMS(t *testing.T) {
+	_, gw := newTestRouter(1)
+	client := &Client{ID: 7, Username: "pbx1", Password: "secret", Type: "legacy",
+		Numbers: []ClientNumber{{ID: 1, ClientID: 7, Number: "15551230000"}}}
+	gw.Clients = map[string]*Client{"pbx1": client}
+	gw.MM4Server = &MM4Server{gateway: gw, MediaTranscodeChan: make(chan *MM4Message, 1)}
+
+	app := iris.New()
+	SetupOutboundRoutes(app, gw)
+	require.NoError(t, app.Build())
+	send := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("queues the upload for transcoding", func(t *testing.T) {
+		w := send(newOutboundMMSRequest(t, "+15551230000", []string{"15559870000", "+15559870000", "15559871111"}, true))
+		require.Equal(t, http.StatusAccepted, w.Code)
+
+		var body map[string]string
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		require.NotEmpty(t, body["id"])
+
+		mm := <-gw.MM4Server.MediaTranscodeChan
+		assert.Equal(t, body["id"], mm.TransactionID)
+		assert.Equal(t, "+15551230000", mm.From)
+		assert.Equal(t, []string{"+15559870000", "+15559871111"}, mm.Recipients)
+		assert.Same(t, client, mm.Client)
+		require.Len(t, mm.Files, 1)
+		assert.Equal(t, "image/png", mm.Files[0].ContentType)
+		assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("png-bytes")), string(mm.Files[0].Content))
+	})
+
+	t.Run("rejects a from number the client does not own", func(t *testing.T) {
+		w := send(newOutboundMMSRequest(t, "15550000000", []string{"15559870000"}, true))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, errCodeForbidden, decodeAPIError(t, w).Code)
+	})
+
+	t.Run("requires a file part", func(t *testing.T) {
+		w := send(newOutboundMMSRequest(t, "15551230000", []string{"15559870000"}, false))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("requires valid credentials", func(t *testing.T) {
+		req := newOutboundMMSRequest(t, "15551230000", []string{"15559870000"}, true)
+		req.SetBasicAuth("pbx1", "wrong")
+		assert.Equal(t, http.StatusUnauthorized, send(req).Code)
+
+		req = newOutboundMMSRequest(t, "15551230000", []string{"15559870000"}, true)
+		req.Header.Set("Authorization", "Bearer "+base64.StdEncoding.EncodeToString([]byte("pbx1:secret")))
+		assert.Equal(t, http.StatusUnauthorized, send(req).Code, "client expects basic auth")
+	})
+
+	assert.Empty(t, gw.MM4Server.MediaTranscodeChan)
+}
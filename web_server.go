@@ -3,10 +3,8 @@ package main
 import (
 	"encoding/base64"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
-	"os"
 	"path"
 	"strconv"
 	"strings"
@@ -16,6 +14,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/kataras/iris/v12"
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 // --- API Key Rate Limiter (sliding window, in-memory) ---
@@ -70,6 +69,8 @@ type StatsResponse struct {
 	SMPPClients          []SMPPClientInfo `json:"smpp_clients"`
 	MM4ConnectedClients  int              `json:"mm4_connected_clients"`
 	MM4Clients           []MM4ClientInfo  `json:"mm4_clients"`
+	SMPPConnections      ConnectionStats  `json:"smpp_connections"`
+	MM4Connections       ConnectionStats  `json:"mm4_connections"`
 }
 
 // SMPPClientInfo contains information about a connected SMPP client.
@@ -139,9 +140,116 @@ func SetupStatsRoutes(app *iris.Application, gateway *Gateway) {
 			statsResponse.MM4ConnectedClients = totalMM4Sessions
 			statsResponse.MM4Clients = mm4Clients
 
+			statsResponse.SMPPConnections = gateway.connectionStats(gateway.SMPPServer.connLimits)
+			statsResponse.MM4Connections = gateway.connectionStats(gateway.MM4Server.connLimits)
+
 			// Return the stats as JSON
 			ctx.JSON(statsResponse)
 		})
+
+		// Conversation queue and carrier ack backlog
+		stats.Get("/conversations", func(ctx iris.Context) {
+			ctx.JSON(gateway.ConvoManager.Stats())
+		})
+	}
+}
+
+// parseDateParam parses a date (2006-01-02) or RFC3339 query value. A bare
+// date used as an upper bound covers the whole day.
+func parseDateParam(val string, endOfDay bool) (time.Time, bool) {
+	if t, err := time.Parse("2006-01-02", val); err == nil {
+		if endOfDay {
+			t = t.Add(24 * time.Hour)
+		}
+		return t, true
+	}
+	if t, err := time.Parse(time.RFC3339, val); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// SetupReportRoutes sets up the HTTP routes for message outcome reporting.
+func SetupReportRoutes(app *iris.Application, gateway *Gateway) {
+	reports := app.Party("/reports", gateway.basicAuthMiddleware)
+	{
+		// GET /reports/messages - message outcomes across all clients
+		reports.Get("/messages", func(ctx iris.Context) {
+			page, _ := strconv.Atoi(ctx.URLParamDefault("page", "1"))
+			perPage, _ := strconv.Atoi(ctx.URLParamDefault("per_page", "50"))
+			if page < 1 {
+				page = 1
+			}
+			if perPage < 1 {
+				perPage = 50
+			}
+			if perPage > 200 {
+				perPage = 200
+			}
+			offset := (page - 1) * perPage
+
+			query := gateway.DB.Model(&MessageRecord{})
+
+			if clientID := ctx.URLParam("client_id"); clientID != "" {
+				id, err := strconv.ParseUint(clientID, 10, 32)
+				if err != nil {
+					apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid client_id")
+					return
+				}
+				query = query.Where("client_id = ?", id)
+			}
+			if status := ctx.URLParam("status"); status != "" {
+				query = query.Where("status = ?", status)
+			}
+			if direction := ctx.URLParam("direction"); direction != "" {
+				query = query.Where("direction = ?", direction)
+			}
+			if msgType := ctx.URLParam("type"); msgType != "" {
+				query = query.Where("type = ?", msgType)
+			}
+			if since := ctx.URLParam("since"); since != "" {
+				t, ok := parseDateParam(since, false)
+				if !ok {
+					apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid since date")
+					return
+				}
+				query = query.Where("created_at >= ?", t)
+			}
+			if until := ctx.URLParam("until"); until != "" {
+				t, ok := parseDateParam(until, true)
+				if !ok {
+					apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid until date")
+					return
+				}
+				query = query.Where("created_at <= ?", t)
+			}
+
+			// Session lets the filtered query be reused for the count and the page.
+			query = query.Session(&gorm.Session{})
+
+			var totalCount int64
+			if err := query.Count(&totalCount).Error; err != nil {
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, "Failed to query message records")
+				return
+			}
+
+			records := []MessageRecord{}
+			if err := query.Order("created_at DESC").Offset(offset).Limit(perPage).Find(&records).Error; err != nil {
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, "Failed to query message records")
+				return
+			}
+
+			ctx.Header("X-Total-Count", strconv.FormatInt(totalCount, 10))
+			ctx.Header("X-Page", strconv.Itoa(page))
+			ctx.Header("X-Per-Page", strconv.Itoa(perPage))
+
+			ctx.JSON(iris.Map{
+				"messages":    records,
+				"total_count": totalCount,
+				"page":        page,
+				"per_page":    perPage,
+			})
+		})
 	}
 }
 
@@ -150,14 +258,15 @@ func (gateway *Gateway) basicAuthMiddleware(ctx iris.Context) {
 	// Retrieve the expected API key from environment variables
 	var lm = gateway.LogManager
 
-	expectedAPIKey := os.Getenv("API_KEY")
+	expectedAPIKey := gateway.Config.APIKey
 	if expectedAPIKey == "" {
 		lm.SendLog(lm.BuildLog(
 			"Server.Web.AuthMiddleware",
 			"Authenticated web client",
 			logrus.ErrorLevel,
 			map[string]interface{}{
-				"ip": ctx.Values().GetString("client_ip"),
+				"ip":         ctx.Values().GetString("client_ip"),
+				"request_id": ctx.Values().GetString("request_id"),
 			},
 		))
 		// Log the error
@@ -168,9 +277,7 @@ func (gateway *Gateway) basicAuthMiddleware(ctx iris.Context) {
 		}
 		logf.Print()*/
 
-		// Respond with 500 Internal Server Error
-		ctx.StatusCode(http.StatusInternalServerError)
-		ctx.WriteString("Internal Server Error")
+		apiError(ctx, http.StatusInternalServerError, errCodeInternal, "Internal Server Error")
 		return
 	}
 
@@ -232,19 +339,19 @@ func SetupCarrierRoutes(app *iris.Application, gateway *Gateway) {
 		carriers.Post("/", func(ctx iris.Context) {
 			var carrier Carrier
 			if err := ctx.ReadJSON(&carrier); err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid carrier data"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Invalid carrier data")
+				return
 			}
 
 			// Validate required fields
 			if carrier.Name == "" || carrier.Type == "" || carrier.Username == "" || carrier.Password == "" {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "All fields (name, type, username, password) are required"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "All fields (name, type, username, password) are required")
+				return
 			}
 
 			if err := gateway.addCarrier(&carrier); err != nil {
-				ctx.StatusCode(iris.StatusInternalServerError)
-				ctx.JSON(iris.Map{"error": err.Error()})
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, err.Error())
+				return
 			}
 
 			// Return the carrier without exposing encrypted fields
@@ -258,11 +365,12 @@ func SetupCarrierRoutes(app *iris.Application, gateway *Gateway) {
 			ctx.JSON(responseCarrier)
 		})
 
-		// Reload carriers from the database
+		// Reload carriers from the database, along with the rest of the
+		// configuration so routing stays consistent
 		carriers.Post("/reload", func(ctx iris.Context) {
-			if err := gateway.reloadCarriers(); err != nil {
-				ctx.StatusCode(iris.StatusInternalServerError)
-				ctx.JSON(iris.Map{"error": err.Error()})
+			if _, err := gateway.reload(); err != nil {
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, err.Error())
+				return
 			}
 
 			ctx.JSON(iris.Map{"status": "Carriers reloaded"})
@@ -277,15 +385,89 @@ func SetupCarrierRoutes(app *iris.Application, gateway *Gateway) {
 			for _, carrier := range gateway.CarrierUUIDs {
 				// Return carriers without exposing sensitive information
 				c := Carrier{
-					ID:   carrier.ID,
-					Name: carrier.Name,
-					Type: carrier.Type,
+					ID:             carrier.ID,
+					Name:           carrier.Name,
+					Type:           carrier.Type,
+					MaxConcurrency: carrier.MaxConcurrency,
+					MaxTPS:         carrier.MaxTPS,
 				}
 				carrierList = append(carrierList, c)
 			}
 
 			ctx.JSON(carrierList)
 		})
+
+		// === Destination Routing ===
+
+		// List destination-prefix routes in match order
+		carriers.Get("/routes", func(ctx iris.Context) {
+			gateway.mu.RLock()
+			routes := gateway.CarrierRoutes
+			gateway.mu.RUnlock()
+
+			if routes == nil {
+				routes = []CarrierRoute{}
+			}
+			ctx.JSON(routes)
+		})
+
+		// Add a destination-prefix route
+		carriers.Post("/routes", func(ctx iris.Context) {
+			var route CarrierRoute
+			if err := ctx.ReadJSON(&route); err != nil {
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Invalid route data")
+				return
+			}
+			if route.Prefix == "" || route.Carrier == "" {
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "destination_prefix and carrier are required")
+				return
+			}
+			route.Enabled = true
+
+			if err := gateway.addCarrierRoute(&route); err != nil {
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, err.Error())
+				return
+			}
+
+			ctx.StatusCode(iris.StatusCreated)
+			ctx.JSON(route)
+		})
+
+		// Delete a destination-prefix route
+		carriers.Delete("/routes/{id}", func(ctx iris.Context) {
+			routeID, err := strconv.ParseUint(ctx.Params().Get("id"), 10, 32)
+			if err != nil {
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid route ID")
+				return
+			}
+
+			result := gateway.DB.Delete(&CarrierRoute{}, routeID)
+			if result.Error != nil {
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, "Failed to delete route")
+				return
+			}
+			if result.RowsAffected == 0 {
+				apiError(ctx, iris.StatusNotFound, errCodeRouteNotFound, "Route not found")
+				return
+			}
+
+			if err := gateway.loadCarrierRoutes(); err != nil {
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, err.Error())
+				return
+			}
+
+			ctx.JSON(iris.Map{"message": "Route deleted", "route_id": routeID})
+		})
+
+		// Reload destination-prefix routes from the database
+		carriers.Post("/routes/reload", func(ctx iris.Context) {
+			if err := gateway.loadCarrierRoutes(); err != nil {
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, err.Error())
+				return
+			}
+
+			ctx.JSON(iris.Map{"status": "Carrier routes reloaded"})
+		})
 	}
 }
 
@@ -308,16 +490,37 @@ func unauthorized(ctx iris.Context, gateway *Gateway, message string) {
 		"Unauthorized web client",
 		logrus.ErrorLevel,
 		map[string]interface{}{
-			"ip": ctx.Values().GetString("client_ip"),
+			"ip":         ctx.Values().GetString("client_ip"),
+			"request_id": ctx.Values().GetString("request_id"),
 		},
 	))
 
 	// Set the WWW-Authenticate header to indicate Basic Auth is required
 	ctx.Header("WWW-Authenticate", `Basic realm="Restricted"`)
 
-	// Respond with 401 Unauthorized
-	ctx.StatusCode(http.StatusUnauthorized)
-	ctx.WriteString(message)
+	apiError(ctx, http.StatusUnauthorized, errCodeUnauthorized, message)
+}
+
+// clientResponse returns a copy of client safe to return from the API,
+// without the password or related records.
+func clientResponse(client *Client) Client {
+	return Client{
+		ID:         client.ID,
+		Username:   client.Username,
+		Name:       client.Name,
+		Type:       client.Type,
+		Address:    client.Address,
+		LogPrivacy: client.LogPrivacy,
+
+		LogPrivacyLevel:    client.LogPrivacyLevel,
+		WebhookURL:         client.WebhookURL,
+		WebhookFailoverURL: client.WebhookFailoverURL,
+		DeliveryMethod:     client.DeliveryMethod,
+		TranscodeMedia:     client.TranscodeMedia,
+
+		DeliveryReceipts:        client.DeliveryReceipts,
+		WebhookDeliveryReceipts: client.WebhookDeliveryReceipts,
+	}
 }
 
 // SetupClientRoutes sets up the HTTP routes for client management.
@@ -326,91 +529,89 @@ func SetupClientRoutes(app *iris.Application, gateway *Gateway) {
 	{
 		// Add a new client
 		clients.Post("/", func(ctx iris.Context) {
-			var client Client
+			client := Client{TranscodeMedia: true}
 			if err := ctx.ReadJSON(&client); err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid client data"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Invalid client data")
 				return
 			}
 
 			// Validate required fields
 			if client.Username == "" || client.Password == "" {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Username and Password are required"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Username and Password are required")
 				return
 			}
 
 			// Legacy clients require an address (IP or hostname) for SMPP ACL and MM4 delivery
 			if (client.Type == "" || client.Type == "legacy") && client.Address == "" {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Address (IP or hostname) is required for legacy clients"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Address (IP or hostname) is required for legacy clients")
 				return
 			}
-
-			if err := gateway.addClient(&client); err != nil {
-				ctx.StatusCode(iris.StatusInternalServerError)
-				ctx.JSON(iris.Map{"error": err.Error()})
+			if !validDeliveryMethod(client.DeliveryMethod) {
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "delivery_method must be one of smpp, mm4, webhook")
+				return
+			}
+			if !validLogPrivacy(client.LogPrivacyLevel) {
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "log_privacy_level must be none, numbers, full or empty")
+				return
+			}
+			client.DeliveryReceipts = normalizeDeliveryReceipts(client.DeliveryReceipts)
+			client.WebhookDeliveryReceipts = normalizeDeliveryReceipts(client.WebhookDeliveryReceipts)
+			if !validDeliveryReceipts(client.DeliveryReceipts) || !validDeliveryReceipts(client.WebhookDeliveryReceipts) {
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "delivery_receipts and webhook_delivery_receipts must be off, failures_only (or failures-only), all or empty")
 				return
 			}
 
-			// Return the client without exposing encrypted fields
-			responseClient := Client{
-				ID:         client.ID,
-				Username:   client.Username,
-				Name:       client.Name,
-				Type:       client.Type,
-				Address:    client.Address,
-				LogPrivacy: client.LogPrivacy,
+			if err := gateway.addClient(&client); err != nil {
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, err.Error())
+				return
 			}
 
 			ctx.StatusCode(iris.StatusCreated)
-			ctx.JSON(responseClient)
+			ctx.JSON(clientResponse(&client))
 		})
 
-		// Reload clients and numbers from the database
+		// Reload clients and numbers from the database, along with the rest
+		// of the configuration so routing stays consistent
 		clients.Post("/reload", func(ctx iris.Context) {
-			if err := gateway.reloadClientsAndNumbers(); err != nil {
-				ctx.StatusCode(iris.StatusInternalServerError)
-				ctx.JSON(iris.Map{"error": err.Error()})
+			if _, err := gateway.reload(); err != nil {
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, err.Error())
 				return
 			}
 
 			ctx.JSON(iris.Map{"status": "Clients and Numbers reloaded"})
 		})
 
+		// Kick a client's live SMPP bind
+		clients.Post("/{username}/disconnect", gateway.webDisconnectClient)
+
 		// Update client password
 		clients.Patch("/{id}/password", func(ctx iris.Context) {
 			clientIDStr := ctx.Params().Get("id")
 			clientID, err := strconv.ParseUint(clientIDStr, 10, 32)
 			if err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid client ID format"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid client ID format")
 				return
 			}
 
 			var passwordUpdate PasswordUpdateRequest
 			if err := ctx.ReadJSON(&passwordUpdate); err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid request data"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Invalid request data")
 				return
 			}
 
 			// Validate the new password
 			if passwordUpdate.NewPassword == "" {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "New password is required"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "New password is required")
 				return
 			}
 
 			// Update the password
 			if err := gateway.updateClientPassword(uint(clientID), passwordUpdate.NewPassword); err != nil {
 				if err.Error() == "client not found in memory" {
-					ctx.StatusCode(iris.StatusNotFound)
-					ctx.JSON(iris.Map{"error": "Client not found"})
+					apiError(ctx, iris.StatusNotFound, errCodeClientNotFound, "Client not found")
 					return
 				}
-				ctx.StatusCode(iris.StatusInternalServerError)
-				ctx.JSON(iris.Map{"error": err.Error()})
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, err.Error())
 				return
 			}
 
@@ -422,29 +623,29 @@ func SetupClientRoutes(app *iris.Application, gateway *Gateway) {
 			clientIDStr := ctx.Params().Get("id")
 			clientID, err := strconv.ParseUint(clientIDStr, 10, 32)
 			if err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid client ID"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid client ID")
 				return
 			}
 
 			var newNumber ClientNumber
 			if err := ctx.ReadJSON(&newNumber); err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid number data"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Invalid number data")
 				return
 			}
 
 			// Validate required fields
 			if newNumber.Number == "" || newNumber.Carrier == "" {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Number and Carrier are required"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Number and Carrier are required")
+				return
+			}
+			if !validInboundRoute(newNumber.InboundRoute) {
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "inbound_route must be one of smpp, mm4, webhook, drop")
 				return
 			}
 
 			// Add the number to the client
 			if err := gateway.addNumber(uint(clientID), &newNumber); err != nil {
-				ctx.StatusCode(iris.StatusInternalServerError)
-				ctx.JSON(iris.Map{"error": err.Error()})
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, err.Error())
 				return
 			}
 
@@ -465,15 +666,13 @@ func SetupClientRoutes(app *iris.Application, gateway *Gateway) {
 			clientIDStr := ctx.Params().Get("id")
 			clientID, err := strconv.ParseUint(clientIDStr, 10, 32)
 			if err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid client ID"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid client ID")
 				return
 			}
 
 			client := gateway.getClientByID(uint(clientID))
 			if client == nil {
-				ctx.StatusCode(iris.StatusNotFound)
-				ctx.JSON(iris.Map{"error": "Client not found"})
+				apiError(ctx, iris.StatusNotFound, errCodeClientNotFound, "Client not found")
 				return
 			}
 
@@ -485,15 +684,13 @@ func SetupClientRoutes(app *iris.Application, gateway *Gateway) {
 			clientIDStr := ctx.Params().Get("id")
 			clientID, err := strconv.ParseUint(clientIDStr, 10, 32)
 			if err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid client ID"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid client ID")
 				return
 			}
 
 			client := gateway.getClientByID(uint(clientID))
 			if client == nil {
-				ctx.StatusCode(iris.StatusNotFound)
-				ctx.JSON(iris.Map{"error": "Client not found"})
+				apiError(ctx, iris.StatusNotFound, errCodeClientNotFound, "Client not found")
 				return
 			}
 
@@ -510,15 +707,13 @@ func SetupClientRoutes(app *iris.Application, gateway *Gateway) {
 			clientIDStr := ctx.Params().Get("id")
 			clientID, err := strconv.ParseUint(clientIDStr, 10, 32)
 			if err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid client ID"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid client ID")
 				return
 			}
 
 			client := gateway.getClientByID(uint(clientID))
 			if client == nil {
-				ctx.StatusCode(iris.StatusNotFound)
-				ctx.JSON(iris.Map{"error": "Client not found"})
+				apiError(ctx, iris.StatusNotFound, errCodeClientNotFound, "Client not found")
 				return
 			}
 
@@ -542,11 +737,41 @@ func SetupClientRoutes(app *iris.Application, gateway *Gateway) {
 				MMSMonthlyLimit *int64 `json:"mms_monthly_limit,omitempty"`
 				// Limit Behavior
 				LimitBoth *bool `json:"limit_both,omitempty"`
+				// SMPP Throttling
+				SubmitRateLimit *int `json:"submit_rate_limit,omitempty"`
+				WindowSize      *int `json:"window_size,omitempty"`
+				// Connection Cap
+				MaxConnections *int `json:"max_connections,omitempty"`
+				// Routing
+				Priority *string `json:"priority,omitempty"`
+				// SMPP Keepalive
+				EnquireIntervalSecs *int `json:"enquire_interval_secs,omitempty"`
+				EnquireTimeoutSecs  *int `json:"enquire_timeout_secs,omitempty"`
+				ResponseTimeoutSecs *int `json:"response_timeout_secs,omitempty"`
+				// Outbound MM4
+				MM4DialTimeoutSecs     *int    `json:"mm4_dial_timeout_secs,omitempty"`
+				MM4SessionDeadlineSecs *int    `json:"mm4_session_deadline_secs,omitempty"`
+				MM4SingleImage         *string `json:"mm4_single_image,omitempty"`
+				MMSDelivery            *string `json:"mms_delivery,omitempty"`
+				// SMPP Coding
+				SMSCoding   *string `json:"sms_coding,omitempty"`
+				SMSLanguage *string `json:"sms_language,omitempty"`
+				DeliverPDU  *string `json:"deliver_pdu,omitempty"`
+				// SMPP Numbering
+				SMPPAddressFormat *string `json:"smpp_address_format,omitempty"`
+				SMPPCountryCode   *string `json:"smpp_country_code,omitempty"`
+				// Number Formatting
+				DefaultCountryCode *string `json:"default_country_code,omitempty"`
+				// MMS Transcode Replies
+				TranscodeFailureReply *string `json:"transcode_failure_reply,omitempty"`
+				TranscodePanicReply   *string `json:"transcode_panic_reply,omitempty"`
+				// Sender Pool
+				SenderPoolPolicy *string `json:"sender_pool_policy,omitempty"`
+				SenderPool       *string `json:"sender_pool,omitempty"`
 			}
 
 			if err := ctx.ReadJSON(&updateReq); err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid request body"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Invalid request body")
 				return
 			}
 
@@ -602,11 +827,148 @@ func SetupClientRoutes(app *iris.Application, gateway *Gateway) {
 			if updateReq.LimitBoth != nil {
 				client.Settings.LimitBoth = *updateReq.LimitBoth
 			}
+			// SMPP Throttling
+			if updateReq.SubmitRateLimit != nil {
+				client.Settings.SubmitRateLimit = *updateReq.SubmitRateLimit
+			}
+			if updateReq.WindowSize != nil {
+				client.Settings.WindowSize = *updateReq.WindowSize
+			}
+			// Connection Cap
+			if updateReq.MaxConnections != nil {
+				if *updateReq.MaxConnections < 0 {
+					apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "max_connections must not be negative")
+					return
+				}
+				client.Settings.MaxConnections = *updateReq.MaxConnections
+			}
+			// Routing
+			if updateReq.Priority != nil {
+				switch p := strings.ToLower(*updateReq.Priority); p {
+				case "", ClientPriorityHigh, ClientPriorityNormal:
+					client.Settings.Priority = p
+				default:
+					apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "priority must be high, normal or empty")
+					return
+				}
+			}
+			// SMPP Keepalive
+			if updateReq.EnquireIntervalSecs != nil {
+				client.Settings.EnquireIntervalSecs = *updateReq.EnquireIntervalSecs
+			}
+			if updateReq.EnquireTimeoutSecs != nil {
+				client.Settings.EnquireTimeoutSecs = *updateReq.EnquireTimeoutSecs
+			}
+			if updateReq.ResponseTimeoutSecs != nil {
+				client.Settings.ResponseTimeoutSecs = *updateReq.ResponseTimeoutSecs
+			}
+			// Outbound MM4
+			if updateReq.MM4DialTimeoutSecs != nil {
+				client.Settings.MM4DialTimeoutSecs = *updateReq.MM4DialTimeoutSecs
+			}
+			if updateReq.MM4SessionDeadlineSecs != nil {
+				client.Settings.MM4SessionDeadlineSecs = *updateReq.MM4SessionDeadlineSecs
+			}
+			if updateReq.MM4SingleImage != nil {
+				mode := strings.ToLower(strings.TrimSpace(*updateReq.MM4SingleImage))
+				if !validMM4SingleImage(mode) {
+					apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "mm4_single_image must be smil, mixed or empty")
+					return
+				}
+				client.Settings.MM4SingleImage = mode
+			}
+			if updateReq.MMSDelivery != nil {
+				mode := strings.ToLower(strings.TrimSpace(*updateReq.MMSDelivery))
+				if !validMMSDelivery(mode) {
+					apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "mms_delivery must be inline, notification, link or empty")
+					return
+				}
+				client.Settings.MMSDelivery = mode
+			}
+
+			// SMPP Coding
+			if updateReq.SMSCoding != nil {
+				policy := strings.ToLower(strings.TrimSpace(*updateReq.SMSCoding))
+				if !validSMSCoding(policy) {
+					apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "sms_coding must be auto, force_gsm7_transliterate, force_ucs2 or empty")
+					return
+				}
+				client.Settings.SMSCoding = policy
+			}
+			if updateReq.SMSLanguage != nil {
+				language := strings.ToLower(strings.TrimSpace(*updateReq.SMSLanguage))
+				if !validSMSLanguage(language) {
+					apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "sms_language must be turkish, spanish, portuguese or empty")
+					return
+				}
+				client.Settings.SMSLanguage = language
+			}
+			if updateReq.DeliverPDU != nil {
+				value := strings.ToLower(strings.TrimSpace(*updateReq.DeliverPDU))
+				if !validDeliverPDU(value) {
+					apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "deliver_pdu must be deliver_sm, data_sm or empty")
+					return
+				}
+				client.Settings.DeliverPDU = value
+			}
+
+			// SMPP Numbering
+			if updateReq.SMPPAddressFormat != nil {
+				format := strings.ToLower(strings.TrimSpace(*updateReq.SMPPAddressFormat))
+				if !validSMPPAddressFormat(format) {
+					apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "smpp_address_format must be international, national, unknown or empty")
+					return
+				}
+				client.Settings.SMPPAddressFormat = format
+			}
+			if updateReq.SMPPCountryCode != nil {
+				cc := strings.TrimPrefix(strings.TrimSpace(*updateReq.SMPPCountryCode), "+")
+				if !validCountryCode(cc) {
+					apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "smpp_country_code must be 1 to 3 digits or empty")
+					return
+				}
+				client.Settings.SMPPCountryCode = cc
+			}
+
+			// Number Formatting
+			if updateReq.DefaultCountryCode != nil {
+				cc := strings.TrimPrefix(strings.TrimSpace(*updateReq.DefaultCountryCode), "+")
+				if !validCountryCode(cc) {
+					apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "default_country_code must be 1 to 3 digits or empty")
+					return
+				}
+				client.Settings.DefaultCountryCode = cc
+			}
+
+			// MMS Transcode Replies
+			if updateReq.TranscodeFailureReply != nil {
+				client.Settings.TranscodeFailureReply = *updateReq.TranscodeFailureReply
+			}
+			if updateReq.TranscodePanicReply != nil {
+				client.Settings.TranscodePanicReply = *updateReq.TranscodePanicReply
+			}
+
+			// Sender Pool
+			if updateReq.SenderPoolPolicy != nil {
+				policy := strings.ToLower(strings.TrimSpace(*updateReq.SenderPoolPolicy))
+				if !validSenderPoolPolicy(policy) {
+					apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "sender_pool_policy must be passthrough, sticky, roundrobin or empty")
+					return
+				}
+				client.Settings.SenderPoolPolicy = policy
+			}
+			if updateReq.SenderPool != nil {
+				pool, err := normalizeSenderPool(client, *updateReq.SenderPool)
+				if err != nil {
+					apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, err.Error())
+					return
+				}
+				client.Settings.SenderPool = pool
+			}
 
 			// Save to database
 			if err := gateway.DB.Save(client.Settings).Error; err != nil {
-				ctx.StatusCode(iris.StatusInternalServerError)
-				ctx.JSON(iris.Map{"error": "Failed to save settings"})
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, "Failed to save settings")
 				return
 			}
 
@@ -634,6 +996,8 @@ func SetupClientRoutes(app *iris.Application, gateway *Gateway) {
 					LogPrivacy: client.LogPrivacy,
 					Settings:   client.Settings,
 					Numbers:    client.Numbers,
+
+					LogPrivacyLevel: client.LogPrivacyLevel,
 				}
 				clientList = append(clientList, c)
 			}
@@ -642,107 +1006,66 @@ func SetupClientRoutes(app *iris.Application, gateway *Gateway) {
 		})
 
 		// Update a number's properties
-		clients.Put("/{id}/numbers/{number_id}", func(ctx iris.Context) {
+		clients.Put("/{id}/numbers/{number}", func(ctx iris.Context) {
 			clientIDStr := ctx.Params().Get("id")
 			clientID, err := strconv.ParseUint(clientIDStr, 10, 32)
 			if err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid client ID"})
-				return
-			}
-
-			numberIDStr := ctx.Params().Get("number_id")
-			numberID, err := strconv.ParseUint(numberIDStr, 10, 32)
-			if err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid number ID"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid client ID")
 				return
 			}
 
 			client := gateway.getClientByID(uint(clientID))
 			if client == nil {
-				ctx.StatusCode(iris.StatusNotFound)
-				ctx.JSON(iris.Map{"error": "Client not found"})
+				apiError(ctx, iris.StatusNotFound, errCodeClientNotFound, "Client not found")
 				return
 			}
 
-			// Find the number in the client's numbers
-			var targetNumber *ClientNumber
-			for i := range client.Numbers {
-				if client.Numbers[i].ID == uint(numberID) {
-					targetNumber = &client.Numbers[i]
-					break
-				}
+			// The number may be given by ID or as the phone number itself
+			gateway.mu.RLock()
+			idx := findClientNumber(client, ctx.Params().Get("number"))
+			var numberID uint
+			if idx >= 0 {
+				numberID = client.Numbers[idx].ID
 			}
-			if targetNumber == nil {
-				ctx.StatusCode(iris.StatusNotFound)
-				ctx.JSON(iris.Map{"error": "Number not found for this client"})
+			gateway.mu.RUnlock()
+			if idx < 0 {
+				apiError(ctx, iris.StatusNotFound, errCodeNumberNotFound, "Number not found for this client")
 				return
 			}
 
-			var updateReq struct {
-				Carrier              *string `json:"carrier,omitempty"`
-				Tag                  *string `json:"tag,omitempty"`
-				Group                *string `json:"group,omitempty"`
-				Webhook              *string `json:"webhook,omitempty"`
-				IgnoreStopCmdSending *bool   `json:"ignore_stop_cmd_sending,omitempty"`
-				AutoReplyEnabled     *bool   `json:"auto_reply_enabled,omitempty"`
-				AutoReplyMessage     *string `json:"auto_reply_message,omitempty"`
-				AutoReplyCooldownSec *int    `json:"auto_reply_cooldown_secs,omitempty"`
-			}
-
+			var updateReq numberUpdate
 			if err := ctx.ReadJSON(&updateReq); err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid request body"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Invalid request body")
 				return
 			}
 
-			// Apply updates
 			if updateReq.Carrier != nil {
-				targetNumber.Carrier = *updateReq.Carrier
-			}
-			if updateReq.Tag != nil {
-				targetNumber.Tag = *updateReq.Tag
-			}
-			if updateReq.Group != nil {
-				targetNumber.Group = *updateReq.Group
-			}
-			if updateReq.Webhook != nil {
-				targetNumber.WebHook = *updateReq.Webhook
-			}
-			if updateReq.IgnoreStopCmdSending != nil {
-				targetNumber.IgnoreStopCmdSending = *updateReq.IgnoreStopCmdSending
-			}
-			if updateReq.AutoReplyEnabled != nil || updateReq.AutoReplyMessage != nil || updateReq.AutoReplyCooldownSec != nil {
-				if targetNumber.Settings == nil {
-					targetNumber.Settings = &NumberSettings{NumberID: targetNumber.ID}
-				}
-				if updateReq.AutoReplyEnabled != nil {
-					targetNumber.Settings.AutoReplyEnabled = *updateReq.AutoReplyEnabled
-				}
-				if updateReq.AutoReplyMessage != nil {
-					targetNumber.Settings.AutoReplyMessage = *updateReq.AutoReplyMessage
-				}
-				if updateReq.AutoReplyCooldownSec != nil {
-					targetNumber.Settings.AutoReplyCooldownSec = *updateReq.AutoReplyCooldownSec
-				}
-				if err := gateway.DB.Save(targetNumber.Settings).Error; err != nil {
-					ctx.StatusCode(iris.StatusInternalServerError)
-					ctx.JSON(iris.Map{"error": "Failed to save auto-reply settings"})
+				gateway.mu.RLock()
+				_, carrierExists := gateway.Carriers[*updateReq.Carrier]
+				gateway.mu.RUnlock()
+				if !carrierExists {
+					apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, fmt.Sprintf("carrier %s does not exist", *updateReq.Carrier))
 					return
 				}
 			}
+			if updateReq.InboundRoute != nil && !validInboundRouteFor(client, *updateReq.InboundRoute) {
+				msg := "inbound_route must be one of smpp, mm4, webhook, drop"
+				if client.Type == "web" {
+					msg = "inbound_route must be webhook or drop for web clients"
+				}
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, msg)
+				return
+			}
 
-			// Save to database
-			if err := gateway.DB.Save(targetNumber).Error; err != nil {
-				ctx.StatusCode(iris.StatusInternalServerError)
-				ctx.JSON(iris.Map{"error": "Failed to update number"})
+			updated, err := gateway.updateNumber(client, numberID, updateReq)
+			if err != nil {
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, "Failed to update number")
 				return
 			}
 
 			ctx.JSON(iris.Map{
 				"message": "Number updated",
-				"number":  targetNumber,
+				"number":  updated,
 			})
 		})
 
@@ -751,23 +1074,20 @@ func SetupClientRoutes(app *iris.Application, gateway *Gateway) {
 			clientIDStr := ctx.Params().Get("id")
 			clientID, err := strconv.ParseUint(clientIDStr, 10, 32)
 			if err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid client ID"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid client ID")
 				return
 			}
 
 			numberIDStr := ctx.Params().Get("number_id")
 			numberID, err := strconv.ParseUint(numberIDStr, 10, 32)
 			if err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid number ID"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid number ID")
 				return
 			}
 
 			client := gateway.getClientByID(uint(clientID))
 			if client == nil {
-				ctx.StatusCode(iris.StatusNotFound)
-				ctx.JSON(iris.Map{"error": "Client not found"})
+				apiError(ctx, iris.StatusNotFound, errCodeClientNotFound, "Client not found")
 				return
 			}
 
@@ -782,8 +1102,7 @@ func SetupClientRoutes(app *iris.Application, gateway *Gateway) {
 				}
 			}
 			if targetNumber == nil {
-				ctx.StatusCode(iris.StatusNotFound)
-				ctx.JSON(iris.Map{"error": "Number not found for this client"})
+				apiError(ctx, iris.StatusNotFound, errCodeNumberNotFound, "Number not found for this client")
 				return
 			}
 
@@ -794,8 +1113,7 @@ func SetupClientRoutes(app *iris.Application, gateway *Gateway) {
 
 			// Delete from database
 			if err := gateway.DB.Delete(targetNumber).Error; err != nil {
-				ctx.StatusCode(iris.StatusInternalServerError)
-				ctx.JSON(iris.Map{"error": "Failed to delete number"})
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, "Failed to delete number")
 				return
 			}
 
@@ -809,52 +1127,89 @@ func SetupClientRoutes(app *iris.Application, gateway *Gateway) {
 			ctx.JSON(iris.Map{"message": "Number deleted", "number_id": numberID})
 		})
 
-		// Delete a client
-		clients.Delete("/{id}", func(ctx iris.Context) {
+		// Update a client's mutable fields
+		clients.Put("/{id}", func(ctx iris.Context) {
 			clientIDStr := ctx.Params().Get("id")
 			clientID, err := strconv.ParseUint(clientIDStr, 10, 32)
 			if err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid client ID"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid client ID")
 				return
 			}
 
 			client := gateway.getClientByID(uint(clientID))
 			if client == nil {
-				ctx.StatusCode(iris.StatusNotFound)
-				ctx.JSON(iris.Map{"error": "Client not found"})
+				apiError(ctx, iris.StatusNotFound, errCodeClientNotFound, "Client not found")
 				return
 			}
 
-			// Delete all NumberSettings for this client's numbers
-			for _, num := range client.Numbers {
-				if num.Settings != nil {
-					gateway.DB.Delete(num.Settings)
+			var updateReq clientUpdate
+			if err := ctx.ReadJSON(&updateReq); err != nil {
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Invalid request body")
+				return
+			}
+
+			if updateReq.Address != nil && *updateReq.Address == "" && client.Type != "web" {
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Address (IP or hostname) is required for legacy clients")
+				return
+			}
+			if updateReq.Password != nil && *updateReq.Password == "" {
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Password cannot be empty")
+				return
+			}
+			if updateReq.DeliveryMethod != nil && !validDeliveryMethod(*updateReq.DeliveryMethod) {
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "delivery_method must be one of smpp, mm4, webhook")
+				return
+			}
+			if updateReq.LogPrivacyLevel != nil && !validLogPrivacy(*updateReq.LogPrivacyLevel) {
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "log_privacy_level must be none, numbers, full or empty")
+				return
+			}
+			for _, policy := range []*string{updateReq.DeliveryReceipts, updateReq.WebhookDeliveryReceipts} {
+				if policy != nil {
+					*policy = normalizeDeliveryReceipts(*policy)
 				}
-				gateway.DB.Delete(&num)
-				gateway.mu.Lock()
-				delete(gateway.Numbers, num.Number)
-				gateway.mu.Unlock()
+			}
+			if (updateReq.DeliveryReceipts != nil && !validDeliveryReceipts(*updateReq.DeliveryReceipts)) ||
+				(updateReq.WebhookDeliveryReceipts != nil && !validDeliveryReceipts(*updateReq.WebhookDeliveryReceipts)) {
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "delivery_receipts and webhook_delivery_receipts must be off, failures_only (or failures-only), all or empty")
+				return
 			}
 
-			// Delete ClientSettings
-			if client.Settings != nil {
-				gateway.DB.Delete(client.Settings)
+			if err := gateway.updateClient(client, updateReq); err != nil {
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, err.Error())
+				return
+			}
+			if updateReq.Password != nil {
+				if err := gateway.updateClientPassword(client.ID, *updateReq.Password); err != nil {
+					apiError(ctx, iris.StatusInternalServerError, errCodeInternal, err.Error())
+					return
+				}
 			}
 
-			// Delete the client from database
-			if err := gateway.DB.Delete(&Client{}, clientID).Error; err != nil {
-				ctx.StatusCode(iris.StatusInternalServerError)
-				ctx.JSON(iris.Map{"error": "Failed to delete client"})
+			ctx.JSON(clientResponse(client))
+		})
+
+		// Delete a client
+		clients.Delete("/{id}", func(ctx iris.Context) {
+			clientIDStr := ctx.Params().Get("id")
+			clientID, err := strconv.ParseUint(clientIDStr, 10, 32)
+			if err != nil {
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid client ID")
 				return
 			}
 
-			// Remove from in-memory map
-			gateway.mu.Lock()
-			delete(gateway.Clients, client.Username)
-			gateway.mu.Unlock()
+			client := gateway.getClientByID(uint(clientID))
+			if client == nil {
+				apiError(ctx, iris.StatusNotFound, errCodeClientNotFound, "Client not found")
+				return
+			}
 
-			ctx.JSON(iris.Map{"message": "Client deleted", "client_id": clientID})
+			if err := gateway.removeClient(client); err != nil {
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, "Failed to delete client")
+				return
+			}
+
+			ctx.JSON(clientResponse(client))
 		})
 
 		// === Failover Management ===
@@ -864,15 +1219,13 @@ func SetupClientRoutes(app *iris.Application, gateway *Gateway) {
 			clientIDStr := ctx.Params().Get("id")
 			clientID, err := strconv.ParseUint(clientIDStr, 10, 32)
 			if err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid client ID"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid client ID")
 				return
 			}
 
 			client := gateway.getClientByID(uint(clientID))
 			if client == nil {
-				ctx.StatusCode(iris.StatusNotFound)
-				ctx.JSON(iris.Map{"error": "Client not found"})
+				apiError(ctx, iris.StatusNotFound, errCodeClientNotFound, "Client not found")
 				return
 			}
 
@@ -910,15 +1263,13 @@ func SetupClientRoutes(app *iris.Application, gateway *Gateway) {
 			clientIDStr := ctx.Params().Get("id")
 			clientID, err := strconv.ParseUint(clientIDStr, 10, 32)
 			if err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid client ID"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid client ID")
 				return
 			}
 
 			client := gateway.getClientByID(uint(clientID))
 			if client == nil {
-				ctx.StatusCode(iris.StatusNotFound)
-				ctx.JSON(iris.Map{"error": "Primary client not found"})
+				apiError(ctx, iris.StatusNotFound, errCodeClientNotFound, "Primary client not found")
 				return
 			}
 
@@ -928,28 +1279,24 @@ func SetupClientRoutes(app *iris.Application, gateway *Gateway) {
 			}
 
 			if err := ctx.ReadJSON(&req); err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid request body"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Invalid request body")
 				return
 			}
 
 			if req.FallbackClientID == 0 {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "fallback_client_id is required"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "fallback_client_id is required")
 				return
 			}
 
 			if req.FallbackClientID == uint(clientID) {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "A client cannot be its own failover"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "A client cannot be its own failover")
 				return
 			}
 
 			// Verify fallback client exists
 			fbClient := gateway.getClientByID(req.FallbackClientID)
 			if fbClient == nil {
-				ctx.StatusCode(iris.StatusNotFound)
-				ctx.JSON(iris.Map{"error": "Fallback client not found"})
+				apiError(ctx, iris.StatusNotFound, errCodeClientNotFound, "Fallback client not found")
 				return
 			}
 
@@ -961,8 +1308,7 @@ func SetupClientRoutes(app *iris.Application, gateway *Gateway) {
 			}
 
 			if err := gateway.DB.Create(&failover).Error; err != nil {
-				ctx.StatusCode(iris.StatusInternalServerError)
-				ctx.JSON(iris.Map{"error": "Failed to create failover: " + err.Error()})
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, "Failed to create failover: "+err.Error())
 				return
 			}
 
@@ -978,15 +1324,13 @@ func SetupClientRoutes(app *iris.Application, gateway *Gateway) {
 			failoverIDStr := ctx.Params().Get("failover_id")
 			failoverID, err := strconv.ParseUint(failoverIDStr, 10, 32)
 			if err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid failover ID"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid failover ID")
 				return
 			}
 
 			var failover ClientFailover
 			if err := gateway.DB.First(&failover, failoverID).Error; err != nil {
-				ctx.StatusCode(iris.StatusNotFound)
-				ctx.JSON(iris.Map{"error": "Failover not found"})
+				apiError(ctx, iris.StatusNotFound, errCodeFailoverNotFound, "Failover not found")
 				return
 			}
 
@@ -996,8 +1340,7 @@ func SetupClientRoutes(app *iris.Application, gateway *Gateway) {
 			}
 
 			if err := ctx.ReadJSON(&req); err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid request body"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Invalid request body")
 				return
 			}
 
@@ -1009,8 +1352,7 @@ func SetupClientRoutes(app *iris.Application, gateway *Gateway) {
 			}
 
 			if err := gateway.DB.Save(&failover).Error; err != nil {
-				ctx.StatusCode(iris.StatusInternalServerError)
-				ctx.JSON(iris.Map{"error": "Failed to update failover"})
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, "Failed to update failover")
 				return
 			}
 
@@ -1028,14 +1370,12 @@ func SetupClientRoutes(app *iris.Application, gateway *Gateway) {
 			failoverIDStr := ctx.Params().Get("failover_id")
 			failoverID, err := strconv.ParseUint(failoverIDStr, 10, 32)
 			if err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid failover ID"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid failover ID")
 				return
 			}
 
 			if err := gateway.DB.Delete(&ClientFailover{}, failoverID).Error; err != nil {
-				ctx.StatusCode(iris.StatusInternalServerError)
-				ctx.JSON(iris.Map{"error": "Failed to delete failover"})
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, "Failed to delete failover")
 				return
 			}
 
@@ -1045,20 +1385,80 @@ func SetupClientRoutes(app *iris.Application, gateway *Gateway) {
 			ctx.JSON(iris.Map{"message": "Failover deleted", "failover_id": failoverID})
 		})
 
+		// GET /clients/{id}/messages?from=&to=&limit= - recent message history
+		clients.Get("/{id}/messages", func(ctx iris.Context) {
+			clientID, err := strconv.ParseUint(ctx.Params().Get("id"), 10, 32)
+			if err != nil {
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid client ID")
+				return
+			}
+			client := gateway.getClientByID(uint(clientID))
+			if client == nil {
+				apiError(ctx, iris.StatusNotFound, errCodeClientNotFound, "Client not found")
+				return
+			}
+
+			var from, to time.Time
+			if val := ctx.URLParam("from"); val != "" {
+				t, ok := parseDateParam(val, false)
+				if !ok {
+					apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid from date")
+					return
+				}
+				from = t
+			}
+			if val := ctx.URLParam("to"); val != "" {
+				t, ok := parseDateParam(val, true)
+				if !ok {
+					apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid to date")
+					return
+				}
+				to = t
+			}
+
+			page, _ := strconv.Atoi(ctx.URLParamDefault("page", "1"))
+			limit, _ := strconv.Atoi(ctx.URLParamDefault("limit", "50"))
+			if page < 1 {
+				page = 1
+			}
+			if limit < 1 {
+				limit = 50
+			}
+			if limit > 200 {
+				limit = 200
+			}
+
+			entries, totalCount, err := gateway.clientHistory(client, from, to, (page-1)*limit, limit)
+			if err != nil {
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, "Failed to query message history")
+				return
+			}
+
+			ctx.Header("X-Total-Count", strconv.FormatInt(totalCount, 10))
+			ctx.Header("X-Page", strconv.Itoa(page))
+			ctx.Header("X-Per-Page", strconv.Itoa(limit))
+
+			ctx.JSON(iris.Map{
+				"client_id":   client.ID,
+				"messages":    entries,
+				"total_count": totalCount,
+				"page":        page,
+				"limit":       limit,
+			})
+		})
+
 		// SMPP session status for a client
 		clients.Get("/{id}/smpp-status", func(ctx iris.Context) {
 			clientIDStr := ctx.Params().Get("id")
 			clientID, err := strconv.ParseUint(clientIDStr, 10, 32)
 			if err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid client ID"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid client ID")
 				return
 			}
 
 			client := gateway.getClientByID(uint(clientID))
 			if client == nil {
-				ctx.StatusCode(iris.StatusNotFound)
-				ctx.JSON(iris.Map{"error": "Client not found"})
+				apiError(ctx, iris.StatusNotFound, errCodeClientNotFound, "Client not found")
 				return
 			}
 
@@ -1119,13 +1519,44 @@ func SetupClientRoutes(app *iris.Application, gateway *Gateway) {
 func SetupNumberRoutes(app *iris.Application, gateway *Gateway) {
 	numbers := app.Party("/numbers", gateway.basicAuthMiddleware)
 	{
+		// GET /numbers?q=&carrier= - search numbers across all clients
+		numbers.Get("/", func(ctx iris.Context) {
+			page, _ := strconv.Atoi(ctx.URLParamDefault("page", "1"))
+			perPage, _ := strconv.Atoi(ctx.URLParamDefault("per_page", "50"))
+			if page < 1 {
+				page = 1
+			}
+			if perPage < 1 {
+				perPage = 50
+			}
+			if perPage > 200 {
+				perPage = 200
+			}
+
+			results, totalCount, err := gateway.searchNumbers(ctx.URLParam("q"), ctx.URLParam("carrier"), (page-1)*perPage, perPage)
+			if err != nil {
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, "Failed to search numbers")
+				return
+			}
+
+			ctx.Header("X-Total-Count", strconv.FormatInt(totalCount, 10))
+			ctx.Header("X-Page", strconv.Itoa(page))
+			ctx.Header("X-Per-Page", strconv.Itoa(perPage))
+
+			ctx.JSON(iris.Map{
+				"numbers":     results,
+				"total_count": totalCount,
+				"page":        page,
+				"per_page":    perPage,
+			})
+		})
+
 		// Update NumberSettings for a number
 		numbers.Put("/{id}/settings", func(ctx iris.Context) {
 			numberIDStr := ctx.Params().Get("id")
 			numberID, err := strconv.ParseUint(numberIDStr, 10, 32)
 			if err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid number ID"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid number ID")
 				return
 			}
 
@@ -1141,8 +1572,7 @@ func SetupNumberRoutes(app *iris.Application, gateway *Gateway) {
 			gateway.mu.RUnlock()
 
 			if targetNumber == nil {
-				ctx.StatusCode(iris.StatusNotFound)
-				ctx.JSON(iris.Map{"error": "Number not found"})
+				apiError(ctx, iris.StatusNotFound, errCodeNumberNotFound, "Number not found")
 				return
 			}
 
@@ -1161,11 +1591,12 @@ func SetupNumberRoutes(app *iris.Application, gateway *Gateway) {
 				AutoReplyEnabled     *bool   `json:"auto_reply_enabled,omitempty"`
 				AutoReplyMessage     *string `json:"auto_reply_message,omitempty"`
 				AutoReplyCooldownSec *int    `json:"auto_reply_cooldown_secs,omitempty"`
+				// Opt-out keywords
+				HelpReplyMessage *string `json:"help_reply_message,omitempty"`
 			}
 
 			if err := ctx.ReadJSON(&updateReq); err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid request body"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Invalid request body")
 				return
 			}
 
@@ -1205,11 +1636,13 @@ func SetupNumberRoutes(app *iris.Application, gateway *Gateway) {
 			if updateReq.AutoReplyCooldownSec != nil {
 				targetNumber.Settings.AutoReplyCooldownSec = *updateReq.AutoReplyCooldownSec
 			}
+			if updateReq.HelpReplyMessage != nil {
+				targetNumber.Settings.HelpReplyMessage = *updateReq.HelpReplyMessage
+			}
 
 			// Save to database
 			if err := gateway.DB.Save(targetNumber.Settings).Error; err != nil {
-				ctx.StatusCode(iris.StatusInternalServerError)
-				ctx.JSON(iris.Map{"error": "Failed to save settings"})
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, "Failed to save settings")
 				return
 			}
 
@@ -1235,8 +1668,7 @@ func SetupNumberRoutes(app *iris.Application, gateway *Gateway) {
 			numberIDStr := ctx.Params().Get("id")
 			numberID, err := strconv.ParseUint(numberIDStr, 10, 32)
 			if err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid number ID"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid number ID")
 				return
 			}
 
@@ -1252,8 +1684,7 @@ func SetupNumberRoutes(app *iris.Application, gateway *Gateway) {
 			gateway.mu.RUnlock()
 
 			if targetNumber == nil {
-				ctx.StatusCode(iris.StatusNotFound)
-				ctx.JSON(iris.Map{"error": "Number not found"})
+				apiError(ctx, iris.StatusNotFound, errCodeNumberNotFound, "Number not found")
 				return
 			}
 
@@ -1269,8 +1700,7 @@ func SetupNumberRoutes(app *iris.Application, gateway *Gateway) {
 		numbers.Get("/{id}/auto-reply", func(ctx iris.Context) {
 			numberID, err := strconv.ParseUint(ctx.Params().Get("id"), 10, 32)
 			if err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid number ID"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid number ID")
 				return
 			}
 
@@ -1285,8 +1715,7 @@ func SetupNumberRoutes(app *iris.Application, gateway *Gateway) {
 			gateway.mu.RUnlock()
 
 			if targetNumber == nil {
-				ctx.StatusCode(iris.StatusNotFound)
-				ctx.JSON(iris.Map{"error": "Number not found"})
+				apiError(ctx, iris.StatusNotFound, errCodeNumberNotFound, "Number not found")
 				return
 			}
 
@@ -1319,8 +1748,7 @@ func SetupNumberRoutes(app *iris.Application, gateway *Gateway) {
 		numbers.Put("/{id}/auto-reply", func(ctx iris.Context) {
 			numberID, err := strconv.ParseUint(ctx.Params().Get("id"), 10, 32)
 			if err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid number ID"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidParameter, "Invalid number ID")
 				return
 			}
 
@@ -1335,8 +1763,7 @@ func SetupNumberRoutes(app *iris.Application, gateway *Gateway) {
 			gateway.mu.RUnlock()
 
 			if targetNumber == nil {
-				ctx.StatusCode(iris.StatusNotFound)
-				ctx.JSON(iris.Map{"error": "Number not found"})
+				apiError(ctx, iris.StatusNotFound, errCodeNumberNotFound, "Number not found")
 				return
 			}
 
@@ -1347,8 +1774,7 @@ func SetupNumberRoutes(app *iris.Application, gateway *Gateway) {
 			}
 
 			if err := ctx.ReadJSON(&updateReq); err != nil {
-				ctx.StatusCode(iris.StatusBadRequest)
-				ctx.JSON(iris.Map{"error": "Invalid request body"})
+				apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Invalid request body")
 				return
 			}
 
@@ -1367,8 +1793,7 @@ func SetupNumberRoutes(app *iris.Application, gateway *Gateway) {
 			}
 
 			if err := gateway.DB.Save(targetNumber.Settings).Error; err != nil {
-				ctx.StatusCode(iris.StatusInternalServerError)
-				ctx.JSON(iris.Map{"error": "Failed to save auto-reply settings"})
+				apiError(ctx, iris.StatusInternalServerError, errCodeInternal, "Failed to save auto-reply settings")
 				return
 			}
 
@@ -1405,13 +1830,38 @@ func SetupNumberRoutes(app *iris.Application, gateway *Gateway) {
 		})
 	}
 }
+
+// webDisconnectClient unbinds and closes the SMPP session bound by a
+// username, if any. The username need not belong to a current client, so a
+// session outliving a deleted client can still be terminated.
+func (gateway *Gateway) webDisconnectClient(ctx iris.Context) {
+	username := ctx.Params().Get("username")
+	if username == "" {
+		apiError(ctx, http.StatusBadRequest, errCodeInvalidParameter, "username parameter is required")
+		return
+	}
+
+	found := gateway.SMPPServer != nil && gateway.SMPPServer.disconnectClient(username)
+
+	gateway.LogManager.SendLog(gateway.LogManager.BuildLog(
+		"WebServer.Clients",
+		"SMPPDisconnectRequested",
+		logrus.InfoLevel,
+		map[string]interface{}{
+			"username":   username,
+			"found":      found,
+			"request_id": ctx.Values().GetString("request_id"),
+		},
+	))
+
+	ctx.JSON(iris.Map{"username": username, "disconnected": found})
+}
+
 func (gateway *Gateway) webInboundCarrier(ctx iris.Context) {
 	// Extract the 'carrier' parameter from the URL
 	carrier := ctx.Params().Get("carrier") // the carrier is the uuid of the carrier
 	if carrier == "" {
-		// Respond with 400 Bad Request
-		ctx.StatusCode(http.StatusBadRequest)
-		ctx.WriteString("carrier parameter is required")
+		apiError(ctx, http.StatusBadRequest, errCodeInvalidParameter, "carrier parameter is required")
 		return
 	}
 
@@ -1424,14 +1874,13 @@ func (gateway *Gateway) webInboundCarrier(ctx iris.Context) {
 			// Call the Inbound method of the carrier handler
 			err := inboundRoute.Inbound(ctx)
 			if err != nil {
-				// Respond with 500 Internal Server Error
-				ctx.StatusCode(http.StatusInternalServerError)
-				ctx.WriteString("failed to process inbound message")
+				apiError(ctx, http.StatusInternalServerError, errCodeInboundFailed, "failed to process inbound message")
 				return
 			}
 			return
 		}
-		// Successfully processed the inbound message
+		// Known UUID whose handler failed to load
+		apiError(ctx, http.StatusServiceUnavailable, errCodeCarrierNotFound, "carrier is not loaded")
 		return
 	}
 
@@ -1442,11 +1891,10 @@ func (gateway *Gateway) webInboundCarrier(ctx iris.Context) {
 		logrus.WarnLevel,
 		map[string]interface{}{
 			"carrier_uuid": carrier,
+			"request_id":   ctx.Values().GetString("request_id"),
 		},
 	))
-	// Respond with 404 Not Found
-	ctx.StatusCode(http.StatusNotFound)
-	ctx.WriteString("carrier not found")
+	apiError(ctx, http.StatusNotFound, errCodeCarrierNotFound, "carrier not found")
 }
 
 func (gateway *Gateway) webMediaFile(ctx iris.Context) {
@@ -1458,12 +1906,12 @@ func (gateway *Gateway) webMediaFile(ctx iris.Context) {
 			logrus.WarnLevel,
 			map[string]interface{}{
 				"client_ip":  ctx.RemoteAddr(),
+				"request_id": ctx.Values().GetString("request_id"),
 				"user_agent": ctx.GetHeader("User-Agent"),
 				"success":    false,
 			},
 		))
-		ctx.StatusCode(http.StatusBadRequest)
-		ctx.WriteString("access token is required")
+		apiError(ctx, http.StatusBadRequest, errCodeInvalidParameter, "access token is required")
 		return
 	}
 
@@ -1485,12 +1933,12 @@ func (gateway *Gateway) webMediaFile(ctx iris.Context) {
 			logrus.WarnLevel,
 			map[string]interface{}{
 				"client_ip":  clientIP,
+				"request_id": ctx.Values().GetString("request_id"),
 				"user_agent": userAgent,
 				"success":    false,
 			},
 		))
-		ctx.StatusCode(http.StatusBadRequest)
-		ctx.WriteString("access token is required")
+		apiError(ctx, http.StatusBadRequest, errCodeInvalidParameter, "access token is required")
 		return
 	}
 
@@ -1504,13 +1952,13 @@ func (gateway *Gateway) webMediaFile(ctx iris.Context) {
 			map[string]interface{}{
 				"access_token": accessToken,
 				"client_ip":    clientIP,
+				"request_id":   ctx.Values().GetString("request_id"),
 				"user_agent":   userAgent,
 				"success":      false,
 				"error_reason": err.Error(),
 			},
 		))
-		ctx.StatusCode(http.StatusNotFound)
-		ctx.WriteString("media file not found")
+		apiError(ctx, http.StatusNotFound, errCodeMediaNotFound, "media file not found")
 		return
 	}
 
@@ -1522,17 +1970,18 @@ func (gateway *Gateway) webMediaFile(ctx iris.Context) {
 			map[string]interface{}{
 				"access_token": accessToken,
 				"client_ip":    clientIP,
+				"request_id":   ctx.Values().GetString("request_id"),
 				"user_agent":   userAgent,
 				"success":      false,
 				"error_reason": "smil_not_supported",
 			},
 		))
-		ctx.StatusCode(500)
+		apiError(ctx, http.StatusUnsupportedMediaType, errCodeUnsupportedMedia, "SMIL content is not served")
 		return
 	}
 
-	// Decode the Base64-encoded data
-	fileBytes, err := base64.StdEncoding.DecodeString(mediaFile.Base64Data)
+	// The Base64-encoded data is decoded as it is sent
+	content, err := newBase64Reader(mediaFile.Base64Data)
 	if err != nil {
 		gateway.LogManager.SendLog(gateway.LogManager.BuildLog(
 			"WebServer.Media.Access",
@@ -1541,16 +1990,32 @@ func (gateway *Gateway) webMediaFile(ctx iris.Context) {
 			map[string]interface{}{
 				"access_token": accessToken,
 				"client_ip":    clientIP,
+				"request_id":   ctx.Values().GetString("request_id"),
 				"user_agent":   userAgent,
 				"success":      false,
 				"error_reason": "base64_decode_failed",
 			},
 		))
-		ctx.StatusCode(http.StatusInternalServerError)
-		ctx.WriteString("failed to decode file data")
+		apiError(ctx, http.StatusInternalServerError, errCodeInternal, "failed to decode file data")
 		return
 	}
 
+	// Every request counts, ranged or not; a range is as good as the file
+	// to a client that asks for all of them
+	rangeHeader := ctx.GetHeader("Range")
+	if err := gateway.recordMediaFetch(mediaFile); err != nil {
+		gateway.LogManager.SendLog(gateway.LogManager.BuildLog(
+			"WebServer.Media.Access",
+			"Failed to count media fetch",
+			logrus.WarnLevel,
+			map[string]interface{}{
+				"access_token": accessToken,
+				"request_id":   ctx.Values().GetString("request_id"),
+			},
+			err,
+		))
+	}
+
 	// Log successful access
 	gateway.LogManager.SendLog(gateway.LogManager.BuildLog(
 		"WebServer.Media.Access",
@@ -1561,26 +2026,23 @@ func (gateway *Gateway) webMediaFile(ctx iris.Context) {
 			"file_name":    mediaFile.FileName,
 			"content_type": mediaFile.ContentType,
 			"client_ip":    clientIP,
+			"request_id":   ctx.Values().GetString("request_id"),
 			"user_agent":   userAgent,
+			"range":        rangeHeader,
 			"success":      true,
 		},
 	))
 
-	// Set the appropriate Content-Type header
-	ctx.ContentType(mediaFile.ContentType)
-
 	// Optionally, set Content-Disposition to suggest a filename for download
 	// Uncomment the following line if you want the browser to prompt a download
 	// ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", mediaFile.FileName))
 
-	// Send the file bytes as the response
-	ctx.Write(fileBytes)
+	serveMediaFile(ctx.ResponseWriter(), ctx.Request(), mediaFile, content)
 }
 
 func (gateway *Gateway) webReloadData(ctx iris.Context) {
-	if err := gateway.reloadClientsAndNumbers(); err != nil {
-		ctx.StatusCode(500)
-		ctx.JSON(iris.Map{"error": err.Error()})
+	if _, err := gateway.reload(); err != nil {
+		apiError(ctx, iris.StatusInternalServerError, errCodeInternal, err.Error())
 		return
 	}
 	ctx.JSON(iris.Map{"status": "Data reloaded successfully"})
@@ -1597,6 +2059,8 @@ func isPrivateIP(ip net.IP) bool {
 }
 
 func ProxyIPMiddleware(ctx iris.Context) {
+	setRequestID(ctx)
+
 	remoteIP := net.ParseIP(ctx.RemoteAddr())
 	if remoteIP == nil {
 		ctx.Values().Set("client_ip", ctx.RemoteAddr())
@@ -1644,6 +2108,18 @@ func ProxyIPMiddleware(ctx iris.Context) {
 // - bicom: expects Bearer token
 // - generic/telnyx/other: expects Basic Auth
 func (gateway *Gateway) clientAuthMiddleware(ctx iris.Context) {
+	gateway.authenticateClient(ctx, false)
+}
+
+// anyClientAuthMiddleware is clientAuthMiddleware for endpoints that legacy
+// clients may use as well, such as POST /outbound/mms.
+func (gateway *Gateway) anyClientAuthMiddleware(ctx iris.Context) {
+	gateway.authenticateClient(ctx, true)
+}
+
+// authenticateClient does the work of the client auth middlewares. Legacy
+// clients are refused unless allowLegacy is set.
+func (gateway *Gateway) authenticateClient(ctx iris.Context, allowLegacy bool) {
 	var username, password string
 	var authMethod string // "basic", "bearer", or "api_key"
 	var apiKey *TenantAPIKey
@@ -1739,7 +2215,7 @@ func (gateway *Gateway) clientAuthMiddleware(ctx iris.Context) {
 	}
 
 	// Only web clients can use REST API - legacy clients must use SMPP
-	if client.Type != "" && client.Type != "web" {
+	if !allowLegacy && client.Type != "" && client.Type != "web" {
 		ctx.StatusCode(iris.StatusForbidden)
 		ctx.JSON(iris.Map{
 			"status":  "error",
@@ -1829,33 +2305,11 @@ type ParsedMessage struct {
 
 // fetchMediaFromURL downloads media content from a URL and returns the content bytes, content type, and filename.
 // This is used when web clients (Bicom/Telnyx) send media_urls instead of base64 content.
-func fetchMediaFromURL(mediaURL string, timeout time.Duration) (content []byte, contentType string, filename string, err error) {
-	client := &http.Client{
-		Timeout: timeout,
-	}
-
-	req, err := http.NewRequest("GET", mediaURL, nil)
-	if err != nil {
-		return nil, "", "", fmt.Errorf("failed to create request: %w", err)
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, "", "", fmt.Errorf("HTTP request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, "", "", fmt.Errorf("non-OK HTTP status: %s", resp.Status)
-	}
-
-	content, err = io.ReadAll(resp.Body)
+func (gateway *Gateway) fetchMediaFromURL(mediaURL string) (content []byte, contentType string, filename string, err error) {
+	content, contentType, err = gateway.fetchMedia(nil, mediaURL, nil)
 	if err != nil {
-		return nil, "", "", fmt.Errorf("error reading media content: %w", err)
+		return nil, "", "", err
 	}
-
-	// Get content type from response header
-	contentType = resp.Header.Get("Content-Type")
 	if contentType == "" {
 		contentType = "application/octet-stream"
 	}
@@ -1907,8 +2361,7 @@ func SetupMessageRoutes(app *iris.Application, gateway *Gateway) {
 			if apiKeyVal != nil {
 				apiKey = apiKeyVal.(*TenantAPIKey)
 				if !apiKey.HasScope("send") {
-					ctx.StatusCode(iris.StatusForbidden)
-					ctx.JSON(iris.Map{"error": "API key does not have 'send' scope"})
+					apiError(ctx, iris.StatusForbidden, errCodeForbidden, "API key does not have 'send' scope")
 					return
 				}
 			}
@@ -1952,8 +2405,7 @@ func SetupMessageRoutes(app *iris.Application, gateway *Gateway) {
 			case "telnyx":
 				var req TelnyxMessageRequest
 				if err := ctx.ReadJSON(&req); err != nil {
-					ctx.StatusCode(iris.StatusBadRequest)
-					ctx.JSON(iris.Map{"error": "Invalid Telnyx format request body"})
+					apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Invalid Telnyx format request body")
 					return
 				}
 				parsed.From = req.Data.Payload.From.PhoneNumber
@@ -1968,14 +2420,12 @@ func SetupMessageRoutes(app *iris.Application, gateway *Gateway) {
 			default: // "generic"
 				var req WebMessageRequest
 				if err := ctx.ReadJSON(&req); err != nil {
-					ctx.StatusCode(iris.StatusBadRequest)
-					ctx.JSON(iris.Map{"error": "Invalid request body"})
+					apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Invalid request body")
 					return
 				}
 				// Validate client_id matches authenticated client
 				if req.ClientID != 0 && req.ClientID != client.ID {
-					ctx.StatusCode(iris.StatusForbidden)
-					ctx.JSON(iris.Map{"error": "Authenticated client does not match specified client_id"})
+					apiError(ctx, iris.StatusForbidden, errCodeForbidden, "Authenticated client does not match specified client_id")
 					return
 				}
 				parsed.From = req.From
@@ -2017,8 +2467,7 @@ func SetupMessageRoutes(app *iris.Application, gateway *Gateway) {
 					ctx.StatusCode(iris.StatusBadRequest)
 					ctx.JSON(iris.Map{"status": "error", "message": "'to' field is required"})
 				} else {
-					ctx.StatusCode(iris.StatusBadRequest)
-					ctx.JSON(iris.Map{"error": "'to' field is required"})
+					apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "'to' field is required")
 				}
 				return
 			}
@@ -2033,8 +2482,7 @@ func SetupMessageRoutes(app *iris.Application, gateway *Gateway) {
 						ctx.StatusCode(iris.StatusBadRequest)
 						ctx.JSON(iris.Map{"status": "error", "message": "'from' field is required when multiple numbers exist"})
 					} else {
-						ctx.StatusCode(iris.StatusBadRequest)
-						ctx.JSON(iris.Map{"error": "'from' field is required when multiple numbers exist"})
+						apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "'from' field is required when multiple numbers exist")
 					}
 					return
 				}
@@ -2058,8 +2506,7 @@ func SetupMessageRoutes(app *iris.Application, gateway *Gateway) {
 						ctx.StatusCode(iris.StatusForbidden)
 						ctx.JSON(iris.Map{"status": "error", "message": "You do not own the 'from' number"})
 					} else {
-						ctx.StatusCode(iris.StatusForbidden)
-						ctx.JSON(iris.Map{"error": "You do not own the 'from' number"})
+						apiError(ctx, iris.StatusForbidden, errCodeForbidden, "You do not own the 'from' number")
 					}
 					return
 				}
@@ -2067,8 +2514,7 @@ func SetupMessageRoutes(app *iris.Application, gateway *Gateway) {
 
 			// API key number scoping check
 			if apiKey != nil && !apiKey.IsNumberAllowed(fromNumber) {
-				ctx.StatusCode(iris.StatusForbidden)
-				ctx.JSON(iris.Map{"error": "API key is not authorized to send from this number"})
+				apiError(ctx, iris.StatusForbidden, errCodeForbidden, "API key is not authorized to send from this number")
 				return
 			}
 
@@ -2101,6 +2547,38 @@ func SetupMessageRoutes(app *iris.Application, gateway *Gateway) {
 			}
 			// --- END LIMIT CHECK ---
 
+			// Refuse recipients who texted STOP to this number
+			toNumber, _ := FormatToE164(parsed.To)
+			if gateway.isOptedOut(fromNumber, toNumber) {
+				if apiFormat == "bicom" {
+					ctx.StatusCode(iris.StatusForbidden)
+					ctx.JSON(iris.Map{"status": "error", "message": "Recipient has opted out of messages from this number"})
+				} else {
+					ctx.StatusCode(iris.StatusForbidden)
+					ctx.JSON(iris.Map{
+						"error":   "recipient_opted_out",
+						"message": "Recipient has opted out of messages from this number",
+					})
+				}
+				return
+			}
+
+			// Refuse undialable carrier-bound recipients (NUMBER_VALIDATION)
+			if err := gateway.validateClientDest(toNumber); err != nil {
+				message := "Invalid destination number: " + err.Error()
+				if apiFormat == "bicom" {
+					ctx.StatusCode(iris.StatusBadRequest)
+					ctx.JSON(iris.Map{"status": "error", "message": message})
+				} else {
+					ctx.StatusCode(iris.StatusBadRequest)
+					ctx.JSON(iris.Map{
+						"error":   "invalid_destination",
+						"message": message,
+					})
+				}
+				return
+			}
+
 			// Parse Media - fetch from URLs if needed and prepare for transcoding
 			var files []MsgFile
 			var originalSizeBytes int
@@ -2123,7 +2601,7 @@ func SetupMessageRoutes(app *iris.Application, gateway *Gateway) {
 							},
 						))
 
-						fetchedContent, fetchedContentType, fetchedFilename, err := fetchMediaFromURL(media.URL, 30*time.Second)
+						fetchedContent, fetchedContentType, fetchedFilename, err := gateway.fetchMediaFromURL(media.URL)
 						if err != nil {
 							lm.SendLog(lm.BuildLog(
 								"WebServer.Messages.Send",
@@ -2141,8 +2619,7 @@ func SetupMessageRoutes(app *iris.Application, gateway *Gateway) {
 								ctx.StatusCode(iris.StatusBadRequest)
 								ctx.JSON(iris.Map{"status": "error", "message": fmt.Sprintf("Failed to fetch media from URL: %s", media.URL)})
 							} else {
-								ctx.StatusCode(iris.StatusBadRequest)
-								ctx.JSON(iris.Map{"error": fmt.Sprintf("Failed to fetch media from URL: %s", media.URL)})
+								apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, fmt.Sprintf("Failed to fetch media from URL: %s", media.URL))
 							}
 							return
 						}
@@ -2188,8 +2665,7 @@ func SetupMessageRoutes(app *iris.Application, gateway *Gateway) {
 									"error":      err.Error(),
 								},
 							))
-							ctx.StatusCode(iris.StatusBadRequest)
-							ctx.JSON(iris.Map{"error": "Invalid base64 content in media"})
+							apiError(ctx, iris.StatusBadRequest, errCodeInvalidRequest, "Invalid base64 content in media")
 							return
 						}
 						content = decodedContent
@@ -2222,6 +2698,7 @@ func SetupMessageRoutes(app *iris.Application, gateway *Gateway) {
 			}
 
 			logID := uuid.New().String()
+			traceID := newTraceID()
 
 			// Get client IP for tracking
 			clientIP = ctx.Values().GetString("client_ip")
@@ -2238,7 +2715,9 @@ func SetupMessageRoutes(app *iris.Application, gateway *Gateway) {
 					Files:         files,
 					TransactionID: logID,
 					MessageID:     logID,
+					TraceID:       traceID,
 					Client:        client,
+					ReceivedAt:    time.Now(),
 				}
 
 				lm.SendLog(lm.BuildLog(
@@ -2247,6 +2726,7 @@ func SetupMessageRoutes(app *iris.Application, gateway *Gateway) {
 					logrus.InfoLevel,
 					map[string]interface{}{
 						"logID":             logID,
+						"traceID":           traceID,
 						"clientID":          client.ID,
 						"clientName":        client.Username,
 						"from":              fromNumber,
@@ -2260,20 +2740,24 @@ func SetupMessageRoutes(app *iris.Application, gateway *Gateway) {
 				gateway.MM4Server.MediaTranscodeChan <- mm4Message
 			} else {
 				// SMS or MMS without media - route directly
+				received := time.Now()
 				item := MsgQueueItem{
 					LogID:             logID,
+					TraceID:           traceID,
 					To:                parsed.To,
 					From:              fromNumber,
 					Type:              msgType,
 					message:           parsed.Text,
 					files:             files,
-					ReceivedTimestamp: time.Now(),
+					ReceivedTimestamp: received,
+					QueuedTimestamp:   received,
 					SourceIP:          clientIP,
 					OriginalSizeBytes: originalSizeBytes,
+					Priority:          gateway.msgPriority(client, msgType),
 				}
 
 				// Inject into Router
-				gateway.Router.ClientMsgChan <- item
+				gateway.Router.clientQueue(item.Priority) <- item
 			}
 
 			// Return success immediately (Async)
@@ -2303,8 +2787,7 @@ func SetupMessageRoutes(app *iris.Application, gateway *Gateway) {
 			if apiKeyVal != nil {
 				apiKey := apiKeyVal.(*TenantAPIKey)
 				if !apiKey.HasScope("usage") {
-					ctx.StatusCode(iris.StatusForbidden)
-					ctx.JSON(iris.Map{"error": "API key does not have 'usage' scope"})
+					apiError(ctx, iris.StatusForbidden, errCodeForbidden, "API key does not have 'usage' scope")
 					return
 				}
 			}
@@ -2422,8 +2905,7 @@ func SetupMessageRoutes(app *iris.Application, gateway *Gateway) {
 			if apiKeyVal != nil {
 				apiKey := apiKeyVal.(*TenantAPIKey)
 				if !apiKey.HasScope("usage") {
-					ctx.StatusCode(iris.StatusForbidden)
-					ctx.JSON(iris.Map{"error": "API key does not have 'usage' scope"})
+					apiError(ctx, iris.StatusForbidden, errCodeForbidden, "API key does not have 'usage' scope")
 					return
 				}
 			}
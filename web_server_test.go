@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"zultys-smpp-mm4/smpp"
+
+	"github.com/kataras/iris/v12"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientResponse_OmitsSecrets(t *testing.T) {
+	client := &Client{
+		ID:         7,
+		Username:   "pbx1",
+		Password:   "s3cret",
+		Name:       "PBX One",
+		Type:       "legacy",
+		Address:    "10.0.0.5",
+		LogPrivacy: true,
+		Settings:   &ClientSettings{SubmitRateLimit: 5},
+		Numbers:    []ClientNumber{{Number: "12505551234"}},
+	}
+
+	resp := clientResponse(client)
+	assert.Equal(t, uint(7), resp.ID)
+	assert.Equal(t, "pbx1", resp.Username)
+	assert.Equal(t, "PBX One", resp.Name)
+	assert.Equal(t, "10.0.0.5", resp.Address)
+	assert.True(t, resp.LogPrivacy)
+
+	body, err := json.Marshal(resp)
+	require.NoError(t, err)
+	assert.NotContains(t, string(body), "s3cret")
+	assert.Nil(t, resp.Settings)
+	assert.Empty(t, resp.Numbers)
+}
+
+func TestWebDisconnectClient(t *testing.T) {
+	_, gw := newTestRouter(1)
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go func() { _, _ = io.Copy(io.Discard, clientConn) }()
+
+	gw.SMPPServer = &SMPPServer{
+		gateway: gw,
+		conns:   map[string]*smpp.Session{"pbx1": smpp.NewSession(context.Background(), serverConn)},
+	}
+
+	disconnect := func(username string) map[string]interface{} {
+		app := iris.New()
+		w := httptest.NewRecorder()
+		ctx := app.ContextPool.Acquire(w, httptest.NewRequest("POST", "/clients/"+username+"/disconnect", nil))
+		ctx.Params().Set("username", username)
+
+		gw.webDisconnectClient(ctx)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return body
+	}
+
+	body := disconnect("pbx1")
+	assert.Equal(t, "pbx1", body["username"])
+	assert.Equal(t, true, body["disconnected"])
+	assert.False(t, gw.SMPPServer.isSessionActive("pbx1"))
+
+	assert.Equal(t, false, disconnect("pbx1")["disconnected"])
+}